@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/logging"
+	"easywi/agent/internal/schedule"
+	"easywi/agent/internal/trace"
+)
+
+const (
+	agentScheduleSyncJobType   = "agent.schedule.sync"
+	agentScheduleDeleteJobType = "agent.schedule.delete"
+	agentScheduleListJobType   = "agent.schedule.list"
+	agentScheduleTick          = 15 * time.Second
+)
+
+var agentSchedulePath = "/etc/easywi/agent_schedules.json"
+
+// schedulableJobTypes are the maintenance jobs the agent may run on its own while the
+// panel is unreachable. Everything else still requires a panel dispatch.
+var schedulableJobTypes = map[string]bool{
+	"instance.watchdog.check": true,
+	"domain.ssl.renew":        true,
+	"instance.backup.create":  true,
+	"instance.backup.prune":   true,
+	"fail2ban.status.check":   true,
+	instanceTaskRunJobType:    true,
+}
+
+// agentSchedule is one recurring job pushed by the panel.
+type agentSchedule struct {
+	ID         string         `json:"id"`
+	JobType    string         `json:"job_type"`
+	Cron       string         `json:"cron"`
+	Payload    map[string]any `json:"payload,omitempty"`
+	Enabled    bool           `json:"enabled"`
+	LastRunAt  *time.Time     `json:"last_run_at,omitempty"`
+	LastJobID  string         `json:"last_job_id,omitempty"`
+	LastStatus string         `json:"last_status,omitempty"`
+}
+
+type agentScheduleFile struct {
+	Schedules []agentSchedule `json:"schedules"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// agentScheduleSpec is the panel's view of a schedule; run history stays agent-side.
+type agentScheduleSpec struct {
+	ID      string         `json:"id"`
+	JobType string         `json:"job_type"`
+	Cron    string         `json:"cron"`
+	Payload map[string]any `json:"payload,omitempty"`
+	Enabled *bool          `json:"enabled,omitempty"`
+}
+
+type agentScheduleEntry struct {
+	schedule agentSchedule
+	parsed   *schedule.Schedule
+	next     time.Time
+	running  bool
+}
+
+// agentScheduler runs recurring maintenance jobs through handleJob and reports the
+// runs afterwards. dispatch hands a job to the runner; report delivers the outcome.
+type agentScheduler struct {
+	mu       sync.Mutex
+	path     string
+	entries  map[string]*agentScheduleEntry
+	dispatch func(job jobs.Job, handler func(jobs.Job))
+	report   func(run jobs.ScheduledRun)
+	logger   *logging.JSONLogger
+	now      func() time.Time
+}
+
+var globalAgentScheduler = newAgentScheduler(agentSchedulePath)
+
+func newAgentScheduler(path string) *agentScheduler {
+	return &agentScheduler{
+		path:    path,
+		entries: map[string]*agentScheduleEntry{},
+		now:     time.Now,
+	}
+}
+
+// Load reads the persisted schedules. A schedule whose activation passed while the
+// agent was down runs once on the next tick.
+func (s *agentScheduler) Load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read schedules: %w", err)
+	}
+	var file agentScheduleFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("decode schedules: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.entries = map[string]*agentScheduleEntry{}
+	for _, item := range file.Schedules {
+		parsed, err := schedule.Parse(item.Cron)
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Error(context.Background(), "agent.schedule_skipped", "SCHEDULE_INVALID", fmt.Sprintf("skip agent schedule: %v", err), map[string]any{"schedule_id": item.ID})
+			}
+			continue
+		}
+		s.entries[item.ID] = newAgentScheduleEntry(item, parsed, now)
+	}
+	return nil
+}
+
+func newAgentScheduleEntry(item agentSchedule, parsed *schedule.Schedule, now time.Time) *agentScheduleEntry {
+	from := now
+	if item.LastRunAt != nil {
+		from = *item.LastRunAt
+	}
+	return &agentScheduleEntry{schedule: item, parsed: parsed, next: parsed.Next(from)}
+}
+
+// Replace validates and stores the full schedule set pushed by the panel, keeping
+// the run history of schedules that already existed.
+func (s *agentScheduler) Replace(specs []agentScheduleSpec) error {
+	now := s.now()
+	entries := map[string]*agentScheduleEntry{}
+	for _, spec := range specs {
+		item, parsed, err := validateAgentScheduleSpec(spec)
+		if err != nil {
+			return err
+		}
+		if _, exists := entries[item.ID]; exists {
+			return fmt.Errorf("duplicate schedule id %s", item.ID)
+		}
+		entries[item.ID] = &agentScheduleEntry{schedule: item, parsed: parsed}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range entries {
+		from := now
+		if previous, ok := s.entries[id]; ok {
+			entry.schedule.LastRunAt = previous.schedule.LastRunAt
+			entry.schedule.LastJobID = previous.schedule.LastJobID
+			entry.schedule.LastStatus = previous.schedule.LastStatus
+			entry.running = previous.running
+			if previous.schedule.Cron == entry.schedule.Cron && previous.schedule.LastRunAt != nil {
+				from = *previous.schedule.LastRunAt
+			}
+		}
+		entry.next = entry.parsed.Next(from)
+	}
+	previous := s.entries
+	s.entries = entries
+	if err := s.persistLocked(); err != nil {
+		s.entries = previous
+		return err
+	}
+	return nil
+}
+
+// Delete removes a schedule. It reports whether the schedule existed.
+func (s *agentScheduler) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.entries, id)
+	if err := s.persistLocked(); err != nil {
+		s.entries[id] = entry
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns the schedules with their next activation, sorted by ID.
+func (s *agentScheduler) List() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]map[string]any, 0, len(s.entries))
+	for _, entry := range s.sortedEntriesLocked() {
+		item := map[string]any{
+			"id":       entry.schedule.ID,
+			"job_type": entry.schedule.JobType,
+			"cron":     entry.schedule.Cron,
+			"enabled":  entry.schedule.Enabled,
+			"running":  entry.running,
+		}
+		if !entry.next.IsZero() {
+			item["next_run_at"] = entry.next.UTC().Format(time.RFC3339)
+		}
+		if entry.schedule.LastRunAt != nil {
+			item["last_run_at"] = entry.schedule.LastRunAt.UTC().Format(time.RFC3339)
+			item["last_job_id"] = entry.schedule.LastJobID
+			item["last_status"] = entry.schedule.LastStatus
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+func (s *agentScheduler) sortedEntriesLocked() []*agentScheduleEntry {
+	entries := make([]*agentScheduleEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].schedule.ID < entries[b].schedule.ID })
+	return entries
+}
+
+func (s *agentScheduler) persistLocked() error {
+	file := agentScheduleFile{UpdatedAt: s.now().UTC()}
+	for _, entry := range s.sortedEntriesLocked() {
+		file.Schedules = append(file.Schedules, entry.schedule)
+	}
+	encoded, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode schedules: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o750); err != nil {
+		return fmt.Errorf("create schedule dir: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(encoded, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write schedules: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit schedules: %w", err)
+	}
+	return nil
+}
+
+// Run fires due schedules until ctx is cancelled.
+func (s *agentScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(agentScheduleTick)
+	defer ticker.Stop()
+	s.Tick()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Tick()
+		}
+	}
+}
+
+// Tick dispatches every enabled schedule that is due and not still running. It
+// returns the dispatched jobs.
+func (s *agentScheduler) Tick() []jobs.Job {
+	s.mu.Lock()
+	now := s.now()
+	type dueJob struct {
+		scheduleID string
+		job        jobs.Job
+	}
+	var due []dueJob
+	for _, entry := range s.sortedEntriesLocked() {
+		if !entry.schedule.Enabled || entry.running || entry.next.IsZero() || entry.next.After(now) {
+			continue
+		}
+		entry.running = true
+		entry.next = entry.parsed.Next(now)
+		due = append(due, dueJob{scheduleID: entry.schedule.ID, job: newScheduledJob(entry.schedule, now)})
+	}
+	dispatch := s.dispatch
+	s.mu.Unlock()
+
+	dispatched := make([]jobs.Job, 0, len(due))
+	for _, item := range due {
+		scheduleID := item.scheduleID
+		handler := func(job jobs.Job) { s.execute(scheduleID, job) }
+		if dispatch != nil {
+			dispatch(item.job, handler)
+		} else {
+			go handler(item.job)
+		}
+		dispatched = append(dispatched, item.job)
+	}
+	return dispatched
+}
+
+func newScheduledJob(item agentSchedule, now time.Time) jobs.Job {
+	requestID, correlationID := trace.Normalize("", "")
+	payload := make(map[string]any, len(item.Payload)+2)
+	for key, value := range item.Payload {
+		payload[key] = value
+	}
+	payload["request_id"] = requestID
+	payload["schedule_id"] = item.ID
+	return jobs.Job{
+		ID:            fmt.Sprintf("schedule-%s-%d", item.ID, now.Unix()),
+		Type:          item.JobType,
+		Payload:       payload,
+		CorrelationID: correlationID,
+		CreatedAt:     now.UTC(),
+	}
+}
+
+func (s *agentScheduler) execute(scheduleID string, job jobs.Job) {
+	started := s.now().UTC()
+	result, afterSubmit := runCancellableJob(job, nil)
+	if afterSubmit != nil {
+		if err := afterSubmit(); err != nil && s.logger != nil {
+			s.logger.Error(context.Background(), "agent.scheduled_job_post_action_failed", "SCHEDULE_POST_ACTION_FAILED", fmt.Sprintf("scheduled job post-action failed: %v", err), map[string]any{"schedule_id": scheduleID, "job_id": job.ID})
+		}
+	}
+
+	requestID, correlationID := trace.Normalize(payloadValue(job.Payload, "request_id"), job.CorrelationID)
+	run := jobs.ScheduledRun{
+		ScheduleID:    scheduleID,
+		JobID:         job.ID,
+		JobType:       job.Type,
+		Status:        result.Status,
+		Output:        result.Output,
+		RequestID:     requestID,
+		CorrelationID: correlationID,
+		StartedAt:     started,
+		Completed:     s.now().UTC(),
+	}
+
+	s.mu.Lock()
+	if entry, ok := s.entries[scheduleID]; ok {
+		entry.running = false
+		entry.schedule.LastRunAt = &started
+		entry.schedule.LastJobID = job.ID
+		entry.schedule.LastStatus = result.Status
+		if err := s.persistLocked(); err != nil && s.logger != nil {
+			s.logger.Error(context.Background(), "agent.schedule_persist_failed", "SCHEDULE_PERSIST_FAILED", fmt.Sprintf("persist agent schedules failed: %v", err), map[string]any{"schedule_id": scheduleID})
+		}
+	}
+	report := s.report
+	s.mu.Unlock()
+
+	if report != nil {
+		report(run)
+	}
+}
+
+func validateAgentScheduleSpec(spec agentScheduleSpec) (agentSchedule, *schedule.Schedule, error) {
+	id := strings.TrimSpace(spec.ID)
+	jobType, _ := normalizeJobType(strings.TrimSpace(spec.JobType))
+	if id == "" {
+		return agentSchedule{}, nil, fmt.Errorf("schedule id is required")
+	}
+	if !schedulableJobTypes[jobType] {
+		return agentSchedule{}, nil, fmt.Errorf("schedule %s: job type %q cannot be scheduled by the agent", id, spec.JobType)
+	}
+	parsed, err := schedule.Parse(spec.Cron)
+	if err != nil {
+		return agentSchedule{}, nil, fmt.Errorf("schedule %s: %w", id, err)
+	}
+	enabled := true
+	if spec.Enabled != nil {
+		enabled = *spec.Enabled
+	}
+	return agentSchedule{
+		ID:      id,
+		JobType: jobType,
+		Cron:    strings.TrimSpace(spec.Cron),
+		Payload: spec.Payload,
+		Enabled: enabled,
+	}, parsed, nil
+}
+
+func handleAgentScheduleSync(job jobs.Job) (jobs.Result, func() error) {
+	raw := payloadValue(job.Payload, "schedules")
+	if raw == "" {
+		return failureResult(job.ID, fmt.Errorf("missing schedules"))
+	}
+	var specs []agentScheduleSpec
+	if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+		return failureResult(job.ID, fmt.Errorf("invalid schedules: %w", err))
+	}
+	if err := globalAgentScheduler.Replace(specs); err != nil {
+		return failureResult(job.ID, err)
+	}
+	return agentScheduleListResult(job.ID, "schedules stored")
+}
+
+func handleAgentScheduleDelete(job jobs.Job) (jobs.Result, func() error) {
+	id := payloadValue(job.Payload, "schedule_id", "id")
+	if id == "" {
+		return failureResult(job.ID, fmt.Errorf("missing schedule_id"))
+	}
+	existed, err := globalAgentScheduler.Delete(id)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	message := "schedule deleted"
+	if !existed {
+		message = "schedule not found"
+	}
+	return agentScheduleListResult(job.ID, message)
+}
+
+func handleAgentScheduleList(job jobs.Job) (jobs.Result, func() error) {
+	return agentScheduleListResult(job.ID, "schedules listed")
+}
+
+func agentScheduleListResult(jobID, message string) (jobs.Result, func() error) {
+	encoded, err := json.Marshal(globalAgentScheduler.List())
+	if err != nil {
+		return failureResult(jobID, err)
+	}
+	return jobs.Result{
+		JobID:  jobID,
+		Status: "success",
+		Output: map[string]string{
+			"message":   message,
+			"schedules": string(encoded),
+		},
+		Completed: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestAgentSchedulerRejectsUnschedulableJobTypes(t *testing.T) {
+	scheduler := newAgentScheduler(filepath.Join(t.TempDir(), "schedules.json"))
+	err := scheduler.Replace([]agentScheduleSpec{{ID: "wipe", JobType: "instance.delete", Cron: "@daily"}})
+	if err == nil {
+		t.Fatal("expected instance.delete to be rejected")
+	}
+	err = scheduler.Replace([]agentScheduleSpec{{ID: "renew", JobType: "domain.ssl.renew", Cron: "61 * * * *"}})
+	if err == nil {
+		t.Fatal("expected invalid cron expression to be rejected")
+	}
+}
+
+func TestAgentSchedulerDispatchesDueSchedulesAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	now := time.Date(2026, 3, 10, 3, 59, 30, 0, time.UTC)
+	scheduler := newAgentScheduler(path)
+	scheduler.now = func() time.Time { return now }
+
+	var dispatched []jobs.Job
+	scheduler.dispatch = func(job jobs.Job, _ func(jobs.Job)) {
+		dispatched = append(dispatched, job)
+	}
+	err := scheduler.Replace([]agentScheduleSpec{
+		{ID: "watchdog", JobType: "instance.watchdog.check", Cron: "0 4 * * *", Payload: map[string]any{"instance_id": "7"}},
+		{ID: "fail2ban", JobType: "fail2ban.status.check", Cron: "0 4 * * *", Enabled: new(bool)},
+	})
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	if got := scheduler.Tick(); len(got) != 0 {
+		t.Fatalf("expected nothing due yet, got %#v", got)
+	}
+	now = now.Add(time.Minute)
+	scheduler.Tick()
+	if len(dispatched) != 1 || dispatched[0].Type != "instance.watchdog.check" {
+		t.Fatalf("expected watchdog job to be dispatched, got %#v", dispatched)
+	}
+	job := dispatched[0]
+	if job.CorrelationID == "" || payloadValue(job.Payload, "request_id") == "" || payloadValue(job.Payload, "instance_id") != "7" {
+		t.Fatalf("expected trace IDs and schedule payload on job, got %#v", job)
+	}
+	if got := scheduler.Tick(); len(got) != 0 {
+		t.Fatalf("expected running schedule not to be dispatched twice, got %#v", got)
+	}
+
+	reloaded := newAgentScheduler(path)
+	reloaded.now = scheduler.now
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if list := reloaded.List(); len(list) != 2 || list[0]["id"] != "fail2ban" || list[0]["enabled"] != false {
+		t.Fatalf("expected persisted schedules, got %#v", list)
+	}
+}
+
+func TestAgentSchedulerReportsRunWithTraceIDs(t *testing.T) {
+	scheduler := newAgentScheduler(filepath.Join(t.TempDir(), "schedules.json"))
+	if err := scheduler.Replace([]agentScheduleSpec{{ID: "renew", JobType: "domain.ssl.renew", Cron: "@daily"}}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	var reported []jobs.ScheduledRun
+	scheduler.report = func(run jobs.ScheduledRun) {
+		reported = append(reported, run)
+	}
+
+	job := jobs.Job{
+		ID:            "schedule-renew-1",
+		Type:          "unsupported.job",
+		Payload:       map[string]any{"request_id": "77a96e16-ab58-4f39-a8b0-df57f12983ea"},
+		CorrelationID: "5f0c7a1e-2b1d-4c3e-9a8b-1c2d3e4f5a6b",
+	}
+	scheduler.execute("renew", job)
+
+	if len(reported) != 1 {
+		t.Fatalf("expected one reported run, got %d", len(reported))
+	}
+	run := reported[0]
+	if run.ScheduleID != "renew" || run.JobID != job.ID || run.RequestID != "77a96e16-ab58-4f39-a8b0-df57f12983ea" || run.CorrelationID != job.CorrelationID {
+		t.Fatalf("unexpected run report: %#v", run)
+	}
+	if list := scheduler.List(); list[0]["last_job_id"] != job.ID || list[0]["last_status"] != run.Status {
+		t.Fatalf("expected run history to be recorded, got %#v", list)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/config"
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/logging"
+	"easywi/agent/internal/system"
+)
+
+// agentUpdateConfirmDeadline is how long a freshly updated agent has to send
+// a heartbeat and pass its local health probe before it is rolled back.
+const agentUpdateConfirmDeadline = 5 * time.Minute
+
+// agentUpdateMinimumGrace keeps an agent that boots after its deadline (for
+// example after a host reboot) from being rolled back without a chance to confirm.
+const agentUpdateMinimumGrace = time.Minute
+
+var globalAgentUpdate = newAgentUpdateGuard("", "")
+
+// agentUpdateGuard tracks an A/B update from the moment the old binary swaps
+// in the new one until the new binary confirms it is healthy.
+type agentUpdateGuard struct {
+	mu         sync.Mutex
+	statePath  string
+	configPath string
+	state      system.UpdateState
+	hasState   bool
+	systemd    bool
+	logger     *logging.JSONLogger
+
+	now   func() time.Time
+	probe func() error
+	exit  func(code int)
+}
+
+func newAgentUpdateGuard(stateDir, configPath string) *agentUpdateGuard {
+	return &agentUpdateGuard{
+		statePath:  system.UpdateStatePath(stateDir),
+		configPath: configPath,
+		now:        time.Now,
+		probe:      func() error { return nil },
+		exit:       os.Exit,
+	}
+}
+
+// Load reads the persisted update state, if any.
+func (g *agentUpdateGuard) Load() error {
+	state, ok, err := system.LoadUpdateState(g.statePath)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state = state
+	g.hasState = ok
+	return nil
+}
+
+// Arm records a pending update after the binary was swapped and installs the
+// rollback helper. If the helper cannot be armed the swap is reverted so the
+// node is never left running an update it cannot recover from. Without
+// systemd there is nothing to run the helper, so the previous binary is only
+// kept on disk for manual recovery.
+func (g *agentUpdateGuard) Arm(job jobs.Job, binaryPath string) error {
+	if !g.systemd {
+		return nil
+	}
+	now := g.now().UTC()
+	state := system.UpdateState{
+		Status:       system.UpdateStatusPending,
+		JobID:        job.ID,
+		FromVersion:  version,
+		ToVersion:    payloadValue(job.Payload, "version"),
+		BinaryPath:   binaryPath,
+		PreviousPath: system.PreviousBinaryPath(binaryPath),
+		StartedAt:    now,
+		Deadline:     now.Add(agentUpdateConfirmDeadline),
+	}
+	err := system.SaveUpdateState(g.statePath, state)
+	if err == nil {
+		err = system.ArmUpdateRollback(state, g.configPath)
+	}
+	if err != nil {
+		_ = os.Remove(g.statePath)
+		_ = system.DisarmUpdateRollback()
+		if restoreErr := system.RestorePreviousBinary(binaryPath); restoreErr != nil {
+			return fmt.Errorf("arm update rollback: %w; restore previous binary: %v", err, restoreErr)
+		}
+		return fmt.Errorf("arm update rollback: %w", err)
+	}
+
+	g.mu.Lock()
+	g.state = state
+	g.hasState = true
+	g.mu.Unlock()
+	return nil
+}
+
+// Watch enforces the confirmation deadline of a pending update. The agent
+// exits with system.UpdateDeadlineExitCode when the deadline passes, which
+// systemd turns into a run of the rollback helper.
+func (g *agentUpdateGuard) Watch(ctx context.Context) {
+	g.mu.Lock()
+	if !g.hasState || g.state.Status != system.UpdateStatusPending {
+		g.mu.Unlock()
+		return
+	}
+	if g.state.ToVersion == "" {
+		g.state.ToVersion = version
+		_ = system.SaveUpdateState(g.statePath, g.state)
+	}
+	wait := g.state.Deadline.Sub(g.now())
+	g.mu.Unlock()
+	if wait < agentUpdateMinimumGrace {
+		wait = agentUpdateMinimumGrace
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		g.expire(ctx)
+	}
+}
+
+func (g *agentUpdateGuard) expire(ctx context.Context) {
+	g.mu.Lock()
+	pending := g.hasState && g.state.Status == system.UpdateStatusPending
+	g.mu.Unlock()
+	if !pending {
+		return
+	}
+	if g.logger != nil {
+		g.logger.Error(ctx, "agent.update_unconfirmed", "UPDATE_UNCONFIRMED", "update was not confirmed by a heartbeat and health probe before the deadline; exiting for rollback", nil)
+	}
+	g.exit(system.UpdateDeadlineExitCode)
+}
+
+// HeartbeatSucceeded confirms a pending update once the local health probe
+// passes as well. A failing probe leaves the update pending until the next
+// heartbeat or the deadline.
+func (g *agentUpdateGuard) HeartbeatSucceeded(ctx context.Context) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.hasState || g.state.Status != system.UpdateStatusPending {
+		return
+	}
+	if err := g.probe(); err != nil {
+		if g.logger != nil {
+			g.logger.Error(ctx, "agent.update_probe_failed", "UPDATE_PROBE_FAILED", fmt.Sprintf("update health probe failed: %v", err), nil)
+		}
+		return
+	}
+	state, err := system.ConfirmPendingUpdate(g.statePath, g.now())
+	if err != nil {
+		if g.logger != nil {
+			g.logger.Error(ctx, "agent.update_confirm_failed", "UPDATE_CONFIRM_FAILED", fmt.Sprintf("confirm update failed: %v", err), nil)
+		}
+		return
+	}
+	g.state = state
+	if g.systemd {
+		if err := system.DisarmUpdateRollback(); err != nil && g.logger != nil {
+			g.logger.Error(ctx, "agent.update_disarm_failed", "UPDATE_DISARM_FAILED", fmt.Sprintf("remove update rollback helper failed: %v", err), nil)
+		}
+	}
+	if g.logger != nil {
+		g.logger.Info(ctx, "agent.update_confirmed", "agent update confirmed", map[string]any{"from_version": state.FromVersion, "to_version": state.ToVersion})
+	}
+}
+
+// Stats reports the last update for the heartbeat, including rollbacks
+// performed by the systemd helper while the agent was down.
+func (g *agentUpdateGuard) Stats() map[string]any {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.hasState {
+		return nil
+	}
+	stats := map[string]any{
+		"status":       g.state.Status,
+		"job_id":       g.state.JobID,
+		"from_version": g.state.FromVersion,
+		"to_version":   g.state.ToVersion,
+		"started_at":   g.state.StartedAt.UTC().Format(time.RFC3339),
+		"deadline":     g.state.Deadline.UTC().Format(time.RFC3339),
+	}
+	if !g.state.ConfirmedAt.IsZero() {
+		stats["confirmed_at"] = g.state.ConfirmedAt.UTC().Format(time.RFC3339)
+	}
+	if !g.state.RolledBackAt.IsZero() {
+		stats["rolled_back_at"] = g.state.RolledBackAt.UTC().Format(time.RFC3339)
+		stats["reason"] = g.state.Reason
+	}
+	return stats
+}
+
+// runUpdateRollbackHelper is the entry point of the systemd OnFailure helper.
+// It runs from the previous binary and restores it if the update is still pending.
+func runUpdateRollbackHelper(ctx context.Context, cfg config.Config, logger *logging.JSONLogger) int {
+	statePath := system.UpdateStatePath(cfg.StateDir)
+	state, ok, err := system.LoadUpdateState(statePath)
+	if err != nil {
+		logger.Error(ctx, "agent.update_rollback_failed", "UPDATE_ROLLBACK_FAILED", fmt.Sprintf("load update state failed: %v", err), nil)
+		return 1
+	}
+	if !ok || state.Status != system.UpdateStatusPending {
+		_ = system.DisarmUpdateRollback()
+		return 0
+	}
+
+	reason := "updated agent failed before confirming the update"
+	if time.Now().After(state.Deadline) {
+		reason = "update was not confirmed by a heartbeat and health probe before the deadline"
+	}
+	if err := system.StopAgentService(); err != nil {
+		logger.Error(ctx, "agent.update_rollback_stop_failed", "UPDATE_ROLLBACK_STOP_FAILED", fmt.Sprintf("stop agent before rollback failed: %v", err), nil)
+	}
+	state, _, err = system.RollbackPendingUpdate(statePath, reason, time.Now())
+	if err != nil {
+		logger.Error(ctx, "agent.update_rollback_failed", "UPDATE_ROLLBACK_FAILED", fmt.Sprintf("rollback failed: %v", err), nil)
+		return 1
+	}
+	if err := system.DisarmUpdateRollback(); err != nil {
+		logger.Error(ctx, "agent.update_disarm_failed", "UPDATE_DISARM_FAILED", fmt.Sprintf("remove update rollback helper failed: %v", err), nil)
+	}
+	logger.Info(ctx, "agent.update_rolled_back", "agent update rolled back", map[string]any{"from_version": state.FromVersion, "to_version": state.ToVersion, "reason": reason})
+	if err := system.StartAgentService(); err != nil {
+		logger.Error(ctx, "agent.update_rollback_start_failed", "UPDATE_ROLLBACK_START_FAILED", fmt.Sprintf("start agent after rollback failed: %v", err), nil)
+		return 1
+	}
+	return 0
+}
+
+// probeAgentHealth is the local half of update confirmation: the state
+// directory must be writable and the service server must answer /health.
+func probeAgentHealth(cfg config.Config) error {
+	probePath := filepath.Join(cfg.StateDir, ".update-probe")
+	if err := os.WriteFile(probePath, []byte(version), 0o600); err != nil {
+		return fmt.Errorf("state dir not writable: %w", err)
+	}
+	_ = os.Remove(probePath)
+
+	healthURL := localServiceHealthURL(cfg.ServiceListen)
+	if healthURL == "" {
+		return nil
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(healthURL)
+	if err != nil {
+		return fmt.Errorf("service health: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("service health: %s", resp.Status)
+	}
+	return nil
+}
+
+func localServiceHealthURL(listen string) string {
+	listen = strings.TrimSpace(listen)
+	if listen == "" || strings.EqualFold(listen, "off") || strings.EqualFold(listen, "disabled") {
+		return ""
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/health"
+}
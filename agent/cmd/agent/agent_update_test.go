@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/system"
+)
+
+func newPendingUpdateGuard(t *testing.T) *agentUpdateGuard {
+	t.Helper()
+	dir := t.TempDir()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	err := system.SaveUpdateState(system.UpdateStatePath(dir), system.UpdateState{
+		Status:       system.UpdateStatusPending,
+		JobID:        "job-1",
+		FromVersion:  "1.0.0",
+		BinaryPath:   filepath.Join(dir, "easywi-agent"),
+		PreviousPath: filepath.Join(dir, "easywi-agent.previous"),
+		StartedAt:    now,
+		Deadline:     now.Add(agentUpdateConfirmDeadline),
+	})
+	if err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+	guard := newAgentUpdateGuard(dir, "")
+	guard.now = func() time.Time { return now.Add(time.Minute) }
+	if err := guard.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return guard
+}
+
+func TestAgentUpdateGuardConfirmsAfterHeartbeatAndProbe(t *testing.T) {
+	guard := newPendingUpdateGuard(t)
+	probeErr := errors.New("service server not answering")
+	guard.probe = func() error { return probeErr }
+
+	guard.HeartbeatSucceeded(context.Background())
+	if stats := guard.Stats(); stats["status"] != system.UpdateStatusPending {
+		t.Fatalf("expected update to stay pending while the probe fails, got %#v", stats)
+	}
+
+	probeErr = nil
+	guard.HeartbeatSucceeded(context.Background())
+	stats := guard.Stats()
+	if stats["status"] != system.UpdateStatusConfirmed || stats["confirmed_at"] == nil {
+		t.Fatalf("expected confirmed update, got %#v", stats)
+	}
+	state, _, _ := system.LoadUpdateState(guard.statePath)
+	if state.Status != system.UpdateStatusConfirmed {
+		t.Fatalf("expected confirmation to be persisted, got %#v", state)
+	}
+}
+
+func TestAgentUpdateGuardExitsForRollbackWhenUnconfirmed(t *testing.T) {
+	guard := newPendingUpdateGuard(t)
+	exitCode := -1
+	guard.exit = func(code int) { exitCode = code }
+
+	guard.expire(context.Background())
+	if exitCode != system.UpdateDeadlineExitCode {
+		t.Fatalf("expected exit code %d, got %d", system.UpdateDeadlineExitCode, exitCode)
+	}
+
+	exitCode = -1
+	guard.HeartbeatSucceeded(context.Background())
+	guard.expire(context.Background())
+	if exitCode != -1 {
+		t.Fatal("expected a confirmed update not to exit at the deadline")
+	}
+}
+
+func TestAgentUpdateGuardReportsRollback(t *testing.T) {
+	dir := t.TempDir()
+	_ = system.SaveUpdateState(system.UpdateStatePath(dir), system.UpdateState{
+		Status:       system.UpdateStatusRolledBack,
+		FromVersion:  "1.0.0",
+		ToVersion:    "1.1.0",
+		RolledBackAt: time.Now(),
+		Reason:       "updated agent failed before confirming the update",
+	})
+	guard := newAgentUpdateGuard(dir, "")
+	if err := guard.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	stats := guard.Stats()
+	if stats["status"] != system.UpdateStatusRolledBack || stats["reason"] == "" || stats["to_version"] != "1.1.0" {
+		t.Fatalf("expected rollback in heartbeat stats, got %#v", stats)
+	}
+	if newAgentUpdateGuard(t.TempDir(), "").Stats() != nil {
+		t.Fatal("expected no stats without an update state")
+	}
+}
+
+func TestLocalServiceHealthURL(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0:7456":   "http://127.0.0.1:7456/health",
+		":7456":          "http://127.0.0.1:7456/health",
+		"10.0.0.5:8080":  "http://10.0.0.5:8080/health",
+		"off":            "",
+		"not-an-address": "",
+	}
+	for listen, want := range cases {
+		if got := localServiceHealthURL(listen); got != want {
+			t.Fatalf("localServiceHealthURL(%q) = %q, want %q", listen, got, want)
+		}
+	}
+}
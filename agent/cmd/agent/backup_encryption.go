@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/backupcrypt"
+	"easywi/agent/internal/backuptarget"
+	"easywi/agent/internal/jobs"
+)
+
+// backupEncryptionOptions reads the backup_encryption object of a create job:
+// either "recipients" (age X25519 public keys, as strings or {key_id, recipient}
+// objects) or a "passphrase" with an optional "key_id".
+func backupEncryptionOptions(payload map[string]any) backupcrypt.Options {
+	settings := payloadMap(payload, "backup_encryption")
+	opts := backupcrypt.Options{
+		Passphrase:      payloadValue(settings, "passphrase"),
+		PassphraseKeyID: payloadValue(settings, "key_id"),
+	}
+	for _, entry := range backupEncryptionKeyEntries(settings, "recipients", "recipient") {
+		opts.Recipients = append(opts.Recipients, backupcrypt.Recipient{KeyID: entry[0], Recipient: entry[1]})
+	}
+	return opts
+}
+
+// backupDecryptionKeys reads the backup_encryption object of a restore job:
+// "identities" (age X25519 secret keys, as strings or {key_id, identity}
+// objects) and/or a "passphrase".
+func backupDecryptionKeys(payload map[string]any) backupcrypt.Keys {
+	settings := payloadMap(payload, "backup_encryption")
+	keys := backupcrypt.Keys{Passphrase: payloadValue(settings, "passphrase")}
+	for _, entry := range backupEncryptionKeyEntries(settings, "identities", "identity") {
+		keys.Identities = append(keys.Identities, backupcrypt.Identity{KeyID: entry[0], Identity: entry[1]})
+	}
+	return keys
+}
+
+// backupEncryptionKeyEntries returns {key_id, key} pairs from a list that
+// mixes plain key strings and objects carrying the key under field.
+func backupEncryptionKeyEntries(settings map[string]any, listKey, field string) [][2]string {
+	entries := [][2]string{}
+	raw, ok := settings[listKey].([]any)
+	if !ok {
+		for _, key := range parseStringList(settings[listKey], payloadValue(settings, field)) {
+			entries = append(entries, [2]string{"", key})
+		}
+		return entries
+	}
+	for _, item := range raw {
+		switch typed := item.(type) {
+		case string:
+			if strings.TrimSpace(typed) != "" {
+				entries = append(entries, [2]string{"", strings.TrimSpace(typed)})
+			}
+		case map[string]any:
+			if key := payloadValue(typed, field, "key"); key != "" {
+				entries = append(entries, [2]string{payloadValue(typed, "key_id", "id"), key})
+			}
+		}
+	}
+	return entries
+}
+
+// encryptBackupArchive replaces the plaintext archive with an age-encrypted
+// copy and writes its manifest next to it. It returns the encrypted path.
+func encryptBackupArchive(archivePath string, opts backupcrypt.Options) (string, error) {
+	encryptedPath := archivePath + backupcrypt.EncryptedSuffix
+	encryption, err := backupcrypt.EncryptFile(archivePath, encryptedPath, opts)
+	_ = os.Remove(archivePath)
+	if err != nil {
+		return "", err
+	}
+	manifest := backupcrypt.Manifest{Format: "tar.gz", CreatedAt: time.Now().UTC(), Encryption: &encryption}
+	if err := backupcrypt.WriteManifest(encryptedPath, manifest); err != nil {
+		_ = os.Remove(encryptedPath)
+		return "", fmt.Errorf("write backup manifest: %w", err)
+	}
+	return encryptedPath, nil
+}
+
+// readLocalBackupManifest returns the manifest stored next to archivePath, or
+// nil when the archive has none.
+func readLocalBackupManifest(archivePath string) (*backupcrypt.Manifest, error) {
+	manifest, err := backupcrypt.ReadManifest(archivePath + backupcrypt.ManifestSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return manifest, err
+}
+
+// addBackupEncryptionOutput reports the manifest of an encrypted archive.
+func addBackupEncryptionOutput(output map[string]string, manifest *backupcrypt.Manifest, manifestPath string) {
+	if manifest == nil || manifest.Encryption == nil {
+		return
+	}
+	output["encrypted"] = "true"
+	output["encryption_scheme"] = manifest.Encryption.Scheme
+	output["key_ids"] = strings.Join(manifest.Encryption.KeyIDs, ",")
+	output["manifest_path"] = manifestPath
+}
+
+// uploadBackupManifest sends the manifest of archivePath ahead of the archive
+// itself, so a remote archive never exists without the key IDs to open it.
+// A failure keeps the archive staged for a retry, like uploadBackupArchive.
+func uploadBackupManifest(ctx context.Context, job jobs.Job, target backuptarget.Target, archivePath, name string) (string, *jobs.Result) {
+	manifestPath := archivePath + backupcrypt.ManifestSuffix
+	remotePath, err := target.Upload(ctx, manifestPath, name+backupcrypt.ManifestSuffix)
+	if err != nil {
+		return "", &jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": backupTargetErrorCode(err), "staging_path": archivePath}, Completed: time.Now().UTC()}
+	}
+	return remotePath, nil
+}
+
+// decryptBackupArchive returns a plaintext copy of an age-encrypted archive
+// and a cleanup that removes it. Plain archives are returned unchanged. The
+// manifest is looked up next to ref, on the remote target when one is set.
+func decryptBackupArchive(ctx context.Context, payload map[string]any, target backuptarget.Target, ref, localPath string) (string, func(), error) {
+	noop := func() {}
+	encrypted, err := backupcrypt.IsEncrypted(localPath)
+	if err != nil || !encrypted {
+		return localPath, noop, err
+	}
+	manifest, err := loadBackupManifest(ctx, target, ref)
+	if err != nil {
+		return "", noop, err
+	}
+	keys := backupDecryptionKeys(payload)
+	if !keys.Enabled() {
+		keyIDs := ""
+		if manifest != nil && manifest.Encryption != nil {
+			keyIDs = strings.Join(manifest.Encryption.KeyIDs, ", ")
+		}
+		return "", noop, fmt.Errorf("backup archive is encrypted (keys: %s) but backup_encryption has no identity or passphrase", keyIDs)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), ".easywi-decrypted-*.tar.gz")
+	if err != nil {
+		return "", noop, err
+	}
+	plainPath := tmp.Name()
+	_ = tmp.Close()
+	cleanup := func() { _ = os.Remove(plainPath) }
+	if err := backupcrypt.DecryptFile(localPath, plainPath, keys, manifest); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return plainPath, cleanup, nil
+}
+
+// loadBackupManifest fetches the optional manifest of the archive at ref.
+// A missing manifest is not an error; decryption then tries every key.
+func loadBackupManifest(ctx context.Context, target backuptarget.Target, ref string) (*backupcrypt.Manifest, error) {
+	if target == nil {
+		return readLocalBackupManifest(ref)
+	}
+	localPath, cleanup, err := fetchBackupArchive(ctx, target, ref+backupcrypt.ManifestSuffix)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, nil
+	}
+	defer cleanup()
+	return backupcrypt.ReadManifest(localPath)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"easywi/agent/internal/backupcrypt"
+	"easywi/agent/internal/jobs"
+)
+
+func TestHandleInstanceBackupEncryptsArchiveAndRestoreDecrypts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("instance backups are not supported on windows agents")
+	}
+
+	instanceDir := t.TempDir()
+	backupRoot := t.TempDir()
+	t.Setenv("EASYWI_INSTANCE_BACKUP_DIR", backupRoot)
+	configPath := filepath.Join(instanceDir, "server.cfg")
+	if err := os.WriteFile(configPath, []byte("rcon_password secret"), 0o644); err != nil {
+		t.Fatalf("write instance file: %v", err)
+	}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+
+	created, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: map[string]any{
+		"instance_id":  "42",
+		"install_path": instanceDir,
+		"backup_encryption": map[string]any{
+			"recipients": []any{map[string]any{"key_id": "customer-7", "recipient": identity.Recipient().String()}},
+		},
+	}})
+	backupPath := created.Output["backup_path"]
+	if created.Status != "success" || created.Output["encrypted"] != "true" || created.Output["key_ids"] != "customer-7" {
+		t.Fatalf("create: status=%s output=%v", created.Status, created.Output)
+	}
+	if !strings.HasSuffix(backupPath, ".tar.gz"+backupcrypt.EncryptedSuffix) {
+		t.Fatalf("backup_path=%q, want an encrypted archive", backupPath)
+	}
+	if encrypted, err := backupcrypt.IsEncrypted(backupPath); err != nil || !encrypted {
+		t.Fatalf("archive is not age-encrypted: %v %v", encrypted, err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(backupPath, backupcrypt.EncryptedSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("plaintext archive left behind: %v", err)
+	}
+	if created.Output["manifest_path"] != backupPath+backupcrypt.ManifestSuffix {
+		t.Fatalf("manifest_path=%q", created.Output["manifest_path"])
+	}
+
+	if err := os.WriteFile(configPath, []byte("changed"), 0o644); err != nil {
+		t.Fatalf("modify instance file: %v", err)
+	}
+	restorePayload := map[string]any{"instance_id": "42", "install_path": instanceDir, "backup_path": backupPath}
+	missingKey, _ := handleInstanceBackupRestore(context.Background(), jobs.Job{ID: "job-2", Payload: restorePayload})
+	if missingKey.Status != "failed" || missingKey.Output["error_code"] != "backup_decryption_failed" || !strings.Contains(missingKey.Output["error"], "customer-7") {
+		t.Fatalf("restore without key: status=%s output=%v", missingKey.Status, missingKey.Output)
+	}
+
+	restorePayload["backup_encryption"] = map[string]any{"identities": []any{identity.String()}}
+	restored, _ := handleInstanceBackupRestore(context.Background(), jobs.Job{ID: "job-3", Payload: restorePayload})
+	if restored.Status != "success" || restored.Output["restored_from"] != backupPath {
+		t.Fatalf("restore: status=%s output=%v", restored.Status, restored.Output)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != "rcon_password secret" {
+		t.Fatalf("restored content %q", data)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(backupPath), ".easywi-decrypted-*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("decrypted copies left behind: %v", leftovers)
+	}
+}
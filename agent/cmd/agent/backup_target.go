@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/backuptarget"
+	"easywi/agent/internal/jobs"
+)
+
+// newBackupTarget returns the remote store named by backup_target_type, or nil
+// when backups stay on the node.
+func newBackupTarget(payload map[string]any) (backuptarget.Target, error) {
+	switch targetType := strings.ToLower(strings.TrimSpace(payloadValue(payload, "backup_target_type"))); targetType {
+	case "", "local":
+		return nil, nil
+	case "webdav", "nextcloud":
+		return webdavBackupTarget{payload: payload}, nil
+	case "s3", "minio", "wasabi", "b2":
+		return newS3BackupTarget(payload)
+	case "sftp":
+		return newSFTPBackupTarget(payload)
+	default:
+		return nil, fmt.Errorf("unsupported backup_target_type: %s", targetType)
+	}
+}
+
+func backupTargetConfigValue(payload map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value := payloadNestedValue(payload, "backup_target_config", key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func backupTargetSecretValue(payload map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if value := payloadNestedValue(payload, "backup_target_secret", key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func newS3BackupTarget(payload map[string]any) (backuptarget.Target, error) {
+	partSize := int64(0)
+	if raw := backupTargetConfigValue(payload, "part_size_mb"); raw != "" {
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid s3 part_size_mb: %s", raw)
+		}
+		partSize = value << 20
+	}
+	verifyTLS := strings.ToLower(backupTargetConfigValue(payload, "verify_tls")) != "false"
+	return backuptarget.NewS3(backuptarget.S3Config{
+		Endpoint:     backupTargetConfigValue(payload, "endpoint", "url"),
+		Region:       backupTargetConfigValue(payload, "region"),
+		Bucket:       backupTargetConfigValue(payload, "bucket"),
+		Prefix:       backupTargetConfigValue(payload, "prefix", "remote_path", "root_path"),
+		AccessKey:    firstNonEmpty(backupTargetSecretValue(payload, "access_key", "access_key_id"), backupTargetConfigValue(payload, "access_key", "access_key_id")),
+		SecretKey:    backupTargetSecretValue(payload, "secret_key", "secret_access_key"),
+		SessionToken: backupTargetSecretValue(payload, "session_token"),
+		PathStyle:    strings.ToLower(backupTargetConfigValue(payload, "path_style")) != "false",
+		PartSize:     partSize,
+		HTTPClient:   backupTargetHTTPClient(verifyTLS),
+	})
+}
+
+func backupTargetHTTPClient(verifyTLS bool) *http.Client {
+	client := webdavClient(verifyTLS)
+	// Multipart parts and whole-object downloads take longer than a WebDAV PUT,
+	// so only the transport deadlines and the job context bound them.
+	client.Timeout = 0
+	return client
+}
+
+// backupTargetValidationError marks a target configuration or backup
+// reference the agent refused before contacting the remote store.
+type backupTargetValidationError struct {
+	err error
+}
+
+func (e *backupTargetValidationError) Error() string { return e.err.Error() }
+func (e *backupTargetValidationError) Unwrap() error { return e.err }
+
+// backupTargetErrorCode returns the error_code reported for a failed upload or
+// download.
+func backupTargetErrorCode(err error) string {
+	var validation *backupTargetValidationError
+	if errors.As(err, &validation) {
+		return "backup_target_validation_failed"
+	}
+	return "backup_target_connection_failed"
+}
+
+func newSFTPBackupTarget(payload map[string]any) (backuptarget.Target, error) {
+	port := 0
+	if raw := backupTargetConfigValue(payload, "port"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 || value > 65535 {
+			return nil, fmt.Errorf("invalid sftp port: %s", raw)
+		}
+		port = value
+	}
+	return backuptarget.NewSFTP(backuptarget.SFTPConfig{
+		Host:                  backupTargetConfigValue(payload, "host"),
+		Port:                  port,
+		User:                  firstNonEmpty(backupTargetConfigValue(payload, "username"), backupTargetSecretValue(payload, "username")),
+		Password:              backupTargetSecretValue(payload, "password"),
+		PrivateKey:            backupTargetSecretValue(payload, "private_key"),
+		Passphrase:            backupTargetSecretValue(payload, "passphrase"),
+		HostKey:               backupTargetConfigValue(payload, "host_key", "host_key_fingerprint"),
+		InsecureIgnoreHostKey: strings.ToLower(backupTargetConfigValue(payload, "verify_host_key")) == "false",
+		RemotePath:            backupTargetConfigValue(payload, "remote_path", "root_path"),
+	})
+}
+
+// uploadBackupArchive sends a finished archive to the remote target and removes
+// the local copy. On failure the archive is kept and its path is reported as
+// staging_path, so a retry can resume the upload instead of archiving again.
+func uploadBackupArchive(ctx context.Context, job jobs.Job, target backuptarget.Target, localPath, name string) (string, *jobs.Result) {
+	remotePath, err := target.Upload(ctx, localPath, name)
+	if err != nil {
+		return "", &jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": backupTargetErrorCode(err), "staging_path": localPath}, Completed: time.Now().UTC()}
+	}
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return "", &jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": fmt.Sprintf("remove local backup staging file: %v", err), "error_code": "backup_local_cleanup_failed"}, Completed: time.Now().UTC()}
+	}
+	return remotePath, nil
+}
+
+// resumableStagingArchive returns the payload's staging_path when it names an
+// archive left in stagingDir by a failed upload, or "" to create a new one.
+func resumableStagingArchive(payload map[string]any, stagingDir string, remote bool) (string, error) {
+	stagingPath := strings.TrimSpace(payloadValue(payload, "staging_path"))
+	if stagingPath == "" || !remote {
+		return "", nil
+	}
+	cleanPath, err := filepath.Abs(filepath.Clean(stagingPath))
+	if err != nil {
+		return "", fmt.Errorf("invalid staging_path")
+	}
+	root, err := filepath.Abs(stagingDir)
+	if err != nil || !backupPathWithinRoot(cleanPath, root) {
+		return "", fmt.Errorf("staging_path is outside the backup directory")
+	}
+	if info, err := os.Stat(cleanPath); err != nil || !info.Mode().IsRegular() {
+		return "", nil
+	}
+	return cleanPath, nil
+}
+
+// fetchBackupArchive downloads a remote backup into a temporary file. The
+// returned cleanup removes it.
+func fetchBackupArchive(ctx context.Context, target backuptarget.Target, ref string) (string, func(), error) {
+	tmp, err := os.CreateTemp("", "easywi-restore-*.tar.gz")
+	if err != nil {
+		return "", func() {}, err
+	}
+	localPath := tmp.Name()
+	_ = tmp.Close()
+	cleanup := func() { _ = os.Remove(localPath) }
+	if err := target.Download(ctx, ref, localPath); err != nil {
+		cleanup()
+		return "", func() {}, err
+	}
+	return localPath, cleanup, nil
+}
+
+// webdavBackupTarget adapts the WebDAV and Nextcloud helpers to backuptarget.Target.
+type webdavBackupTarget struct {
+	payload map[string]any
+}
+
+func (t webdavBackupTarget) Upload(_ context.Context, localPath, name string) (string, error) {
+	if baseURL, _ := webdavBaseAndFolder(t.payload); baseURL == "" || !hasWebdavCredentials(t.payload) {
+		return "", &backupTargetValidationError{err: fmt.Errorf("webdav target credentials/config missing")}
+	}
+	return uploadBackupToWebdavAs(t.payload, localPath, name)
+}
+
+func (t webdavBackupTarget) Download(_ context.Context, ref, localPath string) error {
+	if err := validateRemoteBackupURL(t.payload, ref); err != nil {
+		return &backupTargetValidationError{err: err}
+	}
+	if !hasWebdavCredentials(t.payload) {
+		return &backupTargetValidationError{err: fmt.Errorf("webdav restore credentials/config missing")}
+	}
+	tmpPath, err := downloadBackupFromWebdav(t.payload, ref)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestNewBackupTargetSelectsBackendByType(t *testing.T) {
+	if target, err := newBackupTarget(map[string]any{"backup_target_type": "local"}); target != nil || err != nil {
+		t.Fatalf("expected no remote target for local backups, got %v %v", target, err)
+	}
+	if target, err := newBackupTarget(map[string]any{"backup_target_type": "nextcloud"}); err != nil {
+		t.Fatalf("nextcloud: %v", err)
+	} else if _, ok := target.(webdavBackupTarget); !ok {
+		t.Fatalf("expected webdav adapter, got %T", target)
+	}
+	if _, err := newBackupTarget(map[string]any{"backup_target_type": "ftp"}); err == nil {
+		t.Fatal("expected unsupported target type to fail")
+	}
+	if _, err := newBackupTarget(map[string]any{
+		"backup_target_type":   "sftp",
+		"backup_target_config": map[string]any{"host": "backup.example", "username": "u"},
+		"backup_target_secret": map[string]any{"password": "p"},
+	}); err == nil {
+		t.Fatal("expected sftp without a pinned host key to fail")
+	}
+}
+
+func TestHandleInstanceBackupRestoreReportsRejectedWebdavReference(t *testing.T) {
+	contacted := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contacted = true
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	payload := map[string]any{
+		"instance_id":          "42",
+		"install_path":         t.TempDir(),
+		"backup_target_type":   "webdav",
+		"backup_target_config": map[string]any{"url": server.URL, "username": "user"},
+		"backup_target_secret": map[string]any{"password": "pass"},
+	}
+	payload["backup_path"] = "https://elsewhere.example/remote/instance-42.tar.gz"
+	rejected, _ := handleInstanceBackupRestore(context.Background(), jobs.Job{ID: "job-1", Payload: payload})
+	if rejected.Status != "failed" || rejected.Output["error_code"] != "backup_target_validation_failed" {
+		t.Fatalf("status=%s output=%v", rejected.Status, rejected.Output)
+	}
+	if contacted {
+		t.Fatal("expected a foreign reference to be refused before any request")
+	}
+
+	payload["backup_path"] = server.URL + "/remote/instance-42.tar.gz"
+	failed, _ := handleInstanceBackupRestore(context.Background(), jobs.Job{ID: "job-2", Payload: payload})
+	if failed.Status != "failed" || failed.Output["error_code"] != "backup_target_connection_failed" {
+		t.Fatalf("status=%s output=%v", failed.Status, failed.Output)
+	}
+}
+
+func TestHandleInstanceBackupCreateResumesFailedS3Upload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("instance backups are not supported on windows agents")
+	}
+
+	instanceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(instanceDir, "server.cfg"), []byte("hostname test"), 0o644); err != nil {
+		t.Fatalf("write instance file: %v", err)
+	}
+	backupRoot := t.TempDir()
+	t.Setenv("EASYWI_INSTANCE_BACKUP_DIR", backupRoot)
+
+	var mu sync.Mutex
+	reject := true
+	stored := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") || r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if reject {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>bucket policy</Message></Error>")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		stored[r.URL.Path] = len(body)
+	}))
+	defer server.Close()
+
+	payload := map[string]any{
+		"instance_id":          "42",
+		"install_path":         instanceDir,
+		"backup_target_type":   "s3",
+		"backup_target_config": map[string]any{"endpoint": server.URL, "bucket": "backups", "prefix": "node-1"},
+		"backup_target_secret": map[string]any{"access_key": "AKID", "secret_key": "secret"},
+	}
+	failed, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: payload})
+	stagingPath := failed.Output["staging_path"]
+	if failed.Status != "failed" || failed.Output["error_code"] != "backup_target_connection_failed" || stagingPath == "" {
+		t.Fatalf("status=%s output=%v", failed.Status, failed.Output)
+	}
+	if _, err := os.Stat(stagingPath); err != nil {
+		t.Fatalf("expected staging archive kept: %v", err)
+	}
+
+	mu.Lock()
+	reject = false
+	mu.Unlock()
+	payload["staging_path"] = stagingPath
+	result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-2", Payload: payload})
+	if result.Status != "success" {
+		t.Fatalf("status=%s output=%v", result.Status, result.Output)
+	}
+	wantRef := "s3://backups/node-1/" + filepath.Base(stagingPath)
+	if result.Output["backup_path"] != wantRef {
+		t.Fatalf("backup_path=%q, want %q", result.Output["backup_path"], wantRef)
+	}
+	if size := stored["/backups/node-1/"+filepath.Base(stagingPath)]; size == 0 {
+		t.Fatalf("expected archive uploaded, got %v", stored)
+	}
+	if _, err := os.Stat(stagingPath); !os.IsNotExist(err) {
+		t.Fatalf("expected staging archive removed after upload, got %v", err)
+	}
+
+	payload["staging_path"] = "/etc/passwd"
+	if outside, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-3", Payload: payload}); outside.Status != "failed" {
+		t.Fatalf("expected staging_path outside the backup dir to be refused, got %v", outside.Output)
+	}
+}
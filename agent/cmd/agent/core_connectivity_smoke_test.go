@@ -105,6 +105,7 @@ func TestAgentCoreConnectivitySmoke(t *testing.T) {
 		HeartbeatInterval: 45 * time.Millisecond,
 		MaxConcurrency:    1,
 		ServiceListen:     "disabled",
+		StateDir:          t.TempDir(),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
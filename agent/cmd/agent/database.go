@@ -150,7 +150,7 @@ type databaseRequest struct {
 }
 
 func parseDatabaseRequest(job jobs.Job) (databaseRequest, error) {
-	req := databaseRequest{Engine: strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "engine"))), Host: strings.TrimSpace(payloadValue(job.Payload, "host")), Port: strings.TrimSpace(payloadValue(job.Payload, "port")), Database: strings.TrimSpace(payloadValue(job.Payload, "database", "name")), Username: strings.TrimSpace(payloadValue(job.Payload, "username", "user")), AllowedHost: strings.TrimSpace(payloadValue(job.Payload, "allowed_hosts", "allowed_host")), AdminUser: strings.TrimSpace(payloadValue(job.Payload, "admin_user")), AdminSecret: payloadValue(job.Payload, "admin_secret"), TLSMode: strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "tls_mode"))), CACert: payloadValue(job.Payload, "ca_cert")}
+	req := databaseRequest{Engine: strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "engine"))), Host: strings.TrimSpace(payloadValue(job.Payload, "host")), Port: strings.TrimSpace(payloadValue(job.Payload, "port")), Database: strings.TrimSpace(payloadValue(job.Payload, "database")), Username: strings.TrimSpace(payloadValue(job.Payload, "username")), AllowedHost: strings.TrimSpace(payloadValue(job.Payload, "allowed_hosts")), AdminUser: strings.TrimSpace(payloadValue(job.Payload, "admin_user")), AdminSecret: payloadValue(job.Payload, "admin_secret"), TLSMode: strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "tls_mode"))), CACert: payloadValue(job.Payload, "ca_cert")}
 	if req.AllowedHost == "" {
 		req.AllowedHost = "%"
 	}
@@ -52,7 +52,7 @@ func TestParseDatabaseRequestRejectsInvalidNamesAndHost(t *testing.T) {
 	}
 
 	invalidHost := mapsClone(base)
-	invalidHost["allowed_host"] = "%'; DROP USER root; --"
+	invalidHost["allowed_hosts"] = "%'; DROP USER root; --"
 	if _, err := parseDatabaseRequest(jobs.Job{Payload: invalidHost}); err == nil {
 		t.Fatal("expected invalid allowed host error")
 	}
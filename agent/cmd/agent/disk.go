@@ -158,7 +158,7 @@ func handleNodeDiskStat(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func resolveInstanceDir(payload map[string]any) (string, error) {
-	instanceDir := payloadValue(payload, "instance_dir", "install_path", "root_path", "instance_root")
+	instanceDir := schemaPayloadValue(payload, instanceDirPayload{}, "instance_dir")
 	if instanceDir == "" {
 		instanceDir = buildLegacyInstanceDir(payload)
 	}
@@ -243,7 +243,7 @@ func runCommandOutputWithTimeout(timeout time.Duration, name string, args ...str
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, name, args...)
-	output, err := StreamCommand(cmd, "", nil)
+	output, err := StreamCommand(context.Background(), cmd, "", nil)
 	if ctx.Err() == context.DeadlineExceeded {
 		return output, fmt.Errorf("%s %s timed out", name, strings.Join(args, " "))
 	}
@@ -28,15 +28,15 @@ func handleDNSRecordDelete(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleDNSRecordChange(job jobs.Job, changeType string, requireContent bool) (jobs.Result, func() error) {
-	zoneName := normalizeZoneName(payloadValue(job.Payload, "zone", "zone_name", "domain"))
-	recordName := payloadValue(job.Payload, "record_name", "name", "record")
-	recordType := strings.ToUpper(payloadValue(job.Payload, "type", "record_type"))
-	content := payloadValue(job.Payload, "content", "value")
+	zoneName := normalizeZoneName(payloadValue(job.Payload, "zone"))
+	recordName := payloadValue(job.Payload, "record_name")
+	recordType := strings.ToUpper(payloadValue(job.Payload, "type"))
+	content := payloadValue(job.Payload, "content")
 	ttlValue := payloadValue(job.Payload, "ttl")
 	priority := payloadValue(job.Payload, "priority")
-	apiURL := payloadValue(job.Payload, "api_url", "pdns_api_url")
-	apiKey := payloadValue(job.Payload, "api_key", "pdns_api_key")
-	serverID := payloadValue(job.Payload, "server_id", "pdns_server")
+	apiURL := payloadValue(job.Payload, "api_url")
+	apiKey := payloadValue(job.Payload, "api_key")
+	serverID := payloadValue(job.Payload, "server_id")
 
 	if apiURL == "" {
 		apiURL = defaultPowerDNSURL
@@ -50,11 +50,11 @@ type powerDNSRRRecord struct {
 }
 
 func handleDNSZoneCreate(job jobs.Job) (jobs.Result, func() error) {
-	zoneName := normalizeZoneName(payloadValue(job.Payload, "zone", "zone_name", "name", "domain"))
-	nameServerValue := payloadValue(job.Payload, "nameservers", "name_servers", "ns")
-	apiURL := payloadValue(job.Payload, "api_url", "pdns_api_url")
-	apiKey := payloadValue(job.Payload, "api_key", "pdns_api_key")
-	serverID := payloadValue(job.Payload, "server_id", "pdns_server")
+	zoneName := normalizeZoneName(payloadValue(job.Payload, "zone"))
+	nameServerValue := payloadValue(job.Payload, "nameservers")
+	apiURL := payloadValue(job.Payload, "api_url")
+	apiKey := payloadValue(job.Payload, "api_key")
+	serverID := payloadValue(job.Payload, "server_id")
 
 	if apiURL == "" {
 		apiURL = defaultPowerDNSURL
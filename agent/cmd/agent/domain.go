@@ -16,15 +16,15 @@ const (
 )
 
 func handleDomainAdd(job jobs.Job) (jobs.Result, func() error) {
-	domainName := payloadValue(job.Payload, "domain", "hostname", "name")
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	sourceDir := payloadValue(job.Payload, "source_dir", "public_dir", "docroot_source")
-	docroot := payloadValue(job.Payload, "docroot", "document_root", "docroot_target")
-	nginxVhostPath := payloadValue(job.Payload, "nginx_vhost_path", "vhost_path", "nginx_vhost")
-	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path", "nginx_include")
-	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen", "fpm_listen")
+	domainName := payloadValue(job.Payload, "domain")
+	webRoot := payloadValue(job.Payload, "web_root")
+	sourceDir := payloadValue(job.Payload, "source_dir")
+	docroot := payloadValue(job.Payload, "docroot")
+	nginxVhostPath := payloadValue(job.Payload, "nginx_vhost_path")
+	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path")
+	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen")
 	logsDir := payloadValue(job.Payload, "logs_dir")
-	serverAliases := payloadValue(job.Payload, "server_aliases", "aliases")
+	serverAliases := payloadValue(job.Payload, "server_aliases")
 
 	if sourceDir == "" && webRoot != "" {
 		sourceDir = filepath.Join(webRoot, "public")
@@ -17,10 +17,10 @@ var validEmailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[
 const certRenewThreshold = 14 * 24 * time.Hour
 
 func handleDomainSSLIssue(job jobs.Job) (jobs.Result, func() error) {
-	domainName := payloadValue(job.Payload, "domain", "hostname", "name")
-	payloadWebRoot := payloadValue(job.Payload, "web_root", "webroot", "docroot", "document_root", "path")
-	serverAliases := payloadValue(job.Payload, "server_aliases", "aliases")
-	email := payloadValue(job.Payload, "email", "admin_email", "cert_email")
+	domainName := payloadValue(job.Payload, "domain")
+	payloadWebRoot := payloadValue(job.Payload, "web_root")
+	serverAliases := payloadValue(job.Payload, "server_aliases")
+	email := payloadValue(job.Payload, "email")
 
 	if strings.TrimSpace(domainName) == "" {
 		return failureStepResult(job.ID, "validation", "missing required values: domain", nil)
@@ -43,7 +43,7 @@ func handleDomainSSLRenew(job jobs.Job) (jobs.Result, func() error) {
 	if err := ensureCertbotAvailable(); err != nil {
 		return failureResult(job.ID, err)
 	}
-	domainName := payloadValue(job.Payload, "domain", "hostname", "name")
+	domainName := payloadValue(job.Payload, "domain")
 	if strings.TrimSpace(domainName) == "" {
 		if err := runCommand("certbot", "renew", "--non-interactive"); err != nil {
 			return failureResult(job.ID, err)
@@ -51,8 +51,8 @@ func handleDomainSSLRenew(job jobs.Job) (jobs.Result, func() error) {
 		_ = reloadNginx()
 		return jobs.Result{JobID: job.ID, Status: "success", Output: map[string]string{"action": "renew", "scope": "all"}, Completed: time.Now().UTC()}, nil
 	}
-	payloadWebRoot := payloadValue(job.Payload, "web_root", "webroot", "docroot", "document_root", "path")
-	email := payloadValue(job.Payload, "email", "admin_email", "cert_email")
+	payloadWebRoot := payloadValue(job.Payload, "web_root")
+	email := payloadValue(job.Payload, "email")
 	result, certErr := issueOrReuseCertificate(domainName, "", email, payloadWebRoot)
 	if certErr != nil {
 		return failureStepResult(job.ID, certErr.Step, certErr.Message, certErr.Details)
@@ -137,7 +137,7 @@ func handleDomainSSLRevoke(job jobs.Job) (jobs.Result, func() error) { /* unchan
 	if err := ensureCertbotAvailable(); err != nil {
 		return failureResult(job.ID, err)
 	}
-	domainName := strings.TrimSpace(payloadValue(job.Payload, "domain", "hostname", "name"))
+	domainName := strings.TrimSpace(payloadValue(job.Payload, "domain"))
 	certPath := payloadValue(job.Payload, "cert_path")
 	if certPath == "" && domainName != "" {
 		certPath = filepath.Join("/etc/letsencrypt/live", domainName, "cert.pem")
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/exec"
@@ -356,7 +357,7 @@ func runCommandIgnoreMissing(name string, args ...string) error {
 
 func runCommandWithIgnore(name string, args []string, ignore []string) error {
 	cmd := exec.Command(name, args...)
-	output, err := StreamCommand(cmd, "", nil)
+	output, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err == nil {
 		return nil
 	}
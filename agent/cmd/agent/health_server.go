@@ -132,6 +132,9 @@ func startServiceServer(ctx context.Context, cfg config.Config) {
 		}
 		writeJSONError(w, http.StatusNotFound, "NOT_FOUND", "not found")
 	})
+	// Bearer rather than HMAC auth: request signatures hash the whole body,
+	// which would buffer streamed uploads.
+	mux.HandleFunc("/v1/ts/files/", withBearerAuth(cfg.Secret, handleTsFilesHTTP))
 	instanceHMACCfg := fileapi.Config{AgentID: cfg.AgentID, Secret: cfg.Secret, MaxSkew: cfg.FileMaxSkew}
 	mux.Handle("/v1/instances/", withInstanceSubRouteAuth(instanceHMACCfg, http.HandlerFunc(handleInstanceQueryHTTP)))
 	mux.Handle("/internal/sinusbot/instances", sinusbotServer.Handler())
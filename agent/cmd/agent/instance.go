@@ -45,7 +45,7 @@ func handleInstanceCreate(job jobs.Job) (jobs.Result, func() error) {
 	requiredPortsRaw := payloadValue(job.Payload, "required_ports")
 	baseDir := payloadValue(job.Payload, "base_dir")
 	serviceName := payloadValue(job.Payload, "service_name")
-	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart", "auto_start"), true)
+	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart"), true)
 
 	missing := missingValues([]requiredValue{
 		{key: "instance_id", value: instanceID},
@@ -82,6 +82,14 @@ func handleInstanceCreate(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
+	resources, err := parseInstanceResourceProfile(job.Payload, cpuLimit, ramLimit)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	container, err := parseInstanceRuntime(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
 	diskLimit, err := parsePositiveInt(diskLimitValue, "disk_limit")
 	if err != nil {
 		return failureResult(job.ID, err)
@@ -118,33 +126,42 @@ func handleInstanceCreate(job jobs.Job) (jobs.Result, func() error) {
 		}
 	}
 
-	allocatedPorts, err := parsePayloadPortsStrict(job.Payload, "port_block_ports", "ports")
+	pinnedPorts, err := parsePayloadPortsStrict(job.Payload, "port_block_ports")
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	if len(allocatedPorts) == 0 {
-		allocatedPorts, err = allocateCustomerPorts(customerID)
-		if err != nil {
-			return failureResult(job.ID, err)
+	hadPortLeases := instanceHasPortLeases(instanceID)
+	allocatedPorts, err := allocateInstancePorts(job.Payload, instanceID, customerID, requiredPortsRaw, pinnedPorts, false)
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("allocate ports: %w", err))
+	}
+	// A create that fails from here on gives the ports it leased back, so the
+	// retry or another instance can take them.
+	fail := func(err error) (jobs.Result, func() error) {
+		if !hadPortLeases {
+			if _, releaseErr := releaseInstancePorts(instanceID); releaseErr != nil {
+				err = fmt.Errorf("%w (release port leases: %v)", err, releaseErr)
+			}
 		}
+		return failureResult(job.ID, err)
 	}
 
 	if err := openPorts(allocatedPorts); err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 
 	templateValues := buildInstanceTemplateValues(instanceDir, instanceDir, requiredPortsRaw, allocatedPorts, job.Payload)
 	sharedSpecs, err := parseSharedPathSpecs(job.Payload)
 	if err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 	renderedStartParams, err := renderTemplateStrict(startParams, templateValues)
 	if err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 	startScriptPath, err := writeStartScript(instanceDir, renderedStartParams)
 	if err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 	startCommand := startScriptPath
 	startParams = ""
@@ -159,32 +176,41 @@ func handleInstanceCreate(job jobs.Job) (jobs.Result, func() error) {
 	logSharedValidationState(nil, job.ID, "instance_create", job.Payload, sharedSpecs, sharedActive, sharedRoot)
 	if sharedActive {
 		if err := applySharedPaths(instanceDir, sharedRoot, sharedSpecs); err != nil {
-			return failureResult(job.ID, err)
+			return fail(err)
 		}
 	}
 	if err := chownInstanceTreeNoFollow(instanceDir, osUsername); err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 
-	unitPath := filepath.Join("/etc/systemd/system", fmt.Sprintf("%s.service", serviceName))
-	unitContent := systemdUnitTemplate(serviceName, osUsername, instanceDir, instanceDir, startCommand, startParams, cpuLimit, ramLimit)
-	if err := os.WriteFile(unitPath, []byte(unitContent), instanceFileMode); err != nil {
-		return failureResult(job.ID, fmt.Errorf("write systemd unit: %w", err))
+	if container != nil {
+		if err := container.prepareRootlessUser(osUsername); err != nil {
+			return fail(err)
+		}
 	}
-	if err := runCommand("systemctl", "daemon-reload"); err != nil {
-		return failureResult(job.ID, err)
+	unitContent, err := instanceUnitContent(serviceName, osUsername, instanceDir, startCommand, instanceID, uid, resources, container, containerSharedMounts(job.Payload, baseDir, "instance_create"))
+	if err != nil {
+		return fail(err)
+	}
+	if err := writeInstanceUnit(serviceName, unitContent, resources); err != nil {
+		return fail(err)
+	}
+	if instanceScheduleIDPattern.MatchString(instanceID) {
+		if _, err := globalInstanceCrashes.Configure(instanceID, job.Payload, true); err != nil {
+			return fail(err)
+		}
 	}
 	if autostart {
 		if err := runCommand("systemctl", "enable", "--now", serviceName); err != nil {
-			return failureResult(job.ID, err)
+			return fail(err)
 		}
 	} else {
 		if err := runCommand("systemctl", "start", serviceName); err != nil {
-			return failureResult(job.ID, err)
+			return fail(err)
 		}
 	}
 	if err := ensureServiceActive(serviceName, time.Now().UTC()); err != nil {
-		return failureResult(job.ID, err)
+		return fail(err)
 	}
 
 	diagnostics := collectServiceDiagnostics(serviceName)
@@ -205,6 +231,7 @@ func handleInstanceCreate(job jobs.Job) (jobs.Result, func() error) {
 			"allocated_ports":   strings.Join(intSliceToStrings(allocatedPorts), ","),
 			"required_ports":    requiredPortsRaw,
 			"start_script_path": startScriptPath,
+			"runtime":           instanceRuntimeName(container),
 		}, diagnostics),
 		Completed: time.Now().UTC(),
 	}, nil
@@ -397,6 +424,12 @@ func handleInstanceDelete(job jobs.Job) (jobs.Result, func() error) {
 	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
 		return failureResult(job.ID, fmt.Errorf("remove unit %s: %w", unitPath, err))
 	}
+	if err := removeInstanceNetwork(serviceName); err != nil {
+		return failureResult(job.ID, err)
+	}
+	if err := clearLiveResourceOverrides(serviceName); err != nil {
+		return failureResult(job.ID, err)
+	}
 
 	if commandExists("systemctl") {
 		if err := runCommand("systemctl", "daemon-reload"); err != nil {
@@ -418,12 +451,26 @@ func handleInstanceDelete(job jobs.Job) (jobs.Result, func() error) {
 		}
 	}
 
+	releasedPorts, err := releaseInstancePorts(instanceID)
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("release port leases: %w", err))
+	}
+	if instanceScheduleIDPattern.MatchString(instanceID) {
+		if err := globalInstanceSchedulers.Remove(instanceID); err != nil {
+			return failureResult(job.ID, err)
+		}
+		if err := globalInstanceCrashes.Remove(instanceID); err != nil {
+			return failureResult(job.ID, err)
+		}
+	}
+
 	return jobs.Result{
 		JobID:  job.ID,
 		Status: "success",
 		Output: map[string]string{
-			"instance_dir": instanceDir,
-			"service_name": serviceName,
+			"instance_dir":   instanceDir,
+			"service_name":   serviceName,
+			"released_ports": strings.Join(intSliceToStrings(releasedPorts), ","),
 		},
 		Completed: time.Now().UTC(),
 	}, nil
@@ -575,7 +622,7 @@ func handleInstanceCreateWindows(job jobs.Job) (jobs.Result, func() error) {
 	startParams := payloadValue(job.Payload, "start_params")
 	baseDir := payloadValue(job.Payload, "base_dir")
 	serviceName := payloadValue(job.Payload, "service_name")
-	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart", "auto_start"), true)
+	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart"), true)
 
 	missing := missingValues([]requiredValue{
 		{key: "instance_id", value: instanceID},
@@ -688,15 +735,24 @@ func handleInstanceDeleteWindows(job jobs.Job) (jobs.Result, func() error) {
 		}
 	}
 
+	output := map[string]string{"service_name": serviceName}
+	if instanceID != "" {
+		releasedPorts, err := releaseInstancePorts(instanceID)
+		if err != nil {
+			return failureResult(job.ID, fmt.Errorf("release port leases: %w", err))
+		}
+		output["released_ports"] = strings.Join(intSliceToStrings(releasedPorts), ",")
+	}
+
 	return jobs.Result{
 		JobID:     job.ID,
 		Status:    "success",
-		Output:    map[string]string{"service_name": serviceName},
+		Output:    output,
 		Completed: time.Now().UTC(),
 	}, nil
 }
 
-func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+func handleInstanceReinstall(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
 	if runtime.GOOS == "windows" {
 		return failedResultWithErrorCode(job.ID, "unsupported_os", "instance reinstall unsupported on windows")
 	}
@@ -711,8 +767,8 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 	cpuLimitValue := payloadValue(job.Payload, "cpu_limit")
 	ramLimitValue := payloadValue(job.Payload, "ram_limit")
 	diskLimitValue := payloadValue(job.Payload, "disk_limit")
-	backupOld := strings.EqualFold(payloadValue(job.Payload, "backup_old", "backup"), "true")
-	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart", "auto_start"), true)
+	backupOld := strings.EqualFold(payloadValue(job.Payload, "backup_old"), "true")
+	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart"), true)
 
 	missing := missingValues([]requiredValue{
 		{key: "instance_id", value: instanceID},
@@ -740,10 +796,23 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 		return failureResult(job.ID, err)
 	}
 
-	allocatedPorts, err := parsePayloadPortsStrict(job.Payload, "port_block_ports", "ports")
+	allocatedPorts, err := parsePayloadPortsStrict(job.Payload, "port_block_ports")
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
+	// Instances created before the lease table have no leases yet; their
+	// ports are taken over from port_reservations so a container runtime can
+	// publish them. The instance's own server may still be bound to them, so
+	// the host check is skipped.
+	if len(allocatedPorts) == 0 && !instanceHasPortLeases(instanceID) {
+		allocatedPorts = reservedInstancePorts(job.Payload, requiredPortsRaw)
+	}
+	if len(allocatedPorts) > 0 || instanceHasPortLeases(instanceID) {
+		allocatedPorts, err = allocateInstancePorts(job.Payload, instanceID, customerID, requiredPortsRaw, allocatedPorts, true)
+		if err != nil {
+			return failureResult(job.ID, fmt.Errorf("allocate ports: %w", err))
+		}
+	}
 
 	cpuLimit, err := parsePositiveInt(cpuLimitValue, "cpu_limit")
 	if err != nil {
@@ -753,6 +822,14 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
+	resources, err := parseInstanceResourceProfile(job.Payload, cpuLimit, ramLimit)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	container, err := parseInstanceRuntime(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
 	diskLimit, err := parsePositiveInt(diskLimitValue, "disk_limit")
 	if err != nil {
 		return failureResult(job.ID, err)
@@ -851,14 +928,23 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 	startCommand := startScriptPath
 	startParams = ""
 
-	unitPath := filepath.Join("/etc/systemd/system", fmt.Sprintf("%s.service", serviceName))
-	unitContent := systemdUnitTemplate(serviceName, osUsername, instanceDir, instanceDir, startCommand, startParams, cpuLimit, ramLimit)
-	if err := os.WriteFile(unitPath, []byte(unitContent), instanceFileMode); err != nil {
-		return failureResult(job.ID, fmt.Errorf("write systemd unit: %w", err))
+	if container != nil {
+		if err := container.prepareRootlessUser(osUsername); err != nil {
+			return failureResult(job.ID, err)
+		}
+	}
+	unitContent, err := instanceUnitContent(serviceName, osUsername, instanceDir, startCommand, instanceID, uid, resources, container, containerSharedMounts(job.Payload, baseDir, "instance_reinstall"))
+	if err != nil {
+		return failureResult(job.ID, err)
 	}
-	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+	if err := writeInstanceUnit(serviceName, unitContent, resources); err != nil {
 		return failureResult(job.ID, err)
 	}
+	if instanceScheduleIDPattern.MatchString(instanceID) {
+		if _, err := globalInstanceCrashes.Configure(instanceID, job.Payload, true); err != nil {
+			return failureResult(job.ID, err)
+		}
+	}
 
 	diagnostics := collectServiceDiagnostics(serviceName)
 	sharedActive := shouldUseSharedStorage(job.Payload, "instance_reinstall")
@@ -900,14 +986,14 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 			maskedInstall := maskSensitiveValues(renderedInstallCommand, templateValues)
 			logSender.Send(job.ID, []string{fmt.Sprintf("instance reinstall install starting (uses_steamcmd=%t command=%s)", usesSteamCmd, maskedInstall)}, nil)
 		}
-		installOutput, err := runCommandOutputAsUserWithLogs(osUsername, installWithDir, job.ID, logSender)
+		installOutput, err := runCommandOutputAsUserWithLogs(ctx, osUsername, installWithDir, job.ID, logSender)
 		if err != nil {
 			return failureResult(job.ID, fmt.Errorf("install command failed: %w", err))
 		}
 		steamAppID := payloadValue(job.Payload, "steam_app_id")
 		if usesSteamCmd {
 			for attempts := 0; attempts < steamCmdRetryLimit && shouldRetrySteamCmd(installOutput, steamAppID); attempts++ {
-				retryOutput, retryErr := runCommandOutputAsUserWithLogs(osUsername, installWithDir, job.ID, logSender)
+				retryOutput, retryErr := runCommandOutputAsUserWithLogs(ctx, osUsername, installWithDir, job.ID, logSender)
 				if retryErr != nil {
 					return failureResult(job.ID, fmt.Errorf("install command failed: %w", retryErr))
 				}
@@ -971,6 +1057,7 @@ func handleInstanceReinstall(job jobs.Job, logSender JobLogSender) (jobs.Result,
 	diagnostics["disk_limit"] = strconv.Itoa(diskLimit)
 	diagnostics["autostart"] = strconv.FormatBool(autostart)
 	diagnostics["start_script_path"] = startScriptPath
+	diagnostics["runtime"] = instanceRuntimeName(container)
 
 	return jobs.Result{
 		JobID:     job.ID,
@@ -1052,25 +1139,6 @@ func ensureBaseDir(path string) error {
 	return nil
 }
 
-func allocateCustomerPorts(customerID string) ([]int, error) {
-	basePort := 30000
-	if override := os.Getenv("EASYWI_PORT_POOL_START"); override != "" {
-		if parsed, err := strconv.Atoi(override); err == nil {
-			basePort = parsed
-		}
-	}
-	id, err := strconv.Atoi(customerID)
-	if err != nil || id <= 0 {
-		return nil, fmt.Errorf("invalid customer_id %s", customerID)
-	}
-	start := basePort + (id-1)*5
-	ports := make([]int, 0, 5)
-	for i := 0; i < 5; i++ {
-		ports = append(ports, start+i)
-	}
-	return ports, nil
-}
-
 func systemdUnitTemplateWithEnvFile(serviceName, user, workingDir, readWritePath, startCommand, startParams, envFilePath string, cpuLimit, ramLimit int) string {
 	base := systemdUnitTemplate(serviceName, user, workingDir, readWritePath, startCommand, startParams, cpuLimit, ramLimit)
 	if envFilePath == "" {
@@ -1080,40 +1148,66 @@ func systemdUnitTemplateWithEnvFile(serviceName, user, workingDir, readWritePath
 }
 
 func systemdUnitTemplate(serviceName, user, workingDir, readWritePath, startCommand, startParams string, cpuLimit, ramLimit int) string {
+	resources := instanceResourceProfile{CPUQuota: cpuLimit, MemoryMaxMB: ramLimit}
+	return systemdUnitTemplateWithResources(serviceName, user, workingDir, readWritePath, startCommand, startParams, resources)
+}
+
+// systemdUnitTemplateWithResources renders a unit with the full resource
+// profile. Bandwidth limits apply to the device backing workingDir.
+func systemdUnitTemplateWithResources(serviceName, user, workingDir, readWritePath, startCommand, startParams string, resources instanceResourceProfile) string {
+	return systemdUnitTemplateWithRuntime(serviceName, user, workingDir, readWritePath, startCommand, startParams, resources, nil)
+}
+
+// systemdUnitTemplateWithRuntime renders the unit of an instance whose start
+// command runs a rootless container engine when container is set. The engine
+// needs the user's runtime dir, a delegated cgroup and the setuid newuidmap
+// helper, which NoNewPrivileges and PrivateDevices would block.
+func systemdUnitTemplateWithRuntime(serviceName, user, workingDir, readWritePath, startCommand, startParams string, resources instanceResourceProfile, container *containerUnit) string {
 	command := strings.TrimSpace(startCommand)
 	if startParams != "" && !strings.Contains(startCommand, startParams) {
 		command = strings.TrimSpace(command + " " + startParams)
 	}
 	execStart, runtimeDirectory := buildSystemdExecStart(serviceName, command)
-	limits := buildSystemdLimits(cpuLimit, ramLimit)
+	limits := strings.Join(resources.directives(workingDir), "\n")
 	runtimeDirectoryLine := ""
 	if runtimeDirectory != "" {
 		runtimeDirectoryLine = fmt.Sprintf("RuntimeDirectory=%s\nRuntimeDirectoryMode=0750", runtimeDirectory)
 	}
-	return fmt.Sprintf(`[Unit]
+	unitDeps, serviceNetwork := "After=network.target", ""
+	if resources.Network != nil {
+		unitDeps, serviceNetwork = instanceNetnsUnitLines(serviceName)
+	}
+	serviceEnv, execStartPre, execStopPost := "", "", ""
+	readWritePaths, sandbox := readWritePath, "NoNewPrivileges=true\nPrivateTmp=true\nPrivateDevices=true"
+	if container != nil {
+		serviceEnv = fmt.Sprintf("Environment=XDG_RUNTIME_DIR=%s\n", container.RuntimeDir)
+		execStartPre = fmt.Sprintf("ExecStartPre=%s\n", container.RemoveCommand)
+		execStopPost = fmt.Sprintf("ExecStopPost=%s\n", container.RemoveCommand)
+		readWritePaths += " " + container.RuntimeDir
+		sandbox = "NoNewPrivileges=false\nPrivateTmp=true\nPrivateDevices=false\nDelegate=yes"
+	}
+	unit := fmt.Sprintf(`[Unit]
 Description=Easy-Wi Instance %s
-After=network.target
+%s
 StartLimitIntervalSec=60
 StartLimitBurst=3
 
 [Service]
-Type=simple
+%s%sType=simple
 User=%s
 WorkingDirectory=%s
 Environment=HOME=%s
 Environment=XDG_CONFIG_HOME=%s/.config
 Environment=XDG_DATA_HOME=%s/.local/share
 ExecStartPre=/usr/bin/test -d %s
-ExecStart=%s
-StandardOutput=journal
+%sExecStart=%s
+%sStandardOutput=journal
 StandardError=journal
 Restart=on-failure
 RestartSec=10
 UMask=0027
 LimitNOFILE=1048576
-NoNewPrivileges=true
-PrivateTmp=true
-PrivateDevices=true
+%s
 ProtectSystem=strict
 ProtectHome=false
 ReadWritePaths=%s
@@ -1122,7 +1216,8 @@ ReadWritePaths=%s
 
 [Install]
 WantedBy=multi-user.target
-`, serviceName, user, workingDir, workingDir, workingDir, workingDir, workingDir, execStart, readWritePath, runtimeDirectoryLine, limits)
+`, serviceName, unitDeps, serviceEnv, serviceNetwork, user, workingDir, workingDir, workingDir, workingDir, workingDir, execStartPre, execStart, execStopPost, sandbox, readWritePaths, runtimeDirectoryLine, limits)
+	return unit
 }
 
 func buildSystemdExecStart(serviceName, command string) (string, string) {
@@ -1144,17 +1239,7 @@ func buildSystemdExecStart(serviceName, command string) (string, string) {
 }
 
 func buildSystemdLimits(cpuLimit, ramLimit int) string {
-	lines := []string{}
-	if cpuLimit > 0 {
-		lines = append(lines, fmt.Sprintf("CPUQuota=%d%%", cpuLimit))
-	}
-	if ramLimit > 0 {
-		lines = append(lines,
-			fmt.Sprintf("MemoryMax=%dM", ramLimit),
-			"MemorySwapMax=0",
-		)
-	}
-	return strings.Join(lines, "\n")
+	return strings.Join(instanceResourceProfile{CPUQuota: cpuLimit, MemoryMaxMB: ramLimit}.directives(""), "\n")
 }
 
 func intSliceToStrings(values []int) []string {
@@ -1470,7 +1555,7 @@ func parsePayloadPorts(payload map[string]any) []int {
 		}
 	}
 
-	if portsRaw := payloadValue(payload, "port_block_ports", "ports"); portsRaw != "" {
+	if portsRaw := schemaPayloadValue(payload, instancePortsPayload{}, "port_block_ports"); portsRaw != "" {
 		parsed, err := parsePorts(portsRaw)
 		if err == nil {
 			return parsed
@@ -1746,7 +1831,7 @@ func mergeDiagnostics(output map[string]string, diagnostics map[string]string) m
 
 func runCommandOutput(name string, args ...string) (string, error) {
 	cmd := exec.Command(name, args...)
-	output, err := StreamCommand(cmd, "", nil)
+	output, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err != nil {
 		return output, fmt.Errorf("%s %s failed: %w (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(output))
 	}
@@ -1757,9 +1842,9 @@ func runCommandAsUser(username, command string) error {
 	return runCommand("runuser", "-u", username, "--", "/bin/sh", "-c", command)
 }
 
-func runCommandOutputAsUserWithLogs(username, command, jobID string, logSender JobLogSender) (string, error) {
+func runCommandOutputAsUserWithLogs(ctx context.Context, username, command, jobID string, logSender JobLogSender) (string, error) {
 	cmd := buildRunUserCommand(username, command)
-	output, err := StreamCommand(cmd, jobID, logSender)
+	output, err := StreamCommand(ctx, cmd, jobID, logSender)
 	if err != nil {
 		return output, fmt.Errorf("command failed: %w", err)
 	}
@@ -75,8 +75,8 @@ func handleInstanceAddonInstallUpdate(job jobs.Job, isUpdate bool) (jobs.Result,
 	pluginID := payloadValue(job.Payload, "plugin_id")
 	pluginName := payloadValue(job.Payload, "plugin_name")
 	pluginVersion := payloadValue(job.Payload, "plugin_version")
-	downloadURL := payloadValue(job.Payload, "plugin_download_url", "download_url")
-	checksum := payloadValue(job.Payload, "plugin_checksum", "checksum")
+	downloadURL := payloadValue(job.Payload, "plugin_download_url")
+	checksum := payloadValue(job.Payload, "plugin_checksum")
 	extractSubdir := payloadValue(job.Payload, "plugin_extract_subdir")
 	installMode := payloadValue(job.Payload, "plugin_install_mode")
 	if installMode == "" {
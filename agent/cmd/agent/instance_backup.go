@@ -3,11 +3,13 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,6 +18,7 @@ import (
 	"strings"
 	"time"
 
+	"easywi/agent/internal/backupcrypt"
 	"easywi/agent/internal/jobs"
 )
 
@@ -36,7 +39,7 @@ func instanceBackupTimeout() time.Duration {
 	return defaultInstanceBackupTimeout
 }
 
-func handleInstanceBackupCreate(job jobs.Job) (jobs.Result, func() error) {
+func handleInstanceBackupCreate(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
 	instanceID := payloadValue(job.Payload, "instance_id")
 	if strings.TrimSpace(instanceID) == "" {
 		return failureResult(job.ID, fmt.Errorf("instance_id is required"))
@@ -51,74 +54,146 @@ func handleInstanceBackupCreate(job jobs.Job) (jobs.Result, func() error) {
 		return failureResult(job.ID, fmt.Errorf("instance directory missing: %w", err))
 	}
 
-	backupTargetType := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "backup_target_type")))
-	backupRoot := backupRootDir()
-	if backupTargetType == "local" {
-		resolvedRoot, resolveErr := resolveLocalBackupRoot(job.Payload, backupRoot)
-		if resolveErr != nil {
-			return failureResult(job.ID, resolveErr)
-		}
-		backupRoot = resolvedRoot
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	backupMode, err := instanceBackupMode(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	if backupMode == instanceBackupModeDedup && target != nil {
+		return failureResult(job.ID, fmt.Errorf("dedup backups need a local backup target"))
+	}
+	encryption := backupEncryptionOptions(job.Payload)
+	if backupMode == instanceBackupModeDedup && encryption.Enabled() {
+		return failureResult(job.ID, fmt.Errorf("encrypted backups need backup_mode full"))
+	}
+	targetDir, err := instanceBackupTargetDir(job.Payload, instanceID)
+	if err != nil {
+		return failureResult(job.ID, err)
 	}
-	targetDir := filepath.Join(backupRoot, sanitizeIdentifier(instanceID))
 	if err := os.MkdirAll(targetDir, 0o750); err != nil {
 		return failureResult(job.ID, fmt.Errorf("create backup target dir: %w", err))
 	}
+	if backupMode == instanceBackupModeDedup {
+		backup, err := quiesceInstanceForBackup(ctx, job, instanceID, instanceDir)
+		if err != nil {
+			return failedResultWithErrorCode(job.ID, "backup_hook_failed", err.Error())
+		}
+		result, next := createInstanceSnapshot(ctx, job, instanceID, backup.SourceDir, targetDir)
+		backup.addOutput(result.Output, backup.Release())
+		return result, next
+	}
 
-	backupPath := filepath.Join(targetDir, fmt.Sprintf("instance-%s-%d.tar.gz", sanitizeIdentifier(instanceID), time.Now().UTC().Unix()))
-	if err := createTarGzArchive(backupPath, instanceDir); err != nil {
-		return failureResult(job.ID, fmt.Errorf("create backup archive: %w", err))
+	// staging_path resumes the upload of an archive a previous run created
+	// but could not deliver to the remote target.
+	backupPath, err := resumableStagingArchive(job.Payload, targetDir, target != nil)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	consistency := map[string]string{}
+	if backupPath == "" {
+		backup, err := quiesceInstanceForBackup(ctx, job, instanceID, instanceDir)
+		if err != nil {
+			return failedResultWithErrorCode(job.ID, "backup_hook_failed", err.Error())
+		}
+		backupPath = filepath.Join(targetDir, fmt.Sprintf("instance-%s-%d.tar.gz", sanitizeIdentifier(instanceID), time.Now().UTC().Unix()))
+		err = createTarGzArchive(ctx, backupPath, backup.SourceDir)
+		releaseErr := backup.Release()
+		if err != nil {
+			return failureResult(job.ID, fmt.Errorf("create backup archive: %w", err))
+		}
+		backup.addOutput(consistency, releaseErr)
+		if encryption.Enabled() {
+			if backupPath, err = encryptBackupArchive(backupPath, encryption); err != nil {
+				return failureResult(job.ID, err)
+			}
+		}
 	}
 
 	checksum, sizeBytes, err := computeFileChecksumAndSize(backupPath)
 	if err != nil {
 		return failureResult(job.ID, fmt.Errorf("compute backup metadata: %w", err))
 	}
+	manifest, err := readLocalBackupManifest(backupPath)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	manifestPath := ""
+	if manifest != nil {
+		manifestPath = backupPath + backupcrypt.ManifestSuffix
+	}
 
-	if backupTargetType == "webdav" || backupTargetType == "nextcloud" {
-		localPath := backupPath
-		remotePath, err := uploadBackupToWebdav(job.Payload, localPath)
-		if err != nil {
-			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": "backup_target_connection_failed"}, Completed: time.Now().UTC()}, nil
+	if target != nil {
+		if manifest != nil {
+			remoteManifest, result := uploadBackupManifest(ctx, job, target, backupPath, filepath.Base(backupPath))
+			if result != nil {
+				return *result, nil
+			}
+			manifestPath = remoteManifest
 		}
-		if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
-			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": fmt.Sprintf("remove local backup staging file: %v", err), "error_code": "backup_local_cleanup_failed"}, Completed: time.Now().UTC()}, nil
+		remotePath, result := uploadBackupArchive(ctx, job, target, backupPath, filepath.Base(backupPath))
+		if result != nil {
+			return *result, nil
 		}
+		// The local manifest stays until the archive is uploaded so a staged
+		// retry still knows the archive is encrypted.
+		_ = os.Remove(backupPath + backupcrypt.ManifestSuffix)
 		backupPath = remotePath
 	}
 
+	output := map[string]string{
+		"backup_id":   payloadValue(job.Payload, "backup_id"),
+		"backup_path": backupPath,
+		"size_bytes":  strconv.FormatInt(sizeBytes, 10),
+		"sha256":      checksum,
+	}
+	addBackupEncryptionOutput(output, manifest, manifestPath)
+	for key, value := range consistency {
+		output[key] = value
+	}
 	return jobs.Result{
-		JobID:  job.ID,
-		Status: "success",
-		Output: map[string]string{
-			"backup_id":   payloadValue(job.Payload, "backup_id"),
-			"backup_path": backupPath,
-			"size_bytes":  strconv.FormatInt(sizeBytes, 10),
-			"sha256":      checksum,
-		},
+		JobID:     job.ID,
+		Status:    "success",
+		Output:    output,
 		Completed: time.Now().UTC(),
 	}, nil
 }
 
-func handleInstanceBackupRestore(job jobs.Job) (jobs.Result, func() error) {
+func handleInstanceBackupRestore(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
 	backupPath := payloadValue(job.Payload, "backup_path")
-	if strings.TrimSpace(backupPath) == "" {
+	if strings.TrimSpace(backupPath) == "" && strings.TrimSpace(payloadValue(job.Payload, "snapshot_id")) == "" {
 		return failureResult(job.ID, fmt.Errorf("backup_path is required"))
 	}
-	backupTargetType := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "backup_target_type")))
-	if backupTargetType == "webdav" || backupTargetType == "nextcloud" {
-		if err := validateRemoteBackupURL(job.Payload, backupPath); err != nil {
-			return failureResult(job.ID, err)
-		}
-		tmpPath, err := downloadBackupFromWebdav(job.Payload, backupPath)
+	if repoRoot, snapshotID, ok, err := resolveInstanceSnapshot(job.Payload, backupPath); err != nil {
+		return failureResult(job.ID, err)
+	} else if ok {
+		return restoreInstanceSnapshot(ctx, job, repoRoot, snapshotID)
+	}
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	archiveRef := backupPath
+	if target != nil {
+		localPath, cleanup, err := fetchBackupArchive(ctx, target, backupPath)
 		if err != nil {
-			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": "backup_target_connection_failed"}, Completed: time.Now().UTC()}, nil
+			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": backupTargetErrorCode(err)}, Completed: time.Now().UTC()}, nil
 		}
-		backupPath = tmpPath
+		defer cleanup()
+		backupPath = localPath
 	}
 	if _, err := os.Stat(backupPath); err != nil {
 		return failureResult(job.ID, fmt.Errorf("backup archive missing: %w", err))
 	}
+	restoredFrom := backupPath
+	plainPath, cleanupPlain, err := decryptBackupArchive(ctx, job.Payload, target, archiveRef, backupPath)
+	if err != nil {
+		return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": "backup_decryption_failed"}, Completed: time.Now().UTC()}, nil
+	}
+	defer cleanupPlain()
+	backupPath = plainPath
 
 	instanceDir, err := resolveInstanceDir(job.Payload)
 	if err != nil {
@@ -132,8 +207,8 @@ func handleInstanceBackupRestore(job jobs.Job) (jobs.Result, func() error) {
 	}
 
 	if parsePayloadBool(payloadValue(job.Payload, "pre_backup"), false) {
-		preBackupPath := filepath.Join(filepath.Dir(backupPath), fmt.Sprintf("pre-restore-%d.tar.gz", time.Now().UTC().Unix()))
-		if err := createTarGzArchive(preBackupPath, instanceDir); err != nil {
+		preBackupPath := filepath.Join(filepath.Dir(restoredFrom), fmt.Sprintf("pre-restore-%d.tar.gz", time.Now().UTC().Unix()))
+		if err := createTarGzArchive(ctx, preBackupPath, instanceDir); err != nil {
 			return failureResult(job.ID, fmt.Errorf("create pre-restore backup: %w", err))
 		}
 	}
@@ -147,17 +222,24 @@ func handleInstanceBackupRestore(job jobs.Job) (jobs.Result, func() error) {
 		Status: "success",
 		Output: map[string]string{
 			"backup_id":     payloadValue(job.Payload, "backup_id"),
-			"restored_from": backupPath,
+			"restored_from": restoredFrom,
 		},
 		Completed: time.Now().UTC(),
 	}, nil
 }
 
-func createTarGzArchive(archivePath, sourceDir string) (err error) {
+// createTarGzArchive writes sourceDir to archivePath. The walk stops as soon as ctx is
+// cancelled and the partially written archive is removed.
+func createTarGzArchive(ctx context.Context, archivePath, sourceDir string) (err error) {
 	archive, err := os.Create(archivePath)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(archivePath)
+		}
+	}()
 	defer func() {
 		if closeErr := archive.Close(); err == nil && closeErr != nil {
 			err = closeErr
@@ -182,6 +264,9 @@ func createTarGzArchive(archivePath, sourceDir string) (err error) {
 		if walkErr != nil {
 			return walkErr
 		}
+		if ctx.Err() != nil {
+			return errJobCancelled
+		}
 		rel, err := filepath.Rel(sourceDir, path)
 		if err != nil {
 			return err
@@ -208,7 +293,7 @@ func createTarGzArchive(archivePath, sourceDir string) (err error) {
 		if err != nil {
 			return err
 		}
-		_, copyErr := io.Copy(tarWriter, file)
+		_, copyErr := io.Copy(tarWriter, &contextReader{ctx: ctx, reader: file})
 		closeErr := file.Close()
 		if copyErr != nil {
 			return copyErr
@@ -217,6 +302,19 @@ func createTarGzArchive(archivePath, sourceDir string) (err error) {
 	})
 }
 
+// contextReader aborts long copies once the owning job is cancelled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if r.ctx.Err() != nil {
+		return 0, errJobCancelled
+	}
+	return r.reader.Read(p)
+}
+
 func extractTarGzArchive(archivePath, destinationRoot string) (err error) {
 	archive, err := os.Open(archivePath)
 	if err != nil {
@@ -424,8 +522,21 @@ func payloadNestedValue(payload map[string]any, objectKey string, key string) st
 	return strings.TrimSpace(fmt.Sprintf("%v", v))
 }
 
+// Transport deadlines for remote backup targets. They bound connecting and
+// waiting for the server while leaving the body transfer itself open-ended.
+const (
+	backupTargetDialTimeout           = 30 * time.Second
+	backupTargetTLSHandshakeTimeout   = 30 * time.Second
+	backupTargetResponseHeaderTimeout = 2 * time.Minute
+)
+
 func webdavClient(verifyTLS bool) *http.Client {
-	tr := &http.Transport{}
+	tr := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: backupTargetDialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   backupTargetTLSHandshakeTimeout,
+		ResponseHeaderTimeout: backupTargetResponseHeaderTimeout,
+	}
 	if !verifyTLS {
 		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
 	}
@@ -494,13 +605,17 @@ func hasWebdavCredentials(payload map[string]any) bool {
 }
 
 func uploadBackupToWebdav(payload map[string]any, localPath string) (string, error) {
+	return uploadBackupToWebdavAs(payload, localPath, filepath.Base(localPath))
+}
+
+func uploadBackupToWebdavAs(payload map[string]any, localPath string, filename string) (string, error) {
 	baseURL, remoteFolder := webdavBaseAndFolder(payload)
 	verifyTLS := strings.ToLower(payloadNestedValue(payload, "backup_target_config", "verify_tls")) != "false"
 	if baseURL == "" || !hasWebdavCredentials(payload) {
 		return "", fmt.Errorf("webdav target credentials/config missing")
 	}
 
-	filename := filepath.Base(localPath)
+	filename = filepath.Base(filename)
 	u, err := url.Parse(baseURL + webdavEscapedPath(remoteFolder) + "/" + url.PathEscape(filename))
 	if err != nil {
 		return "", err
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+// Backup consistency strategies. The template picks one in backup_hooks; a
+// strategy that cannot be applied on this host falls back to backup_hooks.fallback.
+const (
+	backupConsistencyConsole  = "console"
+	backupConsistencyStop     = "stop"
+	backupConsistencySnapshot = "snapshot"
+	backupConsistencyNone     = "none"
+	backupConsistencyFail     = "fail"
+
+	defaultBackupHookSettle   = 5 * time.Second
+	maxBackupHookSettle       = 5 * time.Minute
+	defaultBackupSnapshotSize = "2G"
+)
+
+// backupConsoleCommandFn sends one console command to a running instance through
+// the wrapper socket, like instance.console.command does.
+var backupConsoleCommandFn = func(instanceID, command string) error {
+	return writeConsoleCommandToSocket(systemdConsoleSocketPath(instanceID), command)
+}
+
+// backupHooks is the backup_hooks object of a template: console commands sent
+// before and after the archive is written and how to fall back when the
+// console cannot be reached.
+type backupHooks struct {
+	PreCommands  []string
+	PostCommands []string
+	Settle       time.Duration
+	Strategy     string
+	Fallback     string
+	SnapshotSize string
+}
+
+func parseBackupHooks(payload map[string]any) (backupHooks, error) {
+	settings := payloadMap(payload, "backup_hooks")
+	hooks := backupHooks{
+		PreCommands:  parseStringList(firstPresent(settings, "pre_commands", "pre"), ""),
+		PostCommands: parseStringList(firstPresent(settings, "post_commands", "post"), ""),
+		Settle:       defaultBackupHookSettle,
+		Strategy:     strings.ToLower(strings.TrimSpace(payloadValue(settings, "strategy"))),
+		Fallback:     strings.ToLower(strings.TrimSpace(payloadValue(settings, "fallback"))),
+		SnapshotSize: firstNonEmpty(payloadValue(settings, "snapshot_size"), defaultBackupSnapshotSize),
+	}
+	for _, commands := range [][]string{hooks.PreCommands, hooks.PostCommands} {
+		for idx, command := range commands {
+			clean, err := sanitizeConsoleCommand(command)
+			if err != nil {
+				return backupHooks{}, fmt.Errorf("invalid backup hook command %q: %w", command, err)
+			}
+			commands[idx] = clean
+		}
+	}
+	if raw := payloadValue(settings, "settle_seconds", "wait_seconds"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxBackupHookSettle {
+			return backupHooks{}, fmt.Errorf("invalid backup_hooks.settle_seconds: %s", raw)
+		}
+		hooks.Settle = time.Duration(seconds) * time.Second
+	}
+	if hooks.Strategy == "" {
+		hooks.Strategy = backupConsistencyNone
+		if len(hooks.PreCommands) > 0 || len(hooks.PostCommands) > 0 {
+			hooks.Strategy = backupConsistencyConsole
+		}
+	}
+	if hooks.Fallback == "" {
+		hooks.Fallback = backupConsistencyNone
+	}
+	switch hooks.Strategy {
+	case backupConsistencyConsole, backupConsistencyStop, backupConsistencySnapshot, backupConsistencyNone:
+	default:
+		return backupHooks{}, fmt.Errorf("unsupported backup_hooks.strategy: %s", hooks.Strategy)
+	}
+	switch hooks.Fallback {
+	case backupConsistencyStop, backupConsistencySnapshot, backupConsistencyNone, backupConsistencyFail:
+	default:
+		return backupHooks{}, fmt.Errorf("unsupported backup_hooks.fallback: %s", hooks.Fallback)
+	}
+	return hooks, nil
+}
+
+func firstPresent(values map[string]any, keys ...string) any {
+	for _, key := range keys {
+		if value, ok := values[key]; ok {
+			return value
+		}
+	}
+	return nil
+}
+
+// quiescedBackup is an instance prepared for archiving. SourceDir is the
+// directory to archive: the instance directory itself or a read-only
+// snapshot of it. Release undoes the preparation and must always be called.
+type quiescedBackup struct {
+	SourceDir string
+	Method    string
+	Warnings  []string
+	release   []func() error
+}
+
+func (q *quiescedBackup) onRelease(fn func() error) {
+	q.release = append(q.release, fn)
+}
+
+// Release resumes the instance in the reverse order of preparation.
+func (q *quiescedBackup) Release() error {
+	var errs []error
+	for idx := len(q.release) - 1; idx >= 0; idx-- {
+		if err := q.release[idx](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	q.release = nil
+	return errors.Join(errs...)
+}
+
+// addOutput reports how the backup was made consistent.
+func (q *quiescedBackup) addOutput(output map[string]string, releaseErr error) {
+	output["backup_consistency"] = q.Method
+	warnings := append([]string(nil), q.Warnings...)
+	if releaseErr != nil {
+		warnings = append(warnings, "resume after backup: "+releaseErr.Error())
+	}
+	if len(warnings) > 0 {
+		output["backup_hook_warning"] = strings.Join(warnings, "; ")
+	}
+}
+
+// quiesceInstanceForBackup runs the template's backup hooks so the archive
+// sees a consistent instance directory: console commands such as save-off and
+// save-all flush, a short service stop or a filesystem snapshot. A stopped
+// service needs no preparation.
+func quiesceInstanceForBackup(ctx context.Context, job jobs.Job, instanceID, instanceDir string) (*quiescedBackup, error) {
+	hooks, err := parseBackupHooks(job.Payload)
+	if err != nil {
+		return nil, err
+	}
+	backup := &quiescedBackup{SourceDir: instanceDir, Method: backupConsistencyNone}
+	if hooks.Strategy == backupConsistencyNone {
+		return backup, nil
+	}
+	serviceName := firstNonEmpty(payloadValue(job.Payload, "service_name"), "gs-"+instanceID)
+	if !backupServiceActive(serviceName) {
+		backup.Method = "offline"
+		return backup, nil
+	}
+
+	strategy := hooks.Strategy
+	for {
+		err := applyBackupStrategy(ctx, job, backup, hooks, strategy, instanceID, instanceDir, serviceName)
+		if err == nil {
+			return backup, nil
+		}
+		if releaseErr := backup.Release(); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		backup.SourceDir = instanceDir
+		if errors.Is(err, errJobCancelled) || strategy == hooks.Fallback || hooks.Fallback == backupConsistencyFail {
+			return nil, fmt.Errorf("backup hooks (%s): %w", strategy, err)
+		}
+		backup.Warnings = append(backup.Warnings, fmt.Sprintf("%s failed, falling back to %s: %v", strategy, hooks.Fallback, err))
+		strategy = hooks.Fallback
+	}
+}
+
+func applyBackupStrategy(ctx context.Context, job jobs.Job, backup *quiescedBackup, hooks backupHooks, strategy, instanceID, instanceDir, serviceName string) error {
+	switch strategy {
+	case backupConsistencyConsole:
+		backup.Method = backupConsistencyConsole
+		return runBackupConsoleHooks(ctx, job, backup, hooks, instanceID)
+	case backupConsistencyStop:
+		backup.Method = backupConsistencyStop
+		if err := runCommand("systemctl", "stop", serviceName); err != nil {
+			return err
+		}
+		backup.onRelease(func() error { return runCommand("systemctl", "start", serviceName) })
+		return nil
+	case backupConsistencySnapshot:
+		// Flush through the console first when the template has commands, so
+		// the snapshot holds a complete save; the game resumes right after
+		// the snapshot instead of after the whole archive.
+		if len(hooks.PreCommands) > 0 {
+			if err := runBackupConsoleHooks(ctx, job, backup, hooks, instanceID); err != nil {
+				backup.Warnings = append(backup.Warnings, "console flush before snapshot failed: "+err.Error())
+			}
+		}
+		method, sourceDir, cleanup, err := snapshotInstanceDir(instanceDir, instanceID, hooks.SnapshotSize)
+		if err != nil {
+			return err
+		}
+		if releaseErr := backup.Release(); releaseErr != nil {
+			backup.Warnings = append(backup.Warnings, "resume after snapshot: "+releaseErr.Error())
+		}
+		backup.Method = method
+		backup.SourceDir = sourceDir
+		backup.onRelease(cleanup)
+		return nil
+	default:
+		backup.Method = backupConsistencyNone
+		return nil
+	}
+}
+
+// runBackupConsoleHooks sends the pre commands and waits for the game to
+// finish writing. The post commands are registered for Release as soon as
+// the first pre command was accepted.
+func runBackupConsoleHooks(ctx context.Context, job jobs.Job, backup *quiescedBackup, hooks backupHooks, instanceID string) error {
+	for idx, command := range hooks.PreCommands {
+		if err := backupConsoleCommandFn(instanceID, command); err != nil {
+			return fmt.Errorf("console command %q: %w", command, err)
+		}
+		if idx == 0 {
+			backup.onRelease(func() error { return sendBackupPostCommands(instanceID, hooks.PostCommands) })
+		}
+	}
+	if len(hooks.PreCommands) == 0 {
+		backup.onRelease(func() error { return sendBackupPostCommands(instanceID, hooks.PostCommands) })
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return errJobCancelled
+	case <-time.After(hooks.Settle):
+	}
+	return nil
+}
+
+func sendBackupPostCommands(instanceID string, commands []string) error {
+	var errs []error
+	for _, command := range commands {
+		if err := backupConsoleCommandFn(instanceID, command); err != nil {
+			errs = append(errs, fmt.Errorf("console command %q: %w", command, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func backupServiceActive(serviceName string) bool {
+	output, _ := commandOutputRunner("systemctl", "is-active", serviceName)
+	return strings.TrimSpace(output) == "active"
+}
+
+// snapshotInstanceDir takes a read-only btrfs or LVM snapshot of instanceDir
+// and returns the directory inside it that mirrors instanceDir.
+func snapshotInstanceDir(instanceDir, instanceID, lvmSize string) (string, string, func() error, error) {
+	fsType, err := commandOutputRunner("stat", "-f", "-c", "%T", instanceDir)
+	if err != nil {
+		return "", "", nil, err
+	}
+	name := fmt.Sprintf("easywi-backup-%s-%d", sanitizeIdentifier(instanceID), time.Now().UTC().Unix())
+	if strings.TrimSpace(fsType) == "btrfs" {
+		snapshotDir := filepath.Join(filepath.Dir(instanceDir), "."+name)
+		if err := runCommand("btrfs", "subvolume", "snapshot", "-r", instanceDir, snapshotDir); err != nil {
+			return "", "", nil, fmt.Errorf("btrfs snapshot (the instance directory must be a subvolume): %w", err)
+		}
+		return "snapshot-btrfs", snapshotDir, func() error {
+			return runCommand("btrfs", "subvolume", "delete", snapshotDir)
+		}, nil
+	}
+	return snapshotInstanceDirLVM(instanceDir, name, lvmSize)
+}
+
+func snapshotInstanceDirLVM(instanceDir, name, size string) (string, string, func() error, error) {
+	mountInfo, err := commandOutputRunner("findmnt", "-n", "-o", "SOURCE,TARGET,FSTYPE", "--target", instanceDir)
+	if err != nil {
+		return "", "", nil, err
+	}
+	fields := strings.Fields(mountInfo)
+	if len(fields) < 3 {
+		return "", "", nil, fmt.Errorf("cannot resolve the mount of %s", instanceDir)
+	}
+	device, mountTarget, fsType := fields[0], fields[1], fields[2]
+	relative, err := filepath.Rel(mountTarget, instanceDir)
+	if err != nil {
+		return "", "", nil, err
+	}
+	lvInfo, err := commandOutputRunner("lvs", "--noheadings", "-o", "vg_name,lv_name", device)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("%s is not on an LVM volume: %w", instanceDir, err)
+	}
+	lv := strings.Fields(lvInfo)
+	if len(lv) < 2 {
+		return "", "", nil, fmt.Errorf("%s is not on an LVM volume", instanceDir)
+	}
+	volumeGroup := lv[0]
+	if err := runCommand("lvcreate", "--snapshot", "--size", size, "--name", name, volumeGroup+"/"+lv[1]); err != nil {
+		return "", "", nil, err
+	}
+	removeSnapshot := func() error { return runCommand("lvremove", "-f", volumeGroup+"/"+name) }
+	mountDir, err := os.MkdirTemp("", name+"-")
+	if err != nil {
+		_ = removeSnapshot()
+		return "", "", nil, err
+	}
+	options := "ro"
+	if fsType == "xfs" {
+		options += ",nouuid"
+	}
+	if err := runCommand("mount", "-o", options, "/dev/"+volumeGroup+"/"+name, mountDir); err != nil {
+		_ = os.Remove(mountDir)
+		_ = removeSnapshot()
+		return "", "", nil, err
+	}
+	return "snapshot-lvm", filepath.Join(mountDir, relative), func() error {
+		if err := runCommand("umount", mountDir); err != nil {
+			return err
+		}
+		_ = os.Remove(mountDir)
+		return removeSnapshot()
+	}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func stubBackupHookCommands(t *testing.T, serviceState string, consoleErr error) *[]string {
+	t.Helper()
+	calls := []string{}
+	originalRunner := commandOutputRunner
+	originalConsole := backupConsoleCommandFn
+	commandOutputRunner = func(name string, args ...string) (string, error) {
+		if name == "systemctl" && len(args) == 2 && args[0] == "is-active" {
+			return serviceState + "\n", nil
+		}
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return "", nil
+	}
+	backupConsoleCommandFn = func(instanceID, command string) error {
+		calls = append(calls, "console "+instanceID+" "+command)
+		return consoleErr
+	}
+	t.Cleanup(func() {
+		commandOutputRunner = originalRunner
+		backupConsoleCommandFn = originalConsole
+	})
+	return &calls
+}
+
+func backupHooksTestPayload(instanceDir string, hooks map[string]any) map[string]any {
+	return map[string]any{"instance_id": "42", "install_path": instanceDir, "backup_hooks": hooks}
+}
+
+func TestHandleInstanceBackupCreateRunsConsoleHooksAroundArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("instance backups are not supported on windows agents")
+	}
+	instanceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(instanceDir, "level.dat"), []byte("world"), 0o644); err != nil {
+		t.Fatalf("write instance file: %v", err)
+	}
+	t.Setenv("EASYWI_INSTANCE_BACKUP_DIR", t.TempDir())
+	calls := stubBackupHookCommands(t, "active", nil)
+
+	result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: backupHooksTestPayload(instanceDir, map[string]any{
+		"pre_commands":   []any{"save-off", "save-all flush"},
+		"post_commands":  []any{"save-on"},
+		"settle_seconds": 0,
+	})})
+	if result.Status != "success" || result.Output["backup_consistency"] != backupConsistencyConsole || result.Output["backup_hook_warning"] != "" {
+		t.Fatalf("status=%s output=%v", result.Status, result.Output)
+	}
+	want := []string{"console 42 save-off", "console 42 save-all flush", "console 42 save-on"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("calls=%v, want %v", *calls, want)
+	}
+}
+
+func TestHandleInstanceBackupCreateFallsBackToServiceStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("instance backups are not supported on windows agents")
+	}
+	instanceDir := t.TempDir()
+	t.Setenv("EASYWI_INSTANCE_BACKUP_DIR", t.TempDir())
+	calls := stubBackupHookCommands(t, "active", errors.New("console socket unavailable"))
+
+	result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: backupHooksTestPayload(instanceDir, map[string]any{
+		"pre_commands":  "save-off",
+		"post_commands": "save-on",
+		"fallback":      "stop",
+	})})
+	if result.Status != "success" || result.Output["backup_consistency"] != backupConsistencyStop || !strings.Contains(result.Output["backup_hook_warning"], "falling back to stop") {
+		t.Fatalf("status=%s output=%v", result.Status, result.Output)
+	}
+	want := []string{"console 42 save-off", "systemctl stop gs-42", "systemctl start gs-42"}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("calls=%v, want %v", *calls, want)
+	}
+
+	*calls = (*calls)[:0]
+	failed, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-2", Payload: backupHooksTestPayload(instanceDir, map[string]any{
+		"pre_commands": "save-off",
+		"fallback":     "fail",
+	})})
+	if failed.Status != "failed" || failed.Output["error_code"] != "backup_hook_failed" {
+		t.Fatalf("status=%s output=%v", failed.Status, failed.Output)
+	}
+}
+
+func TestQuiesceInstanceForBackupSkipsStoppedInstances(t *testing.T) {
+	calls := stubBackupHookCommands(t, "inactive", nil)
+	backup, err := quiesceInstanceForBackup(context.Background(), jobs.Job{ID: "job-1", Payload: backupHooksTestPayload("/srv/gs-42", map[string]any{
+		"pre_commands": []any{"save-off"},
+		"strategy":     "snapshot",
+	})}, "42", "/srv/gs-42")
+	if err != nil {
+		t.Fatalf("quiesce: %v", err)
+	}
+	if backup.Method != "offline" || backup.SourceDir != "/srv/gs-42" || len(*calls) != 0 {
+		t.Fatalf("method=%s source=%s calls=%v", backup.Method, backup.SourceDir, *calls)
+	}
+
+	if _, err := parseBackupHooks(map[string]any{"backup_hooks": map[string]any{"strategy": "freeze"}}); err == nil {
+		t.Fatal("expected unknown strategy to fail")
+	}
+	if _, err := parseBackupHooks(map[string]any{"backup_hooks": map[string]any{"settle_seconds": "-1"}}); err == nil {
+		t.Fatal("expected negative settle_seconds to fail")
+	}
+}
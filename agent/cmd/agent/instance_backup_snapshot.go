@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/backupstore"
+	"easywi/agent/internal/jobs"
+)
+
+// instanceBackupModeDedup stores backups as snapshots in a chunk-deduplicated
+// repository instead of one tar.gz per run.
+const instanceBackupModeDedup = "dedup"
+
+const instanceBackupModeFull = "full"
+
+// instanceSnapshotRepoDirName is the repository directory below an instance's
+// backup target dir; tar.gz archives keep living next to it.
+const instanceSnapshotRepoDirName = "repository"
+
+func instanceBackupMode(payload map[string]any) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(payloadValue(payload, "backup_mode"))); mode {
+	case "", instanceBackupModeFull, "archive":
+		return instanceBackupModeFull, nil
+	case instanceBackupModeDedup, "incremental", "snapshot":
+		return instanceBackupModeDedup, nil
+	default:
+		return "", fmt.Errorf("unsupported backup_mode: %s", mode)
+	}
+}
+
+// instanceBackupTargetDir is where backups of an instance are written for the
+// payload's target: the instance subdirectory of the local backup root.
+func instanceBackupTargetDir(payload map[string]any, instanceID string) (string, error) {
+	backupRoot := backupRootDir()
+	if strings.EqualFold(strings.TrimSpace(payloadValue(payload, "backup_target_type")), "local") {
+		resolvedRoot, err := resolveLocalBackupRoot(payload, backupRoot)
+		if err != nil {
+			return "", err
+		}
+		backupRoot = resolvedRoot
+	}
+	return filepath.Join(backupRoot, sanitizeIdentifier(instanceID)), nil
+}
+
+// instanceBackupRetention reads the prune rules from a "retention" object or
+// flat retention_keep_* keys. A zero policy means no pruning.
+func instanceBackupRetention(payload map[string]any) (backupstore.RetentionPolicy, error) {
+	policy := backupstore.RetentionPolicy{}
+	fields := []struct {
+		key    string
+		target *int
+	}{
+		{"keep_last", &policy.KeepLast},
+		{"keep_daily", &policy.KeepDaily},
+		{"keep_weekly", &policy.KeepWeekly},
+	}
+	for _, field := range fields {
+		raw := firstNonEmpty(payloadNestedValue(payload, "retention", field.key), payloadValue(payload, "retention_"+field.key))
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retention %s: %s", field.key, raw)
+		}
+		*field.target = value
+	}
+	return policy, policy.Validate()
+}
+
+// resolveInstanceSnapshot reports whether a restore refers to a dedup snapshot,
+// either by snapshot_id or by a backup_path pointing at a snapshot manifest,
+// and returns the repository root and snapshot id.
+func resolveInstanceSnapshot(payload map[string]any, backupPath string) (string, string, bool, error) {
+	if snapshotID := strings.TrimSpace(payloadValue(payload, "snapshot_id")); snapshotID != "" {
+		instanceID := strings.TrimSpace(payloadValue(payload, "instance_id"))
+		if instanceID == "" {
+			return "", "", false, fmt.Errorf("instance_id is required")
+		}
+		targetDir, err := instanceBackupTargetDir(payload, instanceID)
+		if err != nil {
+			return "", "", false, err
+		}
+		if !backupstore.ValidSnapshotID(snapshotID) {
+			return "", "", false, fmt.Errorf("invalid snapshot_id: %s", snapshotID)
+		}
+		return filepath.Join(targetDir, instanceSnapshotRepoDirName), snapshotID, true, nil
+	}
+	if !strings.HasSuffix(backupPath, ".json") || filepath.Base(filepath.Dir(backupPath)) != "snapshots" {
+		return "", "", false, nil
+	}
+	snapshotID := strings.TrimSuffix(filepath.Base(backupPath), ".json")
+	if !backupstore.ValidSnapshotID(snapshotID) {
+		return "", "", false, fmt.Errorf("invalid snapshot manifest: %s", backupPath)
+	}
+	return filepath.Dir(filepath.Dir(backupPath)), snapshotID, true, nil
+}
+
+func createInstanceSnapshot(ctx context.Context, job jobs.Job, instanceID, instanceDir, targetDir string) (jobs.Result, func() error) {
+	policy, err := instanceBackupRetention(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	repo, err := backupstore.Open(filepath.Join(targetDir, instanceSnapshotRepoDirName))
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	snapshot, err := repo.Create(ctx, instanceDir, instanceID, time.Now())
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("create backup snapshot: %w", err))
+	}
+	manifestPath := repo.ManifestPath(snapshot.ID)
+	checksum, _, err := computeFileChecksumAndSize(manifestPath)
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("compute backup metadata: %w", err))
+	}
+
+	output := map[string]string{
+		"backup_id":    payloadValue(job.Payload, "backup_id"),
+		"backup_mode":  instanceBackupModeDedup,
+		"backup_path":  manifestPath,
+		"snapshot_id":  snapshot.ID,
+		"size_bytes":   strconv.FormatInt(snapshot.Stats.Bytes, 10),
+		"added_bytes":  strconv.FormatInt(snapshot.Stats.AddedBytes, 10),
+		"files":        strconv.Itoa(snapshot.Stats.Files),
+		"reused_files": strconv.Itoa(snapshot.Stats.ReusedFiles),
+		"new_chunks":   strconv.Itoa(snapshot.Stats.NewChunks),
+		"sha256":       checksum,
+	}
+	if !policy.IsZero() {
+		// The snapshot is already safe; a failed prune is reported, not fatal.
+		pruned, err := repo.Prune(policy)
+		if err != nil {
+			output["prune_error"] = err.Error()
+		} else {
+			addPruneOutput(output, pruned)
+		}
+	}
+	return jobs.Result{JobID: job.ID, Status: "success", Output: output, Completed: time.Now().UTC()}, nil
+}
+
+func restoreInstanceSnapshot(ctx context.Context, job jobs.Job, repoRoot, snapshotID string) (jobs.Result, func() error) {
+	repo, err := backupstore.Open(repoRoot)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	if _, err := os.Stat(repo.ManifestPath(snapshotID)); err != nil {
+		return failureResult(job.ID, fmt.Errorf("backup snapshot missing: %w", err))
+	}
+	instanceDir, err := resolveInstanceDir(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	if err := os.MkdirAll(instanceDir, 0o750); err != nil {
+		return failureResult(job.ID, fmt.Errorf("create instance dir: %w", err))
+	}
+
+	output := map[string]string{
+		"backup_id":     payloadValue(job.Payload, "backup_id"),
+		"restored_from": repo.ManifestPath(snapshotID),
+		"snapshot_id":   snapshotID,
+	}
+	if parsePayloadBool(payloadValue(job.Payload, "pre_backup"), false) {
+		preRestore, err := repo.Create(ctx, instanceDir, payloadValue(job.Payload, "instance_id"), time.Now())
+		if err != nil {
+			return failureResult(job.ID, fmt.Errorf("create pre-restore snapshot: %w", err))
+		}
+		output["pre_restore_snapshot_id"] = preRestore.ID
+	}
+
+	paths := parseStringList(job.Payload["paths"], "")
+	stats, err := repo.Restore(ctx, snapshotID, instanceDir, paths)
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("restore backup snapshot: %w", err))
+	}
+	output["restored_files"] = strconv.Itoa(stats.Files)
+	output["restored_bytes"] = strconv.FormatInt(stats.Bytes, 10)
+	if len(paths) > 0 {
+		output["restored_paths"] = strings.Join(paths, ",")
+	}
+	return jobs.Result{JobID: job.ID, Status: "success", Output: output, Completed: time.Now().UTC()}, nil
+}
+
+func handleInstanceBackupPrune(job jobs.Job) (jobs.Result, func() error) {
+	instanceID := strings.TrimSpace(payloadValue(job.Payload, "instance_id"))
+	if instanceID == "" {
+		return failureResult(job.ID, fmt.Errorf("instance_id is required"))
+	}
+	policy, err := instanceBackupRetention(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	if policy.IsZero() {
+		return failureResult(job.ID, fmt.Errorf("retention keep_last, keep_daily or keep_weekly is required"))
+	}
+	targetDir, err := instanceBackupTargetDir(job.Payload, instanceID)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	repoRoot := filepath.Join(targetDir, instanceSnapshotRepoDirName)
+	if _, err := os.Stat(repoRoot); err != nil {
+		return failureResult(job.ID, fmt.Errorf("backup repository missing: %w", err))
+	}
+	repo, err := backupstore.Open(repoRoot)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	pruned, err := repo.Prune(policy)
+	if err != nil {
+		return failureResult(job.ID, fmt.Errorf("prune backup snapshots: %w", err))
+	}
+	output := map[string]string{"instance_id": instanceID}
+	addPruneOutput(output, pruned)
+	return jobs.Result{JobID: job.ID, Status: "success", Output: output, Completed: time.Now().UTC()}, nil
+}
+
+func addPruneOutput(output map[string]string, pruned backupstore.PruneResult) {
+	output["kept_snapshots"] = strings.Join(pruned.Kept, ",")
+	output["pruned_snapshots"] = strings.Join(pruned.Removed, ",")
+	output["pruned_chunks"] = strconv.Itoa(pruned.RemovedChunks)
+	output["freed_bytes"] = strconv.FormatInt(pruned.FreedBytes, 10)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestInstanceBackupDedupCreateRestoreAndPrune(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("instance backups are not supported on windows agents")
+	}
+
+	instanceDir := t.TempDir()
+	backupRoot := t.TempDir()
+	t.Setenv("EASYWI_INSTANCE_BACKUP_DIR", backupRoot)
+	writeInstanceFile := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(instanceDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write instance file: %v", err)
+		}
+	}
+	writeInstanceFile("server.cfg", "hostname test")
+	writeInstanceFile("world/level.dat", "v1")
+
+	payload := map[string]any{"instance_id": "42", "install_path": instanceDir, "backup_mode": "incremental"}
+	first, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: payload})
+	if first.Status != "success" || first.Output["backup_mode"] != instanceBackupModeDedup || first.Output["snapshot_id"] == "" {
+		t.Fatalf("first backup: status=%s output=%v", first.Status, first.Output)
+	}
+	if !strings.HasPrefix(first.Output["backup_path"], filepath.Join(backupRoot, "42", instanceSnapshotRepoDirName)) {
+		t.Fatalf("backup_path=%q, want manifest in the instance repository", first.Output["backup_path"])
+	}
+
+	writeInstanceFile("world/level.dat", "v2")
+	writeInstanceFile("server.cfg", "hostname changed later")
+	second, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-2", Payload: map[string]any{
+		"instance_id":  "42",
+		"install_path": instanceDir,
+		"backup_mode":  "dedup",
+		"retention":    map[string]any{"keep_last": 1},
+	}})
+	if second.Status != "success" || second.Output["pruned_snapshots"] != first.Output["snapshot_id"] {
+		t.Fatalf("second backup: status=%s output=%v", second.Status, second.Output)
+	}
+
+	writeInstanceFile("world/level.dat", "corrupted")
+	restore, _ := handleInstanceBackupRestore(context.Background(), jobs.Job{ID: "job-3", Payload: map[string]any{
+		"instance_id":  "42",
+		"install_path": instanceDir,
+		"backup_path":  second.Output["backup_path"],
+		"paths":        []any{"world"},
+	}})
+	if restore.Status != "success" || restore.Output["restored_files"] != "1" {
+		t.Fatalf("restore: status=%s output=%v", restore.Status, restore.Output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(instanceDir, "world", "level.dat")); string(data) != "v2" {
+		t.Fatalf("level.dat=%q, want restored v2", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(instanceDir, "server.cfg")); string(data) != "hostname changed later" {
+		t.Fatalf("server.cfg=%q, want untouched by the selective restore", data)
+	}
+
+	pruned, _ := handleInstanceBackupPrune(jobs.Job{ID: "job-4", Payload: map[string]any{"instance_id": "42", "retention_keep_last": "5"}})
+	if pruned.Status != "success" || pruned.Output["kept_snapshots"] != second.Output["snapshot_id"] {
+		t.Fatalf("prune: status=%s output=%v", pruned.Status, pruned.Output)
+	}
+}
+
+func TestInstanceBackupDedupRejectsRemoteTargetAndUnknownMode(t *testing.T) {
+	payload := map[string]any{"instance_id": "42", "install_path": t.TempDir(), "backup_mode": "dedup", "backup_target_type": "webdav"}
+	if result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-1", Payload: payload}); result.Status != "failed" {
+		t.Fatalf("expected dedup to a webdav target to fail, got %v", result.Output)
+	}
+	payload = map[string]any{"instance_id": "42", "install_path": t.TempDir(), "backup_mode": "differential"}
+	if result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{ID: "job-2", Payload: payload}); result.Status != "failed" {
+		t.Fatalf("expected unknown backup_mode to fail, got %v", result.Output)
+	}
+	if _, _, _, err := resolveInstanceSnapshot(map[string]any{"instance_id": "42", "snapshot_id": "../../etc"}, ""); err == nil {
+		t.Fatal("expected traversal snapshot_id to be rejected")
+	}
+}
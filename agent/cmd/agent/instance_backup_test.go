@@ -3,6 +3,7 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -208,7 +209,7 @@ func TestHandleInstanceBackupCreateUploadsToWebDAVAndRemovesLocalArchive(t *test
 	}))
 	defer server.Close()
 
-	result, _ := handleInstanceBackupCreate(jobs.Job{
+	result, _ := handleInstanceBackupCreate(context.Background(), jobs.Job{
 		ID: "job-1",
 		Payload: map[string]any{
 			"instance_id":        "42",
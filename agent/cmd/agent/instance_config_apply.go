@@ -13,7 +13,7 @@ import (
 
 func handleInstanceConfigApply(job jobs.Job) (jobs.Result, func() error) {
 	requestID := payloadValue(job.Payload, "request_id")
-	installPath := strings.TrimSpace(payloadValue(job.Payload, "install_path", "instance_root", "root_path"))
+	installPath := strings.TrimSpace(payloadValue(job.Payload, "install_path"))
 	baseDir := strings.TrimSpace(payloadValue(job.Payload, "base_dir"))
 	osType := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "os_type")))
 	if osType == "" {
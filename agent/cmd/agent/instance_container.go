@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"easywi/agent/internal/portalloc"
+)
+
+const (
+	instanceRuntimeSystemd   = "systemd"
+	instanceRuntimeContainer = "container"
+
+	containerEnginePodman = "podman"
+
+	containerNetworkPublish = "publish"
+	containerNetworkHost    = "host"
+
+	subordinateIDStart = 100000
+	subordinateIDCount = 65536
+)
+
+// subordinateIDFiles map container user namespaces onto host IDs; rootless
+// engines need a range for the instance user in both.
+var subordinateIDFiles = []string{"/etc/subuid", "/etc/subgid"}
+
+// subordinateIDMu serializes ensureSubordinateIDs: creates and reinstalls of
+// different instances run in parallel, and two concurrent read-then-append
+// passes would hand both users the same range.
+var subordinateIDMu sync.Mutex
+
+// instanceContainer runs a game server in a rootless OCI container instead
+// of directly under its Linux user. The template selects it with
+// runtime=container and a container object (image, engine, network).
+// Only podman is supported: rootless nerdctl also needs a per-user
+// containerd, which the agent does not set up.
+type instanceContainer struct {
+	Engine    string
+	EngineBin string
+	Image     string
+	Network   string
+}
+
+// parseInstanceRuntime returns nil for the default systemd runtime.
+func parseInstanceRuntime(payload map[string]any) (*instanceContainer, error) {
+	runtimeName := strings.ToLower(firstNonEmpty(payloadValue(payload, "runtime", "instance_runtime"), instanceRuntimeSystemd))
+	switch runtimeName {
+	case instanceRuntimeSystemd:
+		return nil, nil
+	case instanceRuntimeContainer, "oci":
+	default:
+		return nil, fmt.Errorf("unsupported runtime: %s", runtimeName)
+	}
+	settings := payloadMap(payload, "container")
+	container := &instanceContainer{
+		Engine:  strings.ToLower(firstNonEmpty(payloadValue(settings, "engine"), containerEnginePodman)),
+		Image:   payloadValue(settings, "image"),
+		Network: strings.ToLower(firstNonEmpty(payloadValue(settings, "network"), containerNetworkPublish)),
+	}
+	if container.Image == "" || strings.ContainsAny(container.Image, " \t\n\"'") {
+		return nil, fmt.Errorf("container.image is required for the container runtime")
+	}
+	switch container.Engine {
+	case containerEnginePodman:
+	case "nerdctl", "containerd":
+		return nil, fmt.Errorf("unsupported container.engine: %s (rootless containers need podman)", container.Engine)
+	default:
+		return nil, fmt.Errorf("unsupported container.engine: %s", container.Engine)
+	}
+	switch container.Network {
+	case containerNetworkPublish, containerNetworkHost:
+	default:
+		return nil, fmt.Errorf("unsupported container.network: %s", container.Network)
+	}
+	return container, nil
+}
+
+func instanceContainerName(serviceName string) string {
+	return "easywi-" + serviceName
+}
+
+// engineCommand builds the engine invocation that replaces the start command.
+// The console wrapper keeps stdin attached through -i. Leased ports are
+// published one by one; the container stays in the unit's cgroup
+// (--cgroups=split) so the unit's resource profile applies. Every argument is
+// quoted for ExecStart=, so paths with spaces, % or $ reach the engine as-is.
+func (c instanceContainer) engineCommand(serviceName, instanceDir, startCommand string, leases []portalloc.Lease, readOnlyMounts []string) (string, error) {
+	fields, err := splitSystemdCommand(startCommand)
+	if err != nil {
+		return "", fmt.Errorf("parse start command: %w", err)
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("missing start command")
+	}
+	args := []string{
+		c.EngineBin, "run", "--rm", "-i",
+		"--name", instanceContainerName(serviceName),
+		"--pull=missing",
+		"--user", "0:0",
+		"--volume", instanceDir + ":" + instanceDir,
+		"--workdir", instanceDir,
+		"--env", "HOME=" + instanceDir,
+	}
+	for _, mount := range readOnlyMounts {
+		args = append(args, "--volume", mount+":"+mount+":ro")
+	}
+	if c.Network == containerNetworkHost {
+		args = append(args, "--network", "host")
+	} else {
+		for _, lease := range leases {
+			args = append(args, "--publish", fmt.Sprintf("%d:%d/%s", lease.Port, lease.Port, lease.Proto))
+		}
+	}
+	args = append(args, "--cgroups=split")
+	args = append(args, "--entrypoint", fields[0], c.Image)
+	args = append(args, fields[1:]...)
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\n\r\x00") {
+			return "", fmt.Errorf("container argument contains a line break: %q", arg)
+		}
+		quoted = append(quoted, systemdQuoteArg(arg))
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// splitSystemdCommand splits a command line the way ExecStart= does for
+// quoting: whitespace separates arguments, double quotes allow backslash
+// escapes and single quotes keep their content literally. The start command
+// of an instance is written in that form by writeStartScript.
+func splitSystemdCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case ch == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			current.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case ch == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) {
+					i++
+					current.WriteByte(unescapeSystemdByte(command[i]))
+					continue
+				}
+				current.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inArg = true
+		case ch == '\\' && i+1 < len(command):
+			i++
+			current.WriteByte(unescapeSystemdByte(command[i]))
+			inArg = true
+		default:
+			current.WriteByte(ch)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+func unescapeSystemdByte(ch byte) byte {
+	switch ch {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	}
+	return ch
+}
+
+// systemdQuoteArg quotes one ExecStart= argument. systemd expands %
+// specifiers and $ variables even inside quotes, so both are doubled.
+func systemdQuoteArg(arg string) string {
+	escaped := strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return escaped
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(escaped) + `"`
+}
+
+// containerUnit is what the unit of a containerized instance needs besides
+// the engine command: the user's runtime dir for the rootless engine and the
+// command that removes a container left behind by a crash.
+type containerUnit struct {
+	RuntimeDir    string
+	RemoveCommand string
+}
+
+func (c instanceContainer) unit(serviceName string, uid int) *containerUnit {
+	return &containerUnit{
+		RuntimeDir:    fmt.Sprintf("/run/user/%d", uid),
+		RemoveCommand: fmt.Sprintf("-%s rm -f %s", c.EngineBin, instanceContainerName(serviceName)),
+	}
+}
+
+// prepareRootlessUser resolves the engine binary and sets up what rootless
+// containers need for the instance user: subordinate ID ranges and a
+// lingering login session that provides /run/user/<uid>.
+func (c *instanceContainer) prepareRootlessUser(username string) error {
+	engineBin, err := exec.LookPath(c.Engine)
+	if err != nil {
+		return fmt.Errorf("container engine %s is not installed", c.Engine)
+	}
+	c.EngineBin = engineBin
+	for _, path := range subordinateIDFiles {
+		if err := ensureSubordinateIDs(path, username); err != nil {
+			return err
+		}
+	}
+	if err := runCommand("loginctl", "enable-linger", username); err != nil {
+		return fmt.Errorf("enable linger for %s: %w", username, err)
+	}
+	return nil
+}
+
+// ensureSubordinateIDs appends a range for username to a subuid/subgid file
+// unless it has one, starting after the highest range in use.
+func ensureSubordinateIDs(path, username string) error {
+	subordinateIDMu.Lock()
+	defer subordinateIDMu.Unlock()
+	next := subordinateIDStart
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	if file != nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+			if len(parts) != 3 {
+				continue
+			}
+			if parts[0] == username {
+				file.Close()
+				return nil
+			}
+			start, errStart := strconv.Atoi(parts[1])
+			count, errCount := strconv.Atoi(parts[2])
+			if errStart == nil && errCount == nil && start+count > next {
+				next = start + count
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	if _, err := fmt.Fprintf(out, "%s:%d:%d\n", username, next, subordinateIDCount); err != nil {
+		out.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return out.Close()
+}
+
+// instanceUnitContent renders the unit of a game server for its runtime.
+func instanceUnitContent(serviceName, osUsername, instanceDir, startCommand, instanceID string, uid int, resources instanceResourceProfile, container *instanceContainer, readOnlyMounts []string) (string, error) {
+	if container == nil {
+		return systemdUnitTemplateWithResources(serviceName, osUsername, instanceDir, instanceDir, startCommand, "", resources), nil
+	}
+	allocator, err := portAllocator()
+	if err != nil {
+		return "", err
+	}
+	command, err := container.engineCommand(serviceName, instanceDir, startCommand, allocator.Leases(instanceID), readOnlyMounts)
+	if err != nil {
+		return "", err
+	}
+	return systemdUnitTemplateWithRuntime(serviceName, osUsername, instanceDir, instanceDir, command, "", resources, container.unit(serviceName, uid)), nil
+}
+
+// containerSharedMounts returns the shared server dir, which overlay and
+// bind mounts in the instance dir point into, for a read-only volume.
+func containerSharedMounts(payload map[string]any, baseDir, contextName string) []string {
+	if !shouldUseSharedStorage(payload, contextName) {
+		return nil
+	}
+	sharedRoot := payloadValue(payload, "shared_server_dir")
+	if sharedRoot == "" {
+		if sharedKey := payloadValue(payload, "shared_key"); sharedKey != "" {
+			sharedRoot = sharedServerDir(baseDir, sharedKey)
+		}
+	}
+	if sharedRoot == "" {
+		return nil
+	}
+	return []string{sharedRoot}
+}
+
+func instanceRuntimeName(container *instanceContainer) string {
+	if container == nil {
+		return instanceRuntimeSystemd
+	}
+	return instanceRuntimeContainer
+}
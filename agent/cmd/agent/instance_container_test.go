@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"easywi/agent/internal/portalloc"
+)
+
+func TestContainerRuntimeUnitRunsStartScriptInRootlessContainer(t *testing.T) {
+	container, err := parseInstanceRuntime(map[string]any{
+		"runtime":   "container",
+		"container": map[string]any{"image": "docker.io/library/debian:bookworm"},
+	})
+	if err != nil || container == nil {
+		t.Fatalf("parse runtime: %v", err)
+	}
+	container.EngineBin = "/usr/bin/podman"
+	instanceDir := "/srv/easywi/instances/42"
+	leases := []portalloc.Lease{{Proto: "tcp", Port: 30000}, {Proto: "udp", Port: 30000}}
+	command, err := container.engineCommand("gs-42", instanceDir, `/bin/bash "`+instanceDir+`/_easywi/start.sh"`, leases, []string{"/srv/shared/cs2"})
+	if err != nil {
+		t.Fatalf("engine command: %v", err)
+	}
+	for _, part := range []string{
+		"/usr/bin/podman run --rm -i --name easywi-gs-42",
+		"--volume " + instanceDir + ":" + instanceDir + " ",
+		"--volume /srv/shared/cs2:/srv/shared/cs2:ro",
+		"--publish 30000:30000/tcp --publish 30000:30000/udp",
+		"--cgroups=split",
+		"--entrypoint /bin/bash docker.io/library/debian:bookworm " + instanceDir + "/_easywi/start.sh",
+	} {
+		if !strings.Contains(command, part) {
+			t.Fatalf("expected %q in %q", part, command)
+		}
+	}
+
+	unit := systemdUnitTemplateWithRuntime("gs-42", "easywi", instanceDir, instanceDir, command, "", instanceResourceProfile{CPUQuota: 100}, container.unit("gs-42", 1042))
+	for _, line := range []string{
+		"Environment=XDG_RUNTIME_DIR=/run/user/1042",
+		"ReadWritePaths=" + instanceDir + " /run/user/1042",
+		"NoNewPrivileges=false",
+		"Delegate=yes",
+		"ExecStartPre=-/usr/bin/podman rm -f easywi-gs-42",
+		"ExecStopPost=-/usr/bin/podman rm -f easywi-gs-42",
+		"CPUQuota=100%",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Fatalf("expected %q in unit:\n%s", line, unit)
+		}
+	}
+	if !strings.Contains(unit, "--command-socket /run/easywi/instances/42/console.sock -- /usr/bin/podman run") {
+		t.Fatalf("expected console wrapper around the engine, got unit:\n%s", unit)
+	}
+
+	if _, err := parseInstanceRuntime(map[string]any{"runtime": "container"}); err == nil {
+		t.Fatalf("expected container runtime without image to be rejected")
+	}
+	if _, err := parseInstanceRuntime(map[string]any{"runtime": "container", "container": map[string]any{"image": "debian", "engine": "nerdctl"}}); err == nil {
+		t.Fatalf("expected nerdctl to be rejected without a per-user containerd")
+	}
+	if runtimeDefault, err := parseInstanceRuntime(map[string]any{}); err != nil || runtimeDefault != nil {
+		t.Fatalf("expected default systemd runtime, got %v, %v", runtimeDefault, err)
+	}
+}
+
+func TestEnsureSubordinateIDsAppendsAfterHighestRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subuid")
+	if err := os.WriteFile(path, []byte("alice:100000:65536\nbob:231072:65536\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureSubordinateIDs(path, "gs42"); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if err := ensureSubordinateIDs(path, "gs42"); err != nil {
+		t.Fatalf("ensure again: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alice:100000:65536\nbob:231072:65536\ngs42:296608:65536\n"; string(data) != want {
+		t.Fatalf("unexpected subuid file:\n%s", data)
+	}
+}
+
+func TestContainerEngineCommandQuotesArgumentsForSystemd(t *testing.T) {
+	container := instanceContainer{Engine: containerEnginePodman, EngineBin: "/usr/bin/podman", Image: "docker.io/library/debian:bookworm", Network: containerNetworkHost}
+	instanceDir := "/srv/easy wi/100%/$HOME"
+	command, err := container.engineCommand("gs-42", instanceDir, fmt.Sprintf("/bin/bash %q", instanceDir+"/_easywi/start.sh"), nil, []string{"/srv/shared dir"})
+	if err != nil {
+		t.Fatalf("engine command: %v", err)
+	}
+	for _, part := range []string{
+		`--volume "/srv/easy wi/100%%/$$HOME:/srv/easy wi/100%%/$$HOME"`,
+		`--workdir "/srv/easy wi/100%%/$$HOME"`,
+		`--volume "/srv/shared dir:/srv/shared dir:ro"`,
+		`--entrypoint /bin/bash docker.io/library/debian:bookworm "/srv/easy wi/100%%/$$HOME/_easywi/start.sh"`,
+	} {
+		if !strings.Contains(command, part) {
+			t.Fatalf("expected %q in %q", part, command)
+		}
+	}
+
+	if _, err := container.engineCommand("gs-42", "/srv/a\nb", "/bin/start", nil, nil); err == nil {
+		t.Fatal("expected a line break in an argument to be rejected")
+	}
+	if _, err := container.engineCommand("gs-42", instanceDir, `/bin/bash "unterminated`, nil, nil); err == nil {
+		t.Fatal("expected an unterminated quote in the start command to be rejected")
+	}
+}
+
+func TestSplitSystemdCommandHonoursQuotes(t *testing.T) {
+	args, err := splitSystemdCommand(`/bin/bash "/srv/a b/\"x\".sh" 'it''s' plain\ arg`)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	want := []string{"/bin/bash", `/srv/a b/"x".sh`, "its", "plain arg"}
+	if strings.Join(args, "|") != strings.Join(want, "|") {
+		t.Fatalf("args = %q, want %q", args, want)
+	}
+}
+
+func TestEnsureSubordinateIDsHandsOutDistinctRangesConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subgid")
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			if err := ensureSubordinateIDs(path, username); err != nil {
+				t.Errorf("ensure %s: %v", username, err)
+			}
+		}(fmt.Sprintf("gs%d", idx))
+	}
+	wg.Wait()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	starts := map[string]bool{}
+	for _, line := range lines {
+		parts := strings.Split(line, ":")
+		if len(parts) != 3 || starts[parts[1]] {
+			t.Fatalf("expected distinct ranges, got:\n%s", data)
+		}
+		starts[parts[1]] = true
+	}
+	if len(starts) != 8 {
+		t.Fatalf("expected 8 ranges, got:\n%s", data)
+	}
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	instanceCrashReportJobType = "instance.crash.report"
+	instanceCrashResetJobType  = "instance.crash.reset"
+
+	// unitProcessExitMessageID is the systemd catalog ID of the "Main process
+	// exited" message PID 1 logs when a service process fails.
+	unitProcessExitMessageID = "98e322203f7a4ed290d09fe03c09fe15"
+
+	defaultCrashConsoleLines  = 100
+	maxCrashConsoleLines      = 1000
+	defaultCrashLoopThreshold = 5
+	defaultCrashLoopWindow    = 10 * time.Minute
+	maxCrashFiles             = 5
+	maxCrashFileBytes         = 64 * 1024
+	crashMonitorRetryDelay    = 30 * time.Second
+)
+
+var (
+	instanceCrashDir = "/var/lib/easywi/instance_crashes"
+	cgroupFSRoot     = "/sys/fs/cgroup"
+)
+
+// crashSignalNames covers the signals game servers usually die from.
+var crashSignalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 6: "SIGABRT", 7: "SIGBUS",
+	8: "SIGFPE", 9: "SIGKILL", 11: "SIGSEGV", 13: "SIGPIPE", 15: "SIGTERM",
+}
+
+// instanceCrashSettings come from the template: crash_files lists globs
+// relative to the instance dir (a trailing slash means every file in that
+// dir), the crash_loop_* values decide when restarts stop.
+type instanceCrashSettings struct {
+	InstanceDir   string   `json:"instance_dir,omitempty"`
+	CrashFiles    []string `json:"crash_files,omitempty"`
+	ConsoleLines  int      `json:"console_lines"`
+	LoopThreshold int      `json:"loop_threshold"`
+	LoopWindowSec int      `json:"loop_window_seconds"`
+}
+
+func defaultInstanceCrashSettings() instanceCrashSettings {
+	return instanceCrashSettings{
+		ConsoleLines:  defaultCrashConsoleLines,
+		LoopThreshold: defaultCrashLoopThreshold,
+		LoopWindowSec: int(defaultCrashLoopWindow.Seconds()),
+	}
+}
+
+// apply overrides the settings present in a job payload.
+func (s *instanceCrashSettings) apply(payload map[string]any) error {
+	if instanceDir, err := resolveInstanceDir(payload); err == nil {
+		s.InstanceDir = instanceDir
+	}
+	if _, ok := payload["crash_files"]; ok {
+		patterns := parseStringList(payload["crash_files"], "")
+		for _, pattern := range patterns {
+			clean := filepath.Clean(strings.TrimSuffix(pattern, "/"))
+			if filepath.IsAbs(pattern) || clean == ".." || strings.HasPrefix(clean, "../") {
+				return fmt.Errorf("crash_files must stay inside the instance dir: %s", pattern)
+			}
+			if _, err := filepath.Match(clean, ""); err != nil {
+				return fmt.Errorf("invalid crash_files pattern %s: %w", pattern, err)
+			}
+		}
+		s.CrashFiles = patterns
+	}
+	for key, target := range map[string]*int{
+		"crash_console_lines":       &s.ConsoleLines,
+		"crash_loop_threshold":      &s.LoopThreshold,
+		"crash_loop_window_seconds": &s.LoopWindowSec,
+	} {
+		value := payloadValue(payload, key)
+		if value == "" {
+			continue
+		}
+		parsed, err := parsePositiveInt(value, key)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	}
+	if s.ConsoleLines > maxCrashConsoleLines {
+		s.ConsoleLines = maxCrashConsoleLines
+	}
+	return nil
+}
+
+type instanceCrashFile struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Content   string    `json:"content"`
+}
+
+// instanceCrashReport bundles what is known about one exit of a game server.
+type instanceCrashReport struct {
+	InstanceID   string              `json:"instance_id"`
+	ServiceName  string              `json:"service_name"`
+	InvocationID string              `json:"invocation_id,omitempty"`
+	DetectedAt   time.Time           `json:"detected_at"`
+	Result       string              `json:"result"`
+	Reason       string              `json:"reason"`
+	ExitCode     *int                `json:"exit_code,omitempty"`
+	Signal       string              `json:"signal,omitempty"`
+	OOMKills     int                 `json:"oom_kills"`
+	Restarts     int                 `json:"restarts"`
+	ConsoleTail  []string            `json:"console_tail"`
+	CrashFiles   []instanceCrashFile `json:"crash_files"`
+	crashed      bool
+}
+
+type instanceCrashRecord struct {
+	At           time.Time `json:"at"`
+	InvocationID string    `json:"invocation_id,omitempty"`
+}
+
+// instanceCrashState is persisted per instance so crash loops are detected
+// across agent restarts.
+type instanceCrashState struct {
+	Settings   instanceCrashSettings `json:"settings"`
+	Crashes    []instanceCrashRecord `json:"crashes,omitempty"`
+	LoopSince  *time.Time            `json:"loop_since,omitempty"`
+	LoopReason string                `json:"loop_reason,omitempty"`
+	LastReport *instanceCrashReport  `json:"last_report,omitempty"`
+}
+
+// unitExitEvent is a "Main process exited" journal entry of a game server.
+type unitExitEvent struct {
+	InstanceID   string
+	ServiceName  string
+	InvocationID string
+	ExitCode     string
+	ExitStatus   string
+}
+
+// parseUnitExitEvent keeps failed exits of gs-* units; a clean exit is not
+// a crash.
+func parseUnitExitEvent(fields map[string]any) (unitExitEvent, bool) {
+	field := func(key string) string {
+		value, _ := fields[key].(string)
+		return strings.TrimSpace(value)
+	}
+	serviceName := strings.TrimSuffix(field("UNIT"), ".service")
+	instanceID := strings.TrimPrefix(serviceName, "gs-")
+	if !strings.HasPrefix(serviceName, "gs-") || !instanceScheduleIDPattern.MatchString(instanceID) {
+		return unitExitEvent{}, false
+	}
+	event := unitExitEvent{
+		InstanceID:   instanceID,
+		ServiceName:  serviceName,
+		InvocationID: field("INVOCATION_ID"),
+		ExitCode:     field("EXIT_CODE"),
+		ExitStatus:   field("EXIT_STATUS"),
+	}
+	if event.ExitCode == "exited" && event.ExitStatus == "0" {
+		return unitExitEvent{}, false
+	}
+	return event, true
+}
+
+// analyzeInstanceCrash reads the exit of the unit's last run. The journal
+// event carries the exit code and status; without one (watchdog, report
+// job) they come from systemctl show.
+func analyzeInstanceCrash(instanceID, serviceName string, settings instanceCrashSettings, event *unitExitEvent) instanceCrashReport {
+	report := instanceCrashReport{
+		InstanceID:  instanceID,
+		ServiceName: serviceName,
+		DetectedAt:  time.Now().UTC(),
+		ConsoleTail: []string{},
+		CrashFiles:  []instanceCrashFile{},
+	}
+	props := map[string]string{}
+	output, err := commandOutputRunner("systemctl", "show", serviceName+".service",
+		"-p", "Result", "-p", "ExecMainCode", "-p", "ExecMainStatus", "-p", "NRestarts",
+		"-p", "ControlGroup", "-p", "InvocationID", "-p", "ExecMainStartTimestamp")
+	if err != nil {
+		log.Printf("instance.crash: systemctl show %s: %v", serviceName, err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			props[key] = value
+		}
+	}
+
+	report.Result = props["Result"]
+	report.Restarts, _ = strconv.Atoi(props["NRestarts"])
+	exitCode, exitStatus := "", props["ExecMainStatus"]
+	switch props["ExecMainCode"] {
+	case "1":
+		exitCode = "exited"
+	case "2":
+		exitCode = "killed"
+	case "3":
+		exitCode = "dumped"
+	}
+	report.InvocationID = props["InvocationID"]
+	if event != nil {
+		report.InvocationID = firstNonEmpty(event.InvocationID, report.InvocationID)
+		exitCode, exitStatus = firstNonEmpty(event.ExitCode, exitCode), firstNonEmpty(event.ExitStatus, exitStatus)
+	}
+	report.crashed = event != nil || (report.Result != "" && report.Result != "success")
+
+	switch exitCode {
+	case "exited":
+		if code, err := strconv.Atoi(exitStatus); err == nil {
+			report.ExitCode = &code
+		}
+	case "killed", "dumped":
+		report.Signal = crashSignalName(exitStatus)
+	}
+	if controlGroup := props["ControlGroup"]; controlGroup != "" {
+		events := readCgroupEvents(filepath.Join(cgroupFSRoot, controlGroup, "memory.events"))
+		report.OOMKills = int(events["oom_kill"])
+	}
+	if report.Result == "oom-kill" && report.OOMKills == 0 {
+		report.OOMKills = 1
+	}
+
+	switch {
+	case report.OOMKills > 0:
+		report.Reason = "oom_kill"
+	case report.Result == "start-limit-hit":
+		report.Reason = "start_limit"
+	case exitCode == "dumped":
+		report.Reason = "core_dump"
+	case exitCode == "killed":
+		report.Reason = "signal"
+	case report.ExitCode != nil:
+		report.Reason = "exit_code"
+	default:
+		report.Reason = strings.ReplaceAll(firstNonEmpty(report.Result, "unknown"), "-", "_")
+	}
+
+	report.ConsoleTail = crashConsoleTail(instanceID, serviceName, settings.ConsoleLines)
+	if settings.InstanceDir != "" && len(settings.CrashFiles) > 0 {
+		var since time.Time
+		if started, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", props["ExecMainStartTimestamp"], time.Local); err == nil {
+			since = started
+		}
+		report.CrashFiles = collectCrashFiles(settings.InstanceDir, settings.CrashFiles, since)
+	}
+	return report
+}
+
+func crashSignalName(status string) string {
+	number, err := strconv.Atoi(status)
+	if err != nil {
+		return "SIG" + strings.TrimPrefix(strings.ToUpper(status), "SIG")
+	}
+	if name, ok := crashSignalNames[number]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", number)
+}
+
+// readCgroupEvents reads a flat keyed cgroup file such as memory.events. The
+// cgroup is gone once the unit stopped, which reads as no events.
+func readCgroupEvents(path string) map[string]int64 {
+	values := map[string]int64{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return values
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values
+}
+
+// crashConsoleTail returns the last console lines from the wrapper log and
+// falls back to the journal for units started without the wrapper.
+func crashConsoleTail(instanceID, serviceName string, lines int) []string {
+	if lines <= 0 {
+		lines = defaultCrashConsoleLines
+	}
+	if logPath := consoleLogFilePath(instanceID); logPath != "" {
+		if tail, err := tailFileLines(logPath, lines); err == nil && len(tail) > 0 {
+			return tail
+		}
+	}
+	output, err := commandOutputRunner("journalctl", "-u", serviceName, "-n", strconv.Itoa(lines), "--no-pager", "--output=cat")
+	if err != nil {
+		return []string{}
+	}
+	tail := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			tail = append(tail, line)
+		}
+	}
+	if len(tail) > lines {
+		tail = tail[len(tail)-lines:]
+	}
+	return tail
+}
+
+// tailFileLines reads at most the last maxCrashFileBytes of a file and
+// returns its last lines.
+func tailFileLines(path string, lines int) ([]string, error) {
+	content, _, err := readFileTail(path, maxCrashFileBytes)
+	if err != nil {
+		return nil, err
+	}
+	tail := []string{}
+	for _, line := range strings.Split(string(stripANSI([]byte(content))), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			tail = append(tail, line)
+		}
+	}
+	if len(tail) > lines {
+		tail = tail[len(tail)-lines:]
+	}
+	return tail, nil
+}
+
+func readFileTail(path string, limit int64) (string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	truncated := info.Size() > limit
+	if truncated {
+		if _, err := file.Seek(info.Size()-limit, io.SeekStart); err != nil {
+			return "", false, err
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(file, limit))
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), truncated, nil
+}
+
+// collectCrashFiles gathers the newest regular files matching the crash
+// patterns that were written since the crashed process started. Symlinks are
+// skipped so a pattern cannot reach outside the instance dir.
+func collectCrashFiles(instanceDir string, patterns []string, since time.Time) []instanceCrashFile {
+	type candidate struct {
+		path string
+		info os.FileInfo
+	}
+	seen := map[string]bool{}
+	var candidates []candidate
+	for _, pattern := range patterns {
+		glob := filepath.Join(instanceDir, filepath.Clean(strings.TrimSuffix(pattern, "/")))
+		if strings.HasSuffix(pattern, "/") {
+			glob = filepath.Join(glob, "*")
+		}
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			info, err := os.Lstat(match)
+			if err != nil || !info.Mode().IsRegular() || seen[match] || info.ModTime().Before(since) {
+				continue
+			}
+			seen[match] = true
+			candidates = append(candidates, candidate{path: match, info: info})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].info.ModTime().After(candidates[j].info.ModTime())
+	})
+	if len(candidates) > maxCrashFiles {
+		candidates = candidates[:maxCrashFiles]
+	}
+	files := make([]instanceCrashFile, 0, len(candidates))
+	for _, entry := range candidates {
+		content, truncated, err := readFileTail(entry.path, maxCrashFileBytes)
+		if err != nil {
+			continue
+		}
+		relative, _ := filepath.Rel(instanceDir, entry.path)
+		files = append(files, instanceCrashFile{
+			Path:      filepath.ToSlash(relative),
+			Size:      entry.info.Size(),
+			Modified:  entry.info.ModTime().UTC(),
+			Truncated: truncated,
+			Content:   content,
+		})
+	}
+	return files
+}
+
+// instanceCrashes records crash reports per instance and stops automatic
+// restarts once an instance crashes loop_threshold times within
+// loop_window_seconds. The loop holds until instance.crash.reset or a
+// reinstall clears it.
+type instanceCrashes struct {
+	dir    string
+	mu     sync.Mutex
+	now    func() time.Time
+	report func(run jobs.ScheduledRun)
+}
+
+func newInstanceCrashes(dir string) *instanceCrashes {
+	return &instanceCrashes{dir: dir, now: time.Now}
+}
+
+var globalInstanceCrashes = newInstanceCrashes(instanceCrashDir)
+
+func (c *instanceCrashes) path(instanceID string) string {
+	return filepath.Join(c.dir, instanceID+".json")
+}
+
+func (c *instanceCrashes) load(instanceID string) (instanceCrashState, error) {
+	state := instanceCrashState{Settings: defaultInstanceCrashSettings()}
+	if !instanceScheduleIDPattern.MatchString(instanceID) {
+		return state, fmt.Errorf("invalid instance_id: %s", instanceID)
+	}
+	data, err := os.ReadFile(c.path(instanceID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, fmt.Errorf("read crash state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("decode crash state: %w", err)
+	}
+	return state, nil
+}
+
+func (c *instanceCrashes) save(instanceID string, state instanceCrashState) error {
+	if err := os.MkdirAll(c.dir, 0o750); err != nil {
+		return fmt.Errorf("create crash state dir: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode crash state: %w", err)
+	}
+	return writeFileAtomic(c.path(instanceID), data, 0o640)
+}
+
+// Configure stores the crash settings of a payload; reset also clears the
+// crash history, as on create and reinstall.
+func (c *instanceCrashes) Configure(instanceID string, payload map[string]any, reset bool) (instanceCrashState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.load(instanceID)
+	if err != nil {
+		return state, err
+	}
+	if err := state.Settings.apply(payload); err != nil {
+		return state, err
+	}
+	if reset {
+		state.Crashes, state.LoopSince, state.LoopReason = nil, nil, ""
+	}
+	return state, c.save(instanceID, state)
+}
+
+// Record adds a crash to the history and reports whether it started a crash
+// loop. A crash already recorded for the same invocation is not counted twice.
+func (c *instanceCrashes) Record(report instanceCrashReport) (instanceCrashState, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, err := c.load(report.InstanceID)
+	if err != nil {
+		return state, false, err
+	}
+	state.LastReport = &report
+	duplicate := false
+	for _, crash := range state.Crashes {
+		if report.InvocationID != "" && crash.InvocationID == report.InvocationID {
+			duplicate = true
+		}
+	}
+	if !duplicate {
+		state.Crashes = append(state.Crashes, instanceCrashRecord{At: report.DetectedAt, InvocationID: report.InvocationID})
+	}
+	window := time.Duration(state.Settings.LoopWindowSec) * time.Second
+	cutoff := c.now().Add(-window)
+	recent := state.Crashes[:0]
+	for _, crash := range state.Crashes {
+		if crash.At.After(cutoff) {
+			recent = append(recent, crash)
+		}
+	}
+	state.Crashes = recent
+
+	started := false
+	if state.LoopSince == nil {
+		switch {
+		case report.Result == "start-limit-hit":
+			state.LoopReason = "systemd refused further starts after repeated failures (start-limit-hit)"
+		case len(state.Crashes) >= state.Settings.LoopThreshold:
+			state.LoopReason = fmt.Sprintf("crashed %d times within %s (last: %s)", len(state.Crashes), window, report.Reason)
+		}
+		if state.LoopReason != "" {
+			since := c.now().UTC()
+			state.LoopSince = &since
+			started = true
+		}
+	}
+	return state, started, c.save(report.InstanceID, state)
+}
+
+func (c *instanceCrashes) State(instanceID string) (instanceCrashState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load(instanceID)
+}
+
+func (c *instanceCrashes) Remove(instanceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Remove(c.path(instanceID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove crash state: %w", err)
+	}
+	return nil
+}
+
+// Inspect analyzes a unit that is not active, records the crash and stops
+// the unit's own Restart= cycle when a crash loop starts. It returns the
+// report outputs and the loop reason, empty while restarts are allowed.
+func (c *instanceCrashes) Inspect(instanceID, serviceName string, payload map[string]any, event *unitExitEvent) (map[string]string, string, error) {
+	state, err := c.Configure(instanceID, payload, false)
+	if err != nil {
+		return nil, "", err
+	}
+	report := analyzeInstanceCrash(instanceID, serviceName, state.Settings, event)
+	if !report.crashed {
+		return nil, state.LoopReason, nil
+	}
+	state, started, err := c.Record(report)
+	if err != nil {
+		return nil, "", err
+	}
+	if started {
+		log.Printf("instance.crash: crash loop for %s: %s", serviceName, state.LoopReason)
+		if err := runCommand("systemctl", "stop", serviceName); err != nil {
+			log.Printf("instance.crash: stop %s after crash loop: %v", serviceName, err)
+		}
+	}
+	return crashStateOutput(state), state.LoopReason, nil
+}
+
+// crashStateOutput flattens the last report into job result outputs; the
+// full bundle including crash file contents is in "report".
+func crashStateOutput(state instanceCrashState) map[string]string {
+	output := map[string]string{
+		"crash_count":       strconv.Itoa(len(state.Crashes)),
+		"crash_loop":        strconv.FormatBool(state.LoopSince != nil),
+		"crash_loop_reason": state.LoopReason,
+	}
+	if state.LoopSince != nil {
+		output["crash_loop_since"] = state.LoopSince.UTC().Format(time.RFC3339)
+	}
+	report := state.LastReport
+	if report == nil {
+		return output
+	}
+	encoded, _ := json.Marshal(report)
+	paths := make([]string, 0, len(report.CrashFiles))
+	for _, file := range report.CrashFiles {
+		paths = append(paths, file.Path)
+	}
+	output["instance_id"] = report.InstanceID
+	output["service_name"] = report.ServiceName
+	output["crash_reason"] = report.Reason
+	output["crash_result"] = report.Result
+	output["crash_signal"] = report.Signal
+	output["crash_oom_kills"] = strconv.Itoa(report.OOMKills)
+	output["crash_restarts"] = strconv.Itoa(report.Restarts)
+	output["crash_detected_at"] = report.DetectedAt.Format(time.RFC3339)
+	output["crash_console_tail"] = strings.Join(report.ConsoleTail, "\n")
+	output["crash_files"] = strings.Join(paths, ",")
+	output["crash_report"] = string(encoded)
+	if report.ExitCode != nil {
+		output["crash_exit_code"] = strconv.Itoa(*report.ExitCode)
+	}
+	return output
+}
+
+// Run follows the journal for failed exits of game server processes until
+// ctx ends, restarting journalctl if it dies.
+func (c *instanceCrashes) Run(ctx context.Context) {
+	if runtime.GOOS != "linux" || !commandExists("journalctl") {
+		return
+	}
+	for {
+		if err := c.follow(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("instance.crash: journal subscription ended: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(crashMonitorRetryDelay):
+		}
+	}
+}
+
+func (c *instanceCrashes) follow(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, "journalctl", "--follow", "--lines=0", "--output=json", "--no-pager", "_PID=1", "MESSAGE_ID="+unitProcessExitMessageID)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var fields map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			continue
+		}
+		if event, ok := parseUnitExitEvent(fields); ok {
+			c.handleExit(event)
+		}
+	}
+	return cmd.Wait()
+}
+
+// handleExit analyzes a failed exit and reports the crash report like a
+// scheduled run of instance.crash.report.
+func (c *instanceCrashes) handleExit(event unitExitEvent) {
+	started := c.now().UTC()
+	output, _, err := c.Inspect(event.InstanceID, event.ServiceName, map[string]any{}, &event)
+	if err != nil {
+		log.Printf("instance.crash: analyze %s: %v", event.ServiceName, err)
+		return
+	}
+	if output == nil || c.report == nil {
+		return
+	}
+	c.report(jobs.ScheduledRun{
+		ScheduleID: "crash-" + event.InstanceID,
+		JobID:      fmt.Sprintf("crash-%s-%d", event.InstanceID, started.UnixNano()),
+		JobType:    instanceCrashReportJobType,
+		Status:     "success",
+		Output:     output,
+		StartedAt:  started,
+		Completed:  c.now().UTC(),
+	})
+}
+
+// handleInstanceCrashReport returns the last crash report of an instance.
+// With analyze=true the unit's current exit state is inspected first.
+func handleInstanceCrashReport(job jobs.Job) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "instance_id")
+	if instanceID == "" {
+		return failureResult(job.ID, fmt.Errorf("missing required values: instance_id"))
+	}
+	serviceName := firstNonEmpty(payloadValue(job.Payload, "service_name"), "gs-"+instanceID)
+	var state instanceCrashState
+	var err error
+	if parsePayloadBool(payloadValue(job.Payload, "analyze"), false) {
+		if _, _, err = globalInstanceCrashes.Inspect(instanceID, serviceName, job.Payload, nil); err == nil {
+			state, err = globalInstanceCrashes.State(instanceID)
+		}
+	} else {
+		state, err = globalInstanceCrashes.Configure(instanceID, job.Payload, false)
+	}
+	if err != nil {
+		return failedResultWithErrorCode(job.ID, "INVALID_INPUT", err.Error())
+	}
+	output := crashStateOutput(state)
+	output["instance_id"] = instanceID
+	output["message"] = "no crash recorded"
+	if state.LastReport != nil {
+		output["message"] = fmt.Sprintf("last crash: %s", state.LastReport.Reason)
+	}
+	return jobs.Result{JobID: job.ID, Status: "success", Output: output, Completed: time.Now().UTC()}, nil
+}
+
+// handleInstanceCrashReset clears the crash history so the watchdog restarts
+// the instance again, typically after the cause of a crash loop was fixed.
+func handleInstanceCrashReset(job jobs.Job) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "instance_id")
+	if instanceID == "" {
+		return failureResult(job.ID, fmt.Errorf("missing required values: instance_id"))
+	}
+	if _, err := globalInstanceCrashes.Configure(instanceID, job.Payload, true); err != nil {
+		return failedResultWithErrorCode(job.ID, "INVALID_INPUT", err.Error())
+	}
+	return jobs.Result{
+		JobID:     job.ID,
+		Status:    "success",
+		Output:    map[string]string{"instance_id": instanceID, "crash_loop": "false", "message": "crash history cleared"},
+		Completed: time.Now().UTC(),
+	}, nil
+}
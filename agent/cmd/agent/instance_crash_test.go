@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestAnalyzeInstanceCrashBundlesExitConsoleAndCrashFiles(t *testing.T) {
+	originalRunner, originalCgroupRoot, originalRuntimeDir := commandOutputRunner, cgroupFSRoot, instanceRuntimeDir
+	t.Cleanup(func() {
+		commandOutputRunner, cgroupFSRoot, instanceRuntimeDir = originalRunner, originalCgroupRoot, originalRuntimeDir
+	})
+	cgroupFSRoot = t.TempDir()
+	instanceRuntimeDir = t.TempDir()
+	instanceDir := t.TempDir()
+	commandOutputRunner = func(name string, args ...string) (string, error) {
+		if name == "systemctl" && args[0] == "show" {
+			return "Result=signal\nExecMainCode=2\nExecMainStatus=9\nNRestarts=2\nControlGroup=/system.slice/gs-42.service\nInvocationID=abc\nExecMainStartTimestamp=\n", nil
+		}
+		return "", fmt.Errorf("unexpected command %s %v", name, args)
+	}
+
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(filepath.Join(cgroupFSRoot, "system.slice/gs-42.service/memory.events"), "low 0\nhigh 3\nmax 1\noom 1\noom_kill 1\n")
+	console := make([]string, 0, 30)
+	for i := 1; i <= 30; i++ {
+		console = append(console, fmt.Sprintf("line %d", i))
+	}
+	writeFile(consoleLogFilePath("42"), strings.Join(console, "\n")+"\n")
+	writeFile(filepath.Join(instanceDir, "crash-reports", "crash-2026-10-16.txt"), "java.lang.OutOfMemoryError")
+	writeFile(filepath.Join(instanceDir, "hs_err_pid123.log"), "# A fatal error has been detected")
+	writeFile(filepath.Join(instanceDir, "server.log"), "not a crash file")
+
+	settings := defaultInstanceCrashSettings()
+	if err := settings.apply(map[string]any{
+		"instance_dir":        instanceDir,
+		"crash_files":         []any{"crash-reports/", "hs_err_pid*.log"},
+		"crash_console_lines": "10",
+	}); err != nil {
+		t.Fatalf("apply settings: %v", err)
+	}
+	report := analyzeInstanceCrash("42", "gs-42", settings, nil)
+	if !report.crashed || report.Reason != "oom_kill" || report.Signal != "SIGKILL" || report.OOMKills != 1 || report.Restarts != 2 {
+		t.Fatalf("unexpected exit analysis: %#v", report)
+	}
+	if len(report.ConsoleTail) != 10 || report.ConsoleTail[9] != "line 30" {
+		t.Fatalf("expected the last 10 console lines, got %v", report.ConsoleTail)
+	}
+	files := map[string]string{}
+	for _, file := range report.CrashFiles {
+		files[file.Path] = file.Content
+	}
+	if len(files) != 2 || files["crash-reports/crash-2026-10-16.txt"] != "java.lang.OutOfMemoryError" || files["hs_err_pid123.log"] == "" {
+		t.Fatalf("unexpected crash files %#v", report.CrashFiles)
+	}
+
+	for _, pattern := range []string{"/etc/passwd", "../other/crash.log", "[bad"} {
+		bad := defaultInstanceCrashSettings()
+		if err := bad.apply(map[string]any{"crash_files": pattern}); err == nil {
+			t.Fatalf("expected crash_files %q to be rejected", pattern)
+		}
+	}
+	if _, ok := parseUnitExitEvent(map[string]any{"UNIT": "gs-42.service", "EXIT_CODE": "exited", "EXIT_STATUS": "0"}); ok {
+		t.Fatal("a clean exit must not count as a crash")
+	}
+	if event, ok := parseUnitExitEvent(map[string]any{"UNIT": "gs-42.service", "EXIT_CODE": "dumped", "EXIT_STATUS": "SEGV"}); !ok || event.InstanceID != "42" {
+		t.Fatalf("expected a crash event for instance 42, got %#v", event)
+	}
+}
+
+func TestInstanceCrashLoopStopsWatchdogRestarts(t *testing.T) {
+	originalRunner, originalCrashes, originalRuntimeDir := commandOutputRunner, globalInstanceCrashes, instanceRuntimeDir
+	t.Cleanup(func() {
+		commandOutputRunner, globalInstanceCrashes, instanceRuntimeDir = originalRunner, originalCrashes, originalRuntimeDir
+	})
+	instanceRuntimeDir = t.TempDir()
+	globalInstanceCrashes = newInstanceCrashes(t.TempDir())
+	invocation := 0
+	var commands []string
+	commandOutputRunner = func(name string, args ...string) (string, error) {
+		command := strings.Join(append([]string{name}, args...), " ")
+		commands = append(commands, command)
+		switch {
+		case strings.HasPrefix(command, "systemctl show"):
+			return fmt.Sprintf("Result=exit-code\nExecMainCode=1\nExecMainStatus=1\nInvocationID=run-%d\n", invocation), nil
+		case strings.HasPrefix(command, "systemctl is-active"):
+			return "failed", fmt.Errorf("inactive")
+		}
+		return "", nil
+	}
+	var reported []jobs.ScheduledRun
+	globalInstanceCrashes.report = func(run jobs.ScheduledRun) { reported = append(reported, run) }
+	if _, err := globalInstanceCrashes.Configure("42", map[string]any{"crash_loop_threshold": "3", "crash_loop_window_seconds": "600"}, true); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+
+	for invocation = 1; invocation <= 3; invocation++ {
+		globalInstanceCrashes.handleExit(unitExitEvent{InstanceID: "42", ServiceName: "gs-42", ExitCode: "exited", ExitStatus: "1"})
+	}
+	// The same invocation seen twice is not a new crash.
+	invocation = 3
+	globalInstanceCrashes.handleExit(unitExitEvent{InstanceID: "42", ServiceName: "gs-42", ExitCode: "exited", ExitStatus: "1"})
+	if len(reported) != 4 || reported[3].JobType != instanceCrashReportJobType || reported[3].Output["crash_count"] != "3" {
+		t.Fatalf("expected four crash reports counting three crashes, got %#v", reported)
+	}
+	if reported[1].Output["crash_loop"] != "false" || reported[2].Output["crash_loop"] != "true" || reported[2].Output["crash_exit_code"] != "1" {
+		t.Fatalf("expected the third crash to start the loop, got %#v", reported[2].Output)
+	}
+	stops := 0
+	for _, command := range commands {
+		if command == "systemctl stop gs-42" {
+			stops++
+		}
+	}
+	if stops != 1 {
+		t.Fatalf("expected one stop when the loop started, got %v", commands)
+	}
+
+	commands = nil
+	result, _ := handleInstanceWatchdogCheck(jobs.Job{ID: "watchdog-1", Payload: map[string]any{"instance_id": "42"}}, nil)
+	if result.Status != "failed" || result.Output["error_code"] != "CRASH_LOOP" || !strings.Contains(result.Output["message"], "crashed 3 times") {
+		t.Fatalf("expected the watchdog to refuse restarts, got %#v", result.Output)
+	}
+	for _, command := range commands {
+		if strings.HasPrefix(command, "systemctl restart") {
+			t.Fatalf("watchdog restarted a crash looping instance: %v", commands)
+		}
+	}
+
+	reset, _ := handleInstanceCrashReset(jobs.Job{ID: "reset-1", Payload: map[string]any{"instance_id": "42"}})
+	state, err := globalInstanceCrashes.State("42")
+	if reset.Status != "success" || err != nil || state.LoopSince != nil || len(state.Crashes) != 0 || state.Settings.LoopThreshold != 3 {
+		t.Fatalf("expected reset to clear the loop and keep settings, got %#v (%v)", state, err)
+	}
+}
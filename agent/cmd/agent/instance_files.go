@@ -28,7 +28,7 @@ func handleInstanceFilesList(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	relativePath := payloadValue(job.Payload, "path", "dir")
+	relativePath := payloadValue(job.Payload, "path")
 
 	target, err := sanitizeInstancePath(instanceDir, relativePath)
 	if err != nil {
@@ -81,8 +81,8 @@ func handleInstanceFileRead(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	relativePath := payloadValue(job.Payload, "path", "dir")
-	filename := payloadValue(job.Payload, "name", "file")
+	relativePath := payloadValue(job.Payload, "path")
+	filename := payloadValue(job.Payload, "name")
 
 	missing := missingValues([]requiredValue{
 		{key: "name", value: filename},
@@ -138,9 +138,9 @@ func handleInstanceFileWrite(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	relativePath := payloadValue(job.Payload, "path", "dir")
-	filename := payloadValue(job.Payload, "name", "file")
-	contentEncoded := payloadValue(job.Payload, "content_base64", "content")
+	relativePath := payloadValue(job.Payload, "path")
+	filename := payloadValue(job.Payload, "name")
+	contentEncoded := payloadValue(job.Payload, "content_base64")
 
 	missing := missingValues([]requiredValue{
 		{key: "name", value: filename},
@@ -207,8 +207,8 @@ func handleInstanceFileDelete(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	relativePath := payloadValue(job.Payload, "path", "dir")
-	filename := payloadValue(job.Payload, "name", "file")
+	relativePath := payloadValue(job.Payload, "path")
+	filename := payloadValue(job.Payload, "name")
 
 	missing := missingValues([]requiredValue{
 		{key: "name", value: filename},
@@ -266,8 +266,8 @@ func handleInstanceFileMkdir(job jobs.Job) (jobs.Result, func() error) {
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
-	relativePath := payloadValue(job.Payload, "path", "dir")
-	dirName := payloadValue(job.Payload, "name", "directory")
+	relativePath := payloadValue(job.Payload, "path")
+	dirName := payloadValue(job.Payload, "name")
 
 	missing := missingValues([]requiredValue{
 		{key: "name", value: dirName},
@@ -15,11 +15,13 @@ import (
 	"strings"
 	"time"
 
+	"easywi/agent/internal/gamequery"
 	"easywi/agent/internal/jobs"
 )
 
 const a2sQueryTimeout = 3 * time.Second
 const minecraftQueryTimeout = 4 * time.Second
+const gameQueryTimeout = 4 * time.Second
 
 const (
 	a2sHeaderSimple  int32 = -1
@@ -34,17 +36,17 @@ var queryA2SDebugEnabled = strings.EqualFold(strings.TrimSpace(os.Getenv("QUERY_
 var queryPayloadDebugEnabled = strings.EqualFold(strings.TrimSpace(os.Getenv("QUERY_PAYLOAD_DEBUG")), "1") || strings.EqualFold(strings.TrimSpace(os.Getenv("QUERY_PAYLOAD_DEBUG")), "true")
 
 func handleInstanceQueryCheck(job jobs.Job) (jobs.Result, func() error) {
-	queryType := strings.ToLower(payloadValue(job.Payload, "query_type", "protocol"))
+	queryType := strings.ToLower(payloadValue(job.Payload, "query_type"))
 	if queryPayloadDebugEnabled {
 		payloadJSON, _ := json.Marshal(job.Payload)
 		log.Printf("instance.query.check payload: job_id=%s payload=%s", job.ID, payloadJSON)
 	}
 	resolution := resolveQueryDialHost(
-		payloadValue(job.Payload, "host", "ip"),
-		payloadValue(job.Payload, "bind_ip", "query_bind_ip"),
+		payloadValue(job.Payload, "host"),
+		payloadValue(job.Payload, "bind_ip"),
 		payloadValue(job.Payload, "instance_ip"),
-		payloadValue(job.Payload, "node_ip", "public_ip"),
-		payloadValue(job.Payload, "local_only", "is_local_only"),
+		payloadValue(job.Payload, "node_ip"),
+		payloadValue(job.Payload, "local_only"),
 		payloadValue(job.Payload, "network_mode"),
 		payloadValue(job.Payload, "share_host_network"),
 	)
@@ -67,10 +69,10 @@ func handleInstanceQueryCheck(job jobs.Job) (jobs.Result, func() error) {
 			strings.Join(missing, ", "),
 			resolution.Source,
 			resolution.NetworkMode,
-			payloadValue(job.Payload, "host", "ip"),
-			payloadValue(job.Payload, "bind_ip", "query_bind_ip"),
+			payloadValue(job.Payload, "host"),
+			payloadValue(job.Payload, "bind_ip"),
 			payloadValue(job.Payload, "instance_ip"),
-			payloadValue(job.Payload, "node_ip", "public_ip"),
+			payloadValue(job.Payload, "node_ip"),
 		)
 		return failedResultWithErrorCode(job.ID, "INVALID_INPUT", message)
 	}
@@ -127,6 +129,10 @@ func handleInstanceQueryCheck(job jobs.Job) (jobs.Result, func() error) {
 			Output:    buildQueryOutput("online", "minecraft_bedrock", "", startedAt, result),
 			Completed: time.Now().UTC(),
 		}, nil
+	case "valheim":
+		startedAt := time.Now()
+		result, err := queryA2S(host, valheimQueryPort(gamePort, queryPort))
+		return serverStatusQueryResult(job.ID, "valheim", startedAt, result, err), nil
 	case "none", "":
 		return jobs.Result{
 			JobID:     job.ID,
@@ -135,6 +141,13 @@ func handleInstanceQueryCheck(job jobs.Job) (jobs.Result, func() error) {
 			Completed: time.Now().UTC(),
 		}, nil
 	default:
+		if engine, ok := gamequery.Lookup(queryType); ok {
+			startedAt := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), gameQueryTimeout)
+			defer cancel()
+			result, err := queryGameEngine(ctx, engine, host, port, queryPassword(job.Payload), payloadValue(job.Payload, "rcon_command"))
+			return serverStatusQueryResult(job.ID, engine.Name, startedAt, result, err), nil
+		}
 		return jobs.Result{
 			JobID:     job.ID,
 			Status:    "failed",
@@ -407,6 +420,44 @@ func dialWithFallback(baseNetwork, host, port string, timeout time.Duration) (ne
 	return nil, fmt.Errorf("dial %s failed after %d attempts (%s)", baseNetwork, len(candidates), strings.Join(errs, "; "))
 }
 
+// queryGameEngine runs one of the shared internal/gamequery engines with the
+// agent's dual-stack dialer.
+func queryGameEngine(ctx context.Context, engine gamequery.Engine, host, port, password, command string) (map[string]string, error) {
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum <= 0 || portNum > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	result, err := engine.Query(ctx, gamequery.Target{
+		Host:     host,
+		Port:     portNum,
+		Password: password,
+		Command:  command,
+		Timeout:  gameQueryTimeout,
+		Dial:     dialWithFallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Fields(), nil
+}
+
+// queryPassword returns the RCON password or API token of a query payload.
+func queryPassword(payload map[string]any) string {
+	return payloadValue(payload, "query_password")
+}
+
+// valheimQueryPort returns the A2S port of a Valheim server, which listens
+// on the game port plus one unless the template sets a query port.
+func valheimQueryPort(gamePort, queryPort string) string {
+	if queryPort != "" {
+		return queryPort
+	}
+	if port, err := strconv.Atoi(gamePort); err == nil && port > 0 && port < 65535 {
+		return strconv.Itoa(port + 1)
+	}
+	return gamePort
+}
+
 func queryA2S(host, port string) (map[string]string, error) {
 	portNum, err := strconv.Atoi(port)
 	if err != nil || portNum <= 0 || portNum > 65535 {
@@ -11,6 +11,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"easywi/agent/internal/gamequery"
 )
 
 type queryHTTPResponse struct {
@@ -119,6 +121,11 @@ func handleInstanceQueryHTTP(w http.ResponseWriter, r *http.Request) {
 		writeQueryEnvelope(w, http.StatusOK, queryHTTPResponse{OK: false, ErrorCode: "INVALID_PORT", Message: err.Error(), RequestID: requestID, Debug: &queryHTTPDebug{ResolvedHost: host, NetworkMode: resolution.NetworkMode, ChosenDialHostSource: resolution.Source, ResolvedHostSource: resolution.Source, LoopbackUsed: resolution.LoopbackUsed, ResolvedProtocol: protocol, LastErrorCode: "INVALID_PORT", LastErrorMessage: err.Error(), RequestID: requestID}})
 		return
 	}
+	if protocol == "valheim" && queryParamOrPayload(r, payload, "query_port") == "" {
+		if adjusted, err := strconv.Atoi(valheimQueryPort(strconv.Itoa(port), "")); err == nil {
+			port = adjusted
+		}
+	}
 
 	if host == "" {
 		writeQueryEnvelope(w, http.StatusUnprocessableEntity, queryHTTPResponse{OK: false, ErrorCode: "INVALID_INPUT", Message: "missing required values: host", RequestID: requestID, Debug: &queryHTTPDebug{ResolvedHost: host, NetworkMode: resolution.NetworkMode, ChosenDialHostSource: resolution.Source, ResolvedHostSource: resolution.Source, LoopbackUsed: resolution.LoopbackUsed, ResolvedProtocol: protocol, LastErrorCode: "INVALID_INPUT", LastErrorMessage: "missing required values: host", RequestID: requestID}})
@@ -152,7 +159,11 @@ func handleInstanceQueryHTTP(w http.ResponseWriter, r *http.Request) {
 	lockKey := "instance:" + instanceID
 	result := queryHTTPResponse{RequestID: requestID}
 	globalInstanceLocks.WithReadLock(lockKey, func() {
-		result = performProtocolQuery(ctx, protocol, host, port, requestID, debug)
+		if engine, ok := gamequery.Lookup(protocol); ok {
+			result = performEngineQuery(ctx, engine, host, port, queryValueFromKeys(r, payload, "query_password", "rcon_password", "api_token"), queryValueFromKeys(r, payload, "rcon_command"), requestID, debug)
+		} else {
+			result = performProtocolQuery(ctx, protocol, host, port, requestID, debug)
+		}
 		if result.Data != nil {
 			result.Data.LatencyMS = time.Since(started).Milliseconds()
 		}
@@ -260,6 +271,15 @@ func performProtocolQuery(ctx context.Context, protocol, host string, port int,
 			return queryHTTPResponse{OK: false, ErrorCode: code, Message: err.Error(), RequestID: requestID, Debug: debug}
 		}
 		return queryHTTPResponse{OK: true, Data: mapResultPayload("running", payload), RequestID: requestID, Debug: debug}
+	case "valheim":
+		payload, err := runWithContext(ctx, func() (map[string]string, error) { return queryA2S(host, portStr) })
+		if err != nil {
+			code := resolveQueryErrCode(err)
+			debug.LastErrorCode = code
+			debug.LastErrorMessage = err.Error()
+			return queryHTTPResponse{OK: false, ErrorCode: code, Message: err.Error(), RequestID: requestID, Debug: debug}
+		}
+		return queryHTTPResponse{OK: true, Data: mapResultPayload("running", payload), RequestID: requestID, Debug: debug}
 	case "custom":
 		debug.LastErrorCode = "UNSUPPORTED_PROTOCOL"
 		debug.LastErrorMessage = "custom protocol handler not implemented"
@@ -271,6 +291,19 @@ func performProtocolQuery(ctx context.Context, protocol, host string, port int,
 	}
 }
 
+// performEngineQuery runs a shared internal/gamequery engine for the query
+// route.
+func performEngineQuery(ctx context.Context, engine gamequery.Engine, host string, port int, password, command, requestID string, debug *queryHTTPDebug) queryHTTPResponse {
+	payload, err := queryGameEngine(ctx, engine, host, strconv.Itoa(port), password, command)
+	if err != nil {
+		code := resolveQueryErrCode(err)
+		debug.LastErrorCode = code
+		debug.LastErrorMessage = err.Error()
+		return queryHTTPResponse{OK: false, ErrorCode: code, Message: err.Error(), RequestID: requestID, Debug: debug}
+	}
+	return queryHTTPResponse{OK: true, Data: mapResultPayload("running", payload), RequestID: requestID, Debug: debug}
+}
+
 func runWithContext(ctx context.Context, fn func() (map[string]string, error)) (map[string]string, error) {
 	type result struct {
 		payload map[string]string
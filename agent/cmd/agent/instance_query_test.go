@@ -573,6 +573,42 @@ func TestHandleInstanceQueryCheckFallsBackToGamePort(t *testing.T) {
 	}
 }
 
+func TestHandleInstanceQueryCheckUsesSharedEngines(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		buffer := make([]byte, 512)
+		n, addr, err := server.ReadFrom(buffer)
+		if err != nil || !strings.Contains(string(buffer[:n]), "getstatus") {
+			return
+		}
+		_, _ = server.WriteTo([]byte("\xFF\xFF\xFF\xFFstatusResponse\n\\sv_hostname\\Arena\\mapname\\q3dm6\\sv_maxclients\\8\n0 20 \"Doom\"\n"), addr)
+	}()
+
+	result, _ := handleInstanceQueryCheck(jobs.Job{ID: "job-quake3", Payload: map[string]any{
+		"query_type":   "q3",
+		"host":         "127.0.0.1",
+		"query_port":   strconv.Itoa(server.LocalAddr().(*net.UDPAddr).Port),
+		"network_mode": "host",
+	}})
+	if result.Status != "success" || result.Output["status"] != "online" || result.Output["engine"] != "quake3" {
+		t.Fatalf("unexpected result %#v", result.Output)
+	}
+	if result.Output["players"] != "1" || result.Output["max_players"] != "8" || result.Output["map"] != "q3dm6" {
+		t.Fatalf("unexpected query data %#v", result.Output)
+	}
+
+	if port := valheimQueryPort("2456", ""); port != "2457" {
+		t.Fatalf("expected valheim to query game port + 1, got %s", port)
+	}
+	if port := valheimQueryPort("2456", "2460"); port != "2460" {
+		t.Fatalf("expected an explicit query port to win, got %s", port)
+	}
+}
+
 func TestHandleInstanceQueryCheckMissingHostReturnsInvalidInput(t *testing.T) {
 	job := jobs.Job{
 		ID: "job-missing-host",
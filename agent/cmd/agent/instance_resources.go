@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	instanceResourcesApplyJobType = "instance.resources.apply"
+	maxSystemdWeight              = 10000
+	// defaultIPCommand is where iproute2 installs ip on the supported
+	// distributions.
+	defaultIPCommand = "/usr/sbin/ip"
+)
+
+var (
+	allowedCPUsPattern   = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+	ioBandwidthPattern   = regexp.MustCompile(`^[0-9]+[KMGT]?$`)
+	interfaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,15}$`)
+)
+
+var (
+	// instanceNetnsUnitDir holds the companion units that set up instance
+	// network namespaces.
+	instanceNetnsUnitDir = "/etc/systemd/system"
+	// systemdControlDir is where systemctl set-property keeps its drop-ins.
+	systemdControlDir = "/etc/systemd/system.control"
+)
+
+// instanceResourceProfile is the cgroup and network setup of a game server
+// unit. CPUQuota and MemoryMaxMB come from the instance's cpu_limit and
+// ram_limit, everything else from the template's resource_profile.
+type instanceResourceProfile struct {
+	CPUQuota            int
+	CPUWeight           int
+	AllowedCPUs         string
+	MemoryMaxMB         int
+	MemoryHighMB        int
+	IOWeight            int
+	IOReadBandwidthMax  string
+	IOWriteBandwidthMax string
+	TasksMax            int
+	Network             *instanceNetwork
+}
+
+// instanceNetwork binds an instance to its own address: the unit runs in a
+// network namespace whose only uplink is an ipvlan interface carrying Address.
+// ipvlan slaves cannot talk to their parent, so the node itself, including
+// status queries run by the agent, cannot reach Address; it is only
+// reachable from other hosts.
+type instanceNetwork struct {
+	Address string
+	Gateway string
+	Parent  string
+	// IPCommand is the absolute path of ip used in the namespace unit.
+	IPCommand string
+}
+
+// parseInstanceResourceProfile reads resource_profile from the payload:
+// cpu_weight, allowed_cpus, memory_high_mb, io_weight,
+// io_read_bandwidth_max, io_write_bandwidth_max, tasks_max and network
+// (address, gateway, parent_interface).
+func parseInstanceResourceProfile(payload map[string]any, cpuLimit, ramLimit int) (instanceResourceProfile, error) {
+	settings := payloadMap(payload, "resource_profile")
+	profile := instanceResourceProfile{CPUQuota: cpuLimit, MemoryMaxMB: ramLimit}
+	var err error
+	if profile.CPUWeight, err = parseProfileInt(settings, 1, maxSystemdWeight, "cpu_weight"); err != nil {
+		return instanceResourceProfile{}, err
+	}
+	if profile.IOWeight, err = parseProfileInt(settings, 1, maxSystemdWeight, "io_weight"); err != nil {
+		return instanceResourceProfile{}, err
+	}
+	if profile.TasksMax, err = parseProfileInt(settings, 1, 0, "tasks_max"); err != nil {
+		return instanceResourceProfile{}, err
+	}
+	if profile.MemoryHighMB, err = parseProfileInt(settings, 1, 0, "memory_high_mb", "memory_high"); err != nil {
+		return instanceResourceProfile{}, err
+	}
+	if profile.MemoryHighMB > 0 && profile.MemoryMaxMB > 0 && profile.MemoryHighMB >= profile.MemoryMaxMB {
+		return instanceResourceProfile{}, fmt.Errorf("resource_profile.memory_high_mb must be below ram_limit")
+	}
+	profile.AllowedCPUs = strings.ReplaceAll(payloadValue(settings, "allowed_cpus", "cpuset"), " ", "")
+	if profile.AllowedCPUs != "" && !allowedCPUsPattern.MatchString(profile.AllowedCPUs) {
+		return instanceResourceProfile{}, fmt.Errorf("invalid resource_profile.allowed_cpus: %s", profile.AllowedCPUs)
+	}
+	for _, field := range []struct {
+		key    string
+		target *string
+	}{
+		{key: "io_read_bandwidth_max", target: &profile.IOReadBandwidthMax},
+		{key: "io_write_bandwidth_max", target: &profile.IOWriteBandwidthMax},
+	} {
+		value := strings.ToUpper(payloadValue(settings, field.key))
+		if value != "" && !ioBandwidthPattern.MatchString(value) {
+			return instanceResourceProfile{}, fmt.Errorf("invalid resource_profile.%s: %s (bytes per second, optionally with K, M, G or T)", field.key, value)
+		}
+		*field.target = value
+	}
+	if profile.Network, err = parseInstanceNetwork(payloadMap(settings, "network")); err != nil {
+		return instanceResourceProfile{}, err
+	}
+	return profile, nil
+}
+
+func parseProfileInt(settings map[string]any, minValue, maxValue int, keys ...string) (int, error) {
+	raw := payloadValue(settings, keys...)
+	if raw == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed < minValue || (maxValue > 0 && parsed > maxValue) {
+		if maxValue > 0 {
+			return 0, fmt.Errorf("resource_profile.%s must be between %d and %d", keys[0], minValue, maxValue)
+		}
+		return 0, fmt.Errorf("resource_profile.%s must be a positive integer", keys[0])
+	}
+	return parsed, nil
+}
+
+func parseInstanceNetwork(settings map[string]any) (*instanceNetwork, error) {
+	address := payloadValue(settings, "address", "ip", "bind_ip")
+	if address == "" {
+		return nil, nil
+	}
+	if !strings.Contains(address, "/") {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid resource_profile.network.address: %s", address)
+		}
+		if ip.To4() != nil {
+			address += "/32"
+		} else {
+			address += "/128"
+		}
+	}
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return nil, fmt.Errorf("invalid resource_profile.network.address: %s", address)
+	}
+	network := &instanceNetwork{
+		Address: address,
+		Gateway: payloadValue(settings, "gateway"),
+		Parent:  payloadValue(settings, "parent_interface", "parent"),
+	}
+	if network.Gateway != "" && net.ParseIP(network.Gateway) == nil {
+		return nil, fmt.Errorf("invalid resource_profile.network.gateway: %s", network.Gateway)
+	}
+	if network.Parent != "" && !interfaceNamePattern.MatchString(network.Parent) {
+		return nil, fmt.Errorf("invalid resource_profile.network.parent_interface: %s", network.Parent)
+	}
+	return network, nil
+}
+
+// properties returns the cgroup settings in unit file order. Unset settings
+// have an empty value. Bandwidth limits apply to the block device backing
+// ioDevice and are left out without one.
+func (p instanceResourceProfile) properties(ioDevice string) [][2]string {
+	positive := func(value int, format string) string {
+		if value <= 0 {
+			return ""
+		}
+		return fmt.Sprintf(format, value)
+	}
+	bandwidth := func(rate string) string {
+		if rate == "" || ioDevice == "" {
+			return ""
+		}
+		return ioDevice + " " + rate
+	}
+	swap := ""
+	if p.MemoryMaxMB > 0 {
+		swap = "0"
+	}
+	return [][2]string{
+		{"CPUQuota", positive(p.CPUQuota, "%d%%")},
+		{"MemoryMax", positive(p.MemoryMaxMB, "%dM")},
+		{"MemorySwapMax", swap},
+		{"MemoryHigh", positive(p.MemoryHighMB, "%dM")},
+		{"CPUWeight", positive(p.CPUWeight, "%d")},
+		{"AllowedCPUs", p.AllowedCPUs},
+		{"IOWeight", positive(p.IOWeight, "%d")},
+		{"IOReadBandwidthMax", bandwidth(p.IOReadBandwidthMax)},
+		{"IOWriteBandwidthMax", bandwidth(p.IOWriteBandwidthMax)},
+		{"TasksMax", positive(p.TasksMax, "%d")},
+	}
+}
+
+// directives renders the settings that are set as unit file lines.
+func (p instanceResourceProfile) directives(ioDevice string) []string {
+	lines := []string{}
+	for _, property := range p.properties(ioDevice) {
+		if property[1] != "" {
+			lines = append(lines, property[0]+"="+property[1])
+		}
+	}
+	return lines
+}
+
+// liveAssignments renders every setting for systemctl set-property. Unset
+// settings are sent as empty assignments, which reset them to the default.
+func (p instanceResourceProfile) liveAssignments(ioDevice string) []string {
+	properties := p.properties(ioDevice)
+	assignments := make([]string, 0, len(properties))
+	for _, property := range properties {
+		assignments = append(assignments, property[0]+"="+property[1])
+	}
+	return assignments
+}
+
+func instanceNetnsName(serviceName string) string {
+	return "easywi-" + serviceName
+}
+
+func instanceNetnsUnitName(serviceName string) string {
+	return "easywi-netns-" + serviceName + ".service"
+}
+
+// instanceNetnsLink names the ipvlan interface. Interface names are limited
+// to 15 bytes, so it is derived from a hash of the service name.
+func instanceNetnsLink(serviceName string) string {
+	sum := sha256.Sum256([]byte(serviceName))
+	return "ewi" + hex.EncodeToString(sum[:5])
+}
+
+// instanceNetnsUnitLines returns the [Unit] dependencies that pull in the
+// companion unit creating the instance's namespace, and the [Service] line
+// that makes the game server run in it.
+func instanceNetnsUnitLines(serviceName string) (unitDeps, serviceLine string) {
+	netnsUnit := instanceNetnsUnitName(serviceName)
+	unitDeps = fmt.Sprintf("After=network.target %s\nRequires=%s", netnsUnit, netnsUnit)
+	serviceLine = fmt.Sprintf("NetworkNamespacePath=/run/netns/%s\n", instanceNetnsName(serviceName))
+	return unitDeps, serviceLine
+}
+
+// instanceNetnsUnitTemplate renders the oneshot unit that builds the
+// namespace on every start, so it also comes back after a reboot. ipvlan
+// shares the parent's MAC address; the host itself cannot reach the address.
+func instanceNetnsUnitTemplate(serviceName string, network instanceNetwork) string {
+	netns := instanceNetnsName(serviceName)
+	link := instanceNetnsLink(serviceName)
+	ip := network.IPCommand
+	if ip == "" {
+		ip = defaultIPCommand
+	}
+	steps := []string{
+		fmt.Sprintf("-%s netns delete %s", ip, netns),
+		fmt.Sprintf("%s netns add %s", ip, netns),
+		fmt.Sprintf("%s link add %s link %s type ipvlan mode l2", ip, link, network.Parent),
+		fmt.Sprintf("%s link set %s netns %s", ip, link, netns),
+		fmt.Sprintf("%s -n %s link set lo up", ip, netns),
+		fmt.Sprintf("%s -n %s addr add %s dev %s", ip, netns, network.Address, link),
+		fmt.Sprintf("%s -n %s link set %s up", ip, netns, link),
+	}
+	if network.Gateway != "" {
+		steps = append(steps, fmt.Sprintf("%s -n %s route add default via %s dev %s", ip, netns, network.Gateway, link))
+	}
+	execLines := make([]string, 0, len(steps))
+	for _, step := range steps {
+		execLines = append(execLines, "ExecStart="+step)
+	}
+	return fmt.Sprintf(`[Unit]
+Description=Easy-Wi network namespace for %s
+After=network-online.target
+Wants=network-online.target
+StopWhenUnneeded=true
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+%s
+ExecStop=%s netns delete %s
+
+[Install]
+WantedBy=multi-user.target
+`, serviceName, strings.Join(execLines, "\n"), ip, netns)
+}
+
+// instanceIPCommand returns the absolute path of ip for the namespace unit.
+// Older systemd versions reject relative ExecStart commands and newer ones
+// only search a fixed path, so the unit never relies on the lookup.
+func instanceIPCommand() string {
+	if path, err := lookupCommand("ip"); err == nil && filepath.IsAbs(path) {
+		return path
+	}
+	return defaultIPCommand
+}
+
+// resolveInstanceNetwork fills in the ip command, and the parent interface
+// and gateway from the host's default route when the profile leaves them out.
+func resolveInstanceNetwork(network instanceNetwork) (instanceNetwork, error) {
+	network.IPCommand = instanceIPCommand()
+	if network.Parent != "" && network.Gateway != "" {
+		return network, nil
+	}
+	family := "-4"
+	if ip, _, _ := net.ParseCIDR(network.Address); ip != nil && ip.To4() == nil {
+		family = "-6"
+	}
+	output, err := commandOutputRunner("ip", family, "-o", "route", "show", "default")
+	if err != nil {
+		return network, fmt.Errorf("read default route: %w", err)
+	}
+	routeDev, routeGateway := "", ""
+	fields := strings.Fields(output)
+	for idx := 0; idx+1 < len(fields); idx++ {
+		switch fields[idx] {
+		case "via":
+			routeGateway = fields[idx+1]
+		case "dev":
+			routeDev = fields[idx+1]
+		}
+	}
+	if network.Parent == "" {
+		network.Parent = routeDev
+	}
+	if network.Gateway == "" && network.Parent == routeDev {
+		network.Gateway = routeGateway
+	}
+	if network.Parent == "" {
+		return network, fmt.Errorf("resource_profile.network.parent_interface is required: no default route")
+	}
+	return network, nil
+}
+
+// applyInstanceNetwork writes or removes the namespace unit of the instance
+// and reports whether it changed.
+func applyInstanceNetwork(serviceName string, network *instanceNetwork) (bool, error) {
+	unitPath := filepath.Join(instanceNetnsUnitDir, instanceNetnsUnitName(serviceName))
+	current, err := os.ReadFile(unitPath)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("read network namespace unit: %w", err)
+	}
+	if network == nil {
+		if current == nil {
+			return false, nil
+		}
+		return true, removeInstanceNetwork(serviceName)
+	}
+	resolved, err := resolveInstanceNetwork(*network)
+	if err != nil {
+		return false, err
+	}
+	content := instanceNetnsUnitTemplate(serviceName, resolved)
+	if string(current) == content {
+		return false, nil
+	}
+	if err := os.WriteFile(unitPath, []byte(content), instanceFileMode); err != nil {
+		return false, fmt.Errorf("write network namespace unit: %w", err)
+	}
+	return true, nil
+}
+
+// removeInstanceNetwork stops and deletes the namespace unit of the instance.
+func removeInstanceNetwork(serviceName string) error {
+	unitName := instanceNetnsUnitName(serviceName)
+	unitPath := filepath.Join(instanceNetnsUnitDir, unitName)
+	if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+		return nil
+	}
+	if err := runCommand("systemctl", "stop", unitName); err != nil {
+		log.Printf("instance.network: stop %s (best-effort): %v", unitName, err)
+	}
+	if err := os.Remove(unitPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove network namespace unit: %w", err)
+	}
+	return nil
+}
+
+// clearLiveResourceOverrides drops the set-property drop-ins of a unit, so a
+// freshly written unit file is authoritative again.
+func clearLiveResourceOverrides(serviceName string) error {
+	if err := os.RemoveAll(filepath.Join(systemdControlDir, serviceName+".service.d")); err != nil {
+		return fmt.Errorf("clear resource overrides: %w", err)
+	}
+	return nil
+}
+
+// writeInstanceUnit writes the unit and namespace unit of a game server,
+// clears live overrides and reloads systemd. A changed namespace is rebuilt
+// right away if it is running, which restarts the instance with it.
+func writeInstanceUnit(serviceName, unitContent string, resources instanceResourceProfile) error {
+	networkChanged, err := applyInstanceNetwork(serviceName, resources.Network)
+	if err != nil {
+		return err
+	}
+	unitPath := filepath.Join("/etc/systemd/system", fmt.Sprintf("%s.service", serviceName))
+	if err := os.WriteFile(unitPath, []byte(unitContent), instanceFileMode); err != nil {
+		return fmt.Errorf("write systemd unit: %w", err)
+	}
+	if err := clearLiveResourceOverrides(serviceName); err != nil {
+		return err
+	}
+	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if networkChanged && resources.Network != nil {
+		if err := runCommand("systemctl", "try-restart", instanceNetnsUnitName(serviceName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleInstanceResourcesApply changes the cgroup limits of a running
+// instance with systemctl set-property, without a reinstall. The settings
+// persist as drop-ins until the next create or reinstall writes the unit.
+// Network namespaces are not cgroup settings and only change on reinstall.
+func handleInstanceResourcesApply(job jobs.Job) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "instance_id")
+	serviceName := payloadValue(job.Payload, "service_name")
+	cpuLimitValue := payloadValue(job.Payload, "cpu_limit")
+	ramLimitValue := payloadValue(job.Payload, "ram_limit")
+	if serviceName == "" && instanceID != "" {
+		serviceName = fmt.Sprintf("gs-%s", instanceID)
+	}
+	missing := missingValues([]requiredValue{
+		{key: "instance_id", value: firstNonEmpty(instanceID, serviceName)},
+		{key: "cpu_limit", value: cpuLimitValue},
+		{key: "ram_limit", value: ramLimitValue},
+	})
+	if len(missing) > 0 {
+		return failureResult(job.ID, fmt.Errorf("missing required values: %s", strings.Join(missing, ", ")))
+	}
+	cpuLimit, err := parsePositiveInt(cpuLimitValue, "cpu_limit")
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	ramLimit, err := parsePositiveInt(ramLimitValue, "ram_limit")
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	resources, err := parseInstanceResourceProfile(job.Payload, cpuLimit, ramLimit)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	ioDevice := ""
+	if resources.IOReadBandwidthMax != "" || resources.IOWriteBandwidthMax != "" {
+		if ioDevice, err = resolveInstanceDir(job.Payload); err != nil {
+			return failureResult(job.ID, err)
+		}
+	}
+	assignments := resources.liveAssignments(ioDevice)
+	args := append([]string{"set-property", serviceName + ".service"}, assignments...)
+	if err := runCommand("systemctl", args...); err != nil {
+		return failureResult(job.ID, err)
+	}
+	return jobs.Result{
+		JobID:  job.ID,
+		Status: "success",
+		Output: map[string]string{
+			"service_name": serviceName,
+			"properties":   strings.Join(assignments, "\n"),
+		},
+		Completed: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestSystemdUnitTemplateWithResourcesRendersProfile(t *testing.T) {
+	resources, err := parseInstanceResourceProfile(map[string]any{
+		"resource_profile": map[string]any{
+			"cpu_weight":            "200",
+			"allowed_cpus":          "2-3, 6",
+			"memory_high_mb":        "1536",
+			"io_weight":             "50",
+			"io_read_bandwidth_max": "50m",
+			"tasks_max":             "256",
+			"network": map[string]any{
+				"address":          "203.0.113.10/24",
+				"gateway":          "203.0.113.1",
+				"parent_interface": "eth0",
+			},
+		},
+	}, 200, 2048)
+	if err != nil {
+		t.Fatalf("parse profile: %v", err)
+	}
+
+	unit := systemdUnitTemplateWithResources("gs-42", "easywi", "/srv/easywi/instances/42", "/srv/easywi/instances/42", "/srv/easywi/instances/42/start.sh", "", resources)
+	for _, line := range []string{
+		"CPUQuota=200%",
+		"MemoryMax=2048M",
+		"MemoryHigh=1536M",
+		"CPUWeight=200",
+		"AllowedCPUs=2-3,6",
+		"IOWeight=50",
+		"IOReadBandwidthMax=/srv/easywi/instances/42 50M",
+		"TasksMax=256",
+		"NetworkNamespacePath=/run/netns/easywi-gs-42",
+		"Requires=easywi-netns-gs-42.service",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Fatalf("expected %q in unit:\n%s", line, unit)
+		}
+	}
+	if strings.Contains(unit, "IOWriteBandwidthMax") {
+		t.Fatalf("unset write bandwidth rendered:\n%s", unit)
+	}
+
+	netnsUnit := instanceNetnsUnitTemplate("gs-42", *resources.Network)
+	link := instanceNetnsLink("gs-42")
+	if len(link) > 15 {
+		t.Fatalf("interface name %q exceeds 15 bytes", link)
+	}
+	for _, line := range []string{
+		"ExecStart=/usr/sbin/ip link add " + link + " link eth0 type ipvlan mode l2",
+		"ExecStart=/usr/sbin/ip -n easywi-gs-42 addr add 203.0.113.10/24 dev " + link,
+		"ExecStart=/usr/sbin/ip -n easywi-gs-42 route add default via 203.0.113.1 dev " + link,
+	} {
+		if !strings.Contains(netnsUnit, line+"\n") {
+			t.Fatalf("expected %q in namespace unit:\n%s", line, netnsUnit)
+		}
+	}
+
+	for _, profile := range []map[string]any{
+		{"cpu_weight": "0"},
+		{"allowed_cpus": "0-"},
+		{"memory_high_mb": "4096"},
+		{"io_read_bandwidth_max": "fast"},
+		{"network": map[string]any{"address": "not-an-ip"}},
+	} {
+		if _, err := parseInstanceResourceProfile(map[string]any{"resource_profile": profile}, 100, 2048); err == nil {
+			t.Fatalf("expected %v to be rejected", profile)
+		}
+	}
+}
+
+func TestHandleInstanceResourcesApplySetsPropertiesLive(t *testing.T) {
+	var calls [][]string
+	originalRunner := commandOutputRunner
+	commandOutputRunner = func(name string, args ...string) (string, error) {
+		calls = append(calls, append([]string{name}, args...))
+		return "", nil
+	}
+	t.Cleanup(func() { commandOutputRunner = originalRunner })
+
+	result, _ := handleInstanceResourcesApply(jobs.Job{ID: "job-1", Payload: map[string]any{
+		"instance_id": "42",
+		"cpu_limit":   "150",
+		"ram_limit":   "1024",
+		"resource_profile": map[string]any{
+			"tasks_max":  "128",
+			"cpu_weight": "50",
+		},
+	}})
+	if result.Status != "success" {
+		t.Fatalf("expected success, got %s: %v", result.Status, result.Output)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected one systemctl call, got %v", calls)
+	}
+	command := strings.Join(calls[0], " ")
+	for _, part := range []string{
+		"systemctl set-property gs-42.service",
+		"CPUQuota=150%",
+		"MemoryMax=1024M",
+		"CPUWeight=50",
+		"TasksMax=128",
+		"MemoryHigh= ",
+		"AllowedCPUs= ",
+	} {
+		if !strings.Contains(command+" ", part) {
+			t.Fatalf("expected %q in %q", part, command)
+		}
+	}
+}
+
+func TestApplyInstanceNetworkUsesAbsoluteIPCommand(t *testing.T) {
+	originalDir, originalLookup := instanceNetnsUnitDir, lookupCommand
+	t.Cleanup(func() { instanceNetnsUnitDir, lookupCommand = originalDir, originalLookup })
+	instanceNetnsUnitDir = t.TempDir()
+	network := &instanceNetwork{Address: "203.0.113.10/32", Gateway: "203.0.113.1", Parent: "eth0"}
+	unitPath := filepath.Join(instanceNetnsUnitDir, instanceNetnsUnitName("gs-42"))
+
+	for _, tc := range []struct {
+		lookup string
+		want   string
+	}{
+		{lookup: "/usr/bin/ip", want: "/usr/bin/ip"},
+		{lookup: "ip", want: defaultIPCommand},
+	} {
+		lookup := tc.lookup
+		lookupCommand = func(string) (string, error) { return lookup, nil }
+		if _, err := applyInstanceNetwork("gs-42", network); err != nil {
+			t.Fatalf("apply network: %v", err)
+		}
+		unit, err := os.ReadFile(unitPath)
+		if err != nil {
+			t.Fatalf("read unit: %v", err)
+		}
+		for _, line := range []string{
+			"ExecStart=" + tc.want + " netns add easywi-gs-42",
+			"ExecStop=" + tc.want + " netns delete easywi-gs-42",
+		} {
+			if !strings.Contains(string(unit), line+"\n") {
+				t.Fatalf("expected %q for lookup %q in unit:\n%s", line, tc.lookup, unit)
+			}
+		}
+	}
+}
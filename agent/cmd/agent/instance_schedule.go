@@ -0,0 +1,743 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/logging"
+	"easywi/agent/internal/trace"
+)
+
+const (
+	instanceTaskRunJobType        = "instance.task.run"
+	instanceScheduleSyncJobType   = "instance.schedule.sync"
+	instanceScheduleDeleteJobType = "instance.schedule.delete"
+	instanceScheduleListJobType   = "instance.schedule.list"
+
+	instanceTaskStepCommand   = "command"
+	instanceTaskStepWait      = "wait"
+	instanceTaskStepBackup    = "backup"
+	instanceTaskStepRestart   = "restart"
+	instanceTaskStepStart     = "start"
+	instanceTaskStepStop      = "stop"
+	instanceTaskStepCondition = "condition"
+
+	instanceTaskStatusWaiting = "waiting"
+
+	maxInstanceTaskSteps = 50
+	maxInstanceTaskWait  = 6 * time.Hour
+)
+
+var instanceScheduleDir = "/etc/easywi/instance_schedules"
+
+// instanceScheduleIDPattern keeps instance IDs usable as schedule file names.
+var instanceScheduleIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// instanceTaskActions run the steps that map onto an existing instance job.
+var instanceTaskActions = map[string]func(ctx context.Context, job jobs.Job) (jobs.Result, func() error){
+	instanceTaskStepCommand: func(_ context.Context, job jobs.Job) (jobs.Result, func() error) {
+		return handleInstanceConsoleCommand(job, nil)
+	},
+	instanceTaskStepBackup: handleInstanceBackupCreate,
+	instanceTaskStepRestart: func(_ context.Context, job jobs.Job) (jobs.Result, func() error) {
+		return handleInstanceRestart(job, nil)
+	},
+	instanceTaskStepStart: func(_ context.Context, job jobs.Job) (jobs.Result, func() error) {
+		return handleInstanceStart(job, nil)
+	},
+	instanceTaskStepStop: func(_ context.Context, job jobs.Job) (jobs.Result, func() error) { return handleInstanceStop(job, nil) },
+}
+
+// instanceTaskStepJobTypes maps steps onto the job type whose handler runs
+// them, so step payloads are read against that job type's schema.
+var instanceTaskStepJobTypes = map[string]string{
+	instanceTaskStepCommand:   "instance.console.command",
+	instanceTaskStepBackup:    "instance.backup.create",
+	instanceTaskStepRestart:   "instance.restart",
+	instanceTaskStepStart:     "instance.start",
+	instanceTaskStepStop:      "instance.stop",
+	instanceTaskStepCondition: "instance.query.check",
+}
+
+// instanceTaskQueryFn reads the player count for condition steps.
+var instanceTaskQueryFn = handleInstanceQueryCheck
+
+// instanceTaskStep is one step of a chained instance task. Payload is merged
+// over the task payload for the step's job, e.g. backup target settings.
+type instanceTaskStep struct {
+	Type       string         `json:"type"`
+	Command    string         `json:"command,omitempty"`
+	Seconds    int            `json:"seconds,omitempty"`
+	PlayersMin *int           `json:"players_min,omitempty"`
+	PlayersMax *int           `json:"players_max,omitempty"`
+	Payload    map[string]any `json:"payload,omitempty"`
+}
+
+// parseInstanceTaskSteps reads steps as a JSON string or a decoded list.
+func parseInstanceTaskSteps(value any) ([]instanceTaskStep, error) {
+	var raw []byte
+	switch typed := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("missing steps")
+	case string:
+		raw = []byte(typed)
+	default:
+		encoded, err := json.Marshal(typed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid steps: %w", err)
+		}
+		raw = encoded
+	}
+	var steps []instanceTaskStep
+	if err := json.Unmarshal(raw, &steps); err != nil {
+		return nil, fmt.Errorf("invalid steps: %w", err)
+	}
+	if len(steps) == 0 || len(steps) > maxInstanceTaskSteps {
+		return nil, fmt.Errorf("a task needs between 1 and %d steps", maxInstanceTaskSteps)
+	}
+	for idx := range steps {
+		step := &steps[idx]
+		step.Type = strings.ToLower(strings.TrimSpace(step.Type))
+		switch step.Type {
+		case instanceTaskStepCommand:
+			clean, err := sanitizeConsoleCommand(step.Command)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", idx+1, err)
+			}
+			step.Command = clean
+		case instanceTaskStepWait:
+			if step.Seconds <= 0 || time.Duration(step.Seconds)*time.Second > maxInstanceTaskWait {
+				return nil, fmt.Errorf("step %d: wait seconds must be between 1 and %d", idx+1, int(maxInstanceTaskWait.Seconds()))
+			}
+		case instanceTaskStepCondition:
+			if step.PlayersMin == nil && step.PlayersMax == nil {
+				return nil, fmt.Errorf("step %d: condition needs players_min or players_max", idx+1)
+			}
+		case instanceTaskStepBackup, instanceTaskStepRestart, instanceTaskStepStart, instanceTaskStepStop:
+		default:
+			return nil, fmt.Errorf("step %d: unsupported step type %q", idx+1, step.Type)
+		}
+	}
+	return steps, nil
+}
+
+// handleInstanceTaskRun runs the steps of an instance task in order. A
+// failed step fails the task; an unmet condition ends it successfully
+// without running the remaining steps. A wait hands the remaining steps to a
+// resume job and reports the task as waiting.
+func handleInstanceTaskRun(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "instance_id")
+	if instanceID == "" {
+		return failureResult(job.ID, fmt.Errorf("missing required values: instance_id"))
+	}
+	steps, err := parseInstanceTaskSteps(job.Payload["steps"])
+	if err != nil {
+		return failedResultWithErrorCode(job.ID, "INVALID_INPUT", err.Error())
+	}
+	offset, _ := strconv.Atoi(payloadValue(job.Payload, "step_offset"))
+	taskID := payloadValue(job.Payload, "task_id")
+	if taskID == "" {
+		taskID = job.ID
+	}
+	report := make([]map[string]string, 0, len(steps))
+	finish := func(status, message string) (jobs.Result, func() error) {
+		encoded, _ := json.Marshal(report)
+		return jobs.Result{
+			JobID:  job.ID,
+			Status: status,
+			Output: map[string]string{
+				"message":     message,
+				"instance_id": instanceID,
+				"task_id":     taskID,
+				"steps_run":   strconv.Itoa(len(report)),
+				"steps_total": strconv.Itoa(len(steps)),
+				"steps":       string(encoded),
+			},
+			Completed: time.Now().UTC(),
+		}, nil
+	}
+	for idx, step := range steps {
+		entry := map[string]string{"step": strconv.Itoa(offset + idx + 1), "type": step.Type}
+		report = append(report, entry)
+		if ctx.Err() != nil {
+			entry["status"] = "cancelled"
+			return finish("failed", errJobCancelled.Error())
+		}
+		switch step.Type {
+		case instanceTaskStepWait:
+			if idx == len(steps)-1 {
+				entry["status"] = "skipped"
+				continue
+			}
+			// The wait runs outside the runner: the remaining steps are handed
+			// back as a new task job once it is over, so neither a worker nor
+			// the instance lock is held for up to maxInstanceTaskWait.
+			delay := time.Duration(step.Seconds) * time.Second
+			resumeJob := instanceTaskResumeJob(job, steps[idx+1:], idx+1)
+			if err := instanceTaskResume(resumeJob, delay); err != nil {
+				entry["status"] = "failed"
+				entry["message"] = err.Error()
+				return finish("failed", fmt.Sprintf("step %d (wait): %v", idx+1, err))
+			}
+			// The task is not done yet: it reports as waiting, and the final
+			// outcome arrives as the scheduled run of the last resume job.
+			entry["status"] = "scheduled"
+			entry["resume_job_id"] = resumeJob.ID
+			result, afterSubmit := finish(instanceTaskStatusWaiting, fmt.Sprintf("waiting %ds, remaining steps resume as job %s", step.Seconds, resumeJob.ID))
+			result.Output["resume_job_id"] = resumeJob.ID
+			result.Output["resume_at"] = time.Now().Add(delay).UTC().Format(time.RFC3339)
+			return result, afterSubmit
+		case instanceTaskStepCondition:
+			players, err := instanceTaskPlayers(job, idx)
+			if err != nil {
+				entry["status"] = "failed"
+				entry["message"] = err.Error()
+				return finish("failed", fmt.Sprintf("step %d (condition): %v", idx+1, err))
+			}
+			entry["players"] = strconv.Itoa(players)
+			if (step.PlayersMin != nil && players < *step.PlayersMin) || (step.PlayersMax != nil && players > *step.PlayersMax) {
+				entry["status"] = "unmet"
+				return finish("success", fmt.Sprintf("condition not met at step %d (players=%d), remaining steps skipped", idx+1, players))
+			}
+			entry["status"] = "met"
+		default:
+			result := runInstanceTaskAction(ctx, job, idx, step)
+			entry["status"] = result.Status
+			if message := result.Output["message"]; message != "" {
+				entry["message"] = message
+			}
+			if result.Status != "success" {
+				return finish("failed", fmt.Sprintf("step %d (%s) failed: %s", idx+1, step.Type, result.Output["message"]))
+			}
+		}
+	}
+	return finish("success", "task completed")
+}
+
+// instanceTaskResumeJob builds the task job that runs the steps after a wait.
+// The step offset and the root task ID are carried along so the resumed job
+// can be traced back to the task the panel started.
+func instanceTaskResumeJob(job jobs.Job, remaining []instanceTaskStep, offset int) jobs.Job {
+	payload := make(map[string]any, len(job.Payload)+2)
+	for key, value := range job.Payload {
+		payload[key] = value
+	}
+	taskID := payloadValue(job.Payload, "task_id")
+	if taskID == "" {
+		taskID = job.ID
+	}
+	previous, _ := strconv.Atoi(payloadValue(job.Payload, "step_offset"))
+	offset += previous
+	payload["task_id"] = taskID
+	payload["step_offset"] = offset
+	payload["steps"] = remaining
+	return jobs.Job{
+		ID:            fmt.Sprintf("%s-resume%d", taskID, offset+1),
+		Type:          instanceTaskRunJobType,
+		Payload:       payload,
+		CorrelationID: job.CorrelationID,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+// instanceTaskResume hands a resumed task job back to the runner after delay.
+var instanceTaskResume = func(job jobs.Job, delay time.Duration) error {
+	return globalInstanceTaskResumes.Schedule(job, delay)
+}
+
+// instanceTaskResumes holds the task jobs waiting for their wait step to end.
+// Every pending resume is persisted with its due time so the remaining steps
+// of a task survive an agent restart or self-update. dispatch submits a
+// resumed job to the runner.
+type instanceTaskResumes struct {
+	mu       sync.Mutex
+	path     string
+	pending  map[string]*instanceTaskPendingResume
+	dispatch func(job jobs.Job)
+	logger   *logging.JSONLogger
+}
+
+type instanceTaskPendingResume struct {
+	Job    jobs.Job  `json:"job"`
+	DueAt  time.Time `json:"due_at"`
+	taskID string
+	timer  *time.Timer
+}
+
+type instanceTaskResumeFile struct {
+	UpdatedAt time.Time                    `json:"updated_at"`
+	Resumes   []*instanceTaskPendingResume `json:"resumes"`
+}
+
+var globalInstanceTaskResumes = globalInstanceSchedulers.resumes
+
+// newInstanceTaskResumes keeps the pending resumes in path. The name carries
+// a dot so the schedule dir scan never mistakes it for an instance file.
+func newInstanceTaskResumes(path string) *instanceTaskResumes {
+	return &instanceTaskResumes{path: path, pending: map[string]*instanceTaskPendingResume{}}
+}
+
+// SetDispatch sets the function that submits due resumes to the runner.
+func (r *instanceTaskResumes) SetDispatch(dispatch func(job jobs.Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatch = dispatch
+}
+
+// Load re-arms the persisted resumes. A resume that fell due while the agent
+// was down is dispatched right away.
+func (r *instanceTaskResumes) Load() error {
+	if r.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read task resumes: %w", err)
+	}
+	var file instanceTaskResumeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("decode task resumes: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, pending := range file.Resumes {
+		if pending == nil || pending.Job.ID == "" {
+			continue
+		}
+		if previous, ok := r.pending[pending.Job.ID]; ok {
+			previous.timer.Stop()
+		}
+		r.armLocked(pending, pending.DueAt.Sub(now))
+	}
+	return nil
+}
+
+// Schedule dispatches job once delay has passed.
+func (r *instanceTaskResumes) Schedule(job jobs.Job, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dispatch == nil {
+		return fmt.Errorf("task resume is unavailable")
+	}
+	previous, hadPrevious := r.pending[job.ID]
+	if hadPrevious {
+		previous.timer.Stop()
+	}
+	pending := &instanceTaskPendingResume{Job: job, DueAt: time.Now().Add(delay).UTC()}
+	r.armLocked(pending, delay)
+	if err := r.persistLocked(); err != nil {
+		pending.timer.Stop()
+		delete(r.pending, job.ID)
+		if hadPrevious {
+			r.armLocked(previous, time.Until(previous.DueAt))
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *instanceTaskResumes) armLocked(pending *instanceTaskPendingResume, delay time.Duration) {
+	jobID := pending.Job.ID
+	pending.taskID = payloadValue(pending.Job.Payload, "task_id")
+	pending.timer = time.AfterFunc(max(delay, 0), func() {
+		r.mu.Lock()
+		current, ok := r.pending[jobID]
+		due := ok && current == pending
+		if due {
+			delete(r.pending, jobID)
+			if err := r.persistLocked(); err != nil {
+				r.logPersistFailure(jobID, payloadValue(pending.Job.Payload, "instance_id"), err)
+			}
+		}
+		dispatch := r.dispatch
+		r.mu.Unlock()
+		if due && dispatch != nil {
+			dispatch(pending.Job)
+		}
+	})
+	r.pending[jobID] = pending
+}
+
+func (r *instanceTaskResumes) persistLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	file := instanceTaskResumeFile{UpdatedAt: time.Now().UTC(), Resumes: []*instanceTaskPendingResume{}}
+	for _, pending := range r.pending {
+		file.Resumes = append(file.Resumes, pending)
+	}
+	sort.Slice(file.Resumes, func(a, b int) bool { return file.Resumes[a].Job.ID < file.Resumes[b].Job.ID })
+	encoded, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode task resumes: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o750); err != nil {
+		return fmt.Errorf("create schedule dir: %w", err)
+	}
+	tmpPath := r.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(encoded, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write task resumes: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit task resumes: %w", err)
+	}
+	return nil
+}
+
+// Cancel drops the waiting resumes of a task, matched by resume job ID or by
+// the ID of the task that started it. It reports whether one was dropped.
+func (r *instanceTaskResumes) Cancel(jobID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancelled := false
+	for resumeID, pending := range r.pending {
+		if resumeID != jobID && pending.taskID != jobID {
+			continue
+		}
+		pending.timer.Stop()
+		delete(r.pending, resumeID)
+		cancelled = true
+	}
+	if cancelled {
+		if err := r.persistLocked(); err != nil {
+			r.logPersistFailure(jobID, "", err)
+		}
+	}
+	return cancelled
+}
+
+func (r *instanceTaskResumes) logPersistFailure(jobID, instanceID string, err error) {
+	if r.logger == nil {
+		return
+	}
+	fields := map[string]any{"job_id": jobID}
+	if instanceID != "" {
+		fields["instance_id"] = instanceID
+	}
+	r.logger.Error(context.Background(), "agent.instance_task_resume_persist_failed", "SCHEDULE_PERSIST_FAILED", fmt.Sprintf("persist task resumes failed: %v", err), fields)
+}
+
+// runResumedInstanceTask runs a resumed task job on a runner worker and
+// reports its outcome like a scheduled run of the task's schedule.
+func runResumedInstanceTask(job jobs.Job, report func(run jobs.ScheduledRun)) {
+	started := time.Now().UTC()
+	ctx, done := globalJobCancels.Begin(context.Background(), job.ID)
+	result, _ := handleInstanceTaskRun(ctx, job)
+	done()
+	if report == nil {
+		return
+	}
+	requestID, correlationID := trace.Normalize(payloadValue(job.Payload, "request_id"), job.CorrelationID)
+	report(jobs.ScheduledRun{
+		ScheduleID:    payloadValue(job.Payload, "schedule_id"),
+		JobID:         job.ID,
+		JobType:       job.Type,
+		Status:        result.Status,
+		Output:        result.Output,
+		RequestID:     requestID,
+		CorrelationID: correlationID,
+		StartedAt:     started,
+		Completed:     time.Now().UTC(),
+	})
+}
+
+func instanceTaskStepJob(job jobs.Job, idx int, stepType string, extra map[string]any) jobs.Job {
+	payload := make(map[string]any, len(job.Payload)+len(extra))
+	for key, value := range job.Payload {
+		if key != "steps" {
+			payload[key] = value
+		}
+	}
+	for key, value := range extra {
+		payload[key] = value
+	}
+	return jobs.Job{
+		ID:            fmt.Sprintf("%s-step%d", job.ID, idx+1),
+		Type:          job.Type,
+		Payload:       globalJobSchemas.canonicalPayload(instanceTaskStepJobTypes[stepType], payload),
+		CorrelationID: job.CorrelationID,
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+func runInstanceTaskAction(ctx context.Context, job jobs.Job, idx int, step instanceTaskStep) jobs.Result {
+	extra := map[string]any{}
+	for key, value := range step.Payload {
+		extra[key] = value
+	}
+	if step.Type == instanceTaskStepCommand {
+		extra["command"] = step.Command
+	}
+	stepJob := instanceTaskStepJob(job, idx, step.Type, extra)
+	stepCtx, done := globalJobCancels.Begin(ctx, stepJob.ID)
+	result, afterSubmit := instanceTaskActions[step.Type](stepCtx, stepJob)
+	done()
+	if afterSubmit != nil {
+		if err := afterSubmit(); err != nil && globalInstanceSchedulers.logger != nil {
+			globalInstanceSchedulers.logger.Error(ctx, "agent.instance_task_post_action_failed", "SCHEDULE_POST_ACTION_FAILED", fmt.Sprintf("instance task post-action failed: %v", err), map[string]any{
+				"job_id":      job.ID,
+				"instance_id": payloadValue(job.Payload, "instance_id"),
+				"step":        idx + 1,
+			})
+		}
+	}
+	return result
+}
+
+// instanceTaskPlayers queries the instance with the query settings of the
+// task payload. An offline server counts as empty.
+func instanceTaskPlayers(job jobs.Job, idx int) (int, error) {
+	result, _ := instanceTaskQueryFn(instanceTaskStepJob(job, idx, instanceTaskStepCondition, nil))
+	if result.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s", result.Output["message"])
+	}
+	if result.Output["status"] == "offline" {
+		return 0, nil
+	}
+	players, err := strconv.Atoi(strings.TrimSpace(result.Output["players"]))
+	if err != nil {
+		return 0, fmt.Errorf("query returned no player count")
+	}
+	return players, nil
+}
+
+// instanceScheduleSpec is a schedule of one instance as the panel sends it.
+// The steps become an instance.task.run job on the agent scheduler.
+type instanceScheduleSpec struct {
+	ID      string         `json:"id"`
+	Cron    string         `json:"cron"`
+	Steps   any            `json:"steps"`
+	Payload map[string]any `json:"payload,omitempty"`
+	Enabled *bool          `json:"enabled,omitempty"`
+}
+
+func (spec instanceScheduleSpec) agentSpec(instanceID string) (agentScheduleSpec, error) {
+	steps, err := parseInstanceTaskSteps(spec.Steps)
+	if err != nil {
+		return agentScheduleSpec{}, fmt.Errorf("schedule %s: %w", spec.ID, err)
+	}
+	payload := make(map[string]any, len(spec.Payload)+2)
+	for key, value := range spec.Payload {
+		payload[key] = value
+	}
+	payload["instance_id"] = instanceID
+	payload["steps"] = steps
+	return agentScheduleSpec{ID: spec.ID, JobType: instanceTaskRunJobType, Cron: spec.Cron, Payload: payload, Enabled: spec.Enabled}, nil
+}
+
+// instanceSchedulers keeps one agent scheduler per instance, each persisted
+// to its own file, and ticks them together.
+type instanceSchedulers struct {
+	mu         sync.Mutex
+	dir        string
+	schedulers map[string]*agentScheduler
+	resumes    *instanceTaskResumes
+	dispatch   func(job jobs.Job, handler func(jobs.Job))
+	report     func(run jobs.ScheduledRun)
+	logger     *logging.JSONLogger
+}
+
+var globalInstanceSchedulers = newInstanceSchedulers(instanceScheduleDir)
+
+func newInstanceSchedulers(dir string) *instanceSchedulers {
+	return &instanceSchedulers{
+		dir:        dir,
+		schedulers: map[string]*agentScheduler{},
+		resumes:    newInstanceTaskResumes(filepath.Join(dir, "task-resumes.pending.json")),
+	}
+}
+
+func (m *instanceSchedulers) path(instanceID string) string {
+	return filepath.Join(m.dir, instanceID+".json")
+}
+
+// Load reads the schedules of every instance from the schedule dir and
+// re-arms the tasks that were waiting to resume.
+func (m *instanceSchedulers) Load() error {
+	if err := m.resumes.Load(); err != nil && m.logger != nil {
+		m.logger.Error(context.Background(), "agent.instance_task_resumes_skipped", "SCHEDULE_LOAD_FAILED", fmt.Sprintf("skip instance task resumes: %v", err), nil)
+	}
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read instance schedules: %w", err)
+	}
+	for _, entry := range entries {
+		instanceID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || !instanceScheduleIDPattern.MatchString(instanceID) {
+			continue
+		}
+		scheduler := m.forInstance(instanceID)
+		if err := scheduler.Load(); err != nil && m.logger != nil {
+			m.logger.Error(context.Background(), "agent.instance_schedules_skipped", "SCHEDULE_LOAD_FAILED", fmt.Sprintf("skip instance schedules: %v", err), map[string]any{"instance_id": instanceID})
+		}
+	}
+	return nil
+}
+
+func (m *instanceSchedulers) forInstance(instanceID string) *agentScheduler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scheduler, ok := m.schedulers[instanceID]
+	if !ok {
+		scheduler = newAgentScheduler(m.path(instanceID))
+		m.schedulers[instanceID] = scheduler
+	}
+	scheduler.dispatch = m.dispatch
+	scheduler.report = m.report
+	scheduler.logger = m.logger
+	return scheduler
+}
+
+// Replace stores the full schedule set of an instance.
+func (m *instanceSchedulers) Replace(instanceID string, specs []instanceScheduleSpec) error {
+	agentSpecs := make([]agentScheduleSpec, 0, len(specs))
+	for _, spec := range specs {
+		agentSpec, err := spec.agentSpec(instanceID)
+		if err != nil {
+			return err
+		}
+		agentSpecs = append(agentSpecs, agentSpec)
+	}
+	return m.forInstance(instanceID).Replace(agentSpecs)
+}
+
+// Remove drops every schedule of an instance, e.g. when it is deleted.
+func (m *instanceSchedulers) Remove(instanceID string) error {
+	m.mu.Lock()
+	delete(m.schedulers, instanceID)
+	m.mu.Unlock()
+	if err := os.Remove(m.path(instanceID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove instance schedules: %w", err)
+	}
+	return nil
+}
+
+// Tick dispatches the due schedules of all instances.
+func (m *instanceSchedulers) Tick() []jobs.Job {
+	m.mu.Lock()
+	instanceIDs := make([]string, 0, len(m.schedulers))
+	for instanceID := range m.schedulers {
+		instanceIDs = append(instanceIDs, instanceID)
+	}
+	m.mu.Unlock()
+	sort.Strings(instanceIDs)
+	dispatched := []jobs.Job{}
+	for _, instanceID := range instanceIDs {
+		dispatched = append(dispatched, m.forInstance(instanceID).Tick()...)
+	}
+	return dispatched
+}
+
+// Run ticks the instance schedulers until ctx is cancelled.
+func (m *instanceSchedulers) Run(ctx context.Context) {
+	ticker := time.NewTicker(agentScheduleTick)
+	defer ticker.Stop()
+	m.Tick()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Tick()
+		}
+	}
+}
+
+func instanceScheduleInstanceID(payload map[string]any) (string, error) {
+	instanceID := payloadValue(payload, "instance_id")
+	if instanceID == "" {
+		return "", fmt.Errorf("missing required values: instance_id")
+	}
+	if !instanceScheduleIDPattern.MatchString(instanceID) {
+		return "", fmt.Errorf("invalid instance_id: %s", instanceID)
+	}
+	return instanceID, nil
+}
+
+func handleInstanceScheduleSync(job jobs.Job) (jobs.Result, func() error) {
+	instanceID, err := instanceScheduleInstanceID(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	var specs []instanceScheduleSpec
+	switch raw := job.Payload["schedules"].(type) {
+	case nil:
+		return failureResult(job.ID, fmt.Errorf("missing schedules"))
+	case string:
+		if err := json.Unmarshal([]byte(raw), &specs); err != nil {
+			return failureResult(job.ID, fmt.Errorf("invalid schedules: %w", err))
+		}
+	default:
+		encoded, _ := json.Marshal(raw)
+		if err := json.Unmarshal(encoded, &specs); err != nil {
+			return failureResult(job.ID, fmt.Errorf("invalid schedules: %w", err))
+		}
+	}
+	if err := globalInstanceSchedulers.Replace(instanceID, specs); err != nil {
+		return failureResult(job.ID, err)
+	}
+	return instanceScheduleListResult(job.ID, instanceID, "schedules stored")
+}
+
+func handleInstanceScheduleDelete(job jobs.Job) (jobs.Result, func() error) {
+	instanceID, err := instanceScheduleInstanceID(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	scheduleID := payloadValue(job.Payload, "schedule_id")
+	if scheduleID == "" {
+		return failureResult(job.ID, fmt.Errorf("missing required values: schedule_id"))
+	}
+	existed, err := globalInstanceSchedulers.forInstance(instanceID).Delete(scheduleID)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	message := "schedule deleted"
+	if !existed {
+		message = "schedule not found"
+	}
+	return instanceScheduleListResult(job.ID, instanceID, message)
+}
+
+func handleInstanceScheduleList(job jobs.Job) (jobs.Result, func() error) {
+	instanceID, err := instanceScheduleInstanceID(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	return instanceScheduleListResult(job.ID, instanceID, "schedules listed")
+}
+
+func instanceScheduleListResult(jobID, instanceID, message string) (jobs.Result, func() error) {
+	encoded, err := json.Marshal(globalInstanceSchedulers.forInstance(instanceID).List())
+	if err != nil {
+		return failureResult(jobID, err)
+	}
+	return jobs.Result{
+		JobID:  jobID,
+		Status: "success",
+		Output: map[string]string{
+			"message":     message,
+			"instance_id": instanceID,
+			"schedules":   string(encoded),
+		},
+		Completed: time.Now().UTC(),
+	}, nil
+}
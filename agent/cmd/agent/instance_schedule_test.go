@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+func stubInstanceTaskActions(t *testing.T, players string) *[]string {
+	t.Helper()
+	var calls []string
+	originalActions := instanceTaskActions
+	originalQuery := instanceTaskQueryFn
+	instanceTaskActions = map[string]func(ctx context.Context, job jobs.Job) (jobs.Result, func() error){}
+	for _, stepType := range []string{instanceTaskStepCommand, instanceTaskStepBackup, instanceTaskStepRestart, instanceTaskStepStart, instanceTaskStepStop} {
+		stepType := stepType
+		instanceTaskActions[stepType] = func(_ context.Context, job jobs.Job) (jobs.Result, func() error) {
+			calls = append(calls, strings.TrimSpace(stepType+" "+payloadValue(job.Payload, "command", "backup_target")))
+			return jobs.Result{JobID: job.ID, Status: "success", Output: map[string]string{}}, nil
+		}
+	}
+	instanceTaskQueryFn = func(job jobs.Job) (jobs.Result, func() error) {
+		return jobs.Result{JobID: job.ID, Status: "success", Output: map[string]string{"status": "running", "players": players}}, nil
+	}
+	t.Cleanup(func() {
+		instanceTaskActions = originalActions
+		instanceTaskQueryFn = originalQuery
+	})
+	return &calls
+}
+
+func TestHandleInstanceTaskRunChainsStepsAndStopsOnUnmetCondition(t *testing.T) {
+	steps := []any{
+		map[string]any{"type": "command", "command": "say Restart now"},
+		map[string]any{"type": "backup", "payload": map[string]any{"backup_target": "s3"}},
+		map[string]any{"type": "condition", "players_max": 0},
+		map[string]any{"type": "restart"},
+	}
+
+	calls := stubInstanceTaskActions(t, "0")
+	result, _ := handleInstanceTaskRun(context.Background(), jobs.Job{ID: "task-1", Payload: map[string]any{"instance_id": "7", "steps": steps}})
+	if result.Status != "success" || result.Output["steps_run"] != "4" {
+		t.Fatalf("expected all steps to run, got %#v", result.Output)
+	}
+	if got := strings.Join(*calls, ","); got != "command say Restart now,backup s3,restart" {
+		t.Fatalf("unexpected step calls %q", got)
+	}
+
+	calls = stubInstanceTaskActions(t, "3")
+	result, _ = handleInstanceTaskRun(context.Background(), jobs.Job{ID: "task-2", Payload: map[string]any{"instance_id": "7", "steps": steps}})
+	if result.Status != "success" || result.Output["steps_run"] != "3" || !strings.Contains(result.Output["message"], "condition not met") {
+		t.Fatalf("expected the task to stop at the condition, got %#v", result.Output)
+	}
+	if got := strings.Join(*calls, ","); strings.Contains(got, "restart") {
+		t.Fatalf("restart must be skipped with players online, got %q", got)
+	}
+
+	if _, err := parseInstanceTaskSteps(`[{"type":"wait","seconds":0}]`); err == nil {
+		t.Fatal("expected a zero wait to be rejected")
+	}
+	if _, err := parseInstanceTaskSteps(`[{"type":"reinstall"}]`); err == nil {
+		t.Fatal("expected an unknown step type to be rejected")
+	}
+}
+
+func TestHandleInstanceTaskRunCancelsStepsThroughTheJobContext(t *testing.T) {
+	calls := stubInstanceTaskActions(t, "0")
+	var stepErr error
+	instanceTaskActions[instanceTaskStepCommand] = func(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+		globalJobCancels.Cancel("task-cancel", "operator abort")
+		stepErr = ctx.Err()
+		return jobs.Result{JobID: job.ID, Status: "success", Output: map[string]string{}}, nil
+	}
+	ctx, done := globalJobCancels.Begin(context.Background(), "task-cancel")
+	defer done()
+
+	steps := []any{map[string]any{"type": "command", "command": "save-all"}, map[string]any{"type": "restart"}}
+	result, _ := handleInstanceTaskRun(ctx, jobs.Job{ID: "task-cancel", Payload: map[string]any{"instance_id": "7", "steps": steps}})
+	if !errors.Is(stepErr, context.Canceled) {
+		t.Fatalf("expected the step context to follow the task context, got %v", stepErr)
+	}
+	if result.Status != "failed" || len(*calls) != 0 {
+		t.Fatalf("expected the task to stop after cancellation, got %#v calls=%v", result.Output, *calls)
+	}
+}
+
+func TestHandleInstanceTaskRunResumesAfterWaitOutsideTheRunner(t *testing.T) {
+	calls := stubInstanceTaskActions(t, "0")
+	type resume struct {
+		job   jobs.Job
+		delay time.Duration
+	}
+	var resumed []resume
+	originalResume := instanceTaskResume
+	instanceTaskResume = func(job jobs.Job, delay time.Duration) error {
+		resumed = append(resumed, resume{job: job, delay: delay})
+		return nil
+	}
+	t.Cleanup(func() { instanceTaskResume = originalResume })
+
+	steps := `[{"type":"command","command":"say Restart in 5 minutes"},{"type":"wait","seconds":300},{"type":"restart"}]`
+	result, _ := handleInstanceTaskRun(context.Background(), jobs.Job{ID: "task-1", Payload: map[string]any{"instance_id": "7", "steps": steps}})
+	if result.Status != instanceTaskStatusWaiting || result.Output["resume_job_id"] != "task-1-resume3" || result.Output["steps_run"] != "2" {
+		t.Fatalf("expected the task to hand off at the wait step, got %#v", result.Output)
+	}
+	if got := strings.Join(*calls, ","); got != "command say Restart in 5 minutes" {
+		t.Fatalf("expected only the steps before the wait to run, got %q", got)
+	}
+	if len(resumed) != 1 || resumed[0].delay != 300*time.Second {
+		t.Fatalf("expected one resume after 300s, got %#v", resumed)
+	}
+
+	result, _ = handleInstanceTaskRun(context.Background(), resumed[0].job)
+	if result.Status != "success" || result.Output["task_id"] != "task-1" || !strings.Contains(result.Output["steps"], `"step":"3"`) {
+		t.Fatalf("expected the resumed job to run the remaining steps, got %#v", result.Output)
+	}
+	if got := strings.Join(*calls, ","); !strings.HasSuffix(got, ",restart") {
+		t.Fatalf("expected restart after the wait, got %q", got)
+	}
+	if _, mode, _ := resolveJobScheduling(resumed[0].job); mode != jobLockWrite {
+		t.Fatalf("expected instance tasks to take the instance write lock, got %v", mode)
+	}
+}
+
+func TestInstanceTaskResumesCancelByTaskID(t *testing.T) {
+	resumes := newInstanceTaskResumes(filepath.Join(t.TempDir(), "task-resumes.pending.json"))
+	dispatched := make(chan jobs.Job, 1)
+	resumes.SetDispatch(func(job jobs.Job) { dispatched <- job })
+	job := instanceTaskResumeJob(jobs.Job{ID: "task-1", Payload: map[string]any{"instance_id": "7"}}, nil, 2)
+	if err := resumes.Schedule(job, 20*time.Millisecond); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if !resumes.Cancel("task-1") {
+		t.Fatal("expected the pending resume to be cancelled by task id")
+	}
+	select {
+	case job := <-dispatched:
+		t.Fatalf("cancelled resume was dispatched: %s", job.ID)
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestInstanceTaskResumesSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+	before := newInstanceSchedulers(dir)
+	before.resumes.SetDispatch(func(jobs.Job) {})
+	later := instanceTaskResumeJob(jobs.Job{ID: "task-1", Payload: map[string]any{"instance_id": "7"}}, []instanceTaskStep{{Type: instanceTaskStepRestart}}, 2)
+	overdue := instanceTaskResumeJob(jobs.Job{ID: "task-2", Payload: map[string]any{"instance_id": "7"}}, []instanceTaskStep{{Type: instanceTaskStepRestart}}, 2)
+	if err := before.resumes.Schedule(later, time.Hour); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if err := before.resumes.Schedule(overdue, time.Hour); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	for _, pending := range before.resumes.pending {
+		pending.timer.Stop()
+	}
+	before.resumes.mu.Lock()
+	before.resumes.pending[overdue.ID].DueAt = time.Now().Add(-time.Minute)
+	if err := before.resumes.persistLocked(); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+	before.resumes.mu.Unlock()
+
+	after := newInstanceSchedulers(dir)
+	dispatched := make(chan jobs.Job, 2)
+	after.resumes.SetDispatch(func(job jobs.Job) { dispatched <- job })
+	if err := after.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	select {
+	case job := <-dispatched:
+		if job.ID != overdue.ID || payloadValue(job.Payload, "instance_id") != "7" {
+			t.Fatalf("expected the overdue resume to run after the restart, got %#v", job)
+		}
+		if _, err := parseInstanceTaskSteps(job.Payload["steps"]); err != nil {
+			t.Fatalf("expected the reloaded steps to parse: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the overdue resume to be dispatched on load")
+	}
+	after.resumes.mu.Lock()
+	pending, ok := after.resumes.pending[later.ID]
+	after.resumes.mu.Unlock()
+	if !ok || time.Until(pending.DueAt) < 59*time.Minute {
+		t.Fatalf("expected the later resume to keep its due time, got %#v", pending)
+	}
+	if !after.resumes.Cancel("task-1") {
+		t.Fatal("expected the reloaded resume to be cancellable by task id")
+	}
+	if len(after.schedulers) != 0 {
+		t.Fatalf("expected the resume file not to be read as an instance schedule, got %v", after.schedulers)
+	}
+}
+
+func TestInstanceSchedulersPersistPerInstance(t *testing.T) {
+	dir := t.TempDir()
+	schedulers := newInstanceSchedulers(dir)
+	err := schedulers.Replace("7", []instanceScheduleSpec{{
+		ID:    "daily-restart",
+		Cron:  "0 6 * * *",
+		Steps: `[{"type":"command","command":"say Restart in 5 minutes"},{"type":"wait","seconds":300},{"type":"restart"}]`,
+	}})
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if err := schedulers.Replace("8", []instanceScheduleSpec{{ID: "bad", Cron: "@hourly", Steps: `[]`}}); err == nil {
+		t.Fatal("expected a schedule without steps to be rejected")
+	}
+
+	reloaded := newInstanceSchedulers(dir)
+	var dispatched []jobs.Job
+	reloaded.dispatch = func(job jobs.Job, _ func(jobs.Job)) { dispatched = append(dispatched, job) }
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	scheduler := reloaded.forInstance("7")
+	list := scheduler.List()
+	if len(list) != 1 || list[0]["id"] != "daily-restart" || list[0]["job_type"] != instanceTaskRunJobType {
+		t.Fatalf("expected persisted instance schedule, got %#v", list)
+	}
+
+	scheduler.mu.Lock()
+	scheduler.entries["daily-restart"].next = time.Now().Add(-time.Minute)
+	scheduler.mu.Unlock()
+	reloaded.Tick()
+	if len(dispatched) != 1 || payloadValue(dispatched[0].Payload, "instance_id") != "7" {
+		t.Fatalf("expected the task job to be dispatched for instance 7, got %#v", dispatched)
+	}
+	steps, err := parseInstanceTaskSteps(dispatched[0].Payload["steps"])
+	if err != nil || len(steps) != 3 || steps[1].Seconds != 300 {
+		t.Fatalf("expected persisted steps on the job, got %#v (%v)", steps, err)
+	}
+
+	if err := reloaded.Remove("7"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	afterRemove := newInstanceSchedulers(dir)
+	if err := afterRemove.Load(); err != nil {
+		t.Fatalf("load after remove: %v", err)
+	}
+	if list := afterRemove.forInstance("7").List(); len(list) != 0 {
+		t.Fatalf("expected schedules to be gone after remove, got %#v", list)
+	}
+}
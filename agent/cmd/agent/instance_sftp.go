@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
@@ -25,10 +26,10 @@ const (
 
 func handleInstanceSftpCredentialsReset(job jobs.Job) (jobs.Result, func() error) {
 	requestID := payloadValue(job.Payload, "request_id")
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	password := strings.TrimSpace(payloadValue(job.Payload, "one_time_password", "password", "sftp_password"))
-	preferredBackend := strings.ToUpper(strings.TrimSpace(payloadValue(job.Payload, "preferred_backend", "backend")))
-	rootPath := strings.TrimSpace(payloadValue(job.Payload, "install_path", "root_path", "instance_root"))
+	username := payloadValue(job.Payload, "username")
+	password := strings.TrimSpace(payloadValue(job.Payload, "one_time_password"))
+	preferredBackend := strings.ToUpper(strings.TrimSpace(payloadValue(job.Payload, "preferred_backend")))
+	rootPath := strings.TrimSpace(payloadValue(job.Payload, "install_path"))
 
 	log.Printf("sftp credentials reset start job_id=%s request_id=%s instance_id=%s customer_id=%s username=%s base_dir=%s", job.ID, requestID, payloadValue(job.Payload, "instance_id"), payloadValue(job.Payload, "customer_id"), username, payloadValue(job.Payload, "base_dir"))
 
@@ -87,7 +88,7 @@ func handleInstanceSftpCredentialsReset(job jobs.Job) (jobs.Result, func() error
 		Output: map[string]string{
 			"username":   username,
 			"backend":    "PROFTPD_SFTP",
-			"host":       payloadValue(job.Payload, "host", "node_ip", "bind_ip"),
+			"host":       payloadValue(job.Payload, "host"),
 			"port":       "2222",
 			"root_path":  homePath,
 			"home_path":  homePath,
@@ -99,10 +100,10 @@ func handleInstanceSftpCredentialsReset(job jobs.Job) (jobs.Result, func() error
 
 func handleInstanceSftpCredentialsResetWindows(job jobs.Job) (jobs.Result, func() error) {
 	requestID := payloadValue(job.Payload, "request_id")
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	password := strings.TrimSpace(payloadValue(job.Payload, "one_time_password", "password", "sftp_password"))
-	preferredBackend := strings.ToUpper(strings.TrimSpace(payloadValue(job.Payload, "preferred_backend", "backend")))
-	rootPath := strings.TrimSpace(payloadValue(job.Payload, "install_path", "root_path", "instance_root"))
+	username := payloadValue(job.Payload, "username")
+	password := strings.TrimSpace(payloadValue(job.Payload, "one_time_password"))
+	preferredBackend := strings.ToUpper(strings.TrimSpace(payloadValue(job.Payload, "preferred_backend")))
+	rootPath := strings.TrimSpace(payloadValue(job.Payload, "install_path"))
 
 	homePath, err := resolveInstanceDir(map[string]any{"install_path": rootPath, "base_dir": payloadValue(job.Payload, "base_dir")})
 	if err != nil {
@@ -129,7 +130,7 @@ func handleInstanceSftpCredentialsResetWindows(job jobs.Job) (jobs.Result, func(
 		Output: map[string]string{
 			"username":   username,
 			"backend":    backend,
-			"host":       payloadValue(job.Payload, "host", "node_ip", "bind_ip"),
+			"host":       payloadValue(job.Payload, "host"),
 			"port":       strconv.Itoa(defaultAccessListenPort),
 			"root_path":  homePath,
 			"home_path":  homePath,
@@ -169,7 +170,7 @@ func userExists(username string) bool {
 func setUserPassword(username, password string) error {
 	cmd := exec.Command("chpasswd")
 	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s:%s", username, password))
-	output, err := StreamCommand(cmd, "", nil)
+	output, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err != nil {
 		log.Printf("sftp set password failed username=%s err=%v output=%s", username, err, strings.TrimSpace(output))
 		return fmt.Errorf("set password for %s failed: %w (%s)", username, err, strings.TrimSpace(output))
@@ -181,10 +182,10 @@ func setUserPassword(username, password string) error {
 }
 
 func handleWebspaceSftpCredentialsReset(job jobs.Job) (jobs.Result, func() error) {
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	password := strings.TrimSpace(payloadValue(job.Payload, "password", "sftp_password"))
-	rootPath := strings.TrimSpace(payloadValue(job.Payload, "root_path", "webspace_path", "web_root"))
-	group := payloadValue(job.Payload, "sftp_group", "group")
+	username := payloadValue(job.Payload, "username")
+	password := strings.TrimSpace(payloadValue(job.Payload, "password"))
+	rootPath := strings.TrimSpace(payloadValue(job.Payload, "root_path"))
+	group := payloadValue(job.Payload, "sftp_group")
 	shell := payloadValue(job.Payload, "shell")
 
 	if password == "" {
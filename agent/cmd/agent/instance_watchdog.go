@@ -10,7 +10,8 @@ import (
 )
 
 // handleInstanceWatchdogCheck checks whether the game server service is active.
-// If it is not running it restarts it (up to max_restarts attempts).
+// If it is not running it records a crash report and restarts it (up to
+// max_restarts attempts) unless the instance is in a crash loop.
 // The job is dispatched by the panel on a configurable schedule.
 func handleInstanceWatchdogCheck(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
 	if runtime.GOOS == "windows" {
@@ -34,7 +35,7 @@ func handleInstanceWatchdogCheck(job jobs.Job, logSender JobLogSender) (jobs.Res
 		}
 	}
 
-	statusOutput, err := runCommandOutput("systemctl", "is-active", serviceName)
+	statusOutput, err := commandOutputRunner("systemctl", "is-active", serviceName)
 	status := strings.TrimSpace(statusOutput)
 
 	diagnostics := map[string]string{
@@ -54,6 +55,23 @@ func handleInstanceWatchdogCheck(job jobs.Job, logSender JobLogSender) (jobs.Res
 		}, nil
 	}
 
+	crashOutput, crashLoop, crashErr := globalInstanceCrashes.Inspect(instanceID, serviceName, job.Payload, nil)
+	if crashErr != nil {
+		sendWatchdogLog(job.ID, logSender, fmt.Sprintf("watchdog: crash analysis for %s failed: %v", serviceName, crashErr))
+	}
+	mergeDiagnostics(diagnostics, crashOutput)
+	if crashLoop != "" {
+		diagnostics["action"] = "crash_loop"
+		diagnostics["error_code"] = "CRASH_LOOP"
+		diagnostics["message"] = fmt.Sprintf("restart skipped: %s; reset the crash loop once the cause is fixed", crashLoop)
+		return jobs.Result{
+			JobID:     job.ID,
+			Status:    "failed",
+			Output:    diagnostics,
+			Completed: time.Now().UTC(),
+		}, nil
+	}
+
 	// Service is not active — attempt restart(s).
 	var lastRestartErr error
 	for attempt := 1; attempt <= maxRestarts; attempt++ {
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	jobCancelJobType       = "job.cancel"
+	jobCancelledStatus     = "cancelled"
+	jobCancelledErrorCode  = "JOB_CANCELLED"
+	jobCancelPendingTTL    = 30 * time.Minute
+	defaultJobCancelReason = "cancelled by panel"
+)
+
+var errJobCancelled = errors.New("job cancelled")
+
+// jobCancelRegistry tracks a cancellable context per running job so job.cancel can
+// find it by job ID. Handlers receive the context from Begin through handleJob.
+type jobCancelRegistry struct {
+	mu      sync.Mutex
+	running map[string]*jobCancelEntry
+	pending map[string]jobCancelRequest
+}
+
+type jobCancelEntry struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	reason string
+}
+
+type jobCancelRequest struct {
+	reason    string
+	requested time.Time
+}
+
+var globalJobCancels = newJobCancelRegistry()
+
+func newJobCancelRegistry() *jobCancelRegistry {
+	return &jobCancelRegistry{
+		running: map[string]*jobCancelEntry{},
+		pending: map[string]jobCancelRequest{},
+	}
+}
+
+// Begin registers a running job and returns its context. The returned done func must
+// be called once the job has finished. If a cancel request arrived while the job was
+// still queued, the returned context is already cancelled.
+func (r *jobCancelRegistry) Begin(parent context.Context, jobID string) (context.Context, func()) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if strings.TrimSpace(jobID) == "" {
+		return parent, func() {}
+	}
+	ctx, cancel := context.WithCancel(parent)
+	entry := &jobCancelEntry{ctx: ctx, cancel: cancel}
+
+	r.mu.Lock()
+	r.prunePendingLocked(time.Now())
+	if request, ok := r.pending[jobID]; ok {
+		delete(r.pending, jobID)
+		entry.reason = request.reason
+		cancel()
+	}
+	r.running[jobID] = entry
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		if current, ok := r.running[jobID]; ok && current == entry {
+			delete(r.running, jobID)
+		}
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel requests cancellation of a job. It returns true if the job was running; a
+// job that is not running yet is remembered and cancelled as soon as it begins.
+func (r *jobCancelRegistry) Cancel(jobID, reason string) bool {
+	jobID = strings.TrimSpace(jobID)
+	if jobID == "" {
+		return false
+	}
+	if strings.TrimSpace(reason) == "" {
+		reason = defaultJobCancelReason
+	}
+	r.mu.Lock()
+	entry, ok := r.running[jobID]
+	if !ok {
+		r.pending[jobID] = jobCancelRequest{reason: reason, requested: time.Now()}
+		r.mu.Unlock()
+		return false
+	}
+	if entry.reason == "" {
+		entry.reason = reason
+	}
+	r.mu.Unlock()
+
+	entry.cancel()
+	return true
+}
+
+// Cancelled reports whether the job was cancelled and why.
+func (r *jobCancelRegistry) Cancelled(jobID string) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.running[jobID]
+	if !ok {
+		return false, ""
+	}
+	if entry.ctx.Err() == nil {
+		return false, ""
+	}
+	return true, entry.reason
+}
+
+func (r *jobCancelRegistry) prunePendingLocked(now time.Time) {
+	for jobID, request := range r.pending {
+		if now.Sub(request.requested) > jobCancelPendingTTL {
+			delete(r.pending, jobID)
+		}
+	}
+}
+
+// coreJobHandler and orchestratorJobHandler dispatch a job once its cancel context
+// is registered; tests replace them to observe whether a handler ran.
+var (
+	coreJobHandler         = handleJob
+	orchestratorJobHandler = handleOrchestratorJob
+)
+
+// runCancellableJob runs a core job with its cancel context. A job cancelled while it
+// was still queued is reported as cancelled without calling its handler; a job
+// cancelled while running is reported as cancelled unless it still succeeded.
+func runCancellableJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+	// The job context is detached from the agent context on purpose: an agent shutdown
+	// must not turn running jobs into "cancelled"; the journal reports them as
+	// AGENT_RESTARTED instead.
+	ctx, done := globalJobCancels.Begin(context.Background(), job.ID)
+	defer done()
+	if cancelled, reason := globalJobCancels.Cancelled(job.ID); cancelled {
+		return cancelledResult(job.ID, reason), nil
+	}
+	result, afterSubmit := coreJobHandler(ctx, job, logSender)
+	if cancelled, reason := globalJobCancels.Cancelled(job.ID); cancelled && result.Status != "success" {
+		return cancelledResult(job.ID, reason), nil
+	}
+	return result, afterSubmit
+}
+
+// runCancellableOrchestratorJob is runCancellableJob for orchestrator jobs.
+func runCancellableOrchestratorJob(job jobs.Job) orchestratorResult {
+	ctx, done := globalJobCancels.Begin(context.Background(), job.ID)
+	defer done()
+	if cancelled, reason := globalJobCancels.Cancelled(job.ID); cancelled {
+		return cancelledOrchestratorResult(reason)
+	}
+	result := orchestratorJobHandler(ctx, job)
+	if cancelled, reason := globalJobCancels.Cancelled(job.ID); cancelled && result.status != "success" {
+		return cancelledOrchestratorResult(reason)
+	}
+	return result
+}
+
+var jobCommandRunner = runJobCommandOutput
+
+// runJobCommandOutput runs a command bound to the job's cancellation context; the
+// whole process group is killed when the job is cancelled.
+func runJobCommandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	output, err := StreamCommand(ctx, cmd, "", nil)
+	if err != nil {
+		if isJobCancelled(err) {
+			return output, errJobCancelled
+		}
+		return output, fmt.Errorf("%s %s failed: %w (%s)", name, strings.Join(args, " "), err, strings.TrimSpace(output))
+	}
+	return output, nil
+}
+
+func runJobCommand(ctx context.Context, name string, args ...string) error {
+	_, err := jobCommandRunner(ctx, name, args...)
+	return err
+}
+
+func isJobCancelled(err error) bool {
+	return errors.Is(err, errJobCancelled) || errors.Is(err, context.Canceled)
+}
+
+func cancelledResult(jobID, reason string) jobs.Result {
+	if strings.TrimSpace(reason) == "" {
+		reason = defaultJobCancelReason
+	}
+	return jobs.Result{
+		JobID:  jobID,
+		Status: jobCancelledStatus,
+		Output: map[string]string{
+			"message":    reason,
+			"error_code": jobCancelledErrorCode,
+		},
+		Completed: time.Now().UTC(),
+	}
+}
+
+func cancelledOrchestratorResult(reason string) orchestratorResult {
+	if strings.TrimSpace(reason) == "" {
+		reason = defaultJobCancelReason
+	}
+	return orchestratorResult{
+		status:        jobCancelledStatus,
+		errorText:     fmt.Sprintf("%s: %s", jobCancelledErrorCode, reason),
+		resultPayload: map[string]any{"error_code": jobCancelledErrorCode},
+	}
+}
+
+// handleJobCancel handles job.cancel. The target job keeps running until its handler
+// observes the cancelled context; its own result is then reported as cancelled.
+func handleJobCancel(job jobs.Job) (jobs.Result, func() error) {
+	targetID := payloadValue(job.Payload, "target_job_id", "cancel_job_id", "job_id")
+	if targetID == "" {
+		return failureResult(job.ID, fmt.Errorf("missing target_job_id"))
+	}
+	if targetID == job.ID {
+		return failureResult(job.ID, fmt.Errorf("job cannot cancel itself"))
+	}
+	reason := payloadValue(job.Payload, "reason")
+	running := globalJobCancels.Cancel(targetID, reason)
+	state := "queued"
+	if running {
+		state = "running"
+	} else if globalInstanceTaskResumes.Cancel(targetID) {
+		state = "waiting"
+	}
+	return jobs.Result{
+		JobID:  job.ID,
+		Status: "success",
+		Output: map[string]string{
+			"message":       "cancellation requested",
+			"target_job_id": targetID,
+			"target_state":  state,
+		},
+		Completed: time.Now().UTC(),
+	}, nil
+}
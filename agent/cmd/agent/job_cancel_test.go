@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestJobCancelRegistryCancelsRunningJob(t *testing.T) {
+	registry := newJobCancelRegistry()
+	ctx, done := registry.Begin(context.Background(), "job-1")
+	defer done()
+
+	if !registry.Cancel("job-1", "operator abort") {
+		t.Fatal("expected running job to be cancelled")
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected job context to be cancelled")
+	}
+	cancelled, reason := registry.Cancelled("job-1")
+	if !cancelled || reason != "operator abort" {
+		t.Fatalf("unexpected cancellation state: %v %q", cancelled, reason)
+	}
+}
+
+func TestJobCancelRegistryRemembersQueuedJob(t *testing.T) {
+	registry := newJobCancelRegistry()
+	if registry.Cancel("queued", "") {
+		t.Fatal("expected queued job not to be reported as running")
+	}
+	ctx, done := registry.Begin(context.Background(), "queued")
+	defer done()
+	if ctx.Err() == nil {
+		t.Fatal("expected queued job to start with a cancelled context")
+	}
+	if _, reason := registry.Cancelled("queued"); reason != defaultJobCancelReason {
+		t.Fatalf("expected default reason, got %q", reason)
+	}
+}
+
+func TestRunCancellableJobSkipsHandlerOfJobCancelledWhileQueued(t *testing.T) {
+	originalCore, originalOrchestrator := coreJobHandler, orchestratorJobHandler
+	t.Cleanup(func() { coreJobHandler, orchestratorJobHandler = originalCore, originalOrchestrator })
+	called := 0
+	coreJobHandler = func(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+		called++
+		return jobs.Result{JobID: job.ID, Status: "success"}, nil
+	}
+	orchestratorJobHandler = func(ctx context.Context, job jobs.Job) orchestratorResult {
+		called++
+		return orchestratorResult{status: "success"}
+	}
+
+	globalJobCancels.Cancel("queued-core", "operator abort")
+	result, afterSubmit := runCancellableJob(jobs.Job{ID: "queued-core", Type: "instance.start"}, nil)
+	if result.Status != jobCancelledStatus || result.Output["message"] != "operator abort" || afterSubmit != nil {
+		t.Fatalf("expected a cancelled result, got %+v", result)
+	}
+
+	globalJobCancels.Cancel("queued-orchestrator", "")
+	if result := runCancellableOrchestratorJob(jobs.Job{ID: "queued-orchestrator", Type: "instance.start"}); result.status != jobCancelledStatus {
+		t.Fatalf("expected a cancelled orchestrator result, got %+v", result)
+	}
+	if called != 0 {
+		t.Fatalf("expected no handler to run for jobs cancelled while queued, ran %d", called)
+	}
+
+	if result, _ := runCancellableJob(jobs.Job{ID: "not-cancelled", Type: "instance.start"}, nil); result.Status != "success" || called != 1 {
+		t.Fatalf("expected the handler to run for a job that was not cancelled, got %+v", result)
+	}
+}
+
+func TestStreamCommandKillsProcessGroupOnCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process groups are unix only")
+	}
+	jobID := "stream-cancel"
+	ctx, done := globalJobCancels.Begin(context.Background(), jobID)
+	defer done()
+
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	cmd := exec.Command("/bin/sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := StreamCommand(ctx, cmd, jobID, nil)
+		errCh <- err
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(pidFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child process did not start")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	globalJobCancels.Cancel(jobID, "test")
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, errJobCancelled) {
+			t.Fatalf("expected errJobCancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected StreamCommand to return after cancellation")
+	}
+}
+
+func TestCreateTarGzArchiveRemovesPartialArchiveOnCancel(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "world.dat"), []byte("data"), 0o600); err != nil {
+		t.Fatalf("write source: %v", err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "backup.tar.gz")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := createTarGzArchive(ctx, archivePath, source)
+	if !isJobCancelled(err) {
+		t.Fatalf("expected cancellation error, got %v", err)
+	}
+	if _, statErr := os.Stat(archivePath); !os.IsNotExist(statErr) {
+		t.Fatalf("expected partial archive to be removed, stat err=%v", statErr)
+	}
+}
+
+func TestHandleJobCancelRequiresTarget(t *testing.T) {
+	result, _ := handleJob(context.Background(), jobs.Job{ID: "cancel-1", Type: jobCancelJobType, Payload: map[string]any{}}, nil)
+	if result.Status != "failed" {
+		t.Fatalf("expected failure without target, got %#v", result)
+	}
+
+	result, _ = handleJob(context.Background(), jobs.Job{ID: "cancel-2", Type: jobCancelJobType, Payload: map[string]any{"target_job_id": "job-x"}}, nil)
+	if result.Status != "success" || result.Output["target_state"] != "queued" {
+		t.Fatalf("unexpected cancel result: %#v", result)
+	}
+	ctx, done := globalJobCancels.Begin(context.Background(), "job-x")
+	defer done()
+	if ctx.Err() == nil {
+		t.Fatal("expected queued target to be cancelled on start")
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// coreJobTypes lists every job type handleJob dispatches. TestJobTypeListsMatchDispatch
+// keeps it in sync with the switch.
+var coreJobTypes = []string{
+	jobCancelJobType,
+	agentScheduleSyncJobType,
+	agentScheduleDeleteJobType,
+	agentScheduleListJobType,
+	portLeasesListJobType,
+	instanceResourcesApplyJobType,
+	instanceTaskRunJobType,
+	instanceScheduleSyncJobType,
+	instanceScheduleDeleteJobType,
+	instanceScheduleListJobType,
+	instanceCrashReportJobType,
+	instanceCrashResetJobType,
+	"agent.diagnostics",
+	"agent.self_update",
+	"agent.update",
+	"core.ssh.policy.apply",
+	"database.create",
+	"database.delete",
+	"database.grant.apply",
+	"database.password.reset",
+	"database.password.rotate",
+	"database.user.create",
+	"db.ensure_base",
+	"ddos.policy.apply",
+	"ddos.status.check",
+	"dns.ensure_base",
+	"dns.record.create",
+	"dns.record.delete",
+	"dns.record.update",
+	"dns.zone.create",
+	"domain.add",
+	"domain.ssl.issue",
+	"domain.ssl.renew",
+	"domain.ssl.revoke",
+	"fail2ban.policy.apply",
+	"fail2ban.status.check",
+	"firewall.close_ports",
+	"firewall.open_ports",
+	"game.ensure_base",
+	"gdpr.anonymize_user",
+	"instance.addon.install",
+	"instance.addon.remove",
+	"instance.addon.update",
+	"instance.backup.create",
+	"instance.backup.prune",
+	"instance.backup.restore",
+	"instance.config.apply",
+	"instance.console.command",
+	"instance.create",
+	"instance.delete",
+	"instance.disk.scan",
+	"instance.disk.top",
+	"instance.files.delete",
+	"instance.files.list",
+	"instance.files.mkdir",
+	"instance.files.read",
+	"instance.files.write",
+	"instance.logs.tail",
+	"instance.query.check",
+	"instance.reinstall",
+	"instance.restart",
+	"instance.sftp.access.disable",
+	"instance.sftp.access.enable",
+	"instance.sftp.access.keys",
+	"instance.sftp.access.reset_password",
+	"instance.sftp.credentials.reset",
+	"instance.start",
+	"instance.stop",
+	"instance.watchdog.check",
+	"mail.alias.create",
+	"mail.alias.delete",
+	"mail.alias.disable",
+	"mail.alias.enable",
+	"mail.alias.update",
+	"mail.dkim.rotate",
+	"mail.dns.validate",
+	"mail.domain.create",
+	"mail.ensure_base",
+	"mailbox.create",
+	"mailbox.delete",
+	"mailbox.disable",
+	"mailbox.enable",
+	"mailbox.password.reset",
+	"mailbox.policy.update",
+	"mailbox.quota.update",
+	"mariadb.db.create",
+	"mariadb.grant.apply",
+	"mariadb.user.create",
+	"node.disk.stat",
+	"os.reboot",
+	"os.update",
+	"postgres.db.create",
+	"postgres.grant.apply",
+	"postgres.role.create",
+	"role.ensure_base",
+	"roundcube.deploy",
+	"roundcube.install",
+	"security.ensure_base",
+	"security.events.collect",
+	"security.ruleset.apply",
+	"security.ruleset.rollback",
+	"server.reboot.check_required",
+	"server.reboot.run",
+	"server.status.check",
+	"server.update.check",
+	"server.update.run",
+	"sniper.install",
+	"sniper.shared_update",
+	"sniper.update",
+	"ts3.backup",
+	"ts3.create",
+	"ts3.logs.export",
+	"ts3.restart",
+	"ts3.restore",
+	"ts3.slots.set",
+	"ts3.start",
+	"ts3.stop",
+	"ts3.token.reset",
+	"ts3.update",
+	"voice.action.restart",
+	"voice.action.start",
+	"voice.action.stop",
+	"voice.probe",
+	"web.ensure_base",
+	"web.stack_reload",
+	"webspace.apply",
+	"webspace.backup",
+	"webspace.composer.install",
+	"webspace.create",
+	"webspace.cron.update",
+	"webspace.delete",
+	"webspace.domain.apply",
+	"webspace.files.delete",
+	"webspace.files.list",
+	"webspace.files.mkdir",
+	"webspace.files.read",
+	"webspace.files.write",
+	"webspace.git.deploy",
+	"webspace.logs.tail",
+	"webspace.provision",
+	"webspace.restore",
+	"webspace.sftp.credentials.reset",
+	"webspace.update",
+	"windows.service.restart",
+	"windows.service.start",
+	"windows.service.stop",
+}
+
+// orchestratorJobTypes lists every job type handleOrchestratorJob dispatches.
+var orchestratorJobTypes = []string{
+	jobCancelJobType,
+	"admin.ssh_key.store",
+	"musicbot.config.apply",
+	"musicbot.connection.test",
+	"musicbot.health.check",
+	"musicbot.health.repair",
+	"musicbot.install",
+	"musicbot.playback.action",
+	"musicbot.queue.sync",
+	"musicbot.repair",
+	"musicbot.service.action",
+	"musicbot.status",
+	"musicbot.teamspeak_backend.install",
+	"musicbot.teamspeak_backend.install_dependencies",
+	"musicbot.teamspeak_backend.install_official_client",
+	"musicbot.teamspeak_backend.install_sdk_client",
+	"musicbot.teamspeak_backend.repair",
+	"musicbot.teamspeak_backend.status",
+	"musicbot.teamspeak_backend.test_connection",
+	"musicbot.teamspeak_backend.validate",
+	"musicbot.uninstall",
+	"musicbot.update",
+	"sinusbot.install",
+	"sinusbot.service.action",
+	"sinusbot.status",
+	"ts3.install",
+	"ts3.instance.action",
+	"ts3.instance.create",
+	"ts3.service.action",
+	"ts3.status",
+	"ts3.viewer.snapshot",
+	"ts3.virtual.action",
+	"ts3.virtual.ban.delete",
+	"ts3.virtual.ban.list",
+	"ts3.virtual.ban.remove",
+	"ts3.virtual.channel.create",
+	"ts3.virtual.channel.delete",
+	"ts3.virtual.channel.edit",
+	"ts3.virtual.channel.layout.apply",
+	"ts3.virtual.channel.list",
+	"ts3.virtual.channel.move",
+	"ts3.virtual.channel.perm.edit",
+	"ts3.virtual.client.ban",
+	"ts3.virtual.client.kick",
+	"ts3.virtual.client.list",
+	"ts3.virtual.client.poke",
+	"ts3.virtual.create",
+	"ts3.virtual.events.subscribe",
+	"ts3.virtual.events.unsubscribe",
+	"ts3.virtual.file.delete",
+	"ts3.virtual.file.download",
+	"ts3.virtual.file.list",
+	"ts3.virtual.file.upload",
+	"ts3.virtual.list",
+	"ts3.virtual.log.view",
+	"ts3.virtual.migration.decommission",
+	"ts3.virtual.migration.export",
+	"ts3.virtual.migration.import",
+	"ts3.virtual.privilegekey.create",
+	"ts3.virtual.servergroup.client.add",
+	"ts3.virtual.servergroup.client.remove",
+	"ts3.virtual.servergroup.copy",
+	"ts3.virtual.servergroup.create",
+	"ts3.virtual.servergroup.delete",
+	"ts3.virtual.servergroup.list",
+	"ts3.virtual.servergroup.perm.edit",
+	"ts3.virtual.servergroup.rename",
+	"ts3.virtual.snapshot.create",
+	"ts3.virtual.snapshot.restore",
+	"ts3.virtual.summary",
+	"ts3.virtual.token.rotate",
+	"ts6.install",
+	"ts6.instance.action",
+	"ts6.instance.create",
+	"ts6.service.action",
+	"ts6.status",
+	"ts6.viewer.snapshot",
+	"ts6.virtual.action",
+	"ts6.virtual.ban.delete",
+	"ts6.virtual.ban.list",
+	"ts6.virtual.ban.remove",
+	"ts6.virtual.channel.create",
+	"ts6.virtual.channel.delete",
+	"ts6.virtual.channel.edit",
+	"ts6.virtual.channel.layout.apply",
+	"ts6.virtual.channel.list",
+	"ts6.virtual.channel.move",
+	"ts6.virtual.channel.perm.edit",
+	"ts6.virtual.client.ban",
+	"ts6.virtual.client.kick",
+	"ts6.virtual.client.list",
+	"ts6.virtual.client.poke",
+	"ts6.virtual.create",
+	"ts6.virtual.events.subscribe",
+	"ts6.virtual.events.unsubscribe",
+	"ts6.virtual.file.delete",
+	"ts6.virtual.file.download",
+	"ts6.virtual.file.list",
+	"ts6.virtual.file.upload",
+	"ts6.virtual.list",
+	"ts6.virtual.log.view",
+	"ts6.virtual.migration.decommission",
+	"ts6.virtual.migration.export",
+	"ts6.virtual.migration.import",
+	"ts6.virtual.privilegekey.create",
+	"ts6.virtual.servergroup.client.add",
+	"ts6.virtual.servergroup.client.remove",
+	"ts6.virtual.servergroup.copy",
+	"ts6.virtual.servergroup.create",
+	"ts6.virtual.servergroup.delete",
+	"ts6.virtual.servergroup.list",
+	"ts6.virtual.servergroup.perm.edit",
+	"ts6.virtual.servergroup.rename",
+	"ts6.virtual.snapshot.create",
+	"ts6.virtual.snapshot.restore",
+	"ts6.virtual.summary",
+	"ts6.virtual.token.rotate",
+}
+
+// untypedJobSchemaVersion is advertised for job types that have no declared payload
+// schema yet.
+const untypedJobSchemaVersion = "0"
+
+// collectJobCapabilities reports the job types this agent build can run on this
+// platform, per polling channel, with the version of each payload schema. The panel
+// uses it to avoid dispatching jobs an older agent cannot run during rolling upgrades.
+func collectJobCapabilities() map[string]any {
+	core := advertisedJobTypes(coreJobTypes)
+	orchestrator := advertisedJobTypes(orchestratorJobTypes)
+	encoded, _ := json.Marshal([]map[string]string{core, orchestrator})
+	sum := sha256.Sum256(encoded)
+	return map[string]any{
+		"version":      hex.EncodeToString(sum[:8]),
+		"core":         core,
+		"orchestrator": orchestrator,
+	}
+}
+
+func advertisedJobTypes(jobTypes []string) map[string]string {
+	advertised := make(map[string]string, len(jobTypes))
+	for _, jobType := range jobTypes {
+		if ensureJobSupportedByPlatform(jobType) != nil {
+			continue
+		}
+		advertised[jobType] = globalJobSchemas.SchemaVersion(jobType)
+	}
+	for alias, canonical := range jobTypeAliases {
+		if version, ok := advertised[canonical]; ok {
+			advertised[alias] = version
+		}
+	}
+	return advertised
+}
+
+// collectAgentFeatures reports optional features that depend on the host rather than
+// on the job type list.
+func collectAgentFeatures(ts6Supported bool) map[string]bool {
+	return map[string]bool{
+		"ts6":                ts6Supported,
+		"pty_console":        runtime.GOOS != "windows",
+		"shared_storage":     sharedStorageSupported(),
+		"windows_service":    runtime.GOOS == "windows",
+		"job_cancel":         true,
+		"agent_schedule":     true,
+		"offline_spool":      true,
+		"payload_schemas":    true,
+		"update_rollback":    globalAgentUpdate.systemd,
+		"job_push":           true,
+		"backup_dedup":       true,
+		"backup_s3":          true,
+		"backup_sftp":        true,
+		"backup_encryption":  true,
+		"backup_hooks":       true,
+		"port_leases":        true,
+		"resource_profiles":  true,
+		"instance_schedules": true,
+		"container_runtime":  runtime.GOOS == "linux" && commandExists("podman"),
+		"crash_reports":      runtime.GOOS == "linux",
+		"ts_query_events":    true,
+		"ts_migration":       true,
+		"ts_file_transfer":   true,
+	}
+}
+
+// sharedStorageSupported reports whether bind and overlay mounts for shared_paths can
+// be set up: they need Linux, root and the mount binary.
+func sharedStorageSupported() bool {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		return false
+	}
+	_, err := exec.LookPath("mount")
+	return err == nil
+}
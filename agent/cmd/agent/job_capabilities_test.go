@@ -0,0 +1,147 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dispatchedJobTypes collects the case labels of a dispatch function, resolving
+// string constants declared anywhere in the package.
+func dispatchedJobTypes(t *testing.T, fileName, funcName string) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	constants := map[string]string{}
+	var target *ast.File
+	paths, _ := filepath.Glob("*.go")
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatalf("parse %s: %v", path, err)
+		}
+		if path == fileName {
+			target = file
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			spec, ok := node.(*ast.ValueSpec)
+			if !ok {
+				return true
+			}
+			for idx, name := range spec.Names {
+				if idx < len(spec.Values) {
+					if lit, ok := spec.Values[idx].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						constants[name.Name], _ = strconv.Unquote(lit.Value)
+					}
+				}
+			}
+			return true
+		})
+	}
+	if target == nil {
+		t.Fatalf("%s not found", fileName)
+	}
+
+	seen := map[string]bool{}
+	for _, decl := range target.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != funcName {
+			continue
+		}
+		ast.Inspect(fn.Body, func(node ast.Node) bool {
+			clause, ok := node.(*ast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, expr := range clause.List {
+				switch value := expr.(type) {
+				case *ast.BasicLit:
+					label, _ := strconv.Unquote(value.Value)
+					seen[label] = true
+				case *ast.Ident:
+					if label, ok := constants[value.Name]; ok {
+						seen[label] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	list := make([]string, 0, len(seen))
+	for label := range seen {
+		list = append(list, label)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func assertSameJobTypes(t *testing.T, name string, declared, dispatched []string) {
+	t.Helper()
+	declaredSet := map[string]bool{}
+	for _, jobType := range declared {
+		if declaredSet[jobType] {
+			t.Fatalf("%s: duplicate job type %s", name, jobType)
+		}
+		declaredSet[jobType] = true
+	}
+	for _, jobType := range dispatched {
+		if !declaredSet[jobType] {
+			t.Fatalf("%s: job type %s is dispatched but not advertised", name, jobType)
+		}
+		delete(declaredSet, jobType)
+	}
+	for jobType := range declaredSet {
+		t.Fatalf("%s: job type %s is advertised but not dispatched", name, jobType)
+	}
+}
+
+func TestJobTypeListsMatchDispatch(t *testing.T) {
+	if _, err := os.Stat("main.go"); err != nil {
+		t.Skip("source files not available")
+	}
+	assertSameJobTypes(t, "core", coreJobTypes, dispatchedJobTypes(t, "main.go", "handleJob"))
+	assertSameJobTypes(t, "orchestrator", orchestratorJobTypes, dispatchedJobTypes(t, "orchestrator_jobs.go", "handleOrchestratorJob"))
+}
+
+func TestCollectJobCapabilitiesAdvertisesSchemasAndPlatform(t *testing.T) {
+	capabilities := collectJobCapabilities()
+	core := capabilities["core"].(map[string]string)
+	orchestrator := capabilities["orchestrator"].(map[string]string)
+
+	if version := core["dns.record.create"]; version == "" || version == untypedJobSchemaVersion {
+		t.Fatalf("expected typed schema version for dns.record.create, got %q", version)
+	}
+	if core["instance.files.upload"] != core["instance.files.write"] {
+		t.Fatal("expected job type aliases to be advertised with their canonical schema version")
+	}
+	for jobType, version := range core {
+		if version == untypedJobSchemaVersion {
+			t.Fatalf("expected %s to advertise a typed schema version", jobType)
+		}
+	}
+	if globalJobSchemas.SchemaVersion("unknown.job") != untypedJobSchemaVersion {
+		t.Fatalf("expected unknown job types to advertise %q", untypedJobSchemaVersion)
+	}
+	if _, ok := orchestrator["ts3.virtual.create"]; !ok {
+		t.Fatal("expected orchestrator job types to be advertised")
+	}
+	if _, ok := core["ts3.virtual.create"]; ok {
+		t.Fatal("expected orchestrator-only job types to stay out of the core channel")
+	}
+	_, windowsService := core["windows.service.start"]
+	if windowsService != (runtime.GOOS == "windows") {
+		t.Fatalf("expected windows.service.* only on windows, got %v on %s", windowsService, runtime.GOOS)
+	}
+	if capabilities["version"] != collectJobCapabilities()["version"] {
+		t.Fatal("expected capability version to be deterministic")
+	}
+}
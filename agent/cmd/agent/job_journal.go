@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/logging"
+)
+
+// jobJournalState is the lifecycle stage a job reached before the entry was written.
+type jobJournalState string
+
+const (
+	jobJournalReceived  jobJournalState = "received"
+	jobJournalStarted   jobJournalState = "started"
+	jobJournalFinished  jobJournalState = "finished"
+	jobJournalSubmitted jobJournalState = "submitted"
+	jobJournalDiscarded jobJournalState = "discarded"
+)
+
+// jobJournalKind separates core jobs (/agent/jobs) from orchestrator jobs (/agent/{id}/jobs),
+// since results for the two are reported through different endpoints.
+type jobJournalKind string
+
+const (
+	jobJournalKindCore         jobJournalKind = "core"
+	jobJournalKindOrchestrator jobJournalKind = "orchestrator"
+)
+
+const (
+	jobJournalFileName         = "job_journal.jsonl"
+	jobJournalCompactThreshold = 256
+	agentRestartedErrorCode    = "AGENT_RESTARTED"
+)
+
+type jobJournalEntry struct {
+	JobID         string           `json:"job_id"`
+	Kind          jobJournalKind   `json:"kind"`
+	Type          string           `json:"type,omitempty"`
+	State         jobJournalState  `json:"state"`
+	At            time.Time        `json:"at"`
+	CorrelationID string           `json:"correlation_id,omitempty"`
+	Result        *jobs.Result     `json:"result,omitempty"`
+	Finish        *journaledFinish `json:"finish,omitempty"`
+}
+
+// journaledFinish is the persisted form of an orchestratorResult.
+type journaledFinish struct {
+	Status        string         `json:"status"`
+	LogText       string         `json:"log_text,omitempty"`
+	ErrorText     string         `json:"error_text,omitempty"`
+	ResultPayload map[string]any `json:"result_payload,omitempty"`
+}
+
+// jobJournal is an append-only write-ahead log of job state transitions. Every
+// entry is fsynced before the call returns so that a crash, OOM kill or self-update
+// never loses a job that the panel already handed to this agent.
+type jobJournal struct {
+	mu              sync.Mutex
+	path            string
+	file            *os.File
+	pending         map[string]jobJournalEntry
+	recovered       map[string]struct{}
+	submittedSince  int
+	compactInterval int
+}
+
+func openJobJournal(dir string) (*jobJournal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+	journal := &jobJournal{
+		path:            filepath.Join(dir, jobJournalFileName),
+		pending:         map[string]jobJournalEntry{},
+		recovered:       map[string]struct{}{},
+		compactInterval: jobJournalCompactThreshold,
+	}
+	entries, err := readJobJournal(journal.path)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		journal.apply(entry)
+	}
+	for jobID := range journal.pending {
+		journal.recovered[jobID] = struct{}{}
+	}
+	if err := journal.compactLocked(); err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+func readJobJournal(path string) ([]jobJournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+	defer file.Close()
+
+	entries := make([]jobJournalEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry jobJournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			// A torn trailing write from a crash is expected; skip it.
+			continue
+		}
+		if entry.JobID == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan journal: %w", err)
+	}
+	return entries, nil
+}
+
+func (j *jobJournal) apply(entry jobJournalEntry) {
+	if entry.State == jobJournalSubmitted || entry.State == jobJournalDiscarded {
+		delete(j.pending, entry.JobID)
+		delete(j.recovered, entry.JobID)
+		return
+	}
+	previous, ok := j.pending[entry.JobID]
+	if ok {
+		if entry.Kind == "" {
+			entry.Kind = previous.Kind
+		}
+		if entry.Type == "" {
+			entry.Type = previous.Type
+		}
+		if entry.CorrelationID == "" {
+			entry.CorrelationID = previous.CorrelationID
+		}
+	}
+	j.pending[entry.JobID] = entry
+}
+
+// Record appends a state transition. A nil journal is a no-op so callers do not
+// have to guard against a journal that failed to open.
+func (j *jobJournal) Record(entry jobJournalEntry) error {
+	if j == nil || entry.JobID == "" {
+		return nil
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now().UTC()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.appendLocked(entry); err != nil {
+		return err
+	}
+	j.apply(entry)
+	if entry.State == jobJournalSubmitted || entry.State == jobJournalDiscarded {
+		j.submittedSince++
+		if j.submittedSince >= j.compactInterval {
+			return j.compactLocked()
+		}
+	}
+	return nil
+}
+
+func (j *jobJournal) Received(kind jobJournalKind, job jobs.Job) error {
+	return j.Record(jobJournalEntry{JobID: job.ID, Kind: kind, Type: job.Type, State: jobJournalReceived, CorrelationID: job.CorrelationID})
+}
+
+func (j *jobJournal) Started(kind jobJournalKind, jobID string) error {
+	return j.Record(jobJournalEntry{JobID: jobID, Kind: kind, State: jobJournalStarted})
+}
+
+func (j *jobJournal) FinishedCore(result jobs.Result) error {
+	resultCopy := result
+	return j.Record(jobJournalEntry{JobID: result.JobID, Kind: jobJournalKindCore, State: jobJournalFinished, Result: &resultCopy})
+}
+
+func (j *jobJournal) FinishedOrchestrator(jobID string, result orchestratorResult) error {
+	return j.Record(jobJournalEntry{JobID: jobID, Kind: jobJournalKindOrchestrator, State: jobJournalFinished, Finish: &journaledFinish{
+		Status:        result.status,
+		LogText:       result.logText,
+		ErrorText:     result.errorText,
+		ResultPayload: result.resultPayload,
+	}})
+}
+
+func (j *jobJournal) Submitted(kind jobJournalKind, jobID string) error {
+	return j.Record(jobJournalEntry{JobID: jobID, Kind: kind, State: jobJournalSubmitted})
+}
+
+// Discard drops a job that never ran, e.g. because the panel refused to start it.
+func (j *jobJournal) Discard(kind jobJournalKind, jobID string) error {
+	return j.Record(jobJournalEntry{JobID: jobID, Kind: kind, State: jobJournalDiscarded})
+}
+
+// Recovered returns the jobs left behind by a previous agent process that still
+// have to be reported to the panel.
+func (j *jobJournal) Recovered() []jobJournalEntry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]jobJournalEntry, 0, len(j.recovered))
+	for _, entry := range j.pendingLocked() {
+		if _, ok := j.recovered[entry.JobID]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Pending returns every job that has not reached the submitted state, oldest first.
+func (j *jobJournal) Pending() []jobJournalEntry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pendingLocked()
+}
+
+// Has reports whether the job is still tracked, i.e. received but not yet submitted.
+func (j *jobJournal) Has(jobID string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, ok := j.pending[jobID]
+	return ok
+}
+
+func (j *jobJournal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func (j *jobJournal) pendingLocked() []jobJournalEntry {
+	entries := make([]jobJournalEntry, 0, len(j.pending))
+	for _, entry := range j.pending {
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].At.Equal(entries[b].At) {
+			return entries[a].JobID < entries[b].JobID
+		}
+		return entries[a].At.Before(entries[b].At)
+	})
+	return entries
+}
+
+func (j *jobJournal) appendLocked(entry jobJournalEntry) error {
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open journal: %w", err)
+		}
+		j.file = file
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode journal entry: %w", err)
+	}
+	encoded = append(encoded, '\n')
+	if _, err := j.file.Write(encoded); err != nil {
+		return fmt.Errorf("write journal entry: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+// compactLocked rewrites the journal so it only holds the latest entry of every
+// pending job. The rewrite goes through a temp file and rename to stay crash safe.
+func (j *jobJournal) compactLocked() error {
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file = nil
+	}
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("open journal temp file: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range j.pendingLocked() {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmpPath)
+			return fmt.Errorf("encode journal entry: %w", err)
+		}
+		_, _ = writer.Write(encoded)
+		_ = writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("write journal temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("sync journal temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("close journal temp file: %w", err)
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace journal: %w", err)
+	}
+	j.submittedSince = 0
+	return nil
+}
+
+// interruptedCoreResult builds the failure reported for a core job that was
+// received or running when the previous agent process died.
+func interruptedCoreResult(entry jobJournalEntry) jobs.Result {
+	return jobs.Result{
+		JobID:  entry.JobID,
+		Status: "failed",
+		Output: map[string]string{
+			"message":    fmt.Sprintf("agent restarted while job was %s", entry.State),
+			"error_code": agentRestartedErrorCode,
+		},
+		Completed: time.Now().UTC(),
+	}
+}
+
+// interruptedOrchestratorResult is the orchestrator counterpart of interruptedCoreResult.
+func interruptedOrchestratorResult(entry jobJournalEntry) orchestratorResult {
+	return orchestratorResult{
+		status:    "failed",
+		errorText: fmt.Sprintf("%s: agent restarted while job was %s", agentRestartedErrorCode, entry.State),
+		resultPayload: map[string]any{
+			"error_code": agentRestartedErrorCode,
+		},
+	}
+}
+
+// jobResultReporter is the subset of the API client used to report job outcomes.
+type jobResultReporter interface {
+	SubmitJobResult(ctx context.Context, result jobs.Result) error
+	FinishAgentJob(ctx context.Context, agentID, jobID, status string, logText string, errorText string, resultPayload map[string]any) error
+}
+
+// replayJobJournal reports every job a previous agent process left behind. Jobs that
+// finished but whose result never reached the panel are resent unchanged; jobs that
+// were received or running are reported as failed with AGENT_RESTARTED. Entries that
+// cannot be delivered stay in the journal and are retried on the next call.
+func replayJobJournal(ctx context.Context, reporter jobResultReporter, agentID string, journal *jobJournal, spool *offlineSpool, logger *logging.JSONLogger) int {
+	delivered := 0
+	for _, entry := range journal.Recovered() {
+		if spool.HasJob(entry.JobID) {
+			// The spool delivers this result in order and marks the journal itself.
+			continue
+		}
+		var err error
+		switch entry.Kind {
+		case jobJournalKindOrchestrator:
+			result := interruptedOrchestratorResult(entry)
+			if entry.State == jobJournalFinished && entry.Finish != nil {
+				result = orchestratorResult{status: entry.Finish.Status, logText: entry.Finish.LogText, errorText: entry.Finish.ErrorText, resultPayload: entry.Finish.ResultPayload}
+			}
+			err = reporter.FinishAgentJob(ctx, agentID, entry.JobID, result.status, result.logText, result.errorText, result.resultPayload)
+		default:
+			result := interruptedCoreResult(entry)
+			if entry.State == jobJournalFinished && entry.Result != nil {
+				result = *entry.Result
+			}
+			err = reporter.SubmitJobResult(ctx, result)
+		}
+		if panelAlreadyAccepted(err) {
+			err = nil
+		}
+		fields := map[string]any{"job_id": entry.JobID, "job_type": entry.Type, "journal_state": string(entry.State)}
+		if err != nil {
+			if logger != nil {
+				logger.Error(ctx, "agent.job_journal_replay_failed", "JOB_JOURNAL_REPLAY_FAILED", fmt.Sprintf("report journaled job failed: %v", err), fields)
+			}
+			continue
+		}
+		if logger != nil {
+			logger.Info(ctx, "agent.job_journal_replayed", "reported journaled job from previous agent run", fields)
+		}
+		if err := journal.Submitted(entry.Kind, entry.JobID); err != nil && logger != nil {
+			logger.Error(ctx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), fields)
+		}
+		delivered++
+	}
+	return delivered
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+type fakeJobResultReporter struct {
+	coreResults   []jobs.Result
+	finished      map[string]orchestratorResult
+	failSubmit    bool
+	failOrchestra bool
+}
+
+func (f *fakeJobResultReporter) SubmitJobResult(_ context.Context, result jobs.Result) error {
+	if f.failSubmit {
+		return errors.New("panel unreachable")
+	}
+	f.coreResults = append(f.coreResults, result)
+	return nil
+}
+
+func (f *fakeJobResultReporter) FinishAgentJob(_ context.Context, _ string, jobID, status string, logText string, errorText string, resultPayload map[string]any) error {
+	if f.failOrchestra {
+		return errors.New("panel unreachable")
+	}
+	if f.finished == nil {
+		f.finished = map[string]orchestratorResult{}
+	}
+	f.finished[jobID] = orchestratorResult{status: status, logText: logText, errorText: errorText, resultPayload: resultPayload}
+	return nil
+}
+
+func TestJobJournalReplaysInterruptedAndUnsubmittedJobs(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "reinstall", Type: "instance.reinstall"})
+	_ = journal.Started(jobJournalKindCore, "reinstall")
+
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "backup", Type: "instance.backup.create"})
+	_ = journal.Started(jobJournalKindCore, "backup")
+	_ = journal.FinishedCore(jobs.Result{JobID: "backup", Status: "success", Output: map[string]string{"archive": "/tmp/a.tar.gz"}})
+
+	_ = journal.Received(jobJournalKindOrchestrator, jobs.Job{ID: "ts3", Type: "ts3.virtual.create"})
+
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "done", Type: "instance.start"})
+	_ = journal.Started(jobJournalKindCore, "done")
+	_ = journal.FinishedCore(jobs.Result{JobID: "done", Status: "success"})
+	_ = journal.Submitted(jobJournalKindCore, "done")
+	_ = journal.Close()
+
+	reopened, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("reopen journal: %v", err)
+	}
+	defer reopened.Close()
+
+	if got := len(reopened.Recovered()); got != 3 {
+		t.Fatalf("expected 3 recovered jobs, got %d", got)
+	}
+
+	reporter := &fakeJobResultReporter{}
+	if delivered := replayJobJournal(context.Background(), reporter, "agent-1", reopened, nil, nil); delivered != 3 {
+		t.Fatalf("expected 3 delivered jobs, got %d", delivered)
+	}
+
+	byID := map[string]jobs.Result{}
+	for _, result := range reporter.coreResults {
+		byID[result.JobID] = result
+	}
+	if got := byID["reinstall"]; got.Status != "failed" || got.Output["error_code"] != agentRestartedErrorCode {
+		t.Fatalf("expected interrupted job to fail with %s, got %#v", agentRestartedErrorCode, got)
+	}
+	if got := byID["backup"]; got.Status != "success" || got.Output["archive"] != "/tmp/a.tar.gz" {
+		t.Fatalf("expected finished job result to be resent unchanged, got %#v", got)
+	}
+	if _, ok := byID["done"]; ok {
+		t.Fatal("expected submitted job to stay out of the replay")
+	}
+	ts3 := reporter.finished["ts3"]
+	if ts3.status != "failed" || !strings.Contains(ts3.errorText, agentRestartedErrorCode) {
+		t.Fatalf("expected orchestrator job to fail with %s, got %#v", agentRestartedErrorCode, ts3)
+	}
+
+	if pending := reopened.Pending(); len(pending) != 0 {
+		t.Fatalf("expected empty journal after replay, got %#v", pending)
+	}
+}
+
+func TestJobJournalKeepsUndeliveredEntriesForNextReplay(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "job-1", Type: "sniper.update"})
+	_ = journal.Close()
+
+	reopened, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("reopen journal: %v", err)
+	}
+	defer reopened.Close()
+
+	if delivered := replayJobJournal(context.Background(), &fakeJobResultReporter{failSubmit: true}, "agent-1", reopened, nil, nil); delivered != 0 {
+		t.Fatalf("expected no delivery while panel is down, got %d", delivered)
+	}
+	if !reopened.Has("job-1") {
+		t.Fatal("expected job to remain journaled after failed delivery")
+	}
+	if delivered := replayJobJournal(context.Background(), &fakeJobResultReporter{}, "agent-1", reopened, nil, nil); delivered != 1 {
+		t.Fatalf("expected delivery once panel is back, got %d", delivered)
+	}
+}
+
+func TestJobJournalRecoveredExcludesJobsOfCurrentProcess(t *testing.T) {
+	journal, err := openJobJournal(t.TempDir())
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	defer journal.Close()
+
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "running", Type: "instance.reinstall"})
+	if recovered := journal.Recovered(); len(recovered) != 0 {
+		t.Fatalf("expected running job not to be treated as interrupted, got %#v", recovered)
+	}
+	if !journal.Has("running") {
+		t.Fatal("expected running job to be tracked")
+	}
+}
+
+func TestJobJournalSkipsTornTrailingLine(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"job_id":"job-1","kind":"core","type":"instance.start","state":"started","at":"2026-01-01T00:00:00Z"}` + "\n" + `{"job_id":"job-2","ki`
+	if err := os.WriteFile(filepath.Join(dir, jobJournalFileName), []byte(content), 0o600); err != nil {
+		t.Fatalf("write journal: %v", err)
+	}
+
+	journal, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	defer journal.Close()
+
+	recovered := journal.Recovered()
+	if len(recovered) != 1 || recovered[0].JobID != "job-1" || recovered[0].Type != "instance.start" {
+		t.Fatalf("unexpected recovered entries: %#v", recovered)
+	}
+}
+
+func TestJobJournalCompactsAfterThreshold(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	defer journal.Close()
+	journal.compactInterval = 2
+
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "keep", Type: "instance.reinstall"})
+	for _, id := range []string{"a", "b"} {
+		_ = journal.Received(jobJournalKindCore, jobs.Job{ID: id, Type: "instance.start"})
+		_ = journal.Submitted(jobJournalKindCore, id)
+	}
+
+	entries, err := readJobJournal(filepath.Join(dir, jobJournalFileName))
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	if len(entries) != 1 || entries[0].JobID != "keep" {
+		t.Fatalf("expected compacted journal with only pending job, got %#v", entries)
+	}
+}
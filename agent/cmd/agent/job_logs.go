@@ -16,13 +16,14 @@ type JobLogSender interface {
 
 type apiJobLogSender struct {
 	client *api.Client
+	spool  *offlineSpool
 }
 
-func newApiJobLogSender(client *api.Client) JobLogSender {
+func newApiJobLogSender(client *api.Client, spool *offlineSpool) JobLogSender {
 	if client == nil {
 		return nil
 	}
-	return &apiJobLogSender{client: client}
+	return &apiJobLogSender{client: client, spool: spool}
 }
 
 func (sender *apiJobLogSender) Send(jobID string, lines []string, progress *int) {
@@ -34,6 +35,9 @@ func (sender *apiJobLogSender) Send(jobID string, lines []string, progress *int)
 
 	if err := sender.client.SubmitJobLogs(ctx, jobID, lines, progress); err != nil {
 		log.Printf("submit job logs failed job_id=%s lines=%d err=%v", jobID, len(lines), err)
+		if spoolErr := sender.spool.Enqueue(spoolKindJobLogs, jobID, spooledJobLogs{Lines: lines, Progress: progress}); spoolErr != nil {
+			log.Printf("spool job logs failed job_id=%s err=%v", jobID, spoolErr)
+		}
 	}
 }
 
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/api"
+	"easywi/agent/internal/logging"
+)
+
+const (
+	jobPushMinBackoff = time.Second
+	jobPushMaxBackoff = time.Minute
+	// jobPushUnsupportedBackoff applies when the panel rejects the handshake
+	// outright, e.g. a panel version without the stream endpoint.
+	jobPushUnsupportedBackoff = 10 * time.Minute
+	// jobPushReconcileInterval is how often the agent still polls while the
+	// push channel is connected, so a job lost between panel and channel is
+	// picked up eventually.
+	jobPushReconcileInterval = 5 * time.Minute
+)
+
+// jobStream is the part of *api.JobStream the push channel needs.
+type jobStream interface {
+	Next() (api.PushMessage, error)
+	Close() error
+}
+
+// jobPushChannel keeps the panel's job push channel open and hands received
+// jobs to the run loop. When the channel drops it reconnects with back-off,
+// and the run loop falls back to interval polling in the meantime.
+type jobPushChannel struct {
+	open     func(ctx context.Context) (jobStream, error)
+	logger   *logging.JSONLogger
+	messages chan api.PushMessage
+
+	mu             sync.Mutex
+	connected      bool
+	connectedSince time.Time
+	connects       int
+	disconnects    int
+	delivered      int
+	lastError      string
+}
+
+func newJobPushChannel(client *api.Client, logger *logging.JSONLogger) *jobPushChannel {
+	return &jobPushChannel{
+		open: func(ctx context.Context) (jobStream, error) {
+			return client.OpenJobStream(ctx)
+		},
+		logger:   logger,
+		messages: make(chan api.PushMessage),
+	}
+}
+
+// Messages delivers job batches received on the channel.
+func (p *jobPushChannel) Messages() <-chan api.PushMessage {
+	return p.messages
+}
+
+// Connected reports whether jobs currently arrive by push.
+func (p *jobPushChannel) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+// Run keeps the channel connected until ctx is done.
+func (p *jobPushChannel) Run(ctx context.Context) {
+	backoff := jobPushMinBackoff
+	for ctx.Err() == nil {
+		stream, err := p.open(ctx)
+		if err != nil {
+			wait := backoff
+			if api.IsPermanent(err) {
+				wait = jobPushUnsupportedBackoff
+			} else if backoff < jobPushMaxBackoff {
+				backoff *= 2
+			}
+			p.recordError(err)
+			if !sleepContext(ctx, wait) {
+				return
+			}
+			continue
+		}
+
+		backoff = jobPushMinBackoff
+		p.setConnected(true)
+		if p.logger != nil {
+			p.logger.Info(ctx, "agent.job_push_connected", "job push channel connected; polling reduced to reconciliation", nil)
+		}
+		err = p.receive(ctx, stream)
+		_ = stream.Close()
+		p.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		p.recordError(err)
+		if p.logger != nil {
+			p.logger.Error(ctx, "agent.job_push_disconnected", "JOB_PUSH_DISCONNECTED", fmt.Sprintf("job push channel dropped; falling back to polling: %v", err), nil)
+		}
+	}
+}
+
+func (p *jobPushChannel) receive(ctx context.Context, stream jobStream) error {
+	for {
+		message, err := stream.Next()
+		if err != nil {
+			return err
+		}
+		if message.Type != api.PushMessageJobs || len(message.Jobs)+len(message.AgentJobs) == 0 {
+			continue
+		}
+		select {
+		case p.messages <- message:
+			p.mu.Lock()
+			p.delivered += len(message.Jobs) + len(message.AgentJobs)
+			p.mu.Unlock()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *jobPushChannel) setConnected(connected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected = connected
+	if connected {
+		p.connects++
+		p.connectedSince = time.Now().UTC()
+		p.lastError = ""
+		return
+	}
+	p.disconnects++
+	p.connectedSince = time.Time{}
+}
+
+func (p *jobPushChannel) recordError(err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastError = err.Error()
+}
+
+// Stats reports the channel state for the heartbeat.
+func (p *jobPushChannel) Stats() map[string]any {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := map[string]any{
+		"connected":   p.connected,
+		"connects":    p.connects,
+		"disconnects": p.disconnects,
+		"delivered":   p.delivered,
+	}
+	if !p.connectedSince.IsZero() {
+		stats["connected_since"] = p.connectedSince.Format(time.RFC3339)
+	}
+	if p.lastError != "" {
+		stats["last_error"] = p.lastError
+	}
+	return stats
+}
+
+func sleepContext(ctx context.Context, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/api"
+	"easywi/agent/internal/jobs"
+)
+
+type fakeJobStream struct {
+	messages []api.PushMessage
+	closed   bool
+}
+
+func (f *fakeJobStream) Next() (api.PushMessage, error) {
+	if len(f.messages) == 0 {
+		return api.PushMessage{}, errors.New("connection reset")
+	}
+	message := f.messages[0]
+	f.messages = f.messages[1:]
+	return message, nil
+}
+
+func (f *fakeJobStream) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestJobPushChannelDeliversJobsAndReconnects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first := &fakeJobStream{messages: []api.PushMessage{
+		{Type: api.PushMessageJobs},
+		{Type: api.PushMessageJobs, Jobs: []jobs.Job{{ID: "job-1"}}, AgentJobs: []jobs.Job{{ID: "ts3-1"}}},
+	}}
+	opens := make(chan int, 4)
+	channel := newJobPushChannel(nil, nil)
+	channel.open = func(ctx context.Context) (jobStream, error) {
+		opens <- len(opens) + 1
+		if len(opens) == 1 {
+			return first, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	go channel.Run(ctx)
+
+	select {
+	case message := <-channel.Messages():
+		if len(message.Jobs) != 1 || len(message.AgentJobs) != 1 {
+			t.Fatalf("expected empty batch to be skipped, got %#v", message)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for pushed jobs")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(opens) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(opens) < 2 {
+		t.Fatal("expected the channel to reconnect after it dropped")
+	}
+	stats := channel.Stats()
+	if channel.Connected() || !first.closed || stats["disconnects"] != 1 || stats["delivered"] != 2 || stats["last_error"] == nil {
+		t.Fatalf("expected dropped channel to be recorded, got %#v", stats)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+// jobLane is a priority class of the job runner. Lower values are served first.
+type jobLane int
+
+const (
+	jobLaneUnset jobLane = iota
+	jobLaneControl
+	jobLaneProvisioning
+	jobLaneBackground
+)
+
+const (
+	jobQueueCapacity = 200
+	// jobLaneAgingThreshold lets a background job that has waited this long compete
+	// with provisioning jobs, so maintenance work is delayed but never starved.
+	jobLaneAgingThreshold = 2 * time.Minute
+)
+
+var jobLanes = []jobLane{jobLaneControl, jobLaneProvisioning, jobLaneBackground}
+
+func (lane jobLane) String() string {
+	switch lane {
+	case jobLaneControl:
+		return "control"
+	case jobLaneProvisioning:
+		return "provisioning"
+	case jobLaneBackground:
+		return "background"
+	default:
+		return "unset"
+	}
+}
+
+func parseJobLane(value string) jobLane {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "control", "interactive", "high":
+		return jobLaneControl
+	case "provisioning", "normal":
+		return jobLaneProvisioning
+	case "background", "maintenance", "low":
+		return jobLaneBackground
+	default:
+		return jobLaneUnset
+	}
+}
+
+var controlJobTypes = map[string]bool{
+	"instance.start":            true,
+	"instance.stop":             true,
+	"instance.restart":          true,
+	"instance.console.command":  true,
+	"security.ruleset.rollback": true,
+	"firewall.close_ports":      true,
+	"ts3.start":                 true,
+	"ts3.stop":                  true,
+	"ts3.restart":               true,
+	"ts3.virtual.client.kick":   true,
+	"ts3.virtual.client.ban":    true,
+	"ts6.virtual.client.kick":   true,
+	"ts6.virtual.client.ban":    true,
+	"voice.action.start":        true,
+	"voice.action.stop":         true,
+	"voice.action.restart":      true,
+	"windows.service.start":     true,
+	"windows.service.stop":      true,
+	"windows.service.restart":   true,
+	"musicbot.playback.action":  true,
+}
+
+var backgroundJobTypes = map[string]bool{
+	"instance.backup.create":  true,
+	"instance.backup.prune":   true,
+	"instance.query.check":    true,
+	instanceTaskRunJobType:    true,
+	"instance.watchdog.check": true,
+	"node.disk.stat":          true,
+	"security.events.collect": true,
+	"agent.diagnostics":       true,
+	"ts3.backup":              true,
+	"ts3.logs.export":         true,
+	"webspace.backup":         true,
+}
+
+var backgroundJobSuffixes = []string{
+	".scan", ".top", ".list", ".status", ".check", ".summary", ".log.view", ".viewer.snapshot", ".snapshot.create",
+}
+
+// resolveJobLane picks the priority class of a job. The panel may override the
+// classification with a "priority" payload field.
+func resolveJobLane(job jobs.Job) jobLane {
+	if lane := parseJobLane(payloadValue(job.Payload, "priority", "job_priority")); lane != jobLaneUnset {
+		return lane
+	}
+	jobType, _ := normalizeJobType(job.Type)
+	if jobType == jobCancelJobType || controlJobTypes[jobType] ||
+		strings.HasSuffix(jobType, ".service.action") || strings.HasSuffix(jobType, ".instance.action") || strings.HasSuffix(jobType, ".virtual.action") {
+		return jobLaneControl
+	}
+	if backgroundJobTypes[jobType] {
+		return jobLaneBackground
+	}
+	for _, suffix := range backgroundJobSuffixes {
+		if strings.HasSuffix(jobType, suffix) {
+			return jobLaneBackground
+		}
+	}
+	return jobLaneProvisioning
+}
+
+type queuedJobTask struct {
+	task     jobTask
+	enqueued time.Time
+}
+
+// jobLaneQueue holds the tasks of one lane, grouped by customer. Customers are served
+// round-robin so one customer's burst cannot monopolise the lane.
+type jobLaneQueue struct {
+	customers map[string][]queuedJobTask
+	order     []string
+	size      int
+
+	dispatched int
+	waitTotal  time.Duration
+	waitMax    time.Duration
+}
+
+func newJobLaneQueue() *jobLaneQueue {
+	return &jobLaneQueue{customers: map[string][]queuedJobTask{}}
+}
+
+func (q *jobLaneQueue) push(customer string, item queuedJobTask) {
+	if _, ok := q.customers[customer]; !ok {
+		q.order = append(q.order, customer)
+	}
+	q.customers[customer] = append(q.customers[customer], item)
+	q.size++
+}
+
+// oldest returns the enqueue time of the oldest task in the lane.
+func (q *jobLaneQueue) oldest() (time.Time, bool) {
+	var oldest time.Time
+	found := false
+	for _, customer := range q.order {
+		items := q.customers[customer]
+		if len(items) == 0 {
+			continue
+		}
+		if !found || items[0].enqueued.Before(oldest) {
+			oldest = items[0].enqueued
+			found = true
+		}
+	}
+	return oldest, found
+}
+
+// pop takes the next task of the customer at the head of the rotation and moves
+// that customer to the back.
+func (q *jobLaneQueue) pop(now time.Time) (jobTask, bool) {
+	if q.size == 0 {
+		return jobTask{}, false
+	}
+	customer := q.order[0]
+	items := q.customers[customer]
+	item := items[0]
+	q.order = q.order[1:]
+	if len(items) == 1 {
+		delete(q.customers, customer)
+	} else {
+		q.customers[customer] = items[1:]
+		q.order = append(q.order, customer)
+	}
+	q.size--
+
+	wait := now.Sub(item.enqueued)
+	q.dispatched++
+	q.waitTotal += wait
+	if wait > q.waitMax {
+		q.waitMax = wait
+	}
+	return item.task, true
+}
+
+// jobQueue is the bounded, prioritised queue in front of the runner's workers.
+type jobQueue struct {
+	mu    sync.Mutex
+	lanes map[jobLane]*jobLaneQueue
+	slots chan struct{}
+	ready chan struct{}
+	now   func() time.Time
+}
+
+func newJobQueue(capacity int) *jobQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	queue := &jobQueue{
+		lanes: map[jobLane]*jobLaneQueue{},
+		slots: make(chan struct{}, capacity),
+		ready: make(chan struct{}, 1),
+		now:   time.Now,
+	}
+	for _, lane := range jobLanes {
+		queue.lanes[lane] = newJobLaneQueue()
+	}
+	return queue
+}
+
+// Push enqueues a task and blocks while the queue is full, which keeps the poll loop
+// from fetching more work than the agent can hold.
+func (q *jobQueue) Push(task jobTask) {
+	q.slots <- struct{}{}
+	if task.lane == jobLaneUnset {
+		task.lane = resolveJobLane(task.job)
+	}
+	customer := strings.TrimSpace(payloadValue(task.job.Payload, "customer_id"))
+	q.mu.Lock()
+	q.lanes[task.lane].push(customer, queuedJobTask{task: task, enqueued: q.now()})
+	q.mu.Unlock()
+	q.signal()
+}
+
+// Pop returns the next task to run, if any.
+func (q *jobQueue) Pop() (jobTask, bool) {
+	q.mu.Lock()
+	now := q.now()
+	lane := q.nextLaneLocked(now)
+	task, ok := jobTask{}, false
+	remaining := 0
+	if lane != jobLaneUnset {
+		task, ok = q.lanes[lane].pop(now)
+	}
+	for _, laneQueue := range q.lanes {
+		remaining += laneQueue.size
+	}
+	q.mu.Unlock()
+	if ok {
+		<-q.slots
+	}
+	if remaining > 0 {
+		// Wake another idle worker for the remaining tasks.
+		q.signal()
+	}
+	return task, ok
+}
+
+func (q *jobQueue) nextLaneLocked(now time.Time) jobLane {
+	if q.lanes[jobLaneControl].size > 0 {
+		return jobLaneControl
+	}
+	provisioning, hasProvisioning := q.lanes[jobLaneProvisioning].oldest()
+	background, hasBackground := q.lanes[jobLaneBackground].oldest()
+	switch {
+	case hasBackground && now.Sub(background) >= jobLaneAgingThreshold && (!hasProvisioning || background.Before(provisioning)):
+		return jobLaneBackground
+	case hasProvisioning:
+		return jobLaneProvisioning
+	case hasBackground:
+		return jobLaneBackground
+	default:
+		return jobLaneUnset
+	}
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Stats reports queue depth and wait times per lane. Wait figures cover the tasks
+// dispatched since the previous call, so each heartbeat shows its own interval.
+func (q *jobQueue) Stats() map[string]any {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	lanes := map[string]any{}
+	total := 0
+	for _, lane := range jobLanes {
+		laneQueue := q.lanes[lane]
+		total += laneQueue.size
+		entry := map[string]any{
+			"queued":      laneQueue.size,
+			"customers":   len(laneQueue.customers),
+			"dispatched":  laneQueue.dispatched,
+			"max_wait_ms": laneQueue.waitMax.Milliseconds(),
+		}
+		if laneQueue.dispatched > 0 {
+			entry["avg_wait_ms"] = (laneQueue.waitTotal / time.Duration(laneQueue.dispatched)).Milliseconds()
+		} else {
+			entry["avg_wait_ms"] = int64(0)
+		}
+		if oldest, ok := laneQueue.oldest(); ok {
+			entry["oldest_wait_ms"] = now.Sub(oldest).Milliseconds()
+		} else {
+			entry["oldest_wait_ms"] = int64(0)
+		}
+		lanes[lane.String()] = entry
+		laneQueue.dispatched = 0
+		laneQueue.waitTotal = 0
+		laneQueue.waitMax = 0
+	}
+	return map[string]any{
+		"queued":   total,
+		"capacity": cap(q.slots),
+		"lanes":    lanes,
+	}
+}
@@ -173,3 +173,78 @@ func TestJobRunnerStreamLimiterCapsConcurrentStreams(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestJobQueueServesControlLaneFirst(t *testing.T) {
+	queue := newJobQueue(10)
+	queue.Push(jobTask{job: jobs.Job{ID: "scan", Type: "instance.disk.scan"}, handler: func(jobs.Job) {}})
+	queue.Push(jobTask{job: jobs.Job{ID: "create", Type: "instance.create"}, handler: func(jobs.Job) {}})
+	queue.Push(jobTask{job: jobs.Job{ID: "stop", Type: "instance.stop"}, handler: func(jobs.Job) {}})
+	queue.Push(jobTask{job: jobs.Job{ID: "rollback", Type: "security.ruleset.rollback"}, handler: func(jobs.Job) {}})
+
+	var order []string
+	for {
+		task, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, task.job.ID)
+	}
+	want := []string{"stop", "rollback", "create", "scan"}
+	for idx := range want {
+		if idx >= len(order) || order[idx] != want[idx] {
+			t.Fatalf("expected order %v, got %v", want, order)
+		}
+	}
+}
+
+func TestJobQueueRotatesCustomersWithinLane(t *testing.T) {
+	queue := newJobQueue(10)
+	for _, id := range []string{"a1", "a2", "a3"} {
+		queue.Push(jobTask{job: jobs.Job{ID: id, Type: "ts3.virtual.client.list", Payload: map[string]any{"customer_id": "a"}}, handler: func(jobs.Job) {}})
+	}
+	queue.Push(jobTask{job: jobs.Job{ID: "b1", Type: "ts3.virtual.client.list", Payload: map[string]any{"customer_id": "b"}}, handler: func(jobs.Job) {}})
+
+	var order []string
+	for {
+		task, ok := queue.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, task.job.ID)
+	}
+	if len(order) != 4 || order[0] != "a1" || order[1] != "b1" {
+		t.Fatalf("expected customer b to be served second, got %v", order)
+	}
+}
+
+func TestJobQueueAgesBackgroundJobs(t *testing.T) {
+	queue := newJobQueue(10)
+	now := time.Now()
+	queue.now = func() time.Time { return now }
+	queue.Push(jobTask{job: jobs.Job{ID: "scan", Type: "instance.disk.scan"}, handler: func(jobs.Job) {}})
+	now = now.Add(jobLaneAgingThreshold)
+	queue.Push(jobTask{job: jobs.Job{ID: "create", Type: "instance.create"}, handler: func(jobs.Job) {}})
+
+	task, ok := queue.Pop()
+	if !ok || task.job.ID != "scan" {
+		t.Fatalf("expected aged background job first, got %#v", task.job)
+	}
+	stats := queue.Stats()
+	lanes := stats["lanes"].(map[string]any)
+	background := lanes["background"].(map[string]any)
+	if background["dispatched"] != 1 || background["max_wait_ms"] != jobLaneAgingThreshold.Milliseconds() {
+		t.Fatalf("unexpected background stats: %#v", background)
+	}
+	if stats["queued"] != 1 {
+		t.Fatalf("expected one queued job, got %#v", stats["queued"])
+	}
+}
+
+func TestResolveJobLaneHonoursPriorityOverride(t *testing.T) {
+	if lane := resolveJobLane(jobs.Job{Type: "instance.disk.scan", Payload: map[string]any{"priority": "control"}}); lane != jobLaneControl {
+		t.Fatalf("expected payload priority to win, got %s", lane)
+	}
+	if lane := resolveJobLane(jobs.Job{Type: "instance.reinstall"}); lane != jobLaneProvisioning {
+		t.Fatalf("expected provisioning lane, got %s", lane)
+	}
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/apienvelope"
+	"easywi/agent/internal/jobs"
+)
+
+// Job payload schemas are declared as structs. Supported field tags:
+//
+//	payload:"name[,required]"  canonical payload key
+//	alias:"a,b"                alternative keys accepted by the handler
+//	enum:"a|b"                 allowed values (case-insensitive)
+//	min:"1" max:"65535"        numeric bounds for int fields
+//	format:"url"               value format: url, job_id
+//
+// Embedded structs contribute their fields, so job families can share them. A
+// schema struct may implement payloadCrossValidator for rules spanning several fields.
+type payloadCrossValidator interface {
+	validatePayload() []payloadViolation
+}
+
+// payloadViolation is one problem with a job payload.
+type payloadViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type jobSchemaField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+	Min      *int64   `json:"min,omitempty"`
+	Max      *int64   `json:"max,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	index    []int
+}
+
+type jobSchema struct {
+	JobType     string           `json:"type"`
+	Fields      []jobSchemaField `json:"fields"`
+	payloadType reflect.Type
+}
+
+type jobSchemaRegistry struct {
+	schemas map[string]*jobSchema
+}
+
+func newJobSchemaRegistry() *jobSchemaRegistry {
+	return &jobSchemaRegistry{schemas: map[string]*jobSchema{}}
+}
+
+// register declares the payload struct of one or more job types. It panics on a
+// malformed struct so mistakes surface in tests rather than at dispatch time.
+func (r *jobSchemaRegistry) register(payload any, jobTypes ...string) {
+	payloadType := reflect.TypeOf(payload)
+	fields, err := schemaFields(payloadType)
+	if err != nil {
+		panic(fmt.Sprintf("job schema %s: %v", payloadType, err))
+	}
+	seen := map[string]bool{}
+	for _, field := range fields {
+		for _, key := range append([]string{field.Name}, field.Aliases...) {
+			if seen[key] {
+				panic(fmt.Sprintf("job schema %s: key %s is declared twice", payloadType, key))
+			}
+			seen[key] = true
+		}
+	}
+	for _, jobType := range jobTypes {
+		if _, exists := r.schemas[jobType]; exists {
+			panic(fmt.Sprintf("job schema %s: job type %s is registered twice", payloadType, jobType))
+		}
+		r.schemas[jobType] = &jobSchema{JobType: jobType, Fields: fields, payloadType: payloadType}
+	}
+}
+
+func (r *jobSchemaRegistry) lookup(jobType string) (*jobSchema, bool) {
+	canonical, _ := normalizeJobType(jobType)
+	schema, ok := r.schemas[canonical]
+	return schema, ok
+}
+
+func schemaFields(payloadType reflect.Type) ([]jobSchemaField, error) {
+	if payloadType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("payload schema must be a struct")
+	}
+	fields := make([]jobSchemaField, 0, payloadType.NumField())
+	for idx := 0; idx < payloadType.NumField(); idx++ {
+		structField := payloadType.Field(idx)
+		if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
+			embedded, err := schemaFields(structField.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, field := range embedded {
+				field.index = append([]int{idx}, field.index...)
+				fields = append(fields, field)
+			}
+			continue
+		}
+		tag := structField.Tag.Get("payload")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		field := jobSchemaField{Name: name, Required: options == "required", Format: structField.Tag.Get("format"), index: []int{idx}}
+		switch structField.Type.Kind() {
+		case reflect.String:
+			field.Type = "string"
+		case reflect.Int, reflect.Int64:
+			field.Type = "integer"
+		case reflect.Bool:
+			field.Type = "boolean"
+		default:
+			if structField.Type == reflect.TypeOf(json.RawMessage{}) {
+				field.Type = "json"
+				break
+			}
+			return nil, fmt.Errorf("field %s: unsupported type %s", structField.Name, structField.Type)
+		}
+		if aliases := structField.Tag.Get("alias"); aliases != "" {
+			field.Aliases = strings.Split(aliases, ",")
+		}
+		if enum := structField.Tag.Get("enum"); enum != "" {
+			field.Enum = strings.Split(enum, "|")
+		}
+		for tagName, target := range map[string]**int64{"min": &field.Min, "max": &field.Max} {
+			if raw := structField.Tag.Get(tagName); raw != "" {
+				value, err := strconv.ParseInt(raw, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: invalid %s tag: %w", structField.Name, tagName, err)
+				}
+				*target = &value
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+var schemaFieldCache sync.Map
+
+// schemaPayloadValue reads one field of a schema struct from a raw payload,
+// accepting the aliases the struct declares. Shared helpers that are also fed
+// payloads which never passed through prepareJob use it rather than keeping
+// alias lists of their own.
+func schemaPayloadValue(payload map[string]any, schema any, name string) string {
+	schemaType := reflect.TypeOf(schema)
+	cached, ok := schemaFieldCache.Load(schemaType)
+	if !ok {
+		fields, err := schemaFields(schemaType)
+		if err != nil {
+			panic(fmt.Sprintf("job schema %s: %v", schemaType, err))
+		}
+		cached, _ = schemaFieldCache.LoadOrStore(schemaType, fields)
+	}
+	for _, field := range cached.([]jobSchemaField) {
+		if field.Name == name {
+			return payloadValue(payload, append([]string{field.Name}, field.Aliases...)...)
+		}
+	}
+	return payloadValue(payload, name)
+}
+
+// decodeJobPayload fills dst (a pointer to a schema struct) from the payload and
+// returns every violation instead of stopping at the first one.
+func decodeJobPayload(payload map[string]any, dst any) []payloadViolation {
+	target := reflect.ValueOf(dst)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return []payloadViolation{{Field: "", Rule: "schema", Message: "payload schema must be a struct pointer"}}
+	}
+	fields, err := schemaFields(target.Elem().Type())
+	if err != nil {
+		return []payloadViolation{{Field: "", Rule: "schema", Message: err.Error()}}
+	}
+	var violations []payloadViolation
+	for _, field := range fields {
+		violations = append(violations, decodePayloadField(payload, field, target.Elem().FieldByIndex(field.index))...)
+	}
+	if validator, ok := target.Elem().Interface().(payloadCrossValidator); ok {
+		violations = append(violations, validator.validatePayload()...)
+	}
+	return violations
+}
+
+func decodePayloadField(payload map[string]any, field jobSchemaField, target reflect.Value) []payloadViolation {
+	raw := strings.TrimSpace(payloadValue(payload, append([]string{field.Name}, field.Aliases...)...))
+	if raw == "" {
+		if field.Required {
+			return []payloadViolation{{Field: field.Name, Rule: "required", Message: field.Name + " is required"}}
+		}
+		return nil
+	}
+
+	if len(field.Enum) > 0 && !enumContains(field.Enum, raw) {
+		return []payloadViolation{{Field: field.Name, Rule: "enum", Message: fmt.Sprintf("%s must be one of %s", field.Name, strings.Join(field.Enum, ", "))}}
+	}
+	if violation, ok := checkPayloadFormat(field, raw); !ok {
+		return []payloadViolation{violation}
+	}
+
+	switch field.Type {
+	case "string":
+		target.SetString(raw)
+	case "json":
+		target.SetBytes([]byte(raw))
+	case "boolean":
+		switch strings.ToLower(raw) {
+		case "1", "true", "yes", "on":
+			target.SetBool(true)
+		case "0", "false", "no", "off":
+			target.SetBool(false)
+		default:
+			return []payloadViolation{{Field: field.Name, Rule: "type", Message: field.Name + " must be a boolean"}}
+		}
+	case "integer":
+		value, err := parsePayloadInteger(raw)
+		if err != nil {
+			return []payloadViolation{{Field: field.Name, Rule: "type", Message: field.Name + " must be an integer"}}
+		}
+		if field.Min != nil && value < *field.Min {
+			return []payloadViolation{{Field: field.Name, Rule: "min", Message: fmt.Sprintf("%s must be at least %d", field.Name, *field.Min)}}
+		}
+		if field.Max != nil && value > *field.Max {
+			return []payloadViolation{{Field: field.Name, Rule: "max", Message: fmt.Sprintf("%s must be at most %d", field.Name, *field.Max)}}
+		}
+		target.SetInt(value)
+	}
+	return nil
+}
+
+// parsePayloadInteger also accepts whole JSON numbers that payloadString renders
+// in exponent form, e.g. 1e+06 for a large quota.
+func parsePayloadInteger(raw string) (int64, error) {
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err == nil {
+		return value, nil
+	}
+	float, floatErr := strconv.ParseFloat(raw, 64)
+	if floatErr != nil || float != math.Trunc(float) || math.Abs(float) > math.MaxInt64 {
+		return 0, err
+	}
+	return int64(float), nil
+}
+
+func enumContains(values []string, candidate string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkPayloadFormat(field jobSchemaField, raw string) (payloadViolation, bool) {
+	switch field.Format {
+	case "":
+		return payloadViolation{}, true
+	case "url":
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return payloadViolation{Field: field.Name, Rule: "format", Message: field.Name + " must be an http(s) URL"}, false
+		}
+	case "job_id":
+		if strings.ContainsAny(raw, " /\\") {
+			return payloadViolation{Field: field.Name, Rule: "format", Message: field.Name + " must be a job id"}, false
+		}
+	}
+	return payloadViolation{}, true
+}
+
+// validateJobPayload checks a job against its registered schema. Job types without a
+// schema are accepted unchanged.
+func (r *jobSchemaRegistry) validateJobPayload(job jobs.Job) []payloadViolation {
+	schema, ok := r.lookup(job.Type)
+	if !ok {
+		return nil
+	}
+	return decodeJobPayload(job.Payload, reflect.New(schema.payloadType).Interface())
+}
+
+// prepareJob validates a job and returns it with a canonical payload. Every
+// entry point that hands a job to a handler goes through it.
+func (r *jobSchemaRegistry) prepareJob(job jobs.Job) (jobs.Job, []payloadViolation) {
+	if violations := r.validateJobPayload(job); len(violations) > 0 {
+		return job, violations
+	}
+	job.Payload = r.canonicalPayload(job.Type, job.Payload)
+	return job, nil
+}
+
+// canonicalPayload copies the value of the first alias the panel sent into the
+// canonical key of each schema field. Handlers read canonical keys only, so the
+// aliases a job type accepts are declared once, on its schema. The payload is
+// copied; the caller's map is left untouched.
+func (r *jobSchemaRegistry) canonicalPayload(jobType string, payload map[string]any) map[string]any {
+	schema, ok := r.lookup(jobType)
+	if !ok {
+		return payload
+	}
+	canonical := make(map[string]any, len(payload))
+	for key, value := range payload {
+		canonical[key] = value
+	}
+	for _, field := range schema.Fields {
+		if !payloadFieldEmpty(canonical, field.Name) {
+			continue
+		}
+		for _, alias := range field.Aliases {
+			if !payloadFieldEmpty(canonical, alias) {
+				canonical[field.Name] = canonical[alias]
+				break
+			}
+		}
+	}
+	return canonical
+}
+
+// payloadFieldEmpty treats empty lists and objects like a missing key, so an
+// alias carrying the actual list still wins over an empty canonical one.
+func payloadFieldEmpty(payload map[string]any, key string) bool {
+	switch strings.TrimSpace(payloadValue(payload, key)) {
+	case "", "[]", "{}", "null":
+		return true
+	}
+	return false
+}
+
+func invalidPayloadResult(jobID string, violations []payloadViolation) jobs.Result {
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	encoded, _ := json.Marshal(violations)
+	return jobs.Result{
+		JobID:  jobID,
+		Status: "failed",
+		Output: map[string]string{
+			"message":    "invalid payload: " + strings.Join(messages, "; "),
+			"error_code": string(apienvelope.ErrorInvalidPayload),
+			"violations": string(encoded),
+		},
+		Completed: time.Now().UTC(),
+	}
+}
+
+// Capabilities lists every declared job type with its payload fields, sorted by type.
+func (r *jobSchemaRegistry) Capabilities() []jobSchema {
+	list := make([]jobSchema, 0, len(r.schemas))
+	for _, schema := range r.schemas {
+		list = append(list, *schema)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].JobType < list[b].JobType })
+	return list
+}
+
+// SchemaVersion is a stable hash of one job type's payload schema, or
+// untypedJobSchemaVersion when the type has none.
+func (r *jobSchemaRegistry) SchemaVersion(jobType string) string {
+	schema, ok := r.lookup(jobType)
+	if !ok {
+		return untypedJobSchemaVersion
+	}
+	encoded, _ := json.Marshal(schema.Fields)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Version is a stable hash of the declared schemas so the panel can cache them.
+func (r *jobSchemaRegistry) Version() string {
+	encoded, _ := json.Marshal(r.Capabilities())
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+var globalJobSchemas = buildJobSchemaRegistry()
+
+func buildJobSchemaRegistry() *jobSchemaRegistry {
+	registry := newJobSchemaRegistry()
+	registry.register(jobCancelPayload{}, jobCancelJobType)
+	registry.register(agentScheduleSyncPayload{}, agentScheduleSyncJobType)
+	registry.register(agentScheduleDeletePayload{}, agentScheduleDeleteJobType)
+	registry.register(instanceServicePayload{}, "instance.start", "instance.stop", "instance.restart")
+	registry.register(instanceFileNamePayload{}, "instance.files.read", "instance.files.delete")
+	registry.register(instanceDirNamePayload{}, "instance.files.mkdir")
+	registry.register(instanceAddonPayload{}, "instance.addon.install", "instance.addon.update")
+	registry.register(dnsRecordPayload{}, "dns.record.create", "dns.record.update")
+	registry.register(dnsRecordDeletePayload{}, "dns.record.delete")
+	registry.register(dnsZonePayload{}, "dns.zone.create")
+	registry.register(tsVirtualCreatePayload{}, "ts3.virtual.create", "ts6.virtual.create")
+	registry.register(tsVirtualActionPayload{}, "ts3.virtual.action", "ts6.virtual.action")
+	registry.register(tsClientPayload{}, "ts3.virtual.client.kick", "ts6.virtual.client.kick", "ts3.virtual.client.ban", "ts6.virtual.client.ban")
+	registry.register(tsClientPokePayload{}, "ts3.virtual.client.poke", "ts6.virtual.client.poke")
+	registry.register(tsServerGroupCreatePayload{}, "ts3.virtual.servergroup.create", "ts6.virtual.servergroup.create")
+	registry.register(tsServerGroupCopyPayload{}, "ts3.virtual.servergroup.copy", "ts6.virtual.servergroup.copy")
+	registry.register(tsServerGroupRenamePayload{}, "ts3.virtual.servergroup.rename", "ts6.virtual.servergroup.rename")
+	registry.register(tsServerGroupDeletePayload{}, "ts3.virtual.servergroup.delete", "ts6.virtual.servergroup.delete")
+	registry.register(tsServerGroupClientPayload{}, "ts3.virtual.servergroup.client.add", "ts6.virtual.servergroup.client.add", "ts3.virtual.servergroup.client.remove", "ts6.virtual.servergroup.client.remove")
+	registry.register(tsServerGroupPermPayload{}, "ts3.virtual.servergroup.perm.edit", "ts6.virtual.servergroup.perm.edit")
+	registry.register(tsChannelPermPayload{}, "ts3.virtual.channel.perm.edit", "ts6.virtual.channel.perm.edit")
+	registry.register(tsPrivilegeKeyPayload{}, "ts3.virtual.privilegekey.create", "ts6.virtual.privilegekey.create")
+	registry.register(tsChannelCreatePayload{}, "ts3.virtual.channel.create", "ts6.virtual.channel.create")
+	registry.register(tsChannelEditPayload{}, "ts3.virtual.channel.edit", "ts6.virtual.channel.edit")
+	registry.register(tsChannelMovePayload{}, "ts3.virtual.channel.move", "ts6.virtual.channel.move")
+	registry.register(tsChannelDeletePayload{}, "ts3.virtual.channel.delete", "ts6.virtual.channel.delete")
+	registry.register(tsChannelLayoutPayload{}, "ts3.virtual.channel.layout.apply", "ts6.virtual.channel.layout.apply")
+	registry.register(tsMigrationExportPayload{}, "ts3.virtual.migration.export", "ts6.virtual.migration.export")
+	registry.register(tsMigrationImportPayload{}, "ts3.virtual.migration.import", "ts6.virtual.migration.import")
+	registry.register(tsMigrationDecommissionPayload{}, "ts3.virtual.migration.decommission", "ts6.virtual.migration.decommission")
+	registry.register(tsFileTargetPayload{}, "ts3.virtual.file.list", "ts6.virtual.file.list")
+	registry.register(tsFileTransferPayload{}, "ts3.virtual.file.upload", "ts6.virtual.file.upload", "ts3.virtual.file.download", "ts6.virtual.file.download")
+	registry.register(tsFileDeletePayload{}, "ts3.virtual.file.delete", "ts6.virtual.file.delete")
+	registry.register(tsEventsSubscribePayload{}, "ts3.virtual.events.subscribe", "ts6.virtual.events.subscribe")
+	registry.register(tsEventsUnsubscribePayload{}, "ts3.virtual.events.unsubscribe", "ts6.virtual.events.unsubscribe")
+	registerInstanceJobSchemas(registry)
+	registerWebspaceJobSchemas(registry)
+	registerMailJobSchemas(registry)
+	registerDatabaseJobSchemas(registry)
+	registerSystemJobSchemas(registry)
+	registerVoiceJobSchemas(registry)
+	return registry
+}
+
+type jobCancelPayload struct {
+	TargetJobID string `payload:"target_job_id,required" alias:"cancel_job_id,job_id" format:"job_id"`
+	Reason      string `payload:"reason"`
+}
+
+type agentScheduleSyncPayload struct {
+	Schedules json.RawMessage `payload:"schedules,required"`
+}
+
+type agentScheduleDeletePayload struct {
+	ScheduleID string `payload:"schedule_id,required" alias:"id"`
+}
+
+type instanceServicePayload struct {
+	instancePortsPayload
+	InstanceID  string `payload:"instance_id"`
+	ServiceName string `payload:"service_name"`
+	StartParams string `payload:"start_params"`
+}
+
+func (p instanceServicePayload) validatePayload() []payloadViolation {
+	if p.InstanceID == "" && p.ServiceName == "" {
+		return []payloadViolation{{Field: "instance_id", Rule: "required", Message: "instance_id or service_name is required"}}
+	}
+	return nil
+}
+
+type instanceFileNamePayload struct {
+	instanceDirPayload
+	InstanceID string `payload:"instance_id"`
+	CustomerID string `payload:"customer_id"`
+	Name       string `payload:"name,required" alias:"file"`
+	Path       string `payload:"path" alias:"dir"`
+}
+
+type instanceDirNamePayload struct {
+	instanceDirPayload
+	InstanceID string `payload:"instance_id"`
+	CustomerID string `payload:"customer_id"`
+	Name       string `payload:"name,required" alias:"directory"`
+	Path       string `payload:"path" alias:"dir"`
+}
+
+type instanceAddonPayload struct {
+	instanceDirPayload
+	InstanceID          string `payload:"instance_id"`
+	CustomerID          string `payload:"customer_id"`
+	PluginID            string `payload:"plugin_id,required"`
+	PluginName          string `payload:"plugin_name"`
+	PluginVersion       string `payload:"plugin_version"`
+	PluginDownloadURL   string `payload:"plugin_download_url,required" alias:"download_url" format:"url"`
+	PluginChecksum      string `payload:"plugin_checksum" alias:"checksum"`
+	PluginExtractSubdir string `payload:"plugin_extract_subdir"`
+	PluginInstallMode   string `payload:"plugin_install_mode"`
+}
+
+// powerDNSPayload locates the PowerDNS API; the handlers fall back to the local
+// defaults when it is left out.
+type powerDNSPayload struct {
+	APIURL   string `payload:"api_url" alias:"pdns_api_url" format:"url"`
+	APIKey   string `payload:"api_key" alias:"pdns_api_key"`
+	ServerID string `payload:"server_id" alias:"pdns_server"`
+}
+
+type dnsRecordPayload struct {
+	powerDNSPayload
+	Zone       string `payload:"zone,required" alias:"zone_name,domain"`
+	RecordName string `payload:"record_name,required" alias:"name,record"`
+	Type       string `payload:"type,required" alias:"record_type"`
+	Content    string `payload:"content,required" alias:"value"`
+	TTL        int    `payload:"ttl,required" min:"1"`
+	Priority   int    `payload:"priority" min:"0" max:"65535"`
+}
+
+type dnsRecordDeletePayload struct {
+	powerDNSPayload
+	Zone       string `payload:"zone,required" alias:"zone_name,domain"`
+	RecordName string `payload:"record_name,required" alias:"name,record"`
+	Type       string `payload:"type,required" alias:"record_type"`
+	Content    string `payload:"content" alias:"value"`
+}
+
+type dnsZonePayload struct {
+	powerDNSPayload
+	Zone        string `payload:"zone,required" alias:"zone_name,name,domain"`
+	Nameservers string `payload:"nameservers,required" alias:"name_servers,ns"`
+}
+
+type tsVirtualCreatePayload struct {
+	tsQueryConnectionPayload
+	Name string `payload:"name,required"`
+}
+
+type tsVirtualActionPayload struct {
+	tsQueryConnectionPayload
+	SID    int    `payload:"sid,required" min:"1"`
+	Action string `payload:"action,required" enum:"start|stop|restart|delete"`
+}
+
+type tsServerGroupCreatePayload struct {
+	tsQueryConnectionPayload
+	SID  int    `payload:"sid,required" min:"1"`
+	Name string `payload:"name,required"`
+	Type int    `payload:"type" alias:"group_type" min:"0" max:"2"`
+}
+
+type tsServerGroupCopyPayload struct {
+	tsQueryConnectionPayload
+	SID        int    `payload:"sid,required" min:"1"`
+	SourceSGID int    `payload:"source_sgid,required" alias:"ssgid" min:"1"`
+	TargetSGID int    `payload:"target_sgid" alias:"tsgid" min:"0"`
+	Name       string `payload:"name"`
+	Type       int    `payload:"type" alias:"group_type" min:"0" max:"2"`
+}
+
+func (p tsServerGroupCopyPayload) validatePayload() []payloadViolation {
+	if p.TargetSGID == 0 && p.Name == "" {
+		return []payloadViolation{{Field: "name", Rule: "required", Message: "name is required when copying into a new group"}}
+	}
+	return nil
+}
+
+type tsServerGroupRenamePayload struct {
+	tsQueryConnectionPayload
+	SID  int    `payload:"sid,required" min:"1"`
+	SGID int    `payload:"sgid,required" alias:"server_group_id" min:"1"`
+	Name string `payload:"name,required"`
+}
+
+type tsServerGroupDeletePayload struct {
+	tsQueryConnectionPayload
+	SID   int  `payload:"sid,required" min:"1"`
+	SGID  int  `payload:"sgid,required" alias:"server_group_id" min:"1"`
+	Force bool `payload:"force"`
+}
+
+type tsServerGroupClientPayload struct {
+	tsQueryConnectionPayload
+	SID    int             `payload:"sid,required" min:"1"`
+	SGID   int             `payload:"sgid,required" alias:"server_group_id" min:"1"`
+	CLDBID json.RawMessage `payload:"cldbid,required" alias:"cldbids"`
+}
+
+type tsServerGroupPermPayload struct {
+	tsQueryConnectionPayload
+	SID         int             `payload:"sid,required" min:"1"`
+	SGID        int             `payload:"sgid,required" alias:"server_group_id" min:"1"`
+	Permissions json.RawMessage `payload:"permissions,required"`
+}
+
+type tsChannelPermPayload struct {
+	tsQueryConnectionPayload
+	SID         int             `payload:"sid,required" min:"1"`
+	CID         int             `payload:"cid,required" alias:"channel_id" min:"1"`
+	Permissions json.RawMessage `payload:"permissions,required"`
+}
+
+type tsPrivilegeKeyPayload struct {
+	tsQueryConnectionPayload
+	SID            int    `payload:"sid,required" min:"1"`
+	SGID           int    `payload:"sgid" alias:"server_group_id" min:"1"`
+	ChannelGroupID int    `payload:"channel_group_id" alias:"cgid" min:"1"`
+	CID            int    `payload:"cid" alias:"channel_id" min:"1"`
+	Description    string `payload:"description"`
+}
+
+func (p tsPrivilegeKeyPayload) validatePayload() []payloadViolation {
+	switch {
+	case p.ChannelGroupID != 0 && p.CID == 0:
+		return []payloadViolation{{Field: "cid", Rule: "required", Message: "cid is required for a channel group key"}}
+	case p.ChannelGroupID == 0 && p.SGID == 0:
+		return []payloadViolation{{Field: "sgid", Rule: "required", Message: "sgid or channel_group_id is required"}}
+	}
+	return nil
+}
+
+type tsChannelCreatePayload struct {
+	tsQueryConnectionPayload
+	SID   int    `payload:"sid,required" min:"1"`
+	Name  string `payload:"name,required" alias:"channel_name"`
+	CPID  int    `payload:"cpid" alias:"parent_cid" min:"0"`
+	Order int    `payload:"order" alias:"channel_order" min:"0"`
+}
+
+type tsChannelEditPayload struct {
+	tsQueryConnectionPayload
+	SID int `payload:"sid,required" min:"1"`
+	CID int `payload:"cid,required" alias:"channel_id" min:"1"`
+}
+
+type tsChannelMovePayload struct {
+	tsQueryConnectionPayload
+	SID   int `payload:"sid,required" min:"1"`
+	CID   int `payload:"cid,required" alias:"channel_id" min:"1"`
+	CPID  int `payload:"cpid,required" alias:"parent_cid" min:"0"`
+	Order int `payload:"order" alias:"channel_order" min:"0"`
+}
+
+type tsChannelDeletePayload struct {
+	tsQueryConnectionPayload
+	SID   int  `payload:"sid,required" min:"1"`
+	CID   int  `payload:"cid,required" alias:"channel_id" min:"1"`
+	Force bool `payload:"force"`
+}
+
+type tsChannelLayoutPayload struct {
+	tsQueryConnectionPayload
+	SID    int             `payload:"sid,required" min:"1"`
+	Layout json.RawMessage `payload:"layout,required" alias:"channels"`
+	Prune  bool            `payload:"prune"`
+	DryRun bool            `payload:"dry_run"`
+}
+
+type tsMigrationExportPayload struct {
+	tsQueryConnectionPayload
+	SID         int    `payload:"sid,required" min:"1"`
+	MigrationID string `payload:"migration_id,required"`
+	SigningKey  string `payload:"signing_key,required" alias:"migration_key"`
+	StopSource  bool   `payload:"stop_source"`
+}
+
+type tsMigrationImportPayload struct {
+	tsQueryConnectionPayload
+	MigrationID      string `payload:"migration_id,required"`
+	SigningKey       string `payload:"signing_key,required" alias:"migration_key"`
+	Signature        string `payload:"signature,required" alias:"bundle_signature"`
+	BundlePath       string `payload:"bundle_path"`
+	BundleRef        string `payload:"bundle_ref"`
+	VoicePort        int    `payload:"voice_port" min:"1" max:"65535"`
+	FiletransferPort int    `payload:"filetransfer_port" min:"1" max:"65535"`
+	KeepOnFailure    bool   `payload:"keep_on_failure"`
+}
+
+func (p tsMigrationImportPayload) validatePayload() []payloadViolation {
+	if p.BundlePath == "" && p.BundleRef == "" {
+		return []payloadViolation{{Field: "bundle_path", Rule: "required", Message: "bundle_path or bundle_ref is required"}}
+	}
+	return nil
+}
+
+type tsMigrationDecommissionPayload struct {
+	tsQueryConnectionPayload
+	SID         int    `payload:"sid,required" min:"1"`
+	MigrationID string `payload:"migration_id,required"`
+	Confirm     string `payload:"confirm,required"`
+	TargetSID   int    `payload:"target_sid,required" min:"1"`
+	Signature   string `payload:"signature,required" alias:"bundle_signature"`
+	RemoveFiles bool   `payload:"remove_files"`
+}
+
+// tsFileTargetPayload names a file in a channel's file repository. For
+// file.list, name is the directory to list.
+type tsFileTargetPayload struct {
+	tsQueryConnectionPayload
+	SID              int    `payload:"sid,required" min:"1"`
+	CID              int    `payload:"cid" alias:"channel_id" min:"0"`
+	ChannelPassword  string `payload:"cpw" alias:"channel_password"`
+	Name             string `payload:"name" alias:"path"`
+	FiletransferHost string `payload:"filetransfer_host" alias:"filetransfer_ip"`
+}
+
+type tsFileTransferPayload struct {
+	tsFileTargetPayload
+	Size          int    `payload:"size" min:"0"`
+	Overwrite     bool   `payload:"overwrite"`
+	ContentBase64 string `payload:"content_base64" alias:"content"`
+}
+
+func (p tsFileTransferPayload) validatePayload() []payloadViolation {
+	if p.Name == "" {
+		return []payloadViolation{{Field: "name", Rule: "required", Message: "name is required"}}
+	}
+	return nil
+}
+
+type tsFileDeletePayload struct {
+	tsFileTargetPayload
+	Names json.RawMessage `payload:"names"`
+}
+
+func (p tsFileDeletePayload) validatePayload() []payloadViolation {
+	if p.Name == "" && len(p.Names) == 0 {
+		return []payloadViolation{{Field: "name", Rule: "required", Message: "name or names is required"}}
+	}
+	return nil
+}
+
+type tsEventsSubscribePayload struct {
+	tsQueryConnectionPayload
+	SID    int             `payload:"sid,required" min:"1"`
+	Events json.RawMessage `payload:"events"`
+}
+
+type tsEventsUnsubscribePayload struct {
+	tsQueryConnectionPayload
+	SubscriptionID string `payload:"subscription_id"`
+	SID            int    `payload:"sid" min:"1"`
+}
+
+func (p tsEventsUnsubscribePayload) validatePayload() []payloadViolation {
+	if p.SubscriptionID == "" && p.SID == 0 {
+		return []payloadViolation{{Field: "subscription_id", Rule: "required", Message: "subscription_id or sid is required"}}
+	}
+	return nil
+}
+
+type tsClientPayload struct {
+	tsQueryConnectionPayload
+	SID      int    `payload:"sid,required" min:"1"`
+	CLID     int    `payload:"clid,required" min:"1"`
+	Reason   string `payload:"reason"`
+	Duration int    `payload:"duration" min:"0"`
+}
+
+type tsClientPokePayload struct {
+	tsQueryConnectionPayload
+	SID     int    `payload:"sid,required" min:"1"`
+	CLID    int    `payload:"clid,required" min:"1"`
+	Message string `payload:"message,required"`
+}
@@ -0,0 +1,69 @@
+package main
+
+func registerDatabaseJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(databaseRequestPayload{}, "database.create", "database.delete", "database.password.reset", "database.password.rotate", "database.user.create", "database.grant.apply")
+	registry.register(mariaDBDatabasePayload{}, "mariadb.db.create")
+	registry.register(mariaDBUserPayload{}, "mariadb.user.create")
+	registry.register(mariaDBGrantPayload{}, "mariadb.grant.apply")
+	registry.register(postgresDatabasePayload{}, "postgres.db.create")
+	registry.register(postgresRolePayload{}, "postgres.role.create")
+	registry.register(postgresGrantPayload{}, "postgres.grant.apply")
+}
+
+// databaseRequestPayload is what parseDatabaseRequest reads. The admin
+// credentials fall back to the DB_ADMIN_* environment, so none of them are
+// required here.
+type databaseRequestPayload struct {
+	Engine       string `payload:"engine"`
+	Host         string `payload:"host"`
+	Port         string `payload:"port"`
+	Database     string `payload:"database" alias:"name"`
+	Username     string `payload:"username" alias:"user"`
+	AllowedHosts string `payload:"allowed_hosts" alias:"allowed_host"`
+	AdminUser    string `payload:"admin_user"`
+	AdminSecret  string `payload:"admin_secret"`
+	TLSMode      string `payload:"tls_mode"`
+	CACert       string `payload:"ca_cert"`
+}
+
+type mariaDBDatabasePayload struct {
+	Database  string `payload:"database,required" alias:"name"`
+	Charset   string `payload:"charset"`
+	Collation string `payload:"collation"`
+}
+
+type mariaDBUserPayload struct {
+	Username          string `payload:"username,required" alias:"user"`
+	Host              string `payload:"host,required" alias:"allowed_host"`
+	AllowedSubnet     string `payload:"allowed_subnet,required" alias:"web_subnet,subnet"`
+	EncryptedPassword string `payload:"encrypted_password,required" alias:"password_encrypted"`
+}
+
+type mariaDBGrantPayload struct {
+	Database      string `payload:"database,required" alias:"name"`
+	Username      string `payload:"username,required" alias:"user"`
+	Host          string `payload:"host,required" alias:"allowed_host"`
+	AllowedSubnet string `payload:"allowed_subnet,required" alias:"web_subnet,subnet"`
+	Privileges    string `payload:"privileges" alias:"grants"`
+}
+
+type postgresDatabasePayload struct {
+	Database  string `payload:"database,required" alias:"name"`
+	Owner     string `payload:"owner" alias:"role"`
+	Encoding  string `payload:"encoding"`
+	Collation string `payload:"collation" alias:"lc_collate"`
+	Ctype     string `payload:"ctype" alias:"lc_ctype"`
+}
+
+type postgresRolePayload struct {
+	Username          string `payload:"username,required" alias:"role,user"`
+	EncryptedPassword string `payload:"encrypted_password,required" alias:"password_encrypted"`
+}
+
+type postgresGrantPayload struct {
+	Database      string `payload:"database,required" alias:"name"`
+	Username      string `payload:"username,required" alias:"role,user"`
+	AllowedSubnet string `payload:"allowed_subnet,required" alias:"subnet,web_subnet"`
+	AuthMethod    string `payload:"auth_method" alias:"pg_hba_auth_method"`
+	PgHBAPath     string `payload:"pg_hba_path,required" alias:"pg_hba_file"`
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+)
+
+func registerInstanceJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(instanceCreatePayload{}, "instance.create")
+	registry.register(instanceReinstallPayload{}, "instance.reinstall")
+	registry.register(instanceDeletePayload{}, "instance.delete")
+	registry.register(instanceLogsTailPayload{}, "instance.logs.tail")
+	registry.register(instanceConsoleCommandPayload{}, "instance.console.command")
+	registry.register(instanceWatchdogPayload{}, "instance.watchdog.check")
+	registry.register(instanceBackupCreatePayload{}, "instance.backup.create")
+	registry.register(instanceBackupRestorePayload{}, "instance.backup.restore")
+	registry.register(instanceBackupPrunePayload{}, "instance.backup.prune")
+	registry.register(instanceAddonRemovePayload{}, "instance.addon.remove")
+	registry.register(instanceConfigApplyPayload{}, "instance.config.apply")
+	registry.register(instanceQueryPayload{}, "instance.query.check")
+	registry.register(instanceFilesListPayload{}, "instance.files.list")
+	registry.register(instanceFileWritePayload{}, "instance.files.write")
+	registry.register(instanceDiskPayload{}, "instance.disk.scan")
+	registry.register(instanceDiskTopPayload{}, "instance.disk.top")
+	registry.register(nodeDiskStatPayload{}, "node.disk.stat")
+	registry.register(instanceSftpCredentialsPayload{}, "instance.sftp.credentials.reset")
+	registry.register(instanceSftpAccessPayload{}, "instance.sftp.access.enable", "instance.sftp.access.reset_password")
+	registry.register(instanceSftpAccessKeysPayload{}, "instance.sftp.access.keys")
+	registry.register(instanceSftpAccessDisablePayload{}, "instance.sftp.access.disable")
+	registry.register(instanceCrashReportPayload{}, instanceCrashReportJobType)
+	registry.register(instanceIDPayload{}, instanceCrashResetJobType, instanceScheduleListJobType, portLeasesListJobType)
+	registry.register(instanceResourcesApplyPayload{}, instanceResourcesApplyJobType)
+	registry.register(instanceTaskRunPayload{}, instanceTaskRunJobType)
+	registry.register(instanceScheduleSyncPayload{}, instanceScheduleSyncJobType)
+	registry.register(instanceScheduleDeletePayload{}, instanceScheduleDeleteJobType)
+}
+
+// instanceDirPayload locates an instance's directory. resolveInstanceDir reads
+// it from instance jobs and from payloads the agent builds itself.
+type instanceDirPayload struct {
+	InstanceDir string `payload:"instance_dir" alias:"install_path,root_path,instance_root"`
+	BaseDir     string `payload:"base_dir"`
+}
+
+// instancePortsPayload is how the panel asks for an instance's ports. The port
+// allocator reads it from create and reinstall jobs and from stored instances.
+type instancePortsPayload struct {
+	PortBlockPorts   json.RawMessage `payload:"port_block_ports" alias:"ports"`
+	RequiredPorts    string          `payload:"required_ports"`
+	PortCount        int             `payload:"port_count" min:"0"`
+	PortRanges       string          `payload:"port_ranges" alias:"port_range"`
+	PortReservations json.RawMessage `payload:"port_reservations"`
+}
+
+// instanceProvisionPayload holds the settings create and reinstall share.
+type instanceProvisionPayload struct {
+	instancePortsPayload
+	BaseDir         string          `payload:"base_dir"`
+	ServiceName     string          `payload:"service_name"`
+	StartParams     string          `payload:"start_params"`
+	Autostart       bool            `payload:"autostart" alias:"auto_start"`
+	ResourceProfile json.RawMessage `payload:"resource_profile"`
+	Runtime         string          `payload:"runtime" alias:"instance_runtime"`
+	Container       json.RawMessage `payload:"container"`
+	EnvVars         json.RawMessage `payload:"env_vars"`
+	Secrets         json.RawMessage `payload:"secrets"`
+	ConfigFiles     json.RawMessage `payload:"config_files"`
+	SharedServerDir string          `payload:"shared_server_dir"`
+	SharedKey       string          `payload:"shared_key"`
+	GameKey         string          `payload:"game_key"`
+}
+
+// instanceLimitsPayload holds the cgroup limits. They are mandatory wherever
+// the agent writes systemd units; Windows services ignore them.
+type instanceLimitsPayload struct {
+	CPULimit  int `payload:"cpu_limit"`
+	RAMLimit  int `payload:"ram_limit"`
+	DiskLimit int `payload:"disk_limit"`
+}
+
+func (p instanceLimitsPayload) validatePayload() []payloadViolation {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var violations []payloadViolation
+	for _, field := range []struct {
+		name  string
+		value int
+	}{{"cpu_limit", p.CPULimit}, {"ram_limit", p.RAMLimit}, {"disk_limit", p.DiskLimit}} {
+		if field.value <= 0 {
+			violations = append(violations, payloadViolation{Field: field.name, Rule: "required", Message: field.name + " must be a positive integer"})
+		}
+	}
+	return violations
+}
+
+type instanceCreatePayload struct {
+	instanceProvisionPayload
+	instanceLimitsPayload
+	InstanceID string `payload:"instance_id,required"`
+	CustomerID string `payload:"customer_id,required"`
+}
+
+func (p instanceCreatePayload) validatePayload() []payloadViolation {
+	violations := p.instanceLimitsPayload.validatePayload()
+	if p.StartParams == "" {
+		violations = append(violations, payloadViolation{Field: "start_params", Rule: "required", Message: "start_params is required"})
+	}
+	return violations
+}
+
+type instanceReinstallPayload struct {
+	instanceProvisionPayload
+	instanceLimitsPayload
+	InstanceID     string `payload:"instance_id,required"`
+	CustomerID     string `payload:"customer_id,required"`
+	InstallCommand string `payload:"install_command"`
+	SteamAppID     string `payload:"steam_app_id"`
+	BackupOld      bool   `payload:"backup_old" alias:"backup"`
+}
+
+type instanceDeletePayload struct {
+	instanceDirPayload
+	InstanceID  string `payload:"instance_id,required"`
+	CustomerID  string `payload:"customer_id"`
+	ServiceName string `payload:"service_name"`
+}
+
+type instanceLogsTailPayload struct {
+	InstanceID  string `payload:"instance_id,required"`
+	ServiceName string `payload:"service_name"`
+}
+
+type instanceConsoleCommandPayload struct {
+	InstanceID string `payload:"instance_id,required"`
+	Command    string `payload:"command,required"`
+}
+
+type instanceWatchdogPayload struct {
+	InstanceID  string `payload:"instance_id"`
+	ServiceName string `payload:"service_name"`
+	MaxRestarts int    `payload:"max_restarts" min:"0"`
+}
+
+// backupTargetPayload selects where backups are written and how.
+type backupTargetPayload struct {
+	BackupTargetType   string          `payload:"backup_target_type"`
+	BackupTargetConfig json.RawMessage `payload:"backup_target_config"`
+	BackupTargetSecret json.RawMessage `payload:"backup_target_secret"`
+	BackupBasePath     string          `payload:"backup_base_path" alias:"backup_root"`
+	BackupEncryption   json.RawMessage `payload:"backup_encryption"`
+	StagingPath        string          `payload:"staging_path"`
+}
+
+type instanceBackupCreatePayload struct {
+	instanceDirPayload
+	backupTargetPayload
+	InstanceID  string          `payload:"instance_id,required"`
+	CustomerID  string          `payload:"customer_id"`
+	ServiceName string          `payload:"service_name"`
+	BackupID    string          `payload:"backup_id"`
+	BackupMode  string          `payload:"backup_mode" alias:"mode" enum:"full|archive|dedup|incremental|snapshot"`
+	BackupHooks json.RawMessage `payload:"backup_hooks"`
+}
+
+type instanceBackupRestorePayload struct {
+	instanceDirPayload
+	backupTargetPayload
+	InstanceID string          `payload:"instance_id"`
+	CustomerID string          `payload:"customer_id"`
+	BackupID   string          `payload:"backup_id"`
+	BackupPath string          `payload:"backup_path"`
+	SnapshotID string          `payload:"snapshot_id"`
+	PreBackup  bool            `payload:"pre_backup"`
+	Paths      json.RawMessage `payload:"paths"`
+}
+
+func (p instanceBackupRestorePayload) validatePayload() []payloadViolation {
+	if p.BackupPath == "" && p.SnapshotID == "" {
+		return []payloadViolation{{Field: "backup_path", Rule: "required", Message: "backup_path or snapshot_id is required"}}
+	}
+	return nil
+}
+
+type instanceBackupPrunePayload struct {
+	backupTargetPayload
+	InstanceID string          `payload:"instance_id,required"`
+	Retention  json.RawMessage `payload:"retention"`
+	KeepLast   int             `payload:"retention_keep_last" min:"0"`
+	KeepDaily  int             `payload:"retention_keep_daily" min:"0"`
+	KeepWeekly int             `payload:"retention_keep_weekly" min:"0"`
+}
+
+type instanceAddonRemovePayload struct {
+	instanceDirPayload
+	InstanceID string `payload:"instance_id"`
+	CustomerID string `payload:"customer_id"`
+	PluginID   string `payload:"plugin_id,required"`
+}
+
+type instanceConfigApplyPayload struct {
+	InstallPath string          `payload:"install_path,required" alias:"instance_root,root_path"`
+	BaseDir     string          `payload:"base_dir"`
+	OSType      string          `payload:"os_type" enum:"linux|windows"`
+	ApplyMode   string          `payload:"apply_mode"`
+	Files       json.RawMessage `payload:"files,required"`
+}
+
+// instanceQueryCredentialsPayload is the secret a query engine logs in with.
+type instanceQueryCredentialsPayload struct {
+	QueryPassword string `payload:"query_password" alias:"rcon_password,api_token"`
+	RconCommand   string `payload:"rcon_command"`
+}
+
+type instanceQueryPayload struct {
+	instanceQueryCredentialsPayload
+	QueryType        string `payload:"query_type" alias:"protocol"`
+	Host             string `payload:"host" alias:"ip"`
+	BindIP           string `payload:"bind_ip" alias:"query_bind_ip"`
+	InstanceIP       string `payload:"instance_ip"`
+	NodeIP           string `payload:"node_ip" alias:"public_ip"`
+	LocalOnly        string `payload:"local_only" alias:"is_local_only"`
+	NetworkMode      string `payload:"network_mode"`
+	ShareHostNetwork string `payload:"share_host_network"`
+	GamePort         int    `payload:"game_port" min:"0" max:"65535"`
+	QueryPort        int    `payload:"query_port" min:"0" max:"65535"`
+}
+
+type instanceFilesListPayload struct {
+	instanceDirPayload
+	InstanceID string `payload:"instance_id"`
+	CustomerID string `payload:"customer_id"`
+	Path       string `payload:"path" alias:"dir"`
+}
+
+type instanceFileWritePayload struct {
+	instanceDirPayload
+	InstanceID    string `payload:"instance_id"`
+	CustomerID    string `payload:"customer_id"`
+	Name          string `payload:"name,required" alias:"file"`
+	Path          string `payload:"path" alias:"dir"`
+	ContentBase64 string `payload:"content_base64" alias:"content"`
+}
+
+type instanceDiskPayload struct {
+	instanceDirPayload
+	InstanceID string `payload:"instance_id"`
+	CustomerID string `payload:"customer_id"`
+}
+
+type instanceDiskTopPayload struct {
+	instanceDiskPayload
+	Limit int `payload:"limit" min:"1"`
+}
+
+type nodeDiskStatPayload struct {
+	BaseDir string `payload:"base_dir"`
+}
+
+type instanceSftpCredentialsPayload struct {
+	InstanceID       string `payload:"instance_id"`
+	CustomerID       string `payload:"customer_id"`
+	BaseDir          string `payload:"base_dir"`
+	Username         string `payload:"username,required" alias:"sftp_username,user"`
+	OneTimePassword  string `payload:"one_time_password,required" alias:"password,sftp_password"`
+	PreferredBackend string `payload:"preferred_backend" alias:"backend"`
+	InstallPath      string `payload:"install_path,required" alias:"root_path,instance_root"`
+	Host             string `payload:"host" alias:"node_ip,bind_ip"`
+}
+
+// instanceSftpUserPayload names the chrooted SFTP account of an instance.
+type instanceSftpUserPayload struct {
+	InstanceID   string `payload:"instance_id"`
+	CustomerID   string `payload:"customer_id"`
+	Username     string `payload:"username,required" alias:"sftp_username,user"`
+	InstanceRoot string `payload:"instance_root,required" alias:"instance_dir,chroot_dir"`
+}
+
+type instanceSftpAccessPayload struct {
+	instanceSftpUserPayload
+	Password       string `payload:"password,required" alias:"sftp_password"`
+	AuthorizedKeys string `payload:"authorized_keys" alias:"keys,sftp_keys"`
+}
+
+type instanceSftpAccessKeysPayload struct {
+	instanceSftpUserPayload
+	AuthorizedKeys string `payload:"authorized_keys" alias:"keys,sftp_keys"`
+}
+
+type instanceSftpAccessDisablePayload struct {
+	instanceSftpUserPayload
+}
+
+type instanceIDPayload struct {
+	InstanceID string `payload:"instance_id,required"`
+}
+
+type instanceCrashReportPayload struct {
+	instanceDirPayload
+	InstanceID             string          `payload:"instance_id,required"`
+	CustomerID             string          `payload:"customer_id"`
+	ServiceName            string          `payload:"service_name"`
+	Analyze                bool            `payload:"analyze"`
+	CrashFiles             json.RawMessage `payload:"crash_files"`
+	CrashConsoleLines      int             `payload:"crash_console_lines" min:"1"`
+	CrashLoopThreshold     int             `payload:"crash_loop_threshold" min:"1"`
+	CrashLoopWindowSeconds int             `payload:"crash_loop_window_seconds" min:"1"`
+}
+
+type instanceResourcesApplyPayload struct {
+	instanceDirPayload
+	InstanceID      string          `payload:"instance_id"`
+	CustomerID      string          `payload:"customer_id"`
+	ServiceName     string          `payload:"service_name"`
+	CPULimit        int             `payload:"cpu_limit,required" min:"1"`
+	RAMLimit        int             `payload:"ram_limit,required" min:"1"`
+	ResourceProfile json.RawMessage `payload:"resource_profile"`
+}
+
+func (p instanceResourcesApplyPayload) validatePayload() []payloadViolation {
+	if p.InstanceID == "" && p.ServiceName == "" {
+		return []payloadViolation{{Field: "instance_id", Rule: "required", Message: "instance_id or service_name is required"}}
+	}
+	return nil
+}
+
+type instanceTaskRunPayload struct {
+	InstanceID string          `payload:"instance_id,required"`
+	Steps      json.RawMessage `payload:"steps,required"`
+	TaskID     string          `payload:"task_id" format:"job_id"`
+	StepOffset int             `payload:"step_offset" min:"0"`
+	ScheduleID string          `payload:"schedule_id"`
+}
+
+type instanceScheduleSyncPayload struct {
+	InstanceID string          `payload:"instance_id,required"`
+	Schedules  json.RawMessage `payload:"schedules,required"`
+}
+
+type instanceScheduleDeletePayload struct {
+	InstanceID string `payload:"instance_id,required"`
+	ScheduleID string `payload:"schedule_id,required" alias:"id"`
+}
@@ -0,0 +1,78 @@
+package main
+
+func registerMailJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(mailBackendPayload{}, "mail.ensure_base")
+	registry.register(mailDomainCreatePayload{}, "mail.domain.create")
+	registry.register(mailDkimRotatePayload{}, "mail.dkim.rotate")
+	registry.register(mailDNSValidatePayload{}, "mail.dns.validate")
+	registry.register(mailAliasPayload{}, "mail.alias.create", "mail.alias.update", "mail.alias.delete", "mail.alias.enable", "mail.alias.disable")
+	registry.register(mailboxPayload{}, "mailbox.create", "mailbox.password.reset", "mailbox.quota.update", "mailbox.enable", "mailbox.disable", "mailbox.delete")
+	registry.register(mailboxPolicyPayload{}, "mailbox.policy.update")
+}
+
+// mailBackendPayload selects the mail backend. mailBackendGuard checks it
+// before any other field, so a disabled backend wins over missing values.
+type mailBackendPayload struct {
+	MailEnabled string `payload:"mail_enabled"`
+	MailBackend string `payload:"mail_backend"`
+	Postoffice  string `payload:"postoffice" alias:"mailenable_postoffice"`
+}
+
+type mailDomainCreatePayload struct {
+	mailBackendPayload
+	Domain        string `payload:"domain" alias:"name,hostname"`
+	ConfigPath    string `payload:"config_path" alias:"domain_config_path,virtual_domain_path"`
+	DkimSelector  string `payload:"dkim_selector" alias:"selector"`
+	DkimDir       string `payload:"dkim_dir" alias:"dkim_path,dkim_directory"`
+	AdminUser     string `payload:"admin_user" alias:"mail_admin_user"`
+	AdminPassword string `payload:"admin_password" alias:"mail_admin_password,password"`
+}
+
+type mailDkimRotatePayload struct {
+	mailBackendPayload
+	Domain   string `payload:"domain" alias:"name,hostname"`
+	Selector string `payload:"selector" alias:"dkim_selector"`
+	DkimDir  string `payload:"dkim_dir" alias:"dkim_path,dkim_directory"`
+}
+
+type mailDNSValidatePayload struct {
+	Domain            string `payload:"domain,required"`
+	Selector          string `payload:"selector" alias:"dkim_selector"`
+	TLSHost           string `payload:"tls_host" alias:"smtp_host"`
+	TLSPort           string `payload:"tls_port" alias:"smtp_port"`
+	ExpectedMXTargets string `payload:"expected_mx_targets"`
+	KnownIPs          string `payload:"known_ips"`
+	MtaStsEnabled     string `payload:"mta_sts_enabled"`
+}
+
+type mailAliasPayload struct {
+	mailBackendPayload
+	Address      string `payload:"address" alias:"alias"`
+	Destinations string `payload:"destinations" alias:"forward_to"`
+	MapPath      string `payload:"map_path" alias:"alias_map_path"`
+	Enabled      string `payload:"enabled"`
+}
+
+// mailboxPayload covers the mailbox lifecycle jobs. Postfix and Dovecot take
+// password_hash while MailEnable needs the plain password, so the two stay
+// separate fields and each backend falls back to the other.
+type mailboxPayload struct {
+	mailBackendPayload
+	Address      string `payload:"address" alias:"email"`
+	PasswordHash string `payload:"password_hash"`
+	Password     string `payload:"password" alias:"plain_password,one_time_password"`
+	QuotaMB      string `payload:"quota_mb" alias:"quota"`
+	Enabled      string `payload:"enabled" alias:"active"`
+	MapPath      string `payload:"map_path" alias:"mailbox_map_path"`
+	PasswdPath   string `payload:"passwd_path" alias:"dovecot_passwd_path"`
+	MailDir      string `payload:"mail_dir" alias:"mail_storage_path"`
+}
+
+type mailboxPolicyPayload struct {
+	Address            string `payload:"address" alias:"email"`
+	SendLimitHour      string `payload:"send_limit_hour"`
+	RecipientLimit     string `payload:"recipient_limit"`
+	PolicyPath         string `payload:"policy_path"`
+	SMTPEnabled        string `payload:"smtp_enabled"`
+	AbusePolicyEnabled string `payload:"abuse_policy_enabled"`
+}
@@ -0,0 +1,143 @@
+package main
+
+import "encoding/json"
+
+func registerSystemJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(noPayload{},
+		agentScheduleListJobType, "agent.diagnostics", "os.update", "os.reboot",
+		"server.update.check", "server.update.run", "server.reboot.check_required", "server.reboot.run",
+		"security.ensure_base", "game.ensure_base", "web.ensure_base", "dns.ensure_base", "db.ensure_base",
+		"web.stack_reload", "fail2ban.status.check", "security.events.collect", "gdpr.anonymize_user")
+	registry.register(agentUpdatePayload{}, "agent.update", "agent.self_update")
+	registry.register(roleEnsureBasePayload{}, "role.ensure_base")
+	registry.register(sshPolicyPayload{}, "core.ssh.policy.apply")
+	registry.register(adminSSHKeyPayload{}, "admin.ssh_key.store")
+	registry.register(firewallPortsPayload{}, "firewall.open_ports", "firewall.close_ports")
+	registry.register(fail2banPolicyPayload{}, "fail2ban.policy.apply")
+	registry.register(ddosPolicyPayload{}, "ddos.policy.apply", "ddos.status.check")
+	registry.register(securityRuleSetPayload{}, "security.ruleset.apply")
+	registry.register(securityRuleSetTargetPayload{}, "security.ruleset.rollback")
+	registry.register(windowsServicePayload{}, "windows.service.start", "windows.service.stop", "windows.service.restart")
+	registry.register(serverStatusPayload{}, "server.status.check")
+	registry.register(sniperPayload{}, "sniper.install", "sniper.update")
+	registry.register(sniperSharedUpdatePayload{}, "sniper.shared_update")
+}
+
+// noPayload is the schema of jobs whose handlers read nothing from the payload.
+type noPayload struct{}
+
+type agentUpdatePayload struct {
+	DownloadURL  string `payload:"download_url,required" alias:"artifact_url,url" format:"url"`
+	ChecksumsURL string `payload:"checksums_url,required" alias:"checksum_url,checksums" format:"url"`
+	SignatureURL string `payload:"signature_url" alias:"checksums_signature_url,checksum_signature_url" format:"url"`
+	AssetName    string `payload:"asset_name"`
+	Version      string `payload:"version" alias:"target_version"`
+}
+
+type roleEnsureBasePayload struct {
+	Role string `payload:"role,required"`
+}
+
+type sshPolicyPayload struct {
+	AccessMode         string `payload:"access_mode" alias:"ssh_access_mode" enum:"ssh_key_only|ssh_key_password"`
+	AuthorizedKeysPath string `payload:"authorized_keys_path"`
+	SftpGroup          string `payload:"sftp_group"`
+}
+
+type adminSSHKeyPayload struct {
+	AuthorizedKeysPath string `payload:"authorized_keys_path,required"`
+	PublicKey          string `payload:"public_key,required"`
+	AdminEmail         string `payload:"admin_email"`
+	UserID             string `payload:"user_id"`
+}
+
+type firewallPortsPayload struct {
+	PortBlockPorts json.RawMessage `payload:"port_block_ports" alias:"ports"`
+}
+
+// fail2banPolicyPayload carries the policy nested under "policy"; older panels
+// send the same keys at the top level, which fail2banPolicyFromPayload accepts.
+type fail2banPolicyPayload struct {
+	Policy json.RawMessage `payload:"policy"`
+}
+
+type ddosPolicyPayload struct {
+	Mode             string          `payload:"mode"`
+	PortReservations json.RawMessage `payload:"port_reservations"`
+	Protocols        json.RawMessage `payload:"protocols"`
+}
+
+type securityRuleSetTargetPayload struct {
+	AgentID string `payload:"agent_id" alias:"node_id"`
+	Target  string `payload:"target" alias:"target_scope"`
+}
+
+type securityRuleSetPayload struct {
+	securityRuleSetTargetPayload
+	RuleSet json.RawMessage `payload:"ruleset"`
+}
+
+type windowsServicePayload struct {
+	ServiceName string `payload:"service_name,required" alias:"name"`
+}
+
+// serverStatusPayload keeps port and query_port apart: games like Valheim
+// derive the query port from the game port when none is given.
+type serverStatusPayload struct {
+	instanceQueryCredentialsPayload
+	IP        string `payload:"ip,required" alias:"host"`
+	Port      string `payload:"port"`
+	QueryPort string `payload:"query_port"`
+	QueryType string `payload:"query_type" alias:"protocol"`
+}
+
+func (p serverStatusPayload) validatePayload() []payloadViolation {
+	if p.Port == "" && p.QueryPort == "" {
+		return []payloadViolation{{Field: "port", Rule: "required", Message: "port or query_port is required"}}
+	}
+	return nil
+}
+
+// sniperPayload installs or updates a game server through the legacy sniper
+// flow. template_key and game_key stay separate: the template key wins and the
+// game key is only its fallback. install_path and instance_dir stay separate too:
+// unlike other instance jobs, the sniper flow prefers install_path.
+type sniperPayload struct {
+	instancePortsPayload
+	InstallPath       string          `payload:"install_path"`
+	InstanceDir       string          `payload:"instance_dir" alias:"root_path,instance_root"`
+	BaseDir           string          `payload:"base_dir"`
+	InstanceID        string          `payload:"instance_id,required"`
+	CustomerID        string          `payload:"customer_id,required"`
+	SteamAppID        string          `payload:"steam_app_id"`
+	InstallCommand    string          `payload:"install_command"`
+	UpdateCommand     string          `payload:"update_command"`
+	ServiceName       string          `payload:"service_name"`
+	StartParams       string          `payload:"start_params,required"`
+	TemplateID        string          `payload:"template_id"`
+	TemplateKey       string          `payload:"template_key"`
+	TemplateSlug      string          `payload:"template_slug"`
+	TemplateName      string          `payload:"template_name"`
+	GameKey           string          `payload:"game_key"`
+	GameType          string          `payload:"game_type"`
+	StartScriptPath   string          `payload:"start_script_path"`
+	CPULimit          int             `payload:"cpu_limit,required"`
+	RAMLimit          int             `payload:"ram_limit,required"`
+	Autostart         bool            `payload:"autostart" alias:"auto_start"`
+	SharedRuntimeMode string          `payload:"shared_runtime_mode"`
+	SharedKey         string          `payload:"shared_key"`
+	SharedPaths       json.RawMessage `payload:"shared_paths"`
+	EnvVars           json.RawMessage `payload:"env_vars"`
+	Secrets           json.RawMessage `payload:"secrets"`
+	ResourceProfile   json.RawMessage `payload:"resource_profile"`
+}
+
+type sniperSharedUpdatePayload struct {
+	instancePortsPayload
+	InstanceID    string `payload:"instance_id"`
+	CustomerID    string `payload:"customer_id"`
+	BaseDir       string `payload:"base_dir"`
+	SharedKey     string `payload:"shared_key"`
+	UpdateCommand string `payload:"update_command"`
+	SteamAppID    string `payload:"steam_app_id"`
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestJobSchemaReportsEveryViolation(t *testing.T) {
+	result, _ := handleJob(context.Background(), jobs.Job{
+		ID:      "job-1",
+		Type:    "dns.record.create",
+		Payload: map[string]any{"zone_name": "example.com", "ttl": "soon", "priority": float64(70000)},
+	}, nil)
+
+	if result.Status != "failed" || result.Output["error_code"] != "INVALID_PAYLOAD" {
+		t.Fatalf("expected INVALID_PAYLOAD failure, got %#v", result)
+	}
+	var violations []payloadViolation
+	if err := json.Unmarshal([]byte(result.Output["violations"]), &violations); err != nil {
+		t.Fatalf("decode violations: %v", err)
+	}
+	rules := map[string]string{}
+	for _, violation := range violations {
+		rules[violation.Field] = violation.Rule
+	}
+	want := map[string]string{"record_name": "required", "type": "required", "content": "required", "ttl": "type", "priority": "max"}
+	for field, rule := range want {
+		if rules[field] != rule {
+			t.Fatalf("expected %s violation for %s, got %#v", rule, field, violations)
+		}
+	}
+	if _, ok := rules["zone"]; ok {
+		t.Fatal("expected zone alias zone_name to satisfy the required zone field")
+	}
+}
+
+func TestJobSchemaDecodesTypedPayload(t *testing.T) {
+	var payload tsVirtualActionPayload
+	violations := decodeJobPayload(map[string]any{"sid": float64(3), "action": "Restart"}, &payload)
+	if len(violations) != 0 {
+		t.Fatalf("unexpected violations: %#v", violations)
+	}
+	if payload.SID != 3 || payload.Action != "Restart" {
+		t.Fatalf("unexpected decoded payload: %#v", payload)
+	}
+
+	violations = decodeJobPayload(map[string]any{"sid": "0", "action": "explode"}, &payload)
+	if len(violations) != 2 {
+		t.Fatalf("expected sid and action violations, got %#v", violations)
+	}
+}
+
+func TestJobSchemaCrossFieldValidation(t *testing.T) {
+	if violations := globalJobSchemas.validateJobPayload(jobs.Job{Type: "instance.start", Payload: map[string]any{"service_name": "gs-1"}}); len(violations) != 0 {
+		t.Fatalf("expected service_name alone to be accepted, got %#v", violations)
+	}
+	violations := globalJobSchemas.validateJobPayload(jobs.Job{Type: "instance.start", Payload: map[string]any{}})
+	if len(violations) != 1 || !strings.Contains(violations[0].Message, "service_name") {
+		t.Fatalf("expected instance_id/service_name violation, got %#v", violations)
+	}
+}
+
+func TestJobSchemaAcceptsHandlerAliases(t *testing.T) {
+	violations := globalJobSchemas.validateJobPayload(jobs.Job{Type: "dns.zone.create", Payload: map[string]any{"name": "example.com", "nameservers": "ns1.example.com"}})
+	if len(violations) != 0 {
+		t.Fatalf("expected name to satisfy the zone field like the handler does, got %#v", violations)
+	}
+}
+
+func TestOrchestratorJobRejectsInvalidPayload(t *testing.T) {
+	result := handleOrchestratorJob(context.Background(), jobs.Job{ID: "job-1", Type: "ts3.virtual.client.poke", Payload: map[string]any{"sid": "1"}})
+	if result.status != "failed" || result.resultPayload["error_code"] != "INVALID_PAYLOAD" {
+		t.Fatalf("expected INVALID_PAYLOAD failure, got %#v", result)
+	}
+}
+
+func TestJobSchemaCapabilitiesAreStable(t *testing.T) {
+	capabilities := globalJobSchemas.Capabilities()
+	if len(capabilities) == 0 {
+		t.Fatal("expected declared job schemas")
+	}
+	for idx := 1; idx < len(capabilities); idx++ {
+		if capabilities[idx-1].JobType >= capabilities[idx].JobType {
+			t.Fatalf("expected capabilities sorted by type, got %s before %s", capabilities[idx-1].JobType, capabilities[idx].JobType)
+		}
+	}
+	if globalJobSchemas.Version() != buildJobSchemaRegistry().Version() {
+		t.Fatal("expected schema version to be deterministic")
+	}
+	if _, ok := globalJobSchemas.lookup("instance.files.upload"); !ok {
+		t.Fatal("expected job type aliases to resolve to their schema")
+	}
+}
+
+func TestEveryDispatchedJobTypeHasSchema(t *testing.T) {
+	for _, list := range [][]string{coreJobTypes, orchestratorJobTypes} {
+		for _, jobType := range list {
+			if _, ok := globalJobSchemas.lookup(jobType); !ok {
+				t.Errorf("job type %s has no payload schema", jobType)
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import "encoding/json"
+
+func registerVoiceJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(voiceProbePayload{}, "voice.probe")
+	registry.register(voiceActionPayload{}, "voice.action.start", "voice.action.stop", "voice.action.restart")
+	registry.register(ts3CreatePayload{}, "ts3.create", "ts3.instance.create")
+	registry.register(ts3InstancePayload{}, "ts3.start", "ts3.stop", "ts3.restart", "ts3.token.reset", "ts3.logs.export")
+	registry.register(ts3UpdatePayload{}, "ts3.update")
+	registry.register(ts3BackupPayload{}, "ts3.backup")
+	registry.register(ts3RestorePayload{}, "ts3.restore")
+	registry.register(ts3SlotsPayload{}, "ts3.slots.set")
+	registry.register(ts3InstanceActionPayload{}, "ts3.instance.action")
+	registry.register(ts3NodeInstallPayload{}, "ts3.install")
+	registry.register(ts6NodeInstallPayload{}, "ts6.install")
+	registry.register(ts6InstanceCreatePayload{}, "ts6.instance.create")
+	registry.register(ts6InstanceActionPayload{}, "ts6.instance.action")
+	registry.register(sinusbotInstallPayload{}, "sinusbot.install")
+	registry.register(serviceActionPayload{}, "ts3.service.action", "ts6.service.action", "sinusbot.service.action")
+	registry.register(serviceStatusPayload{}, "ts3.status", "ts6.status", "sinusbot.status")
+	registry.register(tsQueryConnectionPayload{}, "ts3.virtual.list", "ts6.virtual.list")
+	registry.register(tsVirtualServerPayload{},
+		"ts3.virtual.servergroup.list", "ts6.virtual.servergroup.list", "ts3.virtual.summary", "ts6.virtual.summary",
+		"ts3.virtual.ban.list", "ts6.virtual.ban.list", "ts3.virtual.channel.list", "ts6.virtual.channel.list",
+		"ts3.virtual.client.list", "ts6.virtual.client.list", "ts3.virtual.log.view", "ts6.virtual.log.view",
+		"ts3.virtual.snapshot.create", "ts6.virtual.snapshot.create", "ts3.viewer.snapshot", "ts6.viewer.snapshot")
+	registry.register(tsTokenRotatePayload{}, "ts3.virtual.token.rotate", "ts6.virtual.token.rotate")
+	registry.register(tsBanRemovePayload{}, "ts3.virtual.ban.remove", "ts6.virtual.ban.remove", "ts3.virtual.ban.delete", "ts6.virtual.ban.delete")
+	registry.register(tsSnapshotRestorePayload{}, "ts3.virtual.snapshot.restore", "ts6.virtual.snapshot.restore")
+	registry.register(musicbotPayload{},
+		"musicbot.install", "musicbot.uninstall", "musicbot.update", "musicbot.repair", "musicbot.service.action",
+		"musicbot.status", "musicbot.playback.action", "musicbot.queue.sync", "musicbot.connection.test",
+		"musicbot.config.apply", "musicbot.health.check", "musicbot.health.repair")
+	registry.register(musicbotTeamspeakBackendPayload{},
+		"musicbot.teamspeak_backend.install", "musicbot.teamspeak_backend.status", "musicbot.teamspeak_backend.repair",
+		"musicbot.teamspeak_backend.validate", "musicbot.teamspeak_backend.test_connection",
+		"musicbot.teamspeak_backend.install_official_client", "musicbot.teamspeak_backend.install_sdk_client",
+		"musicbot.teamspeak_backend.install_dependencies")
+}
+
+type voiceActionPayload struct {
+	ProviderType string `payload:"provider_type"`
+}
+
+type voiceProbePayload struct {
+	voiceActionPayload
+	CorrelationID string `payload:"correlation_id" alias:"request_id,trace_id"`
+	QueryHost     string `payload:"query_host" alias:"host"`
+	QueryPort     string `payload:"query_port" alias:"port"`
+	QueryUser     string `payload:"query_user" alias:"user"`
+	QueryEndpoint string `payload:"query_endpoint"`
+}
+
+// ts3InstancePayload identifies a TeamSpeak 3 server the agent installed
+// itself, as opposed to a virtual server on a shared node.
+type ts3InstancePayload struct {
+	InstanceID  string `payload:"ts3_instance_id" alias:"instance_id"`
+	CustomerID  string `payload:"customer_id"`
+	ServiceName string `payload:"service_name"`
+	BaseDir     string `payload:"base_dir"`
+}
+
+type ts3CreatePayload struct {
+	ts3InstancePayload
+	Name                string          `payload:"name"`
+	VoicePort           int             `payload:"voice_port,required" min:"1" max:"65535"`
+	QueryPort           int             `payload:"query_port,required" min:"1" max:"65535"`
+	FilePort            int             `payload:"file_port,required" min:"1" max:"65535"`
+	VoiceIP             string          `payload:"voice_ip"`
+	QueryIP             string          `payload:"query_ip"`
+	FiletransferIP      string          `payload:"filetransfer_ip"`
+	LicensePath         string          `payload:"licensepath" alias:"license_path"`
+	ServerAdminPassword string          `payload:"serveradmin_password" alias:"server_admin_password,admin_password"`
+	DBMode              string          `payload:"db_mode,required"`
+	DBHost              string          `payload:"db_host"`
+	DBPort              string          `payload:"db_port"`
+	DBName              string          `payload:"db_name"`
+	DBUsername          string          `payload:"db_username"`
+	DBPassword          string          `payload:"db_password"`
+	ExecStart           string          `payload:"exec_start" alias:"start_command"`
+	InstallCommand      string          `payload:"install_command"`
+	GameKey             string          `payload:"game_key"`
+	EnvVars             json.RawMessage `payload:"env_vars"`
+	Secrets             json.RawMessage `payload:"secrets"`
+	PortReservations    json.RawMessage `payload:"port_reservations"`
+}
+
+func (p ts3CreatePayload) validatePayload() []payloadViolation {
+	var violations []payloadViolation
+	if p.InstanceID == "" {
+		violations = append(violations, payloadViolation{Field: "ts3_instance_id", Rule: "required", Message: "ts3_instance_id is required"})
+	}
+	if p.CustomerID == "" {
+		violations = append(violations, payloadViolation{Field: "customer_id", Rule: "required", Message: "customer_id is required"})
+	}
+	return violations
+}
+
+type ts3UpdatePayload struct {
+	ts3InstancePayload
+	UpdateCommand string `payload:"update_command"`
+}
+
+type ts3BackupPayload struct {
+	ts3InstancePayload
+	backupTargetPayload
+	BackupPath string `payload:"backup_path"`
+}
+
+type ts3RestorePayload struct {
+	ts3InstancePayload
+	RestorePath string `payload:"restore_path,required"`
+}
+
+type ts3SlotsPayload struct {
+	ts3InstancePayload
+	Slots int `payload:"slots,required" min:"1"`
+}
+
+// ts3InstanceActionPayload is the panel's single entry point for the ts3.*
+// instance jobs; action picks the handler and the rest is passed through.
+type ts3InstanceActionPayload struct {
+	ts3InstancePayload
+	backupTargetPayload
+	Action        string `payload:"action,required" enum:"start|stop|restart|update|backup|restore|token_reset|slots|logs"`
+	UpdateCommand string `payload:"update_command"`
+	BackupPath    string `payload:"backup_path"`
+	RestorePath   string `payload:"restore_path"`
+	Slots         int    `payload:"slots" min:"1"`
+}
+
+// teamspeakNodeInstallPayload holds what the ts3 and ts6 node installers share.
+type teamspeakNodeInstallPayload struct {
+	InstallDir       string `payload:"install_dir"`
+	ServiceName      string `payload:"service_name"`
+	DownloadURL      string `payload:"download_url"`
+	DownloadFilename string `payload:"download_filename"`
+	VoiceIP          string `payload:"voice_ip"`
+	FiletransferIP   string `payload:"filetransfer_ip"`
+}
+
+type ts3NodeInstallPayload struct {
+	teamspeakNodeInstallPayload
+	InstanceName  string `payload:"instance_name"`
+	QueryPort     int    `payload:"query_port" min:"1" max:"65535"`
+	VoicePort     int    `payload:"voice_port" min:"1" max:"65535"`
+	FilePort      int    `payload:"file_port" min:"1" max:"65535"`
+	QueryIP       string `payload:"query_ip"`
+	LicensePath   string `payload:"licensepath" alias:"license_path"`
+	AdminPassword string `payload:"admin_password" alias:"serveradmin_password"`
+}
+
+type ts6NodeInstallPayload struct {
+	teamspeakNodeInstallPayload
+	AcceptLicense    bool   `payload:"accept_license"`
+	DefaultVoicePort int    `payload:"default_voice_port" min:"1" max:"65535"`
+	FiletransferPort int    `payload:"filetransfer_port" min:"1" max:"65535"`
+	QueryBindIP      string `payload:"query_bind_ip"`
+	QueryHTTPSEnable bool   `payload:"query_https_enable"`
+	QueryHTTPSPort   int    `payload:"query_https_port" min:"1" max:"65535"`
+	AdminPassword    string `payload:"admin_password"`
+}
+
+type ts6InstanceCreatePayload struct {
+	InstanceID       string `payload:"instance_id,required"`
+	ServiceName      string `payload:"service_name"`
+	InstallDir       string `payload:"install_dir"`
+	BaseDir          string `payload:"base_dir"`
+	VoicePort        int    `payload:"voice_port" min:"1" max:"65535"`
+	QueryHTTPSPort   int    `payload:"query_https_port" min:"1" max:"65535"`
+	FiletransferPort int    `payload:"filetransfer_port" min:"1" max:"65535"`
+}
+
+type ts6InstanceActionPayload struct {
+	Action        string `payload:"action,required" enum:"start|stop|restart|update|backup|restore"`
+	InstanceID    string `payload:"instance_id"`
+	ServiceName   string `payload:"service_name"`
+	InstanceDir   string `payload:"instance_dir"`
+	UpdateCommand string `payload:"update_command"`
+	BackupPath    string `payload:"backup_path"`
+	RestorePath   string `payload:"restore_path"`
+}
+
+type sinusbotInstallPayload struct {
+	InstallDir           string `payload:"install_dir"`
+	InstanceRoot         string `payload:"instance_root"`
+	ServiceName          string `payload:"service_name"`
+	DownloadURL          string `payload:"download_url"`
+	DownloadFilename     string `payload:"download_filename"`
+	ServiceUser          string `payload:"service_user"`
+	WebBindIP            string `payload:"web_bind_ip"`
+	WebPortBase          int    `payload:"web_port_base" min:"1" max:"65535"`
+	AdminPassword        string `payload:"admin_password"`
+	TS3ClientInstall     bool   `payload:"ts3_client_install" alias:"install_ts3_client"`
+	TS3ClientDownloadURL string `payload:"ts3_client_download_url"`
+}
+
+type serviceActionPayload struct {
+	ServiceName string `payload:"service_name"`
+	Action      string `payload:"action,required"`
+}
+
+type serviceStatusPayload struct {
+	ServiceName          string `payload:"service_name"`
+	DownloadURL          string `payload:"download_url"`
+	InstallDir           string `payload:"install_dir" alias:"install_path"`
+	TS3ClientDownloadURL string `payload:"ts3_client_download_url"`
+}
+
+// tsQueryConnectionPayload is how the panel reaches a node's ServerQuery. It
+// comes with every virtual server job; the query clients and the connection
+// pool also read it from stored event subscriptions.
+type tsQueryConnectionPayload struct {
+	QueryBindIP      string `payload:"query_bind_ip" alias:"query_ip"`
+	QueryProtocol    string `payload:"query_protocol" alias:"query_transport"`
+	QueryPort        string `payload:"query_port"`
+	QueryHTTPSPort   string `payload:"query_https_port"`
+	QueryHTTPPort    string `payload:"query_http_port"`
+	QuerySSHPort     string `payload:"query_ssh_port"`
+	QuerySSHUsername string `payload:"query_ssh_username"`
+	QuerySSHPassword string `payload:"query_ssh_password"`
+	AdminUsername    string `payload:"admin_username"`
+	AdminPassword    string `payload:"admin_password"`
+}
+
+type tsVirtualServerPayload struct {
+	tsQueryConnectionPayload
+	SID int `payload:"sid,required" min:"1"`
+}
+
+type tsTokenRotatePayload struct {
+	tsQueryConnectionPayload
+	SID  int `payload:"sid,required" min:"1"`
+	SGID int `payload:"sgid" alias:"server_group_id" min:"1"`
+}
+
+type tsBanRemovePayload struct {
+	tsQueryConnectionPayload
+	SID   int `payload:"sid,required" min:"1"`
+	BanID int `payload:"banid,required" min:"1"`
+}
+
+type tsSnapshotRestorePayload struct {
+	tsQueryConnectionPayload
+	SID             int    `payload:"sid,required" min:"1"`
+	SnapshotContent string `payload:"snapshot_content,required"`
+}
+
+// musicbotPayload covers the musicbot lifecycle jobs. They all resolve the
+// same install layout; the remaining fields only matter to some of them.
+type musicbotPayload struct {
+	InstallPath    string          `payload:"install_path" alias:"install_dir"`
+	ServiceName    string          `payload:"service_name"`
+	InstanceID     string          `payload:"instance_id"`
+	CustomerID     string          `payload:"customer_id"`
+	NodeID         string          `payload:"node_id"`
+	SystemdUnitDir string          `payload:"systemd_unit_dir"`
+	SkipSystemd    bool            `payload:"skip_systemd"`
+	RuntimeUser    string          `payload:"runtime_user"`
+	RuntimeBinary  string          `payload:"runtime_binary" alias:"runtime_binary_path"`
+	Platform       string          `payload:"platform"`
+	Action         string          `payload:"action"`
+	RepairAction   string          `payload:"repair_action"`
+	Enable         bool            `payload:"enable"`
+	Restart        bool            `payload:"restart"`
+	KeepData       bool            `payload:"keep_data"`
+	DeleteData     string          `payload:"delete_data"`
+	TeamspeakOn    bool            `payload:"teamspeak_enabled"`
+	DiscordOn      bool            `payload:"discord_enabled"`
+	CPULimit       string          `payload:"cpu_limit"`
+	RAMLimit       string          `payload:"ram_limit"`
+	DiskLimit      string          `payload:"disk_limit"`
+	Config         json.RawMessage `payload:"config"`
+	Queue          json.RawMessage `payload:"queue"`
+}
+
+type musicbotTeamspeakBackendPayload struct {
+	musicbotPayload
+	BackendPath                 string `payload:"backend_path"`
+	BinaryPath                  string `payload:"binary_path"`
+	Port                        string `payload:"port"`
+	BackendType                 string `payload:"backend_type"`
+	LibraryPath                 string `payload:"library_path"`
+	OpusLibraryPath             string `payload:"opus_library_path"`
+	IdentityPath                string `payload:"identity_path"`
+	Version                     string `payload:"version"`
+	ExpectedChecksum            string `payload:"expected_checksum" alias:"checksum"`
+	AutoInstallEnabled          bool   `payload:"auto_install_enabled"`
+	Host                        string `payload:"host"`
+	Profile                     string `payload:"profile"`
+	Nickname                    string `payload:"nickname"`
+	ChannelID                   string `payload:"channel_id"`
+	ServerPassword              string `payload:"server_password"`
+	ChannelPassword             string `payload:"channel_password"`
+	BridgePath                  string `payload:"bridge_path"`
+	ClientBinaryPath            string `payload:"client_binary_path"`
+	ClientRunscriptPath         string `payload:"client_runscript_path"`
+	AudioBackend                string `payload:"audio_backend"`
+	InstallDependencies         bool   `payload:"install_dependencies"`
+	InstancePath                string `payload:"instance_path"`
+	ClientQueryHost             string `payload:"client_query_host"`
+	ClientQueryPort             string `payload:"client_query_port"`
+	DownloadURL                 string `payload:"download_url"`
+	ExpectedSHA256              string `payload:"expected_sha256"`
+	RequestedBy                 string `payload:"requested_by"`
+	AcceptedLicenseConfirmation bool   `payload:"accepted_license_confirmation"`
+	RebuildBackendBinary        bool   `payload:"rebuild_backend_binary"`
+	BinarySourcePath            string `payload:"binary_source_path"`
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+)
+
+func registerWebspaceJobSchemas(registry *jobSchemaRegistry) {
+	registry.register(webspaceProvisionPayload{}, "webspace.create", "webspace.provision", "webspace.update")
+	registry.register(webspaceDeletePayload{}, "webspace.delete")
+	registry.register(webspaceApplyPayload{}, "webspace.apply")
+	registry.register(webspaceBackupPayload{}, "webspace.backup")
+	registry.register(webspaceRestorePayload{}, "webspace.restore")
+	registry.register(webspaceLogsTailPayload{}, "webspace.logs.tail")
+	registry.register(webspaceCronUpdatePayload{}, "webspace.cron.update")
+	registry.register(webspaceGitDeployPayload{}, "webspace.git.deploy")
+	registry.register(webspaceComposerPayload{}, "webspace.composer.install")
+	registry.register(webspaceDomainApplyPayload{}, "webspace.domain.apply")
+	registry.register(webspaceFilesPayload{}, "webspace.files.list")
+	registry.register(webspaceFileNamePayload{}, "webspace.files.read", "webspace.files.delete")
+	registry.register(webspaceFileWritePayload{}, "webspace.files.write")
+	registry.register(webspaceDirNamePayload{}, "webspace.files.mkdir")
+	registry.register(webspaceSftpCredentialsPayload{}, "webspace.sftp.credentials.reset")
+	registry.register(domainAddPayload{}, "domain.add")
+	registry.register(domainSSLPayload{}, "domain.ssl.issue", "domain.ssl.renew")
+	registry.register(domainSSLRevokePayload{}, "domain.ssl.revoke")
+	registry.register(roundcubeInstallPayload{}, "roundcube.install")
+	registry.register(roundcubeDeployPayload{}, "roundcube.deploy")
+}
+
+// webspaceOwnerPayload names the system account and PHP-FPM pool that own a
+// webspace.
+type webspaceOwnerPayload struct {
+	WebspaceID       string `payload:"webspace_id"`
+	WebRoot          string `payload:"web_root,required" alias:"path"`
+	OwnerUser        string `payload:"owner_user" alias:"user"`
+	OwnerGroup       string `payload:"owner_group" alias:"group"`
+	PhpFpmPoolPath   string `payload:"php_fpm_pool_path" alias:"fpm_pool_path"`
+	NginxIncludePath string `payload:"nginx_include_path" alias:"nginx_include"`
+}
+
+type webspaceProvisionPayload struct {
+	webspaceOwnerPayload
+	Docroot      string          `payload:"docroot" alias:"document_root"`
+	PhpFpmListen string          `payload:"php_fpm_listen" alias:"fpm_listen"`
+	PhpVersion   string          `payload:"php_version"`
+	PoolName     string          `payload:"pool_name" alias:"php_fpm_pool_name"`
+	PhpSettings  json.RawMessage `payload:"php_settings"`
+	LogsDir      string          `payload:"logs_dir"`
+	TmpDir       string          `payload:"tmp_dir"`
+}
+
+// validatePayload requires the PHP-FPM and nginx paths the Linux stack writes;
+// IIS only needs the web root.
+func (p webspaceProvisionPayload) validatePayload() []payloadViolation {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	var violations []payloadViolation
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"owner_user", p.OwnerUser},
+		{"php_fpm_pool_path", p.PhpFpmPoolPath},
+		{"php_fpm_listen", p.PhpFpmListen},
+		{"nginx_include_path", p.NginxIncludePath},
+	} {
+		if field.value == "" {
+			violations = append(violations, payloadViolation{Field: field.name, Rule: "required", Message: field.name + " is required"})
+		}
+	}
+	return violations
+}
+
+type webspaceDeletePayload struct {
+	webspaceOwnerPayload
+	BaseDir string `payload:"base_dir"`
+}
+
+type webspaceApplyPayload struct {
+	WebspaceID string `payload:"webspace_id"`
+	Runtime    string `payload:"runtime"`
+}
+
+type webspaceBackupPayload struct {
+	backupTargetPayload
+	WebspaceID string `payload:"webspace_id"`
+	WebRoot    string `payload:"web_root,required" alias:"path"`
+	Label      string `payload:"label" alias:"backup_label"`
+}
+
+type webspaceRestorePayload struct {
+	backupTargetPayload
+	WebRoot    string `payload:"web_root,required" alias:"path"`
+	BackupPath string `payload:"backup_path,required"`
+}
+
+type webspaceLogsTailPayload struct {
+	LogsDir string `payload:"logs_dir,required"`
+	LogName string `payload:"log_name,required"`
+	Lines   string `payload:"lines"`
+}
+
+type webspaceCronUpdatePayload struct {
+	OwnerUser string          `payload:"owner_user,required" alias:"username,user"`
+	CronTasks json.RawMessage `payload:"cron_tasks" alias:"tasks"`
+}
+
+type webspaceGitDeployPayload struct {
+	RepoURL string `payload:"repo_url,required" alias:"git_repo_url"`
+	Branch  string `payload:"branch" alias:"git_branch"`
+	Docroot string `payload:"docroot,required" alias:"document_root"`
+}
+
+type webspaceComposerPayload struct {
+	Docroot string `payload:"docroot,required" alias:"document_root"`
+}
+
+type webspaceDomainApplyPayload struct {
+	WebspaceID          string `payload:"webspace_id"`
+	WebRoot             string `payload:"web_root"`
+	TargetPath          string `payload:"target_path"`
+	Domain              string `payload:"domain"`
+	ServerAliases       string `payload:"server_aliases" alias:"aliases"`
+	Docroot             string `payload:"docroot" alias:"document_root"`
+	PhpFpmListen        string `payload:"php_fpm_listen" alias:"fpm_listen"`
+	ExtraDirectives     string `payload:"extra_directives" alias:"nginx_directives"`
+	RedirectHTTPS       string `payload:"redirect_https"`
+	Runtime             string `payload:"runtime"`
+	Action              string `payload:"action"`
+	ProtectedVhostPaths string `payload:"protected_vhost_paths"`
+	NginxVhostPath      string `payload:"nginx_vhost_path" alias:"vhost_path"`
+	SiteName            string `payload:"site_name" alias:"iis_site_name"`
+}
+
+// webspaceFilesPayload scopes a file manager request to a webspace root.
+type webspaceFilesPayload struct {
+	WebspaceID string `payload:"webspace_id"`
+	RootPath   string `payload:"root_path" alias:"web_root"`
+	Path       string `payload:"path" alias:"dir"`
+	TimeoutMS  int    `payload:"timeout_ms" min:"0"`
+	MaxBytes   int    `payload:"max_bytes" min:"0"`
+	MaxEntries int    `payload:"max_entries" min:"0"`
+	ACL        string `payload:"acl" alias:"file_acl"`
+}
+
+type webspaceFileNamePayload struct {
+	webspaceFilesPayload
+	Name string `payload:"name" alias:"file"`
+}
+
+type webspaceFileWritePayload struct {
+	webspaceFilesPayload
+	Name          string `payload:"name" alias:"file"`
+	ContentBase64 string `payload:"content_base64" alias:"content"`
+}
+
+type webspaceDirNamePayload struct {
+	webspaceFilesPayload
+	Name string `payload:"name" alias:"directory"`
+}
+
+type webspaceSftpCredentialsPayload struct {
+	Username  string `payload:"username,required" alias:"sftp_username,user"`
+	Password  string `payload:"password" alias:"sftp_password"`
+	RootPath  string `payload:"root_path,required" alias:"webspace_path,web_root"`
+	SftpGroup string `payload:"sftp_group" alias:"group"`
+	Shell     string `payload:"shell"`
+}
+
+type domainAddPayload struct {
+	Domain           string `payload:"domain,required" alias:"hostname,name"`
+	WebRoot          string `payload:"web_root" alias:"path"`
+	SourceDir        string `payload:"source_dir" alias:"public_dir,docroot_source"`
+	Docroot          string `payload:"docroot" alias:"document_root,docroot_target"`
+	NginxVhostPath   string `payload:"nginx_vhost_path" alias:"vhost_path,nginx_vhost"`
+	NginxIncludePath string `payload:"nginx_include_path" alias:"nginx_include"`
+	PhpFpmListen     string `payload:"php_fpm_listen" alias:"fpm_listen"`
+	LogsDir          string `payload:"logs_dir"`
+	ServerAliases    string `payload:"server_aliases" alias:"aliases"`
+}
+
+type domainSSLPayload struct {
+	Domain        string `payload:"domain,required" alias:"hostname,name"`
+	WebRoot       string `payload:"web_root" alias:"webroot,docroot,document_root,path"`
+	ServerAliases string `payload:"server_aliases" alias:"aliases"`
+	Email         string `payload:"email" alias:"admin_email,cert_email"`
+}
+
+type domainSSLRevokePayload struct {
+	Domain   string `payload:"domain" alias:"hostname,name"`
+	CertPath string `payload:"cert_path"`
+}
+
+func (p domainSSLRevokePayload) validatePayload() []payloadViolation {
+	if p.Domain == "" && p.CertPath == "" {
+		return []payloadViolation{{Field: "cert_path", Rule: "required", Message: "cert_path or domain is required"}}
+	}
+	return nil
+}
+
+type roundcubeInstallPayload struct {
+	Domain        string `payload:"domain" alias:"hostname,name"`
+	Route         string `payload:"route" alias:"path,webmail_path"`
+	RoundcubePath string `payload:"roundcube_path"`
+}
+
+type roundcubeDeployPayload struct {
+	Domain       string `payload:"domain,required"`
+	RoundcubeURL string `payload:"roundcube_url,required" format:"url"`
+}
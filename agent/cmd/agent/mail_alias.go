@@ -69,8 +69,8 @@ func handleMailAliasDelete(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableAliasDelete(job)
 	}
 
-	address := payloadValue(job.Payload, "address", "alias")
-	mapPath := payloadValue(job.Payload, "map_path", "alias_map_path")
+	address := payloadValue(job.Payload, "address")
+	mapPath := payloadValue(job.Payload, "map_path")
 
 	missing := missingValues([]requiredValue{
 		{key: "address", value: address},
@@ -114,9 +114,9 @@ func handleMailAliasDelete(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMailAliasUpsert(job jobs.Job, action string) (jobs.Result, func() error) {
-	address := payloadValue(job.Payload, "address", "alias")
-	destinationsValue := payloadValue(job.Payload, "destinations", "forward_to")
-	mapPath := payloadValue(job.Payload, "map_path", "alias_map_path")
+	address := payloadValue(job.Payload, "address")
+	destinationsValue := payloadValue(job.Payload, "destinations")
+	mapPath := payloadValue(job.Payload, "map_path")
 	enabledValue := payloadValue(job.Payload, "enabled")
 
 	missing := missingValues([]requiredValue{
@@ -183,8 +183,8 @@ func handleMailAliasUpsert(job jobs.Job, action string) (jobs.Result, func() err
 }
 
 func handleMailAliasStatus(job jobs.Job, enabled bool) (jobs.Result, func() error) {
-	address := payloadValue(job.Payload, "address", "alias")
-	mapPath := payloadValue(job.Payload, "map_path", "alias_map_path")
+	address := payloadValue(job.Payload, "address")
+	mapPath := payloadValue(job.Payload, "map_path")
 
 	missing := missingValues([]requiredValue{
 		{key: "address", value: address},
@@ -206,7 +206,7 @@ func handleMailAliasStatus(job jobs.Job, enabled bool) (jobs.Result, func() erro
 
 	action := "disabled"
 	if enabled {
-		destinationValue := payloadValue(job.Payload, "destinations", "forward_to")
+		destinationValue := payloadValue(job.Payload, "destinations")
 		destinations := parseAliasDestinations(destinationValue)
 		if len(destinations) == 0 {
 			return jobs.Result{
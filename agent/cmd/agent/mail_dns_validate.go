@@ -14,7 +14,7 @@ import (
 
 func handleMailDNSValidate(job jobs.Job) (jobs.Result, func() error) {
 	domain := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "domain")))
-	selector := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "selector", "dkim_selector")))
+	selector := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "selector")))
 	if domain == "" {
 		return jobs.Result{
 			JobID:     job.ID,
@@ -24,11 +24,11 @@ func handleMailDNSValidate(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
-	tlsHost := payloadValue(job.Payload, "tls_host", "smtp_host")
+	tlsHost := payloadValue(job.Payload, "tls_host")
 	if tlsHost == "" {
 		tlsHost = domain
 	}
-	tlsPort := parseIntDefault(payloadValue(job.Payload, "tls_port", "smtp_port"), 587)
+	tlsPort := parseIntDefault(payloadValue(job.Payload, "tls_port"), 587)
 
 	engine := validator.NewEngine(
 		validator.NewNetResolver(),
@@ -43,10 +43,10 @@ func handleMailDomainCreate(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableDomainCreate(job)
 	}
 
-	domainName := payloadValue(job.Payload, "domain", "name", "hostname")
-	configPath := payloadValue(job.Payload, "config_path", "domain_config_path", "virtual_domain_path")
-	dkimSelector := payloadValue(job.Payload, "dkim_selector", "selector")
-	dkimDir := payloadValue(job.Payload, "dkim_dir", "dkim_path", "dkim_directory")
+	domainName := payloadValue(job.Payload, "domain")
+	configPath := payloadValue(job.Payload, "config_path")
+	dkimSelector := payloadValue(job.Payload, "dkim_selector")
+	dkimDir := payloadValue(job.Payload, "dkim_dir")
 
 	if dkimSelector == "" {
 		dkimSelector = "default"
@@ -160,9 +160,9 @@ func handleMailDkimRotate(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableDkimRotate(job)
 	}
 
-	domainName := payloadValue(job.Payload, "domain", "name", "hostname")
-	dkimSelector := payloadValue(job.Payload, "selector", "dkim_selector")
-	dkimDir := payloadValue(job.Payload, "dkim_dir", "dkim_path", "dkim_directory")
+	domainName := payloadValue(job.Payload, "domain")
+	dkimSelector := payloadValue(job.Payload, "selector")
+	dkimDir := payloadValue(job.Payload, "dkim_dir")
 
 	if dkimSelector == "" {
 		dkimSelector = "default"
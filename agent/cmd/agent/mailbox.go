@@ -32,10 +32,10 @@ func handleMailboxCreate(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableMailboxCreate(job)
 	}
 
-	address := payloadValue(job.Payload, "address", "email")
+	address := payloadValue(job.Payload, "address")
 	passwordHash := payloadValue(job.Payload, "password_hash", "password")
-	quotaValue := payloadValue(job.Payload, "quota_mb", "quota")
-	enabledValue := payloadValue(job.Payload, "enabled", "active")
+	quotaValue := payloadValue(job.Payload, "quota_mb")
+	enabledValue := payloadValue(job.Payload, "enabled")
 	mapPath, passwdPath, mailDir := resolveMailboxPaths(job.Payload)
 
 	missing := missingValues([]requiredValue{
@@ -121,7 +121,7 @@ func handleMailboxPasswordReset(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableMailboxPasswordReset(job)
 	}
 
-	address := payloadValue(job.Payload, "address", "email")
+	address := payloadValue(job.Payload, "address")
 	passwordHash := payloadValue(job.Payload, "password_hash", "password")
 	_, passwdPath, _ := resolveMailboxPaths(job.Payload)
 
@@ -160,8 +160,8 @@ func handleMailboxQuotaUpdate(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableMailboxQuotaUpdate(job)
 	}
 
-	address := payloadValue(job.Payload, "address", "email")
-	quotaValue := payloadValue(job.Payload, "quota_mb", "quota")
+	address := payloadValue(job.Payload, "address")
+	quotaValue := payloadValue(job.Payload, "quota_mb")
 	_, passwdPath, _ := resolveMailboxPaths(job.Payload)
 
 	if address == "" || quotaValue == "" {
@@ -223,7 +223,7 @@ func handleMailboxDelete(job jobs.Job) (jobs.Result, func() error) {
 		return handleMailEnableMailboxDelete(job)
 	}
 
-	address := payloadValue(job.Payload, "address", "email")
+	address := payloadValue(job.Payload, "address")
 	mapPath, passwdPath, _ := resolveMailboxPaths(job.Payload)
 
 	if address == "" {
@@ -261,7 +261,7 @@ func handleMailboxDelete(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMailboxStatus(job jobs.Job, enabled bool) (jobs.Result, func() error) {
-	address := payloadValue(job.Payload, "address", "email")
+	address := payloadValue(job.Payload, "address")
 	mapPath, _, mailDir := resolveMailboxPaths(job.Payload)
 
 	if address == "" {
@@ -293,7 +293,7 @@ func handleMailboxStatus(job jobs.Job, enabled bool) (jobs.Result, func() error)
 }
 
 func handleMailboxPolicyUpdate(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
 	if address == "" || !strings.Contains(address, "@") {
 		return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"message": "missing or invalid address"}, Completed: time.Now().UTC()}, nil
 	}
@@ -328,9 +328,9 @@ func handleMailboxPolicyUpdate(job jobs.Job) (jobs.Result, func() error) {
 // ── path helpers ──────────────────────────────────────────────────────────────
 
 func resolveMailboxPaths(payload map[string]any) (mapPath, passwdPath, mailDir string) {
-	mapPath = payloadValue(payload, "map_path", "mailbox_map_path")
-	passwdPath = payloadValue(payload, "passwd_path", "dovecot_passwd_path")
-	mailDir = payloadValue(payload, "mail_dir", "mail_storage_path")
+	mapPath = payloadValue(payload, "map_path")
+	passwdPath = payloadValue(payload, "passwd_path")
+	mailDir = payloadValue(payload, "mail_dir")
 	if mapPath == "" {
 		mapPath = defaultMailboxMapPath
 	}
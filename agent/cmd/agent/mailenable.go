@@ -54,7 +54,7 @@ func mailEnablePostofficeForAddress(payload map[string]any, address string) (pos
 	if err != nil {
 		return "", "", "", err
 	}
-	postoffice = strings.TrimSpace(payloadValue(payload, "postoffice", "mailenable_postoffice"))
+	postoffice = strings.TrimSpace(payloadValue(payload, "postoffice"))
 	if postoffice == "" {
 		postoffice = domain
 	}
@@ -62,22 +62,22 @@ func mailEnablePostofficeForAddress(payload map[string]any, address string) (pos
 }
 
 func handleMailEnableDomainCreate(job jobs.Job) (jobs.Result, func() error) {
-	domainName := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "domain", "name", "hostname")))
+	domainName := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "domain")))
 	if domainName == "" {
 		return failureResult(job.ID, fmt.Errorf("missing required values: domain"))
 	}
 	if err := validateDomainName(domainName); err != nil {
 		return failureResult(job.ID, err)
 	}
-	postoffice := strings.TrimSpace(payloadValue(job.Payload, "postoffice", "mailenable_postoffice"))
+	postoffice := strings.TrimSpace(payloadValue(job.Payload, "postoffice"))
 	if postoffice == "" {
 		postoffice = domainName
 	}
-	adminUser := strings.TrimSpace(payloadValue(job.Payload, "admin_user", "mail_admin_user"))
+	adminUser := strings.TrimSpace(payloadValue(job.Payload, "admin_user"))
 	if adminUser == "" {
 		adminUser = "admin"
 	}
-	adminPassword := strings.TrimSpace(payloadValue(job.Payload, "admin_password", "mail_admin_password", "password"))
+	adminPassword := strings.TrimSpace(payloadValue(job.Payload, "admin_password"))
 	if adminPassword == "" {
 		adminPassword = generateSftpPassword()
 	}
@@ -105,10 +105,10 @@ func handleMailEnableDkimRotate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMailEnableMailboxCreate(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
-	password := strings.TrimSpace(payloadValue(job.Payload, "password", "plain_password", "one_time_password", "password_hash"))
-	quotaMB := normalizeMailboxQuota(payloadValue(job.Payload, "quota_mb", "quota"))
-	enabled := normalizeMailboxEnabled(payloadValue(job.Payload, "enabled", "active"), true)
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
+	password := strings.TrimSpace(payloadValue(job.Payload, "password", "password_hash"))
+	quotaMB := normalizeMailboxQuota(payloadValue(job.Payload, "quota_mb"))
+	enabled := normalizeMailboxEnabled(payloadValue(job.Payload, "enabled"), true)
 	if address == "" || password == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address or password"))
 	}
@@ -129,8 +129,8 @@ func handleMailEnableMailboxCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMailEnableMailboxPasswordReset(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
-	password := strings.TrimSpace(payloadValue(job.Payload, "password", "plain_password", "one_time_password", "password_hash"))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
+	password := strings.TrimSpace(payloadValue(job.Payload, "password", "password_hash"))
 	if address == "" || password == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address or password"))
 	}
@@ -146,8 +146,8 @@ func handleMailEnableMailboxPasswordReset(job jobs.Job) (jobs.Result, func() err
 }
 
 func handleMailEnableMailboxQuotaUpdate(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
-	quotaValue := payloadValue(job.Payload, "quota_mb", "quota")
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
+	quotaValue := payloadValue(job.Payload, "quota_mb")
 	if address == "" || quotaValue == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address or quota"))
 	}
@@ -164,7 +164,7 @@ func handleMailEnableMailboxQuotaUpdate(job jobs.Job) (jobs.Result, func() error
 }
 
 func handleMailEnableMailboxStatus(job jobs.Job, enabled bool) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
 	if address == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address"))
 	}
@@ -184,7 +184,7 @@ func handleMailEnableMailboxStatus(job jobs.Job, enabled bool) (jobs.Result, fun
 }
 
 func handleMailEnableMailboxDelete(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "email")))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
 	if address == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address"))
 	}
@@ -207,8 +207,8 @@ func mailEnableQuotaKB(quotaMB int) int {
 }
 
 func handleMailEnableAliasUpsert(job jobs.Job, action string) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "alias")))
-	destinations := parseAliasDestinations(payloadValue(job.Payload, "destinations", "forward_to"))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
+	destinations := parseAliasDestinations(payloadValue(job.Payload, "destinations"))
 	enabled := normalizeMailboxEnabled(payloadValue(job.Payload, "enabled"), true)
 	if address == "" || len(destinations) == 0 {
 		return failureResult(job.ID, fmt.Errorf("missing address or destinations"))
@@ -241,7 +241,7 @@ func handleMailEnableAliasStatus(job jobs.Job, enabled bool) (jobs.Result, func(
 }
 
 func handleMailEnableAliasDelete(job jobs.Job) (jobs.Result, func() error) {
-	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address", "alias")))
+	address := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "address")))
 	if address == "" {
 		return failureResult(job.ID, fmt.Errorf("missing address"))
 	}
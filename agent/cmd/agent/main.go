@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -46,6 +47,7 @@ func main() {
 	}
 	configPath := flag.String("config", "", "path to agent.conf")
 	selfUpdate := flag.Bool("self-update", false, "perform self-update and restart")
+	rollbackUpdate := flag.Bool("rollback-update", false, "restore the previous binary if a pending update was not confirmed (systemd helper)")
 	showVersion := flag.Bool("version", false, "print agent version and exit")
 	wrapperMode := flag.Bool("wrapper", false, "run as game server console wrapper (internal use)")
 	wrapperInstanceID := flag.String("instance-id", "", "instance ID (wrapper mode)")
@@ -78,6 +80,10 @@ func main() {
 		return
 	}
 
+	if *rollbackUpdate {
+		os.Exit(runUpdateRollbackHelper(context.Background(), cfg, logger))
+	}
+
 	lock, err := system.AcquireAgentProcessLock()
 	if err != nil {
 		logger.Error(context.Background(), "agent.lock_failed", "AGENT_ALREADY_RUNNING", fmt.Sprintf("agent process lock failed: %v; if this is an update, stop easywi-agent via systemd before starting the new binary", err), nil)
@@ -96,7 +102,9 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	run(ctx, client, cfg, *configPath, logger)
+	if err := run(ctx, client, cfg, *configPath, logger); errors.Is(err, errAgentDeregistered) {
+		os.Exit(agentDeregisteredExitCode)
+	}
 }
 
 func handleMailCLI(args []string) bool {
@@ -159,12 +167,21 @@ func printMailInstallReport() {
 	}
 }
 
-func run(ctx context.Context, client *api.Client, cfg config.Config, configPath string, logger *logging.JSONLogger) {
+func run(ctx context.Context, client *api.Client, cfg config.Config, configPath string, logger *logging.JSONLogger) error {
 	heartbeatTicker := time.NewTicker(cfg.HeartbeatInterval)
 	pollTicker := time.NewTicker(cfg.PollInterval)
 	defer heartbeatTicker.Stop()
 	defer pollTicker.Stop()
 
+	globalAgentUpdate = newAgentUpdateGuard(cfg.StateDir, configPath)
+	globalAgentUpdate.systemd = system.IsSystemdServiceActive("easywi-agent.service")
+	globalAgentUpdate.logger = logger
+	globalAgentUpdate.probe = func() error { return probeAgentHealth(cfg) }
+	if err := globalAgentUpdate.Load(); err != nil {
+		logger.Error(ctx, "agent.update_state_unavailable", "UPDATE_STATE_UNAVAILABLE", fmt.Sprintf("load update state failed: %v", err), nil)
+	}
+	go globalAgentUpdate.Watch(ctx)
+
 	maxConcurrency := resolveMaxConcurrency(cfg.MaxConcurrency, 0)
 	roles := collectRoles()
 	metadata := collectMetadata(cfg)
@@ -174,160 +191,475 @@ func run(ctx context.Context, client *api.Client, cfg config.Config, configPath
 	lastCredentialRefresh := time.Time{}
 	credentialRefreshCooldown := 2 * time.Minute
 
+	journal, err := openJobJournal(cfg.StateDir)
+	if err != nil {
+		logger.Error(ctx, "agent.job_journal_unavailable", "JOB_JOURNAL_UNAVAILABLE", fmt.Sprintf("open job journal failed; in-flight jobs will not survive restarts: %v", err), nil)
+		journal = nil
+	}
+	defer func() { _ = journal.Close() }()
+	spool, err := openOfflineSpool(cfg.StateDir)
+	if err != nil {
+		logger.Error(ctx, "agent.offline_spool_unavailable", "OFFLINE_SPOOL_UNAVAILABLE", fmt.Sprintf("open offline spool failed; results are dropped while the panel is unreachable: %v", err), nil)
+		spool = nil
+	}
+	executor := &jobExecutor{client: client, agentID: cfg.AgentID, journal: journal, spool: spool, logger: logger}
+
+	if runtime.GOOS != "windows" {
+		seeded, skipped, err := seedLegacyInstancePortLeases()
+		if err != nil {
+			logger.Error(ctx, "agent.port_lease_seed_failed", "PORT_LEASE_SEED_FAILED", fmt.Sprintf("seed port leases of existing instances failed: %v", err), nil)
+		}
+		for instanceID, skipErr := range skipped {
+			logger.Error(ctx, "agent.port_lease_seed_skipped", "PORT_LEASE_SEED_SKIPPED", fmt.Sprintf("existing instance keeps running without port leases: %v", skipErr), map[string]any{"instance_id": instanceID})
+		}
+		if len(seeded) > 0 {
+			logger.Info(ctx, "agent.port_leases_seeded", "leased the legacy ports of existing instances", map[string]any{"instances": seeded})
+		}
+	}
+
+	globalAgentScheduler.logger = logger
+	if err := globalAgentScheduler.Load(); err != nil {
+		logger.Error(ctx, "agent.schedule_load_failed", "SCHEDULE_LOAD_FAILED", fmt.Sprintf("load agent schedules failed: %v", err), nil)
+	}
+	globalAgentScheduler.dispatch = func(job jobs.Job, handler func(jobs.Job)) {
+		instanceLock, lockMode, isStream := resolveJobScheduling(job)
+		runner.Submit(jobTask{job: job, instanceLock: instanceLock, lockMode: lockMode, isStream: isStream, handler: handler})
+	}
+	globalAgentScheduler.report = func(run jobs.ScheduledRun) {
+		executor.reportScheduledRun(ctx, run)
+	}
+	go globalAgentScheduler.Run(ctx)
+
+	globalInstanceSchedulers.dispatch = globalAgentScheduler.dispatch
+	globalInstanceSchedulers.report = globalAgentScheduler.report
+	globalInstanceSchedulers.logger = logger
+	globalInstanceTaskResumes.logger = logger
+	globalInstanceTaskResumes.SetDispatch(func(job jobs.Job) {
+		instanceLock, lockMode, isStream := resolveJobScheduling(job)
+		runner.Submit(jobTask{job: job, instanceLock: instanceLock, lockMode: lockMode, isStream: isStream, handler: func(job jobs.Job) {
+			runResumedInstanceTask(job, globalInstanceSchedulers.report)
+		}})
+	})
+	if err := globalInstanceSchedulers.Load(); err != nil {
+		logger.Error(ctx, "agent.instance_schedule_load_failed", "SCHEDULE_LOAD_FAILED", fmt.Sprintf("load instance schedules failed: %v", err), nil)
+	}
+	go globalInstanceSchedulers.Run(ctx)
+
+	globalInstanceCrashes.report = globalAgentScheduler.report
+	go globalInstanceCrashes.Run(ctx)
+
+	globalTsEvents.report = func(events []map[string]any) error {
+		return executor.reportTsEvents(ctx, events)
+	}
+	go globalTsEvents.Run(ctx)
+	go runTsPoolKeepalive(ctx)
+
 	startServiceServer(ctx, cfg)
 	metricsQueue := make([]map[string]any, 0, 120)
 
+	backoff := newPanelBackoff()
+	reactToPanelError := func(err error, refreshedMessage string) (refreshed bool, stop bool) {
+		reaction, wait := classifyPanelError(err)
+		switch reaction {
+		case panelReactionStop:
+			logger.Error(ctx, "agent.deregistered", "AGENT_DEREGISTERED", fmt.Sprintf("panel no longer knows this agent; stopping: %v", err), nil)
+			return false, true
+		case panelReactionRefreshCredentials:
+			if tryRefreshAgentCredentials(ctx, client, &cfg, configPath, err, &lastCredentialRefresh, credentialRefreshCooldown, logger) {
+				logger.Info(ctx, "agent.credentials_refreshed", refreshedMessage, nil)
+				return true, false
+			}
+		case panelReactionBackoff:
+			delay := backoff.Extend(wait)
+			logger.Info(ctx, "agent.panel_backoff", fmt.Sprintf("panel asked the agent to back off; pausing heartbeats and polls for %s", delay), nil)
+		}
+		return false, false
+	}
+
 	stats := collectStats(version, roles)
 	if metricSnapshot, ok := stats["metrics"].(map[string]any); ok {
 		metricsQueue = append(metricsQueue, metricSnapshot)
 	}
+	stats["offline_spool"] = spool.Stats()
+	stats["job_queue"] = runner.Stats()
+	stats["self_update"] = globalAgentUpdate.Stats()
+	stats["ts_events"] = globalTsEvents.Status()
 	if err := client.SendHeartbeat(ctx, stats, roles, metadata, "online"); err != nil {
 		logger.Error(ctx, "agent.heartbeat_failed", "HEARTBEAT_FAILED", fmt.Sprintf("heartbeat failed: %v", err), nil)
-		if tryRefreshAgentCredentials(ctx, client, &cfg, configPath, err, &lastCredentialRefresh, credentialRefreshCooldown, logger) {
-			logger.Info(ctx, "agent.credentials_refreshed", "agent credentials refreshed; retrying heartbeat", nil)
+		refreshed, stop := reactToPanelError(err, "agent credentials refreshed; retrying heartbeat")
+		if stop {
+			return errAgentDeregistered
+		}
+		if refreshed {
 			if retryErr := client.SendHeartbeat(ctx, stats, roles, metadata, "online"); retryErr != nil {
 				logger.Error(ctx, "agent.heartbeat_retry_failed", "HEARTBEAT_RETRY_FAILED", fmt.Sprintf("heartbeat retry failed: %v", retryErr), nil)
+			} else {
+				globalAgentUpdate.HeartbeatSucceeded(ctx)
+				metricsQueue = executor.flushOffline(ctx, metricsQueue)
+			}
+		}
+	} else {
+		globalAgentUpdate.HeartbeatSucceeded(ctx)
+		metricsQueue = executor.flushOffline(ctx, metricsQueue)
+	}
+
+	dispatchCoreJobs := func(jobsList []jobs.Job) {
+		logSender := newApiJobLogSender(client, spool)
+		for _, job := range jobsList {
+			jobCopy := job
+			if jobCopy.CancelRequested {
+				globalJobCancels.Cancel(jobCopy.ID, payloadValue(jobCopy.Payload, "cancel_reason"))
+				continue
+			}
+			if journal.Has(jobCopy.ID) {
+				continue
 			}
+			if err := journal.Received(jobJournalKindCore, jobCopy); err != nil {
+				logger.Error(ctx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": jobCopy.ID})
+			}
+			if jobCopy.Type == jobCancelJobType {
+				// Cancellation must not queue behind the jobs it is meant to abort.
+				go executor.runCoreJob(ctx, logSender, jobCopy)
+				continue
+			}
+			instanceLock, lockMode, isStream := resolveJobScheduling(jobCopy)
+			runner.Submit(jobTask{
+				job:          jobCopy,
+				instanceLock: instanceLock,
+				lockMode:     lockMode,
+				isStream:     isStream,
+				handler: func(job jobs.Job) {
+					executor.runCoreJob(ctx, logSender, job)
+				},
+			})
 		}
-	} else if len(metricsQueue) > 0 {
-		if err := client.SendMetricsBatch(ctx, metricsQueue); err == nil {
-			metricsQueue = metricsQueue[:0]
+	}
+	dispatchOrchestratorJobs := func(orchestratorJobs []jobs.Job) {
+		for _, job := range orchestratorJobs {
+			jobCopy := job
+			if jobCopy.CancelRequested {
+				globalJobCancels.Cancel(jobCopy.ID, payloadValue(jobCopy.Payload, "cancel_reason"))
+				continue
+			}
+			if journal.Has(jobCopy.ID) {
+				continue
+			}
+			if err := journal.Received(jobJournalKindOrchestrator, jobCopy); err != nil {
+				logger.Error(ctx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": jobCopy.ID})
+			}
+			if jobCopy.Type == jobCancelJobType {
+				go executor.runOrchestratorJob(ctx, jobCopy)
+				continue
+			}
+			runner.Submit(jobTask{
+				job:      jobCopy,
+				lockMode: jobLockNone,
+				handler: func(job jobs.Job) {
+					executor.runOrchestratorJob(ctx, job)
+				},
+			})
 		}
 	}
 
+	push := newJobPushChannel(client, logger)
+	if !cfg.DisableJobPush {
+		go push.Run(ctx)
+	}
+	lastPoll := time.Time{}
+
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			executor.spoolMetrics(metricsQueue)
+			return nil
 		case <-heartbeatTicker.C:
 			roles = collectRoles()
 			metadata = collectMetadata(cfg)
 			stats := collectStats(version, roles)
+			stats["offline_spool"] = spool.Stats()
+			stats["job_queue"] = runner.Stats()
+			stats["self_update"] = globalAgentUpdate.Stats()
+			stats["job_push"] = push.Stats()
+			stats["ts_events"] = globalTsEvents.Status()
 			if metricSnapshot, ok := stats["metrics"].(map[string]any); ok {
 				metricsQueue = append(metricsQueue, metricSnapshot)
 				if len(metricsQueue) > 120 {
 					metricsQueue = metricsQueue[len(metricsQueue)-120:]
 				}
 			}
+			if backoff.Active() {
+				continue
+			}
 			if err := client.SendHeartbeat(ctx, stats, roles, metadata, "online"); err != nil {
 				logger.Error(ctx, "agent.heartbeat_failed", "HEARTBEAT_FAILED", fmt.Sprintf("heartbeat failed: %v", err), nil)
-				if tryRefreshAgentCredentials(ctx, client, &cfg, configPath, err, &lastCredentialRefresh, credentialRefreshCooldown, logger) {
-					logger.Info(ctx, "agent.credentials_refreshed", "agent credentials refreshed; heartbeat will use the new secret", nil)
-				}
-			} else if len(metricsQueue) > 0 {
-				batch := metricsQueue
-				if len(batch) > 50 {
-					batch = batch[:50]
+				if _, stop := reactToPanelError(err, "agent credentials refreshed; heartbeat will use the new secret"); stop {
+					return errAgentDeregistered
 				}
-				if err := client.SendMetricsBatch(ctx, batch); err == nil {
-					metricsQueue = metricsQueue[len(batch):]
+				if len(metricsQueue) >= spoolMetricsBatch && executor.spoolMetrics(metricsQueue) {
+					metricsQueue = metricsQueue[:0]
 				}
+			} else {
+				backoff.Reset()
+				globalAgentUpdate.HeartbeatSucceeded(ctx)
+				metricsQueue = executor.flushOffline(ctx, metricsQueue)
+			}
+		case message := <-push.Messages():
+			if message.MaxConcurrent > 0 {
+				maxConcurrency = resolveMaxConcurrency(cfg.MaxConcurrency, message.MaxConcurrent)
+				runner.SetLimit(maxConcurrency)
 			}
+			dispatchCoreJobs(message.Jobs)
+			dispatchOrchestratorJobs(message.AgentJobs)
 		case <-pollTicker.C:
+			if backoff.Active() {
+				continue
+			}
+			if push.Connected() && time.Since(lastPoll) < jobPushReconcileInterval {
+				continue
+			}
+			lastPoll = time.Now()
 			jobsList, reportedConcurrency, err := client.PollJobs(ctx)
 			if err != nil {
 				logger.Error(ctx, "agent.poll_jobs_failed", "POLL_JOBS_FAILED", fmt.Sprintf("poll jobs failed: %v", err), nil)
-				if tryRefreshAgentCredentials(ctx, client, &cfg, configPath, err, &lastCredentialRefresh, credentialRefreshCooldown, logger) {
-					logger.Info(ctx, "agent.credentials_refreshed", "agent credentials refreshed after poll auth failure", nil)
+				if _, stop := reactToPanelError(err, "agent credentials refreshed after poll auth failure"); stop {
+					return errAgentDeregistered
 				}
 				continue
 			}
 			maxConcurrency = resolveMaxConcurrency(maxConcurrency, reportedConcurrency)
-			logSender := newApiJobLogSender(client)
-			for _, job := range jobsList {
-				jobCopy := job
-				instanceLock, lockMode, isStream := resolveJobScheduling(jobCopy)
-				runner.Submit(jobTask{
-					job:          jobCopy,
-					instanceLock: instanceLock,
-					lockMode:     lockMode,
-					isStream:     isStream,
-					handler: func(job jobs.Job) {
-						jobCorrelationID := payloadValue(job.Payload, "correlation_id", "request_id", "trace_id")
-						if strings.TrimSpace(job.CorrelationID) != "" {
-							jobCorrelationID = job.CorrelationID
-						}
-						jobCtx := trace.WithIDs(ctx, payloadValue(job.Payload, "request_id"), jobCorrelationID)
-						if err := client.StartJob(jobCtx, job.ID); err != nil {
-							logger.Error(jobCtx, "agent.start_job_failed", "START_JOB_FAILED", fmt.Sprintf("start job failed: %v", err), map[string]any{"job_id": job.ID})
-							return
-						}
-						result, afterSubmit := handleJob(job, withConsoleLogMirroring(job, logSender))
-						if err := client.SubmitJobResult(jobCtx, result); err != nil {
-							logger.Error(jobCtx, "agent.submit_job_result_failed", "SUBMIT_JOB_RESULT_FAILED", fmt.Sprintf("submit job result failed: %v", err), map[string]any{"job_id": job.ID})
-							return
-						}
-						if afterSubmit != nil {
-							if err := afterSubmit(); err != nil {
-								logger.Error(jobCtx, "agent.post_submit_failed", "POST_SUBMIT_FAILED", fmt.Sprintf("post-submit job action failed: %v", err), map[string]any{"job_id": job.ID})
-							}
-						}
-					},
-				})
-			}
+			dispatchCoreJobs(jobsList)
 
 			orchestratorJobs, reportedAgentConcurrency, err := client.PollAgentJobs(ctx, cfg.AgentID, maxConcurrency)
 			if err != nil {
 				logger.Error(ctx, "agent.poll_orchestrator_jobs_failed", "POLL_ORCHESTRATOR_FAILED", fmt.Sprintf("poll orchestrator jobs failed: %v", err), nil)
-				if tryRefreshAgentCredentials(ctx, client, &cfg, configPath, err, &lastCredentialRefresh, credentialRefreshCooldown, logger) {
-					logger.Info(ctx, "agent.credentials_refreshed", "agent credentials refreshed after orchestrator poll auth failure", nil)
+				if _, stop := reactToPanelError(err, "agent credentials refreshed after orchestrator poll auth failure"); stop {
+					return errAgentDeregistered
 				}
 				continue
 			}
 			maxConcurrency = resolveMaxConcurrency(cfg.MaxConcurrency, reportedAgentConcurrency)
 			runner.SetLimit(maxConcurrency)
-			for _, job := range orchestratorJobs {
-				jobCopy := job
-				runner.Submit(jobTask{
-					job:      jobCopy,
-					lockMode: jobLockNone,
-					handler: func(job jobs.Job) {
-						jobCorrelationID := payloadValue(job.Payload, "correlation_id", "request_id", "trace_id")
-						if strings.TrimSpace(job.CorrelationID) != "" {
-							jobCorrelationID = job.CorrelationID
-						}
-						jobCtx := trace.WithIDs(ctx, payloadValue(job.Payload, "request_id"), jobCorrelationID)
-						if err := client.StartAgentJob(jobCtx, cfg.AgentID, job.ID); err != nil {
-							logger.Error(jobCtx, "agent.start_orchestrator_job_failed", "START_ORCHESTRATOR_JOB_FAILED", fmt.Sprintf("start orchestrator job failed: %v", err), map[string]any{"job_id": job.ID})
-							return
-						}
-						result := handleOrchestratorJob(job)
-						snapshotLength := 0
-						snapshotPresent := false
-						resultPayloadKeys := ""
-						if result.resultPayload != nil {
-							keys := make([]string, 0, len(result.resultPayload))
-							for k := range result.resultPayload {
-								keys = append(keys, k)
-							}
-							sort.Strings(keys)
-							resultPayloadKeys = strings.Join(keys, ",")
-							if snapshotValue, ok := result.resultPayload["snapshot"].(string); ok {
-								snapshotLength = len(strings.TrimSpace(snapshotValue))
-								snapshotPresent = snapshotLength > 0
-							}
-						}
-						logger.Info(jobCtx, "agent.finish_orchestrator_job_payload", "finishing orchestrator job", map[string]any{
-							"job_id":              job.ID,
-							"job_type":            job.Type,
-							"result_status":       result.status,
-							"result_payload_keys": resultPayloadKeys,
-							"snapshot_present":    snapshotPresent,
-							"snapshot_length":     snapshotLength,
-							"error_text":          result.errorText,
-						})
-						if err := client.FinishAgentJob(jobCtx, cfg.AgentID, job.ID, result.status, result.logText, result.errorText, result.resultPayload); err != nil {
-							logger.Error(jobCtx, "agent.finish_orchestrator_job_failed", "FINISH_ORCHESTRATOR_JOB_FAILED", fmt.Sprintf("finish orchestrator job failed: %v", err), map[string]any{"job_id": job.ID})
-						}
-					},
-				})
-			}
+			dispatchOrchestratorJobs(orchestratorJobs)
 		}
 	}
 }
 
+// jobExecutor bundles what a polled job needs to report its outcome: the API client,
+// the write-ahead journal and the offline spool used while the panel is unreachable.
+type jobExecutor struct {
+	client  *api.Client
+	agentID string
+	journal *jobJournal
+	spool   *offlineSpool
+	logger  *logging.JSONLogger
+}
+
+// flushOffline runs after a successful heartbeat: it reports journaled jobs from a
+// previous run, drains the offline spool in order and then sends queued metrics. It
+// returns the metrics that are still queued in memory.
+func (e *jobExecutor) flushOffline(ctx context.Context, metricsQueue []map[string]any) []map[string]any {
+	replayJobJournal(ctx, e.client, e.agentID, e.journal, e.spool, e.logger)
+	if e.spool.Len() > 0 {
+		delivered, err := e.spool.Drain(ctx, e.client, e.agentID, spoolDrainBatch, e.spoolDelivered)
+		if err != nil {
+			e.logger.Error(ctx, "agent.offline_spool_drain_failed", "OFFLINE_SPOOL_DRAIN_FAILED", fmt.Sprintf("drain offline spool failed: %v", err), map[string]any{"delivered": delivered})
+			// Keep newer metrics behind the spooled ones instead of overtaking them.
+			return metricsQueue
+		}
+		if delivered > 0 {
+			e.logger.Info(ctx, "agent.offline_spool_drained", "delivered spooled records", map[string]any{"delivered": delivered, "remaining": e.spool.Len()})
+		}
+		if e.spool.Len() > 0 {
+			return metricsQueue
+		}
+	}
+	if len(metricsQueue) == 0 {
+		return metricsQueue
+	}
+	batch := metricsQueue
+	if len(batch) > spoolMetricsBatch {
+		batch = batch[:spoolMetricsBatch]
+	}
+	if err := e.client.SendMetricsBatch(ctx, batch); err != nil {
+		return metricsQueue
+	}
+	return metricsQueue[len(batch):]
+}
+
+func (e *jobExecutor) spoolDelivered(record spoolRecord) {
+	kind := jobJournalKindCore
+	switch record.Kind {
+	case spoolKindAgentFinish:
+		kind = jobJournalKindOrchestrator
+	case spoolKindJobResult:
+	default:
+		return
+	}
+	if err := e.journal.Submitted(kind, record.JobID); err != nil {
+		e.logger.Error(context.Background(), "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": record.JobID})
+	}
+}
+
+// spoolMetrics moves queued metrics to disk so they survive an outage or restart.
+func (e *jobExecutor) spoolMetrics(metricsQueue []map[string]any) bool {
+	if len(metricsQueue) == 0 || e.spool == nil {
+		return false
+	}
+	if err := spoolMetrics(e.spool, metricsQueue); err != nil {
+		e.logger.Error(context.Background(), "agent.spool_metrics_failed", "SPOOL_METRICS_FAILED", fmt.Sprintf("spool metrics failed: %v", err), map[string]any{"samples": len(metricsQueue)})
+		return false
+	}
+	return true
+}
+
+// reportScheduledRun tells the panel about a job the local scheduler ran. Reports that
+// cannot be delivered are spooled with the run's own trace IDs.
+func (e *jobExecutor) reportScheduledRun(ctx context.Context, run jobs.ScheduledRun) {
+	reportCtx, cancel := context.WithTimeout(trace.WithIDs(ctx, run.RequestID, run.CorrelationID), 10*time.Second)
+	defer cancel()
+	fields := map[string]any{"schedule_id": run.ScheduleID, "job_id": run.JobID, "job_type": run.JobType, "status": run.Status}
+	e.logger.Info(reportCtx, "agent.scheduled_job_finished", "scheduled job finished", fields)
+	if err := e.client.SubmitScheduledRun(reportCtx, run); err == nil {
+		return
+	}
+	if err := e.spool.Enqueue(spoolKindScheduledRun, run.JobID, run); err != nil {
+		e.logger.Error(reportCtx, "agent.scheduled_run_report_failed", "SCHEDULED_RUN_REPORT_FAILED", fmt.Sprintf("report scheduled run failed: %v", err), fields)
+	}
+}
+
+// reportTsEvents forwards a batch of TeamSpeak notifications. Spooled events
+// are drained first so the panel sees the stream in order; while they cannot be
+// delivered the batch queues behind them. A batch the panel does not take is
+// spooled within the spool's small event budget, or dropped when the spool is
+// under pressure so events never push out job results.
+func (e *jobExecutor) reportTsEvents(ctx context.Context, events []map[string]any) error {
+	reportCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var sendErr error
+	if e.spool.Pending(spoolKindTsEvents) > 0 {
+		_, sendErr = e.spool.Drain(reportCtx, e.client, e.agentID, spoolDrainBatch, e.spoolDelivered)
+		if sendErr == nil && e.spool.Pending(spoolKindTsEvents) > 0 {
+			sendErr = fmt.Errorf("%d spooled teamspeak event batches still pending", e.spool.Pending(spoolKindTsEvents))
+		}
+	}
+	if sendErr == nil {
+		if sendErr = e.client.SendTsEvents(reportCtx, events); sendErr == nil {
+			return nil
+		}
+	}
+	kept, err := spoolTsEvents(e.spool, events)
+	if err != nil {
+		return err
+	}
+	if !kept {
+		e.logger.Info(reportCtx, "agent.ts_events_dropped", "teamspeak events dropped while the spool is under pressure", map[string]any{"events": len(events), "error": sendErr.Error()})
+	}
+	return nil
+}
+
+func jobTraceContext(ctx context.Context, job jobs.Job) context.Context {
+	jobCorrelationID := payloadValue(job.Payload, "correlation_id", "request_id", "trace_id")
+	if strings.TrimSpace(job.CorrelationID) != "" {
+		jobCorrelationID = job.CorrelationID
+	}
+	return trace.WithIDs(ctx, payloadValue(job.Payload, "request_id"), jobCorrelationID)
+}
+
+func (e *jobExecutor) runCoreJob(ctx context.Context, logSender JobLogSender, job jobs.Job) {
+	client, journal, logger := e.client, e.journal, e.logger
+	jobCtx := jobTraceContext(ctx, job)
+	if err := client.StartJob(jobCtx, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.start_job_failed", "START_JOB_FAILED", fmt.Sprintf("start job failed: %v", err), map[string]any{"job_id": job.ID})
+		_ = journal.Discard(jobJournalKindCore, job.ID)
+		return
+	}
+	if err := journal.Started(jobJournalKindCore, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+	result, afterSubmit := runCancellableJob(job, withConsoleLogMirroring(job, logSender))
+	if err := journal.FinishedCore(result); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+	err := client.SubmitJobResult(jobCtx, result)
+	if panelAlreadyAccepted(err) {
+		logger.Info(jobCtx, "agent.job_result_already_accepted", "panel already holds the job result", map[string]any{"job_id": job.ID})
+		err = nil
+	}
+	if err != nil {
+		logger.Error(jobCtx, "agent.submit_job_result_failed", "SUBMIT_JOB_RESULT_FAILED", fmt.Sprintf("submit job result failed: %v", err), map[string]any{"job_id": job.ID})
+		if spoolErr := spoolJobResult(e.spool, result); spoolErr != nil {
+			logger.Error(jobCtx, "agent.spool_job_result_failed", "SPOOL_JOB_RESULT_FAILED", fmt.Sprintf("spool job result failed: %v", spoolErr), map[string]any{"job_id": job.ID})
+			return
+		}
+		logger.Info(jobCtx, "agent.job_result_spooled", "job result spooled until the panel is reachable", map[string]any{"job_id": job.ID})
+	} else if err := journal.Submitted(jobJournalKindCore, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+	if afterSubmit != nil {
+		if err := afterSubmit(); err != nil {
+			logger.Error(jobCtx, "agent.post_submit_failed", "POST_SUBMIT_FAILED", fmt.Sprintf("post-submit job action failed: %v", err), map[string]any{"job_id": job.ID})
+		}
+	}
+}
+
+func (e *jobExecutor) runOrchestratorJob(ctx context.Context, job jobs.Job) {
+	client, agentID, journal, logger := e.client, e.agentID, e.journal, e.logger
+	jobCtx := jobTraceContext(ctx, job)
+	if err := client.StartAgentJob(jobCtx, agentID, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.start_orchestrator_job_failed", "START_ORCHESTRATOR_JOB_FAILED", fmt.Sprintf("start orchestrator job failed: %v", err), map[string]any{"job_id": job.ID})
+		_ = journal.Discard(jobJournalKindOrchestrator, job.ID)
+		return
+	}
+	if err := journal.Started(jobJournalKindOrchestrator, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+	result := runCancellableOrchestratorJob(job)
+	if err := journal.FinishedOrchestrator(job.ID, result); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+	snapshotLength := 0
+	snapshotPresent := false
+	resultPayloadKeys := ""
+	if result.resultPayload != nil {
+		keys := make([]string, 0, len(result.resultPayload))
+		for k := range result.resultPayload {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		resultPayloadKeys = strings.Join(keys, ",")
+		if snapshotValue, ok := result.resultPayload["snapshot"].(string); ok {
+			snapshotLength = len(strings.TrimSpace(snapshotValue))
+			snapshotPresent = snapshotLength > 0
+		}
+	}
+	logger.Info(jobCtx, "agent.finish_orchestrator_job_payload", "finishing orchestrator job", map[string]any{
+		"job_id":              job.ID,
+		"job_type":            job.Type,
+		"result_status":       result.status,
+		"result_payload_keys": resultPayloadKeys,
+		"snapshot_present":    snapshotPresent,
+		"snapshot_length":     snapshotLength,
+		"error_text":          result.errorText,
+	})
+	err := client.FinishAgentJob(jobCtx, agentID, job.ID, result.status, result.logText, result.errorText, result.resultPayload)
+	if panelAlreadyAccepted(err) {
+		logger.Info(jobCtx, "agent.job_result_already_accepted", "panel already holds the job result", map[string]any{"job_id": job.ID})
+		err = nil
+	}
+	if err != nil {
+		logger.Error(jobCtx, "agent.finish_orchestrator_job_failed", "FINISH_ORCHESTRATOR_JOB_FAILED", fmt.Sprintf("finish orchestrator job failed: %v", err), map[string]any{"job_id": job.ID})
+		if spoolErr := spoolAgentFinish(e.spool, job.ID, result); spoolErr != nil {
+			logger.Error(jobCtx, "agent.spool_job_result_failed", "SPOOL_JOB_RESULT_FAILED", fmt.Sprintf("spool job result failed: %v", spoolErr), map[string]any{"job_id": job.ID})
+		}
+		return
+	}
+	if err := journal.Submitted(jobJournalKindOrchestrator, job.ID); err != nil {
+		logger.Error(jobCtx, "agent.job_journal_failed", "JOB_JOURNAL_FAILED", fmt.Sprintf("journal job failed: %v", err), map[string]any{"job_id": job.ID})
+	}
+}
+
 func tryRefreshAgentCredentials(ctx context.Context, client *api.Client, cfg *config.Config, configPath string, requestErr error, lastRefresh *time.Time, cooldown time.Duration, logger *logging.JSONLogger) bool {
-	if !isAuthFailure(requestErr) {
+	if !api.IsAuthFailure(requestErr) {
 		return false
 	}
 	if strings.TrimSpace(cfg.BootstrapToken) == "" {
@@ -356,14 +688,6 @@ func tryRefreshAgentCredentials(ctx context.Context, client *api.Client, cfg *co
 	return true
 }
 
-func isAuthFailure(err error) bool {
-	if err == nil {
-		return false
-	}
-	msg := strings.ToLower(err.Error())
-	return strings.Contains(msg, "api error 401") || strings.Contains(msg, "unauthorized")
-}
-
 func resolveMaxConcurrency(configured int, reported int) int {
 	if configured < 1 {
 		configured = 1
@@ -387,6 +711,7 @@ const (
 
 type jobTask struct {
 	job          jobs.Job
+	lane         jobLane
 	instanceLock string
 	lockMode     jobLockMode
 	isStream     bool
@@ -394,13 +719,14 @@ type jobTask struct {
 }
 
 type jobRunner struct {
-	tasks         chan jobTask
+	queue         *jobQueue
 	stopWorker    chan struct{}
 	locks         *instanceLockManager
 	streamLimiter chan struct{}
 	maxStreamJobs int
 	mu            sync.Mutex
 	limit         int
+	running       int
 }
 
 func newJobRunner(limit int, maxStreamJobs int) *jobRunner {
@@ -408,8 +734,8 @@ func newJobRunner(limit int, maxStreamJobs int) *jobRunner {
 		maxStreamJobs = 1
 	}
 	runner := &jobRunner{
-		tasks:         make(chan jobTask, 200),
-		stopWorker:    make(chan struct{}, 200),
+		queue:         newJobQueue(jobQueueCapacity),
+		stopWorker:    make(chan struct{}, jobQueueCapacity),
 		locks:         newInstanceLockManager(),
 		streamLimiter: make(chan struct{}, maxStreamJobs),
 		maxStreamJobs: maxStreamJobs,
@@ -443,14 +769,32 @@ func (r *jobRunner) Submit(task jobTask) {
 	if task.handler == nil {
 		return
 	}
-	r.tasks <- task
+	r.queue.Push(task)
+}
+
+// Stats reports queue saturation for the heartbeat.
+func (r *jobRunner) Stats() map[string]any {
+	stats := r.queue.Stats()
+	r.mu.Lock()
+	stats["running"] = r.running
+	stats["workers"] = r.limit
+	r.mu.Unlock()
+	return stats
 }
 
 func (r *jobRunner) worker() {
 	for {
 		select {
-		case task := <-r.tasks:
+		case <-r.stopWorker:
+			return
+		default:
+		}
+		if task, ok := r.queue.Pop(); ok {
 			r.execute(task)
+			continue
+		}
+		select {
+		case <-r.queue.ready:
 		case <-r.stopWorker:
 			return
 		}
@@ -458,6 +802,14 @@ func (r *jobRunner) worker() {
 }
 
 func (r *jobRunner) execute(task jobTask) {
+	r.mu.Lock()
+	r.running++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
 	run := func() {
 		task.handler(task.job)
 	}
@@ -571,7 +923,7 @@ func resolveJobScheduling(job jobs.Job) (instanceLock string, lockMode jobLockMo
 	jobType, _ := normalizeJobType(job.Type)
 	if strings.HasPrefix(jobType, "instance.") {
 		switch jobType {
-		case "instance.start", "instance.stop", "instance.restart", "instance.create", "instance.delete", "instance.config.apply", "instance.reinstall", "instance.backup.restore", "instance.files.write", "instance.files.delete", "instance.files.mkdir":
+		case "instance.start", "instance.stop", "instance.restart", "instance.create", "instance.delete", "instance.config.apply", "instance.reinstall", "instance.backup.restore", "instance.files.write", "instance.files.delete", "instance.files.mkdir", instanceResourcesApplyJobType, instanceTaskRunJobType:
 			return instanceLock, jobLockWrite, false
 		case "instance.logs.tail":
 			return instanceLock, jobLockRead, true
@@ -629,13 +981,27 @@ func collectStats(version string, roles []string) map[string]any {
 	return stats
 }
 
-func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+func handleJob(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
 	jobType, _ := normalizeJobType(job.Type)
 	if err := ensureJobSupportedByPlatform(jobType); err != nil {
 		return failureResult(job.ID, err)
 	}
+	job, violations := globalJobSchemas.prepareJob(job)
+	if len(violations) > 0 {
+		return invalidPayloadResult(job.ID, violations), nil
+	}
 
 	switch jobType {
+	case jobCancelJobType:
+		return handleJobCancel(job)
+	case agentScheduleSyncJobType:
+		return handleAgentScheduleSync(job)
+	case agentScheduleDeleteJobType:
+		return handleAgentScheduleDelete(job)
+	case agentScheduleListJobType:
+		return handleAgentScheduleList(job)
+	case portLeasesListJobType:
+		return handlePortLeasesList(job)
 	case "agent.update":
 		return handleAgentUpdate(job)
 	case "agent.self_update":
@@ -675,15 +1041,15 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 	case "webspace.provision":
 		return handleWebspaceCreate(job)
 	case "webspace.backup":
-		return handleWebspaceBackup(job)
+		return handleWebspaceBackup(ctx, job)
 	case "webspace.restore":
-		return handleWebspaceRestore(job)
+		return handleWebspaceRestore(ctx, job)
 	case "webspace.logs.tail":
 		return handleWebspaceLogsTail(job)
 	case "webspace.cron.update":
 		return handleWebspaceCronUpdate(job)
 	case "webspace.git.deploy":
-		return handleWebspaceGitDeploy(job)
+		return handleWebspaceGitDeploy(ctx, job)
 	case "webspace.composer.install":
 		return handleWebspaceComposerInstall(job)
 	case "webspace.domain.apply":
@@ -785,11 +1151,13 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 	case "instance.console.command":
 		return handleInstanceConsoleCommand(job, logSender)
 	case "instance.reinstall":
-		return handleInstanceReinstall(job, logSender)
+		return handleInstanceReinstall(ctx, job, logSender)
 	case "instance.backup.create":
-		return handleInstanceBackupCreate(job)
+		return handleInstanceBackupCreate(ctx, job)
+	case "instance.backup.prune":
+		return handleInstanceBackupPrune(job)
 	case "instance.backup.restore":
-		return handleInstanceBackupRestore(job)
+		return handleInstanceBackupRestore(ctx, job)
 	case "instance.addon.install":
 		return handleInstanceAddonInstall(job)
 	case "instance.addon.update":
@@ -816,6 +1184,20 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 		return handleWebspaceSftpCredentialsReset(job)
 	case "instance.config.apply":
 		return handleInstanceConfigApply(job)
+	case instanceResourcesApplyJobType:
+		return handleInstanceResourcesApply(job)
+	case instanceTaskRunJobType:
+		return handleInstanceTaskRun(ctx, job)
+	case instanceScheduleSyncJobType:
+		return handleInstanceScheduleSync(job)
+	case instanceScheduleDeleteJobType:
+		return handleInstanceScheduleDelete(job)
+	case instanceScheduleListJobType:
+		return handleInstanceScheduleList(job)
+	case instanceCrashReportJobType:
+		return handleInstanceCrashReport(job)
+	case instanceCrashResetJobType:
+		return handleInstanceCrashReset(job)
 	case "instance.watchdog.check":
 		return handleInstanceWatchdogCheck(job, logSender)
 	case "core.ssh.policy.apply":
@@ -831,11 +1213,11 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 	case "instance.sftp.access.disable":
 		return handleInstanceSftpAccessDisable(job)
 	case "sniper.install":
-		return handleSniperInstall(job, logSender)
+		return handleSniperInstall(ctx, job, logSender)
 	case "sniper.update":
-		return handleSniperUpdate(job, logSender)
+		return handleSniperUpdate(ctx, job, logSender)
 	case "sniper.shared_update":
-		return handleSniperSharedUpdate(job, logSender)
+		return handleSniperSharedUpdate(ctx, job, logSender)
 	case "node.disk.stat":
 		return handleNodeDiskStat(job)
 	case "webspace.files.list":
@@ -875,7 +1257,7 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 	case "security.events.collect":
 		return handleSecurityEventsCollect(job)
 	case "ts3.create":
-		return handleTs3Create(job, logSender)
+		return handleTs3Create(ctx, job, logSender)
 	case "ts3.start":
 		return handleTs3Start(job)
 	case "ts3.stop":
@@ -885,7 +1267,7 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 	case "ts3.update":
 		return handleTs3Update(job)
 	case "ts3.backup":
-		return handleTs3Backup(job)
+		return handleTs3Backup(ctx, job)
 	case "ts3.restore":
 		return handleTs3Restore(job)
 	case "ts3.token.reset":
@@ -913,9 +1295,9 @@ func handleJob(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error)
 }
 
 func handleAgentUpdate(job jobs.Job) (jobs.Result, func() error) {
-	downloadURL := payloadValue(job.Payload, "download_url", "artifact_url", "url")
-	checksumsURL := payloadValue(job.Payload, "checksums_url", "checksum_url", "checksums")
-	signatureURL := payloadValue(job.Payload, "signature_url", "checksums_signature_url", "checksum_signature_url")
+	downloadURL := payloadValue(job.Payload, "download_url")
+	checksumsURL := payloadValue(job.Payload, "checksums_url")
+	signatureURL := payloadValue(job.Payload, "signature_url")
 	assetName := payloadValue(job.Payload, "asset_name")
 
 	if downloadURL == "" || checksumsURL == "" {
@@ -942,6 +1324,17 @@ func handleAgentUpdate(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
+	if runtime.GOOS != "windows" {
+		if err := globalAgentUpdate.Arm(job, updatePlan.BinaryPath); err != nil {
+			return jobs.Result{
+				JobID:     job.ID,
+				Status:    "failed",
+				Output:    map[string]string{"message": err.Error()},
+				Completed: time.Now().UTC(),
+			}, nil
+		}
+	}
+
 	result := jobs.Result{
 		JobID:     job.ID,
 		Status:    "success",
@@ -10,7 +10,7 @@ import (
 )
 
 func handleMariaDBDatabaseCreate(job jobs.Job) (jobs.Result, func() error) {
-	database := payloadValue(job.Payload, "database", "name")
+	database := payloadValue(job.Payload, "database")
 	charset := payloadValue(job.Payload, "charset")
 	collation := payloadValue(job.Payload, "collation")
 
@@ -43,10 +43,10 @@ func handleMariaDBDatabaseCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMariaDBUserCreate(job jobs.Job) (jobs.Result, func() error) {
-	username := payloadValue(job.Payload, "username", "user")
-	host := payloadValue(job.Payload, "host", "allowed_host")
-	allowedSubnet := payloadValue(job.Payload, "allowed_subnet", "web_subnet", "subnet")
-	encryptedPassword := payloadValue(job.Payload, "encrypted_password", "password_encrypted")
+	username := payloadValue(job.Payload, "username")
+	host := payloadValue(job.Payload, "host")
+	allowedSubnet := payloadValue(job.Payload, "allowed_subnet")
+	encryptedPassword := payloadValue(job.Payload, "encrypted_password")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
@@ -83,11 +83,11 @@ func handleMariaDBUserCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleMariaDBGrantApply(job jobs.Job) (jobs.Result, func() error) {
-	database := payloadValue(job.Payload, "database", "name")
-	username := payloadValue(job.Payload, "username", "user")
-	host := payloadValue(job.Payload, "host", "allowed_host")
-	allowedSubnet := payloadValue(job.Payload, "allowed_subnet", "web_subnet", "subnet")
-	privileges := payloadValue(job.Payload, "privileges", "grants")
+	database := payloadValue(job.Payload, "database")
+	username := payloadValue(job.Payload, "username")
+	host := payloadValue(job.Payload, "host")
+	allowedSubnet := payloadValue(job.Payload, "allowed_subnet")
+	privileges := payloadValue(job.Payload, "privileges")
 
 	missing := missingValues([]requiredValue{
 		{key: "database", value: database},
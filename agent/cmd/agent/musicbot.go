@@ -173,7 +173,7 @@ func handleMusicbotStatus(job jobs.Job) orchestratorResult {
 	if err := validateMusicbotServiceName(serviceName); err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
-	installPath, pathErr := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+	installPath, pathErr := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 	lastError := ""
 	if pathErr == nil {
 		if data, err := os.ReadFile(filepath.Join(installPath, "last_error.txt")); err == nil {
@@ -213,7 +213,7 @@ func handleMusicbotStatus(job jobs.Job) orchestratorResult {
 }
 
 func handleMusicbotPlaybackAction(job jobs.Job) orchestratorResult {
-	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
@@ -246,7 +246,7 @@ func handleMusicbotConnectionTest(job jobs.Job) orchestratorResult {
 	if platform != "teamspeak" && platform != "discord" {
 		return orchestratorResult{status: "failed", errorText: "platform must be teamspeak or discord"}
 	}
-	installPath, pathErr := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+	installPath, pathErr := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 	if pathErr == nil {
 		response, err := NewRuntimeControlClient(installPath).Command("connection_status", map[string]any{"platform": platform})
 		if err == nil {
@@ -263,7 +263,7 @@ func handleMusicbotConnectionTest(job jobs.Job) orchestratorResult {
 }
 
 func handleMusicbotQueueSync(job jobs.Job) orchestratorResult {
-	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
@@ -368,7 +368,7 @@ type musicbotLayout struct {
 }
 
 func resolveMusicbotLayout(job jobs.Job) (musicbotLayout, error) {
-	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+	installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 	if err != nil {
 		return musicbotLayout{}, err
 	}
@@ -443,7 +443,7 @@ func musicbotUseSystemctl(job jobs.Job) bool {
 }
 
 func resolveMusicbotRuntimeBinary(job jobs.Job) (string, error) {
-	binary := strings.TrimSpace(payloadValue(job.Payload, "runtime_binary", "runtime_binary_path"))
+	binary := strings.TrimSpace(payloadValue(job.Payload, "runtime_binary"))
 	if binary == "" {
 		binary = "/usr/local/bin/easywi-musicbot"
 	}
@@ -621,7 +621,7 @@ WantedBy=multi-user.target
 func handleMusicbotServiceAction(job jobs.Job) orchestratorResult {
 	action := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "action")))
 	if (action == "start" || action == "restart") && runtime.GOOS != "windows" {
-		installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path", "install_dir"))
+		installPath, err := validateMusicbotInstallPath(payloadValue(job.Payload, "install_path"))
 		if err == nil {
 			if err := checkMusicbotRuntimeAccess(installPath, job); err != nil {
 				return orchestratorResult{status: "failed", errorText: err.Error()}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -353,7 +354,7 @@ func TestHandleMusicbotConfigApplyJobDispatch(t *testing.T) {
 	dir := t.TempDir()
 	installPath := filepath.Join(dir, "musicbot", "dispatch-test")
 
-	result := handleOrchestratorJob(jobs.Job{
+	result := handleOrchestratorJob(context.Background(), jobs.Job{
 		ID:   "job-dispatch",
 		Type: "musicbot.config.apply",
 		Payload: map[string]any{
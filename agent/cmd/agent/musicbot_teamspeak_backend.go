@@ -528,7 +528,7 @@ func teamspeakBackendConfigFromJob(job jobs.Job) teamspeakBackendConfig {
 		InstallPath:         strings.TrimSpace(payloadValue(job.Payload, "install_path")),
 		BinaryPath:          binaryPath,
 		Version:             strings.TrimSpace(payloadValue(job.Payload, "version")),
-		ExpectedChecksum:    strings.TrimSpace(payloadValue(job.Payload, "expected_checksum", "checksum")),
+		ExpectedChecksum:    strings.TrimSpace(payloadValue(job.Payload, "expected_checksum")),
 		AutoInstall:         payloadBool(job.Payload, "auto_install_enabled"),
 		Host:                strings.TrimSpace(payloadValue(job.Payload, "host")),
 		Port:                port,
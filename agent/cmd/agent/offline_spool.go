@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/api"
+	"easywi/agent/internal/jobs"
+)
+
+// spoolKind identifies which API call a spooled record replays.
+type spoolKind string
+
+const (
+	spoolKindJobResult    spoolKind = "job_result"
+	spoolKindAgentFinish  spoolKind = "agent_job_finish"
+	spoolKindJobLogs      spoolKind = "job_logs"
+	spoolKindMetricsBatch spoolKind = "metrics_batch"
+	spoolKindScheduledRun spoolKind = "scheduled_run"
+	spoolKindTsEvents     spoolKind = "ts_events"
+)
+
+const (
+	spoolDirName        = "spool"
+	spoolMaxRecords     = 5000
+	spoolMaxBytes       = 64 << 20
+	spoolDrainBatch     = 200
+	spoolMetricsBatch   = 50
+	spoolRecordFileMode = 0o600
+	// TeamSpeak events only get a small slice of the spool so chat and
+	// client chatter can never crowd out job results during an outage.
+	spoolMaxTsEventRecords = 100
+)
+
+// spoolRecord is one failed API call persisted for later delivery.
+type spoolRecord struct {
+	Seq       uint64          `json:"seq"`
+	Kind      spoolKind       `json:"kind"`
+	JobID     string          `json:"job_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Body      json.RawMessage `json:"body"`
+	size      int64
+}
+
+type spooledAgentFinish struct {
+	Status        string         `json:"status"`
+	LogText       string         `json:"log_text,omitempty"`
+	ErrorText     string         `json:"error_text,omitempty"`
+	ResultPayload map[string]any `json:"result_payload,omitempty"`
+}
+
+type spooledJobLogs struct {
+	Lines    []string `json:"lines"`
+	Progress *int     `json:"progress,omitempty"`
+}
+
+// spoolTransport is the subset of the API client needed to deliver spooled records.
+type spoolTransport interface {
+	SubmitJobResult(ctx context.Context, result jobs.Result) error
+	FinishAgentJob(ctx context.Context, agentID, jobID, status string, logText string, errorText string, resultPayload map[string]any) error
+	SubmitJobLogs(ctx context.Context, jobID string, logs []string, progress *int) error
+	SendMetricsBatch(ctx context.Context, samples []map[string]any) error
+	SubmitScheduledRun(ctx context.Context, run jobs.ScheduledRun) error
+	SendTsEvents(ctx context.Context, events []map[string]any) error
+}
+
+// offlineSpool is a bounded on-disk FIFO for job results, job logs and metric batches
+// that could not be delivered while the panel was unreachable. Every record is its
+// own file named by sequence number, so a crash can lose at most the record being
+// written and draining never has to rewrite the whole spool.
+type offlineSpool struct {
+	mu sync.Mutex
+	// drainMu serialises drains, so two callers never deliver the same head
+	// record twice.
+	drainMu    sync.Mutex
+	dir        string
+	nextSeq    uint64
+	records    []spoolRecord
+	totalBytes int64
+	maxRecords int
+	maxBytes   int64
+	maxTsEvent int
+	dropped    int
+	rejected   int
+}
+
+func openOfflineSpool(stateDir string) (*offlineSpool, error) {
+	dir := filepath.Join(stateDir, spoolDirName)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create spool dir: %w", err)
+	}
+	spool := &offlineSpool{dir: dir, nextSeq: 1, maxRecords: spoolMaxRecords, maxBytes: spoolMaxBytes, maxTsEvent: spoolMaxTsEventRecords}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var record spoolRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.Seq == 0 {
+			// Half-written record from a crash; it was never acknowledged to anyone.
+			_ = os.Remove(path)
+			continue
+		}
+		record.size = int64(len(data))
+		spool.records = append(spool.records, record)
+		spool.totalBytes += record.size
+		if record.Seq >= spool.nextSeq {
+			spool.nextSeq = record.Seq + 1
+		}
+	}
+	sort.Slice(spool.records, func(a, b int) bool { return spool.records[a].Seq < spool.records[b].Seq })
+	return spool, nil
+}
+
+func (s *offlineSpool) recordPath(record spoolRecord) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d-%s.json", record.Seq, record.Kind))
+}
+
+// Enqueue persists a record. When the spool is full the oldest records of the least
+// important kind are evicted first: TeamSpeak events, metrics, logs, scheduled runs,
+// then results. TeamSpeak events are additionally capped at maxTsEvent records.
+func (s *offlineSpool) Enqueue(kind spoolKind, jobID string, body any) error {
+	if s == nil {
+		return fmt.Errorf("offline spool unavailable")
+	}
+	encodedBody, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode spool body: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record := spoolRecord{Seq: s.nextSeq, Kind: kind, JobID: jobID, CreatedAt: time.Now().UTC(), Body: encodedBody}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode spool record: %w", err)
+	}
+	record.size = int64(len(encoded))
+	if record.size > s.maxBytes {
+		return fmt.Errorf("spool record of %d bytes exceeds spool capacity", record.size)
+	}
+	if kind == spoolKindTsEvents {
+		for s.countLocked(spoolKindTsEvents) >= s.maxTsEvent {
+			if !s.evictKindLocked(spoolKindTsEvents) {
+				break
+			}
+		}
+	}
+	for len(s.records) > 0 && (len(s.records)+1 > s.maxRecords || s.totalBytes+record.size > s.maxBytes) {
+		if !s.evictLocked() {
+			break
+		}
+	}
+
+	path := s.recordPath(record)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, encoded, spoolRecordFileMode); err != nil {
+		return fmt.Errorf("write spool record: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit spool record: %w", err)
+	}
+	s.nextSeq++
+	s.records = append(s.records, record)
+	s.totalBytes += record.size
+	return nil
+}
+
+func (s *offlineSpool) evictLocked() bool {
+	for _, kind := range []spoolKind{spoolKindTsEvents, spoolKindMetricsBatch, spoolKindJobLogs, spoolKindScheduledRun, spoolKindJobResult, spoolKindAgentFinish} {
+		if s.evictKindLocked(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// evictKindLocked drops the oldest record of kind.
+func (s *offlineSpool) evictKindLocked(kind spoolKind) bool {
+	for idx, record := range s.records {
+		if record.Kind != kind {
+			continue
+		}
+		s.removeLocked(idx)
+		s.dropped++
+		return true
+	}
+	return false
+}
+
+func (s *offlineSpool) countLocked(kind spoolKind) int {
+	count := 0
+	for _, record := range s.records {
+		if record.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// UnderPressure reports whether the spool is at least half full, by records or bytes.
+func (s *offlineSpool) UnderPressure() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)*2 >= s.maxRecords || s.totalBytes*2 >= s.maxBytes
+}
+
+func (s *offlineSpool) removeLocked(idx int) {
+	record := s.records[idx]
+	_ = os.Remove(s.recordPath(record))
+	s.totalBytes -= record.size
+	s.records = append(s.records[:idx], s.records[idx+1:]...)
+}
+
+// HasJob reports whether a result for the job is still waiting in the spool.
+func (s *offlineSpool) HasJob(jobID string) bool {
+	if s == nil || jobID == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.records {
+		if record.JobID == jobID && (record.Kind == spoolKindJobResult || record.Kind == spoolKindAgentFinish) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns spool depth figures for the heartbeat.
+func (s *offlineSpool) Stats() map[string]any {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byKind := map[string]int{}
+	for _, record := range s.records {
+		byKind[string(record.Kind)]++
+	}
+	stats := map[string]any{
+		"records":  len(s.records),
+		"bytes":    s.totalBytes,
+		"dropped":  s.dropped,
+		"rejected": s.rejected,
+		"by_kind":  byKind,
+	}
+	if len(s.records) > 0 {
+		stats["oldest_at"] = s.records[0].CreatedAt.Format(time.RFC3339)
+	}
+	return stats
+}
+
+func (s *offlineSpool) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// Pending returns how many records of kind wait for delivery.
+func (s *offlineSpool) Pending(kind spoolKind) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.countLocked(kind)
+}
+
+// Drain delivers up to limit records in order. It stops at the first failure so the
+// panel is not flooded while it is still recovering; the remaining records are
+// retried on the next successful heartbeat. Records the panel rejects with a
+// permanent client error are dropped instead. onDelivered is called for each record
+// after it has been removed from the spool.
+func (s *offlineSpool) Drain(ctx context.Context, transport spoolTransport, agentID string, limit int, onDelivered func(spoolRecord)) (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+	if limit <= 0 {
+		limit = spoolDrainBatch
+	}
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	delivered := 0
+	for delivered < limit {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+		s.mu.Lock()
+		if len(s.records) == 0 {
+			s.mu.Unlock()
+			return delivered, nil
+		}
+		record := s.records[0]
+		s.mu.Unlock()
+
+		err := deliverSpoolRecord(ctx, transport, agentID, record)
+		if panelAlreadyAccepted(err) {
+			err = nil
+		}
+		// A record the panel rejects outright would block the spool forever;
+		// it is dropped and counted like a delivery so the journal lets go too.
+		rejected := err != nil && api.IsPermanent(err)
+		if err != nil && !rejected {
+			return delivered, fmt.Errorf("deliver spooled %s seq=%d: %w", record.Kind, record.Seq, err)
+		}
+
+		s.mu.Lock()
+		if len(s.records) > 0 && s.records[0].Seq == record.Seq {
+			s.removeLocked(0)
+		}
+		if rejected {
+			s.rejected++
+		}
+		s.mu.Unlock()
+		delivered++
+		if onDelivered != nil {
+			onDelivered(record)
+		}
+	}
+	return delivered, nil
+}
+
+func deliverSpoolRecord(ctx context.Context, transport spoolTransport, agentID string, record spoolRecord) error {
+	switch record.Kind {
+	case spoolKindJobResult:
+		var result jobs.Result
+		if err := json.Unmarshal(record.Body, &result); err != nil {
+			return nil
+		}
+		return transport.SubmitJobResult(ctx, result)
+	case spoolKindAgentFinish:
+		var finish spooledAgentFinish
+		if err := json.Unmarshal(record.Body, &finish); err != nil {
+			return nil
+		}
+		return transport.FinishAgentJob(ctx, agentID, record.JobID, finish.Status, finish.LogText, finish.ErrorText, finish.ResultPayload)
+	case spoolKindJobLogs:
+		var logs spooledJobLogs
+		if err := json.Unmarshal(record.Body, &logs); err != nil {
+			return nil
+		}
+		return transport.SubmitJobLogs(ctx, record.JobID, logs.Lines, logs.Progress)
+	case spoolKindMetricsBatch:
+		var samples []map[string]any
+		if err := json.Unmarshal(record.Body, &samples); err != nil {
+			return nil
+		}
+		return transport.SendMetricsBatch(ctx, samples)
+	case spoolKindScheduledRun:
+		var run jobs.ScheduledRun
+		if err := json.Unmarshal(record.Body, &run); err != nil {
+			return nil
+		}
+		return transport.SubmitScheduledRun(ctx, run)
+	case spoolKindTsEvents:
+		var events []map[string]any
+		if err := json.Unmarshal(record.Body, &events); err != nil {
+			return nil
+		}
+		return transport.SendTsEvents(ctx, events)
+	default:
+		// Unknown kinds come from a newer agent version; drop them instead of blocking the queue.
+		return nil
+	}
+}
+
+func spoolJobResult(spool *offlineSpool, result jobs.Result) error {
+	return spool.Enqueue(spoolKindJobResult, result.JobID, result)
+}
+
+func spoolAgentFinish(spool *offlineSpool, jobID string, result orchestratorResult) error {
+	return spool.Enqueue(spoolKindAgentFinish, jobID, spooledAgentFinish{
+		Status:        result.status,
+		LogText:       result.logText,
+		ErrorText:     result.errorText,
+		ResultPayload: result.resultPayload,
+	})
+}
+
+func spoolMetrics(spool *offlineSpool, samples []map[string]any) error {
+	return spool.Enqueue(spoolKindMetricsBatch, "", samples)
+}
+
+// spoolTsEvents keeps a TeamSpeak event batch for later delivery unless the spool
+// is already under pressure; then the batch is dropped and counted, so events never
+// compete with job results for the remaining space. It reports whether the batch
+// was kept.
+func spoolTsEvents(spool *offlineSpool, events []map[string]any) (bool, error) {
+	if spool == nil {
+		return false, fmt.Errorf("offline spool unavailable")
+	}
+	if spool.UnderPressure() {
+		spool.mu.Lock()
+		spool.dropped++
+		spool.mu.Unlock()
+		return false, nil
+	}
+	if err := spool.Enqueue(spoolKindTsEvents, "", events); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"easywi/agent/internal/api"
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/logging"
+)
+
+type fakeSpoolTransport struct {
+	fakeJobResultReporter
+	logs      map[string][]string
+	metrics   [][]map[string]any
+	order     []spoolKind
+	failAfter int
+}
+
+func (f *fakeSpoolTransport) deliver(kind spoolKind) error {
+	if f.failAfter > 0 && len(f.order) >= f.failAfter {
+		return errors.New("panel unreachable")
+	}
+	f.order = append(f.order, kind)
+	return nil
+}
+
+func (f *fakeSpoolTransport) SubmitJobResult(ctx context.Context, result jobs.Result) error {
+	if err := f.deliver(spoolKindJobResult); err != nil {
+		return err
+	}
+	return f.fakeJobResultReporter.SubmitJobResult(ctx, result)
+}
+
+func (f *fakeSpoolTransport) FinishAgentJob(ctx context.Context, agentID, jobID, status string, logText string, errorText string, resultPayload map[string]any) error {
+	if err := f.deliver(spoolKindAgentFinish); err != nil {
+		return err
+	}
+	return f.fakeJobResultReporter.FinishAgentJob(ctx, agentID, jobID, status, logText, errorText, resultPayload)
+}
+
+func (f *fakeSpoolTransport) SubmitJobLogs(_ context.Context, jobID string, logs []string, _ *int) error {
+	if err := f.deliver(spoolKindJobLogs); err != nil {
+		return err
+	}
+	if f.logs == nil {
+		f.logs = map[string][]string{}
+	}
+	f.logs[jobID] = append(f.logs[jobID], logs...)
+	return nil
+}
+
+func (f *fakeSpoolTransport) SendMetricsBatch(_ context.Context, samples []map[string]any) error {
+	if err := f.deliver(spoolKindMetricsBatch); err != nil {
+		return err
+	}
+	f.metrics = append(f.metrics, samples)
+	return nil
+}
+
+func (f *fakeSpoolTransport) SubmitScheduledRun(_ context.Context, _ jobs.ScheduledRun) error {
+	return f.deliver(spoolKindScheduledRun)
+}
+
+func (f *fakeSpoolTransport) SendTsEvents(_ context.Context, _ []map[string]any) error {
+	return f.deliver(spoolKindTsEvents)
+}
+
+func TestOfflineSpoolDrainsInOrderAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := openOfflineSpool(dir)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	if err := spool.Enqueue(spoolKindJobLogs, "job-1", spooledJobLogs{Lines: []string{"line"}}); err != nil {
+		t.Fatalf("enqueue logs: %v", err)
+	}
+	if err := spoolJobResult(spool, jobs.Result{JobID: "job-1", Status: "success"}); err != nil {
+		t.Fatalf("enqueue result: %v", err)
+	}
+	if err := spoolMetrics(spool, []map[string]any{{"cpu": 1.0}}); err != nil {
+		t.Fatalf("enqueue metrics: %v", err)
+	}
+	if err := spoolAgentFinish(spool, "ts3-1", orchestratorResult{status: "success"}); err != nil {
+		t.Fatalf("enqueue finish: %v", err)
+	}
+
+	reopened, err := openOfflineSpool(dir)
+	if err != nil {
+		t.Fatalf("reopen spool: %v", err)
+	}
+	if reopened.Len() != 4 || !reopened.HasJob("job-1") || !reopened.HasJob("ts3-1") {
+		t.Fatalf("expected all records to survive reopen, got %d", reopened.Len())
+	}
+
+	transport := &fakeSpoolTransport{}
+	var delivered []string
+	count, err := reopened.Drain(context.Background(), transport, "agent-1", 10, func(record spoolRecord) {
+		delivered = append(delivered, record.JobID)
+	})
+	if err != nil || count != 4 {
+		t.Fatalf("expected 4 delivered records, got %d (%v)", count, err)
+	}
+	want := []spoolKind{spoolKindJobLogs, spoolKindJobResult, spoolKindMetricsBatch, spoolKindAgentFinish}
+	for idx, kind := range want {
+		if transport.order[idx] != kind {
+			t.Fatalf("expected delivery order %v, got %v", want, transport.order)
+		}
+	}
+	if transport.finished["ts3-1"].status != "success" || len(transport.logs["job-1"]) != 1 {
+		t.Fatalf("unexpected delivered payloads: %#v %#v", transport.finished, transport.logs)
+	}
+	if len(delivered) != 4 || reopened.Len() != 0 || reopened.HasJob("job-1") {
+		t.Fatalf("expected empty spool after drain, got %d records", reopened.Len())
+	}
+}
+
+func TestOfflineSpoolDrainStopsAtFirstFailure(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		_ = spoolJobResult(spool, jobs.Result{JobID: id, Status: "success"})
+	}
+
+	transport := &fakeSpoolTransport{failAfter: 1}
+	count, err := spool.Drain(context.Background(), transport, "agent-1", 10, nil)
+	if err == nil || count != 1 {
+		t.Fatalf("expected drain to stop after one record, got %d (%v)", count, err)
+	}
+	if spool.Len() != 2 || !spool.HasJob("b") {
+		t.Fatalf("expected undelivered records to stay queued, got %d", spool.Len())
+	}
+
+	count, err = spool.Drain(context.Background(), &fakeSpoolTransport{}, "agent-1", 1, nil)
+	if err != nil || count != 1 || spool.Len() != 1 {
+		t.Fatalf("expected drain limit to apply, got %d delivered, %d left (%v)", count, spool.Len(), err)
+	}
+}
+
+func TestOfflineSpoolEvictsMetricsBeforeResults(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	spool.maxRecords = 3
+
+	_ = spoolJobResult(spool, jobs.Result{JobID: "job-1", Status: "success"})
+	_ = spoolMetrics(spool, []map[string]any{{"cpu": 1.0}})
+	_ = spool.Enqueue(spoolKindJobLogs, "job-1", spooledJobLogs{Lines: []string{"line"}})
+	_ = spoolJobResult(spool, jobs.Result{JobID: "job-2", Status: "success"})
+	_ = spoolJobResult(spool, jobs.Result{JobID: "job-3", Status: "success"})
+
+	if spool.Len() != 3 {
+		t.Fatalf("expected spool to stay bounded, got %d", spool.Len())
+	}
+	for _, id := range []string{"job-1", "job-2", "job-3"} {
+		if !spool.HasJob(id) {
+			t.Fatalf("expected result %s to be kept over metrics and logs", id)
+		}
+	}
+	if stats := spool.Stats(); stats["dropped"] != 2 {
+		t.Fatalf("expected 2 dropped records, got %#v", stats)
+	}
+}
+
+func TestOfflineSpoolEvictsTsEventsBeforeResults(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	spool.maxRecords = 4
+	spool.maxTsEvent = 10
+
+	_ = spoolJobResult(spool, jobs.Result{JobID: "job-1", Status: "success"})
+	for i := 0; i < 20; i++ {
+		if err := spool.Enqueue(spoolKindTsEvents, "", []map[string]any{{"event": "textmessage"}}); err != nil {
+			t.Fatalf("enqueue ts events: %v", err)
+		}
+	}
+
+	if spool.Len() != 4 || !spool.HasJob("job-1") {
+		t.Fatalf("expected job result to survive a flood of ts events, got %d records", spool.Len())
+	}
+	byKind := spool.Stats()["by_kind"].(map[string]int)
+	if byKind[string(spoolKindTsEvents)] != 3 {
+		t.Fatalf("expected ts events to fill only the remaining slots, got %#v", byKind)
+	}
+}
+
+func TestOfflineSpoolCapsTsEventRecords(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	spool.maxTsEvent = 2
+	for i := 0; i < 5; i++ {
+		_ = spool.Enqueue(spoolKindTsEvents, "", []map[string]any{{"event": "textmessage"}})
+	}
+	if spool.Len() != 2 {
+		t.Fatalf("expected ts events capped at 2 records, got %d", spool.Len())
+	}
+
+	spool.maxRecords = 4
+	if kept, err := spoolTsEvents(spool, []map[string]any{{"event": "textmessage"}}); err != nil || kept {
+		t.Fatalf("expected batch dropped under pressure, got kept=%v (%v)", kept, err)
+	}
+	if spool.Len() != 2 {
+		t.Fatalf("expected dropped batch not to be spooled, got %d", spool.Len())
+	}
+}
+
+func TestReportTsEventsDrainsSpooledEventsFirst(t *testing.T) {
+	var mu sync.Mutex
+	available := false
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Events []map[string]any `json:"events"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for _, event := range body.Events {
+			received = append(received, event["event"].(string))
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(server.URL, "agent-1", "secret", "test")
+	if err != nil {
+		t.Fatalf("create api client: %v", err)
+	}
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	executor := &jobExecutor{client: client, agentID: "agent-1", spool: spool, logger: logging.NewJSONLogger(io.Discard, "agent", "agent-1")}
+
+	_ = spool.Enqueue(spoolKindTsEvents, "", []map[string]any{{"event": "first"}})
+	if err := executor.reportTsEvents(context.Background(), []map[string]any{{"event": "second"}}); err != nil {
+		t.Fatalf("report while unreachable: %v", err)
+	}
+	if pending := spool.Pending(spoolKindTsEvents); pending != 2 {
+		t.Fatalf("expected the batch queued behind the spooled one, got %d pending", pending)
+	}
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+	if err := executor.reportTsEvents(context.Background(), []map[string]any{{"event": "third"}}); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"first", "second", "third"}; len(received) != len(want) || received[0] != want[0] || received[1] != want[1] || received[2] != want[2] {
+		t.Fatalf("received %v, want %v", received, want)
+	}
+	if spool.Len() != 0 {
+		t.Fatalf("expected the spool drained, got %d records", spool.Len())
+	}
+}
+
+func TestReplayJobJournalSkipsSpooledResults(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	_ = journal.Received(jobJournalKindCore, jobs.Job{ID: "job-1", Type: "instance.start"})
+	_ = journal.FinishedCore(jobs.Result{JobID: "job-1", Status: "success"})
+	_ = journal.Close()
+
+	reopened, err := openJobJournal(dir)
+	if err != nil {
+		t.Fatalf("reopen journal: %v", err)
+	}
+	defer reopened.Close()
+	spool, err := openOfflineSpool(dir)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	_ = spoolJobResult(spool, jobs.Result{JobID: "job-1", Status: "success"})
+
+	reporter := &fakeJobResultReporter{}
+	if delivered := replayJobJournal(context.Background(), reporter, "agent-1", reopened, spool, nil); delivered != 0 {
+		t.Fatalf("expected spooled job to be left to the spool, got %d", delivered)
+	}
+	if !reopened.Has("job-1") {
+		t.Fatal("expected journal entry to remain until the spool delivers it")
+	}
+}
+
+type rejectingSpoolTransport struct {
+	fakeSpoolTransport
+	reject string
+	status int
+}
+
+func (f *rejectingSpoolTransport) SubmitJobResult(ctx context.Context, result jobs.Result) error {
+	if result.JobID == f.reject {
+		if f.status != 0 {
+			return &api.Error{StatusCode: f.status, Status: http.StatusText(f.status)}
+		}
+		return &api.Error{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	}
+	return f.fakeSpoolTransport.SubmitJobResult(ctx, result)
+}
+
+func TestOfflineSpoolDropsRecordsThePanelRejects(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	for _, id := range []string{"gone", "kept"} {
+		_ = spoolJobResult(spool, jobs.Result{JobID: id, Status: "success"})
+	}
+
+	transport := &rejectingSpoolTransport{reject: "gone"}
+	var released []string
+	count, err := spool.Drain(context.Background(), transport, "agent-1", 10, func(record spoolRecord) {
+		released = append(released, record.JobID)
+	})
+	if err != nil || count != 2 || spool.Len() != 0 {
+		t.Fatalf("expected rejected record not to block the spool, got %d delivered, %d left (%v)", count, spool.Len(), err)
+	}
+	if len(released) != 2 || len(transport.coreResults) != 1 || transport.coreResults[0].JobID != "kept" {
+		t.Fatalf("expected both records released and the second delivered, got %v", released)
+	}
+	if stats := spool.Stats(); stats["rejected"] != 1 {
+		t.Fatalf("expected one rejected record, got %#v", stats)
+	}
+}
+
+func TestOfflineSpoolCountsConflictsAsDelivered(t *testing.T) {
+	spool, err := openOfflineSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	_ = spoolJobResult(spool, jobs.Result{JobID: "accepted", Status: "success"})
+
+	transport := &rejectingSpoolTransport{reject: "accepted", status: http.StatusConflict}
+	count, err := spool.Drain(context.Background(), transport, "agent-1", 10, nil)
+	if err != nil || count != 1 || spool.Len() != 0 {
+		t.Fatalf("expected the accepted result released, got %d delivered, %d left (%v)", count, spool.Len(), err)
+	}
+	if stats := spool.Stats(); stats["rejected"] != 0 {
+		t.Fatalf("expected a conflict not to count as rejected, got %#v", stats)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -52,7 +53,11 @@ var teamspeakVersionRegex = regexp.MustCompile(`\d+\.\d+(?:\.\d+)*(?:[A-Za-z])?(
 
 const defaultSinusbotTs3ClientURL = "https://files.teamspeak-services.com/releases/client/3.6.2/TeamSpeak3-Client-linux_amd64-3.6.2.run"
 
-func handleOrchestratorJob(job jobs.Job) orchestratorResult {
+func handleOrchestratorJob(ctx context.Context, job jobs.Job) orchestratorResult {
+	job, violations := globalJobSchemas.prepareJob(job)
+	if len(violations) > 0 {
+		return convertJobResult(invalidPayloadResult(job.ID, violations), nil)
+	}
 	switch job.Type {
 	case "ts3.install":
 		return handleTs3NodeInstall(job)
@@ -61,10 +66,10 @@ func handleOrchestratorJob(job jobs.Job) orchestratorResult {
 	case "ts3.status":
 		return handleServiceStatus(job)
 	case "ts3.instance.create":
-		result, afterSubmit := handleTs3Create(job, nil)
+		result, afterSubmit := handleTs3Create(ctx, job, nil)
 		return convertJobResult(result, afterSubmit)
 	case "ts3.instance.action":
-		return handleTs3InstanceAction(job)
+		return handleTs3InstanceAction(ctx, job)
 	case "ts6.install":
 		return handleTs6NodeInstall(job)
 	case "ts6.service.action":
@@ -193,10 +198,63 @@ func handleOrchestratorJob(job jobs.Job) orchestratorResult {
 		return handleTs3VirtualSnapshotRestore(job)
 	case "ts6.virtual.snapshot.restore":
 		return handleTs6VirtualSnapshotRestore(job)
+	case "ts3.virtual.servergroup.create", "ts6.virtual.servergroup.create":
+		return handleTsServerGroupCreate(job)
+	case "ts3.virtual.servergroup.copy", "ts6.virtual.servergroup.copy":
+		return handleTsServerGroupCopy(job)
+	case "ts3.virtual.servergroup.rename", "ts6.virtual.servergroup.rename":
+		return handleTsServerGroupRename(job)
+	case "ts3.virtual.servergroup.delete", "ts6.virtual.servergroup.delete":
+		return handleTsServerGroupDelete(job)
+	case "ts3.virtual.servergroup.client.add", "ts6.virtual.servergroup.client.add":
+		return handleTsServerGroupClientAdd(job)
+	case "ts3.virtual.servergroup.client.remove", "ts6.virtual.servergroup.client.remove":
+		return handleTsServerGroupClientRemove(job)
+	case "ts3.virtual.servergroup.perm.edit", "ts6.virtual.servergroup.perm.edit":
+		return handleTsServerGroupPermEdit(job)
+	case "ts3.virtual.channel.create", "ts6.virtual.channel.create":
+		return handleTsChannelCreate(job)
+	case "ts3.virtual.channel.edit", "ts6.virtual.channel.edit":
+		return handleTsChannelEdit(job)
+	case "ts3.virtual.channel.move", "ts6.virtual.channel.move":
+		return handleTsChannelMove(job)
+	case "ts3.virtual.channel.delete", "ts6.virtual.channel.delete":
+		return handleTsChannelDelete(job)
+	case "ts3.virtual.channel.layout.apply", "ts6.virtual.channel.layout.apply":
+		return handleTsChannelLayoutApply(job)
+	case "ts3.virtual.channel.perm.edit", "ts6.virtual.channel.perm.edit":
+		return handleTsChannelPermEdit(job)
+	case "ts3.virtual.privilegekey.create", "ts6.virtual.privilegekey.create":
+		return handleTsPrivilegeKeyCreate(job)
+	case "ts3.virtual.migration.export", "ts6.virtual.migration.export":
+		return handleTsMigrationExport(ctx, job)
+	case "ts3.virtual.migration.import", "ts6.virtual.migration.import":
+		return handleTsMigrationImport(ctx, job)
+	case "ts3.virtual.migration.decommission", "ts6.virtual.migration.decommission":
+		return handleTsMigrationDecommission(job)
+	case "ts3.virtual.file.list", "ts6.virtual.file.list":
+		return handleTsFileList(job)
+	case "ts3.virtual.file.upload", "ts6.virtual.file.upload":
+		return handleTsFileUpload(ctx, job)
+	case "ts3.virtual.file.download", "ts6.virtual.file.download":
+		return handleTsFileDownload(ctx, job)
+	case "ts3.virtual.file.delete", "ts6.virtual.file.delete":
+		return handleTsFileDelete(job)
+	case "ts3.virtual.events.subscribe":
+		return handleTs3VirtualEventsSubscribe(job)
+	case "ts6.virtual.events.subscribe":
+		return handleTs6VirtualEventsSubscribe(job)
+	case "ts3.virtual.events.unsubscribe":
+		return handleTs3VirtualEventsUnsubscribe(job)
+	case "ts6.virtual.events.unsubscribe":
+		return handleTs6VirtualEventsUnsubscribe(job)
 	case "ts3.viewer.snapshot", "ts6.viewer.snapshot":
 		return handleViewerSnapshot(job)
 	case "admin.ssh_key.store":
 		return handleAdminSshKeyStore(job)
+	case jobCancelJobType:
+		result, afterSubmit := handleJobCancel(job)
+		return convertJobResult(result, afterSubmit)
 	default:
 		return orchestratorResult{
 			status:    "failed",
@@ -205,7 +263,7 @@ func handleOrchestratorJob(job jobs.Job) orchestratorResult {
 	}
 }
 
-func handleTs3InstanceAction(job jobs.Job) orchestratorResult {
+func handleTs3InstanceAction(ctx context.Context, job jobs.Job) orchestratorResult {
 	action := strings.ToLower(payloadValue(job.Payload, "action"))
 	switch action {
 	case "start":
@@ -221,7 +279,7 @@ func handleTs3InstanceAction(job jobs.Job) orchestratorResult {
 		result, afterSubmit := handleTs3Update(job)
 		return convertJobResult(result, afterSubmit)
 	case "backup":
-		result, afterSubmit := handleTs3Backup(job)
+		result, afterSubmit := handleTs3Backup(ctx, job)
 		return convertJobResult(result, afterSubmit)
 	case "restore":
 		result, afterSubmit := handleTs3Restore(job)
@@ -421,8 +479,8 @@ func handleTs3NodeInstall(job jobs.Job) orchestratorResult {
 	voiceIP := payloadValue(job.Payload, "voice_ip")
 	queryIP := payloadValue(job.Payload, "query_ip")
 	fileIP := payloadValue(job.Payload, "filetransfer_ip")
-	licensePath := payloadValue(job.Payload, "licensepath", "license_path")
-	adminPassword := payloadValue(job.Payload, "admin_password", "serveradmin_password")
+	licensePath := payloadValue(job.Payload, "licensepath")
+	adminPassword := payloadValue(job.Payload, "admin_password")
 	adminPassword = ensureTs3AdminPassword(adminPassword)
 
 	if installDir == "" || serviceName == "" || downloadURL == "" {
@@ -677,7 +735,7 @@ func handleSinusbotInstall(job jobs.Job) orchestratorResult {
 	webBindIP := payloadValue(job.Payload, "web_bind_ip")
 	webPortBase := payloadValue(job.Payload, "web_port_base")
 	adminPassword := payloadValue(job.Payload, "admin_password")
-	ts3ClientInstall := parseBool(payloadValue(job.Payload, "ts3_client_install", "install_ts3_client"), true)
+	ts3ClientInstall := parseBool(payloadValue(job.Payload, "ts3_client_install"), true)
 	ts3ClientDownloadURL := payloadValue(job.Payload, "ts3_client_download_url")
 	if ts3ClientDownloadURL == "" {
 		ts3ClientDownloadURL = defaultSinusbotTs3ClientURL
@@ -1119,7 +1177,7 @@ func installSinusbotTs3Client(installDir, downloadURL, serviceUser string) (stri
 }
 
 func resolveSinusbotTs3ClientStatus(job jobs.Job) map[string]any {
-	installDir := payloadValue(job.Payload, "install_dir", "install_path")
+	installDir := payloadValue(job.Payload, "install_dir")
 	if installDir == "" {
 		return nil
 	}
@@ -1155,7 +1213,7 @@ func handleViewerSnapshot(job jobs.Job) orchestratorResult {
 	var channelLines []string
 	var clientLines []string
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		serverInfo, err := client.command("serverinfo")
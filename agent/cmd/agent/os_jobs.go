@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -242,7 +243,7 @@ func runCommandCapture(name string, args []string, output *strings.Builder) (str
 	if name == "apt-get" {
 		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
 	}
-	commandOutput, err := StreamCommand(cmd, "", nil)
+	commandOutput, err := StreamCommand(context.Background(), cmd, "", nil)
 	appendOutput(output, fmt.Sprintf("cmd=%s %s", name, strings.Join(args, " ")))
 	if len(commandOutput) > 0 {
 		appendOutput(output, commandOutput)
@@ -255,7 +256,7 @@ func runCommandCapture(name string, args []string, output *strings.Builder) (str
 
 func runRhelUpdateCheck(tool string, output *strings.Builder) (bool, int, error) {
 	cmd := exec.Command(tool, "check-update")
-	commandOutput, err := StreamCommand(cmd, "", nil)
+	commandOutput, err := StreamCommand(context.Background(), cmd, "", nil)
 	appendOutput(output, fmt.Sprintf("cmd=%s check-update", tool))
 	if len(commandOutput) > 0 {
 		appendOutput(output, commandOutput)
@@ -274,7 +275,7 @@ func runRhelUpdateCheck(tool string, output *strings.Builder) (bool, int, error)
 
 func runPacmanCheckUpdates(tool string, args []string, output *strings.Builder) (bool, int, error) {
 	cmd := exec.Command(tool, args...)
-	commandOutput, err := StreamCommand(cmd, "", nil)
+	commandOutput, err := StreamCommand(context.Background(), cmd, "", nil)
 	appendOutput(output, fmt.Sprintf("cmd=%s %s", tool, strings.Join(args, " ")))
 	if len(commandOutput) > 0 {
 		appendOutput(output, commandOutput)
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"easywi/agent/internal/api"
+)
+
+// panelReaction is how the heartbeat and poll loops respond to a failed panel request.
+type panelReaction int
+
+const (
+	// panelReactionRetry leaves the next tick to try again; used for network
+	// errors and server failures that the API client already retried.
+	panelReactionRetry panelReaction = iota
+	panelReactionRefreshCredentials
+	panelReactionBackoff
+	panelReactionStop
+	// panelReactionDelivered means the panel refused the request with 409
+	// because it already holds what the request carried, e.g. a job result
+	// an earlier attempt delivered before its reply was lost. Retrying can
+	// only conflict again, so the request counts as done.
+	panelReactionDelivered
+)
+
+const (
+	panelRateLimitBackoff = 30 * time.Second
+	panelForbiddenBackoff = 5 * time.Minute
+	panelMaxBackoff       = 15 * time.Minute
+)
+
+// agentDeregisteredExitCode tells the service manager not to restart an agent
+// the panel has removed (EX_CONFIG). The systemd unit lists it in
+// RestartPreventExitStatus.
+const agentDeregisteredExitCode = 78
+
+var errAgentDeregistered = errors.New("agent was deregistered by the panel")
+
+// classifyPanelError maps a panel error to the loop reaction and, for
+// back-offs, the delay the panel asked for (zero when it did not say).
+func classifyPanelError(err error) (panelReaction, time.Duration) {
+	switch {
+	case err == nil:
+		return panelReactionRetry, 0
+	case api.IsDeregistered(err):
+		return panelReactionStop, 0
+	case api.IsAuthFailure(err):
+		return panelReactionRefreshCredentials, 0
+	case api.IsForbidden(err):
+		return panelReactionBackoff, panelForbiddenBackoff
+	case api.IsConflict(err):
+		return panelReactionDelivered, 0
+	}
+	if retryAfter, ok := api.RetryAfter(err); ok {
+		return panelReactionBackoff, retryAfter
+	}
+	return panelReactionRetry, 0
+}
+
+// panelAlreadyAccepted reports whether a failed result submission was only
+// refused because the panel already accepted that result.
+func panelAlreadyAccepted(err error) bool {
+	reaction, _ := classifyPanelError(err)
+	return err != nil && reaction == panelReactionDelivered
+}
+
+// panelBackoff pauses heartbeats and polls while the panel asks the agent to
+// slow down. Without a Retry-After hint the pause doubles on every
+// consecutive back-off.
+type panelBackoff struct {
+	until       time.Time
+	consecutive int
+	now         func() time.Time
+}
+
+func newPanelBackoff() *panelBackoff {
+	return &panelBackoff{now: time.Now}
+}
+
+// Active reports whether requests to the panel should be skipped.
+func (b *panelBackoff) Active() bool {
+	return b.now().Before(b.until)
+}
+
+// Extend starts or lengthens the pause and returns its duration.
+func (b *panelBackoff) Extend(wait time.Duration) time.Duration {
+	b.consecutive++
+	if wait <= 0 {
+		wait = panelRateLimitBackoff
+		for idx := 1; idx < b.consecutive && wait < panelMaxBackoff; idx++ {
+			wait *= 2
+		}
+	}
+	if wait > panelMaxBackoff {
+		wait = panelMaxBackoff
+	}
+	if until := b.now().Add(wait); until.After(b.until) {
+		b.until = until
+	}
+	return wait
+}
+
+// Reset clears the pause after the panel accepted a request again.
+func (b *panelBackoff) Reset() {
+	b.consecutive = 0
+	b.until = time.Time{}
+}
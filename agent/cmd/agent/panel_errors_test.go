@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/api"
+)
+
+func TestClassifyPanelError(t *testing.T) {
+	cases := []struct {
+		err      error
+		reaction panelReaction
+		wait     time.Duration
+	}{
+		{&api.Error{StatusCode: http.StatusUnauthorized}, panelReactionRefreshCredentials, 0},
+		{&api.Error{StatusCode: http.StatusForbidden}, panelReactionBackoff, panelForbiddenBackoff},
+		{&api.Error{StatusCode: http.StatusGone}, panelReactionStop, 0},
+		{&api.Error{StatusCode: http.StatusTooManyRequests, RetryAfter: 90 * time.Second}, panelReactionBackoff, 90 * time.Second},
+		{&api.Error{StatusCode: http.StatusConflict}, panelReactionDelivered, 0},
+		{errors.New("dial tcp: connection refused"), panelReactionRetry, 0},
+	}
+	for _, tc := range cases {
+		reaction, wait := classifyPanelError(tc.err)
+		if reaction != tc.reaction || wait != tc.wait {
+			t.Fatalf("classifyPanelError(%v) = %v, %s; want %v, %s", tc.err, reaction, wait, tc.reaction, tc.wait)
+		}
+	}
+}
+
+func TestPanelBackoffDoublesWithoutRetryAfter(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	backoff := newPanelBackoff()
+	backoff.now = func() time.Time { return now }
+
+	if got := backoff.Extend(0); got != panelRateLimitBackoff {
+		t.Fatalf("first back-off = %s, want %s", got, panelRateLimitBackoff)
+	}
+	if got := backoff.Extend(0); got != 2*panelRateLimitBackoff {
+		t.Fatalf("second back-off = %s, want %s", got, 2*panelRateLimitBackoff)
+	}
+	if got := backoff.Extend(time.Hour); got != panelMaxBackoff {
+		t.Fatalf("expected back-off to be capped, got %s", got)
+	}
+	if !backoff.Active() {
+		t.Fatal("expected back-off to be active")
+	}
+	now = now.Add(panelMaxBackoff)
+	if backoff.Active() {
+		t.Fatal("expected back-off to expire")
+	}
+	backoff.Reset()
+	if got := backoff.Extend(0); got != panelRateLimitBackoff {
+		t.Fatalf("expected reset to restart doubling, got %s", got)
+	}
+}
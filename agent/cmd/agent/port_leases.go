@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/gamesvcembed"
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/portalloc"
+)
+
+const (
+	portLeasesListJobType    = "ports.leases.list"
+	defaultPortPoolStart     = 30000
+	defaultPortPoolSize      = 10000
+	defaultInstancePortCount = 5
+)
+
+var portLeasePath = "/etc/easywi/agent_port_leases.json"
+
+// legacyInstanceUnitDir holds the gs-<instance_id>.service units that
+// seedLegacyInstancePortLeases scans.
+var legacyInstanceUnitDir = "/etc/systemd/system"
+
+var (
+	portAllocatorMu     sync.Mutex
+	globalPortAllocator *portalloc.Allocator
+)
+
+// portAllocator opens the lease table on first use. Host checks go through
+// the same probe as the /ports/check-free endpoint.
+func portAllocator() (*portalloc.Allocator, error) {
+	portAllocatorMu.Lock()
+	defer portAllocatorMu.Unlock()
+	if globalPortAllocator == nil {
+		allocator, err := portalloc.Open(portLeasePath, gamesvcembed.CheckPortFree)
+		if err != nil {
+			return nil, err
+		}
+		globalPortAllocator = allocator
+	}
+	return globalPortAllocator, nil
+}
+
+// portPoolRanges returns the ranges new ports are picked from: the payload's
+// port_ranges, then EASYWI_PORT_POOL_RANGES, then EASYWI_PORT_POOL_START and
+// EASYWI_PORT_POOL_END around the 30000-39999 default.
+func portPoolRanges(payload map[string]any) ([]portalloc.Range, error) {
+	if raw := firstNonEmpty(schemaPayloadValue(payload, instancePortsPayload{}, "port_ranges"), os.Getenv("EASYWI_PORT_POOL_RANGES")); raw != "" {
+		return portalloc.ParseRanges(raw)
+	}
+	start := defaultPortPoolStart
+	if raw := strings.TrimSpace(os.Getenv("EASYWI_PORT_POOL_START")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EASYWI_PORT_POOL_START: %s", raw)
+		}
+		start = parsed
+	}
+	end := min(start+defaultPortPoolSize-1, portalloc.MaxPort)
+	if raw := strings.TrimSpace(os.Getenv("EASYWI_PORT_POOL_END")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EASYWI_PORT_POOL_END: %s", raw)
+		}
+		end = parsed
+	}
+	return portalloc.ParseRanges(fmt.Sprintf("%d-%d", start, end))
+}
+
+// instancePortRequests turns required_ports ("game/udp,query/udp,rcon/tcp")
+// into one request per label. pinned ports from the payload are assigned to
+// the labels in order. Without labels the instance gets port_count ports.
+func instancePortRequests(payload map[string]any, requiredPortsRaw string, pinned []int) ([]portalloc.PortRequest, error) {
+	requests := []portalloc.PortRequest{}
+	for _, field := range strings.FieldsFunc(requiredPortsRaw, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\t'
+	}) {
+		label, proto, _ := strings.Cut(strings.TrimSpace(field), "/")
+		if strings.TrimSpace(label) == "" {
+			continue
+		}
+		requests = append(requests, portalloc.PortRequest{Label: strings.TrimSpace(label), Protos: []string{proto}})
+	}
+	if len(requests) == 0 {
+		count := defaultInstancePortCount
+		if len(pinned) > 0 {
+			count = len(pinned)
+		}
+		if raw := payloadValue(payload, "port_count"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("invalid port_count: %s", raw)
+			}
+			count = max(parsed, len(pinned))
+		}
+		for idx := 0; idx < count; idx++ {
+			requests = append(requests, portalloc.PortRequest{Label: "port" + strconv.Itoa(idx+1)})
+		}
+	}
+	for idx, port := range pinned {
+		if idx >= len(requests) {
+			requests = append(requests, portalloc.PortRequest{Label: "port" + strconv.Itoa(idx+1)})
+		}
+		requests[idx].Port = port
+	}
+	return requests, nil
+}
+
+// allocateInstancePorts leases the instance's ports, keeping the ones it
+// already holds. Ports from the payload are reserved as given and fail on a
+// conflict instead of being moved.
+func allocateInstancePorts(payload map[string]any, instanceID, customerID, requiredPortsRaw string, pinned []int, skipHostCheck bool) ([]int, error) {
+	requests, err := instancePortRequests(payload, requiredPortsRaw, pinned)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := portPoolRanges(payload)
+	if err != nil {
+		return nil, err
+	}
+	allocator, err := portAllocator()
+	if err != nil {
+		return nil, err
+	}
+	return allocator.Allocate(portalloc.Request{
+		InstanceID:    instanceID,
+		CustomerID:    customerID,
+		Ports:         requests,
+		Ranges:        ranges,
+		SkipHostCheck: skipHostCheck,
+	})
+}
+
+// reservedInstancePorts returns the ports of an instance created before the
+// lease table, as the panel sends them in port_reservations. With
+// required_ports they follow the label order and stop at the first label
+// without a reservation, so no port lands on the wrong label.
+func reservedInstancePorts(payload map[string]any, requiredPortsRaw string) []int {
+	entries, _ := payload["port_reservations"].([]any)
+	byRole := map[string]int{}
+	ordered := []int{}
+	for _, entry := range entries {
+		typed, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		role := strings.ToLower(strings.TrimSpace(firstNonEmpty(payloadString(typed["role"]), payloadString(typed["name"]))))
+		port, err := strconv.Atoi(strings.TrimSpace(payloadString(typed["port"])))
+		if role == "" || err != nil || port <= 0 || port > portalloc.MaxPort {
+			continue
+		}
+		byRole[role] = port
+		ordered = append(ordered, port)
+	}
+	labels := parsePortLabels(requiredPortsRaw)
+	if len(labels) == 0 {
+		return ordered
+	}
+	ports := []int{}
+	for _, label := range labels {
+		port, ok := byRole[strings.ToLower(label)]
+		if !ok {
+			break
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// legacyInstancePorts returns the five ports instances got before the lease
+// table: a block per customer starting at EASYWI_PORT_POOL_START.
+func legacyInstancePorts(customerID string) ([]int, error) {
+	basePort := defaultPortPoolStart
+	if override := os.Getenv("EASYWI_PORT_POOL_START"); override != "" {
+		if parsed, err := strconv.Atoi(override); err == nil {
+			basePort = parsed
+		}
+	}
+	id, err := strconv.Atoi(customerID)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("invalid customer_id %s", customerID)
+	}
+	start := basePort + (id-1)*defaultInstancePortCount
+	if start+defaultInstancePortCount-1 > portalloc.MaxPort {
+		return nil, fmt.Errorf("legacy port block of customer %s is out of range", customerID)
+	}
+	ports := make([]int, 0, defaultInstancePortCount)
+	for idx := 0; idx < defaultInstancePortCount; idx++ {
+		ports = append(ports, start+idx)
+	}
+	return ports, nil
+}
+
+// seedLegacyInstancePortLeases leases the legacy port block of every instance
+// unit on the host that holds no leases yet, so new instances are never handed
+// the ports of a stopped one. The customer comes from the unit's User=, which
+// buildInstanceUsername derived from it. It returns the seeded instances and
+// the ones skipped with the reason; units with custom service names are not
+// recognised and stay unleased until the panel reinstalls them.
+func seedLegacyInstancePortLeases() ([]string, map[string]error, error) {
+	entries, err := os.ReadDir(legacyInstanceUnitDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	allocator, err := portAllocator()
+	if err != nil {
+		return nil, nil, err
+	}
+	seeded := []string{}
+	skipped := map[string]error{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "gs-") || !strings.HasSuffix(name, ".service") {
+			continue
+		}
+		instanceID := strings.TrimSuffix(strings.TrimPrefix(name, "gs-"), ".service")
+		if instanceID == "" || len(allocator.Leases(instanceID)) > 0 {
+			continue
+		}
+		customerID, err := legacyUnitCustomerID(filepath.Join(legacyInstanceUnitDir, name), instanceID)
+		if err != nil {
+			skipped[instanceID] = err
+			continue
+		}
+		ports, err := legacyInstancePorts(customerID)
+		if err != nil {
+			skipped[instanceID] = err
+			continue
+		}
+		requests := make([]portalloc.PortRequest, 0, len(ports))
+		for idx, port := range ports {
+			requests = append(requests, portalloc.PortRequest{Label: "port" + strconv.Itoa(idx+1), Port: port})
+		}
+		// The instance's own server may be bound to its ports right now.
+		if _, err := allocator.Allocate(portalloc.Request{InstanceID: instanceID, CustomerID: customerID, Ports: requests, SkipHostCheck: true}); err != nil {
+			skipped[instanceID] = err
+			continue
+		}
+		seeded = append(seeded, instanceID)
+	}
+	return seeded, skipped, nil
+}
+
+// legacyUnitCustomerID reads the customer ID back out of the unit's
+// gs<customer_id><instance> user.
+func legacyUnitCustomerID(unitPath, instanceID string) (string, error) {
+	content, err := os.ReadFile(unitPath)
+	if err != nil {
+		return "", err
+	}
+	suffix := buildInstanceUsername("", instanceID)[len("gs"):]
+	for _, line := range strings.Split(string(content), "\n") {
+		user, ok := strings.CutPrefix(strings.TrimSpace(line), "User=")
+		if !ok {
+			continue
+		}
+		customerID := strings.TrimSuffix(strings.TrimPrefix(user, "gs"), suffix)
+		if !strings.HasPrefix(user, "gs") || !strings.HasSuffix(user, suffix) || customerID == "" {
+			return "", fmt.Errorf("unit user %s does not name a customer", user)
+		}
+		return customerID, nil
+	}
+	return "", fmt.Errorf("unit has no User=")
+}
+
+func instanceHasPortLeases(instanceID string) bool {
+	allocator, err := portAllocator()
+	return err == nil && len(allocator.Leases(instanceID)) > 0
+}
+
+// releaseInstancePorts returns the instance's ports to the pool.
+func releaseInstancePorts(instanceID string) ([]int, error) {
+	allocator, err := portAllocator()
+	if err != nil {
+		return nil, err
+	}
+	released, err := allocator.Release(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	ports := []int{}
+	for _, lease := range released {
+		if len(ports) == 0 || ports[len(ports)-1] != lease.Port {
+			ports = append(ports, lease.Port)
+		}
+	}
+	return ports, nil
+}
+
+func handlePortLeasesList(job jobs.Job) (jobs.Result, func() error) {
+	allocator, err := portAllocator()
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	ranges, err := portPoolRanges(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	leases := allocator.Leases(payloadValue(job.Payload, "instance_id"))
+	encoded, err := json.Marshal(leases)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	rangeNames := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		rangeNames = append(rangeNames, r.String())
+	}
+	return jobs.Result{
+		JobID:  job.ID,
+		Status: "success",
+		Output: map[string]string{
+			"leases":      string(encoded),
+			"lease_count": strconv.Itoa(len(leases)),
+			"port_ranges": strings.Join(rangeNames, ","),
+		},
+		Completed: time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+	"easywi/agent/internal/portalloc"
+)
+
+func useTestPortAllocator(t *testing.T) {
+	t.Helper()
+	allocator, err := portalloc.Open(filepath.Join(t.TempDir(), "port_leases.json"), nil)
+	if err != nil {
+		t.Fatalf("open allocator: %v", err)
+	}
+	portAllocatorMu.Lock()
+	previous := globalPortAllocator
+	globalPortAllocator = allocator
+	portAllocatorMu.Unlock()
+	t.Cleanup(func() {
+		portAllocatorMu.Lock()
+		globalPortAllocator = previous
+		portAllocatorMu.Unlock()
+	})
+}
+
+func TestInstancePortRequestsMapsLabelsProtocolsAndPinnedPorts(t *testing.T) {
+	requests, err := instancePortRequests(map[string]any{}, "game/udp, query/udp,rcon", []int{27015})
+	if err != nil {
+		t.Fatalf("requests: %v", err)
+	}
+	want := []portalloc.PortRequest{
+		{Label: "game", Protos: []string{"udp"}, Port: 27015},
+		{Label: "query", Protos: []string{"udp"}},
+		{Label: "rcon", Protos: []string{""}},
+	}
+	if !reflect.DeepEqual(requests, want) {
+		t.Fatalf("requests = %+v, want %+v", requests, want)
+	}
+	if requests, _ := instancePortRequests(map[string]any{}, "", nil); len(requests) != defaultInstancePortCount {
+		t.Fatalf("unlabelled default = %d ports", len(requests))
+	}
+	if requests, _ := instancePortRequests(map[string]any{"port_count": "8"}, "", []int{31000, 31001}); len(requests) != 8 || requests[1].Port != 31001 {
+		t.Fatalf("port_count requests = %+v", requests)
+	}
+}
+
+func TestReservedInstancePortsFollowLabelOrder(t *testing.T) {
+	payload := map[string]any{"port_reservations": []any{
+		map[string]any{"role": "query", "port": float64(27016)},
+		map[string]any{"role": "game", "port": "27015"},
+	}}
+	if got := reservedInstancePorts(payload, "game/udp,query/udp"); !reflect.DeepEqual(got, []int{27015, 27016}) {
+		t.Fatalf("labelled ports = %v", got)
+	}
+	if got := reservedInstancePorts(payload, "game/udp,rcon/tcp,query/udp"); !reflect.DeepEqual(got, []int{27015}) {
+		t.Fatalf("expected ports to stop at the first label without a reservation, got %v", got)
+	}
+	if got := reservedInstancePorts(payload, ""); !reflect.DeepEqual(got, []int{27016, 27015}) {
+		t.Fatalf("unlabelled ports = %v", got)
+	}
+}
+
+func TestPortLeasesAreAllocatedListedAndReleased(t *testing.T) {
+	useTestPortAllocator(t)
+	payload := map[string]any{"port_ranges": "40000-40009"}
+
+	first, err := allocateInstancePorts(payload, "7", "3", "game/udp,rcon/tcp", nil, false)
+	if err != nil || !reflect.DeepEqual(first, []int{40000, 40001}) {
+		t.Fatalf("instance 7 ports = %v, %v", first, err)
+	}
+	if _, err := allocateInstancePorts(payload, "8", "3", "game/udp", []int{40000}, false); err == nil {
+		t.Fatal("expected a pinned port leased by another instance to conflict")
+	}
+	// 40001 is only leased for tcp, so a udp-only port may share it.
+	second, err := allocateInstancePorts(payload, "8", "4", "game/udp", nil, false)
+	if err != nil || !reflect.DeepEqual(second, []int{40001}) {
+		t.Fatalf("instance 8 ports = %v, %v", second, err)
+	}
+
+	result, _ := handlePortLeasesList(jobs.Job{ID: "job-1", Payload: map[string]any{"instance_id": "7", "port_ranges": "40000-40009"}})
+	var leases []portalloc.Lease
+	if err := json.Unmarshal([]byte(result.Output["leases"]), &leases); err != nil {
+		t.Fatalf("decode leases: %v", err)
+	}
+	if result.Status != "success" || result.Output["lease_count"] != "2" || result.Output["port_ranges"] != "40000-40009" || leases[0].Label != "game" {
+		t.Fatalf("status=%s output=%v", result.Status, result.Output)
+	}
+
+	released, err := releaseInstancePorts("7")
+	if err != nil || !reflect.DeepEqual(released, []int{40000, 40001}) {
+		t.Fatalf("released = %v, %v", released, err)
+	}
+	if instanceHasPortLeases("7") || !instanceHasPortLeases("8") {
+		t.Fatal("release must only drop the deleted instance's leases")
+	}
+}
+
+func TestInstanceDeleteWindowsReleasesPortLeases(t *testing.T) {
+	useTestPortAllocator(t)
+	if _, err := allocateInstancePorts(map[string]any{"port_ranges": "40000-40009"}, "7", "3", "game/udp,query/udp", nil, false); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+
+	result, _ := handleInstanceDeleteWindows(jobs.Job{ID: "job-1", Payload: map[string]any{"instance_id": "7", "service_name": "easywi-test-missing"}})
+	if result.Status != "success" || result.Output["released_ports"] != "40000,40001" {
+		t.Fatalf("status=%s output=%v", result.Status, result.Output)
+	}
+	if instanceHasPortLeases("7") {
+		t.Fatal("expected the deleted instance's leases to be released")
+	}
+}
+
+func TestSeedLegacyInstancePortLeasesLeasesExistingUnits(t *testing.T) {
+	useTestPortAllocator(t)
+	t.Setenv("EASYWI_PORT_POOL_START", "")
+	unitDir := t.TempDir()
+	previous := legacyInstanceUnitDir
+	legacyInstanceUnitDir = unitDir
+	t.Cleanup(func() { legacyInstanceUnitDir = previous })
+
+	units := map[string]string{
+		"gs-42.service":     "[Service]\nUser=gs342\n",
+		"gs-43.service":     "[Service]\nUser=gs343\n",
+		"gs-leased.service": "[Service]\nUser=gs7leased\n",
+		"gs-odd.service":    "[Service]\nUser=root\n",
+		"nginx.service":     "[Service]\nUser=www-data\n",
+	}
+	for name, content := range units {
+		if err := os.WriteFile(filepath.Join(unitDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write unit: %v", err)
+		}
+	}
+	allocator, _ := portAllocator()
+	if _, err := allocator.Allocate(portalloc.Request{InstanceID: "leased", Ports: []portalloc.PortRequest{{Label: "game", Port: 31000}}}); err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+
+	seeded, skipped, err := seedLegacyInstancePortLeases()
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	// Customer 3's two instances shared one legacy block; only one can keep it.
+	if len(seeded) != 1 || (seeded[0] != "42" && seeded[0] != "43") {
+		t.Fatalf("seeded = %v", seeded)
+	}
+	if _, ok := skipped["odd"]; !ok || len(skipped) != 2 {
+		t.Fatalf("skipped = %v", skipped)
+	}
+	var ports []int
+	for _, lease := range allocator.Leases(seeded[0]) {
+		ports = append(ports, lease.Port)
+	}
+	if len(ports) == 0 || ports[0] != 30010 || ports[len(ports)-1] != 30014 {
+		t.Fatalf("leased ports = %v, want the 30010-30014 block", ports)
+	}
+	if leases := allocator.Leases("leased"); len(leases) == 0 || leases[0].Port != 31000 {
+		t.Fatalf("expected existing leases untouched, got %+v", leases)
+	}
+}
@@ -38,11 +38,11 @@ const (
 )
 
 func handlePostgresDatabaseCreate(job jobs.Job) (jobs.Result, func() error) {
-	database := payloadValue(job.Payload, "database", "name")
-	owner := payloadValue(job.Payload, "owner", "role")
+	database := payloadValue(job.Payload, "database")
+	owner := payloadValue(job.Payload, "owner")
 	encoding := payloadValue(job.Payload, "encoding")
-	collation := payloadValue(job.Payload, "collation", "lc_collate")
-	ctype := payloadValue(job.Payload, "ctype", "lc_ctype")
+	collation := payloadValue(job.Payload, "collation")
+	ctype := payloadValue(job.Payload, "ctype")
 
 	missing := missingValues([]requiredValue{{key: "database", value: database}})
 	if len(missing) > 0 {
@@ -77,8 +77,8 @@ func handlePostgresDatabaseCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handlePostgresRoleCreate(job jobs.Job) (jobs.Result, func() error) {
-	username := payloadValue(job.Payload, "username", "role", "user")
-	encryptedPassword := payloadValue(job.Payload, "encrypted_password", "password_encrypted")
+	username := payloadValue(job.Payload, "username")
+	encryptedPassword := payloadValue(job.Payload, "encrypted_password")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
@@ -107,11 +107,11 @@ func handlePostgresRoleCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handlePostgresGrantApply(job jobs.Job) (jobs.Result, func() error) {
-	database := payloadValue(job.Payload, "database", "name")
-	username := payloadValue(job.Payload, "username", "role", "user")
-	subnet := payloadValue(job.Payload, "allowed_subnet", "subnet", "web_subnet")
-	authMethod := payloadValue(job.Payload, "auth_method", "pg_hba_auth_method")
-	pgHBAPath := payloadValue(job.Payload, "pg_hba_path", "pg_hba_file")
+	database := payloadValue(job.Payload, "database")
+	username := payloadValue(job.Payload, "username")
+	subnet := payloadValue(job.Payload, "allowed_subnet")
+	authMethod := payloadValue(job.Payload, "auth_method")
+	pgHBAPath := payloadValue(job.Payload, "pg_hba_path")
 
 	missing := missingValues([]requiredValue{
 		{key: "database", value: database},
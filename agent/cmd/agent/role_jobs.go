@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -1198,7 +1199,7 @@ func runCommandWithOutput(name string, args []string, output *strings.Builder) e
 	if name == "apt-get" {
 		cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
 	}
-	commandOutput, err := StreamCommand(cmd, "", nil)
+	commandOutput, err := StreamCommand(context.Background(), cmd, "", nil)
 	appendOutput(output, fmt.Sprintf("cmd=%s %s", name, strings.Join(args, " ")))
 	if len(commandOutput) > 0 {
 		appendOutput(output, commandOutput)
@@ -136,8 +136,9 @@ func canonicalRoleName(role string) string {
 }
 
 func collectMetadata(cfg config.Config) map[string]any {
+	ts6Supported := detectTS6Support()
 	metadata := map[string]any{
-		"ts6_supported":   detectTS6Support(),
+		"ts6_supported":   ts6Supported,
 		"platform_family": runtime.GOOS,
 		"os":              runtime.GOOS,
 	}
@@ -165,6 +166,10 @@ func collectMetadata(cfg config.Config) map[string]any {
 	if runtime.GOOS == "windows" {
 		metadata["capabilities"] = windowsCapabilities()
 	}
+	metadata["job_schema_version"] = globalJobSchemas.Version()
+	metadata["job_schemas"] = globalJobSchemas.Capabilities()
+	metadata["job_types"] = collectJobCapabilities()
+	metadata["features"] = collectAgentFeatures(ts6Supported)
 	if ipv6Addrs := getPublicIPv6Addresses(); len(ipv6Addrs) > 0 {
 		metadata["ipv6_address"] = ipv6Addrs[0]
 		if len(ipv6Addrs) > 1 {
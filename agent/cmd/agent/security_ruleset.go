@@ -70,7 +70,7 @@ func handleSecurityRuleSetApply(job jobs.Job) (jobs.Result, func() error) {
 	ruleset = normalizeSecurityRuleSet(ruleset)
 	targetKey := resolveRuleSetTarget(job.Payload)
 	backend := resolveFirewallBackend()
-	nodeID := strings.TrimSpace(payloadValue(job.Payload, "agent_id", "node_id"))
+	nodeID := strings.TrimSpace(payloadValue(job.Payload, "agent_id"))
 	hash := hashSecurityRuleSet(ruleset)
 	cleanupLegacySecurityStatePaths()
 	statePath := activeRuleSetStatePath(nodeID, targetKey, backend)
@@ -105,7 +105,7 @@ func handleSecurityRuleSetApply(job jobs.Job) (jobs.Result, func() error) {
 func handleSecurityRuleSetRollback(job jobs.Job) (jobs.Result, func() error) {
 	targetKey := resolveRuleSetTarget(job.Payload)
 	backend := resolveFirewallBackend()
-	nodeID := strings.TrimSpace(payloadValue(job.Payload, "agent_id", "node_id"))
+	nodeID := strings.TrimSpace(payloadValue(job.Payload, "agent_id"))
 	lastGood, ok := readSecurityRuleSetState(lastKnownGoodRuleSetStatePath(nodeID, targetKey, backend))
 	if !ok {
 		return failureResult(job.ID, fmt.Errorf("no last known good ruleset found"))
@@ -393,7 +393,7 @@ func ensureNoSelfLockout(ruleset securityRuleSet) error {
 }
 
 func resolveRuleSetTarget(payload map[string]any) string {
-	target := strings.TrimSpace(payloadValue(payload, "target", "target_scope"))
+	target := strings.TrimSpace(payloadValue(payload, "target"))
 	if target == "" {
 		return "global"
 	}
@@ -24,10 +24,10 @@ func handleInstanceSftpAccessEnable(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	password := payloadValue(job.Payload, "password", "sftp_password")
-	instanceRoot := payloadValue(job.Payload, "instance_root", "instance_dir", "chroot_dir")
-	authorizedKeys := payloadValue(job.Payload, "authorized_keys", "keys", "sftp_keys")
+	username := payloadValue(job.Payload, "username")
+	password := payloadValue(job.Payload, "password")
+	instanceRoot := payloadValue(job.Payload, "instance_root")
+	authorizedKeys := payloadValue(job.Payload, "authorized_keys")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
@@ -106,9 +106,9 @@ func handleInstanceSftpAccessResetPassword(job jobs.Job) (jobs.Result, func() er
 		}, nil
 	}
 
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	password := payloadValue(job.Payload, "password", "sftp_password")
-	instanceRoot := payloadValue(job.Payload, "instance_root", "instance_dir", "chroot_dir")
+	username := payloadValue(job.Payload, "username")
+	password := payloadValue(job.Payload, "password")
+	instanceRoot := payloadValue(job.Payload, "instance_root")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
@@ -173,9 +173,9 @@ func handleInstanceSftpAccessKeys(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	instanceRoot := payloadValue(job.Payload, "instance_root", "instance_dir", "chroot_dir")
-	authorizedKeys := payloadValue(job.Payload, "authorized_keys", "keys", "sftp_keys")
+	username := payloadValue(job.Payload, "username")
+	instanceRoot := payloadValue(job.Payload, "instance_root")
+	authorizedKeys := payloadValue(job.Payload, "authorized_keys")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
@@ -249,8 +249,8 @@ func handleInstanceSftpAccessDisable(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
-	username := payloadValue(job.Payload, "username", "sftp_username", "user")
-	instanceRoot := payloadValue(job.Payload, "instance_root", "instance_dir", "chroot_dir")
+	username := payloadValue(job.Payload, "username")
+	instanceRoot := payloadValue(job.Payload, "instance_root")
 
 	missing := missingValues([]requiredValue{
 		{key: "username", value: username},
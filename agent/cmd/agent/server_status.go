@@ -1,19 +1,21 @@
 package main
 
 import (
+	"context"
 	"net"
 	"strings"
 	"time"
 
+	"easywi/agent/internal/gamequery"
 	"easywi/agent/internal/jobs"
 )
 
 const serverStatusTimeout = 3 * time.Second
 
 func handleServerStatusCheck(job jobs.Job) (jobs.Result, func() error) {
-	ip := payloadValue(job.Payload, "ip", "host")
+	ip := payloadValue(job.Payload, "ip")
 	port := payloadValue(job.Payload, "query_port", "port")
-	queryType := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "query_type", "protocol")))
+	queryType := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "query_type")))
 
 	missing := missingValues([]requiredValue{{key: "ip", value: ip}, {key: "port", value: port}})
 	if len(missing) > 0 {
@@ -40,9 +42,18 @@ func handleServerStatusCheck(job jobs.Job) (jobs.Result, func() error) {
 	case "minecraft_bedrock", "bedrock", "mcpe":
 		data, err := queryMinecraftBedrock(ip, port)
 		return serverStatusQueryResult(job.ID, "minecraft_bedrock", startedAt, data, err), nil
+	case "valheim":
+		data, err := queryA2S(ip, valheimQueryPort(payloadValue(job.Payload, "port"), payloadValue(job.Payload, "query_port")))
+		return serverStatusQueryResult(job.ID, "valheim", startedAt, data, err), nil
 	case "tcp", "tcp_connect", "connect", "generic":
 		return serverStatusTCPResult(job.ID, ip, port, startedAt), nil
 	default:
+		if engine, ok := gamequery.Lookup(queryType); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), gameQueryTimeout)
+			defer cancel()
+			data, err := queryGameEngine(ctx, engine, ip, port, queryPassword(job.Payload), payloadValue(job.Payload, "rcon_command"))
+			return serverStatusQueryResult(job.ID, engine.Name, startedAt, data, err), nil
+		}
 		return jobs.Result{
 			JobID:     job.ID,
 			Status:    "failed",
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"os"
@@ -387,7 +388,7 @@ func TestSniperSharedUpdateFailsWhenManifestMissing(t *testing.T) {
 		"shared_key":     "minecraft",
 		"update_command": "echo ok",
 	}}
-	res, _ := handleSniperSharedUpdate(job, nil)
+	res, _ := handleSniperSharedUpdate(context.Background(), job, nil)
 	if res.Status != "failed" || !strings.Contains(res.Output["message"], "SHARED_MANIFEST_INVALID") {
 		t.Fatalf("expected SHARED_MANIFEST_INVALID, got %#v", res.Output)
 	}
@@ -404,7 +405,7 @@ func TestSniperSharedUpdateFailsWhenServerMissing(t *testing.T) {
 		t.Fatal(err)
 	}
 	job := jobs.Job{ID: "2", Payload: map[string]any{"base_dir": base, "shared_key": key, "update_command": "echo ok"}}
-	res, _ := handleSniperSharedUpdate(job, nil)
+	res, _ := handleSniperSharedUpdate(context.Background(), job, nil)
 	if res.Status != "failed" || !strings.Contains(res.Output["message"], "SHARED_SERVER_MISSING") {
 		t.Fatalf("expected SHARED_SERVER_MISSING, got %#v", res.Output)
 	}
@@ -421,7 +422,7 @@ func TestSniperSharedUpdateMissingCommandSetsManifestFailed(t *testing.T) {
 		t.Fatal(err)
 	}
 	job := jobs.Job{ID: "3", Payload: map[string]any{"base_dir": base, "shared_key": key}}
-	res, _ := handleSniperSharedUpdate(job, nil)
+	res, _ := handleSniperSharedUpdate(context.Background(), job, nil)
 	if res.Status != "failed" {
 		t.Fatalf("expected failed")
 	}
@@ -451,7 +452,7 @@ func TestSniperSharedUpdateCommandFailureMarksJobFailed(t *testing.T) {
 		"shared_key":     key,
 		"update_command": "exit 7",
 	}}
-	res, _ := handleSniperSharedUpdate(job, nil)
+	res, _ := handleSniperSharedUpdate(context.Background(), job, nil)
 	if res.Status != "failed" {
 		t.Fatalf("expected failed, got %s", res.Status)
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
@@ -81,15 +82,15 @@ func (permissionCommandOps) SetfaclDefaultRecursive(path, aclSpec string) error
 	return runCommand("setfacl", "-R", "-d", "-m", aclSpec, path)
 }
 
-func handleSniperInstall(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
-	return handleSniperAction(job, "install", logSender)
+func handleSniperInstall(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+	return handleSniperAction(ctx, job, "install", logSender)
 }
 
-func handleSniperUpdate(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
-	return handleSniperAction(job, "update", logSender)
+func handleSniperUpdate(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+	return handleSniperAction(ctx, job, "update", logSender)
 }
 
-func handleSniperSharedUpdate(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+func handleSniperSharedUpdate(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
 	baseDir := payloadValue(job.Payload, "base_dir")
 	if baseDir == "" {
 		baseDir = defaultInstanceBaseDir()
@@ -293,7 +294,7 @@ func handleSniperSharedUpdate(job jobs.Job, logSender JobLogSender) (jobs.Result
 		_ = markSharedManifestFailed(manifestPath, err)
 		return failureResult(job.ID, err)
 	}
-	output, err := runCommandOutputAsUserWithLogs(osUsername, shellCmd, job.ID, logSender)
+	output, err := runCommandOutputAsUserWithLogs(ctx, osUsername, shellCmd, job.ID, logSender)
 	if err != nil {
 		reason := "steamcmd_command_failed"
 		exitCode := "unknown"
@@ -521,7 +522,7 @@ func evaluateSharedInstallReuse(manifestPath, installTargetDir, action, command
 	return mf, false, nil
 }
 
-func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jobs.Result, func() error) {
+func handleSniperAction(ctx context.Context, job jobs.Job, action string, logSender JobLogSender) (jobs.Result, func() error) {
 	instanceID := payloadValue(job.Payload, "instance_id")
 	customerID := payloadValue(job.Payload, "customer_id")
 	steamAppID := strings.TrimSpace(payloadValue(job.Payload, "steam_app_id"))
@@ -534,7 +535,7 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 	templateKey := payloadValue(job.Payload, "template_key", "game_key")
 	cpuLimitValue := payloadValue(job.Payload, "cpu_limit")
 	ramLimitValue := payloadValue(job.Payload, "ram_limit")
-	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart", "auto_start"), true)
+	autostart := parsePayloadBool(payloadValue(job.Payload, "autostart"), true)
 
 	missing := missingValues([]requiredValue{
 		{key: "instance_id", value: instanceID},
@@ -567,11 +568,7 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 
 	osUsername := buildInstanceUsername(customerID, instanceID)
 	defaultHomeDir := fmt.Sprintf("%s/%s", strings.TrimRight(baseDir, "/"), osUsername)
-	payloadInstallPath := strings.TrimSpace(payloadValue(job.Payload, "install_path", "instance_dir"))
-	if payloadInstallPath == "" {
-		payloadInstallPath = defaultHomeDir
-	}
-	userHomeDir, instanceDir, err := resolveSniperUserHomeAndGameDir(payloadInstallPath, osUsername)
+	userHomeDir, instanceDir, err := resolveSniperUserHomeAndGameDir(sniperInstallPath(job.Payload, defaultHomeDir), osUsername)
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
@@ -618,6 +615,10 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 	if err != nil {
 		return failureResult(job.ID, err)
 	}
+	resources, err := parseInstanceResourceProfile(job.Payload, cpuLimit, ramLimit)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
 
 	var command string
 	var steamCmdExecPath string
@@ -865,7 +866,7 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 		if logSender != nil && job.ID != "" {
 			logSender.Send(job.ID, []string{"command started: runuser", "command_started=true"}, nil)
 		}
-		output, err = runCommandOutputAsUserWithLogs(osUsername, shellCmd, job.ID, logSender)
+		output, err = runCommandOutputAsUserWithLogs(ctx, osUsername, shellCmd, job.ID, logSender)
 		if err != nil {
 			markSharedFailure(err)
 			return failureResult(job.ID, err)
@@ -873,7 +874,7 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 	}
 	if usesSteamCmd {
 		for attempts := 0; attempts < steamCmdRetryLimit && shouldRetrySteamCmd(output, steamAppID); attempts++ {
-			retryOutput, retryErr := runCommandOutputAsUserWithLogs(osUsername, shellCmd, job.ID, logSender)
+			retryOutput, retryErr := runCommandOutputAsUserWithLogs(ctx, osUsername, shellCmd, job.ID, logSender)
 			if retryErr != nil {
 				markSharedFailure(retryErr)
 				return failureResult(job.ID, retryErr)
@@ -1054,13 +1055,8 @@ func handleSniperAction(job jobs.Job, action string, logSender JobLogSender) (jo
 		return failureResult(job.ID, err)
 	}
 
-	unitPath := filepath.Join("/etc/systemd/system", fmt.Sprintf("%s.service", serviceName))
-	unitContent := systemdUnitTemplate(serviceName, osUsername, instanceDir, instanceDir, startScriptPath, "", cpuLimit, ramLimit)
-	if err := os.WriteFile(unitPath, []byte(unitContent), instanceFileMode); err != nil {
-		markSharedFailure(err)
-		return failureResult(job.ID, fmt.Errorf("write systemd unit: %w", err))
-	}
-	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+	unitContent := systemdUnitTemplateWithResources(serviceName, osUsername, instanceDir, instanceDir, startScriptPath, "", resources)
+	if err := writeInstanceUnit(serviceName, unitContent, resources); err != nil {
 		markSharedFailure(err)
 		return failureResult(job.ID, err)
 	}
@@ -1246,6 +1242,16 @@ func validateExpectedStartExecutablePath(gameDir, expectedPath string, allowExte
 	execOk := st.Mode()&0o111 != 0
 	return true, execOk, nil
 }
+
+// sniperInstallPath picks the install path of a sniper job. install_path wins
+// over instance_dir when a payload carries both.
+func sniperInstallPath(payload map[string]any, defaultHomeDir string) string {
+	if installPath := strings.TrimSpace(payloadValue(payload, "install_path", "instance_dir")); installPath != "" {
+		return installPath
+	}
+	return defaultHomeDir
+}
+
 func resolveSniperUserHomeAndGameDir(payloadInstallPath string, osUsername string) (string, string, error) {
 	installPath := filepath.Clean(strings.TrimSpace(payloadInstallPath))
 	if installPath == "" || !filepath.IsAbs(installPath) {
@@ -29,6 +29,23 @@ func TestResolveSniperUserHomeAndGameDir(t *testing.T) {
 	})
 }
 
+func TestSniperInstallPathPrefersInstallPathOverInstanceDir(t *testing.T) {
+	payload := globalJobSchemas.canonicalPayload("sniper.install", map[string]any{
+		"install_path": "/home/gs225/game",
+		"instance_dir": "/home/gs225",
+	})
+	if got := sniperInstallPath(payload, "/home/gs225"); got != "/home/gs225/game" {
+		t.Fatalf("expected install_path to win, got %q", got)
+	}
+	payload = globalJobSchemas.canonicalPayload("sniper.install", map[string]any{"root_path": "/home/gs225/root"})
+	if got := sniperInstallPath(payload, "/home/gs225"); got != "/home/gs225/root" {
+		t.Fatalf("expected the instance_dir alias to apply, got %q", got)
+	}
+	if got := sniperInstallPath(map[string]any{}, "/home/gs225"); got != "/home/gs225" {
+		t.Fatalf("expected the default home dir, got %q", got)
+	}
+}
+
 func TestTemplateValuesUseLegacyInstanceDirByDefault(t *testing.T) {
 	userHome := "/home/gs23"
 	instanceDir := "/home/gs23"
@@ -34,7 +34,7 @@ func handleCoreSshPolicyApply(job jobs.Job) (jobs.Result, func() error) {
 		}, nil
 	}
 
-	accessMode := strings.TrimSpace(payloadValue(job.Payload, "access_mode", "ssh_access_mode"))
+	accessMode := strings.TrimSpace(payloadValue(job.Payload, "access_mode"))
 	authorizedKeysPath := strings.TrimSpace(payloadValue(job.Payload, "authorized_keys_path"))
 	sftpGroup := strings.TrimSpace(payloadValue(job.Payload, "sftp_group"))
 	if sftpGroup == "" {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -29,7 +30,9 @@ var (
 	errNoProgress     = errors.New("command no progress timeout")
 )
 
-func StreamCommand(cmd *exec.Cmd, jobID string, logSender JobLogSender) (string, error) {
+// StreamCommand runs cmd, mirrors its output to the job log and kills its
+// process group once ctx is cancelled.
+func StreamCommand(ctx context.Context, cmd *exec.Cmd, jobID string, logSender JobLogSender) (string, error) {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return "", fmt.Errorf("stdout pipe: %w", err)
@@ -39,6 +42,12 @@ func StreamCommand(cmd *exec.Cmd, jobID string, logSender JobLogSender) (string,
 		return "", fmt.Errorf("stderr pipe: %w", err)
 	}
 
+	if ctx.Done() != nil {
+		setCommandProcessGroup(cmd)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", errJobCancelled
+	}
 	if err := cmd.Start(); err != nil {
 		return "", fmt.Errorf("start command: %w", err)
 	}
@@ -157,14 +166,14 @@ func StreamCommand(cmd *exec.Cmd, jobID string, logSender JobLogSender) (string,
 				steamSawAppUpdate = true
 			}
 			if strings.Contains(lowerChunk, "failed to load script file") {
-				_ = cmd.Process.Kill()
+				killCommandProcessGroup(cmd)
 				flush(true)
 				close(logSendCh)
 				logWg.Wait()
 				return output.String(), errors.New("steamcmd_runscript_failed")
 			}
 			if strings.Contains(chunkText, "Steam>") && !steamSawAppUpdate {
-				_ = cmd.Process.Kill()
+				killCommandProcessGroup(cmd)
 				flush(true)
 				close(logSendCh)
 				logWg.Wait()
@@ -175,7 +184,7 @@ func StreamCommand(cmd *exec.Cmd, jobID string, logSender JobLogSender) (string,
 			flush(false)
 		case <-keepaliveTicker.C:
 			if idleDuration > 0 && time.Since(lastOutput) >= idleDuration {
-				_ = cmd.Process.Kill()
+				killCommandProcessGroup(cmd)
 				flush(true)
 				close(logSendCh)
 				logWg.Wait()
@@ -188,8 +197,16 @@ func StreamCommand(cmd *exec.Cmd, jobID string, logSender JobLogSender) (string,
 				logBuffer = append(logBuffer, "still running …")
 				flush(true)
 			}
+		case <-ctx.Done():
+			killCommandProcessGroup(cmd)
+			go func() { _ = cmd.Wait() }()
+			logBuffer = append(logBuffer, fmt.Sprintf("command cancelled: %s", commandLabel(cmd)))
+			flush(true)
+			close(logSendCh)
+			logWg.Wait()
+			return output.String(), errJobCancelled
 		case <-timeoutTimer:
-			_ = cmd.Process.Kill()
+			killCommandProcessGroup(cmd)
 			flush(true)
 			close(logSendCh)
 			logWg.Wait()
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"os/exec"
 	"strings"
@@ -11,7 +12,7 @@ import (
 func TestStreamCommandNoProgressTimeout(t *testing.T) {
 	t.Setenv("EASYWI_STEAMCMD_IDLE_TIMEOUT", "100ms")
 	cmd := exec.Command("bash", "-lc", "exec -a steamcmd sleep 7")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if !errors.Is(err, errNoProgress) {
 		t.Fatalf("expected errNoProgress, got %v", err)
 	}
@@ -20,7 +21,7 @@ func TestStreamCommandNoProgressTimeout(t *testing.T) {
 func TestStreamCommandTimeout(t *testing.T) {
 	t.Setenv("EASYWI_COMMAND_TIMEOUT", "100ms")
 	cmd := exec.Command("bash", "-lc", "sleep 1")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if !errors.Is(err, errCommandTimeout) {
 		t.Fatalf("expected errCommandTimeout, got %v", err)
 	}
@@ -30,7 +31,7 @@ func TestStreamCommandZeroExitSucceeds(t *testing.T) {
 	t.Setenv("EASYWI_COMMAND_TIMEOUT", "")
 	t.Setenv("EASYWI_STEAMCMD_IDLE_TIMEOUT", "")
 	cmd := exec.Command("bash", "-lc", "echo ok")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err != nil {
 		t.Fatalf("expected success, got %v", err)
 	}
@@ -38,7 +39,7 @@ func TestStreamCommandZeroExitSucceeds(t *testing.T) {
 
 func TestStreamCommandNonZeroExitFails(t *testing.T) {
 	cmd := exec.Command("bash", "-lc", "exit 3")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err == nil {
 		t.Fatal("expected non-zero exit error")
 	}
@@ -53,7 +54,7 @@ func TestParseDurationEnv(t *testing.T) {
 
 func TestStreamCommandSteamCmdFailedRunScriptStopsImmediately(t *testing.T) {
 	cmd := exec.Command("bash", "-lc", "echo 'Failed to load script file /tmp/a.txt'; sleep 10 # steamcmd +runscript /x")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err == nil || !strings.Contains(err.Error(), "steamcmd_runscript_failed") {
 		t.Fatalf("expected steamcmd_runscript_failed, got %v", err)
 	}
@@ -61,7 +62,7 @@ func TestStreamCommandSteamCmdFailedRunScriptStopsImmediately(t *testing.T) {
 
 func TestStreamCommandSteamCmdInteractivePromptFailsWithoutUpdate(t *testing.T) {
 	cmd := exec.Command("bash", "-lc", "echo 'Steam>'; sleep 10 # steamcmd +runscript /x")
-	_, err := StreamCommand(cmd, "", nil)
+	_, err := StreamCommand(context.Background(), cmd, "", nil)
 	if err == nil || !strings.Contains(err.Error(), "steamcmd_interactive_prompt_or_runscript_failed") {
 		t.Fatalf("expected steamcmd_interactive_prompt_or_runscript_failed, got %v", err)
 	}
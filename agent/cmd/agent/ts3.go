@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -16,18 +17,18 @@ const (
 	ts3ConfigFile = "ts3server.ini"
 )
 
-func handleTs3Create(job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
-	instanceID := payloadValue(job.Payload, "ts3_instance_id", "instance_id")
+func handleTs3Create(ctx context.Context, job jobs.Job, logSender JobLogSender) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "ts3_instance_id")
 	customerID := payloadValue(job.Payload, "customer_id")
 	name := payloadValue(job.Payload, "name")
-	voicePort := payloadValue(job.Payload, "voice_port", "voice_port")
-	queryPort := payloadValue(job.Payload, "query_port", "query_port")
-	filePort := payloadValue(job.Payload, "file_port", "file_port")
+	voicePort := payloadValue(job.Payload, "voice_port")
+	queryPort := payloadValue(job.Payload, "query_port")
+	filePort := payloadValue(job.Payload, "file_port")
 	voiceIP := payloadValue(job.Payload, "voice_ip")
 	queryIP := payloadValue(job.Payload, "query_ip")
 	fileIP := payloadValue(job.Payload, "filetransfer_ip")
-	licensePath := payloadValue(job.Payload, "licensepath", "license_path")
-	adminPassword := payloadValue(job.Payload, "serveradmin_password", "server_admin_password", "admin_password")
+	licensePath := payloadValue(job.Payload, "licensepath")
+	adminPassword := payloadValue(job.Payload, "serveradmin_password")
 	dbMode := strings.ToLower(payloadValue(job.Payload, "db_mode"))
 	dbHost := payloadValue(job.Payload, "db_host")
 	dbPort := payloadValue(job.Payload, "db_port")
@@ -35,7 +36,7 @@ func handleTs3Create(job jobs.Job, logSender JobLogSender) (jobs.Result, func()
 	dbUsername := payloadValue(job.Payload, "db_username")
 	dbPassword := payloadValue(job.Payload, "db_password")
 	baseDir := payloadValue(job.Payload, "base_dir")
-	startCommand := payloadValue(job.Payload, "exec_start", "start_command")
+	startCommand := payloadValue(job.Payload, "exec_start")
 	installCommand := payloadValue(job.Payload, "install_command")
 	serviceName := payloadValue(job.Payload, "service_name")
 
@@ -90,7 +91,7 @@ func handleTs3Create(job jobs.Job, logSender JobLogSender) (jobs.Result, func()
 			return failureResult(job.ID, err)
 		}
 		installWithDir := fmt.Sprintf("cd %s && %s", instanceDir, renderedInstallCommand)
-		_, err = runCommandOutputAsUserWithLogs(osUsername, installWithDir, job.ID, logSender)
+		_, err = runCommandOutputAsUserWithLogs(ctx, osUsername, installWithDir, job.ID, logSender)
 		if err != nil {
 			return failureResult(job.ID, fmt.Errorf("install command failed: %w", err))
 		}
@@ -187,8 +188,8 @@ func handleTs3Update(job jobs.Job) (jobs.Result, func() error) {
 	return handleTs3ServiceAction(job, "restart")
 }
 
-func handleTs3Backup(job jobs.Job) (jobs.Result, func() error) {
-	instanceID := payloadValue(job.Payload, "ts3_instance_id", "instance_id")
+func handleTs3Backup(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+	instanceID := payloadValue(job.Payload, "ts3_instance_id")
 	instanceDir := ts3InstanceDir(job)
 	if instanceDir == "" {
 		return failureResult(job.ID, fmt.Errorf("missing instance directory"))
@@ -197,6 +198,11 @@ func handleTs3Backup(job jobs.Job) (jobs.Result, func() error) {
 		return failureResult(job.ID, fmt.Errorf("missing instance id"))
 	}
 
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+
 	backupPath := payloadValue(job.Payload, "backup_path")
 	if backupPath == "" {
 		backupDir := filepath.Join(filepath.Dir(instanceDir), "backups")
@@ -209,6 +215,13 @@ func handleTs3Backup(job jobs.Job) (jobs.Result, func() error) {
 	if err := runCommand("tar", "-czf", backupPath, "-C", instanceDir, "."); err != nil {
 		return failureResult(job.ID, err)
 	}
+	if target != nil {
+		remotePath, result := uploadBackupArchive(ctx, job, target, backupPath, filepath.Base(backupPath))
+		if result != nil {
+			return *result, nil
+		}
+		backupPath = remotePath
+	}
 
 	return jobs.Result{
 		JobID:  job.ID,
@@ -452,7 +465,7 @@ func ts3ServiceName(job jobs.Job) string {
 	if serviceName != "" {
 		return serviceName
 	}
-	instanceID := payloadValue(job.Payload, "ts3_instance_id", "instance_id")
+	instanceID := payloadValue(job.Payload, "ts3_instance_id")
 	if instanceID == "" {
 		return ""
 	}
@@ -461,7 +474,7 @@ func ts3ServiceName(job jobs.Job) string {
 
 func ts3Username(job jobs.Job) string {
 	customerID := payloadValue(job.Payload, "customer_id")
-	instanceID := payloadValue(job.Payload, "ts3_instance_id", "instance_id")
+	instanceID := payloadValue(job.Payload, "ts3_instance_id")
 	if customerID == "" || instanceID == "" {
 		return ""
 	}
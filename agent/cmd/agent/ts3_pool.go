@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"net"
@@ -11,12 +14,22 @@ import (
 	"time"
 )
 
-const tsQueryMinInterval = 200 * time.Millisecond
+const (
+	tsQueryMinInterval = 200 * time.Millisecond
+	// TS3 and TS6 drop query sessions after five idle minutes.
+	tsQueryKeepaliveInterval = 2 * time.Minute
+	// tsQueryIdleClose ends sessions no job has used for a while, so hosts
+	// the panel stopped managing do not keep a login open forever.
+	tsQueryIdleClose = 30 * time.Minute
+)
 
-// tsPoolKey identifies a unique TS query endpoint.
+// tsPoolKey identifies a unique TS query endpoint. credentials is a digest of
+// the passwords, so a rotated password opens a new session instead of reusing
+// one logged in with the old one.
 type tsPoolKey struct {
-	address  string
-	username string
+	address     string
+	username    string
+	credentials string
 }
 
 // tsPoolEntry holds a single persistent connection to one TS server.
@@ -26,11 +39,16 @@ type tsPoolEntry struct {
 	mu      sync.Mutex
 	client  *ts3QueryClient
 	lastCmd time.Time
+	// lastUse is the last time a job ran on the entry; keepalives do not
+	// count.
+	lastUse time.Time
 	factory func() (*ts3QueryClient, error)
 }
 
 // use locks the entry, enforces a minimum command interval to avoid
-// flood-ban thresholds, and retries once on connection failure.
+// flood-ban thresholds, and retries once on connection failure. The retry
+// only happens while fn has not sent a command that changes server state, so
+// a command the server may already have run is never replayed.
 func (e *tsPoolEntry) use(fn func(*ts3QueryClient) error) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -50,10 +68,12 @@ func (e *tsPoolEntry) use(fn func(*ts3QueryClient) error) error {
 			e.client = c
 		}
 
+		e.client.sentChanges = false
 		err := fn(e.client)
 		e.lastCmd = time.Now()
+		e.lastUse = e.lastCmd
 		if err != nil {
-			if attempt == 0 && isTsQueryConnectionError(err) {
+			if attempt == 0 && !e.client.sentChanges && isTsQueryConnectionError(err) {
 				e.client.close()
 				e.client = nil
 				continue
@@ -65,6 +85,33 @@ func (e *tsPoolEntry) use(fn func(*ts3QueryClient) error) error {
 	return nil
 }
 
+// keepalive pings an idle session so the server does not drop it, and
+// closes sessions that have not been used for tsQueryIdleClose. Entries
+// busy with a job are skipped.
+func (e *tsPoolEntry) keepalive(now time.Time) {
+	if !e.mu.TryLock() {
+		return
+	}
+	defer e.mu.Unlock()
+	if e.client == nil {
+		return
+	}
+	if now.Sub(e.lastUse) >= tsQueryIdleClose {
+		e.client.close()
+		e.client = nil
+		return
+	}
+	if now.Sub(e.lastCmd) < tsQueryKeepaliveInterval {
+		return
+	}
+	e.lastCmd = now
+	if _, err := e.client.command("whoami"); err != nil {
+		// The next job reconnects through the factory.
+		e.client.close()
+		e.client = nil
+	}
+}
+
 func isTsQueryConnectionError(err error) bool {
 	if err == nil {
 		return false
@@ -103,6 +150,34 @@ func (p *tsConnectionPool) entry(key tsPoolKey, factory func() (*ts3QueryClient,
 	return e
 }
 
+func (p *tsConnectionPool) keepalive(now time.Time) {
+	p.mu.Lock()
+	entries := make([]*tsPoolEntry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	p.mu.Unlock()
+	for _, e := range entries {
+		e.keepalive(now)
+	}
+}
+
+// runTsPoolKeepalive keeps the pooled TS3 and TS6 sessions alive until ctx
+// ends.
+func runTsPoolKeepalive(ctx context.Context) {
+	ticker := time.NewTicker(tsQueryKeepaliveInterval / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			globalTs3Pool.keepalive(now)
+			globalTs6Pool.keepalive(now)
+		}
+	}
+}
+
 var (
 	globalTs3Pool = &tsConnectionPool{entries: make(map[tsPoolKey]*tsPoolEntry)}
 	globalTs6Pool = &tsConnectionPool{entries: make(map[tsPoolKey]*tsPoolEntry)}
@@ -163,7 +238,7 @@ func ensureQueryAllowlisted(installDir, filename, addr string) {
 }
 
 func tsPoolKeyFromTs3Payload(payload map[string]any) tsPoolKey {
-	queryIP := payloadValue(payload, "query_bind_ip", "query_ip")
+	queryIP := schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip")
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -176,12 +251,12 @@ func tsPoolKeyFromTs3Payload(payload map[string]any) tsPoolKey {
 	if user == "" {
 		user = "serveradmin"
 	}
-	return tsPoolKey{address: net.JoinHostPort(queryIP, queryPort), username: user}
+	return tsPoolKey{address: net.JoinHostPort(queryIP, queryPort), username: user, credentials: tsPoolCredentials(payload)}
 }
 
 func tsPoolKeyFromTs6Payload(payload map[string]any) tsPoolKey {
-	protocol := strings.ToLower(strings.TrimSpace(payloadValue(payload, "query_protocol", "query_transport")))
-	queryIP := payloadValue(payload, "query_bind_ip", "query_ip")
+	protocol := strings.ToLower(strings.TrimSpace(schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_protocol")))
+	queryIP := schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip")
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -202,5 +277,15 @@ func tsPoolKeyFromTs6Payload(payload map[string]any) tsPoolKey {
 	if user == "" {
 		user = "serveradmin"
 	}
-	return tsPoolKey{address: net.JoinHostPort(queryIP, queryPort), username: user}
+	return tsPoolKey{address: net.JoinHostPort(queryIP, queryPort), username: user, credentials: tsPoolCredentials(payload)}
+}
+
+// tsPoolCredentials digests every secret a query login may use.
+func tsPoolCredentials(payload map[string]any) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		payloadValue(payload, "admin_password"),
+		payloadValue(payload, "query_ssh_username"),
+		payloadValue(payload, "query_ssh_password"),
+	}, "\x00")))
+	return hex.EncodeToString(sum[:])
 }
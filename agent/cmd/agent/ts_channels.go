@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	tsChannelNameMaxLength = 40
+	tsChannelLayoutMaxSize = 500
+)
+
+// tsChannelSpec is the desired state of one channel. Nil fields are left as
+// they are; an empty Password removes the password.
+type tsChannelSpec struct {
+	Name         string
+	Password     *string
+	Topic        *string
+	Description  *string
+	Codec        *int
+	CodecQuality *int
+	// MaxClients is -1 for unlimited.
+	MaxClients *int
+	// Type is permanent or semi_permanent; empty keeps the current type and
+	// creates permanent channels.
+	Type     string
+	Default  bool
+	Children []tsChannelSpec
+}
+
+// parseTsChannelSpec reads channel properties from a job payload or from one
+// entry of a layout.
+func parseTsChannelSpec(values map[string]any) (tsChannelSpec, error) {
+	spec := tsChannelSpec{
+		Name:        strings.TrimSpace(payloadValue(values, "name", "channel_name")),
+		Password:    optionalTsString(values, "password", "channel_password"),
+		Topic:       optionalTsString(values, "topic", "channel_topic"),
+		Description: optionalTsString(values, "description", "channel_description"),
+		Type:        strings.ToLower(payloadValue(values, "type")),
+		Default:     parsePayloadBool(payloadValue(values, "default"), false),
+	}
+	if spec.Name != "" && utf8.RuneCountInString(spec.Name) > tsChannelNameMaxLength {
+		return spec, fmt.Errorf("channel name %q is longer than %d characters", spec.Name, tsChannelNameMaxLength)
+	}
+	switch spec.Type {
+	case "", "permanent", "semi_permanent":
+	case "semi-permanent":
+		spec.Type = "semi_permanent"
+	default:
+		return spec, fmt.Errorf("channel %q: unsupported type %q", spec.Name, spec.Type)
+	}
+	for _, field := range []struct {
+		target   **int
+		keys     []string
+		min, max int
+	}{
+		{&spec.Codec, []string{"codec", "channel_codec"}, 0, 5},
+		{&spec.CodecQuality, []string{"codec_quality", "channel_codec_quality"}, 0, 10},
+		{&spec.MaxClients, []string{"max_clients", "channel_maxclients"}, -1, 10000},
+	} {
+		raw := payloadValue(values, field.keys...)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < field.min || value > field.max {
+			return spec, fmt.Errorf("channel %q: %s must be between %d and %d", spec.Name, field.keys[0], field.min, field.max)
+		}
+		*field.target = &value
+	}
+	return spec, nil
+}
+
+func optionalTsString(values map[string]any, keys ...string) *string {
+	for _, key := range keys {
+		if value, ok := values[key]; ok && value != nil {
+			text := payloadString(value)
+			return &text
+		}
+	}
+	return nil
+}
+
+// parseTsChannelLayout reads a layout: a list of channels, each with an
+// optional children list. Sibling names must be unique because they are how
+// the layout finds existing channels.
+func parseTsChannelLayout(raw string) ([]tsChannelSpec, error) {
+	var entries []map[string]any
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, fmt.Errorf("layout must be a list of channels: %w", err)
+	}
+	count := 0
+	specs, err := parseTsChannelLayoutLevel(entries, &count)
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("layout has no channels")
+	}
+	return specs, nil
+}
+
+func parseTsChannelLayoutLevel(entries []map[string]any, count *int) ([]tsChannelSpec, error) {
+	specs := make([]tsChannelSpec, 0, len(entries))
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if *count++; *count > tsChannelLayoutMaxSize {
+			return nil, fmt.Errorf("layout has more than %d channels", tsChannelLayoutMaxSize)
+		}
+		spec, err := parseTsChannelSpec(entry)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Name == "" {
+			return nil, errors.New("layout channel without name")
+		}
+		if seen[spec.Name] {
+			return nil, fmt.Errorf("layout lists channel %q twice under the same parent", spec.Name)
+		}
+		seen[spec.Name] = true
+		if rawChildren, ok := entry["children"]; ok && rawChildren != nil {
+			var children []map[string]any
+			if err := json.Unmarshal([]byte(payloadString(rawChildren)), &children); err != nil {
+				return nil, fmt.Errorf("channel %q: children must be a list", spec.Name)
+			}
+			if spec.Children, err = parseTsChannelLayoutLevel(children, count); err != nil {
+				return nil, err
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// createArgs are the channelcreate properties of the spec.
+func (spec tsChannelSpec) createArgs() []string {
+	args := []string{"channel_name=" + escapeTs3Query(spec.Name)}
+	if spec.Password != nil && *spec.Password != "" {
+		args = append(args, "channel_password="+escapeTs3Query(*spec.Password))
+	}
+	if spec.Topic != nil && *spec.Topic != "" {
+		args = append(args, "channel_topic="+escapeTs3Query(*spec.Topic))
+	}
+	if spec.Description != nil && *spec.Description != "" {
+		args = append(args, "channel_description="+escapeTs3Query(*spec.Description))
+	}
+	if spec.Codec != nil {
+		args = append(args, fmt.Sprintf("channel_codec=%d", *spec.Codec))
+	}
+	if spec.CodecQuality != nil {
+		args = append(args, fmt.Sprintf("channel_codec_quality=%d", *spec.CodecQuality))
+	}
+	args = append(args, spec.maxClientsArgs()...)
+	if spec.Type == "semi_permanent" {
+		args = append(args, "channel_flag_semi_permanent=1")
+	} else {
+		args = append(args, "channel_flag_permanent=1")
+	}
+	if spec.Default {
+		args = append(args, "channel_flag_default=1")
+	}
+	return args
+}
+
+func (spec tsChannelSpec) maxClientsArgs() []string {
+	if spec.MaxClients == nil {
+		return nil
+	}
+	if *spec.MaxClients < 0 {
+		return []string{"channel_flag_maxclients_unlimited=1"}
+	}
+	return []string{"channel_flag_maxclients_unlimited=0", fmt.Sprintf("channel_maxclients=%d", *spec.MaxClients)}
+}
+
+// editArgs are the channeledit properties that differ from the channel as
+// listed by channellist -topic -flags -voice -limits. Passwords cannot be
+// read back, so only setting or removing one is detected; changing an
+// existing password needs channel.edit. Descriptions are not listed either
+// and are only set when a layout creates the channel.
+func (spec tsChannelSpec) editArgs(current map[string]string) []string {
+	var args []string
+	if spec.Name != "" && spec.Name != current["channel_name"] {
+		args = append(args, "channel_name="+escapeTs3Query(spec.Name))
+	}
+	if spec.Password != nil {
+		hasPassword := current["channel_flag_password"] == "1"
+		if *spec.Password == "" && hasPassword {
+			args = append(args, "channel_password=")
+		} else if *spec.Password != "" && !hasPassword {
+			args = append(args, "channel_password="+escapeTs3Query(*spec.Password))
+		}
+	}
+	if spec.Topic != nil && *spec.Topic != current["channel_topic"] {
+		args = append(args, "channel_topic="+escapeTs3Query(*spec.Topic))
+	}
+	if spec.Codec != nil && strconv.Itoa(*spec.Codec) != current["channel_codec"] {
+		args = append(args, fmt.Sprintf("channel_codec=%d", *spec.Codec))
+	}
+	if spec.CodecQuality != nil && strconv.Itoa(*spec.CodecQuality) != current["channel_codec_quality"] {
+		args = append(args, fmt.Sprintf("channel_codec_quality=%d", *spec.CodecQuality))
+	}
+	if spec.MaxClients != nil {
+		unlimited := current["channel_flag_maxclients_unlimited"] == "1" || current["channel_maxclients"] == "-1"
+		if (*spec.MaxClients < 0) != unlimited || (*spec.MaxClients >= 0 && strconv.Itoa(*spec.MaxClients) != current["channel_maxclients"]) {
+			args = append(args, spec.maxClientsArgs()...)
+		}
+	}
+	switch spec.Type {
+	case "permanent":
+		if current["channel_flag_permanent"] != "1" {
+			args = append(args, "channel_flag_permanent=1", "channel_flag_semi_permanent=0")
+		}
+	case "semi_permanent":
+		if current["channel_flag_semi_permanent"] != "1" {
+			args = append(args, "channel_flag_semi_permanent=1", "channel_flag_permanent=0")
+		}
+	}
+	if spec.Default && current["channel_flag_default"] != "1" {
+		args = append(args, "channel_flag_default=1")
+	}
+	return args
+}
+
+// tsChannelLayoutChange is one step of a layout apply, reported to the panel
+// without property values so passwords do not end up in job results.
+type tsChannelLayoutChange struct {
+	Action string   `json:"action"`
+	Path   string   `json:"path"`
+	CID    string   `json:"cid"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+// tsChannelLayout diffs a layout against channellist and runs the changes
+// through exec. In a dry run exec only records commands and hands out
+// placeholder cids for new channels.
+type tsChannelLayout struct {
+	existing []map[string]string
+	claimed  map[string]bool
+	exec     func(cmd string) (map[string]string, error)
+	changes  []tsChannelLayoutChange
+}
+
+func newTsChannelLayout(existing []map[string]string, exec func(cmd string) (map[string]string, error)) *tsChannelLayout {
+	return &tsChannelLayout{existing: existing, claimed: map[string]bool{}, exec: exec}
+}
+
+func (layout *tsChannelLayout) match(parent string, spec tsChannelSpec) map[string]string {
+	for _, channel := range layout.existing {
+		if channel["pid"] != parent || layout.claimed[channel["cid"]] {
+			continue
+		}
+		if channel["channel_name"] == spec.Name || (spec.Default && channel["channel_flag_default"] == "1") {
+			return channel
+		}
+	}
+	return nil
+}
+
+func (layout *tsChannelLayout) apply(specs []tsChannelSpec, parent, parentPath string) error {
+	previous := "0"
+	for _, spec := range specs {
+		path := parentPath + "/" + spec.Name
+		current := layout.match(parent, spec)
+		var cid string
+		if current == nil {
+			args := append(spec.createArgs(), "cpid="+parent, "channel_order="+previous)
+			response, err := layout.exec("channelcreate " + strings.Join(args, " "))
+			if err != nil {
+				return fmt.Errorf("create channel %s: %w", path, err)
+			}
+			if cid = response["cid"]; cid == "" {
+				return fmt.Errorf("create channel %s: channelcreate did not return cid", path)
+			}
+			layout.changes = append(layout.changes, tsChannelLayoutChange{Action: "create", Path: path, CID: cid})
+		} else {
+			cid = current["cid"]
+			layout.claimed[cid] = true
+			if args := spec.editArgs(current); len(args) > 0 {
+				if _, err := layout.exec(fmt.Sprintf("channeledit cid=%s %s", cid, strings.Join(args, " "))); err != nil {
+					return fmt.Errorf("edit channel %s: %w", path, err)
+				}
+				layout.changes = append(layout.changes, tsChannelLayoutChange{Action: "edit", Path: path, CID: cid, Fields: tsQueryArgNames(args)})
+			}
+			if current["channel_order"] != previous {
+				if _, err := layout.exec(fmt.Sprintf("channelmove cid=%s cpid=%s order=%s", cid, parent, previous)); err != nil {
+					return fmt.Errorf("move channel %s: %w", path, err)
+				}
+				layout.changes = append(layout.changes, tsChannelLayoutChange{Action: "move", Path: path, CID: cid})
+			}
+		}
+		if err := layout.apply(spec.Children, cid, path); err != nil {
+			return err
+		}
+		previous = cid
+	}
+	return nil
+}
+
+// prune deletes channels the layout does not list. Subtrees go with their
+// top channel; the default channel and temporary channels users opened are
+// kept.
+func (layout *tsChannelLayout) prune() error {
+	byCID := make(map[string]map[string]string, len(layout.existing))
+	for _, channel := range layout.existing {
+		byCID[channel["cid"]] = channel
+	}
+	deletable := func(channel map[string]string) bool {
+		if layout.claimed[channel["cid"]] || channel["channel_flag_default"] == "1" {
+			return false
+		}
+		return channel["channel_flag_permanent"] == "1" || channel["channel_flag_semi_permanent"] == "1"
+	}
+	for _, channel := range layout.existing {
+		if !deletable(channel) {
+			continue
+		}
+		// force=1 takes the subtree along with its top channel.
+		if parent, ok := byCID[channel["pid"]]; ok && deletable(parent) {
+			continue
+		}
+		cid := channel["cid"]
+		if _, err := layout.exec(fmt.Sprintf("channeldelete cid=%s force=1", cid)); err != nil {
+			return fmt.Errorf("delete channel %s: %w", channel["channel_name"], err)
+		}
+		layout.changes = append(layout.changes, tsChannelLayoutChange{Action: "delete", Path: "/" + channel["channel_name"], CID: cid})
+	}
+	return nil
+}
+
+func tsQueryArgNames(args []string) []string {
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		names = append(names, name)
+	}
+	return names
+}
+
+func listTsChannels(client *ts3QueryClient) ([]map[string]string, error) {
+	lines, err := client.commandLines("channellist -topic -flags -voice -limits")
+	if err != nil {
+		return nil, err
+	}
+	return parseQueryList(lines), nil
+}
+
+// applyTsChannelLayout brings the selected virtual server's channels in line
+// with specs and returns what changed.
+func applyTsChannelLayout(client *ts3QueryClient, specs []tsChannelSpec, prune, dryRun bool) ([]tsChannelLayoutChange, error) {
+	existing, err := listTsChannels(client)
+	if err != nil {
+		return nil, err
+	}
+	created := 0
+	exec := client.command
+	if dryRun {
+		exec = func(cmd string) (map[string]string, error) {
+			if strings.HasPrefix(cmd, "channelcreate ") {
+				created++
+				return map[string]string{"cid": fmt.Sprintf("new-%d", created)}, nil
+			}
+			return map[string]string{}, nil
+		}
+	}
+	layout := newTsChannelLayout(existing, exec)
+	if err := layout.apply(specs, "0", ""); err != nil {
+		return layout.changes, err
+	}
+	if prune {
+		if err := layout.prune(); err != nil {
+			return layout.changes, err
+		}
+	}
+	return layout.changes, nil
+}
+
+func handleTsChannelLayoutApply(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	if sid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid"}
+	}
+	specs, err := parseTsChannelLayout(payloadValue(job.Payload, "layout"))
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	prune := parsePayloadBool(payloadValue(job.Payload, "prune"), false)
+	dryRun := parsePayloadBool(payloadValue(job.Payload, "dry_run"), false)
+
+	var changes []tsChannelLayoutChange
+	err = withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(sid); err != nil {
+			return err
+		}
+		var err error
+		changes, err = applyTsChannelLayout(client, specs, prune, dryRun)
+		return err
+	})
+	payload := map[string]any{"sid": sid, "changes": changes, "dry_run": dryRun}
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error(), resultPayload: payload}
+	}
+	return orchestratorResult{status: "success", resultPayload: payload}
+}
+
+func handleTsChannelCreate(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	spec, err := parseTsChannelSpec(job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if sid == "" || spec.Name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or name"}
+	}
+	args := spec.createArgs()
+	if parent := payloadValue(job.Payload, "cpid"); parent != "" {
+		args = append(args, "cpid="+parent)
+	}
+	if order := payloadValue(job.Payload, "order"); order != "" {
+		args = append(args, "channel_order="+order)
+	}
+	response, err := runTsServerCommands(job, sid, []string{"channelcreate " + strings.Join(args, " ")})
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if response["cid"] == "" {
+		return orchestratorResult{status: "failed", errorText: "channelcreate did not return cid"}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": response["cid"], "name": spec.Name}}
+}
+
+// handleTsChannelEdit sets the given properties; unlike a layout apply it
+// does not compare them first, so it can also change a password.
+func handleTsChannelEdit(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	cid := payloadValue(job.Payload, "cid")
+	if sid == "" || cid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or cid"}
+	}
+	spec, err := parseTsChannelSpec(job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	args := spec.editArgs(map[string]string{})
+	if spec.Password != nil && *spec.Password == "" {
+		args = append(args, "channel_password=")
+	}
+	if spec.Topic != nil && *spec.Topic == "" {
+		args = append(args, "channel_topic=")
+	}
+	if spec.Description != nil {
+		args = append(args, "channel_description="+escapeTs3Query(*spec.Description))
+	}
+	if len(args) == 0 {
+		return orchestratorResult{status: "failed", errorText: "no channel properties to change"}
+	}
+	if _, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("channeledit cid=%s %s", cid, strings.Join(args, " "))}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": cid, "fields": tsQueryArgNames(args)}}
+}
+
+func handleTsChannelMove(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	cid := payloadValue(job.Payload, "cid")
+	parent := payloadValue(job.Payload, "cpid")
+	if sid == "" || cid == "" || parent == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid, cid or cpid"}
+	}
+	command := fmt.Sprintf("channelmove cid=%s cpid=%s", cid, parent)
+	if order := payloadValue(job.Payload, "order"); order != "" {
+		command += " order=" + order
+	}
+	if _, err := runTsServerCommands(job, sid, []string{command}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": cid, "cpid": parent}}
+}
+
+func handleTsChannelDelete(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	cid := payloadValue(job.Payload, "cid")
+	if sid == "" || cid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or cid"}
+	}
+	force := boolToInt(parsePayloadBool(payloadValue(job.Payload, "force"), false))
+	if _, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("channeldelete cid=%s force=%d", cid, force)}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": cid, "deleted": true}}
+}
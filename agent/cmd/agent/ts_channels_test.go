@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTsChannelLayoutAppliesOnlyDifferences(t *testing.T) {
+	specs, err := parseTsChannelLayout(`[
+		{"name": "Lobby", "default": true, "topic": "Welcome"},
+		{"name": "Games", "max_clients": -1, "children": [
+			{"name": "Squad 1", "max_clients": 5, "codec": 4, "codec_quality": 10},
+			{"name": "Squad 2", "max_clients": "5", "password": "s3cret", "type": "semi-permanent"}
+		]},
+		{"name": "AFK", "max_clients": 20}
+	]`)
+	if err != nil {
+		t.Fatalf("parse layout: %v", err)
+	}
+	existing := parseQueryList([]string{strings.Join([]string{
+		`cid=1 pid=0 channel_order=0 channel_name=Default\sChannel channel_topic=Welcome channel_flag_default=1 channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=2 pid=0 channel_order=1 channel_name=Games channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=3 pid=2 channel_order=0 channel_name=Squad\s1 channel_flag_permanent=1 channel_maxclients=5 channel_codec=4 channel_codec_quality=10`,
+		`cid=4 pid=0 channel_order=2 channel_name=Old channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=5 pid=4 channel_order=0 channel_name=Old\sChild channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=6 pid=0 channel_order=4 channel_name=Someone's\sRoom channel_maxclients=-1`,
+	}, "|")})
+
+	var commands []string
+	nextCID := 10
+	layout := newTsChannelLayout(existing, func(cmd string) (map[string]string, error) {
+		commands = append(commands, cmd)
+		if strings.HasPrefix(cmd, "channelcreate ") {
+			nextCID++
+			return map[string]string{"cid": fmt.Sprint(nextCID)}, nil
+		}
+		return map[string]string{}, nil
+	})
+	if err := layout.apply(specs, "0", ""); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if err := layout.prune(); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+
+	want := []string{
+		`channeledit cid=1 channel_name=Lobby`,
+		`channelcreate channel_name=Squad\s2 channel_password=s3cret channel_flag_maxclients_unlimited=0 channel_maxclients=5 channel_flag_semi_permanent=1 cpid=2 channel_order=3`,
+		`channelcreate channel_name=AFK channel_flag_maxclients_unlimited=0 channel_maxclients=20 channel_flag_permanent=1 cpid=0 channel_order=2`,
+		`channeldelete cid=4 force=1`,
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(commands, "\n"))
+	}
+	for _, change := range layout.changes {
+		if strings.Contains(fmt.Sprint(change), "s3cret") {
+			t.Fatalf("change report leaks the password: %#v", change)
+		}
+	}
+
+	// Applying the same layout to the resulting tree changes nothing.
+	applied := parseQueryList([]string{strings.Join([]string{
+		`cid=1 pid=0 channel_order=0 channel_name=Lobby channel_topic=Welcome channel_flag_default=1 channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=2 pid=0 channel_order=1 channel_name=Games channel_flag_permanent=1 channel_maxclients=-1`,
+		`cid=3 pid=2 channel_order=0 channel_name=Squad\s1 channel_flag_permanent=1 channel_maxclients=5 channel_codec=4 channel_codec_quality=10`,
+		`cid=11 pid=2 channel_order=3 channel_name=Squad\s2 channel_flag_password=1 channel_flag_semi_permanent=1 channel_maxclients=5`,
+		`cid=12 pid=0 channel_order=2 channel_name=AFK channel_flag_permanent=1 channel_maxclients=20`,
+	}, "|")})
+	commands = nil
+	layout = newTsChannelLayout(applied, layout.exec)
+	if err := layout.apply(specs, "0", ""); err != nil || len(commands) != 0 {
+		t.Fatalf("expected no changes, got %v (%v)", commands, err)
+	}
+
+	for _, invalid := range []string{
+		`[{"name": "A"}, {"name": "A"}]`,
+		`[{"name": "A", "codec": 9}]`,
+		`[{"name": "A", "type": "temporary"}]`,
+		`[{"topic": "no name"}]`,
+		`[]`,
+	} {
+		if _, err := parseTsChannelLayout(invalid); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
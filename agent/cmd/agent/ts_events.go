@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	tsEventsFlushInterval  = 2 * time.Second
+	tsEventsBatchSize      = 200
+	tsEventsMaxQueued      = 5000
+	tsEventsReconnectDelay = 15 * time.Second
+)
+
+// tsEventRegistrations maps the event names a subscription job may ask for to
+// their servernotifyregister arguments. textchannel only covers the channel
+// the query client sits in, which is the default channel.
+var tsEventRegistrations = map[string]string{
+	"server":      "event=server",
+	"channel":     "event=channel id=0",
+	"textserver":  "event=textserver",
+	"textchannel": "event=textchannel",
+	"textprivate": "event=textprivate",
+}
+
+const tsDefaultEventRegistrations = "server,channel,textserver,textchannel,textprivate"
+
+// tsNotifyEventNames gives the panel stable names for the notifications it
+// cares about; others are forwarded without their notify prefix.
+var tsNotifyEventNames = map[string]string{
+	"notifycliententerview":           "client_join",
+	"notifyclientleftview":            "client_leave",
+	"notifyclientmoved":               "client_moved",
+	"notifytextmessage":               "text_message",
+	"notifychannelcreated":            "channel_created",
+	"notifychanneledited":             "channel_edited",
+	"notifychanneldescriptionchanged": "channel_description_changed",
+	"notifychannelpasswordchanged":    "channel_password_changed",
+	"notifychannelmoved":              "channel_moved",
+	"notifychanneldeleted":            "channel_deleted",
+	"notifyserveredited":              "server_edited",
+}
+
+// tsEventSubscription is one ServerQuery session registered for
+// notifications of one virtual server. It is not pooled: a connection that
+// waits for notifications cannot run job commands in between.
+type tsEventSubscription struct {
+	id      string
+	kind    string
+	sid     string
+	events  []string
+	payload map[string]any
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	connected bool
+	lastError string
+	since     time.Time
+}
+
+func (sub *tsEventSubscription) setState(connected bool, err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.connected = connected
+	if err != nil {
+		sub.lastError = err.Error()
+	} else if connected {
+		sub.lastError = ""
+	}
+}
+
+func (sub *tsEventSubscription) status() map[string]any {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	status := map[string]any{
+		"subscription_id": sub.id,
+		"kind":            sub.kind,
+		"sid":             sub.sid,
+		"events":          sub.events,
+		"connected":       sub.connected,
+		"since":           sub.since.UTC().Format(time.RFC3339),
+	}
+	if sub.lastError != "" {
+		status["last_error"] = sub.lastError
+	}
+	return status
+}
+
+// tsEvents holds the notification subscriptions and the queue of events
+// waiting to be forwarded to the panel in batches.
+type tsEvents struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	subs    map[string]*tsEventSubscription
+	queue   []map[string]any
+	dropped int
+	now     func() time.Time
+	dial    func(kind string, payload map[string]any) (*ts3QueryClient, error)
+	report  func(events []map[string]any) error
+}
+
+var globalTsEvents = &tsEvents{
+	subs: map[string]*tsEventSubscription{},
+	now:  time.Now,
+	dial: dialTsEventClient,
+}
+
+func dialTsEventClient(kind string, payload map[string]any) (*ts3QueryClient, error) {
+	installDir := strings.TrimSpace(payloadValue(payload, "install_dir"))
+	if kind == "ts6" {
+		if installDir != "" {
+			ensureQueryAllowlisted(installDir, "query_ip_allowlist.txt", tsPoolKeyFromTs6Payload(payload).address)
+		}
+		return newTs6QueryClient(payload)
+	}
+	if installDir != "" {
+		ensureQueryAllowlisted(installDir, "query_ip_whitelist.txt", tsPoolKeyFromTs3Payload(payload).address)
+	}
+	return newTs3QueryClient(payload)
+}
+
+// Run forwards queued events every tsEventsFlushInterval until ctx ends.
+// Subscriptions started before Run use the background context.
+func (h *tsEvents) Run(ctx context.Context) {
+	h.mu.Lock()
+	h.ctx = ctx
+	h.mu.Unlock()
+	ticker := time.NewTicker(tsEventsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			h.mu.Lock()
+			for id, sub := range h.subs {
+				sub.cancel()
+				delete(h.subs, id)
+			}
+			h.mu.Unlock()
+			return
+		case <-ticker.C:
+			h.flush()
+		}
+	}
+}
+
+// flush sends queued events in batches of tsEventsBatchSize. A failed batch
+// goes back to the front of the queue for the next tick.
+func (h *tsEvents) flush() {
+	for {
+		h.mu.Lock()
+		report := h.report
+		if report == nil || len(h.queue) == 0 {
+			h.mu.Unlock()
+			return
+		}
+		batch := h.queue
+		if len(batch) > tsEventsBatchSize {
+			batch = batch[:tsEventsBatchSize]
+		}
+		h.queue = h.queue[len(batch):]
+		h.mu.Unlock()
+
+		if err := report(batch); err != nil {
+			h.mu.Lock()
+			h.queue = append(append([]map[string]any{}, batch...), h.queue...)
+			h.trimQueue()
+			h.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (h *tsEvents) enqueue(sub *tsEventSubscription, line string) {
+	events := parseTsNotification(line)
+	if len(events) == 0 {
+		return
+	}
+	now := h.now().UTC().Format(time.RFC3339Nano)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		event["subscription_id"] = sub.id
+		event["kind"] = sub.kind
+		event["sid"] = sub.sid
+		event["received_at"] = now
+		h.queue = append(h.queue, event)
+	}
+	h.trimQueue()
+}
+
+// trimQueue drops the oldest events once the panel has been unreachable for
+// long enough to fill the queue; the caller holds h.mu.
+func (h *tsEvents) trimQueue() {
+	if excess := len(h.queue) - tsEventsMaxQueued; excess > 0 {
+		h.queue = h.queue[excess:]
+		h.dropped += excess
+	}
+}
+
+// parseTsNotification turns one notify line into events. Notifications list
+// several entries separated by | when, for example, a whole channel of
+// clients leaves at once.
+func parseTsNotification(line string) []map[string]any {
+	name, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if !strings.HasPrefix(name, "notify") {
+		return nil
+	}
+	eventName, ok := tsNotifyEventNames[name]
+	if !ok {
+		eventName = strings.TrimPrefix(name, "notify")
+	}
+	entries := parseQueryList([]string{rest})
+	if len(entries) == 0 {
+		entries = []map[string]string{{}}
+	}
+	events := make([]map[string]any, 0, len(entries))
+	for _, entry := range entries {
+		events = append(events, map[string]any{"event": eventName, "fields": entry})
+	}
+	return events
+}
+
+// Subscribe registers for notifications of a virtual server. The first
+// session is opened here so bad credentials fail the job; afterwards the
+// session reconnects on its own until Unsubscribe.
+func (h *tsEvents) Subscribe(kind, sid string, events []string, payload map[string]any) (*tsEventSubscription, error) {
+	sub := &tsEventSubscription{
+		id:      tsEventSubscriptionID(kind, sid, payload),
+		kind:    kind,
+		sid:     sid,
+		events:  events,
+		payload: payload,
+		since:   h.now(),
+	}
+	client, err := h.connect(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	parent := h.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	sub.cancel = cancel
+	if previous, ok := h.subs[sub.id]; ok {
+		previous.cancel()
+	}
+	h.subs[sub.id] = sub
+	h.mu.Unlock()
+
+	sub.setState(true, nil)
+	go h.follow(ctx, sub, client)
+	return sub, nil
+}
+
+// Unsubscribe stops the session of a subscription and reports whether one
+// existed.
+func (h *tsEvents) Unsubscribe(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub, ok := h.subs[id]
+	if !ok {
+		return false
+	}
+	sub.cancel()
+	delete(h.subs, id)
+	return true
+}
+
+// tsEventSubscriptionID names a subscription after the query endpoint and
+// virtual server, so subscribing twice replaces the first session.
+func tsEventSubscriptionID(kind, sid string, payload map[string]any) string {
+	key := tsPoolKeyFromTs3Payload(payload)
+	if kind == "ts6" {
+		key = tsPoolKeyFromTs6Payload(payload)
+	}
+	return fmt.Sprintf("%s:%s:%s", kind, key.address, sid)
+}
+
+// Status lists the subscriptions and how many events were dropped because
+// the queue was full. It goes out with every heartbeat: subscriptions live
+// only in memory, so after a restart or self-update the panel sees them
+// missing and subscribes again.
+func (h *tsEvents) Status() map[string]any {
+	h.mu.Lock()
+	subs := make([]*tsEventSubscription, 0, len(h.subs))
+	for _, sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	dropped, queued := h.dropped, len(h.queue)
+	h.mu.Unlock()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].id < subs[j].id })
+	statuses := make([]map[string]any, 0, len(subs))
+	for _, sub := range subs {
+		statuses = append(statuses, sub.status())
+	}
+	return map[string]any{
+		"subscriptions":  statuses,
+		"queued_events":  queued,
+		"dropped_events": dropped,
+	}
+}
+
+func (h *tsEvents) connect(sub *tsEventSubscription) (*ts3QueryClient, error) {
+	client, err := h.dial(sub.kind, sub.payload)
+	if err != nil {
+		return nil, err
+	}
+	// Notifications may already arrive between two register commands.
+	client.notify = func(line string) { h.enqueue(sub, line) }
+	if err := client.useServer(sub.sid); err != nil {
+		client.close()
+		return nil, err
+	}
+	for _, event := range sub.events {
+		if _, err := client.command("servernotifyregister " + tsEventRegistrations[event]); err != nil {
+			client.close()
+			return nil, fmt.Errorf("register %s events: %w", event, err)
+		}
+	}
+	return client, nil
+}
+
+// follow listens on the session and reconnects after tsEventsReconnectDelay
+// when it drops.
+func (h *tsEvents) follow(ctx context.Context, sub *tsEventSubscription, client *ts3QueryClient) {
+	for {
+		err := h.listen(ctx, sub, client)
+		client.close()
+		if ctx.Err() != nil {
+			return
+		}
+		sub.setState(false, err)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(tsEventsReconnectDelay):
+			}
+			if client, err = h.connect(sub); err == nil {
+				break
+			}
+			sub.setState(false, err)
+		}
+		sub.setState(true, nil)
+	}
+}
+
+// listen reads notifications until the session fails or ctx ends. The
+// reader runs in its own goroutine because SSH sessions have no read
+// deadline; keepalive replies arrive on the same stream and are ignored.
+func (h *tsEvents) listen(ctx context.Context, sub *tsEventSubscription, client *ts3QueryClient) error {
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	// done releases a reader blocked on lines once listen returns, e.g. after
+	// a failed keepalive; closing the client then ends its pending read.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			line, err := client.reader.ReadString('\n')
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(tsQueryKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-readErr:
+			return err
+		case line := <-lines:
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "notify") {
+				h.enqueue(sub, line)
+			}
+		case <-keepalive.C:
+			if err := writeTsQueryLine(client.writer, "whoami"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeTsQueryLine(writer *bufio.Writer, cmd string) error {
+	if _, err := writer.WriteString(cmd + "\n"); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func handleTs3VirtualEventsSubscribe(job jobs.Job) orchestratorResult {
+	return handleTsVirtualEventsSubscribe(job, "ts3")
+}
+
+func handleTs6VirtualEventsSubscribe(job jobs.Job) orchestratorResult {
+	return handleTsVirtualEventsSubscribe(job, "ts6")
+}
+
+func handleTsVirtualEventsSubscribe(job jobs.Job, kind string) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	if sid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid"}
+	}
+	events := parseStringList(job.Payload["events"], tsDefaultEventRegistrations)
+	for _, event := range events {
+		if _, ok := tsEventRegistrations[event]; !ok {
+			return orchestratorResult{status: "failed", errorText: fmt.Sprintf("unsupported event: %s", event)}
+		}
+	}
+
+	sub, err := globalTsEvents.Subscribe(kind, sid, events, job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	payload := globalTsEvents.Status()
+	payload["subscription_id"] = sub.id
+	return orchestratorResult{status: "success", resultPayload: payload}
+}
+
+func handleTs3VirtualEventsUnsubscribe(job jobs.Job) orchestratorResult {
+	return handleTsVirtualEventsUnsubscribe(job, "ts3")
+}
+
+func handleTs6VirtualEventsUnsubscribe(job jobs.Job) orchestratorResult {
+	return handleTsVirtualEventsUnsubscribe(job, "ts6")
+}
+
+func handleTsVirtualEventsUnsubscribe(job jobs.Job, kind string) orchestratorResult {
+	id := payloadValue(job.Payload, "subscription_id")
+	if id == "" {
+		sid := payloadValue(job.Payload, "sid")
+		if sid == "" {
+			return orchestratorResult{status: "failed", errorText: "missing subscription_id or sid"}
+		}
+		id = tsEventSubscriptionID(kind, sid, job.Payload)
+	}
+	if !strings.HasPrefix(id, kind+":") {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("subscription %s is not a %s subscription", id, kind)}
+	}
+	removed := globalTsEvents.Unsubscribe(id)
+	payload := globalTsEvents.Status()
+	payload["subscription_id"] = id
+	payload["removed"] = removed
+	return orchestratorResult{status: "success", resultPayload: payload}
+}
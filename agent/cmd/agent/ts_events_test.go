@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTsEventsSubscribeForwardsNotifications(t *testing.T) {
+	agentSide, serverSide := net.Pipe()
+	defer serverSide.Close()
+	commands := make(chan string, 8)
+	go func() {
+		reader := bufio.NewReader(serverSide)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			commands <- line
+			reply := "error id=0 msg=ok\n"
+			if line == "servernotifyregister event=server" {
+				// A join that arrives before the next registration is answered.
+				reply = "notifycliententerview cfid=0 ctid=1 clid=5 client_nickname=Alice\n" + reply
+			}
+			if line == "servernotifyregister event=textprivate" {
+				reply += "notifyclientleftview cfid=1 ctid=0 clid=5|clid=6\n" +
+					"notifytextmessage targetmode=3 msg=hello\\sworld invokername=Bob\n"
+			}
+			if _, err := serverSide.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+
+	batches := make(chan []map[string]any, 1)
+	hub := &tsEvents{
+		subs: map[string]*tsEventSubscription{},
+		now:  time.Now,
+		dial: func(kind string, _ map[string]any) (*ts3QueryClient, error) {
+			if kind != "ts3" {
+				t.Errorf("unexpected kind %q", kind)
+			}
+			return &ts3QueryClient{conn: agentSide, reader: bufio.NewReader(agentSide), writer: bufio.NewWriter(agentSide), commandTimeout: time.Second}, nil
+		},
+		report: func(events []map[string]any) error {
+			batches <- events
+			return nil
+		},
+	}
+	sub, err := hub.Subscribe("ts3", "3", []string{"server", "textprivate"}, map[string]any{"query_port": "10011"})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if sub.id != "ts3:127.0.0.1:10011:3" {
+		t.Fatalf("unexpected subscription id %q", sub.id)
+	}
+	for _, want := range []string{"use sid=3", "servernotifyregister event=server", "servernotifyregister event=textprivate"} {
+		if got := <-commands; got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mu.Lock()
+		queued := len(hub.queue)
+		hub.mu.Unlock()
+		if queued == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	hub.flush()
+	var events []map[string]any
+	select {
+	case events = <-batches:
+	default:
+		t.Fatal("expected a batch to be reported")
+	}
+	var names []string
+	for _, event := range events {
+		names = append(names, event["event"].(string))
+		if event["subscription_id"] != sub.id || event["sid"] != "3" {
+			t.Fatalf("event without subscription data: %#v", event)
+		}
+	}
+	if strings.Join(names, ",") != "client_join,client_leave,client_leave,text_message" {
+		t.Fatalf("unexpected events %v", names)
+	}
+	if fields := events[3]["fields"].(map[string]string); fields["msg"] != "hello world" {
+		t.Fatalf("expected an unescaped message, got %#v", fields)
+	}
+
+	if !hub.Unsubscribe(sub.id) || hub.Unsubscribe(sub.id) {
+		t.Fatal("expected exactly one unsubscribe to remove the subscription")
+	}
+}
+
+func TestTsQueryClientSkipsRepeatedServerSelection(t *testing.T) {
+	conn := newScriptedQueryConn("error id=0 msg=ok\nerror id=0 msg=ok\nerror id=0 msg=ok\nerror id=0 msg=ok\n")
+	client := &ts3QueryClient{conn: conn, reader: newScriptedReader(conn), writer: newScriptedWriter(conn), commandTimeout: time.Second}
+	entry := &tsPoolEntry{factory: func() (*ts3QueryClient, error) { return client, nil }}
+
+	for range 2 {
+		if err := entry.use(func(client *ts3QueryClient) error { return client.useServer("1") }); err != nil {
+			t.Fatalf("use server: %v", err)
+		}
+	}
+	if _, err := client.command("serverstop sid=1"); err != nil {
+		t.Fatalf("serverstop: %v", err)
+	}
+	if err := client.useServer("1"); err != nil {
+		t.Fatalf("use server after stop: %v", err)
+	}
+	// use, serverstop and use again consumed three replies; the second job
+	// reused the selection.
+	if rest, _ := client.reader.ReadString('\n'); rest != "error id=0 msg=ok\n" {
+		t.Fatalf("expected one unread reply, got %q", rest)
+	}
+
+	entry.keepalive(entry.lastUse.Add(tsQueryIdleClose))
+	if entry.client != nil {
+		t.Fatal("expected an idle session to be closed")
+	}
+}
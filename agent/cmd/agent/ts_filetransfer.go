@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	// tsFileJobMaxBytes caps files carried base64 encoded in job payloads and
+	// results; larger files go through the /v1/ts/files/ routes.
+	tsFileJobMaxBytes         = 8 << 20
+	tsFileTransferDialTimeout = 10 * time.Second
+)
+
+// tsClientFTID numbers transfers; the server only needs clientftfid to be
+// unique per query session.
+var tsClientFTID atomic.Uint32
+
+// tsFileTarget is the file or directory a file job works on. Files live in
+// a channel, cid 0 holding icons and avatars; password is the channel
+// password.
+type tsFileTarget struct {
+	sid      string
+	cid      string
+	password string
+	name     string
+	// host is the file transfer host: filetransfer_host, else the host of
+	// the query connection.
+	host         string
+	explicitHost bool
+}
+
+func parseTsFileTarget(job jobs.Job) (tsFileTarget, error) {
+	target := tsFileTarget{
+		sid:      payloadValue(job.Payload, "sid"),
+		cid:      firstNonEmpty(payloadValue(job.Payload, "cid"), "0"),
+		password: payloadValue(job.Payload, "cpw"),
+	}
+	if target.sid == "" {
+		return target, errors.New("missing sid")
+	}
+	if _, err := strconv.Atoi(target.cid); err != nil {
+		return target, errors.New("cid must be numeric")
+	}
+	if name := payloadValue(job.Payload, "name"); name != "" {
+		var err error
+		if target.name, err = normalizeTsFilePath(name); err != nil {
+			return target, err
+		}
+	}
+	if host := payloadValue(job.Payload, "filetransfer_host"); host != "" {
+		target.host, target.explicitHost = host, true
+	} else {
+		key := tsPoolKeyFromTs3Payload(job.Payload)
+		if tsJobKind(job) == "ts6" {
+			key = tsPoolKeyFromTs6Payload(job.Payload)
+		}
+		target.host, _, _ = net.SplitHostPort(key.address)
+	}
+	return target, nil
+}
+
+// normalizeTsFilePath turns name into the absolute, clean path the file
+// transfer commands expect.
+func normalizeTsFilePath(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", errors.New("invalid file name")
+	}
+	return path.Clean("/" + name), nil
+}
+
+func (target tsFileTarget) channelArgs() string {
+	return fmt.Sprintf("cid=%s cpw=%s", target.cid, escapeTs3Query(target.password))
+}
+
+// tsFileTransfer is a transfer the server accepted with ftinitupload or
+// ftinitdownload. The data goes over its own TCP connection to address,
+// which starts with key.
+type tsFileTransfer struct {
+	address string
+	key     string
+	// size is the number of bytes to send or receive.
+	size int64
+}
+
+// parseTsFileTransfer reads an ftinit reply. Refusals such as a missing file
+// come as status and msg next to error id=0. The reply's ip lists where the
+// server listens for transfers and is used unless the job names a host.
+func (target tsFileTarget) parseTsFileTransfer(response map[string]string) (tsFileTransfer, error) {
+	if status := response["status"]; status != "" && status != "0" {
+		return tsFileTransfer{}, &tsQueryError{ID: status, Message: response["msg"]}
+	}
+	if response["ftkey"] == "" || response["port"] == "" {
+		return tsFileTransfer{}, errors.New("server did not return a file transfer key")
+	}
+	host := target.host
+	if first, _, _ := strings.Cut(response["ip"], ","); !target.explicitHost && first != "" && first != "0.0.0.0" && first != "::" {
+		host = first
+	}
+	return tsFileTransfer{address: net.JoinHostPort(host, response["port"]), key: response["ftkey"]}, nil
+}
+
+// open connects to the file transfer port and announces the key. Closing
+// ctx aborts the connection.
+func (transfer tsFileTransfer) open(ctx context.Context) (net.Conn, func(), error) {
+	dialer := net.Dialer{Timeout: tsFileTransferDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", transfer.address)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("connect to file transfer %s: %w", transfer.address, err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	done := func() {
+		stop()
+		_ = conn.Close()
+	}
+	if _, err := io.WriteString(conn, transfer.key); err != nil {
+		done()
+		return nil, func() {}, fmt.Errorf("send file transfer key: %w", err)
+	}
+	return conn, done, nil
+}
+
+func (transfer tsFileTransfer) upload(ctx context.Context, source io.Reader) error {
+	conn, done, err := transfer.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	written, err := io.Copy(conn, io.LimitReader(source, transfer.size))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if written != transfer.size {
+		return fmt.Errorf("upload ended after %d of %d bytes", written, transfer.size)
+	}
+	return nil
+}
+
+func (transfer tsFileTransfer) download(ctx context.Context, target io.Writer) error {
+	conn, done, err := transfer.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, err := io.CopyN(target, conn, transfer.size); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("download: %w", err)
+	}
+	return nil
+}
+
+// initTsFileUpload and initTsFileDownload negotiate a transfer on the
+// pooled query connection. The data itself is moved after the connection
+// is released, so a long transfer does not hold up other jobs; the server
+// drops transfers nobody connects to.
+func initTsFileUpload(job jobs.Job, target tsFileTarget, size int64, overwrite bool) (tsFileTransfer, error) {
+	var transfer tsFileTransfer
+	err := withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(target.sid); err != nil {
+			return err
+		}
+		response, err := client.command(fmt.Sprintf("ftinitupload clientftfid=%d name=%s %s size=%d overwrite=%d resume=0",
+			tsClientFTID.Add(1), escapeTs3Query(target.name), target.channelArgs(), size, boolToInt(overwrite)))
+		if err != nil {
+			return err
+		}
+		transfer, err = target.parseTsFileTransfer(response)
+		transfer.size = size
+		return err
+	})
+	return transfer, err
+}
+
+func initTsFileDownload(job jobs.Job, target tsFileTarget) (tsFileTransfer, error) {
+	var transfer tsFileTransfer
+	err := withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(target.sid); err != nil {
+			return err
+		}
+		response, err := client.command(fmt.Sprintf("ftinitdownload clientftfid=%d name=%s %s seekpos=0",
+			tsClientFTID.Add(1), escapeTs3Query(target.name), target.channelArgs()))
+		if err != nil {
+			return err
+		}
+		if transfer, err = target.parseTsFileTransfer(response); err != nil {
+			return err
+		}
+		if transfer.size, err = strconv.ParseInt(response["size"], 10, 64); err != nil || transfer.size < 0 {
+			return fmt.Errorf("invalid file size %q", response["size"])
+		}
+		return nil
+	})
+	return transfer, err
+}
+
+func handleTsFileList(job jobs.Job) orchestratorResult {
+	target, err := parseTsFileTarget(job)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	dir := firstNonEmpty(target.name, "/")
+	var lines []string
+	err = withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(target.sid); err != nil {
+			return err
+		}
+		var err error
+		lines, err = client.commandLines(fmt.Sprintf("ftgetfilelist %s path=%s", target.channelArgs(), escapeTs3Query(dir)))
+		return err
+	})
+	if err != nil && !isTsQueryEmptyResultError(err) {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	files := parseQueryList(lines)
+	for _, file := range files {
+		// type 0 is a directory, 1 a file.
+		file["is_dir"] = strconv.FormatBool(file["type"] == "0")
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": target.cid, "path": dir, "files": files}}
+}
+
+func handleTsFileUpload(ctx context.Context, job jobs.Job) orchestratorResult {
+	target, err := parseTsFileTarget(job)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if target.name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing name"}
+	}
+	content, err := base64.StdEncoding.DecodeString(payloadValue(job.Payload, "content_base64"))
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: "content_base64 is not valid base64"}
+	}
+	if len(content) > tsFileJobMaxBytes {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("file is larger than %d bytes; upload it through /v1/ts/files/upload", tsFileJobMaxBytes)}
+	}
+	overwrite := parsePayloadBool(payloadValue(job.Payload, "overwrite"), true)
+	transfer, err := initTsFileUpload(job, target, int64(len(content)), overwrite)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if err := transfer.upload(ctx, bytes.NewReader(content)); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": target.cid, "name": target.name, "size": len(content)}}
+}
+
+func handleTsFileDownload(ctx context.Context, job jobs.Job) orchestratorResult {
+	target, err := parseTsFileTarget(job)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if target.name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing name"}
+	}
+	transfer, err := initTsFileDownload(job, target)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if transfer.size > tsFileJobMaxBytes {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("file is larger than %d bytes; download it through /v1/ts/files/download", tsFileJobMaxBytes)}
+	}
+	var content bytes.Buffer
+	if err := transfer.download(ctx, &content); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{
+		"cid":            target.cid,
+		"name":           target.name,
+		"size":           content.Len(),
+		"content_base64": base64.StdEncoding.EncodeToString(content.Bytes()),
+	}}
+}
+
+func handleTsFileDelete(job jobs.Job) orchestratorResult {
+	target, err := parseTsFileTarget(job)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	// A single name may contain commas, so only names is split.
+	raw := []string{target.name}
+	if _, ok := job.Payload["names"]; ok {
+		raw = parseStringList(job.Payload["names"], "")
+	}
+	names := make([]string, 0, len(raw))
+	args := make([]string, 0, len(raw))
+	for _, name := range raw {
+		if name == "" {
+			continue
+		}
+		name, err := normalizeTsFilePath(name)
+		if err != nil {
+			return orchestratorResult{status: "failed", errorText: err.Error()}
+		}
+		names = append(names, name)
+		args = append(args, "name="+escapeTs3Query(name))
+	}
+	if len(names) == 0 {
+		return orchestratorResult{status: "failed", errorText: "missing name or names"}
+	}
+	command := fmt.Sprintf("ftdeletefile %s %s", target.channelArgs(), strings.Join(args, "|"))
+	if _, err := runTsServerCommands(job, target.sid, []string{command}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"cid": target.cid, "deleted": names}}
+}
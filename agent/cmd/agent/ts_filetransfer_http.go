@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const tsFileHTTPRequestMaxBytes = 1 << 20
+
+// handleTsFilesHTTP serves /v1/ts/files/{list,download,upload,delete}. A
+// request carries the payload of the matching ts3/ts6.virtual.file.* job
+// plus its kind; download and upload stream the file instead of going
+// through base64, so they have no size cap. Upload bodies are multipart
+// with a "request" part followed by the "file" part.
+func handleTsFilesHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "method not allowed")
+		return
+	}
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/ts/files/"), "/")
+	switch action {
+	case "list", "delete", "download":
+		var payload map[string]any
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, tsFileHTTPRequestMaxBytes)).Decode(&payload); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "invalid file request payload")
+			return
+		}
+		job, ok := tsFileHTTPJob(w, action, payload)
+		if !ok {
+			return
+		}
+		switch action {
+		case "list":
+			writeTsFileHTTPResult(w, handleTsFileList(job))
+		case "delete":
+			writeTsFileHTTPResult(w, handleTsFileDelete(job))
+		default:
+			serveTsFileDownload(w, r, job)
+		}
+	case "upload":
+		serveTsFileUpload(w, r)
+	default:
+		writeJSONError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	}
+}
+
+// tsFileHTTPJob builds the job a file request stands for and checks it
+// against the job's payload schema.
+func tsFileHTTPJob(w http.ResponseWriter, action string, payload map[string]any) (jobs.Job, bool) {
+	kind := payloadValue(payload, "kind")
+	if kind != "ts3" && kind != "ts6" {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "kind must be ts3 or ts6")
+		return jobs.Job{}, false
+	}
+	job, violations := globalJobSchemas.prepareJob(jobs.Job{Type: kind + ".virtual.file." + action, Payload: payload})
+	if len(violations) > 0 {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", violations[0].Message)
+		return jobs.Job{}, false
+	}
+	return job, true
+}
+
+func writeTsFileHTTPResult(w http.ResponseWriter, result orchestratorResult) {
+	if result.status != "success" {
+		writeJSONError(w, http.StatusBadGateway, "TS_FILE_TRANSFER_FAILED", result.errorText)
+		return
+	}
+	response := map[string]any{"ok": true}
+	for key, value := range result.resultPayload {
+		response[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func serveTsFileDownload(w http.ResponseWriter, r *http.Request, job jobs.Job) {
+	target, err := parseTsFileTarget(job)
+	if err == nil && target.name == "" {
+		err = fmt.Errorf("missing name")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", err.Error())
+		return
+	}
+	transfer, err := initTsFileDownload(job, target)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "TS_FILE_TRANSFER_FAILED", err.Error())
+		return
+	}
+	// Large files outlast the server's write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	filename := path.Base(target.name)
+	contentType := mime.TypeByExtension(path.Ext(filename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(transfer.size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", strings.ReplaceAll(filename, "\"", "")))
+	// Once the headers are out an error can only cut the body short, which
+	// the client sees against Content-Length.
+	_ = transfer.download(r.Context(), w)
+}
+
+// serveTsFileUpload streams the file part straight into the file transfer
+// connection. The request part must name the size because ftinitupload
+// needs it before any data is sent.
+func serveTsFileUpload(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "upload must be multipart/form-data")
+		return
+	}
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != "request" {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "the request part must come before the file")
+		return
+	}
+	var payload map[string]any
+	if err := json.NewDecoder(io.LimitReader(part, tsFileHTTPRequestMaxBytes)).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "invalid file request payload")
+		return
+	}
+	job, ok := tsFileHTTPJob(w, "upload", payload)
+	if !ok {
+		return
+	}
+	target, err := parseTsFileTarget(job)
+	if err == nil && target.name == "" {
+		err = fmt.Errorf("missing name")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", err.Error())
+		return
+	}
+	size, err := strconv.ParseInt(payloadValue(payload, "size"), 10, 64)
+	if err != nil || size < 0 {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "size is required")
+		return
+	}
+	file, err := reader.NextPart()
+	if err != nil || file.FormName() != "file" {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAYLOAD", "missing file part")
+		return
+	}
+
+	transfer, err := initTsFileUpload(job, target, size, parsePayloadBool(payloadValue(payload, "overwrite"), true))
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "TS_FILE_TRANSFER_FAILED", err.Error())
+		return
+	}
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+	if err := transfer.upload(r.Context(), file); err != nil {
+		writeJSONError(w, http.StatusBadGateway, "TS_FILE_TRANSFER_FAILED", err.Error())
+		return
+	}
+	writeTsFileHTTPResult(w, orchestratorResult{status: "success", resultPayload: map[string]any{"cid": target.cid, "name": target.name, "size": size}})
+}
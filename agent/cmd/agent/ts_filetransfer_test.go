@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+// fakeTsFileServer answers the file transfer commands on a pooled query
+// connection and serves the transfer port. Uploads are stored under their
+// key; downloads send the content the server was created with.
+type fakeTsFileServer struct {
+	port     int
+	mu       sync.Mutex
+	commands []string
+	uploaded map[string][]byte
+	payload  map[string]any
+}
+
+func newFakeTsFileServer(t *testing.T, queryPort string, download []byte) *fakeTsFileServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	server := &fakeTsFileServer{port: listener.Addr().(*net.TCPAddr).Port, uploaded: map[string][]byte{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				key := make([]byte, 8)
+				if _, err := io.ReadFull(conn, key); err != nil {
+					return
+				}
+				if string(key) == "dnload01" {
+					_, _ = conn.Write(download)
+					return
+				}
+				data, _ := io.ReadAll(conn)
+				server.mu.Lock()
+				server.uploaded[string(key)] = data
+				server.mu.Unlock()
+			}()
+		}
+	}()
+
+	agentSide, serverSide := net.Pipe()
+	t.Cleanup(func() { _ = serverSide.Close() })
+	go func() {
+		reader := bufio.NewReader(serverSide)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			server.mu.Lock()
+			server.commands = append(server.commands, line)
+			server.mu.Unlock()
+			reply := ""
+			switch {
+			case strings.HasPrefix(line, "ftinitupload "):
+				reply = fmt.Sprintf("clientftfid=1 serverftfid=1 ftkey=upload01 port=%d seekpos=0 ip=0.0.0.0,::\n", server.port)
+			case strings.Contains(line, `name=\/missing`):
+				reply = "clientftfid=2 status=2051 msg=invalid\\sfile\\spath\n"
+			case strings.HasPrefix(line, "ftinitdownload "):
+				reply = fmt.Sprintf("clientftfid=2 serverftfid=2 ftkey=dnload01 port=%d size=%d\n", server.port, len(download))
+			case strings.HasPrefix(line, "ftgetfilelist "):
+				reply = "cid=2 path=\\/ name=rules.txt size=7 datetime=1 type=1|cid=2 path=\\/ name=maps size=0 datetime=1 type=0\n"
+			}
+			if _, err := serverSide.Write([]byte(reply + "error id=0 msg=ok\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	server.payload = map[string]any{"query_ip": "127.0.0.1", "query_port": queryPort, "sid": "1", "cid": "2"}
+	key := tsPoolKeyFromTs3Payload(server.payload)
+	globalTs3Pool.entry(key, func() (*ts3QueryClient, error) {
+		return &ts3QueryClient{conn: agentSide, reader: bufio.NewReader(agentSide), writer: bufio.NewWriter(agentSide), commandTimeout: time.Second}, nil
+	})
+	t.Cleanup(func() {
+		globalTs3Pool.mu.Lock()
+		delete(globalTs3Pool.entries, key)
+		globalTs3Pool.mu.Unlock()
+	})
+	return server
+}
+
+// upload waits for the transfer connection of key to finish.
+func (server *fakeTsFileServer) upload(key string) string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		server.mu.Lock()
+		data, ok := server.uploaded[key]
+		server.mu.Unlock()
+		if ok || time.Now().After(deadline) {
+			return string(data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (server *fakeTsFileServer) jobPayload(values map[string]any) map[string]any {
+	payload := map[string]any{}
+	for key, value := range server.payload {
+		payload[key] = value
+	}
+	for key, value := range values {
+		payload[key] = value
+	}
+	return payload
+}
+
+func TestTsFileJobsUseSeparateTransferConnection(t *testing.T) {
+	server := newFakeTsFileServer(t, "19101", []byte("be nice"))
+
+	upload := jobs.Job{Type: "ts3.virtual.file.upload", Payload: server.jobPayload(map[string]any{"name": "docs/../rules.txt", "cpw": "se cret", "content_base64": base64.StdEncoding.EncodeToString([]byte("hello world"))})}
+	if result := handleTsFileUpload(context.Background(), upload); result.status != "success" || result.resultPayload["name"] != "/rules.txt" {
+		t.Fatalf("upload failed: %#v", result)
+	}
+	download := handleTsFileDownload(context.Background(), jobs.Job{Type: "ts3.virtual.file.download", Payload: server.jobPayload(map[string]any{"name": "/rules.txt"})})
+	if download.status != "success" || download.resultPayload["content_base64"] != base64.StdEncoding.EncodeToString([]byte("be nice")) {
+		t.Fatalf("download failed: %#v", download)
+	}
+	missing := handleTsFileDownload(context.Background(), jobs.Job{Type: "ts3.virtual.file.download", Payload: server.jobPayload(map[string]any{"name": "/missing"})})
+	if missing.status != "failed" || !strings.Contains(missing.errorText, "invalid file path") {
+		t.Fatalf("expected the refused download to fail, got %#v", missing)
+	}
+	list := handleTsFileList(jobs.Job{Type: "ts3.virtual.file.list", Payload: server.jobPayload(nil)})
+	files, _ := list.resultPayload["files"].([]map[string]string)
+	if list.status != "success" || len(files) != 2 || files[1]["is_dir"] != "true" {
+		t.Fatalf("unexpected file list %#v", list)
+	}
+	deleted := handleTsFileDelete(jobs.Job{Type: "ts3.virtual.file.delete", Payload: server.jobPayload(map[string]any{"names": []any{"/a b.txt", "maps"}})})
+	if deleted.status != "success" {
+		t.Fatalf("delete failed: %#v", deleted)
+	}
+
+	if got := server.upload("upload01"); got != "hello world" {
+		t.Fatalf("unexpected uploaded content %q", got)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	want := []string{
+		"use sid=1",
+		`ftinitupload clientftfid=%d name=\/rules.txt cid=2 cpw=se\scret size=11 overwrite=1 resume=0`,
+		`ftinitdownload clientftfid=%d name=\/rules.txt cid=2 cpw= seekpos=0`,
+		`ftinitdownload clientftfid=%d name=\/missing cid=2 cpw= seekpos=0`,
+		`ftgetfilelist cid=2 cpw= path=\/`,
+		`ftdeletefile cid=2 cpw= name=\/a\sb.txt|name=\/maps`,
+	}
+	if len(server.commands) != len(want) {
+		t.Fatalf("unexpected commands %v", server.commands)
+	}
+	for idx, command := range server.commands {
+		var id int
+		if strings.Contains(want[idx], "%d") {
+			if _, err := fmt.Sscanf(strings.Fields(command)[1], "clientftfid=%d", &id); err != nil {
+				t.Fatalf("command without clientftfid: %q", command)
+			}
+		}
+		expected := want[idx]
+		if id != 0 {
+			expected = fmt.Sprintf(want[idx], id)
+		}
+		if command != expected {
+			t.Fatalf("expected %q, got %q", expected, command)
+		}
+	}
+}
+
+func TestTsFilesHTTPStreamsUploadsAndDownloads(t *testing.T) {
+	server := newFakeTsFileServer(t, "19102", []byte("streamed download"))
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	request, _ := json.Marshal(server.jobPayload(map[string]any{"kind": "ts3", "name": "/big.bin", "size": 12}))
+	_ = form.WriteField("request", string(request))
+	file, _ := form.CreateFormFile("file", "big.bin")
+	_, _ = file.Write([]byte("streamed up!"))
+	_ = form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/v1/ts/files/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handleTsFilesHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	request, _ = json.Marshal(server.jobPayload(map[string]any{"kind": "ts3", "name": "/big.bin"}))
+	rec = httptest.NewRecorder()
+	handleTsFilesHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/ts/files/download", bytes.NewReader(request)))
+	if rec.Code != http.StatusOK || rec.Body.String() != "streamed download" || rec.Header().Get("Content-Length") != "17" {
+		t.Fatalf("unexpected download %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	if uploaded := server.upload("upload01"); uploaded != "streamed up!" {
+		t.Fatalf("unexpected uploaded content %q", uploaded)
+	}
+
+	for _, invalid := range []map[string]any{
+		{"kind": "ts4", "sid": "1", "name": "/x"},
+		{"kind": "ts3", "name": "/x"},
+	} {
+		request, _ = json.Marshal(invalid)
+		rec = httptest.NewRecorder()
+		handleTsFilesHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/ts/files/download", bytes.NewReader(request)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %v to be rejected, got %d", invalid, rec.Code)
+		}
+	}
+}
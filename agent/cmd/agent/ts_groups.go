@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"easywi/agent/internal/jobs"
+)
+
+// tsQueryBatchSize caps the entries joined with | into one command so a large
+// permission edit does not exceed the ServerQuery line limit.
+const tsQueryBatchSize = 50
+
+var tsPermissionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// tsPermissionEdit is one entry of a batch permission edit. Name is the
+// permsid (i_client_talk_power); ID is the numeric permid for callers that
+// only know that.
+type tsPermissionEdit struct {
+	Name    string
+	ID      string
+	Value   int
+	Negated bool
+	Skip    bool
+	Remove  bool
+}
+
+func (edit tsPermissionEdit) identifier() string {
+	if edit.Name != "" {
+		return "permsid=" + edit.Name
+	}
+	return "permid=" + edit.ID
+}
+
+// withTsClientForJob picks the TS3 or TS6 pool from the job type prefix.
+func withTsClientForJob(job jobs.Job) func(map[string]any, func(*ts3QueryClient) error) error {
+	if strings.HasPrefix(job.Type, "ts6.") {
+		return withTs6Client
+	}
+	return withTs3Client
+}
+
+// parseTsPermissionEdits reads the permissions list of a job, given either as
+// a JSON array or as a JSON-encoded string.
+func parseTsPermissionEdits(raw string) ([]tsPermissionEdit, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, errors.New("missing permissions")
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, fmt.Errorf("permissions must be a list: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("missing permissions")
+	}
+	edits := make([]tsPermissionEdit, 0, len(entries))
+	for idx, entry := range entries {
+		edit := tsPermissionEdit{
+			Name:    strings.ToLower(payloadValue(entry, "permsid", "perm", "name")),
+			ID:      payloadValue(entry, "permid"),
+			Negated: parsePayloadBool(payloadValue(entry, "negated", "permnegated"), false),
+			Skip:    parsePayloadBool(payloadValue(entry, "skip", "permskip"), false),
+			Remove:  parsePayloadBool(payloadValue(entry, "remove"), false),
+		}
+		switch strings.ToLower(payloadValue(entry, "action")) {
+		case "", "add", "set":
+		case "remove", "delete":
+			edit.Remove = true
+		default:
+			return nil, fmt.Errorf("permissions[%d]: unsupported action %q", idx, payloadValue(entry, "action"))
+		}
+		switch {
+		case edit.Name != "":
+			if !tsPermissionNamePattern.MatchString(edit.Name) {
+				return nil, fmt.Errorf("permissions[%d]: invalid permission name %q", idx, edit.Name)
+			}
+		case edit.ID != "":
+			if _, err := strconv.Atoi(edit.ID); err != nil {
+				return nil, fmt.Errorf("permissions[%d]: invalid permid %q", idx, edit.ID)
+			}
+		default:
+			return nil, fmt.Errorf("permissions[%d]: missing permsid or permid", idx)
+		}
+		if !edit.Remove {
+			value := payloadValue(entry, "value", "permvalue")
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("permissions[%d]: invalid value %q", idx, value)
+			}
+			edit.Value = parsed
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
+}
+
+// tsPermissionCommands turns edits into batched add and delete commands.
+// Group permissions carry the negated and skip flags; channel permissions
+// have neither.
+func tsPermissionCommands(addCommand, delCommand, target string, edits []tsPermissionEdit, withFlags bool) []string {
+	var adds, removes []string
+	for _, edit := range edits {
+		if edit.Remove {
+			removes = append(removes, edit.identifier())
+			continue
+		}
+		entry := fmt.Sprintf("%s permvalue=%d", edit.identifier(), edit.Value)
+		if withFlags {
+			entry += fmt.Sprintf(" permnegated=%d permskip=%d", boolToInt(edit.Negated), boolToInt(edit.Skip))
+		}
+		adds = append(adds, entry)
+	}
+	commands := tsBatchCommands(addCommand+" "+target, adds)
+	return append(commands, tsBatchCommands(delCommand+" "+target, removes)...)
+}
+
+func tsBatchCommands(prefix string, entries []string) []string {
+	var commands []string
+	for start := 0; start < len(entries); start += tsQueryBatchSize {
+		end := min(start+tsQueryBatchSize, len(entries))
+		commands = append(commands, prefix+" "+strings.Join(entries[start:end], "|"))
+	}
+	return commands
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// parseTsIDList reads one or more numeric IDs given as a number, a
+// comma-separated string or a JSON array.
+func parseTsIDList(raw, key string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	var values []string
+	if strings.HasPrefix(raw, "[") {
+		var list []any
+		if err := json.Unmarshal([]byte(raw), &list); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		for _, item := range list {
+			values = append(values, payloadString(item))
+		}
+	} else {
+		values = strings.Split(raw, ",")
+	}
+	ids := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if id, err := strconv.Atoi(value); err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid %s %q", key, value)
+		}
+		ids = append(ids, value)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("missing %s", key)
+	}
+	return ids, nil
+}
+
+// runTsServerCommands selects the virtual server and runs commands in order.
+func runTsServerCommands(job jobs.Job, sid string, commands []string) (map[string]string, error) {
+	var last map[string]string
+	err := withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(sid); err != nil {
+			return err
+		}
+		for _, command := range commands {
+			response, err := client.command(command)
+			if err != nil {
+				return err
+			}
+			last = response
+		}
+		return nil
+	})
+	return last, err
+}
+
+func tsServerGroupType(payload map[string]any) (string, error) {
+	groupType := payloadValue(payload, "type")
+	switch groupType {
+	case "":
+		return "1", nil
+	case "0", "1", "2":
+		return groupType, nil
+	default:
+		return "", fmt.Errorf("invalid group type %q", groupType)
+	}
+}
+
+func handleTsServerGroupCreate(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	name := strings.TrimSpace(payloadValue(job.Payload, "name"))
+	if sid == "" || name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or name"}
+	}
+	groupType, err := tsServerGroupType(job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	response, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("servergroupadd name=%s type=%s", escapeTs3Query(name), groupType)})
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if response["sgid"] == "" {
+		return orchestratorResult{status: "failed", errorText: "servergroupadd did not return sgid"}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"sgid": response["sgid"], "name": name}}
+}
+
+// handleTsServerGroupCopy copies a group with its permissions, either into a
+// new group (name required) or over an existing target_sgid.
+func handleTsServerGroupCopy(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	source := payloadValue(job.Payload, "source_sgid")
+	target := payloadValue(job.Payload, "target_sgid")
+	name := strings.TrimSpace(payloadValue(job.Payload, "name"))
+	if sid == "" || source == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or source_sgid"}
+	}
+	if target == "" {
+		target = "0"
+	}
+	if target == "0" && name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing name for the new group"}
+	}
+	groupType, err := tsServerGroupType(job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if name == "" {
+		// Overwriting keeps the target's name; the query still wants one.
+		name = "copy"
+	}
+	response, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("servergroupcopy ssgid=%s tsgid=%s name=%s type=%s", source, target, escapeTs3Query(name), groupType)})
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	sgid := response["sgid"]
+	if target != "0" {
+		sgid = target
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"sgid": sgid, "source_sgid": source}}
+}
+
+func handleTsServerGroupRename(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	sgid := payloadValue(job.Payload, "sgid")
+	name := strings.TrimSpace(payloadValue(job.Payload, "name"))
+	if sid == "" || sgid == "" || name == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid, sgid or name"}
+	}
+	if _, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("servergrouprename sgid=%s name=%s", sgid, escapeTs3Query(name))}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"sgid": sgid, "name": name}}
+}
+
+// handleTsServerGroupDelete refuses to delete a group that still has members
+// unless force is set, like the TS client does.
+func handleTsServerGroupDelete(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	sgid := payloadValue(job.Payload, "sgid")
+	if sid == "" || sgid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or sgid"}
+	}
+	force := boolToInt(parsePayloadBool(payloadValue(job.Payload, "force"), false))
+	if _, err := runTsServerCommands(job, sid, []string{fmt.Sprintf("servergroupdel sgid=%s force=%d", sgid, force)}); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"sgid": sgid, "deleted": true}}
+}
+
+func handleTsServerGroupClientAdd(job jobs.Job) orchestratorResult {
+	return handleTsServerGroupClients(job, "servergroupaddclient", "added")
+}
+
+func handleTsServerGroupClientRemove(job jobs.Job) orchestratorResult {
+	return handleTsServerGroupClients(job, "servergroupdelclient", "removed")
+}
+
+func handleTsServerGroupClients(job jobs.Job, command, resultKey string) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	sgid := payloadValue(job.Payload, "sgid")
+	if sid == "" || sgid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or sgid"}
+	}
+	cldbids, err := parseTsIDList(payloadValue(job.Payload, "cldbid"), "cldbid")
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	entries := make([]string, 0, len(cldbids))
+	for _, cldbid := range cldbids {
+		entries = append(entries, "cldbid="+cldbid)
+	}
+	if _, err := runTsServerCommands(job, sid, tsBatchCommands(fmt.Sprintf("%s sgid=%s", command, sgid), entries)); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"sgid": sgid, resultKey: cldbids}}
+}
+
+func handleTsServerGroupPermEdit(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	sgid := payloadValue(job.Payload, "sgid")
+	if sid == "" || sgid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or sgid"}
+	}
+	return handleTsPermissionEdit(job, sid, tsPermissionTarget{key: "sgid", id: sgid, add: "servergroupaddperm", del: "servergroupdelperm", flags: true})
+}
+
+func handleTsChannelPermEdit(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	cid := payloadValue(job.Payload, "cid")
+	if sid == "" || cid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid or cid"}
+	}
+	return handleTsPermissionEdit(job, sid, tsPermissionTarget{key: "cid", id: cid, add: "channeladdperm", del: "channeldelperm"})
+}
+
+type tsPermissionTarget struct {
+	key   string
+	id    string
+	add   string
+	del   string
+	flags bool
+}
+
+func handleTsPermissionEdit(job jobs.Job, sid string, target tsPermissionTarget) orchestratorResult {
+	if _, err := strconv.Atoi(target.id); err != nil {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("invalid %s", target.key)}
+	}
+	edits, err := parseTsPermissionEdits(payloadValue(job.Payload, "permissions"))
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	commands := tsPermissionCommands(target.add, target.del, target.key+"="+target.id, edits, target.flags)
+	if _, err := runTsServerCommands(job, sid, commands); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	removed := 0
+	for _, edit := range edits {
+		if edit.Remove {
+			removed++
+		}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{
+		target.key: target.id,
+		"set":      len(edits) - removed,
+		"removed":  removed,
+	}}
+}
+
+// handleTsPrivilegeKeyCreate creates a privilege key for a server group, or
+// for a channel group in one channel when channel_group_id and cid are given.
+func handleTsPrivilegeKeyCreate(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	if sid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid"}
+	}
+	tokenType, id1, id2 := "0", payloadValue(job.Payload, "sgid"), "0"
+	if cgid := payloadValue(job.Payload, "channel_group_id"); cgid != "" {
+		tokenType, id1, id2 = "1", cgid, payloadValue(job.Payload, "cid")
+		if id2 == "" {
+			return orchestratorResult{status: "failed", errorText: "missing cid for a channel group key"}
+		}
+	}
+	if id1 == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sgid or channel_group_id"}
+	}
+	args := fmt.Sprintf("tokentype=%s tokenid1=%s tokenid2=%s", tokenType, id1, id2)
+	if description := strings.TrimSpace(payloadValue(job.Payload, "description")); description != "" {
+		args += " tokendescription=" + escapeTs3Query(description)
+	}
+
+	var response map[string]string
+	err := withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(sid); err != nil {
+			return err
+		}
+		var err error
+		response, err = client.command("privilegekeyadd " + args)
+		var queryErr *tsQueryError
+		if errors.As(err, &queryErr) && queryErr.ID == "256" {
+			// Servers before 3.0.13 only know the old name.
+			response, err = client.command("tokenadd " + args)
+		}
+		return err
+	})
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	token := response["token"]
+	if token == "" {
+		return orchestratorResult{status: "failed", errorText: "privilegekeyadd did not return a token"}
+	}
+	scope := fmt.Sprintf("server_group:%s", id1)
+	if tokenType == "1" {
+		scope = fmt.Sprintf("channel_group:%s:%s", id1, id2)
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"token": token, "token_type": scope}}
+}
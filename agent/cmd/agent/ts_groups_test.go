@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestTsPermissionEditsBuildBatchedCommands(t *testing.T) {
+	edits, err := parseTsPermissionEdits(`[
+		{"permsid": "i_client_talk_power", "value": 50, "skip": true},
+		{"permid": "8470", "value": "-1", "negated": "1"},
+		{"perm": "b_client_ignore_antiflood", "action": "remove"}
+	]`)
+	if err != nil {
+		t.Fatalf("parse edits: %v", err)
+	}
+	commands := tsPermissionCommands("servergroupaddperm", "servergroupdelperm", "sgid=7", edits, true)
+	want := []string{
+		"servergroupaddperm sgid=7 permsid=i_client_talk_power permvalue=50 permnegated=0 permskip=1|permid=8470 permvalue=-1 permnegated=1 permskip=0",
+		"servergroupdelperm sgid=7 permsid=b_client_ignore_antiflood",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(commands, "\n"))
+	}
+
+	channel := tsPermissionCommands("channeladdperm", "channeldelperm", "cid=3", edits[:1], false)
+	if len(channel) != 1 || channel[0] != "channeladdperm cid=3 permsid=i_client_talk_power permvalue=50" {
+		t.Fatalf("unexpected channel commands %v", channel)
+	}
+
+	many := make([]string, 0, tsQueryBatchSize+1)
+	for idx := range tsQueryBatchSize + 1 {
+		many = append(many, fmt.Sprintf(`{"permid": "%d", "value": 1}`, idx+1))
+	}
+	edits, err = parseTsPermissionEdits("[" + strings.Join(many, ",") + "]")
+	if err != nil {
+		t.Fatalf("parse many edits: %v", err)
+	}
+	if commands := tsPermissionCommands("channeladdperm", "channeldelperm", "cid=3", edits, false); len(commands) != 2 {
+		t.Fatalf("expected the edit to be split into two batches, got %d", len(commands))
+	}
+
+	for _, invalid := range []string{
+		`[{"permsid": "i_client talk", "value": 1}]`,
+		`[{"permsid": "i_client_talk_power"}]`,
+		`[{"value": 1}]`,
+		`{"permsid": "i_client_talk_power"}`,
+	} {
+		if _, err := parseTsPermissionEdits(invalid); err == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestTsServerGroupJobsValidateIDs(t *testing.T) {
+	ids, err := parseTsIDList("[4, \"9\"]", "cldbid")
+	if err != nil || strings.Join(ids, ",") != "4,9" {
+		t.Fatalf("unexpected ids %v (%v)", ids, err)
+	}
+	if _, err := parseTsIDList("4,x", "cldbid"); err == nil {
+		t.Fatal("expected a non-numeric id to be rejected")
+	}
+
+	for _, job := range []jobs.Job{
+		{Type: "ts3.virtual.privilegekey.create", Payload: map[string]any{"sid": "1", "channel_group_id": "5"}},
+		{Type: "ts6.virtual.servergroup.copy", Payload: map[string]any{"sid": "1", "source_sgid": "6"}},
+		{Type: "ts6.virtual.servergroup.perm.edit", Payload: map[string]any{"sid": "1", "sgid": "6 permsid=x"}},
+	} {
+		if violations := globalJobSchemas.validateJobPayload(job); len(violations) == 0 {
+			t.Errorf("expected %s with %v to be rejected", job.Type, job.Payload)
+		}
+	}
+	valid := jobs.Job{Type: "ts3.virtual.servergroup.client.add", Payload: map[string]any{"sid": "1", "sgid": "6", "cldbid": []any{4, 9}}}
+	if violations := globalJobSchemas.validateJobPayload(valid); len(violations) != 0 {
+		t.Fatalf("unexpected violations %v", violations)
+	}
+}
@@ -0,0 +1,773 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	defaultTsMigrationDir   = "/var/lib/easywi/ts-migrations"
+	tsMigrationVersion      = 1
+	tsMigrationKeyMinLength = 32
+	tsSnapshotTimeout       = 120 * time.Second
+)
+
+var tsMigrationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// tsMigrationManifest describes a migration bundle. The bundle is a tar.gz
+// with manifest.json, snapshot.txt and the server's files tree under files/;
+// its HMAC is handed to the panel, which passes it on to the import.
+type tsMigrationManifest struct {
+	Version          int       `json:"version"`
+	MigrationID      string    `json:"migration_id"`
+	Kind             string    `json:"kind"`
+	SourceSID        string    `json:"source_sid"`
+	Name             string    `json:"name"`
+	VoicePort        string    `json:"voice_port"`
+	MaxClients       string    `json:"max_clients,omitempty"`
+	ChannelCount     int       `json:"channel_count"`
+	ServerGroupCount int       `json:"server_group_count"`
+	FileCount        int       `json:"file_count"`
+	FileBytes        int64     `json:"file_bytes"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// tsMigrationRecord is what the source node remembers about an export, so a
+// decommission can only remove the server that was actually exported.
+type tsMigrationRecord struct {
+	MigrationID string    `json:"migration_id"`
+	Kind        string    `json:"kind"`
+	SID         string    `json:"sid"`
+	FilesDir    string    `json:"files_dir,omitempty"`
+	BundlePath  string    `json:"bundle_path,omitempty"`
+	BundleRef   string    `json:"bundle_ref,omitempty"`
+	Signature   string    `json:"signature"`
+	ExportedAt  time.Time `json:"exported_at"`
+}
+
+// tsMigrationCheck is one comparison of the imported server against the
+// manifest.
+type tsMigrationCheck struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	OK       bool   `json:"ok"`
+}
+
+func tsMigrationDir() string {
+	if custom := strings.TrimSpace(os.Getenv("EASYWI_TS_MIGRATION_DIR")); custom != "" {
+		return custom
+	}
+	return defaultTsMigrationDir
+}
+
+func tsJobKind(job jobs.Job) string {
+	kind, _, _ := strings.Cut(job.Type, ".")
+	return kind
+}
+
+func tsVirtualServerFilesDir(installDir, sid string) string {
+	return filepath.Join(installDir, "files", "virtualserver_"+sid)
+}
+
+// parseTsMigrationJob reads the migration id and, for jobs that sign or
+// verify a bundle, the signing key.
+func parseTsMigrationJob(job jobs.Job, needsKey bool) (string, string, error) {
+	migrationID := payloadValue(job.Payload, "migration_id")
+	if !tsMigrationIDPattern.MatchString(migrationID) {
+		return "", "", errors.New("invalid migration_id")
+	}
+	key := payloadValue(job.Payload, "signing_key")
+	if needsKey && len(key) < tsMigrationKeyMinLength {
+		return "", "", fmt.Errorf("signing_key must have at least %d characters", tsMigrationKeyMinLength)
+	}
+	return migrationID, key, nil
+}
+
+// createTsSnapshot runs serversnapshotcreate on the selected server. Large
+// servers need longer than a normal command, so the pooled client gets the
+// snapshot timeout for this one command only.
+func createTsSnapshot(client *ts3QueryClient) (string, error) {
+	previous := client.commandTimeout
+	client.commandTimeout = tsSnapshotTimeout
+	defer func() { client.commandTimeout = previous }()
+	lines, err := client.commandLines("serversnapshotcreate")
+	if err != nil {
+		return "", err
+	}
+	snapshot, err := extractSnapshotFromServerQueryLines(lines)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(snapshot) == "" {
+		return "", errors.New("serversnapshotcreate returned no snapshot content")
+	}
+	return snapshot, nil
+}
+
+func deployTsSnapshot(client *ts3QueryClient, snapshot string) error {
+	previous := client.commandTimeout
+	client.commandTimeout = tsSnapshotTimeout
+	defer func() { client.commandTimeout = previous }()
+	_, err := client.command("serversnapshotdeploy " + snapshot)
+	return err
+}
+
+// countTsServerGroups counts the regular groups of the selected server;
+// template and query groups belong to the instance and differ between nodes.
+func countTsServerGroups(client *ts3QueryClient) (int, error) {
+	lines, err := client.commandLines("servergrouplist")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, group := range parseQueryList(lines) {
+		if group["type"] == "1" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// tsFilesStats counts the regular files below dir. A missing dir is a server
+// nobody uploaded anything to.
+func tsFilesStats(dir string) (int, int64, error) {
+	count, size := 0, int64(0)
+	if dir == "" {
+		return 0, 0, nil
+	}
+	err := filepath.WalkDir(dir, func(_ string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	return count, size, err
+}
+
+// writeTsMigrationBundle writes the bundle to path. The walk stops as soon
+// as ctx is cancelled and the partial bundle is removed.
+func writeTsMigrationBundle(ctx context.Context, path string, manifest tsMigrationManifest, snapshot, filesDir string) (err error) {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	bundle, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+	defer func() {
+		if closeErr := bundle.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	gzWriter := gzip.NewWriter(bundle)
+	defer func() {
+		if closeErr := gzWriter.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	tarWriter := tar.NewWriter(gzWriter)
+	defer func() {
+		if closeErr := tarWriter.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+
+	for _, entry := range []struct {
+		name    string
+		content []byte
+	}{{"manifest.json", encoded}, {"snapshot.txt", []byte(snapshot)}} {
+		header := &tar.Header{Name: entry.name, Mode: 0o600, Size: int64(len(entry.content)), ModTime: manifest.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tarWriter.Write(entry.content); err != nil {
+			return err
+		}
+	}
+	if manifest.FileCount == 0 {
+		return nil
+	}
+	return filepath.WalkDir(filesDir, func(path string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if ctx.Err() != nil {
+			return errJobCancelled
+		}
+		rel, err := filepath.Rel(filesDir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join("files", rel))
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, copyErr := io.Copy(tarWriter, &contextReader{ctx: ctx, reader: file})
+		closeErr := file.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+		return closeErr
+	})
+}
+
+// signTsMigrationBundle returns the hex HMAC-SHA256 of the bundle file.
+func signTsMigrationBundle(path, key string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	mac := hmac.New(sha256.New, []byte(key))
+	if _, err := io.Copy(mac, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func verifyTsMigrationBundle(path, key, signature string) error {
+	actual, err := signTsMigrationBundle(path, key)
+	if err != nil {
+		return err
+	}
+	expected, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil {
+		return errors.New("signature is not hex encoded")
+	}
+	decoded, _ := hex.DecodeString(actual)
+	if !hmac.Equal(decoded, expected) {
+		return errors.New("bundle signature does not match")
+	}
+	return nil
+}
+
+// readTsMigrationBundle reads the manifest and snapshot of a bundle that was
+// extracted to dir and checks it belongs to this migration.
+func readTsMigrationBundle(dir, migrationID, kind string) (tsMigrationManifest, string, error) {
+	var manifest tsMigrationManifest
+	raw, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return manifest, "", fmt.Errorf("bundle has no manifest: %w", err)
+	}
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return manifest, "", fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	switch {
+	case manifest.Version != tsMigrationVersion:
+		return manifest, "", fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	case manifest.MigrationID != migrationID:
+		return manifest, "", fmt.Errorf("bundle belongs to migration %q", manifest.MigrationID)
+	case manifest.Kind != kind:
+		return manifest, "", fmt.Errorf("bundle holds a %s server", manifest.Kind)
+	}
+	snapshot, err := os.ReadFile(filepath.Join(dir, "snapshot.txt"))
+	if err != nil || strings.TrimSpace(string(snapshot)) == "" {
+		return manifest, "", errors.New("bundle has no snapshot")
+	}
+	return manifest, string(snapshot), nil
+}
+
+// verifyTsMigration compares the imported server with the manifest.
+func verifyTsMigration(manifest tsMigrationManifest, info map[string]string, voicePort string, channels, groups, files int, fileBytes int64) []tsMigrationCheck {
+	checks := []tsMigrationCheck{
+		{Name: "name", Expected: manifest.Name, Actual: info["virtualserver_name"]},
+		{Name: "voice_port", Expected: voicePort, Actual: info["virtualserver_port"]},
+		{Name: "channel_count", Expected: strconv.Itoa(manifest.ChannelCount), Actual: strconv.Itoa(channels)},
+		{Name: "server_group_count", Expected: strconv.Itoa(manifest.ServerGroupCount), Actual: strconv.Itoa(groups)},
+		{Name: "file_count", Expected: strconv.Itoa(manifest.FileCount), Actual: strconv.Itoa(files)},
+		{Name: "file_bytes", Expected: strconv.FormatInt(manifest.FileBytes, 10), Actual: strconv.FormatInt(fileBytes, 10)},
+	}
+	for idx := range checks {
+		checks[idx].OK = checks[idx].Expected == checks[idx].Actual
+	}
+	return checks
+}
+
+func failedTsMigrationChecks(checks []tsMigrationCheck) []string {
+	var failed []string
+	for _, check := range checks {
+		if !check.OK {
+			failed = append(failed, fmt.Sprintf("%s: expected %s, got %s", check.Name, check.Expected, check.Actual))
+		}
+	}
+	return failed
+}
+
+func tsMigrationRecordPath(migrationID string) string {
+	return filepath.Join(tsMigrationDir(), migrationID+".json")
+}
+
+func loadTsMigrationRecord(migrationID string) (tsMigrationRecord, error) {
+	var record tsMigrationRecord
+	raw, err := os.ReadFile(tsMigrationRecordPath(migrationID))
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(raw, &record)
+	return record, err
+}
+
+func saveTsMigrationRecord(record tsMigrationRecord) error {
+	encoded, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tsMigrationRecordPath(record.MigrationID), encoded, 0o600)
+}
+
+// handleTsMigrationExport snapshots a virtual server on the source node and
+// packs it with its files into a signed bundle. The bundle is uploaded to
+// the backup target when one is configured and kept locally otherwise.
+func handleTsMigrationExport(ctx context.Context, job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	if sid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid"}
+	}
+	migrationID, key, err := parseTsMigrationJob(job, true)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	filesDir := payloadValue(job.Payload, "files_dir")
+	if installDir := payloadValue(job.Payload, "install_dir"); filesDir == "" && installDir != "" {
+		filesDir = tsVirtualServerFilesDir(installDir, sid)
+	}
+	stopSource := parsePayloadBool(payloadValue(job.Payload, "stop_source"), false)
+
+	manifest := tsMigrationManifest{Version: tsMigrationVersion, MigrationID: migrationID, Kind: tsJobKind(job), SourceSID: sid, CreatedAt: time.Now().UTC()}
+	var snapshot string
+	err = withTsClientForJob(job)(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(sid); err != nil {
+			return err
+		}
+		info, err := client.command("serverinfo")
+		if err != nil {
+			return err
+		}
+		manifest.Name, manifest.VoicePort, manifest.MaxClients = info["virtualserver_name"], info["virtualserver_port"], info["virtualserver_maxclients"]
+		channels, err := listTsChannels(client)
+		if err != nil {
+			return err
+		}
+		manifest.ChannelCount = len(channels)
+		if manifest.ServerGroupCount, err = countTsServerGroups(client); err != nil {
+			return err
+		}
+		if snapshot, err = createTsSnapshot(client); err != nil {
+			return err
+		}
+		// Stopping right after the snapshot keeps users from changing a
+		// server that is about to move.
+		if stopSource {
+			_, err = client.command("serverstop sid=" + sid)
+		}
+		return err
+	})
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if manifest.FileCount, manifest.FileBytes, err = tsFilesStats(filesDir); err != nil {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("read server files: %v", err)}
+	}
+
+	dir := tsMigrationDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	bundlePath := filepath.Join(dir, migrationID+".tar.gz")
+	if err := writeTsMigrationBundle(ctx, bundlePath, manifest, snapshot, filesDir); err != nil {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("write migration bundle: %v", err)}
+	}
+	signature, err := signTsMigrationBundle(bundlePath, key)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	checksum, size, err := computeFileChecksumAndSize(bundlePath)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+
+	record := tsMigrationRecord{MigrationID: migrationID, Kind: manifest.Kind, SID: sid, FilesDir: filesDir, BundlePath: bundlePath, Signature: signature, ExportedAt: manifest.CreatedAt}
+	if target != nil {
+		ref, failure := uploadBackupArchive(ctx, job, target, bundlePath, migrationID+".tar.gz")
+		if failure != nil {
+			return convertJobResult(*failure, nil)
+		}
+		record.BundlePath, record.BundleRef = "", ref
+	}
+	if err := saveTsMigrationRecord(record); err != nil {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("save migration record: %v", err)}
+	}
+
+	payload := map[string]any{
+		"migration_id": migrationID,
+		"sid":          sid,
+		"signature":    signature,
+		"sha256":       checksum,
+		"size":         size,
+		"manifest":     manifest,
+		"stopped":      stopSource,
+	}
+	if record.BundleRef != "" {
+		payload["bundle_ref"] = record.BundleRef
+	} else {
+		payload["bundle_path"] = bundlePath
+	}
+	return orchestratorResult{status: "success", resultPayload: payload}
+}
+
+// resolveTsMigrationBundle returns the local path of the bundle named by the
+// payload: bundle_ref is fetched from the backup target, bundle_path must lie
+// in the migration directory.
+func resolveTsMigrationBundle(ctx context.Context, job jobs.Job) (string, func(), error) {
+	if ref := payloadValue(job.Payload, "bundle_ref"); ref != "" {
+		target, err := newBackupTarget(job.Payload)
+		if err != nil {
+			return "", func() {}, err
+		}
+		if target == nil {
+			return "", func() {}, errors.New("bundle_ref needs a backup target")
+		}
+		return fetchBackupArchive(ctx, target, ref)
+	}
+	bundlePath := payloadValue(job.Payload, "bundle_path")
+	if bundlePath == "" {
+		return "", func() {}, errors.New("missing bundle_path or bundle_ref")
+	}
+	cleanPath, err := filepath.Abs(filepath.Clean(bundlePath))
+	if err != nil {
+		return "", func() {}, errors.New("invalid bundle_path")
+	}
+	root, err := filepath.Abs(tsMigrationDir())
+	if err != nil || !backupPathWithinRoot(cleanPath, root) {
+		return "", func() {}, errors.New("bundle_path is outside the migration directory")
+	}
+	return cleanPath, func() {}, nil
+}
+
+// handleTsMigrationImport creates a virtual server from a signed bundle on
+// the target node, remaps its ports, restores its files and verifies the
+// result against the manifest. A server that fails verification is removed
+// again unless keep_on_failure is set.
+func handleTsMigrationImport(ctx context.Context, job jobs.Job) orchestratorResult {
+	migrationID, key, err := parseTsMigrationJob(job, true)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	signature := payloadValue(job.Payload, "signature")
+	if signature == "" {
+		return orchestratorResult{status: "failed", errorText: "missing signature"}
+	}
+	installDir := payloadValue(job.Payload, "install_dir")
+	bundlePath, cleanup, err := resolveTsMigrationBundle(ctx, job)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	defer cleanup()
+	if err := verifyTsMigrationBundle(bundlePath, key, signature); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+
+	// Staging next to the files directory lets the tree be moved into place
+	// with a rename.
+	stagingRoot := tsMigrationDir()
+	if installDir != "" {
+		stagingRoot = filepath.Join(installDir, "files")
+	}
+	if err := os.MkdirAll(stagingRoot, 0o750); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	staging, err := os.MkdirTemp(stagingRoot, ".migration-"+migrationID+"-")
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	defer os.RemoveAll(staging)
+	if err := extractTarGzArchive(bundlePath, staging); err != nil {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("extract migration bundle: %v", err)}
+	}
+	manifest, snapshot, err := readTsMigrationBundle(staging, migrationID, tsJobKind(job))
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if manifest.FileCount > 0 && installDir == "" {
+		return orchestratorResult{status: "failed", errorText: "install_dir is required to restore the server files"}
+	}
+
+	voicePort := firstNonEmpty(payloadValue(job.Payload, "voice_port"), manifest.VoicePort)
+	filePort := payloadValue(job.Payload, "filetransfer_port")
+	keepOnFailure := parsePayloadBool(payloadValue(job.Payload, "keep_on_failure"), false)
+	withClient := withTsClientForJob(job)
+
+	var newSID, filesDir string
+	var checks []tsMigrationCheck
+	err = withClient(job.Payload, func(client *ts3QueryClient) error {
+		servers, err := listVirtualServers(client)
+		if err != nil {
+			return err
+		}
+		for _, server := range servers {
+			if server["virtualserver_port"] == voicePort {
+				return fmt.Errorf("voice port %s is already used by virtual server %s", voicePort, server["virtualserver_id"])
+			}
+		}
+		args := []string{"virtualserver_name=" + escapeTs3Query(manifest.Name), "virtualserver_port=" + voicePort}
+		if manifest.MaxClients != "" {
+			args = append(args, "virtualserver_maxclients="+manifest.MaxClients)
+		}
+		response, err := client.command("servercreate " + strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		if newSID = response["sid"]; newSID == "" {
+			return errors.New("servercreate did not return sid")
+		}
+		if err := client.useServer(newSID); err != nil {
+			return err
+		}
+		if err := deployTsSnapshot(client, snapshot); err != nil {
+			return fmt.Errorf("deploy snapshot: %w", err)
+		}
+		// The snapshot carries the source ports; put the target's back.
+		edit := []string{"virtualserver_port=" + voicePort}
+		if filePort != "" {
+			edit = append(edit, "virtualserver_filetransfer_port="+filePort)
+		}
+		if _, err := client.command("serveredit " + strings.Join(edit, " ")); err != nil {
+			return fmt.Errorf("remap ports: %w", err)
+		}
+		if _, err := client.command("serverstop sid=" + newSID); err != nil {
+			return err
+		}
+		if manifest.FileCount > 0 {
+			filesDir = tsVirtualServerFilesDir(installDir, newSID)
+			if err := restoreTsMigrationFiles(filepath.Join(staging, "files"), filesDir, installDir); err != nil {
+				return fmt.Errorf("restore server files: %w", err)
+			}
+		}
+		if _, err := client.command("serverstart sid=" + newSID); err != nil {
+			return err
+		}
+
+		if err := client.useServer(newSID); err != nil {
+			return err
+		}
+		info, err := client.command("serverinfo")
+		if err != nil {
+			return err
+		}
+		channels, err := listTsChannels(client)
+		if err != nil {
+			return err
+		}
+		groups, err := countTsServerGroups(client)
+		if err != nil {
+			return err
+		}
+		var files int
+		var fileBytes int64
+		if filesDir != "" {
+			if files, fileBytes, err = tsFilesStats(filesDir); err != nil {
+				return err
+			}
+		}
+		checks = verifyTsMigration(manifest, info, voicePort, len(channels), groups, files, fileBytes)
+		if failed := failedTsMigrationChecks(checks); len(failed) > 0 {
+			return fmt.Errorf("verification failed: %s", strings.Join(failed, "; "))
+		}
+		return nil
+	})
+
+	payload := map[string]any{"migration_id": migrationID, "source_sid": manifest.SourceSID, "voice_port": voicePort, "checks": checks}
+	if filePort != "" {
+		payload["filetransfer_port"] = filePort
+	}
+	if err != nil {
+		if newSID != "" && !keepOnFailure {
+			if rollbackErr := removeTsMigratedServer(withClient, job.Payload, newSID, filesDir); rollbackErr != nil {
+				payload["rollback_error"] = rollbackErr.Error()
+			} else {
+				payload["rolled_back"] = true
+			}
+		} else if newSID != "" {
+			payload["sid"] = newSID
+		}
+		return orchestratorResult{status: "failed", errorText: err.Error(), resultPayload: payload}
+	}
+	payload["sid"] = newSID
+	payload["verified"] = true
+	// The verified signature is what the panel hands to the decommission of
+	// the source, so the source is only removed after this import passed.
+	payload["signature"] = signature
+	return orchestratorResult{status: "success", resultPayload: payload}
+}
+
+// restoreTsMigrationFiles moves the staged files tree to dest, replacing the
+// empty tree the server created, and hands it to the owner of installDir.
+func restoreTsMigrationFiles(staged, dest, installDir string) error {
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		return err
+	}
+	if uid, gid, ok := resolveOwnerFromPath(installDir); ok {
+		if err := os.Chown(dest, uid, gid); err != nil {
+			return err
+		}
+		return chownRecursive(dest, uid, gid)
+	}
+	return nil
+}
+
+// removeTsMigratedServer deletes a virtual server and, when filesDir is set,
+// its files. serverdelete refuses running servers, so it is stopped first.
+func removeTsMigratedServer(withClient func(map[string]any, func(*ts3QueryClient) error) error, payload map[string]any, sid, filesDir string) error {
+	err := withClient(payload, func(client *ts3QueryClient) error {
+		servers, err := listVirtualServers(client)
+		if err != nil {
+			return err
+		}
+		for _, server := range servers {
+			if server["virtualserver_id"] != sid {
+				continue
+			}
+			if server["virtualserver_status"] == "online" {
+				if _, err := client.command("serverstop sid=" + sid); err != nil {
+					return err
+				}
+			}
+			_, err := client.command("serverdelete sid=" + sid)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if filesDir != "" && filepath.Base(filesDir) == "virtualserver_"+sid {
+		return os.RemoveAll(filesDir)
+	}
+	return nil
+}
+
+// handleTsMigrationDecommission removes the source server of a finished
+// migration. The panel confirms by repeating the migration id and passes the
+// target sid and the signature returned by the verified import; only the
+// server recorded by this node's export, and only with the signature of that
+// export, can be removed.
+func handleTsMigrationDecommission(job jobs.Job) orchestratorResult {
+	sid := payloadValue(job.Payload, "sid")
+	if sid == "" {
+		return orchestratorResult{status: "failed", errorText: "missing sid"}
+	}
+	migrationID, _, err := parseTsMigrationJob(job, false)
+	if err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if payloadValue(job.Payload, "confirm") != migrationID {
+		return orchestratorResult{status: "failed", errorText: "confirm must repeat the migration_id once the import was verified"}
+	}
+	record, err := loadTsMigrationRecord(migrationID)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return orchestratorResult{status: "failed", errorText: fmt.Sprintf("migration %s was not exported on this node", migrationID)}
+		}
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if record.SID != sid || record.Kind != tsJobKind(job) {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("migration %s exported %s server %s", migrationID, record.Kind, record.SID)}
+	}
+	targetSID := payloadValue(job.Payload, "target_sid")
+	if targetSID == "" {
+		return orchestratorResult{status: "failed", errorText: "missing target_sid of the verified import"}
+	}
+	if !tsMigrationSignatureMatches(record.Signature, payloadValue(job.Payload, "signature")) {
+		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("signature does not match the export of migration %s", migrationID)}
+	}
+
+	filesDir := ""
+	if parsePayloadBool(payloadValue(job.Payload, "remove_files"), true) {
+		filesDir = record.FilesDir
+	}
+	if err := removeTsMigratedServer(withTsClientForJob(job), job.Payload, sid, filesDir); err != nil {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	if record.BundlePath != "" {
+		_ = os.Remove(record.BundlePath)
+	}
+	if err := os.Remove(tsMigrationRecordPath(migrationID)); err != nil && !os.IsNotExist(err) {
+		return orchestratorResult{status: "failed", errorText: err.Error()}
+	}
+	return orchestratorResult{status: "success", resultPayload: map[string]any{"migration_id": migrationID, "sid": sid, "target_sid": targetSID, "deleted": true, "files_removed": filesDir != ""}}
+}
+
+// tsMigrationSignatureMatches compares the signature of a verified import
+// with the one recorded at export. A record without a signature never
+// matches.
+func tsMigrationSignatureMatches(recorded, given string) bool {
+	expected, err := hex.DecodeString(strings.TrimSpace(recorded))
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	actual, err := hex.DecodeString(strings.TrimSpace(given))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, actual)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestTsMigrationBundleIsSignedAndVerified(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("EASYWI_TS_MIGRATION_DIR", dir)
+	filesDir := filepath.Join(dir, "install", "files", "virtualserver_4")
+	if err := os.MkdirAll(filepath.Join(filesDir, "channel_2"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filesDir, "channel_2", "rules.txt"), []byte("be nice"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	count, size, err := tsFilesStats(filesDir)
+	if err != nil || count != 1 || size != 7 {
+		t.Fatalf("unexpected file stats %d/%d (%v)", count, size, err)
+	}
+
+	key := strings.Repeat("k", tsMigrationKeyMinLength)
+	manifest := tsMigrationManifest{Version: tsMigrationVersion, MigrationID: "mig-1", Kind: "ts3", SourceSID: "4", Name: "Clan", VoicePort: "9987", ChannelCount: 3, ServerGroupCount: 2, FileCount: count, FileBytes: size, CreatedAt: time.Now().UTC()}
+	bundle := filepath.Join(dir, "mig-1.tar.gz")
+	if err := writeTsMigrationBundle(context.Background(), bundle, manifest, "version=2 data=abc", filesDir); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	signature, err := signTsMigrationBundle(bundle, key)
+	if err != nil {
+		t.Fatalf("sign bundle: %v", err)
+	}
+	if err := verifyTsMigrationBundle(bundle, key, signature); err != nil {
+		t.Fatalf("verify bundle: %v", err)
+	}
+	if err := verifyTsMigrationBundle(bundle, strings.Repeat("x", tsMigrationKeyMinLength), signature); err == nil {
+		t.Fatal("expected a bundle signed with another key to be rejected")
+	}
+
+	staging := t.TempDir()
+	if err := extractTarGzArchive(bundle, staging); err != nil {
+		t.Fatalf("extract bundle: %v", err)
+	}
+	read, snapshot, err := readTsMigrationBundle(staging, "mig-1", "ts3")
+	if err != nil || snapshot != "version=2 data=abc" || read.Name != "Clan" {
+		t.Fatalf("unexpected bundle contents %#v %q (%v)", read, snapshot, err)
+	}
+	if _, _, err := readTsMigrationBundle(staging, "mig-2", "ts3"); err == nil {
+		t.Fatal("expected a bundle of another migration to be rejected")
+	}
+	if _, _, err := readTsMigrationBundle(staging, "mig-1", "ts6"); err == nil {
+		t.Fatal("expected a TS3 bundle to be rejected by a TS6 import")
+	}
+	if data, err := os.ReadFile(filepath.Join(staging, "files", "channel_2", "rules.txt")); err != nil || string(data) != "be nice" {
+		t.Fatalf("unexpected restored file %q (%v)", data, err)
+	}
+
+	// The import remapped the voice port; a lost channel fails verification.
+	info := map[string]string{"virtualserver_name": "Clan", "virtualserver_port": "10001"}
+	checks := verifyTsMigration(read, info, "10001", 3, 2, 1, 7)
+	if failed := failedTsMigrationChecks(checks); len(failed) != 0 {
+		t.Fatalf("unexpected failed checks %v", failed)
+	}
+	checks = verifyTsMigration(read, info, "10001", 2, 2, 1, 7)
+	if failed := failedTsMigrationChecks(checks); len(failed) != 1 || !strings.HasPrefix(failed[0], "channel_count") {
+		t.Fatalf("expected the channel count to fail, got %v", failed)
+	}
+}
+
+func TestTsMigrationDecommissionRequiresConfirmationAndExport(t *testing.T) {
+	t.Setenv("EASYWI_TS_MIGRATION_DIR", t.TempDir())
+	signature := strings.Repeat("ab", 32)
+	if err := saveTsMigrationRecord(tsMigrationRecord{MigrationID: "mig-1", Kind: "ts3", SID: "4", Signature: signature}); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveTsMigrationRecord(tsMigrationRecord{MigrationID: "mig-3", Kind: "ts3", SID: "4"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []map[string]any{
+		{"sid": "4", "migration_id": "mig-1", "target_sid": "9", "signature": signature},
+		{"sid": "4", "migration_id": "mig-1", "confirm": "true", "target_sid": "9", "signature": signature},
+		{"sid": "5", "migration_id": "mig-1", "confirm": "mig-1", "target_sid": "9", "signature": signature},
+		{"sid": "4", "migration_id": "mig-2", "confirm": "mig-2", "target_sid": "9", "signature": signature},
+		{"sid": "4", "migration_id": "mig-1", "confirm": "mig-1", "signature": signature},
+		{"sid": "4", "migration_id": "mig-1", "confirm": "mig-1", "target_sid": "9"},
+		{"sid": "4", "migration_id": "mig-1", "confirm": "mig-1", "target_sid": "9", "signature": strings.Repeat("cd", 32)},
+		{"sid": "4", "migration_id": "mig-1", "confirm": "mig-1", "target_sid": "9", "signature": "not-hex"},
+		{"sid": "4", "migration_id": "mig-3", "confirm": "mig-3", "target_sid": "9", "signature": ""},
+	} {
+		result := handleTsMigrationDecommission(jobs.Job{Type: "ts3.virtual.migration.decommission", Payload: payload})
+		if result.status != "failed" {
+			t.Errorf("expected %v to be refused", payload)
+		}
+	}
+	result := handleTsMigrationDecommission(jobs.Job{Type: "ts6.virtual.migration.decommission", Payload: map[string]any{"sid": "4", "migration_id": "mig-1", "confirm": "mig-1", "target_sid": "9", "signature": signature}})
+	if result.status != "failed" || !strings.Contains(result.errorText, "ts3 server 4") {
+		t.Fatalf("expected a TS6 job to be refused for a TS3 export, got %#v", result)
+	}
+	if _, err := loadTsMigrationRecord("mig-1"); err != nil {
+		t.Fatalf("refused decommissions must keep the record: %v", err)
+	}
+	if !tsMigrationSignatureMatches(signature, strings.ToUpper(signature)) {
+		t.Fatal("expected the signature comparison to ignore hex case")
+	}
+}
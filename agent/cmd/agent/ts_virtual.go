@@ -21,12 +21,16 @@ import (
 var queryPromptRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+>\s*$`)
 
 func handleTs3VirtualCreate(job jobs.Job) orchestratorResult {
-	client, err := newTs3QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
+	return handleTsVirtualCreate(job, withTs3Client, false)
+}
+
+// handleTs6VirtualCreate refuses duplicate names because TS6 does not
+// enforce unique virtual server names itself.
+func handleTs6VirtualCreate(job jobs.Job) orchestratorResult {
+	return handleTsVirtualCreate(job, withTs6Client, true)
+}
 
+func handleTsVirtualCreate(job jobs.Job, withClient func(map[string]any, func(*ts3QueryClient) error) error, rejectDuplicate bool) orchestratorResult {
 	name := payloadValue(job.Payload, "name")
 	if strings.TrimSpace(name) == "" {
 		return orchestratorResult{status: "failed", errorText: "missing virtual server name"}
@@ -47,54 +51,48 @@ func handleTs3VirtualCreate(job jobs.Job) orchestratorResult {
 	if maxClients != "" {
 		args = append(args, fmt.Sprintf("virtualserver_maxclients=%s", maxClients))
 	}
-	response, err := client.command("servercreate " + strings.Join(args, " "))
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
-	sid := response["sid"]
-	token := response["token"]
-	if sid == "" {
-		return orchestratorResult{status: "failed", errorText: "servercreate did not return sid"}
-	}
-
-	payload := map[string]any{
-		"sid": sid,
-	}
-	if voicePort != "" {
-		payload["voice_port"] = voicePort
-	}
-	if filePort != "" {
-		payload["filetransfer_port"] = filePort
-	}
-	if token != "" {
-		payload["token"] = token
-	}
-
-	return orchestratorResult{
-		status:        "success",
-		resultPayload: payload,
-	}
-}
-
-func handleTs6VirtualCreate(job jobs.Job) orchestratorResult {
-	client, err := newTs6QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
-
-	name := payloadValue(job.Payload, "name")
-	if strings.TrimSpace(name) == "" {
-		return orchestratorResult{status: "failed", errorText: "missing virtual server name"}
+	// A channel layout preset is applied right after the server is created.
+	var layout []tsChannelSpec
+	if raw := firstNonEmpty(payloadValue(job.Payload, "channel_layout"), payloadValue(params, "channel_layout")); raw != "" {
+		var err error
+		if layout, err = parseTsChannelLayout(raw); err != nil {
+			return orchestratorResult{status: "failed", errorText: err.Error()}
+		}
 	}
+	prune := parsePayloadBool(firstNonEmpty(payloadValue(job.Payload, "channel_layout_prune"), payloadValue(params, "channel_layout_prune")), false)
 
-	existingServers, err := listVirtualServers(client)
+	var existingSummary string
+	var response map[string]string
+	var duplicate bool
+	var layoutChanges []tsChannelLayoutChange
+	var layoutErr error
+	err := withClient(job.Payload, func(client *ts3QueryClient) error {
+		if rejectDuplicate {
+			existingServers, err := listVirtualServers(client)
+			if err != nil {
+				return err
+			}
+			existingSummary = formatVirtualServerSummary(existingServers)
+			if duplicate = findVirtualServerByName(existingServers, name); duplicate {
+				return nil
+			}
+		}
+		var err error
+		response, err = client.command("servercreate " + strings.Join(args, " "))
+		if err != nil || len(layout) == 0 || response["sid"] == "" {
+			return err
+		}
+		// The server exists at this point; a failed layout is reported but
+		// must not make the panel retry the create.
+		if layoutErr = client.useServer(response["sid"]); layoutErr == nil {
+			layoutChanges, layoutErr = applyTsChannelLayout(client, layout, prune, false)
+		}
+		return nil
+	})
 	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
+		return orchestratorResult{status: "failed", errorText: err.Error(), logText: existingSummary}
 	}
-	existingSummary := formatVirtualServerSummary(existingServers)
-	if findVirtualServerByName(existingServers, name) {
+	if duplicate {
 		return orchestratorResult{
 			status:    "failed",
 			errorText: fmt.Sprintf("virtual server %q already exists", name),
@@ -102,26 +100,6 @@ func handleTs6VirtualCreate(job jobs.Job) orchestratorResult {
 		}
 	}
 
-	params := payloadMap(job.Payload, "params")
-	voicePort := payloadValue(params, "voice_port")
-	filePort := payloadValue(params, "filetransfer_port")
-	maxClients := payloadValue(params, "slots", "max_clients")
-
-	args := []string{fmt.Sprintf("virtualserver_name=%s", escapeTs3Query(name))}
-	if voicePort != "" {
-		args = append(args, fmt.Sprintf("virtualserver_port=%s", voicePort))
-	}
-	if filePort != "" {
-		args = append(args, fmt.Sprintf("virtualserver_filetransfer_port=%s", filePort))
-	}
-	if maxClients != "" {
-		args = append(args, fmt.Sprintf("virtualserver_maxclients=%s", maxClients))
-	}
-	response, err := client.command("servercreate " + strings.Join(args, " "))
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
 	sid := response["sid"]
 	token := response["token"]
 	if sid == "" {
@@ -140,6 +118,12 @@ func handleTs6VirtualCreate(job jobs.Job) orchestratorResult {
 	if token != "" {
 		payload["token"] = token
 	}
+	if len(layout) > 0 {
+		payload["channel_layout_changes"] = layoutChanges
+		if layoutErr != nil {
+			payload["channel_layout_error"] = layoutErr.Error()
+		}
+	}
 
 	return orchestratorResult{
 		status:        "success",
@@ -149,79 +133,43 @@ func handleTs6VirtualCreate(job jobs.Job) orchestratorResult {
 }
 
 func handleTs3VirtualAction(job jobs.Job) orchestratorResult {
-	client, err := newTs3QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
-
-	sid := payloadValue(job.Payload, "sid")
-	action := strings.ToLower(payloadValue(job.Payload, "action"))
-	if sid == "" || action == "" {
-		return orchestratorResult{status: "failed", errorText: "missing sid or action"}
-	}
-
-	var command string
-	switch action {
-	case "start":
-		command = fmt.Sprintf("serverstart sid=%s", sid)
-	case "stop":
-		command = fmt.Sprintf("serverstop sid=%s", sid)
-	case "restart":
-		if _, err := client.command(fmt.Sprintf("serverstop sid=%s", sid)); err != nil {
-			return orchestratorResult{status: "failed", errorText: err.Error()}
-		}
-		command = fmt.Sprintf("serverstart sid=%s", sid)
-	case "delete":
-		command = fmt.Sprintf("serverdelete sid=%s", sid)
-	default:
-		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("unsupported action: %s", action)}
-	}
-
-	if _, err := client.command(command); err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
-	return orchestratorResult{
-		status: "success",
-		resultPayload: map[string]any{
-			"sid":    sid,
-			"action": action,
-		},
-	}
+	return handleTsVirtualAction(job, withTs3Client)
 }
 
 func handleTs6VirtualAction(job jobs.Job) orchestratorResult {
-	client, err := newTs6QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
+	return handleTsVirtualAction(job, withTs6Client)
+}
 
+func handleTsVirtualAction(job jobs.Job, withClient func(map[string]any, func(*ts3QueryClient) error) error) orchestratorResult {
 	sid := payloadValue(job.Payload, "sid")
 	action := strings.ToLower(payloadValue(job.Payload, "action"))
 	if sid == "" || action == "" {
 		return orchestratorResult{status: "failed", errorText: "missing sid or action"}
 	}
 
-	var command string
+	var commands []string
 	switch action {
 	case "start":
-		command = fmt.Sprintf("serverstart sid=%s", sid)
+		commands = []string{fmt.Sprintf("serverstart sid=%s", sid)}
 	case "stop":
-		command = fmt.Sprintf("serverstop sid=%s", sid)
+		commands = []string{fmt.Sprintf("serverstop sid=%s", sid)}
 	case "restart":
-		if _, err := client.command(fmt.Sprintf("serverstop sid=%s", sid)); err != nil {
-			return orchestratorResult{status: "failed", errorText: err.Error()}
-		}
-		command = fmt.Sprintf("serverstart sid=%s", sid)
+		commands = []string{fmt.Sprintf("serverstop sid=%s", sid), fmt.Sprintf("serverstart sid=%s", sid)}
 	case "delete":
-		command = fmt.Sprintf("serverdelete sid=%s", sid)
+		commands = []string{fmt.Sprintf("serverdelete sid=%s", sid)}
 	default:
 		return orchestratorResult{status: "failed", errorText: fmt.Sprintf("unsupported action: %s", action)}
 	}
 
-	if _, err := client.command(command); err != nil {
+	err := withClient(job.Payload, func(client *ts3QueryClient) error {
+		for _, command := range commands {
+			if _, err := client.command(command); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
 
@@ -235,34 +183,20 @@ func handleTs6VirtualAction(job jobs.Job) orchestratorResult {
 }
 
 func handleTs6VirtualList(job jobs.Job) orchestratorResult {
-	client, err := newTs6QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
-
-	servers, err := listVirtualServers(client)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
-	normalized := normalizeVirtualServerList(servers)
-	return orchestratorResult{
-		status: "success",
-		resultPayload: map[string]any{
-			"servers": normalized,
-		},
-	}
+	return handleTsVirtualList(job, withTs6Client)
 }
 
 func handleTs3VirtualList(job jobs.Job) orchestratorResult {
-	client, err := newTs3QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
+	return handleTsVirtualList(job, withTs3Client)
+}
 
-	servers, err := listVirtualServers(client)
+func handleTsVirtualList(job jobs.Job, withClient func(map[string]any, func(*ts3QueryClient) error) error) orchestratorResult {
+	var servers []map[string]string
+	err := withClient(job.Payload, func(client *ts3QueryClient) error {
+		var err error
+		servers, err = listVirtualServers(client)
+		return err
+	})
 	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
@@ -277,64 +211,32 @@ func handleTs3VirtualList(job jobs.Job) orchestratorResult {
 }
 
 func handleTs3VirtualTokenRotate(job jobs.Job) orchestratorResult {
-	client, err := newTs3QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
-
-	sid := payloadValue(job.Payload, "sid")
-	if sid == "" {
-		return orchestratorResult{status: "failed", errorText: "missing sid"}
-	}
-	serverGroupID := payloadValue(job.Payload, "server_group_id", "sgid")
-	if serverGroupID == "" {
-		return orchestratorResult{status: "failed", errorText: "missing server_group_id"}
-	}
-
-	if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
-	response, err := client.command(fmt.Sprintf("tokenadd tokentype=0 tokenid1=%s tokenid2=0", serverGroupID))
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	token := response["token"]
-	if token == "" {
-		return orchestratorResult{status: "failed", errorText: "token rotate did not return token"}
-	}
-
-	return orchestratorResult{
-		status: "success",
-		resultPayload: map[string]any{
-			"token":      token,
-			"token_type": fmt.Sprintf("server_group:%s", serverGroupID),
-		},
-	}
+	return handleTsVirtualTokenRotate(job, withTs3Client)
 }
 
 func handleTs6VirtualTokenRotate(job jobs.Job) orchestratorResult {
-	client, err := newTs6QueryClient(job.Payload)
-	if err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-	defer client.close()
+	return handleTsVirtualTokenRotate(job, withTs6Client)
+}
 
+func handleTsVirtualTokenRotate(job jobs.Job, withClient func(map[string]any, func(*ts3QueryClient) error) error) orchestratorResult {
 	sid := payloadValue(job.Payload, "sid")
 	if sid == "" {
 		return orchestratorResult{status: "failed", errorText: "missing sid"}
 	}
-	serverGroupID := payloadValue(job.Payload, "server_group_id", "sgid")
+	serverGroupID := payloadValue(job.Payload, "sgid")
 	if serverGroupID == "" {
 		return orchestratorResult{status: "failed", errorText: "missing server_group_id"}
 	}
 
-	if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
-		return orchestratorResult{status: "failed", errorText: err.Error()}
-	}
-
-	response, err := client.command(fmt.Sprintf("tokenadd tokentype=0 tokenid1=%s tokenid2=0", serverGroupID))
+	var response map[string]string
+	err := withClient(job.Payload, func(client *ts3QueryClient) error {
+		if err := client.useServer(sid); err != nil {
+			return err
+		}
+		var err error
+		response, err = client.command(fmt.Sprintf("tokenadd tokentype=0 tokenid1=%s tokenid2=0", serverGroupID))
+		return err
+	})
 	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
 	}
@@ -360,7 +262,7 @@ func handleTs3ServerGroupList(job jobs.Job) orchestratorResult {
 
 	var groups []map[string]string
 	err := withTs3Client(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		var err error
@@ -387,7 +289,7 @@ func handleTs6ServerGroupList(job jobs.Job) orchestratorResult {
 
 	var groups []map[string]string
 	err := withTs6Client(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		var err error
@@ -414,7 +316,7 @@ func handleTs3VirtualSummary(job jobs.Job) orchestratorResult {
 
 	var result map[string]any
 	err := withTs3Client(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		response, err := client.command("serverinfo")
@@ -443,7 +345,7 @@ func handleTs6VirtualSummary(job jobs.Job) orchestratorResult {
 
 	var result map[string]any
 	err := withTs6Client(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		response, err := client.command("serverinfo")
@@ -530,7 +432,7 @@ func handleTsClientKick(job jobs.Job, withClient func(map[string]any, func(*ts3Q
 		reason = "kicked by panel"
 	}
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		_, err := client.command(fmt.Sprintf("clientkick clid=%s reasonid=5 reasonmsg=%s", clid, escapeTs3Query(reason)))
@@ -550,7 +452,7 @@ func handleTsClientPoke(job jobs.Job, withClient func(map[string]any, func(*ts3Q
 		return orchestratorResult{status: "failed", errorText: "missing sid, clid or message"}
 	}
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		_, err := client.command(fmt.Sprintf("clientpoke clid=%s msg=%s", clid, escapeTs3Query(message)))
@@ -577,7 +479,7 @@ func handleTsClientBan(job jobs.Job, withClient func(map[string]any, func(*ts3Qu
 		duration = 0
 	}
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		if _, err := client.command(fmt.Sprintf("banclient clid=%s time=%d banreason=%s", clid, duration, escapeTs3Query(reason))); err == nil {
@@ -614,7 +516,7 @@ func handleTsBanRemove(job jobs.Job, withClient func(map[string]any, func(*ts3Qu
 	}
 	log.Printf("voice.ban.delete job_id=%s job_type=%s sid_present=%t banid=%s", job.ID, job.Type, sid != "", banID)
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		_, err := client.command(fmt.Sprintf("bandel banid=%s", banID))
@@ -666,7 +568,7 @@ func handleTsSnapshotRestore(job jobs.Job, withClient func(map[string]any, func(
 	}
 
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		_, err := client.command(fmt.Sprintf("serversnapshotdeploy %s", snapshotContent))
@@ -690,7 +592,7 @@ func handleTsQueryList(job jobs.Job, withClient func(map[string]any, func(*ts3Qu
 
 	var lines []string
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
 		var err error
@@ -749,22 +651,12 @@ func handleTsSnapshot(job jobs.Job, withClient func(map[string]any, func(*ts3Que
 
 	var snapshot string
 	err := withClient(job.Payload, func(client *ts3QueryClient) error {
-		if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		if err := client.useServer(sid); err != nil {
 			return err
 		}
-		client.commandTimeout = 120 * time.Second
-		lines, err := client.commandLines("serversnapshotcreate")
-		if err != nil {
-			return err
-		}
-		snapshot, err = extractSnapshotFromServerQueryLines(lines)
-		if err != nil {
-			return err
-		}
-		if strings.TrimSpace(snapshot) == "" {
-			return fmt.Errorf("serversnapshotcreate returned no snapshot content")
-		}
-		return nil
+		var err error
+		snapshot, err = createTsSnapshot(client)
+		return err
 	})
 	if err != nil {
 		return orchestratorResult{status: "failed", errorText: err.Error()}
@@ -814,7 +706,7 @@ func extractSnapshotFromServerQueryLines(lines []string) (string, error) {
 }
 
 func newTs3QueryClient(payload map[string]any) (*ts3QueryClient, error) {
-	queryIP := payloadValue(payload, "query_bind_ip", "query_ip")
+	queryIP := schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip")
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -854,8 +746,8 @@ func dialTsQueryTCP(address string) (net.Conn, error) {
 }
 
 func newTs6QueryClient(payload map[string]any) (*ts3QueryClient, error) {
-	protocol := strings.ToLower(strings.TrimSpace(payloadValue(payload, "query_protocol", "query_transport")))
-	queryIP := normalizeQueryConnectIP(payloadValue(payload, "query_bind_ip", "query_ip"))
+	protocol := strings.ToLower(strings.TrimSpace(schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_protocol")))
+	queryIP := normalizeQueryConnectIP(schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip"))
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -908,7 +800,7 @@ func resolveValueOrDefault(value string, fallback string) string {
 }
 
 func newTs6QueryClientTCP(payload map[string]any) (*ts3QueryClient, error) {
-	queryIP := payloadValue(payload, "query_bind_ip", "query_ip")
+	queryIP := schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip")
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -949,7 +841,7 @@ func newTs6QueryClientTCP(payload map[string]any) (*ts3QueryClient, error) {
 }
 
 func newTs6QueryClientSSH(payload map[string]any) (*ts3QueryClient, error) {
-	queryIP := payloadValue(payload, "query_bind_ip", "query_ip")
+	queryIP := schemaPayloadValue(payload, tsQueryConnectionPayload{}, "query_bind_ip")
 	if queryIP == "" {
 		queryIP = "127.0.0.1"
 	}
@@ -1111,6 +1003,15 @@ type ts3QueryClient struct {
 	writer         *bufio.Writer
 	commandTimeout time.Duration
 	deadlineTimer  *time.Timer
+	// selectedSID is the virtual server picked by the last successful use
+	// command; pooled connections keep it between jobs.
+	selectedSID string
+	// notify receives notify* lines that arrive while a command waits for
+	// its reply. Without it they are dropped.
+	notify func(line string)
+	// sentChanges records that a command outside tsReplaySafeCommand reached
+	// the server since the pool last cleared it.
+	sentChanges bool
 }
 
 func (client *ts3QueryClient) close() {
@@ -1158,7 +1059,47 @@ func (client *ts3QueryClient) command(cmd string) (map[string]string, error) {
 	return parseTs3QueryLines(lines), nil
 }
 
+// useServer selects the virtual server for the following commands and
+// skips the round trip when the connection already has it selected.
+func (client *ts3QueryClient) useServer(sid string) error {
+	if client.selectedSID != "" && client.selectedSID == sid {
+		return nil
+	}
+	if _, err := client.command(fmt.Sprintf("use sid=%s", sid)); err != nil {
+		return err
+	}
+	client.selectedSID = sid
+	return nil
+}
+
+// tsSelectionCommands may leave the connection without a selected virtual
+// server, or with a different one than before.
+var tsSelectionCommands = map[string]bool{
+	"use":                  true,
+	"login":                true,
+	"logout":               true,
+	"servercreate":         true,
+	"serverdelete":         true,
+	"serverstart":          true,
+	"serverstop":           true,
+	"serversnapshotdeploy": true,
+}
+
+// tsReplaySafeCommand reports whether running verb twice has the same effect
+// as running it once: reads, session selection and notification registration.
+func tsReplaySafeCommand(verb string) bool {
+	switch verb {
+	case "login", "use", "whoami", "version", "servernotifyregister", "serversnapshotcreate", "ftinitdownload":
+		return true
+	}
+	return strings.HasSuffix(verb, "list") || strings.HasSuffix(verb, "info")
+}
+
 func (client *ts3QueryClient) commandLines(cmd string) ([]string, error) {
+	verb, _, _ := strings.Cut(cmd, " ")
+	if tsSelectionCommands[verb] {
+		client.selectedSID = ""
+	}
 	client.setDeadline()
 	defer client.clearDeadline()
 
@@ -1168,6 +1109,11 @@ func (client *ts3QueryClient) commandLines(cmd string) ([]string, error) {
 	if err := client.writer.Flush(); err != nil {
 		return nil, err
 	}
+	// The server runs a command as soon as its line arrives, so from here on it
+	// may have been applied even if the reply never comes back.
+	if !tsReplaySafeCommand(verb) {
+		client.sentChanges = true
+	}
 	return client.readResponse()
 }
 
@@ -1239,6 +1185,12 @@ func (client *ts3QueryClient) readResponse() ([]string, error) {
 		if isQueryPromptLine(line) {
 			continue
 		}
+		if strings.HasPrefix(line, "notify") {
+			if client.notify != nil {
+				client.notify(line)
+			}
+			continue
+		}
 		if strings.HasPrefix(line, "error id=") {
 			queryErr := parseTsQueryErrorLine(line)
 			if queryErr != nil && queryErr.ID != "0" {
@@ -94,6 +94,44 @@ func TestTsPoolRetriesConnectionErrorsOnly(t *testing.T) {
 	}
 }
 
+func TestTsPoolDoesNotReplayCommandsThatReachedTheServer(t *testing.T) {
+	droppedClient := func() (*ts3QueryClient, error) {
+		conn := newScriptedQueryConn("")
+		return &ts3QueryClient{conn: conn, reader: newScriptedReader(conn), writer: newScriptedWriter(conn), commandTimeout: time.Second}, nil
+	}
+
+	for _, tc := range []struct {
+		command  string
+		attempts int
+	}{
+		{command: "channellist", attempts: 2},
+		{command: "channeldelete cid=5 force=1", attempts: 1},
+	} {
+		entry := &tsPoolEntry{factory: droppedClient}
+		attempts := 0
+		err := entry.use(func(client *ts3QueryClient) error {
+			attempts++
+			_, err := client.command(tc.command)
+			return err
+		})
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("%s: expected the dropped connection error, got %v", tc.command, err)
+		}
+		if attempts != tc.attempts {
+			t.Fatalf("%s: expected %d attempts, got %d", tc.command, tc.attempts, attempts)
+		}
+	}
+}
+
+func TestTsPoolKeySeparatesCredentials(t *testing.T) {
+	payload := map[string]any{"query_bind_ip": "127.0.0.1", "query_port": "10011", "admin_password": "old"}
+	before := tsPoolKeyFromTs3Payload(payload)
+	payload["admin_password"] = "rotated"
+	if after := tsPoolKeyFromTs3Payload(payload); after == before {
+		t.Fatal("expected a rotated password to select a new pool entry")
+	}
+}
+
 func TestTsQueryClientHasCommandDeadline(t *testing.T) {
 	client := &ts3QueryClient{commandTimeout: time.Second, conn: &deadlineRecorderConn{}}
 	client.setDeadline()
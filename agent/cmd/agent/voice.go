@@ -35,15 +35,15 @@ func handleVoiceProbe(job jobs.Job) (jobs.Result, func() error) {
 		}, Completed: time.Now().UTC()}, nil
 	}
 
-	correlationID := payloadValue(job.Payload, "correlation_id", "request_id", "trace_id")
+	correlationID := payloadValue(job.Payload, "correlation_id")
 	if correlationID == "" {
 		correlationID = "voice-" + job.ID
 	}
 	target := voiceTarget{
 		provider: provider,
-		host:     payloadValue(job.Payload, "query_host", "host"),
-		port:     payloadValue(job.Payload, "query_port", "port"),
-		user:     payloadValue(job.Payload, "query_user", "user"),
+		host:     payloadValue(job.Payload, "query_host"),
+		port:     payloadValue(job.Payload, "query_port"),
+		user:     payloadValue(job.Payload, "query_user"),
 		endpoint: payloadValue(job.Payload, "query_endpoint"),
 	}
 	if target.host == "" {
@@ -27,15 +27,15 @@ func handleWebspaceCreate(job jobs.Job) (jobs.Result, func() error) {
 		return handleWebspaceCreateWindows(job)
 	}
 
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	docroot := payloadValue(job.Payload, "docroot", "document_root")
-	ownerUser := payloadValue(job.Payload, "owner_user", "user")
-	ownerGroup := payloadValue(job.Payload, "owner_group", "group")
-	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path", "fpm_pool_path")
-	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen", "fpm_listen")
-	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path", "nginx_include")
+	webRoot := payloadValue(job.Payload, "web_root")
+	docroot := payloadValue(job.Payload, "docroot")
+	ownerUser := payloadValue(job.Payload, "owner_user")
+	ownerGroup := payloadValue(job.Payload, "owner_group")
+	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path")
+	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen")
+	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path")
 	phpVersion := payloadValue(job.Payload, "php_version")
-	poolName := payloadValue(job.Payload, "pool_name", "php_fpm_pool_name")
+	poolName := payloadValue(job.Payload, "pool_name")
 	phpSettings := payloadPhpSettings(job.Payload)
 	nginxSocketUser, nginxSocketGroup := detectNginxSocketIdentity()
 
@@ -159,8 +159,8 @@ func handleWebspaceCreate(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleWebspaceCreateWindows(job jobs.Job) (jobs.Result, func() error) {
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	docroot := payloadValue(job.Payload, "docroot", "document_root")
+	webRoot := payloadValue(job.Payload, "web_root")
+	docroot := payloadValue(job.Payload, "docroot")
 	if docroot == "" && webRoot != "" {
 		docroot = filepath.Join(webRoot, "public")
 	}
@@ -208,11 +208,11 @@ func handleWebspaceCreateWindows(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleWebspaceDelete(job jobs.Job) (jobs.Result, func() error) {
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	ownerUser := payloadValue(job.Payload, "owner_user", "user")
-	ownerGroup := payloadValue(job.Payload, "owner_group", "group")
-	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path", "fpm_pool_path")
-	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path", "nginx_include")
+	webRoot := payloadValue(job.Payload, "web_root")
+	ownerUser := payloadValue(job.Payload, "owner_user")
+	ownerGroup := payloadValue(job.Payload, "owner_group")
+	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path")
+	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path")
 
 	missing := missingValues([]requiredValue{
 		{key: "web_root", value: webRoot},
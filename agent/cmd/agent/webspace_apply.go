@@ -74,10 +74,10 @@ func handleWebspaceDomainApplyWindows(job jobs.Job) (jobs.Result, func() error)
 	webRoot := payloadValue(job.Payload, "web_root")
 	targetPath := payloadValue(job.Payload, "target_path")
 	domainName := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "domain")))
-	serverAliases := strings.TrimSpace(payloadValue(job.Payload, "server_aliases", "aliases"))
-	docroot := strings.TrimSpace(payloadValue(job.Payload, "docroot", "document_root"))
+	serverAliases := strings.TrimSpace(payloadValue(job.Payload, "server_aliases"))
+	docroot := strings.TrimSpace(payloadValue(job.Payload, "docroot"))
 	action := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "action")))
-	siteName := strings.TrimSpace(payloadValue(job.Payload, "site_name", "iis_site_name", "webspace_id"))
+	siteName := strings.TrimSpace(payloadValue(job.Payload, "site_name", "webspace_id"))
 	if siteName == "" {
 		siteName = domainName
 	}
@@ -185,10 +185,10 @@ func handleWebspaceDomainApply(job jobs.Job) (jobs.Result, func() error) {
 	webRoot := payloadValue(job.Payload, "web_root")
 	targetPath := payloadValue(job.Payload, "target_path")
 	domainName := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "domain")))
-	serverAliases := strings.TrimSpace(payloadValue(job.Payload, "server_aliases", "aliases"))
-	docroot := strings.TrimSpace(payloadValue(job.Payload, "docroot", "document_root"))
-	phpFpmListen := strings.TrimSpace(payloadValue(job.Payload, "php_fpm_listen", "fpm_listen"))
-	directivesRaw := payloadValue(job.Payload, "extra_directives", "nginx_directives")
+	serverAliases := strings.TrimSpace(payloadValue(job.Payload, "server_aliases"))
+	docroot := strings.TrimSpace(payloadValue(job.Payload, "docroot"))
+	phpFpmListen := strings.TrimSpace(payloadValue(job.Payload, "php_fpm_listen"))
+	directivesRaw := payloadValue(job.Payload, "extra_directives")
 	redirectHTTPS := payloadValue(job.Payload, "redirect_https") == "1" || strings.EqualFold(payloadValue(job.Payload, "redirect_https"), "true")
 	runtimeType := strings.ToLower(payloadValue(job.Payload, "runtime"))
 	action := strings.ToLower(strings.TrimSpace(payloadValue(job.Payload, "action")))
@@ -205,7 +205,7 @@ func handleWebspaceDomainApply(job jobs.Job) (jobs.Result, func() error) {
 	defer release()
 
 	if action == "remove" {
-		vhost := strings.TrimSpace(payloadValue(job.Payload, "nginx_vhost_path", "vhost_path"))
+		vhost := strings.TrimSpace(payloadValue(job.Payload, "nginx_vhost_path"))
 		if vhost != "" {
 			if err := validateNginxVhostPath(vhost); err != nil {
 				return webspaceApplyFailure(job.ID, "invalid_vhost_path", err.Error()), nil
@@ -256,7 +256,7 @@ func handleWebspaceDomainApply(job jobs.Job) (jobs.Result, func() error) {
 			return webspaceApplyFailure(job.ID, "forbidden_directive", err.Error()), nil
 		}
 
-		vhost := strings.TrimSpace(payloadValue(job.Payload, "nginx_vhost_path", "vhost_path"))
+		vhost := strings.TrimSpace(payloadValue(job.Payload, "nginx_vhost_path"))
 		if vhost == "" {
 			vhost = filepath.Join(nginxVhostBaseDir, domainName+".conf")
 		}
@@ -101,7 +101,7 @@ func handleWebspaceFileRead(job jobs.Job) (jobs.Result, func() error) {
 		return errResult, nil
 	}
 	defer release()
-	filename := payloadValue(job.Payload, "name", "file")
+	filename := payloadValue(job.Payload, "name")
 	if filename == "" {
 		return webspaceFileFailure(job.ID, "invalid_payload", fmt.Errorf("missing required values: name")), nil
 	}
@@ -151,8 +151,8 @@ func handleWebspaceFileWrite(job jobs.Job) (jobs.Result, func() error) {
 		return errResult, nil
 	}
 	defer release()
-	filename := payloadValue(job.Payload, "name", "file")
-	contentEncoded := payloadValue(job.Payload, "content_base64", "content")
+	filename := payloadValue(job.Payload, "name")
+	contentEncoded := payloadValue(job.Payload, "content_base64")
 	if filename == "" {
 		return webspaceFileFailure(job.ID, "invalid_payload", fmt.Errorf("missing required values: name")), nil
 	}
@@ -208,7 +208,7 @@ func handleWebspaceFileDelete(job jobs.Job) (jobs.Result, func() error) {
 		return errResult, nil
 	}
 	defer release()
-	filename := payloadValue(job.Payload, "name", "file")
+	filename := payloadValue(job.Payload, "name")
 	if filename == "" {
 		return webspaceFileFailure(job.ID, "invalid_payload", fmt.Errorf("missing required values: name")), nil
 	}
@@ -248,7 +248,7 @@ func handleWebspaceFileMkdir(job jobs.Job) (jobs.Result, func() error) {
 		return errResult, nil
 	}
 	defer release()
-	dirName := payloadValue(job.Payload, "name", "directory")
+	dirName := payloadValue(job.Payload, "name")
 	if dirName == "" {
 		return webspaceFileFailure(job.ID, "invalid_payload", fmt.Errorf("missing required values: name")), nil
 	}
@@ -346,8 +346,8 @@ func rootRemoveAll(r *os.Root, name string) error {
 }
 
 func prepareWebspaceFileOperation(job jobs.Job, readOnly bool) (string, string, webspaceFilePolicy, func(), jobs.Result, bool) {
-	rootPath := payloadValue(job.Payload, "root_path", "web_root")
-	relativePath := payloadValue(job.Payload, "path", "dir")
+	rootPath := payloadValue(job.Payload, "root_path")
+	relativePath := payloadValue(job.Payload, "path")
 	if rootPath == "" {
 		return "", "", webspaceFilePolicy{}, func() {}, webspaceFileFailureNow(job.ID, "invalid_payload", "missing required values: root_path"), false
 	}
@@ -385,7 +385,7 @@ func parseWebspaceFilePolicy(payload map[string]any) webspaceFilePolicy {
 	if maxEntries <= 0 {
 		maxEntries = webspaceFilesDefaultEntryLimit
 	}
-	return webspaceFilePolicy{acl: strings.ToLower(strings.TrimSpace(payloadValue(payload, "acl", "file_acl"))), timeout: time.Duration(timeout) * time.Millisecond, maxBytes: maxBytes, maxEntries: maxEntries}
+	return webspaceFilePolicy{acl: strings.ToLower(strings.TrimSpace(payloadValue(payload, "acl"))), timeout: time.Duration(timeout) * time.Millisecond, maxBytes: maxBytes, maxEntries: maxEntries}
 }
 
 func ensureWebspaceFileACL(acl string, readOnly bool) error {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net/url"
 	"os"
@@ -78,15 +79,15 @@ func validateGitRepoURL(rawURL string) error {
 const webspaceBackupDir = "/var/lib/easywi/web/backups"
 
 func handleWebspaceUpdate(job jobs.Job) (jobs.Result, func() error) {
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	docroot := payloadValue(job.Payload, "docroot", "document_root")
-	ownerUser := payloadValue(job.Payload, "owner_user", "user")
-	ownerGroup := payloadValue(job.Payload, "owner_group", "group")
-	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path", "fpm_pool_path")
-	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen", "fpm_listen")
-	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path", "nginx_include")
+	webRoot := payloadValue(job.Payload, "web_root")
+	docroot := payloadValue(job.Payload, "docroot")
+	ownerUser := payloadValue(job.Payload, "owner_user")
+	ownerGroup := payloadValue(job.Payload, "owner_group")
+	phpFpmPoolPath := payloadValue(job.Payload, "php_fpm_pool_path")
+	phpFpmListen := payloadValue(job.Payload, "php_fpm_listen")
+	nginxIncludePath := payloadValue(job.Payload, "nginx_include_path")
 	phpVersion := payloadValue(job.Payload, "php_version")
-	poolName := payloadValue(job.Payload, "pool_name", "php_fpm_pool_name")
+	poolName := payloadValue(job.Payload, "pool_name")
 	logsDir := payloadValue(job.Payload, "logs_dir")
 	tmpDir := payloadValue(job.Payload, "tmp_dir")
 
@@ -161,9 +162,9 @@ func handleWebspaceUpdate(job jobs.Job) (jobs.Result, func() error) {
 	}, nil
 }
 
-func handleWebspaceBackup(job jobs.Job) (jobs.Result, func() error) {
-	webRoot := payloadValue(job.Payload, "web_root", "path")
-	label := payloadValue(job.Payload, "label", "backup_label")
+func handleWebspaceBackup(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+	webRoot := payloadValue(job.Payload, "web_root")
+	label := payloadValue(job.Payload, "label")
 	webspaceID := payloadValue(job.Payload, "webspace_id")
 
 	if webRoot == "" {
@@ -173,6 +174,11 @@ func handleWebspaceBackup(job jobs.Job) (jobs.Result, func() error) {
 		return failureResult(job.ID, err)
 	}
 
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+
 	if label == "" {
 		label = time.Now().UTC().Format("20060102-150405")
 	}
@@ -184,10 +190,29 @@ func handleWebspaceBackup(job jobs.Job) (jobs.Result, func() error) {
 		return failureResult(job.ID, fmt.Errorf("create backup dir: %w", err))
 	}
 
-	backupPath := filepath.Join(backupRoot, fmt.Sprintf("webspace-%s.tar.gz", label))
-	if err := runCommand("tar", "-czf", backupPath, "-C", filepath.Dir(webRoot), filepath.Base(webRoot)); err != nil {
+	backupPath, err := resumableStagingArchive(job.Payload, backupRoot, target != nil)
+	if err != nil {
 		return failureResult(job.ID, err)
 	}
+	if backupPath == "" {
+		backupPath = filepath.Join(backupRoot, fmt.Sprintf("webspace-%s.tar.gz", label))
+		if err := runCommand("tar", "-czf", backupPath, "-C", filepath.Dir(webRoot), filepath.Base(webRoot)); err != nil {
+			return failureResult(job.ID, err)
+		}
+	}
+
+	if target != nil {
+		// Webspaces share one remote folder, so the remote name carries the id.
+		remoteName := filepath.Base(backupPath)
+		if webspaceID != "" {
+			remoteName = fmt.Sprintf("webspace-%s-%s", sanitizeIdentifier(webspaceID), strings.TrimPrefix(remoteName, "webspace-"))
+		}
+		remotePath, result := uploadBackupArchive(ctx, job, target, backupPath, remoteName)
+		if result != nil {
+			return *result, nil
+		}
+		backupPath = remotePath
+	}
 
 	return jobs.Result{
 		JobID:  job.ID,
@@ -200,8 +225,8 @@ func handleWebspaceBackup(job jobs.Job) (jobs.Result, func() error) {
 	}, nil
 }
 
-func handleWebspaceRestore(job jobs.Job) (jobs.Result, func() error) {
-	webRoot := payloadValue(job.Payload, "web_root", "path")
+func handleWebspaceRestore(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+	webRoot := payloadValue(job.Payload, "web_root")
 	backupPath := payloadValue(job.Payload, "backup_path")
 
 	if webRoot == "" || backupPath == "" {
@@ -210,11 +235,23 @@ func handleWebspaceRestore(job jobs.Job) (jobs.Result, func() error) {
 	if err := validateWebspaceRoot(webRoot); err != nil {
 		return failureResult(job.ID, err)
 	}
-	if err := validateBackupPath(backupPath); err != nil {
+	target, err := newBackupTarget(job.Payload)
+	if err != nil {
+		return failureResult(job.ID, err)
+	}
+	archivePath := backupPath
+	if target != nil {
+		localPath, cleanup, err := fetchBackupArchive(ctx, target, backupPath)
+		if err != nil {
+			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"error": err.Error(), "error_code": backupTargetErrorCode(err)}, Completed: time.Now().UTC()}, nil
+		}
+		defer cleanup()
+		archivePath = localPath
+	} else if err := validateBackupPath(backupPath); err != nil {
 		return failureResult(job.ID, err)
 	}
 
-	if err := runCommand("tar", "-xzf", backupPath, "-C", filepath.Dir(webRoot)); err != nil {
+	if err := runCommand("tar", "-xzf", archivePath, "-C", filepath.Dir(webRoot)); err != nil {
 		return failureResult(job.ID, err)
 	}
 
@@ -264,8 +301,8 @@ func handleWebspaceLogsTail(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleWebspaceCronUpdate(job jobs.Job) (jobs.Result, func() error) {
-	username := payloadValue(job.Payload, "owner_user", "username", "user")
-	cronTasks := payloadValue(job.Payload, "cron_tasks", "tasks")
+	username := payloadValue(job.Payload, "owner_user")
+	cronTasks := payloadValue(job.Payload, "cron_tasks")
 
 	if username == "" {
 		return failureResult(job.ID, fmt.Errorf("missing owner_user"))
@@ -299,10 +336,10 @@ func handleWebspaceCronUpdate(job jobs.Job) (jobs.Result, func() error) {
 	}, nil
 }
 
-func handleWebspaceGitDeploy(job jobs.Job) (jobs.Result, func() error) {
-	repoURL := payloadValue(job.Payload, "repo_url", "git_repo_url")
-	branch := payloadValue(job.Payload, "branch", "git_branch")
-	docroot := payloadValue(job.Payload, "docroot", "document_root")
+func handleWebspaceGitDeploy(ctx context.Context, job jobs.Job) (jobs.Result, func() error) {
+	repoURL := payloadValue(job.Payload, "repo_url")
+	branch := payloadValue(job.Payload, "branch")
+	docroot := payloadValue(job.Payload, "docroot")
 
 	if repoURL == "" || docroot == "" {
 		return failureResult(job.ID, fmt.Errorf("missing repo_url or docroot"))
@@ -319,17 +356,23 @@ func handleWebspaceGitDeploy(job jobs.Job) (jobs.Result, func() error) {
 	}
 
 	if _, err := os.Stat(filepath.Join(docroot, ".git")); err == nil {
-		if err := runCommand("git", "-C", docroot, "fetch", "--all"); err != nil {
+		if err := runJobCommand(ctx, "git", "-C", docroot, "fetch", "--all"); err != nil {
 			return failureResult(job.ID, err)
 		}
-		if err := runCommand("git", "-C", docroot, "checkout", branch); err != nil {
+		if err := runJobCommand(ctx, "git", "-C", docroot, "checkout", branch); err != nil {
 			return failureResult(job.ID, err)
 		}
-		if err := runCommand("git", "-C", docroot, "pull", "--ff-only", "origin", branch); err != nil {
+		if err := runJobCommand(ctx, "git", "-C", docroot, "pull", "--ff-only", "origin", branch); err != nil {
 			return failureResult(job.ID, err)
 		}
 	} else {
-		if err := runCommand("git", "clone", "--branch", branch, repoURL, docroot); err != nil {
+		_, statErr := os.Stat(docroot)
+		docrootExisted := statErr == nil
+		if err := runJobCommand(ctx, "git", "clone", "--branch", branch, repoURL, docroot); err != nil {
+			if isJobCancelled(err) && !docrootExisted {
+				// Do not leave a half-cloned checkout behind for the next deploy.
+				_ = os.RemoveAll(docroot)
+			}
 			return failureResult(job.ID, err)
 		}
 	}
@@ -347,7 +390,7 @@ func handleWebspaceGitDeploy(job jobs.Job) (jobs.Result, func() error) {
 }
 
 func handleWebspaceComposerInstall(job jobs.Job) (jobs.Result, func() error) {
-	docroot := payloadValue(job.Payload, "docroot", "document_root")
+	docroot := payloadValue(job.Payload, "docroot")
 	if docroot == "" {
 		return failureResult(job.ID, fmt.Errorf("missing docroot"))
 	}
@@ -387,8 +430,8 @@ func handleRoundcubeInstall(job jobs.Job) (jobs.Result, func() error) {
 			return jobs.Result{JobID: job.ID, Status: "failed", Output: map[string]string{"success": "false", "step": "detect_system_roundcube", "message": detectErr.Error()}, Completed: time.Now().UTC()}, nil
 		}
 	}
-	domain := payloadValue(job.Payload, "domain", "hostname", "name")
-	route := payloadValue(job.Payload, "route", "path", "webmail_path")
+	domain := payloadValue(job.Payload, "domain")
+	route := payloadValue(job.Payload, "route")
 	if route == "" {
 		route = "/roundcube"
 	}
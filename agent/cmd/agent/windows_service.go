@@ -26,7 +26,7 @@ func handleWindowsServiceAction(job jobs.Job, action string) (jobs.Result, func(
 		return failureResult(job.ID, fmt.Errorf("windows service control is only supported on Windows agents"))
 	}
 
-	serviceName := payloadValue(job.Payload, "service_name", "name")
+	serviceName := payloadValue(job.Payload, "service_name")
 	if serviceName == "" {
 		return failureResult(job.ID, fmt.Errorf("missing service_name"))
 	}
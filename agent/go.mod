@@ -3,6 +3,7 @@ module easywi/agent
 go 1.25.11
 
 require (
+	filippo.io/age v1.2.1
 	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/creack/pty v1.1.24
 	github.com/gliderlabs/ssh v0.3.8
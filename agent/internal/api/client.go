@@ -161,6 +161,18 @@ func (c *Client) SubmitJobResult(ctx context.Context, result jobs.Result) error
 	return err
 }
 
+// SubmitScheduledRun reports a job the agent ran from its local schedule.
+func (c *Client) SubmitScheduledRun(ctx context.Context, run jobs.ScheduledRun) error {
+	_, err := c.doSignedJSON(ctx, http.MethodPost, "/agent/scheduled-runs", run, nil)
+	return err
+}
+
+// SendTsEvents forwards a batch of TeamSpeak ServerQuery notifications.
+func (c *Client) SendTsEvents(ctx context.Context, events []map[string]any) error {
+	_, err := c.doSignedJSON(ctx, http.MethodPost, "/agent/ts-events", map[string]any{"events": events}, nil)
+	return err
+}
+
 // StartJob marks a core job as running.
 func (c *Client) StartJob(ctx context.Context, jobID string) error {
 	path := fmt.Sprintf("/agent/jobs/%s/start", url.PathEscape(jobID))
@@ -301,7 +313,7 @@ func (c *Client) doUnsignedJSON(ctx context.Context, method, path string, body a
 	}()
 	if resp.StatusCode >= http.StatusBadRequest {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return resp, fmt.Errorf("api error %s: %s", resp.Status, string(bodyBytes))
+		return resp, newError(resp, bodyBytes, time.Now())
 	}
 	if out != nil {
 		decoder := json.NewDecoder(resp.Body)
@@ -355,30 +367,12 @@ func (c *Client) doSignedJSONWithSecret(ctx context.Context, method, path string
 		if body != nil {
 			req.Header.Set("Content-Type", "application/json")
 		}
-		nonce, nonceErr := agentcrypto.NewNonce()
-		if nonceErr != nil {
-			return nil, nonceErr
-		}
-		headers, signErr := agentcrypto.Sign(c.AgentID, secret, method, requestPath.Path, requestBody, time.Now(), nonce)
-		if signErr != nil {
+		if signErr := c.signHeaders(ctx, req.Header, secret, method, requestPath.Path, requestBody); signErr != nil {
 			return nil, signErr
 		}
-		req.Header.Set("X-Agent-ID", headers.AgentID)
-		req.Header.Set("X-Timestamp", headers.Timestamp)
-		req.Header.Set("X-Nonce", headers.Nonce)
-		req.Header.Set("X-Signature", headers.Signature)
-		jwtToken, jwtErr := agentcrypto.BuildAgentJWT(secret, c.AgentID, c.JWTIssuer, c.JWTAudience, headers.Nonce, time.Now(), time.Minute)
-		if jwtErr != nil {
-			return nil, fmt.Errorf("build jwt: %w", jwtErr)
-		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-		req.Header.Set("User-Agent", c.UserAgent)
 		if idempotencyKey != "" {
 			req.Header.Set("Idempotency-Key", idempotencyKey)
 		}
-		requestID, correlationID := trace.IDsFromContext(ctx)
-		req.Header.Set("X-Request-ID", requestID)
-		req.Header.Set("X-Correlation-ID", correlationID)
 		return req, nil
 	}
 	retryClass := classifyRetry(method, idempotencyKey)
@@ -394,7 +388,7 @@ func (c *Client) doSignedJSONWithSecret(ctx context.Context, method, path string
 
 	if resp.StatusCode >= http.StatusBadRequest {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return resp, fmt.Errorf("api error %s: %s", resp.Status, string(bodyBytes))
+		return resp, newError(resp, bodyBytes, time.Now())
 	}
 
 	if out != nil {
@@ -407,6 +401,33 @@ func (c *Client) doSignedJSONWithSecret(ctx context.Context, method, path string
 	return resp, nil
 }
 
+// signHeaders sets the HMAC signature, agent JWT, user agent and trace headers
+// shared by every signed request.
+func (c *Client) signHeaders(ctx context.Context, header http.Header, secret, method, path string, body []byte) error {
+	nonce, err := agentcrypto.NewNonce()
+	if err != nil {
+		return err
+	}
+	headers, err := agentcrypto.Sign(c.AgentID, secret, method, path, body, time.Now(), nonce)
+	if err != nil {
+		return err
+	}
+	header.Set("X-Agent-ID", headers.AgentID)
+	header.Set("X-Timestamp", headers.Timestamp)
+	header.Set("X-Nonce", headers.Nonce)
+	header.Set("X-Signature", headers.Signature)
+	jwtToken, err := agentcrypto.BuildAgentJWT(secret, c.AgentID, c.JWTIssuer, c.JWTAudience, headers.Nonce, time.Now(), time.Minute)
+	if err != nil {
+		return fmt.Errorf("build jwt: %w", err)
+	}
+	header.Set("Authorization", "Bearer "+jwtToken)
+	header.Set("User-Agent", c.UserAgent)
+	requestID, correlationID := trace.IDsFromContext(ctx)
+	header.Set("X-Request-ID", requestID)
+	header.Set("X-Correlation-ID", correlationID)
+	return nil
+}
+
 func (c *Client) doSignedRaw(ctx context.Context, method, path string, body []byte, extraHeaders map[string]string, out any) (resp *http.Response, err error) {
 	requestPath, err := url.Parse(path)
 	if err != nil {
@@ -425,27 +446,9 @@ func (c *Client) doSignedRaw(ctx context.Context, method, path string, body []by
 		if buildErr != nil {
 			return nil, fmt.Errorf("build request: %w", buildErr)
 		}
-		nonce, nonceErr := agentcrypto.NewNonce()
-		if nonceErr != nil {
-			return nil, nonceErr
-		}
-		headers, signErr := agentcrypto.Sign(c.AgentID, c.Secret, method, requestPath.Path, body, time.Now(), nonce)
-		if signErr != nil {
+		if signErr := c.signHeaders(ctx, req.Header, c.Secret, method, requestPath.Path, body); signErr != nil {
 			return nil, signErr
 		}
-		req.Header.Set("X-Agent-ID", headers.AgentID)
-		req.Header.Set("X-Timestamp", headers.Timestamp)
-		req.Header.Set("X-Nonce", headers.Nonce)
-		req.Header.Set("X-Signature", headers.Signature)
-		jwtToken, jwtErr := agentcrypto.BuildAgentJWT(c.Secret, c.AgentID, c.JWTIssuer, c.JWTAudience, headers.Nonce, time.Now(), time.Minute)
-		if jwtErr != nil {
-			return nil, fmt.Errorf("build jwt: %w", jwtErr)
-		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-		req.Header.Set("User-Agent", c.UserAgent)
-		requestID, correlationID := trace.IDsFromContext(ctx)
-		req.Header.Set("X-Request-ID", requestID)
-		req.Header.Set("X-Correlation-ID", correlationID)
 		if idempotencyKey != "" {
 			req.Header.Set("Idempotency-Key", idempotencyKey)
 		}
@@ -466,7 +469,7 @@ func (c *Client) doSignedRaw(ctx context.Context, method, path string, body []by
 	}()
 	if resp.StatusCode >= http.StatusBadRequest {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return resp, fmt.Errorf("api error %s: %s", resp.Status, string(bodyBytes))
+		return resp, newError(resp, bodyBytes, time.Now())
 	}
 	if out != nil {
 		decoder := json.NewDecoder(resp.Body)
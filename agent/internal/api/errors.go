@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"easywi/agent/internal/apienvelope"
+)
+
+// Error is returned for every panel response with a 4xx or 5xx status.
+// Code and Message are taken from the apienvelope error body when present.
+type Error struct {
+	StatusCode int
+	Status     string
+	Code       apienvelope.ErrorCode
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api error %s: %s", e.Status, e.Body)
+}
+
+func newError(resp *http.Response, body []byte, now time.Time) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		apiErr.RetryAfter = retryAfter
+	}
+
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+		Code  string          `json:"code"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return apiErr
+	}
+	var detail struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(envelope.Error, &detail); err == nil {
+		apiErr.Code = apienvelope.ErrorCode(strings.ToUpper(strings.TrimSpace(detail.Code)))
+		apiErr.Message = detail.Message
+		apiErr.RequestID = detail.RequestID
+		return apiErr
+	}
+	// Older panel endpoints answer {"error": "message", "code": "..."}.
+	var message string
+	if err := json.Unmarshal(envelope.Error, &message); err == nil {
+		apiErr.Message = message
+	}
+	apiErr.Code = apienvelope.ErrorCode(strings.ToUpper(strings.TrimSpace(envelope.Code)))
+	return apiErr
+}
+
+// AsError unwraps err to the panel error it carries, if any.
+func AsError(err error) (*Error, bool) {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsAuthFailure reports whether the panel rejected the agent credentials.
+func IsAuthFailure(err error) bool {
+	apiErr, ok := AsError(err)
+	return ok && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.Code == apienvelope.ErrorUnauthorized)
+}
+
+// IsForbidden reports whether the credentials were accepted but the agent is
+// not allowed to perform the request, e.g. because it is suspended.
+func IsForbidden(err error) bool {
+	apiErr, ok := AsError(err)
+	return ok && apiErr.StatusCode == http.StatusForbidden && apiErr.Code != apienvelope.ErrorAgentDeregistered
+}
+
+// IsDeregistered reports whether the panel no longer knows this agent.
+func IsDeregistered(err error) bool {
+	apiErr, ok := AsError(err)
+	return ok && (apiErr.StatusCode == http.StatusGone || apiErr.Code == apienvelope.ErrorAgentDeregistered)
+}
+
+// IsConflict reports whether the panel refused the request because of a conflicting state.
+func IsConflict(err error) bool {
+	apiErr, ok := AsError(err)
+	return ok && apiErr.StatusCode == http.StatusConflict
+}
+
+// RetryAfter reports how long to wait before the next request when the panel
+// is rate limiting or temporarily unavailable. ok is false for other errors.
+func RetryAfter(err error) (time.Duration, bool) {
+	apiErr, ok := AsError(err)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusTooManyRequests, apiErr.Code == apienvelope.ErrorRateLimited:
+		return apiErr.RetryAfter, true
+	case apiErr.StatusCode == http.StatusServiceUnavailable && apiErr.RetryAfter > 0:
+		return apiErr.RetryAfter, true
+	default:
+		return 0, false
+	}
+}
+
+// IsPermanent reports whether repeating the same request cannot succeed.
+// Authentication, timeout and rate-limit statuses are excluded because they
+// clear up without the request changing.
+func IsPermanent(err error) bool {
+	apiErr, ok := AsError(err)
+	if !ok || apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"easywi/agent/internal/apienvelope"
+)
+
+func TestClientReturnsTypedErrorWithEnvelope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apienvelope.WriteError(w, r, http.StatusGone, apienvelope.ErrorAgentDeregistered, "agent removed", nil)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "agent-1", "secret", "test")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	err = client.SendHeartbeat(context.Background(), map[string]any{}, nil, nil, "online")
+	apiErr, ok := AsError(err)
+	if !ok {
+		t.Fatalf("expected typed api error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusGone || apiErr.Code != apienvelope.ErrorAgentDeregistered || apiErr.Message != "agent removed" {
+		t.Fatalf("unexpected api error: %#v", apiErr)
+	}
+	if !IsDeregistered(err) || IsAuthFailure(err) || !IsPermanent(err) {
+		t.Fatalf("unexpected classification for %v", err)
+	}
+}
+
+func TestClientReturnsRetryAfterOnRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "42")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"error":"slow down","code":"rate_limited"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "agent-1", "secret", "test")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	// POST without retries so the test does not wait for the advertised delay.
+	client.RetryPolicy.MaxAttempts = 1
+	err = client.StartJob(context.Background(), "job-1")
+	wait, ok := RetryAfter(err)
+	if !ok || wait != 42*time.Second {
+		t.Fatalf("expected 42s retry-after, got %v (%v)", wait, err)
+	}
+	apiErr, _ := AsError(err)
+	if apiErr.Code != apienvelope.ErrorRateLimited || apiErr.Message != "slow down" {
+		t.Fatalf("expected legacy error body to be parsed, got %#v", apiErr)
+	}
+	if IsPermanent(err) {
+		t.Fatal("expected rate limit not to be permanent")
+	}
+}
+
+func TestErrorPredicatesSeeThroughWrapping(t *testing.T) {
+	unauthorized := fmt.Errorf("poll: %w", &Error{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"})
+	if !IsAuthFailure(unauthorized) || IsPermanent(unauthorized) {
+		t.Fatalf("expected wrapped 401 to be an auth failure, got %v", unauthorized)
+	}
+	forbidden := &Error{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}
+	if !IsForbidden(forbidden) || IsAuthFailure(forbidden) {
+		t.Fatal("expected 403 to be forbidden but not an auth failure")
+	}
+	if IsAuthFailure(errors.New("unauthorized access to /srv")) {
+		t.Fatal("expected plain errors mentioning unauthorized to be ignored")
+	}
+	if _, ok := RetryAfter(&Error{StatusCode: http.StatusServiceUnavailable, Status: "503"}); ok {
+		t.Fatal("expected 503 without Retry-After not to request a back-off")
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"easywi/agent/internal/jobs"
+)
+
+const (
+	pushPingInterval = 30 * time.Second
+	pushReadTimeout  = 90 * time.Second
+	pushWriteTimeout = 10 * time.Second
+)
+
+// Push message types sent by the panel.
+const (
+	PushMessageJobs = "jobs"
+	PushMessagePing = "ping"
+)
+
+// PushMessage is one frame received on the job push channel. Jobs are the
+// same core jobs PollJobs returns, AgentJobs the orchestrator jobs of PollAgentJobs.
+type PushMessage struct {
+	Type          string     `json:"type"`
+	Jobs          []jobs.Job `json:"jobs,omitempty"`
+	AgentJobs     []jobs.Job `json:"agent_jobs,omitempty"`
+	MaxConcurrent int        `json:"max_concurrency,omitempty"`
+}
+
+// JobStream is an open push channel. It is not safe for concurrent reads.
+type JobStream struct {
+	conn      *websocket.Conn
+	writeMu   sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenJobStream opens the WebSocket job push channel. The handshake carries
+// the same HMAC and JWT headers as a signed GET so the panel authenticates it
+// like any other agent request. Handshake rejections are returned as *Error.
+func (c *Client) OpenJobStream(ctx context.Context) (*JobStream, error) {
+	requestPath, err := url.Parse(fmt.Sprintf("/agent/%s/jobs/stream", url.PathEscape(c.AgentID)))
+	if err != nil {
+		return nil, fmt.Errorf("parse stream path: %w", err)
+	}
+	streamURL := c.BaseURL.ResolveReference(requestPath)
+	switch streamURL.Scheme {
+	case "https":
+		streamURL.Scheme = "wss"
+	case "http":
+		streamURL.Scheme = "ws"
+	}
+
+	header := http.Header{}
+	if err := c.signHeaders(ctx, header, c.Secret, http.MethodGet, requestPath.Path, nil); err != nil {
+		return nil, err
+	}
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.RetryPolicy.RequestTimeout,
+	}
+	if transport, ok := c.Client.Transport.(*http.Transport); ok && transport.TLSClientConfig != nil {
+		dialer.TLSClientConfig = transport.TLSClientConfig.Clone()
+	}
+	conn, resp, err := dialer.DialContext(ctx, streamURL.String(), header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+			if resp.StatusCode >= http.StatusBadRequest {
+				return nil, newError(resp, body, time.Now())
+			}
+		}
+		return nil, fmt.Errorf("open job stream: %w", err)
+	}
+
+	stream := &JobStream{conn: conn, done: make(chan struct{})}
+	_ = conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+	})
+	conn.SetPingHandler(func(data string) error {
+		_ = conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+		stream.writeMu.Lock()
+		defer stream.writeMu.Unlock()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(pushWriteTimeout))
+	})
+	go stream.keepAlive()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = stream.Close()
+		case <-stream.done:
+		}
+	}()
+	return stream, nil
+}
+
+// Next blocks until the panel sends a message. Ping messages only extend the
+// read deadline and are not returned.
+func (s *JobStream) Next() (PushMessage, error) {
+	for {
+		var message PushMessage
+		if err := s.conn.ReadJSON(&message); err != nil {
+			return PushMessage{}, fmt.Errorf("read job stream: %w", err)
+		}
+		_ = s.conn.SetReadDeadline(time.Now().Add(pushReadTimeout))
+		if message.Type == PushMessagePing {
+			continue
+		}
+		return message, nil
+	}
+}
+
+// Close shuts the channel down; a blocked Next returns with an error.
+func (s *JobStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.writeMu.Lock()
+		_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(pushWriteTimeout))
+		s.writeMu.Unlock()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+func (s *JobStream) keepAlive() {
+	ticker := time.NewTicker(pushPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pushWriteTimeout))
+			s.writeMu.Unlock()
+			if err != nil {
+				_ = s.Close()
+				return
+			}
+		}
+	}
+}
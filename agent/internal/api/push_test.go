@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"easywi/agent/internal/jobs"
+)
+
+func TestOpenJobStreamSignsHandshakeAndSkipsPings(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/agent/agent-1/jobs/stream" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Signature") == "" || r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(PushMessage{Type: PushMessagePing})
+		_ = conn.WriteJSON(PushMessage{Type: PushMessageJobs, Jobs: []jobs.Job{{ID: "job-1", Type: "instance.start"}}, MaxConcurrent: 3})
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "agent-1", "secret", "test")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	stream, err := client.OpenJobStream(context.Background())
+	if err != nil {
+		t.Fatalf("open job stream: %v", err)
+	}
+	defer stream.Close()
+
+	message, err := stream.Next()
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	if message.Type != PushMessageJobs || len(message.Jobs) != 1 || message.Jobs[0].ID != "job-1" || message.MaxConcurrent != 3 {
+		t.Fatalf("unexpected push message: %#v", message)
+	}
+}
+
+func TestOpenJobStreamReturnsTypedHandshakeError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client, err := NewClient(server.URL, "agent-1", "secret", "test")
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	_, err = client.OpenJobStream(context.Background())
+	if apiErr, ok := AsError(err); !ok || apiErr.StatusCode != http.StatusNotFound || !IsPermanent(err) {
+		t.Fatalf("expected permanent 404 api error, got %v", err)
+	}
+}
@@ -18,6 +18,11 @@ const (
 	ErrorNotFound         ErrorCode = "NOT_FOUND"
 	ErrorMethodNotAllowed ErrorCode = "METHOD_NOT_ALLOWED"
 	ErrorInternal         ErrorCode = "INTERNAL_ERROR"
+
+	ErrorUnauthorized      ErrorCode = "UNAUTHORIZED"
+	ErrorForbidden         ErrorCode = "FORBIDDEN"
+	ErrorRateLimited       ErrorCode = "RATE_LIMITED"
+	ErrorAgentDeregistered ErrorCode = "AGENT_DEREGISTERED"
 )
 
 func HTTPStatus(code ErrorCode) int {
@@ -30,6 +35,14 @@ func HTTPStatus(code ErrorCode) int {
 		return http.StatusNotFound
 	case ErrorMethodNotAllowed:
 		return http.StatusMethodNotAllowed
+	case ErrorUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorForbidden:
+		return http.StatusForbidden
+	case ErrorRateLimited:
+		return http.StatusTooManyRequests
+	case ErrorAgentDeregistered:
+		return http.StatusGone
 	default:
 		return http.StatusInternalServerError
 	}
@@ -0,0 +1,289 @@
+// Package backupcrypt encrypts backup archives with age, either to X25519
+// recipient keys or to a passphrase, and keeps a small manifest next to each
+// encrypted archive that records which keys can open it. Encrypted archives
+// are standard age files and can also be opened with the age command line tool.
+package backupcrypt
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+)
+
+const (
+	// EncryptedSuffix is appended to the name of an encrypted archive.
+	EncryptedSuffix = ".age"
+	// ManifestSuffix is appended to the archive name for its manifest.
+	ManifestSuffix = ".manifest.json"
+
+	SchemeX25519     = "age-x25519"
+	SchemePassphrase = "age-scrypt"
+
+	// DefaultPassphraseKeyID names a passphrase key when the panel gives none.
+	DefaultPassphraseKeyID = "passphrase"
+
+	manifestVersion = 1
+	ageMagic        = "age-encryption.org/v1\n"
+)
+
+// scryptWorkFactor is the log2 scrypt cost for passphrase-encrypted archives.
+var scryptWorkFactor = 18
+
+// ErrNoMatchingKey is returned when none of the supplied keys can open an archive.
+var ErrNoMatchingKey = errors.New("no supplied key matches the backup")
+
+// Recipient is a public key an archive is encrypted to.
+type Recipient struct {
+	KeyID     string
+	Recipient string
+}
+
+// Identity is a private key that can open archives encrypted to its recipient.
+type Identity struct {
+	KeyID    string
+	Identity string
+}
+
+// Options selects how a new archive is encrypted. Recipients and a passphrase
+// are mutually exclusive.
+type Options struct {
+	Recipients      []Recipient
+	Passphrase      string
+	PassphraseKeyID string
+}
+
+// Keys are the secrets offered to open an archive.
+type Keys struct {
+	Identities []Identity
+	Passphrase string
+}
+
+// Encryption is the manifest section describing an encrypted archive.
+type Encryption struct {
+	Scheme string   `json:"scheme"`
+	KeyIDs []string `json:"key_ids"`
+}
+
+// Manifest describes an archive.
+type Manifest struct {
+	Version    int         `json:"version"`
+	Format     string      `json:"format"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Encryption *Encryption `json:"encryption,omitempty"`
+}
+
+// Enabled reports whether the options ask for encryption.
+func (o Options) Enabled() bool {
+	return len(o.Recipients) > 0 || o.Passphrase != ""
+}
+
+// Enabled reports whether any key was supplied.
+func (k Keys) Enabled() bool {
+	return len(k.Identities) > 0 || k.Passphrase != ""
+}
+
+// KeyIDForRecipient derives a stable key ID from an age recipient.
+func KeyIDForRecipient(recipient string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(recipient)))
+	return "x25519:" + hex.EncodeToString(sum[:8])
+}
+
+func (o Options) ageRecipients() ([]age.Recipient, Encryption, error) {
+	if len(o.Recipients) > 0 && o.Passphrase != "" {
+		return nil, Encryption{}, fmt.Errorf("backup encryption takes recipients or a passphrase, not both")
+	}
+	if o.Passphrase != "" {
+		recipient, err := age.NewScryptRecipient(o.Passphrase)
+		if err != nil {
+			return nil, Encryption{}, fmt.Errorf("backup passphrase: %w", err)
+		}
+		recipient.SetWorkFactor(scryptWorkFactor)
+		keyID := strings.TrimSpace(o.PassphraseKeyID)
+		if keyID == "" {
+			keyID = DefaultPassphraseKeyID
+		}
+		return []age.Recipient{recipient}, Encryption{Scheme: SchemePassphrase, KeyIDs: []string{keyID}}, nil
+	}
+	recipients := make([]age.Recipient, 0, len(o.Recipients))
+	encryption := Encryption{Scheme: SchemeX25519}
+	for _, entry := range o.Recipients {
+		recipient, err := age.ParseX25519Recipient(strings.TrimSpace(entry.Recipient))
+		if err != nil {
+			return nil, Encryption{}, fmt.Errorf("backup recipient: %w", err)
+		}
+		recipients = append(recipients, recipient)
+		keyID := strings.TrimSpace(entry.KeyID)
+		if keyID == "" {
+			keyID = KeyIDForRecipient(recipient.String())
+		}
+		encryption.KeyIDs = append(encryption.KeyIDs, keyID)
+	}
+	return recipients, encryption, nil
+}
+
+// EncryptFile encrypts src into dst and returns the manifest section for it.
+// dst is written through a temporary file and never left half-written.
+func EncryptFile(src, dst string, opts Options) (Encryption, error) {
+	recipients, encryption, err := opts.ageRecipients()
+	if err != nil {
+		return Encryption{}, err
+	}
+	if len(recipients) == 0 {
+		return Encryption{}, fmt.Errorf("backup encryption needs a recipient or a passphrase")
+	}
+	source, err := os.Open(src)
+	if err != nil {
+		return Encryption{}, err
+	}
+	defer source.Close()
+
+	tmpPath := dst + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Encryption{}, err
+	}
+	writer, err := age.Encrypt(out, recipients...)
+	if err == nil {
+		_, err = io.Copy(writer, source)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return Encryption{}, fmt.Errorf("encrypt backup: %w", err)
+	}
+	return encryption, nil
+}
+
+// IsEncrypted reports whether the file at path is an age file.
+func IsEncrypted(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	header := make([]byte, len(ageMagic))
+	if _, err := io.ReadFull(file, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(header) == ageMagic, nil
+}
+
+// DecryptFile decrypts src into dst. With a manifest the supplied keys are
+// first checked against its key IDs, so a missing key is reported by ID
+// instead of as a generic decryption failure.
+func DecryptFile(src, dst string, keys Keys, manifest *Manifest) error {
+	identities, err := keys.ageIdentities(manifest)
+	if err != nil {
+		return err
+	}
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	reader, err := age.Decrypt(bufio.NewReader(source), identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return fmt.Errorf("%w: %v", ErrNoMatchingKey, err)
+		}
+		return fmt.Errorf("decrypt backup: %w", err)
+	}
+
+	tmpPath := dst + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, reader)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("decrypt backup: %w", err)
+	}
+	return nil
+}
+
+func (k Keys) ageIdentities(manifest *Manifest) ([]age.Identity, error) {
+	required := &Encryption{}
+	if manifest != nil && manifest.Encryption != nil {
+		required = manifest.Encryption
+	}
+	identities := []age.Identity{}
+	matched := len(required.KeyIDs) == 0
+	if k.Passphrase != "" && (required.Scheme == "" || required.Scheme == SchemePassphrase) {
+		identity, err := age.NewScryptIdentity(k.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("backup passphrase: %w", err)
+		}
+		identities = append(identities, identity)
+		matched = true
+	}
+	for _, entry := range k.Identities {
+		identity, err := age.ParseX25519Identity(strings.TrimSpace(entry.Identity))
+		if err != nil {
+			return nil, fmt.Errorf("backup identity: %w", err)
+		}
+		identities = append(identities, identity)
+		// An identity without a key ID may belong to a panel-assigned ID, so
+		// only labelled identities can be ruled out up front.
+		if strings.TrimSpace(entry.KeyID) == "" || slices.Contains(required.KeyIDs, strings.TrimSpace(entry.KeyID)) || slices.Contains(required.KeyIDs, KeyIDForRecipient(identity.Recipient().String())) {
+			matched = true
+		}
+	}
+	if len(identities) == 0 || !matched {
+		return nil, fmt.Errorf("%w (archive keys: %s)", ErrNoMatchingKey, strings.Join(required.KeyIDs, ", "))
+	}
+	return identities, nil
+}
+
+// WriteManifest stores the manifest of the archive at archivePath.
+func WriteManifest(archivePath string, manifest Manifest) error {
+	if manifest.Version == 0 {
+		manifest.Version = manifestVersion
+	}
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(archivePath+ManifestSuffix, encoded, 0o640)
+}
+
+// ReadManifest loads a manifest file.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("decode backup manifest: %w", err)
+	}
+	return &manifest, nil
+}
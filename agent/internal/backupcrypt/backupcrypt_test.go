@@ -0,0 +1,96 @@
+package backupcrypt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestEncryptDecryptWithRecipientRecordsKeyIDs(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "backup.tar.gz")
+	if err := os.WriteFile(plain, []byte("rcon_password=secret"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	customer, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	other, _ := age.GenerateX25519Identity()
+
+	encrypted := plain + EncryptedSuffix
+	encryption, err := EncryptFile(plain, encrypted, Options{Recipients: []Recipient{{Recipient: customer.Recipient().String()}}})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	wantKeyID := KeyIDForRecipient(customer.Recipient().String())
+	if encryption.Scheme != SchemeX25519 || len(encryption.KeyIDs) != 1 || encryption.KeyIDs[0] != wantKeyID {
+		t.Fatalf("encryption=%+v, want key %s", encryption, wantKeyID)
+	}
+	if ok, err := IsEncrypted(encrypted); err != nil || !ok {
+		t.Fatalf("IsEncrypted(encrypted)=%v, %v", ok, err)
+	}
+	if ok, err := IsEncrypted(plain); err != nil || ok {
+		t.Fatalf("IsEncrypted(plain)=%v, %v", ok, err)
+	}
+
+	if err := WriteManifest(encrypted, Manifest{Format: "tar.gz", CreatedAt: time.Now().UTC(), Encryption: &encryption}); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	manifest, err := ReadManifest(encrypted + ManifestSuffix)
+	if err != nil || manifest.Version != manifestVersion {
+		t.Fatalf("read manifest: %+v %v", manifest, err)
+	}
+
+	out := filepath.Join(dir, "restored.tar.gz")
+	err = DecryptFile(encrypted, out, Keys{Identities: []Identity{{Identity: other.String()}}}, manifest)
+	if !errors.Is(err, ErrNoMatchingKey) {
+		t.Fatalf("expected ErrNoMatchingKey for a foreign identity, got %v", err)
+	}
+	if err := DecryptFile(encrypted, out, Keys{Identities: []Identity{{Identity: other.String()}, {Identity: customer.String()}}}, manifest); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "rcon_password=secret" {
+		t.Fatalf("decrypted content %q", data)
+	}
+}
+
+func TestEncryptDecryptWithPassphrase(t *testing.T) {
+	previous := scryptWorkFactor
+	scryptWorkFactor = 10
+	t.Cleanup(func() { scryptWorkFactor = previous })
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "backup.tar.gz")
+	if err := os.WriteFile(plain, []byte("dump"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := EncryptFile(plain, plain+EncryptedSuffix, Options{Recipients: []Recipient{{Recipient: "age1x"}}, Passphrase: "p"}); err == nil {
+		t.Fatal("expected mixing recipients and a passphrase to fail")
+	}
+	encryption, err := EncryptFile(plain, plain+EncryptedSuffix, Options{Passphrase: "correct horse", PassphraseKeyID: "customer-7"})
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if encryption.Scheme != SchemePassphrase || encryption.KeyIDs[0] != "customer-7" {
+		t.Fatalf("encryption=%+v", encryption)
+	}
+	manifest := &Manifest{Encryption: &encryption}
+	out := filepath.Join(dir, "restored.tar.gz")
+	if err := DecryptFile(plain+EncryptedSuffix, out, Keys{Passphrase: "wrong"}, manifest); err == nil {
+		t.Fatal("expected a wrong passphrase to fail")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Fatalf("failed decryption left output behind: %v", err)
+	}
+	if err := DecryptFile(plain+EncryptedSuffix, out, Keys{Passphrase: "correct horse"}, manifest); err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "dump" {
+		t.Fatalf("decrypted content %q", data)
+	}
+}
@@ -0,0 +1,117 @@
+package backupstore
+
+import (
+	"io"
+)
+
+// Default content-defined chunk bounds. Chunk boundaries depend only on the
+// data, so an insertion in a large world file only changes the chunks around
+// it and everything else deduplicates against the previous snapshot.
+const (
+	defaultMinChunkSize = 512 << 10
+	defaultAvgChunkSize = 1 << 20
+	defaultMaxChunkSize = 4 << 20
+)
+
+type chunkParams struct {
+	min, avg, max int
+}
+
+var defaultChunkParams = chunkParams{min: defaultMinChunkSize, avg: defaultAvgChunkSize, max: defaultMaxChunkSize}
+
+// gearTable drives the rolling hash. It must never change: a different table
+// moves every chunk boundary and defeats deduplication against existing
+// repositories.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x45617379574921)
+	for idx := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[idx] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks with a gear hash. The
+// slice returned by Next is only valid until the following call.
+type chunker struct {
+	reader io.Reader
+	params chunkParams
+	mask   uint64
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+func newChunker(params chunkParams) *chunker {
+	bits := 0
+	for size := params.avg; size > 1; size >>= 1 {
+		bits++
+	}
+	return &chunker{
+		params: params,
+		mask:   (uint64(1) << bits) - 1,
+		buf:    make([]byte, params.max*2),
+	}
+}
+
+// Reset starts splitting a new stream and reuses the buffer.
+func (c *chunker) Reset(reader io.Reader) {
+	c.reader = reader
+	c.start, c.end = 0, 0
+	c.eof = false
+}
+
+// Next returns the next chunk or io.EOF once the stream is exhausted.
+func (c *chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.params.max && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	data := c.buf[c.start:c.end]
+	size := c.cutPoint(data)
+	c.start += size
+	return data[:size], nil
+}
+
+func (c *chunker) fill() error {
+	copy(c.buf, c.buf[c.start:c.end])
+	c.end -= c.start
+	c.start = 0
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *chunker) cutPoint(data []byte) int {
+	if len(data) <= c.params.min {
+		return len(data)
+	}
+	limit := min(len(data), c.params.max)
+	var hash uint64
+	for idx := c.params.min; idx < limit; idx++ {
+		hash = (hash << 1) + gearTable[data[idx]]
+		if hash&c.mask == 0 {
+			return idx + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,676 @@
+// Package backupstore keeps content-addressed, chunk-deduplicated snapshots of
+// instance directories. A repository holds gzip-compressed chunks named by the
+// SHA-256 of their plain content and one JSON manifest per snapshot that lists
+// every file with the chunks it is made of.
+package backupstore
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chunksDirName    = "chunks"
+	snapshotsDirName = "snapshots"
+	manifestSuffix   = ".json"
+	tempPrefix       = ".tmp-"
+)
+
+// Entry types recorded in a snapshot manifest.
+const (
+	TypeDir     = "dir"
+	TypeFile    = "file"
+	TypeSymlink = "symlink"
+)
+
+// ErrCancelled is returned when the context of a running create or restore is done.
+var ErrCancelled = errors.New("backup cancelled")
+
+var snapshotIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// repositoryLocks serialises create, restore and prune per repository root, so
+// a prune never collects chunks a concurrent create has written but not yet
+// referenced from a manifest.
+var repositoryLocks sync.Map
+
+// Repository is a snapshot store rooted at one directory.
+type Repository struct {
+	root   string
+	params chunkParams
+	mu     *sync.Mutex
+}
+
+// File is one entry of a snapshot.
+type File struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Size    int64       `json:"size,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+	Target  string      `json:"target,omitempty"`
+}
+
+// Stats summarises what a create stored.
+type Stats struct {
+	Files       int   `json:"files"`
+	Dirs        int   `json:"dirs"`
+	Symlinks    int   `json:"symlinks"`
+	Skipped     int   `json:"skipped"`
+	Bytes       int64 `json:"bytes"`
+	ReusedFiles int   `json:"reused_files"`
+	NewChunks   int   `json:"new_chunks"`
+	AddedBytes  int64 `json:"added_bytes"`
+}
+
+// Snapshot is the manifest of one backup.
+type Snapshot struct {
+	ID        string    `json:"id"`
+	Instance  string    `json:"instance,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Parent    string    `json:"parent,omitempty"`
+	Files     []File    `json:"files"`
+	Stats     Stats     `json:"stats"`
+}
+
+// RestoreStats summarises what a restore wrote.
+type RestoreStats struct {
+	Files    int   `json:"files"`
+	Dirs     int   `json:"dirs"`
+	Symlinks int   `json:"symlinks"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// PruneResult lists what a prune removed.
+type PruneResult struct {
+	Kept          []string `json:"kept"`
+	Removed       []string `json:"removed"`
+	RemovedChunks int      `json:"removed_chunks"`
+	FreedBytes    int64    `json:"freed_bytes"`
+}
+
+// Open opens the repository at root, creating it when missing.
+func Open(root string) (*Repository, error) {
+	root, err := filepath.Abs(filepath.Clean(root))
+	if err != nil {
+		return nil, fmt.Errorf("resolve repository root: %w", err)
+	}
+	for _, dir := range []string{chunksDirName, snapshotsDirName} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o750); err != nil {
+			return nil, fmt.Errorf("create repository: %w", err)
+		}
+	}
+	lock, _ := repositoryLocks.LoadOrStore(root, &sync.Mutex{})
+	return &Repository{root: root, params: defaultChunkParams, mu: lock.(*sync.Mutex)}, nil
+}
+
+// Root returns the repository directory.
+func (r *Repository) Root() string {
+	return r.root
+}
+
+// ManifestPath returns where the manifest of a snapshot is stored.
+func (r *Repository) ManifestPath(id string) string {
+	return filepath.Join(r.root, snapshotsDirName, id+manifestSuffix)
+}
+
+// ValidSnapshotID reports whether id can name a snapshot manifest.
+func ValidSnapshotID(id string) bool {
+	return snapshotIDPattern.MatchString(id) && !strings.Contains(id, "..")
+}
+
+// Create snapshots sourceDir. Files whose size, mode and modification time
+// match the newest existing snapshot are not read again; everything else is
+// chunked and only chunks missing from the repository are written.
+func (r *Repository) Create(ctx context.Context, sourceDir, instance string, now time.Time) (*Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshots, err := r.loadSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	parentFiles := map[string]File{}
+	snapshot := &Snapshot{ID: newSnapshotID(now), Instance: instance, CreatedAt: now.UTC()}
+	if len(snapshots) > 0 {
+		parent := snapshots[len(snapshots)-1]
+		snapshot.Parent = parent.ID
+		for _, file := range parent.Files {
+			if file.Type == TypeFile {
+				parentFiles[file.Path] = file
+			}
+		}
+	}
+
+	known := map[string]bool{}
+	splitter := newChunker(r.params)
+	err = filepath.WalkDir(sourceDir, func(current string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if ctx.Err() != nil {
+			return ErrCancelled
+		}
+		rel, err := filepath.Rel(sourceDir, current)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := File{Path: filepath.ToSlash(rel), Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC()}
+		switch {
+		case info.IsDir():
+			file.Type = TypeDir
+			snapshot.Stats.Dirs++
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(current)
+			if err != nil {
+				return err
+			}
+			file.Type = TypeSymlink
+			file.Target = target
+			snapshot.Stats.Symlinks++
+		case info.Mode().IsRegular():
+			file.Type = TypeFile
+			file.Size = info.Size()
+			if previous, ok := parentFiles[file.Path]; ok && previous.Size == file.Size && previous.Mode == file.Mode && previous.ModTime.Equal(file.ModTime) {
+				file.Chunks = previous.Chunks
+				snapshot.Stats.ReusedFiles++
+			} else {
+				chunks, err := r.storeFile(ctx, current, splitter, known, &snapshot.Stats)
+				if err != nil {
+					return fmt.Errorf("store %s: %w", file.Path, err)
+				}
+				file.Chunks = chunks
+			}
+			snapshot.Stats.Files++
+			snapshot.Stats.Bytes += file.Size
+		default:
+			// Sockets, FIFOs and device nodes cannot be restored meaningfully.
+			snapshot.Stats.Skipped++
+			return nil
+		}
+		snapshot.Files = append(snapshot.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.writeManifest(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (r *Repository) storeFile(ctx context.Context, filePath string, splitter *chunker, known map[string]bool, stats *Stats) ([]string, error) {
+	source, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	chunks := []string{}
+	splitter.Reset(source)
+	for {
+		if ctx.Err() != nil {
+			return nil, ErrCancelled
+		}
+		data, err := splitter.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		id := hex.EncodeToString(sum[:])
+		chunks = append(chunks, id)
+		if known[id] {
+			continue
+		}
+		written, err := r.storeChunk(id, data)
+		if err != nil {
+			return nil, err
+		}
+		known[id] = true
+		if written > 0 {
+			stats.NewChunks++
+			stats.AddedBytes += written
+		}
+	}
+}
+
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.root, chunksDirName, id[:2], id)
+}
+
+// storeChunk writes a chunk unless the repository already has it and returns
+// the number of bytes written to disk.
+func (r *Repository) storeChunk(id string, data []byte) (int64, error) {
+	target := r.chunkPath(id)
+	if _, err := os.Stat(target); err == nil {
+		return 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), tempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	tmpPath := tmp.Name()
+	writer, err := gzip.NewWriterLevel(tmp, gzip.BestSpeed)
+	if err == nil {
+		_, err = writer.Write(data)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, target)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return 0, fmt.Errorf("write chunk %s: %w", id, err)
+	}
+	return size, nil
+}
+
+func (r *Repository) readChunk(id string) ([]byte, error) {
+	if len(id) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk id %q", id)
+	}
+	file, err := os.Open(r.chunkPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("open chunk %s: %w", id, err)
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("read chunk %s: %w", id, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read chunk %s: %w", id, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt", id)
+	}
+	return data, nil
+}
+
+func (r *Repository) writeManifest(snapshot *Snapshot) error {
+	encoded, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("encode snapshot manifest: %w", err)
+	}
+	target := r.ManifestPath(snapshot.ID)
+	tmpPath := filepath.Join(filepath.Dir(target), tempPrefix+snapshot.ID)
+	if err := os.WriteFile(tmpPath, encoded, 0o640); err != nil {
+		return fmt.Errorf("write snapshot manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit snapshot manifest: %w", err)
+	}
+	return nil
+}
+
+// Load reads one snapshot manifest.
+func (r *Repository) Load(id string) (*Snapshot, error) {
+	if !ValidSnapshotID(id) {
+		return nil, fmt.Errorf("invalid snapshot id %q", id)
+	}
+	data, err := os.ReadFile(r.ManifestPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot %s: %w", id, err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("decode snapshot %s: %w", id, err)
+	}
+	return &snapshot, nil
+}
+
+// Snapshots returns all snapshots, oldest first.
+func (r *Repository) Snapshots() ([]*Snapshot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadSnapshots()
+}
+
+func (r *Repository) loadSnapshots() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(r.root, snapshotsDirName))
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	snapshots := []*Snapshot{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, manifestSuffix) {
+			continue
+		}
+		snapshot, err := r.Load(strings.TrimSuffix(name, manifestSuffix))
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(a, b int) bool {
+		if snapshots[a].CreatedAt.Equal(snapshots[b].CreatedAt) {
+			return snapshots[a].ID < snapshots[b].ID
+		}
+		return snapshots[a].CreatedAt.Before(snapshots[b].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Restore writes a snapshot into destination. With paths set only those files
+// and directory trees are restored; paths are slash-separated and relative to
+// the snapshot root. Files not in the snapshot are left alone.
+func (r *Repository) Restore(ctx context.Context, id, destination string, paths []string) (RestoreStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := RestoreStats{}
+	snapshot, err := r.Load(id)
+	if err != nil {
+		return stats, err
+	}
+	filters, err := normalizeRestorePaths(paths)
+	if err != nil {
+		return stats, err
+	}
+	root, err := filepath.Abs(filepath.Clean(destination))
+	if err != nil {
+		return stats, err
+	}
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return stats, err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return stats, err
+	}
+
+	selected := []File{}
+	for _, file := range snapshot.Files {
+		if matchesRestorePaths(file.Path, filters) {
+			selected = append(selected, file)
+		}
+	}
+	if len(filters) > 0 && len(selected) == 0 {
+		return stats, fmt.Errorf("snapshot %s contains none of the requested paths", id)
+	}
+
+	// Directories first so files have a parent, symlinks last so no later
+	// write can follow a link out of the destination.
+	dirs := []File{}
+	for _, file := range selected {
+		if file.Type != TypeDir {
+			continue
+		}
+		target, err := restoreTarget(root, resolvedRoot, file.Path)
+		if err != nil {
+			return stats, err
+		}
+		if err := os.MkdirAll(target, 0o750); err != nil {
+			return stats, err
+		}
+		dirs = append(dirs, file)
+		stats.Dirs++
+	}
+	for _, file := range selected {
+		if file.Type != TypeFile {
+			continue
+		}
+		if ctx.Err() != nil {
+			return stats, ErrCancelled
+		}
+		target, err := restoreTarget(root, resolvedRoot, file.Path)
+		if err != nil {
+			return stats, err
+		}
+		if err := r.restoreFile(ctx, file, target); err != nil {
+			return stats, fmt.Errorf("restore %s: %w", file.Path, err)
+		}
+		stats.Files++
+		stats.Bytes += file.Size
+	}
+	for _, file := range selected {
+		if file.Type != TypeSymlink {
+			continue
+		}
+		target, err := restoreTarget(root, resolvedRoot, file.Path)
+		if err != nil {
+			return stats, err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return stats, err
+		}
+		if err := os.Symlink(file.Target, target); err != nil {
+			return stats, fmt.Errorf("restore %s: %w", file.Path, err)
+		}
+		stats.Symlinks++
+	}
+	// Directory modes and times last; a read-only directory would block the
+	// writes above and every write bumps the parent's mtime.
+	for idx := len(dirs) - 1; idx >= 0; idx-- {
+		target := filepath.Join(root, filepath.FromSlash(dirs[idx].Path))
+		_ = os.Chmod(target, dirs[idx].Mode)
+		_ = os.Chtimes(target, dirs[idx].ModTime, dirs[idx].ModTime)
+	}
+	return stats, nil
+}
+
+func (r *Repository) restoreFile(ctx context.Context, file File, target string) error {
+	if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	for _, id := range file.Chunks {
+		if ctx.Err() != nil {
+			err = ErrCancelled
+			break
+		}
+		var data []byte
+		if data, err = r.readChunk(id); err != nil {
+			break
+		}
+		if _, err = tmp.Write(data); err != nil {
+			break
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, file.Mode)
+	}
+	if err == nil {
+		err = os.Chtimes(tmpPath, file.ModTime, file.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, target)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
+
+// restoreTarget maps a manifest path below root and refuses paths that escape
+// it, either lexically or through a symlink already present in the destination.
+func restoreTarget(root, resolvedRoot, relPath string) (string, error) {
+	if relPath == "" || path.IsAbs(relPath) || strings.HasPrefix(path.Clean(relPath), "..") {
+		return "", fmt.Errorf("snapshot contains unsafe path: %s", relPath)
+	}
+	target := filepath.Join(root, filepath.FromSlash(path.Clean(relPath)))
+	parent, err := deepestExisting(filepath.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	resolvedParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(resolvedRoot, resolvedParent)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("snapshot path escapes the destination: %s", relPath)
+	}
+	return target, nil
+}
+
+func deepestExisting(dir string) (string, error) {
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			return dir, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+func normalizeRestorePaths(paths []string) ([]string, error) {
+	filters := []string{}
+	for _, raw := range paths {
+		raw = strings.TrimSpace(filepath.ToSlash(raw))
+		if raw == "" {
+			continue
+		}
+		for _, segment := range strings.Split(raw, "/") {
+			if segment == ".." {
+				return nil, fmt.Errorf("invalid restore path: %s", raw)
+			}
+		}
+		cleaned := strings.TrimPrefix(path.Clean("/"+raw), "/")
+		if cleaned == "" {
+			// "/" selects everything.
+			return nil, nil
+		}
+		filters = append(filters, cleaned)
+	}
+	return filters, nil
+}
+
+func matchesRestorePaths(filePath string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		if filePath == filter || strings.HasPrefix(filePath, filter+"/") || strings.HasPrefix(filter, filePath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Prune deletes the snapshots the policy does not keep and then every chunk
+// no remaining snapshot references.
+func (r *Repository) Prune(policy RetentionPolicy) (PruneResult, error) {
+	result := PruneResult{Kept: []string{}, Removed: []string{}}
+	if policy.IsZero() {
+		return result, fmt.Errorf("retention policy keeps no snapshots")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshots, err := r.loadSnapshots()
+	if err != nil {
+		return result, err
+	}
+	keep := policy.Keep(snapshots)
+	referenced := map[string]bool{}
+	for _, snapshot := range snapshots {
+		if !keep[snapshot.ID] {
+			if err := os.Remove(r.ManifestPath(snapshot.ID)); err != nil && !os.IsNotExist(err) {
+				return result, fmt.Errorf("remove snapshot %s: %w", snapshot.ID, err)
+			}
+			result.Removed = append(result.Removed, snapshot.ID)
+			continue
+		}
+		result.Kept = append(result.Kept, snapshot.ID)
+		for _, file := range snapshot.Files {
+			for _, id := range file.Chunks {
+				referenced[id] = true
+			}
+		}
+	}
+
+	chunksDir := filepath.Join(r.root, chunksDirName)
+	err = filepath.WalkDir(chunksDir, func(current string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() || referenced[entry.Name()] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(current); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !strings.HasPrefix(entry.Name(), tempPrefix) {
+			result.RemovedChunks++
+		}
+		result.FreedBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("collect unreferenced chunks: %w", err)
+	}
+	return result, nil
+}
+
+func newSnapshotID(now time.Time) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return now.UTC().Format("20060102T150405.000000000Z")
+	}
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
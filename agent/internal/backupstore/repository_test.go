@@ -0,0 +1,175 @@
+package backupstore
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testChunkParams = chunkParams{min: 64, avg: 256, max: 1024}
+
+func openTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	repo, err := Open(filepath.Join(t.TempDir(), "repo"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	repo.params = testChunkParams
+	return repo
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o640); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestCreateDeduplicatesUnchangedAndShiftedData(t *testing.T) {
+	repo := openTestRepository(t)
+	source := t.TempDir()
+	world := randomBytes(1, 64<<10)
+	writeTestFile(t, filepath.Join(source, "world", "region.mca"), world)
+	writeTestFile(t, filepath.Join(source, "server.properties"), []byte("motd=hello\n"))
+
+	now := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	first, err := repo.Create(context.Background(), source, "42", now)
+	if err != nil {
+		t.Fatalf("first snapshot: %v", err)
+	}
+	if first.Stats.Files != 2 || first.Stats.NewChunks == 0 {
+		t.Fatalf("unexpected first stats: %+v", first.Stats)
+	}
+
+	// Insert a few bytes in the middle of the world file: content-defined
+	// boundaries keep most chunks identical.
+	shifted := append(append(append([]byte{}, world[:30000]...), []byte("inserted")...), world[30000:]...)
+	writeTestFile(t, filepath.Join(source, "world", "region.mca"), shifted)
+	second, err := repo.Create(context.Background(), source, "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("second snapshot: %v", err)
+	}
+	if second.Parent != first.ID {
+		t.Fatalf("expected parent %s, got %s", first.ID, second.Parent)
+	}
+	if second.Stats.ReusedFiles != 1 {
+		t.Fatalf("expected unchanged properties file to be reused, got %+v", second.Stats)
+	}
+	if second.Stats.NewChunks == 0 || second.Stats.NewChunks > first.Stats.NewChunks/4 {
+		t.Fatalf("expected only a few new chunks after an insertion, first=%+v second=%+v", first.Stats, second.Stats)
+	}
+
+	restored := t.TempDir()
+	if _, err := repo.Restore(context.Background(), first.ID, restored, nil); err != nil {
+		t.Fatalf("restore first: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(restored, "world", "region.mca"))
+	if err != nil || !bytes.Equal(got, world) {
+		t.Fatalf("restored world file differs from the first snapshot (err=%v)", err)
+	}
+}
+
+func TestRestoreSelectedPathsOnly(t *testing.T) {
+	repo := openTestRepository(t)
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "world", "level.dat"), []byte("level"))
+	writeTestFile(t, filepath.Join(source, "plugins", "config.yml"), []byte("plugin"))
+	snapshot, err := repo.Create(context.Background(), source, "42", time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	destination := t.TempDir()
+	writeTestFile(t, filepath.Join(destination, "plugins", "config.yml"), []byte("live"))
+	stats, err := repo.Restore(context.Background(), snapshot.ID, destination, []string{"/world/"})
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if stats.Files != 1 {
+		t.Fatalf("expected one restored file, got %+v", stats)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destination, "world", "level.dat")); string(data) != "level" {
+		t.Fatalf("expected world restored, got %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(destination, "plugins", "config.yml")); string(data) != "live" {
+		t.Fatalf("expected unselected file untouched, got %q", data)
+	}
+
+	if _, err := repo.Restore(context.Background(), snapshot.ID, destination, []string{"../etc"}); err == nil {
+		t.Fatal("expected traversal path to be rejected")
+	}
+	if _, err := repo.Restore(context.Background(), snapshot.ID, destination, []string{"missing"}); err == nil {
+		t.Fatal("expected an error when no requested path is in the snapshot")
+	}
+}
+
+func TestRestoreRefusesSymlinkInDestination(t *testing.T) {
+	repo := openTestRepository(t)
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "world", "level.dat"), []byte("level"))
+	snapshot, err := repo.Create(context.Background(), source, "42", time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	outside := t.TempDir()
+	destination := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(destination, "world")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := repo.Restore(context.Background(), snapshot.ID, destination, nil); err == nil {
+		t.Fatal("expected restore through a symlinked directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "level.dat")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing written outside the destination, got %v", err)
+	}
+}
+
+func TestPruneRemovesSnapshotsAndUnreferencedChunks(t *testing.T) {
+	repo := openTestRepository(t)
+	source := t.TempDir()
+	start := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	ids := []string{}
+	for idx := 0; idx < 4; idx++ {
+		writeTestFile(t, filepath.Join(source, "save.dat"), randomBytes(int64(idx+10), 8<<10))
+		snapshot, err := repo.Create(context.Background(), source, "42", start.Add(time.Duration(idx)*time.Hour))
+		if err != nil {
+			t.Fatalf("create %d: %v", idx, err)
+		}
+		ids = append(ids, snapshot.ID)
+	}
+
+	result, err := repo.Prune(RetentionPolicy{KeepLast: 2})
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if len(result.Removed) != 2 || result.Removed[0] != ids[0] || result.Removed[1] != ids[1] {
+		t.Fatalf("expected the two oldest snapshots removed, got %+v", result)
+	}
+	if result.RemovedChunks == 0 || result.FreedBytes == 0 {
+		t.Fatalf("expected chunks of removed snapshots collected, got %+v", result)
+	}
+	if _, err := os.Stat(repo.ManifestPath(ids[0])); !os.IsNotExist(err) {
+		t.Fatalf("expected manifest removed, got %v", err)
+	}
+	restored := t.TempDir()
+	if _, err := repo.Restore(context.Background(), ids[3], restored, nil); err != nil {
+		t.Fatalf("restore kept snapshot after prune: %v", err)
+	}
+	if _, err := repo.Prune(RetentionPolicy{}); err == nil {
+		t.Fatal("expected an empty policy to be refused")
+	}
+}
@@ -0,0 +1,59 @@
+package backupstore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RetentionPolicy decides which snapshots a prune keeps. A snapshot is kept
+// when any rule selects it: one of the KeepLast newest, the newest of one of
+// the KeepDaily most recent days with a snapshot, or the newest of one of the
+// KeepWeekly most recent ISO weeks with a snapshot. Days and weeks are UTC.
+type RetentionPolicy struct {
+	KeepLast   int `json:"keep_last"`
+	KeepDaily  int `json:"keep_daily"`
+	KeepWeekly int `json:"keep_weekly"`
+}
+
+// IsZero reports whether the policy keeps nothing at all.
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLast <= 0 && p.KeepDaily <= 0 && p.KeepWeekly <= 0
+}
+
+// Validate rejects negative counts.
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	return nil
+}
+
+// Keep returns the IDs of the snapshots the policy retains.
+func (p RetentionPolicy) Keep(snapshots []*Snapshot) map[string]bool {
+	ordered := append([]*Snapshot(nil), snapshots...)
+	sort.SliceStable(ordered, func(a, b int) bool {
+		return ordered[a].CreatedAt.After(ordered[b].CreatedAt)
+	})
+
+	keep := map[string]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	for idx, snapshot := range ordered {
+		if idx < p.KeepLast {
+			keep[snapshot.ID] = true
+		}
+		created := snapshot.CreatedAt.UTC()
+		day := created.Format("2006-01-02")
+		if !days[day] && len(days) < p.KeepDaily {
+			days[day] = true
+			keep[snapshot.ID] = true
+		}
+		year, week := created.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < p.KeepWeekly {
+			weeks[weekKey] = true
+			keep[snapshot.ID] = true
+		}
+	}
+	return keep
+}
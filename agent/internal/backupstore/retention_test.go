@@ -0,0 +1,32 @@
+package backupstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionKeepsLastDailyAndWeekly(t *testing.T) {
+	// Hourly snapshots over 15 days, newest on Friday 2026-05-15 23:00 UTC.
+	start := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	snapshots := []*Snapshot{}
+	for hour := 0; hour < 15*24; hour++ {
+		created := start.Add(time.Duration(hour) * time.Hour)
+		snapshots = append(snapshots, &Snapshot{ID: created.Format("20060102T15"), CreatedAt: created})
+	}
+
+	keep := RetentionPolicy{KeepLast: 3, KeepDaily: 2, KeepWeekly: 3}.Keep(snapshots)
+	want := []string{
+		"20260515T23", "20260515T22", "20260515T21", // last 3
+		"20260514T23", // newest of the second day; the first day is already kept
+		"20260510T23", // newest of ISO week 19
+		"20260503T23", // newest of ISO week 18
+	}
+	if len(keep) != len(want) {
+		t.Fatalf("expected %d kept snapshots, got %d: %v", len(want), len(keep), keep)
+	}
+	for _, id := range want {
+		if !keep[id] {
+			t.Fatalf("expected %s to be kept, got %v", id, keep)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package backuptarget
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // S3 part ETags are MD5 digests.
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region   = "us-east-1"
+	defaultS3PartSize = 16 << 20
+	s3MaxParts        = 10000
+	s3StateSuffix     = ".s3-upload.json"
+	s3RefScheme       = "s3://"
+)
+
+// s3MinPartSize is the smallest part S3 accepts for all but the last part.
+var s3MinPartSize int64 = 5 << 20
+
+// S3Config configures an S3-compatible target such as AWS, MinIO, Wasabi or
+// the Backblaze B2 S3 API.
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-central-1.wasabisys.com.
+	// Empty means AWS for Region.
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle addresses the bucket in the path instead of the host name;
+	// MinIO and most self-hosted services need it.
+	PathStyle  bool
+	PartSize   int64
+	HTTPClient *http.Client
+}
+
+// S3 stores backups as objects. Archives larger than one part are sent as a
+// multipart upload whose state is kept next to the local file, so a failed
+// upload of the same archive continues with the missing parts.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	signer   sigV4Signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+// S3Error is an error response of the S3 API.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3 request failed: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3 request failed: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+type s3UploadState struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	UploadID string    `json:"upload_id"`
+	PartSize int64     `json:"part_size"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+// NewS3 validates cfg and returns the target.
+func NewS3(cfg S3Config) (*S3, error) {
+	cfg.Bucket = strings.TrimSpace(cfg.Bucket)
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3 access key and secret key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", cfg.Endpoint)
+	}
+	if cfg.PartSize < s3MinPartSize {
+		cfg.PartSize = max(defaultS3PartSize, s3MinPartSize)
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		signer:   sigV4Signer{accessKey: cfg.AccessKey, secretKey: cfg.SecretKey, sessionToken: cfg.SessionToken, region: cfg.Region},
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Upload implements Target.
+func (s *S3) Upload(ctx context.Context, localPath, name string) (string, error) {
+	key := joinRemote(s.cfg.Prefix, name)
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return "", err
+	}
+	partSize := s.partSizeFor(info.Size())
+	if info.Size() <= partSize {
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			return "", err
+		}
+		resp, err := s.do(ctx, http.MethodPut, key, nil, data, nil)
+		if err != nil {
+			return "", fmt.Errorf("s3 upload %s: %w", key, err)
+		}
+		_ = resp.Body.Close()
+		return s.ref(key), nil
+	}
+	if err := s.uploadMultipart(ctx, localPath, key, info, partSize); err != nil {
+		return "", fmt.Errorf("s3 multipart upload %s: %w", key, err)
+	}
+	return s.ref(key), nil
+}
+
+// Download implements Target.
+func (s *S3) Download(ctx context.Context, ref, localPath string) error {
+	key, err := s.keyFromRef(ref)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("s3 download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	return writeFileAtomically(localPath, resp.Body)
+}
+
+func (s *S3) ref(key string) string {
+	return s3RefScheme + s.cfg.Bucket + "/" + key
+}
+
+func (s *S3) keyFromRef(ref string) (string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(ref), s3RefScheme)
+	if !ok {
+		return "", fmt.Errorf("backup reference is not an s3 object: %s", ref)
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	prefix := joinRemote(s.cfg.Prefix, "")
+	if bucket != s.cfg.Bucket || key == "" || strings.Contains(key, "..") || (prefix != "" && !strings.HasPrefix(key, prefix+"/")) {
+		return "", fmt.Errorf("backup reference is outside the configured bucket: %s", ref)
+	}
+	return key, nil
+}
+
+func (s *S3) partSizeFor(size int64) int64 {
+	partSize := s.cfg.PartSize
+	if size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+		partSize = (partSize + (1 << 20) - 1) &^ ((1 << 20) - 1)
+	}
+	return partSize
+}
+
+func (s *S3) uploadMultipart(ctx context.Context, localPath, key string, info os.FileInfo, partSize int64) error {
+	statePath := localPath + s3StateSuffix
+	uploaded := map[int]s3Part{}
+	state, ok := s.loadUploadState(statePath, key, info, partSize)
+	if ok {
+		parts, err := s.listParts(ctx, key, state.UploadID)
+		var s3Err *S3Error
+		switch {
+		case errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound:
+			ok = false
+		case err != nil:
+			return err
+		default:
+			for _, part := range parts {
+				uploaded[part.PartNumber] = part
+			}
+		}
+	}
+	if !ok {
+		uploadID, err := s.initiateMultipart(ctx, key)
+		if err != nil {
+			return err
+		}
+		state = s3UploadState{Bucket: s.cfg.Bucket, Key: key, UploadID: uploadID, PartSize: partSize, Size: info.Size(), ModTime: info.ModTime().UTC()}
+		if err := saveUploadState(statePath, state); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	partCount := int((info.Size() + partSize - 1) / partSize)
+	completed := make([]s3Part, 0, partCount)
+	buf := make([]byte, partSize)
+	for number := 1; number <= partCount; number++ {
+		if err := ctx.Err(); err != nil {
+			s.abortMultipart(state)
+			_ = os.Remove(statePath)
+			return err
+		}
+		offset := int64(number-1) * partSize
+		length := min(partSize, info.Size()-offset)
+		data := buf[:length]
+		if _, err := file.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		sum := md5.Sum(data) //nolint:gosec
+		etag := hex.EncodeToString(sum[:])
+		if previous, ok := uploaded[number]; ok && previous.Size == length && strings.Trim(previous.ETag, `"`) == etag {
+			completed = append(completed, s3Part{PartNumber: number, ETag: previous.ETag})
+			continue
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {state.UploadID}}
+		header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+		resp, err := s.do(ctx, http.MethodPut, key, query, data, header)
+		if err != nil {
+			if ctx.Err() != nil {
+				s.abortMultipart(state)
+				_ = os.Remove(statePath)
+			}
+			return fmt.Errorf("upload part %d: %w", number, err)
+		}
+		_ = resp.Body.Close()
+		returned := resp.Header.Get("ETag")
+		if returned == "" {
+			returned = `"` + etag + `"`
+		}
+		completed = append(completed, s3Part{PartNumber: number, ETag: returned})
+	}
+
+	if err := s.completeMultipart(ctx, key, state.UploadID, completed); err != nil {
+		return err
+	}
+	_ = os.Remove(statePath)
+	return nil
+}
+
+func (s *S3) loadUploadState(statePath, key string, info os.FileInfo, partSize int64) (s3UploadState, bool) {
+	var state s3UploadState
+	data, err := os.ReadFile(statePath)
+	if err != nil || json.Unmarshal(data, &state) != nil {
+		return state, false
+	}
+	matches := state.Bucket == s.cfg.Bucket && state.Key == key && state.UploadID != "" &&
+		state.PartSize == partSize && state.Size == info.Size() && state.ModTime.Equal(info.ModTime().UTC())
+	return state, matches
+}
+
+func saveUploadState(statePath string, state s3UploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, data, 0o600)
+}
+
+func (s *S3) initiateMultipart(ctx context.Context, key string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("initiate: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("initiate: invalid response")
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) listParts(ctx context.Context, key, uploadID string) ([]s3Part, error) {
+	parts := []s3Part{}
+	marker := ""
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		resp, err := s.do(ctx, http.MethodGet, key, query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated          bool     `xml:"IsTruncated"`
+			NextPartNumberMarker string   `xml:"NextPartNumberMarker"`
+			Parts                []s3Part `xml:"Part"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list parts: %w", err)
+		}
+		parts = append(parts, result.Parts...)
+		if !result.IsTruncated || result.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+func (s *S3) completeMultipart(ctx context.Context, key, uploadID string, parts []s3Part) error {
+	type completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	body := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, part := range parts {
+		body.Parts = append(body.Parts, completePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	encoded, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, encoded, http.Header{"Content-Type": {"application/xml"}})
+	if err != nil {
+		return fmt.Errorf("complete: %w", err)
+	}
+	defer resp.Body.Close()
+	// S3 may report a failed completion with status 200 and an error body.
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if bytes.Contains(data, []byte("<Error>")) {
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(data, s3Err)
+		return fmt.Errorf("complete: %w", s3Err)
+	}
+	return nil
+}
+
+func (s *S3) abortMultipart(state s3UploadState) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if resp, err := s.do(ctx, http.MethodDelete, state.Key, url.Values{"uploadId": {state.UploadID}}, nil, nil); err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+func (s *S3) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	segments := []string{}
+	if s.cfg.PathStyle {
+		segments = append(segments, s.cfg.Bucket)
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	if key != "" {
+		segments = append(segments, strings.Split(key, "/")...)
+	}
+	basePath := strings.TrimRight(u.Path, "/")
+	escaped := make([]string, len(segments))
+	for idx, segment := range segments {
+		escaped[idx] = awsEscape(segment)
+	}
+	u.Path = basePath + "/" + strings.Join(segments, "/")
+	u.RawPath = basePath + "/" + strings.Join(escaped, "/")
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do sends one signed request and retries network errors and server failures.
+// Responses with status 300 and above are returned as *S3Error.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	var resp *http.Response
+	err := retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, query).String(), bytes.NewReader(body))
+		if err != nil {
+			return permanent(err)
+		}
+		req.ContentLength = int64(len(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		s.signer.Sign(req, payloadHash, s.now())
+		response, err := s.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return permanent(ctx.Err())
+			}
+			return err
+		}
+		if response.StatusCode >= http.StatusMultipleChoices {
+			data, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+			_ = response.Body.Close()
+			s3Err := &S3Error{StatusCode: response.StatusCode}
+			_ = xml.Unmarshal(data, s3Err)
+			if response.StatusCode < http.StatusInternalServerError && response.StatusCode != http.StatusTooManyRequests {
+				return permanent(s3Err)
+			}
+			return s3Err
+		}
+		resp = response
+		return nil
+	})
+	return resp, err
+}
@@ -0,0 +1,221 @@
+package backuptarget
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a path-style, in-memory stand-in for MinIO.
+type fakeS3 struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	uploads    map[string]map[int][]byte
+	failPart   int
+	partPuts   int
+	unsigned   int
+	nextUpload int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		f.unsigned++
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex(body) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("<Error><Code>XAmzContentSHA256Mismatch</Code></Error>"))
+		return
+	}
+	key := r.URL.Path
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextUpload++
+		id := fmt.Sprintf("upload-%d", f.nextUpload)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if number == f.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.partPuts++
+		parts[number] = body
+		sum := md5.Sum(body) //nolint:gosec
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodGet && uploadID != "":
+		parts, ok := f.uploads[uploadID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchUpload</Code></Error>"))
+			return
+		}
+		fmt.Fprint(w, "<ListPartsResult><IsTruncated>false</IsTruncated>")
+		for number, data := range parts {
+			sum := md5.Sum(data) //nolint:gosec
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"%s"</ETag><Size>%d</Size></Part>`, number, hex.EncodeToString(sum[:]), len(data))
+		}
+		fmt.Fprint(w, "</ListPartsResult>")
+	case r.Method == http.MethodPost && uploadID != "":
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		numbers := []int{}
+		for _, part := range complete.Parts {
+			numbers = append(numbers, part.PartNumber)
+		}
+		sort.Ints(numbers)
+		var object bytes.Buffer
+		for _, number := range numbers {
+			object.Write(f.uploads[uploadID][number])
+		}
+		f.objects[key] = object.Bytes()
+		delete(f.uploads, uploadID)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestS3(t *testing.T, server *httptest.Server) *S3 {
+	t.Helper()
+	target, err := NewS3(S3Config{Endpoint: server.URL, Bucket: "backups", Prefix: "nodes/1", AccessKey: "access", SecretKey: "secret", PathStyle: true})
+	if err != nil {
+		t.Fatalf("new s3: %v", err)
+	}
+	return target
+}
+
+func TestS3UploadAndDownloadSingleObject(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target := newTestS3(t, server)
+
+	local := filepath.Join(t.TempDir(), "instance-42-1.tar.gz")
+	if err := os.WriteFile(local, []byte("archive"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := target.Upload(context.Background(), local, "instance-42-1.tar.gz")
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if ref != "s3://backups/nodes/1/instance-42-1.tar.gz" {
+		t.Fatalf("ref=%s", ref)
+	}
+	if string(fake.objects["/backups/nodes/1/instance-42-1.tar.gz"]) != "archive" {
+		t.Fatalf("unexpected objects: %v", fake.objects)
+	}
+
+	restored := filepath.Join(t.TempDir(), "restore.tar.gz")
+	if err := target.Download(context.Background(), ref, restored); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if data, _ := os.ReadFile(restored); string(data) != "archive" {
+		t.Fatalf("downloaded %q", data)
+	}
+	for _, foreign := range []string{"s3://other/nodes/1/instance-42-1.tar.gz", "s3://backups/elsewhere.tar.gz", "s3://backups/nodes/1/../../x"} {
+		if err := target.Download(context.Background(), foreign, restored); err == nil {
+			t.Fatalf("expected %s to be refused", foreign)
+		}
+	}
+}
+
+func TestS3MultipartUploadResumesMissingParts(t *testing.T) {
+	previousMin, previousDelay := s3MinPartSize, retryBaseDelay
+	s3MinPartSize, retryBaseDelay = 1024, time.Millisecond
+	t.Cleanup(func() { s3MinPartSize, retryBaseDelay = previousMin, previousDelay })
+
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, err := NewS3(S3Config{Endpoint: server.URL, Bucket: "backups", AccessKey: "access", SecretKey: "secret", PathStyle: true, PartSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(7)).Read(data)
+	local := filepath.Join(t.TempDir(), "big.tar.gz")
+	if err := os.WriteFile(local, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.failPart = 3
+	if _, err := target.Upload(context.Background(), local, "big.tar.gz"); err == nil {
+		t.Fatal("expected the first upload to fail on part 3")
+	}
+	if _, err := os.Stat(local + s3StateSuffix); err != nil {
+		t.Fatalf("expected upload state to be kept for resuming: %v", err)
+	}
+	if fake.partPuts != 2 {
+		t.Fatalf("expected parts 1 and 2 stored, got %d", fake.partPuts)
+	}
+
+	fake.failPart = 0
+	fake.partPuts = 0
+	if _, err := target.Upload(context.Background(), local, "big.tar.gz"); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+	if fake.partPuts != 3 {
+		t.Fatalf("expected only parts 3-5 uploaded on resume, got %d", fake.partPuts)
+	}
+	if !bytes.Equal(fake.objects["/backups/big.tar.gz"], data) {
+		t.Fatal("assembled object differs from the local file")
+	}
+	if _, err := os.Stat(local + s3StateSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected upload state removed after completion, got %v", err)
+	}
+	if fake.unsigned != 0 {
+		t.Fatalf("expected every request to be signed, %d were not", fake.unsigned)
+	}
+}
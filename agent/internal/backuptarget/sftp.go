@@ -0,0 +1,287 @@
+package backuptarget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultSFTPPort    = 22
+	defaultSFTPTimeout = 30 * time.Second
+	sftpPartSuffix     = ".part"
+	sftpRefScheme      = "sftp://"
+)
+
+// SFTPConfig configures an SFTP target.
+type SFTPConfig struct {
+	Host       string
+	Port       int
+	User       string
+	Password   string
+	PrivateKey string
+	Passphrase string
+	// HostKey pins the server key, either as an authorized_keys line or as a
+	// "SHA256:" fingerprint.
+	HostKey               string
+	InsecureIgnoreHostKey bool
+	// RemotePath is the folder backups are written to; relative paths start
+	// in the login directory.
+	RemotePath string
+	Timeout    time.Duration
+}
+
+// SFTP stores backups on an SSH server. Uploads go to a ".part" file that is
+// renamed once complete; an interrupted upload appends to the existing part.
+type SFTP struct {
+	cfg          SFTPConfig
+	clientConfig *ssh.ClientConfig
+}
+
+// NewSFTP validates cfg and returns the target.
+func NewSFTP(cfg SFTPConfig) (*SFTP, error) {
+	cfg.Host = strings.TrimSpace(cfg.Host)
+	if cfg.Host == "" || cfg.User == "" {
+		return nil, fmt.Errorf("sftp host and username are required")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = defaultSFTPPort
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultSFTPTimeout
+	}
+	auth := []ssh.AuthMethod{}
+	if strings.TrimSpace(cfg.PrivateKey) != "" {
+		var signer ssh.Signer
+		var err error
+		if cfg.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(cfg.PrivateKey), []byte(cfg.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse sftp private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp password or private key is required")
+	}
+	hostKeyCallback, err := sftpHostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SFTP{
+		cfg: cfg,
+		clientConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         cfg.Timeout,
+		},
+	}, nil
+}
+
+func sftpHostKeyCallback(cfg SFTPConfig) (ssh.HostKeyCallback, error) {
+	pinned := strings.TrimSpace(cfg.HostKey)
+	switch {
+	case strings.HasPrefix(pinned, "SHA256:"):
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != pinned {
+				return fmt.Errorf("sftp host key mismatch: got %s", ssh.FingerprintSHA256(key))
+			}
+			return nil
+		}, nil
+	case pinned != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+		if err != nil {
+			return nil, fmt.Errorf("parse sftp host key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	case cfg.InsecureIgnoreHostKey:
+		return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec // explicitly configured by the operator.
+	default:
+		return nil, fmt.Errorf("sftp host key is required")
+	}
+}
+
+func (s *SFTP) address() string {
+	return net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+}
+
+func (s *SFTP) remotePath(name string) string {
+	joined := joinRemote(s.cfg.RemotePath, name)
+	if strings.HasPrefix(strings.TrimSpace(s.cfg.RemotePath), "/") {
+		return "/" + joined
+	}
+	return joined
+}
+
+// Upload implements Target.
+func (s *SFTP) Upload(ctx context.Context, localPath, name string) (string, error) {
+	remote := s.remotePath(name)
+	err := retry(ctx, func() error {
+		return s.withClient(ctx, func(client *sftp.Client) error {
+			return uploadSFTP(ctx, client, localPath, remote)
+		})
+	})
+	if err != nil {
+		return "", fmt.Errorf("sftp upload %s: %w", remote, err)
+	}
+	return s.ref(remote), nil
+}
+
+// Download implements Target.
+func (s *SFTP) Download(ctx context.Context, ref, localPath string) error {
+	remote, err := s.pathFromRef(ref)
+	if err != nil {
+		return err
+	}
+	err = retry(ctx, func() error {
+		return s.withClient(ctx, func(client *sftp.Client) error {
+			file, err := client.Open(remote)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return permanent(err)
+				}
+				return err
+			}
+			defer file.Close()
+			return writeFileAtomically(localPath, &contextReader{ctx: ctx, reader: file})
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("sftp download %s: %w", remote, err)
+	}
+	return nil
+}
+
+func (s *SFTP) ref(remote string) string {
+	if !strings.HasPrefix(remote, "/") {
+		remote = "/~/" + remote
+	}
+	return sftpRefScheme + s.address() + remote
+}
+
+func (s *SFTP) pathFromRef(ref string) (string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(ref), sftpRefScheme)
+	if !ok {
+		return "", fmt.Errorf("backup reference is not an sftp path: %s", ref)
+	}
+	address, remote, _ := strings.Cut(rest, "/")
+	remote = "/" + remote
+	if relative, ok := strings.CutPrefix(remote, "/~/"); ok {
+		remote = relative
+	}
+	folder := s.remotePath("")
+	if address != s.address() || strings.Contains(remote, "..") || (folder != "" && folder != "/" && !strings.HasPrefix(remote, strings.TrimSuffix(folder, "/")+"/")) {
+		return "", fmt.Errorf("backup reference is outside the configured sftp target: %s", ref)
+	}
+	return remote, nil
+}
+
+func (s *SFTP) withClient(ctx context.Context, fn func(client *sftp.Client) error) error {
+	dialer := net.Dialer{Timeout: s.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address())
+	if err != nil {
+		return err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, s.address(), s.clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		if strings.Contains(err.Error(), "unable to authenticate") || strings.Contains(err.Error(), "host key") {
+			return permanent(err)
+		}
+		return err
+	}
+	sshClient := ssh.NewClient(sshConn, channels, requests)
+	defer sshClient.Close()
+	stop := context.AfterFunc(ctx, func() { _ = sshClient.Close() })
+	defer stop()
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return fn(client)
+}
+
+func uploadSFTP(ctx context.Context, client *sftp.Client, localPath, remote string) error {
+	local, err := os.Open(localPath)
+	if err != nil {
+		return permanent(err)
+	}
+	defer local.Close()
+	info, err := local.Stat()
+	if err != nil {
+		return permanent(err)
+	}
+
+	if dir := path.Dir(remote); dir != "." && dir != "/" {
+		if err := client.MkdirAll(dir); err != nil {
+			return err
+		}
+	}
+	partPath := remote + sftpPartSuffix
+	var offset int64
+	if partInfo, err := client.Stat(partPath); err == nil && partInfo.Size() <= info.Size() {
+		offset = partInfo.Size()
+	}
+	part, err := client.OpenFile(partPath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	if offset == 0 {
+		if err := part.Truncate(0); err != nil {
+			_ = part.Close()
+			return err
+		}
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		_ = part.Close()
+		return err
+	}
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		_ = part.Close()
+		return permanent(err)
+	}
+	_, err = io.Copy(part, &contextReader{ctx: ctx, reader: local})
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := client.PosixRename(partPath, remote); err != nil {
+		_ = client.Remove(remote)
+		return client.Rename(partPath, remote)
+	}
+	return nil
+}
+
+// contextReader stops a copy once ctx is done.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, permanent(err)
+	}
+	return r.reader.Read(p)
+}
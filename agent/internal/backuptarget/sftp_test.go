@@ -0,0 +1,124 @@
+package backuptarget
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	gssh "github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func startTestSFTPServer(t *testing.T) (string, int, cryptossh.PublicKey) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := cryptossh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &gssh.Server{
+		PasswordHandler: func(ctx gssh.Context, password string) bool {
+			return ctx.User() == "backup" && password == "secret"
+		},
+		SubsystemHandlers: map[string]gssh.SubsystemHandler{
+			"sftp": func(sess gssh.Session) {
+				server, err := sftp.NewServer(sess)
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+			},
+		},
+	}
+	srv.AddHostKey(signer)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("close server: %v", err)
+		}
+	})
+	host, portText, _ := net.SplitHostPort(ln.Addr().String())
+	port, _ := strconv.Atoi(portText)
+	return host, port, signer.PublicKey()
+}
+
+func TestSFTPUploadResumesPartialFileAndDownloads(t *testing.T) {
+	host, port, hostKey := startTestSFTPServer(t)
+	remoteDir := filepath.Join(t.TempDir(), "backups")
+	target, err := NewSFTP(SFTPConfig{Host: host, Port: port, User: "backup", Password: "secret", HostKey: cryptossh.FingerprintSHA256(hostKey), RemotePath: remoteDir})
+	if err != nil {
+		t.Fatalf("new sftp: %v", err)
+	}
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	local := filepath.Join(t.TempDir(), "ts3-1-1.tar.gz")
+	if err := os.WriteFile(local, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A previous attempt stopped after the first half.
+	if err := os.MkdirAll(filepath.Join(remoteDir, "ts3"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(remoteDir, "ts3", "ts3-1-1.tar.gz.part"), content[:18], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := target.Upload(context.Background(), local, "ts3/ts3-1-1.tar.gz")
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	stored, err := os.ReadFile(filepath.Join(remoteDir, "ts3", "ts3-1-1.tar.gz"))
+	if err != nil || string(stored) != string(content) {
+		t.Fatalf("stored %q (%v), want %q", stored, err, content)
+	}
+	if _, err := os.Stat(filepath.Join(remoteDir, "ts3", "ts3-1-1.tar.gz.part")); !os.IsNotExist(err) {
+		t.Fatalf("expected part file renamed, got %v", err)
+	}
+
+	restored := filepath.Join(t.TempDir(), "restore.tar.gz")
+	if err := target.Download(context.Background(), ref, restored); err != nil {
+		t.Fatalf("download %s: %v", ref, err)
+	}
+	if data, _ := os.ReadFile(restored); string(data) != string(content) {
+		t.Fatalf("downloaded %q", data)
+	}
+	if err := target.Download(context.Background(), "sftp://"+net.JoinHostPort(host, strconv.Itoa(port))+"/etc/passwd", restored); err == nil {
+		t.Fatal("expected a path outside the remote folder to be refused")
+	}
+}
+
+func TestSFTPRejectsUnpinnedOrMismatchedHostKey(t *testing.T) {
+	if _, err := NewSFTP(SFTPConfig{Host: "backup.example", User: "backup", Password: "secret"}); err == nil {
+		t.Fatal("expected a missing host key to be refused")
+	}
+
+	host, port, _ := startTestSFTPServer(t)
+	previousDelay := retryBaseDelay
+	retryBaseDelay = 0
+	t.Cleanup(func() { retryBaseDelay = previousDelay })
+	target, err := NewSFTP(SFTPConfig{Host: host, Port: port, User: "backup", Password: "secret", HostKey: "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", RemotePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(t.TempDir(), "a.tar.gz")
+	if err := os.WriteFile(local, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := target.Upload(context.Background(), local, "a.tar.gz"); err == nil {
+		t.Fatal("expected host key mismatch to fail the upload")
+	}
+}
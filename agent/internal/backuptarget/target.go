@@ -0,0 +1,91 @@
+// Package backuptarget uploads backup archives to remote storage and fetches
+// them back for restores. Every storage backend implements Target; the agent
+// picks one from the job's backup_target_type.
+package backuptarget
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Target is a remote backup store.
+type Target interface {
+	// Upload stores the file at localPath under name, relative to the
+	// target's configured folder, and returns the reference Download accepts.
+	// An interrupted upload of the same unchanged file resumes where it
+	// stopped when the backend supports it.
+	Upload(ctx context.Context, localPath, name string) (string, error)
+	// Download fetches a reference returned by Upload into localPath. It
+	// refuses references that point outside the configured target.
+	Download(ctx context.Context, ref, localPath string) error
+}
+
+const requestAttempts = 3
+
+var retryBaseDelay = 500 * time.Millisecond
+
+// errPermanent marks failures a retry cannot fix.
+type errPermanent struct {
+	err error
+}
+
+func (e *errPermanent) Error() string { return e.err.Error() }
+func (e *errPermanent) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	return &errPermanent{err: err}
+}
+
+// retry runs fn until it succeeds, fails permanently or the attempts run out.
+func retry(ctx context.Context, fn func() error) error {
+	var err error
+	delay := retryBaseDelay
+	for attempt := 1; attempt <= requestAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		var perm *errPermanent
+		if errors.As(err, &perm) || attempt == requestAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// joinRemote joins the configured folder and an object name with slashes.
+func joinRemote(folder, name string) string {
+	joined := path.Join("/", strings.TrimSpace(folder), strings.TrimSpace(name))
+	return strings.TrimPrefix(joined, "/")
+}
+
+// writeFileAtomically streams reader into localPath through a temporary file
+// so a failed download never leaves a truncated archive behind.
+func writeFileAtomically(localPath string, reader io.Reader) error {
+	tmpPath := localPath + ".download"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, localPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
@@ -39,6 +39,10 @@ type Config struct {
 	FileMaxUploadMB   int64
 	MaxJournalStreams int
 	StreamTTL         time.Duration
+	StateDir          string
+	// DisableJobPush turns off the WebSocket job push channel; jobs are then
+	// only fetched by interval polling.
+	DisableJobPush bool
 
 	// Sinusbot multi-instance settings
 	SinusbotInstallDir   string
@@ -63,6 +67,18 @@ func DefaultPath() (string, error) {
 	}
 }
 
+// DefaultStateDir returns the directory used for agent-local state such as the job journal.
+func DefaultStateDir() string {
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("PROGRAMDATA")
+		if programData == "" {
+			programData = `C:\\ProgramData`
+		}
+		return filepath.Join(programData, "easywi", "state")
+	}
+	return "/var/lib/easywi/agent"
+}
+
 // Load reads the configuration from the provided path, or the default path when empty.
 func Load(path string) (cfg Config, err error) {
 	if path == "" {
@@ -138,6 +154,14 @@ func Load(path string) (cfg Config, err error) {
 			if err != nil {
 				return Config{}, fmt.Errorf("parse stream_ttl: %w", err)
 			}
+		case "state_dir":
+			cfg.StateDir = value
+		case "job_push":
+			parsed, parseErr := strconv.ParseBool(value)
+			if parseErr != nil {
+				return Config{}, fmt.Errorf("parse job_push: %w", parseErr)
+			}
+			cfg.DisableJobPush = !parsed
 		case "version":
 			cfg.Version = value
 		case "update_url":
@@ -294,6 +318,9 @@ func validate(cfg Config) error {
 			return errors.New("file_base_dirs must be absolute")
 		}
 	}
+	if cfg.StateDir != "" && !filepath.IsAbs(cfg.StateDir) {
+		return errors.New("state_dir must be absolute")
+	}
 	if cfg.FileCacheSize < 0 {
 		return errors.New("file_cache_size must be positive")
 	}
@@ -322,6 +349,9 @@ func applyDefaults(cfg *Config) {
 	if cfg.StreamTTL == 0 {
 		cfg.StreamTTL = 75 * time.Second
 	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = DefaultStateDir()
+	}
 	if cfg.Version == "" {
 		cfg.Version = defaultVersion()
 	}
@@ -206,3 +206,30 @@ func TestLoadInvalidAgentIntervalEnvFallsBackToConfig(t *testing.T) {
 		t.Fatalf("PollInterval = %v, want %v", cfg.PollInterval, 45*time.Second)
 	}
 }
+
+func TestLoadStateDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "agent.conf")
+	base := []string{
+		"agent_id=agent-123",
+		"secret=super-secret",
+		"api_url=https://api.example.test",
+	}
+	if err := os.WriteFile(configPath, []byte(strings.Join(base, "\n")), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.StateDir != DefaultStateDir() {
+		t.Fatalf("StateDir = %q, want default %q", cfg.StateDir, DefaultStateDir())
+	}
+
+	if err := os.WriteFile(configPath, []byte(strings.Join(append(base, "state_dir=relative/state"), "\n")), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if _, err := Load(configPath); err == nil {
+		t.Fatal("expected relative state_dir to be rejected")
+	}
+}
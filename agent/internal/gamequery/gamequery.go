@@ -0,0 +1,202 @@
+// Package gamequery implements the game server query protocols shared by the
+// agent's instance queries and the status agent. Every engine splits network
+// I/O from a parser for the raw response, so parsers are tested against
+// recorded packets.
+package gamequery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a query when neither the target nor the context
+// sets a shorter one.
+const DefaultTimeout = 3 * time.Second
+
+// ErrMalformed is returned when a response does not follow the protocol.
+var ErrMalformed = errors.New("malformed query response")
+
+// DialFunc dials a target; the agent passes its dual-stack dialer.
+type DialFunc func(network, host, port string, timeout time.Duration) (net.Conn, error)
+
+// Target is the server to query.
+type Target struct {
+	Host string
+	Port int
+	// Password is the RCON password or the API token of the HTTP engines.
+	Password string
+	// Command is the RCON command whose output carries the player count.
+	Command string
+	Timeout time.Duration
+	Dial    DialFunc
+}
+
+// Result is what an engine learned about a server.
+type Result struct {
+	Name        string
+	Map         string
+	Version     string
+	Players     int
+	MaxPlayers  int
+	PlayerNames []string
+	// PlayersUnknown is set when the server answered without player counts,
+	// e.g. a Satisfactory health check without an API token.
+	PlayersUnknown bool
+}
+
+// Fields returns the result with the keys of the agent's query outputs.
+func (r Result) Fields() map[string]string {
+	fields := map[string]string{}
+	if !r.PlayersUnknown {
+		fields["players"] = strconv.Itoa(r.Players)
+		fields["max_players"] = strconv.Itoa(r.MaxPlayers)
+	}
+	for key, value := range map[string]string{
+		"name":         r.Name,
+		"map":          r.Map,
+		"version":      r.Version,
+		"player_names": strings.Join(r.PlayerNames, ","),
+	} {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// Engine is one query protocol. NeedsPassword marks engines that cannot
+// reach a server without Target.Password.
+type Engine struct {
+	Name          string
+	Query         func(ctx context.Context, target Target) (Result, error)
+	NeedsPassword bool
+}
+
+var engines = map[string]Engine{
+	"gamespy4":     {Name: "gamespy4", Query: QueryGameSpy4},
+	"quake3":       {Name: "quake3", Query: QueryQuake3},
+	"fivem":        {Name: "fivem", Query: QueryFiveM},
+	"terraria":     {Name: "terraria", Query: QueryTerraria},
+	"satisfactory": {Name: "satisfactory", Query: QuerySatisfactory},
+	"source_rcon":  {Name: "source_rcon", Query: QueryRCON, NeedsPassword: true},
+}
+
+var aliases = map[string]string{
+	"gamespy":    "gamespy4",
+	"gamespy_v4": "gamespy4",
+	"gs4":        "gamespy4",
+	"ut3":        "gamespy4",
+	"q3":         "quake3",
+	"getstatus":  "quake3",
+	"redm":       "fivem",
+	"cfx":        "fivem",
+	"tshock":     "terraria",
+	"rcon":       "source_rcon",
+}
+
+// Lookup returns the engine for a protocol name or alias.
+func Lookup(protocol string) (Engine, bool) {
+	name := strings.ToLower(strings.TrimSpace(protocol))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	engine, ok := engines[name]
+	return engine, ok
+}
+
+// Names lists the engine names.
+func Names() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t Target) timeout(ctx context.Context) time.Duration {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+func (t Target) address() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+func (t Target) validate() error {
+	if strings.TrimSpace(t.Host) == "" {
+		return fmt.Errorf("missing host")
+	}
+	if t.Port <= 0 || t.Port > 65535 {
+		return fmt.Errorf("invalid port %q", strconv.Itoa(t.Port))
+	}
+	return nil
+}
+
+// dial opens a connection whose deadline covers the whole query.
+func (t Target) dial(ctx context.Context, network string) (net.Conn, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	timeout := t.timeout(ctx)
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	var conn net.Conn
+	var err error
+	if t.Dial != nil {
+		conn, err = t.Dial(network, t.Host, strconv.Itoa(t.Port), timeout)
+	} else {
+		conn, err = net.DialTimeout(network, t.address(), timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", network, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// httpClient returns a client for the HTTP engines. Game servers serve
+// their APIs with self-signed certificates, so skipVerify accepts them.
+func (t Target) httpClient(ctx context.Context, skipVerify bool) *http.Client {
+	client := &http.Client{Timeout: t.timeout(ctx)}
+	if skipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return client
+}
+
+// readCString reads a NUL terminated string at offset and returns the
+// offset after the terminator.
+func readCString(payload []byte, offset int) (string, int, error) {
+	if offset >= len(payload) {
+		return "", offset, ErrMalformed
+	}
+	end := offset
+	for end < len(payload) && payload[end] != 0 {
+		end++
+	}
+	if end >= len(payload) {
+		return "", offset, ErrMalformed
+	}
+	return string(payload[offset:end]), end + 1, nil
+}
@@ -0,0 +1,208 @@
+package gamequery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	return data
+}
+
+func TestParsersReadRecordedFixtures(t *testing.T) {
+	challenge, err := ParseGameSpy4Challenge(fixture(t, "gamespy4_handshake.bin"))
+	if err != nil || challenge != 9513307 {
+		t.Fatalf("unexpected challenge %d (%v)", challenge, err)
+	}
+
+	fivem, err := ParseFiveMInfo(fixture(t, "fivem_info.json"))
+	if err != nil {
+		t.Fatalf("parse fivem info: %v", err)
+	}
+	if fivem.PlayerNames, err = ParseFiveMPlayers(fixture(t, "fivem_players.json")); err != nil {
+		t.Fatalf("parse fivem players: %v", err)
+	}
+
+	satisfactoryHealth, err := ParseSatisfactoryResponse(fixture(t, "satisfactory_health.json"))
+	if err != nil || !satisfactoryHealth.PlayersUnknown {
+		t.Fatalf("expected a health check without player counts, got %#v (%v)", satisfactoryHealth, err)
+	}
+	if _, ok := satisfactoryHealth.Fields()["players"]; ok {
+		t.Fatalf("health check must not report a player count: %#v", satisfactoryHealth.Fields())
+	}
+
+	parse := func(name string, parser func([]byte) (Result, error)) Result {
+		t.Helper()
+		result, err := parser(fixture(t, name))
+		if err != nil {
+			t.Fatalf("parse %s: %v", name, err)
+		}
+		return result
+	}
+	for name, test := range map[string]struct {
+		result Result
+		want   Result
+	}{
+		"gamespy4 minecraft": {
+			result: parse("gamespy4_minecraft.bin", func(data []byte) (Result, error) { return ParseGameSpy4([][]byte{data}) }),
+			want:   Result{Name: "A Minecraft Server", Map: "world", Version: "1.20.4", Players: 2, MaxPlayers: 20, PlayerNames: []string{"Steve", "Alex"}},
+		},
+		"gamespy4 split": {
+			result: func() Result {
+				result, err := ParseGameSpy4([][]byte{fixture(t, "gamespy4_split_2.bin"), fixture(t, "gamespy4_split_1.bin")})
+				if err != nil {
+					t.Fatalf("parse split packets: %v", err)
+				}
+				return result
+			}(),
+			want: Result{Name: "UT3 Deathmatch", Map: "DM-Deck", Version: "1.3", Players: 3, MaxPlayers: 16, PlayerNames: []string{"Alpha", "Bravo", "Charlie"}},
+		},
+		"quake3": {
+			result: parse("quake3_status.bin", ParseQuake3Status),
+			want:   Result{Name: "Red Arena", Map: "q3dm17", Version: "ioq3 1.36_GIT linux-x86_64", Players: 2, MaxPlayers: 12, PlayerNames: []string{"Visor", "Sarge"}},
+		},
+		"fivem": {
+			result: fivem,
+			want:   Result{Name: "Los Santos Roleplay", Version: "FXServer-master SERVER v1.0.0.7290 linux", MaxPlayers: 48, PlayerNames: []string{"Franklin", "Trevor"}},
+		},
+		"tshock": {
+			result: parse("tshock_status.json", ParseTShockStatus),
+			want:   Result{Name: "Journey Server", Map: "Forest of Doom", Version: "v1.4.4.9", Players: 1, MaxPlayers: 8, PlayerNames: []string{"Guide"}},
+		},
+		"satisfactory": {
+			result: parse("satisfactory_state.json", ParseSatisfactoryResponse),
+			want:   Result{Name: "Factory One", Players: 2, MaxPlayers: 4},
+		},
+		"source rcon status": {
+			result: parse("rcon_status.bin", func(data []byte) (Result, error) {
+				_, _, body, err := ParseRCONPacket(data)
+				if err != nil {
+					return Result{}, err
+				}
+				return ParseRCONPlayers(body)
+			}),
+			want: Result{Name: "Easy-Wi Public", Map: "de_dust2", Version: "1.38.8.1/13881", Players: 3, MaxPlayers: 16},
+		},
+	} {
+		got, want := test.result.Fields(), test.want.Fields()
+		if strings.Join(mapEntries(got), "|") != strings.Join(mapEntries(want), "|") {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	list, err := ParseRCONPlayers("There are 2 of a max of 20 players online: Steve, Alex")
+	if err != nil || list.Players != 2 || list.MaxPlayers != 20 || len(list.PlayerNames) != 2 {
+		t.Fatalf("unexpected minecraft list result %#v (%v)", list, err)
+	}
+	if _, err := ParseTShockStatus([]byte(`{"status":"401","error":"Not authorized"}`)); err == nil {
+		t.Fatal("expected a TShock error status to fail")
+	}
+	if _, err := ParseQuake3Status([]byte("\xFF\xFF\xFF\xFFprint\nbanned\n")); err == nil {
+		t.Fatal("expected a non-status reply to fail")
+	}
+}
+
+func mapEntries(values map[string]string) []string {
+	entries := make([]string, 0, len(values))
+	for _, key := range []string{"name", "map", "version", "players", "max_players", "player_names"} {
+		entries = append(entries, key+"="+values[key])
+	}
+	return entries
+}
+
+func TestQueryGameSpy4SendsChallenge(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer server.Close()
+	requests := make(chan []byte, 2)
+	replies := [][]byte{fixture(t, "gamespy4_handshake.bin"), fixture(t, "gamespy4_minecraft.bin")}
+	go func() {
+		buffer := make([]byte, 512)
+		for _, reply := range replies {
+			n, addr, err := server.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			requests <- append([]byte{}, buffer[:n]...)
+			_, _ = server.WriteTo(reply, addr)
+		}
+	}()
+
+	engine, ok := Lookup("UT3")
+	if !ok || engine.Name != "gamespy4" {
+		t.Fatalf("expected the ut3 alias to resolve to gamespy4, got %#v", engine)
+	}
+	result, err := engine.Query(context.Background(), Target{Host: "127.0.0.1", Port: server.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if result.Players != 2 || result.MaxPlayers != 20 {
+		t.Fatalf("unexpected result %#v", result)
+	}
+	<-requests
+	request := <-requests
+	want := []byte{0xFE, 0xFD, 0x00, 0x01, 0x02, 0x03, 0x04, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0x01}
+	binary.BigEndian.PutUint32(want[7:11], 9513307)
+	if !bytes.Equal(request, want) {
+		t.Fatalf("unexpected status request % x", request)
+	}
+}
+
+func TestQueryRCONAuthenticatesAndRunsCommand(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	commands := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, password, err := readRCONPacket(conn)
+		if err != nil {
+			return
+		}
+		authID := int32(rconAuthID)
+		if password != "secret" {
+			authID = -1
+		}
+		_ = writeRCONPacket(conn, rconAuthID, rconTypeResponse, "")
+		_ = writeRCONPacket(conn, authID, rconTypeAuthResp, "")
+		_, _, command, err := readRCONPacket(conn)
+		if err != nil {
+			return
+		}
+		commands <- command
+		_ = writeRCONPacket(conn, rconCommandID, rconTypeResponse, "There are 1/10 players online: Notch")
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	result, err := QueryRCON(context.Background(), Target{Host: "127.0.0.1", Port: port, Password: "secret", Command: "list"})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if command := <-commands; command != "list" || result.Players != 1 || result.MaxPlayers != 10 {
+		t.Fatalf("unexpected command %q or result %#v", command, result)
+	}
+	if _, err := QueryRCON(context.Background(), Target{Host: "127.0.0.1", Port: port}); err == nil {
+		t.Fatal("expected a missing password to be rejected")
+	}
+}
@@ -0,0 +1,208 @@
+package gamequery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gameSpy4TypeStat      = 0x00
+	gameSpy4TypeHandshake = 0x09
+	gameSpy4LastPacket    = 0x80
+	gameSpy4MaxPackets    = 16
+)
+
+var (
+	gameSpy4Magic    = []byte{0xFE, 0xFD}
+	gameSpy4Session  = []byte{0x01, 0x02, 0x03, 0x04}
+	gameSpy4SplitTag = []byte("splitnum\x00")
+)
+
+// QueryGameSpy4 runs a GameSpy v4 (UT3 query) full status request. Servers
+// that skip the challenge handshake, like older Battlefield builds, do not
+// answer it; the request then goes out without a challenge.
+func QueryGameSpy4(ctx context.Context, target Target) (Result, error) {
+	conn, err := target.dial(ctx, "udp")
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	deadline := time.Now().Add(target.timeout(ctx))
+	buffer := make([]byte, 4096)
+	handshake := append(append([]byte{}, gameSpy4Magic...), gameSpy4TypeHandshake)
+	handshake = append(handshake, gameSpy4Session...)
+	var challenge []byte
+	if _, err := conn.Write(handshake); err != nil {
+		return Result{}, fmt.Errorf("send handshake: %w", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Until(deadline) / 3))
+	if n, err := conn.Read(buffer); err == nil {
+		if value, err := ParseGameSpy4Challenge(buffer[:n]); err == nil {
+			challenge = make([]byte, 4)
+			binary.BigEndian.PutUint32(challenge, uint32(value))
+		}
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	request := append(append([]byte{}, gameSpy4Magic...), gameSpy4TypeStat)
+	request = append(request, gameSpy4Session...)
+	request = append(request, challenge...)
+	request = append(request, 0xFF, 0xFF, 0xFF, 0x01)
+	if _, err := conn.Write(request); err != nil {
+		return Result{}, fmt.Errorf("send status request: %w", err)
+	}
+
+	var packets [][]byte
+	for len(packets) < gameSpy4MaxPackets {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			return Result{}, fmt.Errorf("read status: %w", err)
+		}
+		packet := append([]byte{}, buffer[:n]...)
+		packets = append(packets, packet)
+		if index, err := gameSpy4PacketIndex(packet); err != nil || index&gameSpy4LastPacket != 0 {
+			break
+		}
+	}
+	return ParseGameSpy4(packets)
+}
+
+// ParseGameSpy4Challenge reads the challenge number of a handshake reply.
+func ParseGameSpy4Challenge(packet []byte) (int32, error) {
+	if len(packet) < 6 || packet[0] != gameSpy4TypeHandshake || !bytes.Equal(packet[1:5], gameSpy4Session) {
+		return 0, ErrMalformed
+	}
+	value, _, err := readCString(packet, 5)
+	if err != nil {
+		return 0, err
+	}
+	challenge, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: challenge %q", ErrMalformed, value)
+	}
+	return int32(challenge), nil
+}
+
+func gameSpy4PacketIndex(packet []byte) (byte, error) {
+	header := 5 + len(gameSpy4SplitTag)
+	if len(packet) <= header || packet[0] != gameSpy4TypeStat || !bytes.Equal(packet[5:header], gameSpy4SplitTag) {
+		return 0, ErrMalformed
+	}
+	return packet[header], nil
+}
+
+// ParseGameSpy4 parses the packets of a full status reply in any order.
+// Each packet carries sections: 0x00 holds key/value pairs, 0x01 and 0x02
+// hold player and team fields, each a name, an offset byte and values.
+func ParseGameSpy4(packets [][]byte) (Result, error) {
+	type indexed struct {
+		index byte
+		body  []byte
+	}
+	ordered := make([]indexed, 0, len(packets))
+	for _, packet := range packets {
+		index, err := gameSpy4PacketIndex(packet)
+		if err != nil {
+			return Result{}, err
+		}
+		ordered = append(ordered, indexed{index: index &^ gameSpy4LastPacket, body: packet[5+len(gameSpy4SplitTag)+1:]})
+	}
+	if len(ordered) == 0 {
+		return Result{}, ErrMalformed
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].index < ordered[j].index })
+
+	info := map[string]string{}
+	var players []string
+	for _, packet := range ordered {
+		parseGameSpy4Body(packet.body, info, &players)
+	}
+
+	result := Result{
+		Name:        info["hostname"],
+		Map:         firstValue(info, "mapname", "map"),
+		Version:     firstValue(info, "gamever", "version"),
+		PlayerNames: players,
+	}
+	result.Players = len(players)
+	if value, err := strconv.Atoi(info["numplayers"]); err == nil {
+		result.Players = value
+	}
+	result.MaxPlayers, _ = strconv.Atoi(info["maxplayers"])
+	if len(info) == 0 && len(players) == 0 {
+		return Result{}, ErrMalformed
+	}
+	return result, nil
+}
+
+// parseGameSpy4Body reads what it can; a section cut off at the end of a
+// packet continues in the next one.
+func parseGameSpy4Body(body []byte, info map[string]string, players *[]string) {
+	offset := 0
+	for offset < len(body) {
+		section := body[offset]
+		offset++
+		switch section {
+		case 0x00:
+			for offset < len(body) {
+				key, next, err := readCString(body, offset)
+				if err != nil {
+					return
+				}
+				offset = next
+				if key == "" {
+					break
+				}
+				value, next, err := readCString(body, offset)
+				if err != nil {
+					return
+				}
+				offset = next
+				info[key] = value
+			}
+		case 0x01, 0x02:
+			for offset < len(body) {
+				field, next, err := readCString(body, offset)
+				if err != nil {
+					return
+				}
+				offset = next
+				if field == "" {
+					break
+				}
+				offset++ // offset of the first value in this packet
+				for offset < len(body) {
+					value, next, err := readCString(body, offset)
+					if err != nil {
+						return
+					}
+					offset = next
+					if value == "" {
+						break
+					}
+					if section == 0x01 && field == "player_" {
+						*players = append(*players, value)
+					}
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+func firstValue(values map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(values[key]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package gamequery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxHTTPResponseBytes = 1 << 20
+
+// QueryFiveM reads info.json and players.json of a FiveM or RedM server.
+func QueryFiveM(ctx context.Context, target Target) (Result, error) {
+	if err := target.validate(); err != nil {
+		return Result{}, err
+	}
+	client := target.httpClient(ctx, false)
+	base := "http://" + target.address()
+	info, err := httpGet(ctx, client, base+"/info.json", nil)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := ParseFiveMInfo(info)
+	if err != nil {
+		return Result{}, err
+	}
+	players, err := httpGet(ctx, client, base+"/players.json", nil)
+	if err != nil {
+		return Result{}, err
+	}
+	if result.PlayerNames, err = ParseFiveMPlayers(players); err != nil {
+		return Result{}, err
+	}
+	result.Players = len(result.PlayerNames)
+	return result, nil
+}
+
+// ParseFiveMInfo reads server name, slots and build from info.json.
+func ParseFiveMInfo(data []byte) (Result, error) {
+	var info struct {
+		Server string            `json:"server"`
+		Vars   map[string]string `json:"vars"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil || info.Vars == nil {
+		return Result{}, fmt.Errorf("%w: info.json", ErrMalformed)
+	}
+	result := Result{
+		Name:    firstValue(info.Vars, "sv_projectName", "sv_hostname"),
+		Map:     firstValue(info.Vars, "mapname"),
+		Version: info.Server,
+	}
+	result.MaxPlayers, _ = strconv.Atoi(firstValue(info.Vars, "sv_maxClients", "sv_maxclients"))
+	return result, nil
+}
+
+// ParseFiveMPlayers returns the player names of players.json.
+func ParseFiveMPlayers(data []byte) ([]string, error) {
+	var players []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &players); err != nil {
+		return nil, fmt.Errorf("%w: players.json", ErrMalformed)
+	}
+	names := make([]string, 0, len(players))
+	for _, player := range players {
+		names = append(names, player.Name)
+	}
+	return names, nil
+}
+
+// QueryTerraria reads /v2/server/status of the TShock REST API. The query
+// port is the REST port; the token is only needed when the endpoint is
+// restricted.
+func QueryTerraria(ctx context.Context, target Target) (Result, error) {
+	if err := target.validate(); err != nil {
+		return Result{}, err
+	}
+	params := url.Values{"players": {"true"}}
+	if target.Password != "" {
+		params.Set("token", target.Password)
+	}
+	data, err := httpGet(ctx, target.httpClient(ctx, false), "http://"+target.address()+"/v2/server/status?"+params.Encode(), nil)
+	if err != nil {
+		return Result{}, err
+	}
+	return ParseTShockStatus(data)
+}
+
+// ParseTShockStatus parses a TShock status reply. TShock reports failures
+// in the body with a status field other than 200.
+func ParseTShockStatus(data []byte) (Result, error) {
+	var status struct {
+		Status        json.RawMessage `json:"status"`
+		Error         string          `json:"error"`
+		Name          string          `json:"name"`
+		World         string          `json:"world"`
+		ServerVersion string          `json:"serverversion"`
+		PlayerCount   int             `json:"playercount"`
+		MaxPlayers    int             `json:"maxplayers"`
+		Players       json.RawMessage `json:"players"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return Result{}, fmt.Errorf("%w: tshock status", ErrMalformed)
+	}
+	code := strings.Trim(string(status.Status), `"`)
+	if code != "200" {
+		return Result{}, fmt.Errorf("tshock status %s: %s", code, status.Error)
+	}
+	result := Result{
+		Name:       status.Name,
+		Map:        status.World,
+		Version:    status.ServerVersion,
+		Players:    status.PlayerCount,
+		MaxPlayers: status.MaxPlayers,
+	}
+	var players []struct {
+		Nickname string `json:"nickname"`
+	}
+	var legacy string
+	if err := json.Unmarshal(status.Players, &players); err == nil {
+		for _, player := range players {
+			result.PlayerNames = append(result.PlayerNames, player.Nickname)
+		}
+	} else if err := json.Unmarshal(status.Players, &legacy); err == nil && strings.TrimSpace(legacy) != "" {
+		for _, name := range strings.Split(legacy, ",") {
+			result.PlayerNames = append(result.PlayerNames, strings.TrimSpace(name))
+		}
+	}
+	return result, nil
+}
+
+// QuerySatisfactory calls the HTTPS API of a Satisfactory dedicated server.
+// With an API token it reads QueryServerState; without one only HealthCheck
+// is allowed, which tells whether the server is up but not who is on it.
+func QuerySatisfactory(ctx context.Context, target Target) (Result, error) {
+	if err := target.validate(); err != nil {
+		return Result{}, err
+	}
+	request := map[string]any{"function": "HealthCheck", "data": map[string]string{"clientCustomData": ""}}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if target.Password != "" {
+		request = map[string]any{"function": "QueryServerState"}
+		headers["Authorization"] = "Bearer " + target.Password
+	}
+	body, _ := json.Marshal(request)
+	data, err := httpPost(ctx, target.httpClient(ctx, true), "https://"+target.address()+"/api/v1", body, headers)
+	if err != nil {
+		return Result{}, err
+	}
+	return ParseSatisfactoryResponse(data)
+}
+
+// ParseSatisfactoryResponse parses a QueryServerState or HealthCheck reply.
+func ParseSatisfactoryResponse(data []byte) (Result, error) {
+	var response struct {
+		ErrorCode    string `json:"errorCode"`
+		ErrorMessage string `json:"errorMessage"`
+		Data         struct {
+			Health          string `json:"health"`
+			ServerGameState *struct {
+				ActiveSessionName   string `json:"activeSessionName"`
+				NumConnectedPlayers int    `json:"numConnectedPlayers"`
+				PlayerLimit         int    `json:"playerLimit"`
+			} `json:"serverGameState"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return Result{}, fmt.Errorf("%w: satisfactory api", ErrMalformed)
+	}
+	if response.ErrorCode != "" {
+		return Result{}, fmt.Errorf("satisfactory api %s: %s", response.ErrorCode, response.ErrorMessage)
+	}
+	if state := response.Data.ServerGameState; state != nil {
+		return Result{
+			Name:       state.ActiveSessionName,
+			Players:    state.NumConnectedPlayers,
+			MaxPlayers: state.PlayerLimit,
+		}, nil
+	}
+	switch response.Data.Health {
+	case "healthy", "slow":
+		return Result{PlayersUnknown: true}, nil
+	case "":
+		return Result{}, fmt.Errorf("%w: satisfactory api", ErrMalformed)
+	default:
+		return Result{}, fmt.Errorf("satisfactory server health: %s", response.Data.Health)
+	}
+}
+
+func httpGet(ctx context.Context, client *http.Client, target string, headers map[string]string) ([]byte, error) {
+	return httpDo(ctx, client, http.MethodGet, target, nil, headers)
+}
+
+func httpPost(ctx context.Context, client *http.Client, target string, body []byte, headers map[string]string) ([]byte, error) {
+	return httpDo(ctx, client, http.MethodPost, target, body, headers)
+}
+
+func httpDo(ctx context.Context, client *http.Client, method, target string, body []byte, headers map[string]string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxHTTPResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 && len(data) == 0 {
+		return nil, fmt.Errorf("%s %s: %s", method, request.URL.Path, response.Status)
+	}
+	return data, nil
+}
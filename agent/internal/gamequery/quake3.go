@@ -0,0 +1,78 @@
+package gamequery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	quake3Request        = []byte("\xFF\xFF\xFF\xFFgetstatus\n")
+	quake3ResponseHeader = []byte("\xFF\xFF\xFF\xFFstatusResponse")
+	quake3ColorCode      = regexp.MustCompile(`\^[0-9A-Za-z]`)
+)
+
+// QueryQuake3 sends getstatus, which every id Tech 3 derivative answers
+// (Quake 3, Urban Terror, Call of Duty 1-4, Wolfenstein: ET).
+func QueryQuake3(ctx context.Context, target Target) (Result, error) {
+	conn, err := target.dial(ctx, "udp")
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write(quake3Request); err != nil {
+		return Result{}, fmt.Errorf("send getstatus: %w", err)
+	}
+	buffer := make([]byte, 16*1024)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		return Result{}, fmt.Errorf("read status: %w", err)
+	}
+	return ParseQuake3Status(buffer[:n])
+}
+
+// ParseQuake3Status parses a statusResponse: a line of \key\value server
+// variables followed by one `score ping "name"` line per player.
+func ParseQuake3Status(packet []byte) (Result, error) {
+	if !bytes.HasPrefix(packet, quake3ResponseHeader) {
+		return Result{}, ErrMalformed
+	}
+	lines := strings.Split(strings.TrimRight(string(packet[len(quake3ResponseHeader):]), "\x00"), "\n")
+	if len(lines) < 2 {
+		return Result{}, ErrMalformed
+	}
+	vars := map[string]string{}
+	parts := strings.Split(strings.TrimPrefix(lines[1], "\\"), "\\")
+	for idx := 0; idx+1 < len(parts); idx += 2 {
+		vars[strings.ToLower(parts[idx])] = parts[idx+1]
+	}
+	if len(vars) == 0 {
+		return Result{}, ErrMalformed
+	}
+	result := Result{
+		Name:    stripQuake3Colors(vars["sv_hostname"]),
+		Map:     vars["mapname"],
+		Version: firstValue(vars, "version", "shortversion"),
+	}
+	result.MaxPlayers, _ = strconv.Atoi(firstValue(vars, "sv_maxclients", "sv_maxplayers"))
+	for _, line := range lines[2:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name := line
+		if start := strings.Index(line, "\""); start >= 0 {
+			name = strings.TrimSuffix(line[start+1:], "\"")
+		}
+		result.PlayerNames = append(result.PlayerNames, stripQuake3Colors(name))
+	}
+	result.Players = len(result.PlayerNames)
+	return result, nil
+}
+
+func stripQuake3Colors(value string) string {
+	return strings.TrimSpace(quake3ColorCode.ReplaceAllString(value, ""))
+}
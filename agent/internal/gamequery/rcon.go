@@ -0,0 +1,174 @@
+package gamequery
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rconTypeResponse = 0
+	rconTypeCommand  = 2
+	rconTypeAuthResp = 2
+	rconTypeAuth     = 3
+
+	rconAuthID      = 1
+	rconCommandID   = 2
+	rconMaxPacket   = 4096 + 10
+	rconSplitLength = 4000
+
+	// DefaultRCONCommand prints the player count on Source servers.
+	DefaultRCONCommand = "status"
+)
+
+var (
+	// status on Source and Rust: "players : 3 humans, 1 bots (16/0 max)".
+	rconSourcePlayers = regexp.MustCompile(`(?m)^players\s*:\s*(\d+)[^(\n]*\((\d+)(?:/\d+)?\s*max\)`)
+	rconSourceHost    = regexp.MustCompile(`(?m)^hostname\s*:\s*(.+)$`)
+	rconSourceMap     = regexp.MustCompile(`(?m)^map\s*:\s*(\S+)`)
+	rconSourceVersion = regexp.MustCompile(`(?m)^version\s*:\s*(\S+)`)
+	// list on Minecraft: "There are 3 of a max of 20 players online: a, b, c"
+	// or "There are 3/20 players online:".
+	rconMinecraftList = regexp.MustCompile(`There are (\d+)(?: of a max of |/)(\d+) players online:?(.*)`)
+)
+
+// QueryRCON authenticates with the Source RCON protocol, also spoken by
+// Minecraft, Rust and ARK, and parses the player count from the output of
+// Target.Command (status by default, list for Minecraft).
+func QueryRCON(ctx context.Context, target Target) (Result, error) {
+	if target.Password == "" {
+		return Result{}, fmt.Errorf("rcon password is required")
+	}
+	command := strings.TrimSpace(target.Command)
+	if command == "" {
+		command = DefaultRCONCommand
+	}
+	conn, err := target.dial(ctx, "tcp")
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := writeRCONPacket(conn, rconAuthID, rconTypeAuth, target.Password); err != nil {
+		return Result{}, fmt.Errorf("send rcon auth: %w", err)
+	}
+	// Source servers send an empty response value before the auth reply.
+	for {
+		id, packetType, _, err := readRCONPacket(conn)
+		if err != nil {
+			return Result{}, fmt.Errorf("read rcon auth: %w", err)
+		}
+		if packetType != rconTypeAuthResp {
+			continue
+		}
+		if id == -1 {
+			return Result{}, fmt.Errorf("rcon authentication failed")
+		}
+		break
+	}
+
+	if err := writeRCONPacket(conn, rconCommandID, rconTypeCommand, command); err != nil {
+		return Result{}, fmt.Errorf("send rcon command: %w", err)
+	}
+	var output strings.Builder
+	for {
+		id, packetType, body, err := readRCONPacket(conn)
+		if err != nil {
+			if output.Len() > 0 {
+				break
+			}
+			return Result{}, fmt.Errorf("read rcon response: %w", err)
+		}
+		if id != rconCommandID || packetType != rconTypeResponse {
+			continue
+		}
+		output.WriteString(body)
+		if len(body) < rconSplitLength {
+			break
+		}
+		// A full packet means the output may continue in the next one.
+		_ = conn.SetReadDeadline(time.Now().Add(250 * time.Millisecond))
+	}
+	return ParseRCONPlayers(output.String())
+}
+
+func writeRCONPacket(conn net.Conn, id, packetType int32, body string) error {
+	var packet bytes.Buffer
+	_ = binary.Write(&packet, binary.LittleEndian, int32(len(body)+10))
+	_ = binary.Write(&packet, binary.LittleEndian, id)
+	_ = binary.Write(&packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+	_, err := conn.Write(packet.Bytes())
+	return err
+}
+
+func readRCONPacket(reader io.Reader) (int32, int32, string, error) {
+	var size int32
+	if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	if size < 10 || size > rconMaxPacket {
+		return 0, 0, "", fmt.Errorf("%w: rcon packet size %d", ErrMalformed, size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, 0, "", err
+	}
+	id, packetType, body, err := ParseRCONPacket(payload)
+	return id, packetType, body, err
+}
+
+// ParseRCONPacket parses a packet without its leading size field.
+func ParseRCONPacket(payload []byte) (int32, int32, string, error) {
+	if len(payload) < 10 {
+		return 0, 0, "", ErrMalformed
+	}
+	id := int32(binary.LittleEndian.Uint32(payload[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(payload[4:8]))
+	body := payload[8:]
+	if end := bytes.IndexByte(body, 0); end >= 0 {
+		body = body[:end]
+	}
+	return id, packetType, string(body), nil
+}
+
+// ParseRCONPlayers reads the player count from the output of Source status
+// or Minecraft list.
+func ParseRCONPlayers(output string) (Result, error) {
+	if match := rconMinecraftList.FindStringSubmatch(output); match != nil {
+		result := Result{}
+		result.Players, _ = strconv.Atoi(match[1])
+		result.MaxPlayers, _ = strconv.Atoi(match[2])
+		for _, name := range strings.Split(match[3], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				result.PlayerNames = append(result.PlayerNames, name)
+			}
+		}
+		return result, nil
+	}
+	match := rconSourcePlayers.FindStringSubmatch(output)
+	if match == nil {
+		return Result{}, fmt.Errorf("%w: no player count in rcon output", ErrMalformed)
+	}
+	result := Result{}
+	result.Players, _ = strconv.Atoi(match[1])
+	result.MaxPlayers, _ = strconv.Atoi(match[2])
+	if host := rconSourceHost.FindStringSubmatch(output); host != nil {
+		result.Name = strings.TrimSpace(host[1])
+	}
+	if mapName := rconSourceMap.FindStringSubmatch(output); mapName != nil {
+		result.Map = mapName[1]
+	}
+	if version := rconSourceVersion.FindStringSubmatch(output); version != nil {
+		result.Version = version[1]
+	}
+	return result, nil
+}
@@ -97,7 +97,7 @@ func (s *Server) handleCheckFree(w http.ResponseWriter, r *http.Request) {
 			results = append(results, result)
 			continue
 		}
-		free, err := checkPortFree(result.Proto, check.Port)
+		free, err := CheckPortFree(result.Proto, check.Port)
 		if err != nil {
 			result.Error = err.Error()
 			results = append(results, result)
@@ -109,7 +109,10 @@ func (s *Server) handleCheckFree(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, checkFreeResponse{Results: results})
 }
 
-func checkPortFree(proto string, port int) (bool, error) {
+// CheckPortFree reports whether proto ("tcp" or "udp") port can be bound on all
+// interfaces right now. The agent's port allocator uses it to skip ports taken
+// by processes it does not manage.
+func CheckPortFree(proto string, port int) (bool, error) {
 	address := net.JoinHostPort("0.0.0.0", fmtPort(port))
 	if proto == "udp" {
 		conn, err := net.ListenPacket("udp", address)
@@ -9,6 +9,8 @@ type Job struct {
 	Payload       map[string]any `json:"payload"`
 	CorrelationID string         `json:"correlation_id,omitempty"`
 	CreatedAt     time.Time      `json:"created_at"`
+	// CancelRequested is set by the panel when it re-sends a job that should be aborted.
+	CancelRequested bool `json:"cancel_requested,omitempty"`
 }
 
 // Result describes a completed job payload.
@@ -18,3 +20,17 @@ type Result struct {
 	Output    map[string]string `json:"output,omitempty"`
 	Completed time.Time         `json:"completed_at"`
 }
+
+// ScheduledRun reports a job the agent ran on its own schedule rather than on
+// request of the panel.
+type ScheduledRun struct {
+	ScheduleID    string            `json:"schedule_id"`
+	JobID         string            `json:"job_id"`
+	JobType       string            `json:"job_type"`
+	Status        string            `json:"status"`
+	Output        map[string]string `json:"output,omitempty"`
+	RequestID     string            `json:"request_id"`
+	CorrelationID string            `json:"correlation_id"`
+	StartedAt     time.Time         `json:"started_at"`
+	Completed     time.Time         `json:"completed_at"`
+}
@@ -7,10 +7,23 @@ type instanceMetricSample struct {
 	CPUPercent      *float64
 	MemCurrentBytes *int64
 	TasksCurrent    *int
+	Throttling      *instanceThrottling
 	CollectedAt     time.Time
 	ErrorCode       string
 }
 
+// instanceThrottling holds the cumulative cgroup counters that show an
+// instance running into its limits.
+type instanceThrottling struct {
+	CPUThrottledPeriods uint64
+	CPUThrottledUsec    uint64
+	MemoryHighEvents    uint64
+	MemoryMaxEvents     uint64
+	OOMKills            uint64
+	TasksMaxEvents      uint64
+	IOStallUsec         uint64
+}
+
 // CollectInstanceMetrics gathers per-instance metrics from platform-specific sources.
 func CollectInstanceMetrics() map[string]any {
 	samples, supported, reason := collectInstanceMetricsPlatform()
@@ -29,6 +42,17 @@ func CollectInstanceMetrics() map[string]any {
 		if sample.TasksCurrent != nil {
 			entry["tasks_current"] = *sample.TasksCurrent
 		}
+		if sample.Throttling != nil {
+			entry["throttling"] = map[string]any{
+				"cpu_throttled_periods": sample.Throttling.CPUThrottledPeriods,
+				"cpu_throttled_usec":    sample.Throttling.CPUThrottledUsec,
+				"memory_high_events":    sample.Throttling.MemoryHighEvents,
+				"memory_max_events":     sample.Throttling.MemoryMaxEvents,
+				"oom_kills":             sample.Throttling.OOMKills,
+				"tasks_max_events":      sample.Throttling.TasksMaxEvents,
+				"io_stall_usec":         sample.Throttling.IOStallUsec,
+			}
+		}
 		if sample.ErrorCode != "" {
 			entry["error_code"] = sample.ErrorCode
 		}
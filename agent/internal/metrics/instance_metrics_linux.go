@@ -4,7 +4,9 @@ package metrics
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,6 +18,9 @@ type cpuDeltaEntry struct {
 	at        time.Time
 }
 
+// cgroupRoot is where the unified cgroup hierarchy is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
 var (
 	instanceCPUCacheMu sync.Mutex
 	instanceCPUCache   = map[string]cpuDeltaEntry{}
@@ -56,6 +61,8 @@ func collectInstanceMetricsPlatform() ([]instanceMetricSample, bool, string) {
 			sample.TasksCurrent = &tasksCurrent
 		}
 
+		sample.Throttling = readCgroupThrottling(props["ControlGroup"])
+
 		samples = append(samples, sample)
 	}
 
@@ -118,6 +125,7 @@ func showUnitProperties(unit string) (map[string]string, error) {
 		"-p", "CPUUsageNSec",
 		"-p", "MemoryCurrent",
 		"-p", "TasksCurrent",
+		"-p", "ControlGroup",
 	).Output()
 	if err != nil {
 		return nil, err
@@ -142,6 +150,72 @@ func showUnitProperties(unit string) (map[string]string, error) {
 	return props, nil
 }
 
+// readCgroupThrottling reads the throttling counters of a unit's cgroup. It
+// returns nil without cgroup v2 or when the unit has no cgroup.
+func readCgroupThrottling(controlGroup string) *instanceThrottling {
+	controlGroup = strings.TrimSpace(controlGroup)
+	if controlGroup == "" || !strings.HasPrefix(controlGroup, "/") {
+		return nil
+	}
+	dir := filepath.Join(cgroupRoot, filepath.Clean(controlGroup))
+	cpuStat, err := readCgroupKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil
+	}
+	memoryEvents, _ := readCgroupKeyValues(filepath.Join(dir, "memory.events"))
+	pidsEvents, _ := readCgroupKeyValues(filepath.Join(dir, "pids.events"))
+	return &instanceThrottling{
+		CPUThrottledPeriods: cpuStat["nr_throttled"],
+		CPUThrottledUsec:    cpuStat["throttled_usec"],
+		MemoryHighEvents:    memoryEvents["high"],
+		MemoryMaxEvents:     memoryEvents["max"],
+		OOMKills:            memoryEvents["oom_kill"],
+		TasksMaxEvents:      pidsEvents["max"],
+		IOStallUsec:         readPressureTotal(filepath.Join(dir, "io.pressure")),
+	}
+}
+
+// readCgroupKeyValues parses flat-keyed cgroup files such as cpu.stat.
+func readCgroupKeyValues(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, ok := parseUint64(fields[1]); ok {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+// readPressureTotal returns the total stall time in microseconds from the
+// "some" line of a pressure file, or zero when PSI is unavailable.
+func readPressureTotal(path string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if raw, found := strings.CutPrefix(field, "total="); found {
+				total, _ := parseUint64(raw)
+				return total
+			}
+		}
+	}
+	return 0
+}
+
 func parseUint64(value string) (uint64, bool) {
 	if value == "" || value == "[not set]" {
 		return 0, false
@@ -0,0 +1,113 @@
+//go:build linux
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFixture(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir %s: %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func useCgroupRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	original := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = original })
+	return root
+}
+
+func TestReadCgroupThrottlingReadsUnitCounters(t *testing.T) {
+	root := useCgroupRoot(t)
+	dir := filepath.Join(root, "system.slice", "gs-42.service")
+	writeCgroupFixture(t, dir, "cpu.stat", "usage_usec 912345\nuser_usec 800000\nsystem_usec 112345\nnr_periods 1200\nnr_throttled 37\nthrottled_usec 451200\n")
+	writeCgroupFixture(t, dir, "memory.events", "low 0\nhigh 12\nmax 3\noom 1\noom_kill 1\noom_group_kill 0\n")
+	writeCgroupFixture(t, dir, "pids.events", "max 5\n")
+	writeCgroupFixture(t, dir, "io.pressure", "some avg10=0.00 avg60=0.12 avg300=0.05 total=78123\nfull avg10=0.00 avg60=0.02 avg300=0.01 total=12000\n")
+
+	throttling := readCgroupThrottling("/system.slice/gs-42.service")
+	if throttling == nil {
+		t.Fatal("expected throttling counters")
+	}
+	want := instanceThrottling{
+		CPUThrottledPeriods: 37,
+		CPUThrottledUsec:    451200,
+		MemoryHighEvents:    12,
+		MemoryMaxEvents:     3,
+		OOMKills:            1,
+		TasksMaxEvents:      5,
+		IOStallUsec:         78123,
+	}
+	if *throttling != want {
+		t.Fatalf("unexpected throttling %+v, want %+v", *throttling, want)
+	}
+}
+
+func TestReadCgroupThrottlingWithoutCgroupV2(t *testing.T) {
+	root := useCgroupRoot(t)
+	if throttling := readCgroupThrottling(""); throttling != nil {
+		t.Fatalf("expected nil without a control group, got %+v", throttling)
+	}
+	if throttling := readCgroupThrottling("/system.slice/gs-7.service"); throttling != nil {
+		t.Fatalf("expected nil without cpu.stat, got %+v", throttling)
+	}
+
+	// A unit without memory and pids controllers still reports its CPU counters.
+	dir := filepath.Join(root, "system.slice", "gs-8.service")
+	writeCgroupFixture(t, dir, "cpu.stat", "nr_throttled 2\nthrottled_usec 90\n")
+	throttling := readCgroupThrottling("/system.slice/gs-8.service")
+	if throttling == nil || throttling.CPUThrottledPeriods != 2 || throttling.MemoryMaxEvents != 0 || throttling.IOStallUsec != 0 {
+		t.Fatalf("unexpected partial throttling %+v", throttling)
+	}
+}
+
+func TestReadCgroupThrottlingStaysInsideCgroupRoot(t *testing.T) {
+	root := useCgroupRoot(t)
+	writeCgroupFixture(t, filepath.Join(root, "gs-9.service"), "cpu.stat", "nr_throttled 4\n")
+	if throttling := readCgroupThrottling("/../../gs-9.service"); throttling == nil || throttling.CPUThrottledPeriods != 4 {
+		t.Fatalf("expected the control group to resolve below the cgroup root, got %+v", throttling)
+	}
+	if throttling := readCgroupThrottling("system.slice/gs-9.service"); throttling != nil {
+		t.Fatalf("expected relative control groups to be ignored, got %+v", throttling)
+	}
+}
+
+func TestReadCgroupKeyValuesSkipsMalformedLines(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFixture(t, dir, "memory.events", "high 4\nmax\noom_kill -1\nbroken line here\n\nmax 2\n")
+	values, err := readCgroupKeyValues(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(values) != 2 || values["high"] != 4 || values["max"] != 2 {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if _, err := readCgroupKeyValues(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestReadPressureTotalUsesSomeLine(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFixture(t, dir, "cpu.pressure", "full avg10=0.00 avg60=0.00 avg300=0.00 total=999\nsome avg10=1.50 avg60=0.80 avg300=0.20 total=4242\n")
+	writeCgroupFixture(t, dir, "memory.pressure", "some avg10=0.00 avg60=0.00 avg300=0.00\n")
+	if total := readPressureTotal(filepath.Join(dir, "cpu.pressure")); total != 4242 {
+		t.Fatalf("expected the some total, got %d", total)
+	}
+	if total := readPressureTotal(filepath.Join(dir, "memory.pressure")); total != 0 {
+		t.Fatalf("expected zero without a total, got %d", total)
+	}
+	if total := readPressureTotal(filepath.Join(dir, "io.pressure")); total != 0 {
+		t.Fatalf("expected zero without PSI, got %d", total)
+	}
+}
@@ -0,0 +1,377 @@
+// Package portalloc hands out host ports to game server instances. Every
+// assignment is a lease recorded per instance, protocol and port in a JSON
+// file, so ports survive agent restarts, never collide between instances and
+// return to the pool when an instance is deleted.
+package portalloc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+
+	MinPort = 1024
+	MaxPort = 65535
+)
+
+var (
+	// ErrConflict is returned when a requested port is leased by another
+	// instance or already bound on the host.
+	ErrConflict = errors.New("port conflict")
+	// ErrExhausted is returned when the ranges have no free port left.
+	ErrExhausted = errors.New("no free port in range")
+)
+
+// Range is an inclusive port range.
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (r Range) String() string {
+	return strconv.Itoa(r.Start) + "-" + strconv.Itoa(r.End)
+}
+
+// ParseRanges reads "27015-27115,30000-39999"; a single number is a one-port range.
+func ParseRanges(raw string) ([]Range, error) {
+	ranges := []Range{}
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		startRaw, endRaw, isRange := strings.Cut(part, "-")
+		if !isRange {
+			endRaw = startRaw
+		}
+		start, errStart := strconv.Atoi(strings.TrimSpace(startRaw))
+		end, errEnd := strconv.Atoi(strings.TrimSpace(endRaw))
+		if errStart != nil || errEnd != nil || start < MinPort || end > MaxPort || start > end {
+			return nil, fmt.Errorf("invalid port range %q (ports must be within %d-%d)", part, MinPort, MaxPort)
+		}
+		ranges = append(ranges, Range{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// Lease is one port held by an instance for one protocol.
+type Lease struct {
+	InstanceID string    `json:"instance_id"`
+	CustomerID string    `json:"customer_id,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	Proto      string    `json:"proto"`
+	Port       int       `json:"port"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PortRequest asks for one labelled port on one or more protocols. Port
+// pins a specific port; zero picks the lowest free port from the ranges.
+type PortRequest struct {
+	Label  string
+	Protos []string
+	Port   int
+}
+
+// Request is the complete set of ports an instance needs. Leases the
+// instance holds for labels no longer requested are released.
+type Request struct {
+	InstanceID string
+	CustomerID string
+	Ports      []PortRequest
+	Ranges     []Range
+	// SkipHostCheck leaves pinned ports unprobed, for instances that may
+	// already be listening on them.
+	SkipHostCheck bool
+}
+
+// ProbeFunc reports whether proto/port is currently free on the host.
+type ProbeFunc func(proto string, port int) (bool, error)
+
+// Allocator is the persistent lease table.
+type Allocator struct {
+	mu     sync.Mutex
+	path   string
+	leases []Lease
+	probe  ProbeFunc
+	now    func() time.Time
+}
+
+type leaseFile struct {
+	Leases    []Lease   `json:"leases"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Open loads the lease table at path; a missing file is an empty table.
+// probe may be nil to skip host checks.
+func Open(path string, probe ProbeFunc) (*Allocator, error) {
+	allocator := &Allocator{path: path, probe: probe, now: time.Now}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return allocator, nil
+		}
+		return nil, fmt.Errorf("read port leases: %w", err)
+	}
+	var file leaseFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("decode port leases: %w", err)
+	}
+	allocator.leases = file.Leases
+	return allocator, nil
+}
+
+// Allocate leases the requested ports to the instance and returns one port
+// per PortRequest, in request order. Ports the instance already holds under
+// the same label are kept, so repeating a request is a no-op.
+func (a *Allocator) Allocate(req Request) ([]int, error) {
+	if strings.TrimSpace(req.InstanceID) == "" {
+		return nil, fmt.Errorf("instance id is required")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	held := map[string]map[string]Lease{}
+	others := map[string]map[int]string{ProtoTCP: {}, ProtoUDP: {}}
+	for _, lease := range a.leases {
+		if lease.InstanceID == req.InstanceID {
+			if held[lease.Label] == nil {
+				held[lease.Label] = map[string]Lease{}
+			}
+			held[lease.Label][lease.Proto] = lease
+			continue
+		}
+		others[lease.Proto][lease.Port] = lease.InstanceID
+	}
+
+	now := a.now().UTC()
+	// An instance's labels never share a port number, even on different
+	// protocols; only separate instances interleave tcp and udp leases.
+	taken := map[int]bool{}
+	// Ports kept under their labels are off limits for new picks even when
+	// their label comes later in the request.
+	kept := map[int]bool{}
+	for idx, portReq := range req.Ports {
+		protos, err := normalizeProtos(portReq.Protos)
+		if err != nil {
+			return nil, err
+		}
+		if port := heldPort(held[requestLabel(portReq, idx)], protos); port != 0 && portReq.Port == 0 {
+			kept[port] = true
+		}
+	}
+	granted := make([]Lease, 0, len(req.Ports))
+	ports := make([]int, 0, len(req.Ports))
+	for idx, portReq := range req.Ports {
+		label := requestLabel(portReq, idx)
+		protos, _ := normalizeProtos(portReq.Protos)
+		port := portReq.Port
+		if port == 0 {
+			port = heldPort(held[label], protos)
+		}
+		if port != 0 {
+			if err := a.checkPinned(port, protos, others, taken, held[label], req.SkipHostCheck || portReq.Port == 0); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			if port, err = a.pickFree(req.Ranges, protos, others, taken, kept); err != nil {
+				return nil, fmt.Errorf("%s (%s): %w", label, strings.Join(protos, "+"), err)
+			}
+		}
+		taken[port] = true
+		for _, proto := range protos {
+			lease := Lease{InstanceID: req.InstanceID, CustomerID: req.CustomerID, Label: label, Proto: proto, Port: port, CreatedAt: now}
+			if existing, ok := held[label][proto]; ok && existing.Port == port {
+				lease.CreatedAt = existing.CreatedAt
+			}
+			granted = append(granted, lease)
+		}
+		ports = append(ports, port)
+	}
+
+	next := make([]Lease, 0, len(a.leases)+len(granted))
+	for _, lease := range a.leases {
+		if lease.InstanceID != req.InstanceID {
+			next = append(next, lease)
+		}
+	}
+	next = append(next, granted...)
+	if err := a.persist(next); err != nil {
+		return nil, err
+	}
+	a.leases = next
+	return ports, nil
+}
+
+func requestLabel(portReq PortRequest, idx int) string {
+	if label := strings.TrimSpace(portReq.Label); label != "" {
+		return label
+	}
+	return "port" + strconv.Itoa(idx+1)
+}
+
+// heldPort returns the port the instance holds for every proto under one
+// label, or zero.
+func heldPort(held map[string]Lease, protos []string) int {
+	port := 0
+	for _, proto := range protos {
+		lease, ok := held[proto]
+		if !ok || (port != 0 && lease.Port != port) {
+			return 0
+		}
+		port = lease.Port
+	}
+	return port
+}
+
+func (a *Allocator) checkPinned(port int, protos []string, others map[string]map[int]string, taken map[int]bool, held map[string]Lease, skipHost bool) error {
+	if port < 1 || port > MaxPort {
+		return fmt.Errorf("invalid port %d", port)
+	}
+	if taken[port] {
+		return fmt.Errorf("%w: %d is requested twice", ErrConflict, port)
+	}
+	for _, proto := range protos {
+		if owner, ok := others[proto][port]; ok {
+			return fmt.Errorf("%w: %d/%s is leased by instance %s", ErrConflict, port, proto, owner)
+		}
+		if lease, ok := held[proto]; skipHost || (ok && lease.Port == port) {
+			continue
+		}
+		if !a.hostFree(proto, port) {
+			return fmt.Errorf("%w: %d/%s is in use on the host", ErrConflict, port, proto)
+		}
+	}
+	return nil
+}
+
+func (a *Allocator) pickFree(ranges []Range, protos []string, others map[string]map[int]string, taken, kept map[int]bool) (int, error) {
+	if len(ranges) == 0 {
+		return 0, fmt.Errorf("no port range configured")
+	}
+	for _, r := range ranges {
+	candidates:
+		for port := r.Start; port <= r.End; port++ {
+			if taken[port] || kept[port] {
+				continue
+			}
+			for _, proto := range protos {
+				if _, leased := others[proto][port]; leased {
+					continue candidates
+				}
+			}
+			for _, proto := range protos {
+				if !a.hostFree(proto, port) {
+					continue candidates
+				}
+			}
+			return port, nil
+		}
+	}
+	return 0, ErrExhausted
+}
+
+func (a *Allocator) hostFree(proto string, port int) bool {
+	if a.probe == nil {
+		return true
+	}
+	free, err := a.probe(proto, port)
+	return err == nil && free
+}
+
+// Release drops every lease of the instance and returns them.
+func (a *Allocator) Release(instanceID string) ([]Lease, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	released := []Lease{}
+	next := make([]Lease, 0, len(a.leases))
+	for _, lease := range a.leases {
+		if lease.InstanceID == instanceID {
+			released = append(released, lease)
+			continue
+		}
+		next = append(next, lease)
+	}
+	if len(released) == 0 {
+		return released, nil
+	}
+	if err := a.persist(next); err != nil {
+		return nil, err
+	}
+	a.leases = next
+	return released, nil
+}
+
+// Leases returns the lease table sorted by port and protocol. A non-empty
+// instanceID limits it to that instance.
+func (a *Allocator) Leases(instanceID string) []Lease {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	leases := make([]Lease, 0, len(a.leases))
+	for _, lease := range a.leases {
+		if instanceID == "" || lease.InstanceID == instanceID {
+			leases = append(leases, lease)
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Port != leases[j].Port {
+			return leases[i].Port < leases[j].Port
+		}
+		return leases[i].Proto < leases[j].Proto
+	})
+	return leases
+}
+
+func (a *Allocator) persist(leases []Lease) error {
+	encoded, err := json.MarshalIndent(leaseFile{Leases: leases, UpdatedAt: a.now().UTC()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode port leases: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0o750); err != nil {
+		return fmt.Errorf("create port lease dir: %w", err)
+	}
+	tmpPath := a.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(encoded, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write port leases: %w", err)
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("commit port leases: %w", err)
+	}
+	return nil
+}
+
+// normalizeProtos maps "tcp", "udp" and "both"/"tcp+udp" (the default) to
+// the protocols a lease is taken for.
+func normalizeProtos(protos []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, proto := range protos {
+		switch strings.ToLower(strings.TrimSpace(proto)) {
+		case ProtoTCP:
+			set[ProtoTCP] = true
+		case ProtoUDP:
+			set[ProtoUDP] = true
+		case "", "both", "tcp+udp", "tcp/udp", "any":
+			set[ProtoTCP], set[ProtoUDP] = true, true
+		default:
+			return nil, fmt.Errorf("unsupported port protocol %q", proto)
+		}
+	}
+	if len(set) == 0 {
+		set[ProtoTCP], set[ProtoUDP] = true, true
+	}
+	normalized := make([]string, 0, len(set))
+	for _, proto := range []string{ProtoTCP, ProtoUDP} {
+		if set[proto] {
+			normalized = append(normalized, proto)
+		}
+	}
+	return normalized, nil
+}
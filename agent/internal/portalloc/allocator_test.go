@@ -0,0 +1,113 @@
+package portalloc
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestAllocator(t *testing.T, path string, busy map[string]bool) *Allocator {
+	t.Helper()
+	allocator, err := Open(path, func(proto string, port int) (bool, error) {
+		return !busy[fmt.Sprintf("%s/%d", proto, port)], nil
+	})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return allocator
+}
+
+func TestAllocateIsProtocolAwareIdempotentAndPersistent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	allocator := openTestAllocator(t, path, nil)
+	ranges := []Range{{Start: 30000, End: 30009}}
+
+	first, err := allocator.Allocate(Request{InstanceID: "1", Ranges: ranges, Ports: []PortRequest{
+		{Label: "game", Protos: []string{"udp"}},
+		{Label: "rcon", Protos: []string{"tcp"}},
+	}})
+	if err != nil || !reflect.DeepEqual(first, []int{30000, 30001}) {
+		t.Fatalf("first allocation = %v, %v", first, err)
+	}
+
+	// 30000 is only leased for udp, so a tcp-only port may reuse it.
+	second, err := allocator.Allocate(Request{InstanceID: "2", Ranges: ranges, Ports: []PortRequest{
+		{Label: "web", Protos: []string{"tcp"}},
+		{Label: "game"},
+	}})
+	if err != nil || !reflect.DeepEqual(second, []int{30000, 30002}) {
+		t.Fatalf("second allocation = %v, %v", second, err)
+	}
+
+	reopened := openTestAllocator(t, path, nil)
+	again, err := reopened.Allocate(Request{InstanceID: "1", Ranges: ranges, Ports: []PortRequest{
+		{Label: "game", Protos: []string{"udp"}},
+		{Label: "rcon", Protos: []string{"tcp"}},
+		{Label: "query", Protos: []string{"udp"}},
+	}})
+	if err != nil || !reflect.DeepEqual(again, []int{30000, 30001, 30003}) {
+		t.Fatalf("repeated allocation = %v, %v", again, err)
+	}
+	if leases := reopened.Leases(""); len(leases) != 6 {
+		t.Fatalf("lease table = %+v", leases)
+	}
+
+	// A new label listed before a kept one must not take the kept port, and
+	// labels dropped from the request are released.
+	reordered, err := reopened.Allocate(Request{InstanceID: "1", Ranges: ranges, Ports: []PortRequest{
+		{Label: "motd", Protos: []string{"udp"}},
+		{Label: "game", Protos: []string{"udp"}},
+	}})
+	if err != nil || !reflect.DeepEqual(reordered, []int{30001, 30000}) {
+		t.Fatalf("reordered allocation = %v, %v", reordered, err)
+	}
+	if leases := reopened.Leases("1"); len(leases) != 2 {
+		t.Fatalf("instance leases = %+v", leases)
+	}
+}
+
+func TestAllocateRejectsConflictsAndSkipsBusyHostPorts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	busy := map[string]bool{"tcp/30000": true}
+	allocator := openTestAllocator(t, path, busy)
+	ranges := []Range{{Start: 30000, End: 30001}}
+
+	ports, err := allocator.Allocate(Request{InstanceID: "1", Ranges: ranges, Ports: []PortRequest{{Label: "game"}}})
+	if err != nil || !reflect.DeepEqual(ports, []int{30001}) {
+		t.Fatalf("allocation = %v, %v; want the host-bound port skipped", ports, err)
+	}
+	if _, err := allocator.Allocate(Request{InstanceID: "2", Ranges: ranges, Ports: []PortRequest{{Label: "game", Port: 30001}}}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("pinned leased port: %v, want ErrConflict", err)
+	}
+	if _, err := allocator.Allocate(Request{InstanceID: "2", Ranges: ranges, Ports: []PortRequest{{Label: "game", Port: 30000}}}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("pinned host-bound port: %v, want ErrConflict", err)
+	}
+	if _, err := allocator.Allocate(Request{InstanceID: "2", Ranges: ranges, SkipHostCheck: true, Ports: []PortRequest{{Label: "game", Port: 30000}}}); err != nil {
+		t.Fatalf("pinned port without host check: %v", err)
+	}
+	if _, err := allocator.Allocate(Request{InstanceID: "3", Ranges: ranges, Ports: []PortRequest{{Label: "game"}}}); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("full range: %v, want ErrExhausted", err)
+	}
+
+	released, err := allocator.Release("1")
+	if err != nil || len(released) != 2 {
+		t.Fatalf("release = %+v, %v", released, err)
+	}
+	if ports, err := allocator.Allocate(Request{InstanceID: "3", Ranges: ranges, Ports: []PortRequest{{Label: "game"}}}); err != nil || ports[0] != 30001 {
+		t.Fatalf("allocation after release = %v, %v", ports, err)
+	}
+}
+
+func TestParseRanges(t *testing.T) {
+	ranges, err := ParseRanges("27015-27020, 30000")
+	if err != nil || !reflect.DeepEqual(ranges, []Range{{27015, 27020}, {30000, 30000}}) {
+		t.Fatalf("ranges = %v, %v", ranges, err)
+	}
+	for _, raw := range []string{"80-90", "40000-30000", "60000-70000", "abc"} {
+		if _, err := ParseRanges(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDailyAtFixedTime(t *testing.T) {
+	s, err := Parse("30 3 * * *")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	from := time.Date(2026, 3, 10, 4, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 3, 11, 3, 30, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestNextStepsAndRanges(t *testing.T) {
+	s, err := Parse("*/15 9-17 * * 1-5")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// Friday 17:50 rolls over to Monday 09:00.
+	from := time.Date(2026, 3, 13, 17, 50, 0, 0, time.UTC)
+	want := time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestNextDayOfMonthOrWeekday(t *testing.T) {
+	s, err := Parse("0 0 1 * 7")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	// Sunday 2026-03-15 comes before April 1st.
+	from := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestParseMacrosAndEvery(t *testing.T) {
+	hourly, err := Parse("@hourly")
+	if err != nil {
+		t.Fatalf("parse hourly: %v", err)
+	}
+	from := time.Date(2026, 3, 10, 4, 20, 0, 0, time.UTC)
+	if got := hourly.Next(from); !got.Equal(time.Date(2026, 3, 10, 5, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected hourly activation %s", got)
+	}
+	every, err := Parse("@every 10m")
+	if err != nil {
+		t.Fatalf("parse every: %v", err)
+	}
+	if got := every.Next(from); !got.Equal(from.Add(10 * time.Minute)) {
+		t.Fatalf("unexpected @every activation %s", got)
+	}
+}
+
+func TestParseRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "5-1 * * * *", "*/0 * * * *", "@every 10s"} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatalf("expected %q to be rejected", expr)
+		}
+	}
+}
+
+func TestNextNeverMatching(t *testing.T) {
+	s, err := Parse("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Fatalf("expected no activation, got %s", got)
+	}
+}
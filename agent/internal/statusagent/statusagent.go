@@ -16,6 +16,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"easywi/agent/internal/gamequery"
 )
 
 type ServerConfig struct {
@@ -65,17 +67,29 @@ func checkServer(s ServerConfig) CheckResult {
 	started := time.Now()
 	r := CheckResult{ServerID: s.ServerID, ObservedAt: time.Now().UTC().Format(time.RFC3339), Status: "offline"}
 	ok := false
+	engine, hasEngine := gamequery.Lookup(s.Type)
 	switch {
+	case strings.Contains(s.Type, "terraria"):
+		// Terraria's game port has no status protocol; without a TShock REST
+		// port configured a TCP connect decides.
+		tshock, _ := gamequery.Lookup("terraria")
+		if ok = engineCheck(tshock, s, &r); !ok {
+			r.Error = nil
+			ok = tcpPing(s.Host, s.Port)
+		}
+	case hasEngine && !engine.NeedsPassword:
+		ok = engineCheck(engine, s, &r)
 	case strings.Contains(s.Type, "minecraft_bedrock"):
 		ok = udpPing(s.Host, s.Port, []byte{0x01})
 	case strings.Contains(s.Type, "minecraft"):
 		ok = tcpPing(s.Host, s.Port)
-	case strings.Contains(s.Type, "fivem"):
-		ok = httpPing(fmt.Sprintf("http://%s:%d/players.json", s.Host, s.Port), &r)
+	case strings.Contains(s.Type, "fivem"), strings.Contains(s.Type, "redm"):
+		fivem, _ := gamequery.Lookup("fivem")
+		ok = engineCheck(fivem, s, &r)
+	case strings.Contains(s.Type, "valheim"):
+		ok = a2sPing(s.Host, s.Port+1, &r)
 	case strings.Contains(s.Type, "factorio"):
 		ok = udpPing(s.Host, s.Port, []byte{0x01})
-	case strings.Contains(s.Type, "terraria"):
-		ok = tcpPing(s.Host, s.Port)
 	default:
 		ok = a2sPing(s.Host, s.Port, &r)
 	}
@@ -88,6 +102,32 @@ func checkServer(s ServerConfig) CheckResult {
 	return r
 }
 
+// engineCheck runs a shared query engine. The status agent has no server
+// credentials: RCON servers keep the A2S check of their game port, and
+// token-only APIs report without counts.
+func engineCheck(engine gamequery.Engine, s ServerConfig, r *CheckResult) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	result, err := engine.Query(ctx, gamequery.Target{Host: s.Host, Port: s.Port})
+	if err != nil {
+		e := err.Error()
+		r.Error = &e
+		return false
+	}
+	if result.Map != "" {
+		r.Map = &result.Map
+	}
+	if !result.PlayersUnknown {
+		r.PlayersOnline = &result.Players
+		r.PlayersMax = &result.MaxPlayers
+	}
+	r.Raw = map[string]any{"engine": engine.Name}
+	if result.Name != "" {
+		r.Raw["name"] = result.Name
+	}
+	return true
+}
+
 func a2sPing(host string, port int, r *CheckResult) bool {
 	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	conn, err := net.DialTimeout("udp", addr, 2*time.Second)
@@ -146,20 +186,6 @@ func udpPing(host string, port int, p []byte) bool {
 	_, err = conn.Read(b)
 	return err == nil
 }
-func httpPing(url string, r *CheckResult) bool {
-	c := http.Client{Timeout: 4 * time.Second}
-	resp, err := c.Get(url)
-	if err != nil {
-		return false
-	}
-	defer func() { _ = resp.Body.Close() }()
-	var players []any
-	_ = json.NewDecoder(resp.Body).Decode(&players)
-	p := len(players)
-	r.PlayersOnline = &p
-	return resp.StatusCode < 400
-}
-
 func (c *client) pullServers(ctx context.Context) ([]ServerConfig, error) {
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/api/agent/servers", nil)
 	resp, err := c.http.Do(req)
@@ -0,0 +1,39 @@
+package statusagent
+
+import (
+	"net"
+	"testing"
+)
+
+// serveA2SInfo answers one A2S_INFO request with a minimal info response.
+func serveA2SInfo(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 1400)
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		response := []byte{0xFF, 0xFF, 0xFF, 0xFF, 'I', 0x11}
+		response = append(response, "de_dust2\x00"...)
+		response = append(response, 0x00, 0x00, 0x00, 0x00, 7, 16, 0x00)
+		_, _ = conn.WriteTo(response, addr)
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestCheckServerKeepsA2SForRconServers(t *testing.T) {
+	port := serveA2SInfo(t)
+	result := checkServer(ServerConfig{ServerID: 1, Host: "127.0.0.1", Port: port, Type: "rcon"})
+	if !result.Reachable || result.Status != "online" {
+		t.Fatalf("expected an RCON server to be checked through A2S, got %+v (error %v)", result, result.Error)
+	}
+	if result.Map == nil || *result.Map != "de_dust2" {
+		t.Fatalf("expected the A2S map, got %v", result.Map)
+	}
+}
@@ -322,17 +322,18 @@ func verifySHA256(path, expected string) (err error) {
 	return nil
 }
 
+// atomicSwap moves the update into place and keeps the replaced binary at
+// PreviousBinaryPath so a failed update can be rolled back.
 func atomicSwap(binaryPath, updatePath string) error {
-	backupPath := binaryPath + ".bak"
-	_ = os.Remove(backupPath)
-	if err := os.Rename(binaryPath, backupPath); err != nil {
+	previousPath := PreviousBinaryPath(binaryPath)
+	_ = os.Remove(previousPath)
+	if err := os.Rename(binaryPath, previousPath); err != nil {
 		return fmt.Errorf("backup binary: %w", err)
 	}
 	if err := os.Rename(updatePath, binaryPath); err != nil {
-		_ = os.Rename(backupPath, binaryPath)
+		_ = os.Rename(previousPath, binaryPath)
 		return fmt.Errorf("swap binary: %w", err)
 	}
-	_ = os.Remove(backupPath)
 	return nil
 }
 
@@ -0,0 +1,255 @@
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Update states recorded while an A/B update is in flight.
+const (
+	UpdateStatusPending    = "pending"
+	UpdateStatusConfirmed  = "confirmed"
+	UpdateStatusRolledBack = "rolled_back"
+)
+
+// UpdateDeadlineExitCode is the exit status used by an agent that could not
+// confirm a pending update in time. The rollback drop-in excludes it from
+// Restart= so the unit fails and systemd starts the rollback helper.
+const UpdateDeadlineExitCode = 75
+
+const (
+	agentServiceName          = "easywi-agent.service"
+	updateRollbackServiceName = "easywi-agent-rollback.service"
+	updateWatchdogUnitName    = "easywi-agent-update-watchdog"
+	updateRollbackDropInName  = "update-rollback.conf"
+)
+
+var systemdUnitDir = "/etc/systemd/system"
+
+// UpdateState is persisted in the agent state directory between the old
+// binary swapping in an update and the new binary confirming it.
+type UpdateState struct {
+	Status       string    `json:"status"`
+	JobID        string    `json:"job_id,omitempty"`
+	FromVersion  string    `json:"from_version,omitempty"`
+	ToVersion    string    `json:"to_version,omitempty"`
+	BinaryPath   string    `json:"binary_path"`
+	PreviousPath string    `json:"previous_path"`
+	StartedAt    time.Time `json:"started_at"`
+	Deadline     time.Time `json:"deadline"`
+	ConfirmedAt  time.Time `json:"confirmed_at,omitzero"`
+	RolledBackAt time.Time `json:"rolled_back_at,omitzero"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// UpdateStatePath returns the location of the update state file.
+func UpdateStatePath(stateDir string) string {
+	return filepath.Join(stateDir, "update_state.json")
+}
+
+// PreviousBinaryPath returns where the binary replaced by an update is kept.
+func PreviousBinaryPath(binaryPath string) string {
+	return binaryPath + ".previous"
+}
+
+// LoadUpdateState reads the update state; ok is false when no update was recorded.
+func LoadUpdateState(path string) (state UpdateState, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return UpdateState{}, false, nil
+	}
+	if err != nil {
+		return UpdateState{}, false, fmt.Errorf("read update state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return UpdateState{}, false, fmt.Errorf("decode update state: %w", err)
+	}
+	return state, true, nil
+}
+
+// SaveUpdateState atomically replaces the update state file.
+func SaveUpdateState(path string, state UpdateState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode update state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create update state dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write update state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("replace update state: %w", err)
+	}
+	return nil
+}
+
+// RestorePreviousBinary puts the binary kept by the last update back in place.
+// The rejected binary is kept next to it with a .failed suffix for inspection.
+func RestorePreviousBinary(binaryPath string) error {
+	previousPath := PreviousBinaryPath(binaryPath)
+	if _, err := os.Stat(previousPath); err != nil {
+		return fmt.Errorf("previous binary unavailable: %w", err)
+	}
+	failedPath := binaryPath + ".failed"
+	_ = os.Remove(failedPath)
+	if err := os.Rename(binaryPath, failedPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("move rejected binary: %w", err)
+	}
+	if err := os.Rename(previousPath, binaryPath); err != nil {
+		_ = os.Rename(failedPath, binaryPath)
+		return fmt.Errorf("restore previous binary: %w", err)
+	}
+	return nil
+}
+
+// ConfirmPendingUpdate marks a pending update as healthy and drops the previous binary.
+func ConfirmPendingUpdate(statePath string, now time.Time) (UpdateState, error) {
+	state, ok, err := LoadUpdateState(statePath)
+	if err != nil {
+		return UpdateState{}, err
+	}
+	if !ok || state.Status != UpdateStatusPending {
+		return state, fmt.Errorf("no pending update to confirm")
+	}
+	state.Status = UpdateStatusConfirmed
+	state.ConfirmedAt = now.UTC()
+	if err := SaveUpdateState(statePath, state); err != nil {
+		return UpdateState{}, err
+	}
+	if state.PreviousPath != "" {
+		_ = os.Remove(state.PreviousPath)
+	}
+	return state, nil
+}
+
+// RollbackPendingUpdate restores the previous binary if an update is still
+// pending. It reports false when there was nothing to roll back.
+func RollbackPendingUpdate(statePath, reason string, now time.Time) (UpdateState, bool, error) {
+	state, ok, err := LoadUpdateState(statePath)
+	if err != nil {
+		return UpdateState{}, false, err
+	}
+	if !ok || state.Status != UpdateStatusPending {
+		return state, false, nil
+	}
+	if err := RestorePreviousBinary(state.BinaryPath); err != nil {
+		return state, false, err
+	}
+	state.Status = UpdateStatusRolledBack
+	state.RolledBackAt = now.UTC()
+	state.Reason = reason
+	if err := SaveUpdateState(statePath, state); err != nil {
+		return state, true, err
+	}
+	return state, true, nil
+}
+
+// ArmUpdateRollback installs the systemd OnFailure helper for a pending
+// update and schedules a watchdog that rolls back an agent which hangs
+// instead of exiting. The helper always runs the previous binary because the
+// new one may not start at all.
+func ArmUpdateRollback(state UpdateState, configPath string) error {
+	unitPath := filepath.Join(systemdUnitDir, updateRollbackServiceName)
+	if err := os.WriteFile(unitPath, []byte(renderUpdateRollbackUnit(state.PreviousPath, configPath)), 0o644); err != nil {
+		return fmt.Errorf("write rollback unit: %w", err)
+	}
+	dropInDir := filepath.Join(systemdUnitDir, agentServiceName+".d")
+	if err := os.MkdirAll(dropInDir, 0o755); err != nil {
+		return fmt.Errorf("create drop-in dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dropInDir, updateRollbackDropInName), []byte(renderUpdateRollbackDropIn()), 0o644); err != nil {
+		return fmt.Errorf("write rollback drop-in: %w", err)
+	}
+	if err := runSystemctl("daemon-reload"); err != nil {
+		return err
+	}
+
+	_ = runSystemctl("stop", updateWatchdogUnitName+".timer")
+	_ = runSystemctl("reset-failed", updateWatchdogUnitName+".service")
+	delay := time.Until(state.Deadline) + time.Minute
+	if delay < time.Minute {
+		delay = time.Minute
+	}
+	args := []string{
+		"--unit=" + updateWatchdogUnitName,
+		"--on-active=" + strconv.Itoa(int(delay.Seconds())) + "s",
+		"--timer-property=AccuracySec=5s",
+	}
+	args = append(args, updateRollbackCommand(state.PreviousPath, configPath)...)
+	if output, err := exec.Command("systemd-run", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("schedule update watchdog: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// DisarmUpdateRollback removes the rollback drop-in and watchdog once an
+// update has been confirmed or rolled back. The helper unit itself stays in
+// place; without the drop-in nothing references it.
+func DisarmUpdateRollback() error {
+	_ = runSystemctl("stop", updateWatchdogUnitName+".timer")
+	dropIn := filepath.Join(systemdUnitDir, agentServiceName+".d", updateRollbackDropInName)
+	if err := os.Remove(dropIn); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove rollback drop-in: %w", err)
+	}
+	return runSystemctl("daemon-reload")
+}
+
+// StopAgentService stops the agent unit so its binary can be replaced.
+func StopAgentService() error {
+	return runSystemctl("stop", agentServiceName)
+}
+
+// StartAgentService clears a failed state left by the rollback drop-in and starts the agent.
+func StartAgentService() error {
+	_ = runSystemctl("reset-failed", agentServiceName)
+	return runSystemctl("start", "--no-block", agentServiceName)
+}
+
+func renderUpdateRollbackUnit(previousPath, configPath string) string {
+	return fmt.Sprintf(`[Unit]
+Description=EasyWI Agent update rollback
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Join(updateRollbackCommand(previousPath, configPath), " "))
+}
+
+func renderUpdateRollbackDropIn() string {
+	return fmt.Sprintf(`[Unit]
+OnFailure=%s
+StartLimitIntervalSec=300
+StartLimitBurst=3
+
+[Service]
+RestartPreventExitStatus=%d
+`, updateRollbackServiceName, UpdateDeadlineExitCode)
+}
+
+func updateRollbackCommand(previousPath, configPath string) []string {
+	command := []string{previousPath, "--rollback-update"}
+	if configPath != "" {
+		command = append(command, "--config", configPath)
+	}
+	return command
+}
+
+func runSystemctl(args ...string) error {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
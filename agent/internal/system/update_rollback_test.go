@@ -0,0 +1,105 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAtomicSwapKeepsPreviousBinary(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "easywi-agent")
+	update := filepath.Join(dir, "agent.update")
+	writeFile(t, binary, "old")
+	writeFile(t, update, "new")
+
+	if err := atomicSwap(binary, update); err != nil {
+		t.Fatalf("swap: %v", err)
+	}
+	if readFile(t, binary) != "new" || readFile(t, PreviousBinaryPath(binary)) != "old" {
+		t.Fatal("expected new binary in place and old binary kept as previous")
+	}
+}
+
+func TestRollbackPendingUpdateRestoresPreviousBinary(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "easywi-agent")
+	writeFile(t, binary, "new")
+	writeFile(t, PreviousBinaryPath(binary), "old")
+	statePath := UpdateStatePath(dir)
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := SaveUpdateState(statePath, UpdateState{
+		Status:       UpdateStatusPending,
+		BinaryPath:   binary,
+		PreviousPath: PreviousBinaryPath(binary),
+		StartedAt:    now,
+		Deadline:     now.Add(5 * time.Minute),
+	}); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	state, rolledBack, err := RollbackPendingUpdate(statePath, "heartbeat deadline exceeded", now.Add(6*time.Minute))
+	if err != nil || !rolledBack {
+		t.Fatalf("expected rollback, got %v (%v)", rolledBack, err)
+	}
+	if readFile(t, binary) != "old" || readFile(t, binary+".failed") != "new" {
+		t.Fatal("expected previous binary restored and rejected binary kept as .failed")
+	}
+	if state.Status != UpdateStatusRolledBack || state.Reason == "" || state.RolledBackAt.IsZero() {
+		t.Fatalf("unexpected rollback state: %#v", state)
+	}
+
+	_, rolledBack, err = RollbackPendingUpdate(statePath, "again", now)
+	if err != nil || rolledBack {
+		t.Fatalf("expected second rollback to be a no-op, got %v (%v)", rolledBack, err)
+	}
+}
+
+func TestConfirmPendingUpdateDropsPreviousBinary(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "easywi-agent")
+	writeFile(t, binary, "new")
+	writeFile(t, PreviousBinaryPath(binary), "old")
+	statePath := UpdateStatePath(dir)
+	_ = SaveUpdateState(statePath, UpdateState{Status: UpdateStatusPending, BinaryPath: binary, PreviousPath: PreviousBinaryPath(binary)})
+
+	state, err := ConfirmPendingUpdate(statePath, time.Now())
+	if err != nil || state.Status != UpdateStatusConfirmed {
+		t.Fatalf("expected confirmed update, got %#v (%v)", state, err)
+	}
+	if _, err := os.Stat(PreviousBinaryPath(binary)); !os.IsNotExist(err) {
+		t.Fatal("expected previous binary to be removed after confirmation")
+	}
+	if _, _, err := RollbackPendingUpdate(statePath, "late", time.Now()); err != nil || readFile(t, binary) != "new" {
+		t.Fatal("expected confirmed update to survive a late rollback request")
+	}
+}
+
+func TestUpdateRollbackUnitsRunPreviousBinary(t *testing.T) {
+	unit := renderUpdateRollbackUnit("/usr/local/bin/easywi-agent.previous", "/etc/easywi/agent.conf")
+	if !strings.Contains(unit, "ExecStart=/usr/local/bin/easywi-agent.previous --rollback-update --config /etc/easywi/agent.conf") {
+		t.Fatalf("unexpected rollback unit:\n%s", unit)
+	}
+	dropIn := renderUpdateRollbackDropIn()
+	if !strings.Contains(dropIn, "OnFailure=easywi-agent-rollback.service") || !strings.Contains(dropIn, "RestartPreventExitStatus=75") {
+		t.Fatalf("unexpected rollback drop-in:\n%s", dropIn)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
@@ -1901,6 +1901,7 @@ Environment=LC_ALL=C.UTF-8
 ExecStart=/usr/local/bin/easywi-agent --config /etc/easywi/agent.conf
 Restart=always
 RestartSec=5
+RestartPreventExitStatus=78
 LimitNOFILE=1048576
 RuntimeDirectory=easywi-agent
 RuntimeDirectoryMode=0755
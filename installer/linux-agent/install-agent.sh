@@ -148,6 +148,7 @@ WorkingDirectory=/var/lib/easywi-agent
 ExecStart=${binaryPath} --config ${CONFIG_PATH}
 Restart=always
 RestartSec=5
+RestartPreventExitStatus=78
 Environment=LANG=C.UTF-8
 Environment=LC_ALL=C.UTF-8
 LimitNOFILE=1048576